@@ -0,0 +1,82 @@
+package imap
+
+import (
+	"fmt"
+	"net"
+)
+
+// NetworkACL decides which client addresses may connect to the server.
+// Connections are checked as soon as they are accepted, before the
+// greeting is sent.
+type NetworkACL struct {
+	// If not empty, only clients in these networks may connect
+	Allow []*net.IPNet
+
+	// Clients in these networks may not connect, even if they are allowed
+	Deny []*net.IPNet
+
+	// Check, if set, is consulted for clients which pass the lists above
+	// and may make a dynamic decision (eg consult a blocklist service). It
+	// should return false to reject the client.
+	Check func(ip net.IP) bool
+
+	// RejectWithBye sends rejected clients a BYE response before closing
+	// the connection, rather than closing it immediately
+	RejectWithBye bool
+}
+
+// ParseCIDRs parses a list of networks in CIDR notation (eg "10.0.0.0/8")
+// for use in a NetworkACL
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Permits returns true if a client at the given address may connect
+func (acl NetworkACL) Permits(addr net.Addr) bool {
+	if len(acl.Allow) == 0 && len(acl.Deny) == 0 && acl.Check == nil {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.IP
+
+	if len(acl.Allow) > 0 && !containsIP(acl.Allow, ip) {
+		return false
+	}
+	if containsIP(acl.Deny, ip) {
+		return false
+	}
+	if acl.Check != nil && !acl.Check(ip) {
+		return false
+	}
+	return true
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Turn away a client which the ACL does not permit to connect
+func (s *Server) rejectConn(netConn net.Conn, acl NetworkACL) {
+	fmt.Fprintf(s.Transcript, "Connection from %s rejected by ACL\n", netConn.RemoteAddr())
+	if acl.RejectWithBye {
+		fmt.Fprintf(netConn, "* BYE Connections from your address are not permitted\r\n")
+	}
+	netConn.Close()
+}
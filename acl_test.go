@@ -0,0 +1,61 @@
+package imap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jordwest/imap-server/internal/client"
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+func TestNetworkACLPermits(t *testing.T) {
+	allow, _ := ParseCIDRs("10.0.0.0/8", "2001:db8::/32")
+	deny, _ := ParseCIDRs("10.1.0.0/16")
+	acl := NetworkACL{
+		Allow: allow,
+		Deny:  deny,
+		Check: func(ip net.IP) bool { return !ip.Equal(net.ParseIP("10.2.0.1")) },
+	}
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"192.168.0.1", false},
+		{"10.1.2.3", false},
+		{"10.2.0.1", false},
+	}
+	for _, test := range tests {
+		addr := &net.TCPAddr{IP: net.ParseIP(test.ip), Port: 1234}
+		if acl.Permits(addr) != test.expected {
+			t.Errorf("Expected Permits(%s) to be %v", test.ip, test.expected)
+		}
+	}
+
+	if !(NetworkACL{}).Permits(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}) {
+		t.Errorf("Expected an empty ACL to permit all clients")
+	}
+	if _, err := ParseCIDRs("10.0.0.0"); err == nil {
+		t.Errorf("Expected error parsing a network without a prefix length")
+	}
+}
+
+func TestNetworkACLRejects(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	s.Addr = "127.0.0.1:10149"
+	s.ACL.Deny, _ = ParseCIDRs("127.0.0.0/8")
+	s.ACL.RejectWithBye = true
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	_, err := client.Dial(s.Addr)
+	statusErr, ok := err.(*client.StatusError)
+	if !ok || statusErr.Response.Status != "BYE" {
+		t.Errorf("Expected connection to be rejected with BYE, got %v", err)
+	}
+}
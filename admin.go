@@ -0,0 +1,229 @@
+package imap
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// ErrMaintenanceUnsupported indicates that the server's mailstore has no
+// maintenance to run, as it doesn't implement mailstore.MaintainedMailstore
+var ErrMaintenanceUnsupported = errors.New("Mailstore has no maintenance to run")
+
+// SessionSummary describes a client connection, as listed by the admin API
+type SessionSummary struct {
+	ID         string
+	RemoteAddr string
+	Username   string `json:",omitempty"` // Blank if the client has not authenticated
+	Command    string `json:",omitempty"` // The command being executed, eg "UID FETCH"
+	Mailbox    string `json:",omitempty"` // The mailbox the command is working on
+	Memory     uint64 // Estimated memory held by the connection, in bytes
+
+	// How long the command being executed has been running for
+	CommandDuration time.Duration `json:",omitempty"`
+}
+
+// CommandSummary describes a command being executed, as listed by the admin
+// API
+type CommandSummary struct {
+	SessionID string
+	CommandID string
+	Verb      string // eg "UID FETCH"
+	Username  string `json:",omitempty"` // Blank if the client has not authenticated
+	Mailbox   string `json:",omitempty"` // Blank if no mailbox is selected
+	Started   time.Time
+	Duration  time.Duration // How long the command has been running for
+}
+
+// ServerStats summarises the state of the server, as shown by the admin API
+type ServerStats struct {
+	Uptime                time.Duration
+	Sessions              int
+	AuthenticatedSessions int
+	CommandsInFlight      int
+	Memory                uint64 // Estimated memory held by all connections, in bytes
+}
+
+// Sessions lists the open client connections
+func (s *Server) Sessions() []SessionSummary {
+	conns := s.connections()
+	sessions := make([]SessionSummary, 0, len(conns))
+	for _, c := range conns {
+		usage := c.MemoryUsage()
+		session := SessionSummary{ID: c.ID, Username: usage.Username, Memory: usage.Total()}
+		if netConn, ok := c.Rwc.(net.Conn); ok {
+			session.RemoteAddr = netConn.RemoteAddr().String()
+		}
+		if info, ok := c.InFlightCommand(); ok {
+			session.Command = info.Verb
+			session.Mailbox = info.Mailbox
+			session.CommandDuration = info.Duration()
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Commands summarises the commands being executed on every connection,
+// longest running first
+func (s *Server) Commands() []CommandSummary {
+	inFlight := s.InFlightCommands()
+	commands := make([]CommandSummary, 0, len(inFlight))
+	for _, info := range inFlight {
+		commands = append(commands, CommandSummary{
+			SessionID: info.ConnID,
+			CommandID: info.CommandID,
+			Verb:      info.Verb,
+			Username:  info.Username,
+			Mailbox:   info.Mailbox,
+			Started:   info.Started,
+			Duration:  info.Duration(),
+		})
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Duration > commands[j].Duration
+	})
+	return commands
+}
+
+// Stats summarises the server's connections and the work they're doing
+func (s *Server) Stats() ServerStats {
+	stats := ServerStats{}
+	if !s.started.IsZero() {
+		stats.Uptime = time.Since(s.started)
+	}
+	for _, session := range s.Sessions() {
+		stats.Sessions++
+		if session.Username != "" {
+			stats.AuthenticatedSessions++
+		}
+		if session.Command != "" {
+			stats.CommandsInFlight++
+		}
+		stats.Memory += session.Memory
+	}
+	return stats
+}
+
+// BroadcastAlert sends every connected client an [ALERT] with the given
+// text, eg to warn users of planned downtime. Clients are alerted in
+// parallel, so that one which has stopped reading doesn't hold up the
+// others. Returns the number of clients alerted.
+func (s *Server) BroadcastAlert(text string) int {
+	conns := s.connections()
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *conn.Conn) {
+			defer wg.Done()
+			c.SendAlert(text)
+		}(c)
+	}
+	wg.Wait()
+	return len(conns)
+}
+
+// RunMaintenance runs the mailstore's housekeeping, if it has any
+func (s *Server) RunMaintenance(ctx context.Context) error {
+	maintained, ok := s.mailstore.(mailstore.MaintainedMailstore)
+	if !ok {
+		return ErrMaintenanceUnsupported
+	}
+	return maintained.Maintain(ctx)
+}
+
+// AdminHandler returns an HTTP handler for the admin API used by
+// cmd/imapadmin, which lets operators list and end sessions, alert users,
+// run maintenance, see statistics and change some of the configuration.
+// Requests must give the token as a bearer token in their Authorization
+// header. The API should only be served to operators, eg on a loopback
+// address.
+//
+//	GET    /sessions       List sessions, as SessionSummary values
+//	DELETE /sessions/<id>  Close a session
+//	GET    /commands       List the commands being executed, as CommandSummary values
+//	POST   /alert          Send {"Text": "..."} to every client as an [ALERT]
+//	POST   /maintenance    Run the mailstore's maintenance
+//	GET    /stats          Show ServerStats
+//	GET    /config         Show the options which can be changed, as AdminConfig
+//	PATCH  /config         Change the options given as AdminConfig for new connections
+func (s *Server) AdminHandler(token string) http.Handler {
+	return &adminHandler{server: s, token: token}
+}
+
+type adminHandler struct {
+	server *Server
+	token  string
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/sessions" && r.Method == http.MethodGet:
+		writeJSON(w, h.server.Sessions())
+	case strings.HasPrefix(r.URL.Path, "/sessions/") && r.Method == http.MethodDelete:
+		if err := h.server.CloseSession(strings.TrimPrefix(r.URL.Path, "/sessions/")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/commands" && r.Method == http.MethodGet:
+		writeJSON(w, h.server.Commands())
+	case r.URL.Path == "/alert" && r.Method == http.MethodPost:
+		var alert struct{ Text string }
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil || alert.Text == "" ||
+			strings.ContainsAny(alert.Text, "\r\n") {
+			http.Error(w, "Expected {\"Text\": \"...\"} on a single line", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, struct{ Alerted int }{h.server.BroadcastAlert(alert.Text)})
+	case r.URL.Path == "/maintenance" && r.Method == http.MethodPost:
+		err := h.server.RunMaintenance(r.Context())
+		if err == ErrMaintenanceUnsupported {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/stats" && r.Method == http.MethodGet:
+		writeJSON(w, h.server.Stats())
+	case r.URL.Path == "/config" && r.Method == http.MethodGet:
+		writeJSON(w, adminConfig(h.server.CurrentConfig()))
+	case r.URL.Path == "/config" && r.Method == http.MethodPatch:
+		var update AdminConfig
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Expected options as JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.server.UpdateConfig(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, adminConfig(h.server.CurrentConfig()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
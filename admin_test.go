@@ -0,0 +1,245 @@
+package imap
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordwest/imap-server/internal/client"
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// A mailstore which counts the times its maintenance has been run
+type maintainedMailstore struct {
+	mailstore.DummyMailstore
+	runs *int
+}
+
+func (m maintainedMailstore) Maintain(ctx context.Context) error {
+	*m.runs++
+	return nil
+}
+
+func adminRequest(t *testing.T, api *httptest.Server, method string, path string, body string, token string) *http.Response {
+	req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+	return resp
+}
+
+func TestAdminAPI(t *testing.T) {
+	runs := 0
+	s := NewServer(maintainedMailstore{mailstore.NewDummyMailstore(), &runs})
+	s.Addr = "127.0.0.1:10153"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+	api := httptest.NewServer(s.AdminHandler("secret"))
+	defer api.Close()
+
+	c, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+
+	if resp := adminRequest(t, api, "GET", "/sessions", "", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a request with the wrong token to be refused, got %s", resp.Status)
+	}
+
+	var sessions []SessionSummary
+	resp := adminRequest(t, api, "GET", "/sessions", "", "secret")
+	if err = json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatalf("Error decoding sessions: %s", err)
+	}
+	if len(sessions) != 1 || sessions[0].Username != "username" || sessions[0].RemoteAddr == "" {
+		t.Fatalf("Expected the logged in session, got %+v", sessions)
+	}
+
+	var stats ServerStats
+	resp = adminRequest(t, api, "GET", "/stats", "", "secret")
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Error decoding stats: %s", err)
+	}
+	if stats.Sessions != 1 || stats.AuthenticatedSessions != 1 {
+		t.Errorf("Expected 1 authenticated session, got %+v", stats)
+	}
+
+	resp = adminRequest(t, api, "POST", "/alert", `{"Text": "Down for maintenance at 22:00"}`, "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Error sending alert: %s", resp.Status)
+	}
+	untagged, err := c.Command("NOOP")
+	if err != nil {
+		t.Fatalf("Error reading NOOP response: %s", err)
+	}
+	if len(untagged) != 1 || untagged[0].Code != "ALERT" || untagged[0].Text != "Down for maintenance at 22:00" {
+		t.Errorf("Expected the alert to be sent to the client, got %+v", untagged)
+	}
+
+	if resp = adminRequest(t, api, "POST", "/maintenance", "", "secret"); resp.StatusCode != http.StatusNoContent || runs != 1 {
+		t.Errorf("Expected maintenance to be run once, got %s and %d runs", resp.Status, runs)
+	}
+
+	if resp = adminRequest(t, api, "DELETE", "/sessions/"+sessions[0].ID, "", "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Error closing session: %s", resp.Status)
+	}
+	if _, err = c.Command("NOOP"); err == nil {
+		t.Errorf("Expected the session to be closed")
+	}
+	if resp = adminRequest(t, api, "DELETE", "/sessions/nonexistent", "", "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected closing a nonexistent session to fail, got %s", resp.Status)
+	}
+}
+
+// A mailstore whose users' lookups of the "Slow" mailbox wait until they're
+// released
+type slowMailstore struct {
+	mailstore.DummyMailstore
+	release chan struct{}
+}
+
+func (m slowMailstore) Authenticate(username string, password string) (mailstore.User, error) {
+	user, err := m.DummyMailstore.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return slowUser{user.(mailstore.DummyUser), m.release}, nil
+}
+
+type slowUser struct {
+	mailstore.DummyUser
+	release chan struct{}
+}
+
+func (u slowUser) MailboxByName(name string) (mailstore.Mailbox, error) {
+	if name == "Slow" {
+		<-u.release
+	}
+	return u.DummyUser.MailboxByName(name)
+}
+
+func TestAdminInFlightCommands(t *testing.T) {
+	release := make(chan struct{})
+	s := NewServer(slowMailstore{mailstore.NewDummyMailstore(), release})
+	s.Addr = "127.0.0.1:10155"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+	api := httptest.NewServer(s.AdminHandler("secret"))
+	defer api.Close()
+
+	c, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Command("STATUS Slow (MESSAGES)")
+		close(done)
+	}()
+
+	var commands []CommandSummary
+	for deadline := time.Now().Add(5 * time.Second); len(commands) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected STATUS to be listed as in flight")
+		}
+		time.Sleep(10 * time.Millisecond)
+		resp := adminRequest(t, api, "GET", "/commands", "", "secret")
+		if err = json.NewDecoder(resp.Body).Decode(&commands); err != nil {
+			t.Fatalf("Error decoding commands: %s", err)
+		}
+	}
+	if len(commands) != 1 || commands[0].Verb != "STATUS" || commands[0].Username != "username" ||
+		commands[0].CommandID != commands[0].SessionID+".2" || commands[0].Duration <= 0 {
+		t.Errorf("Expected the running STATUS command, got %+v", commands)
+	}
+
+	var sessions []SessionSummary
+	resp := adminRequest(t, api, "GET", "/sessions", "", "secret")
+	if err = json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatalf("Error decoding sessions: %s", err)
+	}
+	if len(sessions) != 1 || sessions[0].Command != "STATUS" || sessions[0].CommandDuration <= 0 {
+		t.Errorf("Expected the session to show the running STATUS command, got %+v", sessions)
+	}
+
+	close(release)
+	<-done
+	resp = adminRequest(t, api, "GET", "/commands", "", "secret")
+	if err = json.NewDecoder(resp.Body).Decode(&commands); err != nil {
+		t.Fatalf("Error decoding commands: %s", err)
+	}
+	if len(commands) != 0 {
+		t.Errorf("Expected no commands in flight once STATUS finished, got %+v", commands)
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	s.Addr = "127.0.0.1:10156"
+	s.Hostname = "imap.example.com"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+	api := httptest.NewServer(s.AdminHandler("secret"))
+	defer api.Close()
+
+	var cfg AdminConfig
+	resp := adminRequest(t, api, "GET", "/config", "", "secret")
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		t.Fatalf("Error decoding config: %s", err)
+	}
+	if cfg.Hostname == nil || *cfg.Hostname != "imap.example.com" || cfg.MaxLineLength == nil || *cfg.MaxLineLength != DefaultMaxLineLength {
+		t.Errorf("Expected the server's configuration, got %+v", cfg)
+	}
+
+	resp = adminRequest(t, api, "PATCH", "/config", `{"MaxLineLength": 10}`, "secret")
+	if resp.StatusCode != http.StatusBadRequest || s.CurrentConfig().MaxLineLength != DefaultMaxLineLength {
+		t.Errorf("Expected an invalid change to be refused, got %s", resp.Status)
+	}
+
+	resp = adminRequest(t, api, "PATCH", "/config", `{"DisabledCommands": ["DELETE"], "AppendLimit": 1024}`, "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Error changing config: %s", resp.Status)
+	}
+	current := s.CurrentConfig()
+	if current.AppendLimit != 1024 || len(current.DisabledCommands) != 1 || current.Hostname != "imap.example.com" {
+		t.Errorf("Expected only the options given to change, got %+v", current)
+	}
+
+	c, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+	if _, err = c.Command("DELETE Trash"); err == nil || !strings.Contains(err.Error(), "DELETE is disabled") {
+		t.Errorf("Expected new connections to have DELETE disabled, got %v", err)
+	}
+}
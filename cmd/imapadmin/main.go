@@ -0,0 +1,196 @@
+// Command imapadmin manages a running IMAP server through its admin API
+// (see Server.AdminHandler).
+//
+//	imapadmin [-addr URL] [-token TOKEN] sessions
+//	imapadmin [-addr URL] [-token TOKEN] commands
+//	imapadmin [-addr URL] [-token TOKEN] kick SESSION-ID
+//	imapadmin [-addr URL] [-token TOKEN] alert TEXT...
+//	imapadmin [-addr URL] [-token TOKEN] maintenance
+//	imapadmin [-addr URL] [-token TOKEN] stats
+//	imapadmin [-addr URL] [-token TOKEN] config [JSON]
+//
+// config shows the options which can be changed while the server is
+// running, or changes those given as JSON, eg '{"DisabledCommands": ["DELETE"]}'.
+//
+// The token may also be given in the IMAPADMIN_TOKEN environment variable.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	imap "github.com/jordwest/imap-server"
+)
+
+func main() {
+	addr := flag.String("addr", "http://127.0.0.1:8143", "URL of the server's admin API")
+	token := flag.String("token", os.Getenv("IMAPADMIN_TOKEN"), "Token for the admin API")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	api := adminClient{url: strings.TrimSuffix(*addr, "/"), token: *token}
+	var err error
+	switch args := flag.Args(); args[0] {
+	case "sessions":
+		err = api.sessions(os.Stdout)
+	case "commands":
+		err = api.commands(os.Stdout)
+	case "kick":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		err = api.do(http.MethodDelete, "/sessions/"+args[1], nil, nil)
+	case "alert":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		var result struct{ Alerted int }
+		alert := struct{ Text string }{strings.Join(args[1:], " ")}
+		if err = api.do(http.MethodPost, "/alert", alert, &result); err == nil {
+			fmt.Printf("Alerted %d sessions\n", result.Alerted)
+		}
+	case "maintenance":
+		err = api.do(http.MethodPost, "/maintenance", nil, nil)
+	case "stats":
+		err = api.stats(os.Stdout)
+	case "config":
+		err = api.config(os.Stdout, strings.Join(args[1:], " "))
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imapadmin: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: imapadmin [flags] sessions|commands|kick ID|alert TEXT|maintenance|stats|config [JSON]\n")
+	flag.PrintDefaults()
+}
+
+type adminClient struct {
+	url   string
+	token string
+}
+
+// Send a request to the admin API, with body encoded as JSON if it isn't
+// nil, and decode the response into result if it isn't nil
+func (a adminClient) do(method string, path string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, a.url+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		text, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (a adminClient) sessions(out io.Writer) error {
+	var sessions []imap.SessionSummary
+	if err := a.do(http.MethodGet, "/sessions", nil, &sessions); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tUSER\tCOMMAND\tRUNNING\tMAILBOX\tMEMORY")
+	for _, s := range sessions {
+		running := ""
+		if s.Command != "" {
+			running = s.CommandDuration.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", s.ID, s.RemoteAddr, s.Username, s.Command, running, s.Mailbox, s.Memory)
+	}
+	return w.Flush()
+}
+
+func (a adminClient) commands(out io.Writer) error {
+	var commands []imap.CommandSummary
+	if err := a.do(http.MethodGet, "/commands", nil, &commands); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tCOMMAND ID\tUSER\tCOMMAND\tMAILBOX\tRUNNING")
+	for _, c := range commands {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.SessionID, c.CommandID, c.Username, c.Verb, c.Mailbox, c.Duration)
+	}
+	return w.Flush()
+}
+
+func (a adminClient) stats(out io.Writer) error {
+	var stats imap.ServerStats
+	if err := a.do(http.MethodGet, "/stats", nil, &stats); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Uptime:                 %s\n", stats.Uptime)
+	fmt.Fprintf(out, "Sessions:               %d\n", stats.Sessions)
+	fmt.Fprintf(out, "Authenticated sessions: %d\n", stats.AuthenticatedSessions)
+	fmt.Fprintf(out, "Commands in flight:     %d\n", stats.CommandsInFlight)
+	fmt.Fprintf(out, "Memory:                 %d bytes\n", stats.Memory)
+	return nil
+}
+
+// Show the server's configuration, after changing the options given as JSON
+// if there are any
+func (a adminClient) config(out io.Writer, changes string) error {
+	var cfg imap.AdminConfig
+	var err error
+	if changes == "" {
+		err = a.do(http.MethodGet, "/config", nil, &cfg)
+	} else {
+		var update imap.AdminConfig
+		if err = json.Unmarshal([]byte(changes), &update); err != nil {
+			return err
+		}
+		err = a.do(http.MethodPatch, "/config", update, &cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
@@ -0,0 +1,370 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
+	"github.com/jordwest/imap-server/util"
+)
+
+// Config holds the options for client connections, which may be changed
+// while the server is running
+type Config struct {
+	// AppendLimit is the maximum size in bytes of a message which clients
+	// may APPEND. Zero means no limit.
+	AppendLimit uint32
+
+	// MaxLineLength is the maximum length in bytes of a line of command
+	// text, which must be at least 1024. Longer commands are read to the
+	// end of the line and thrown away, and answered with BAD [TOOBIG].
+	// NewServer sets it to DefaultMaxLineLength. Zero means no limit.
+	MaxLineLength uint32
+
+	// MaxLiteralSize is the maximum size in bytes of any literal, whether
+	// command text or a message being APPENDed. Larger literals are
+	// refused with NO [TOOBIG]. NewServer sets it to DefaultMaxLiteralSize.
+	// Zero means no limit.
+	MaxLiteralSize uint32
+
+	// SessionMemoryLimit is the approximate memory in bytes each connection
+	// may hold in buffered responses, cached search results and literals.
+	// Connections near the limit stop coalescing responses and forget old
+	// search results, and refuse literals which would exceed it. Zero means
+	// no limit.
+	SessionMemoryLimit uint64
+
+	// QuotaWarningThresholds are the percentages of a user's storage quota
+	// (eg 80, 95) at which they are sent an [ALERT]. Users are only warned
+	// if their mailstore User implements mailstore.QuotaUser.
+	QuotaWarningThresholds []uint8
+
+	// LoginReferral, if set, is consulted whenever a user attempts to log
+	// in. It should return an IMAP URL (eg "imap://user@other-host/") if
+	// the user's mailstore is hosted on another server, in which case the
+	// client is referred there, or an empty string to log in as normal.
+	LoginReferral func(username string) (url string)
+
+	// Hostname, if set, is announced to clients in the greeting and ID
+	// responses
+	Hostname string
+
+	// Stealth hides the server software's name and version from the
+	// greeting and ID responses
+	Stealth bool
+
+	// Catalog, if set, provides translations of response text which
+	// clients may choose between with the LANGUAGE command
+	Catalog conn.Catalog
+
+	// ProgressInterval is how often long-running commands such as SEARCH
+	// send an untagged OK [INPROGRESS] so that clients don't time out.
+	// Zero disables progress responses.
+	ProgressInterval time.Duration
+
+	// CommandTimeout limits how long the mailstore may spend on each
+	// command, for mailstores implementing the Context interfaces, whose
+	// contexts' deadlines are set this far ahead. Zero sets no deadline,
+	// though contexts are still cancelled when the client disconnects.
+	CommandTimeout time.Duration
+
+	// ExtraCapabilities are advertised to clients along with the server's
+	// own capabilities, eg "X-ACME-PUSH" to hint at features of the
+	// operator's own client apps. Each must be a valid IMAP atom.
+	ExtraCapabilities []string
+
+	// FetchItems are proprietary FETCH data items (eg X-GM-MSGID) offered
+	// to clients alongside the standard ones
+	FetchItems []conn.FetchItem
+
+	// Transactions allows clients to group changes to a mailbox into a
+	// batch with the XBEGIN, XCOMMIT and XROLLBACK commands, for mailboxes
+	// which implement mailstore.TransactionMailbox
+	Transactions bool
+
+	// RecordSession, if set, is called with a record of each session when
+	// it ends, with credentials and message bodies redacted. Records can be
+	// saved as JSON with SessionRecord.WriteJSON.
+	RecordSession func(conn.SessionRecord)
+
+	// Logger, if set, is called with each line of a connection's transcript
+	// and the correlation ID of the connection or command it belongs to, eg
+	// to send them to a structured logger
+	Logger func(correlationID string, line string)
+
+	// CommandMetrics, if set, is called as each command finishes with its
+	// context and a summary of how it went, eg to export latency and error
+	// rates. The context carries the command's correlation ID, which
+	// mailstore.CorrelationID reads.
+	CommandMetrics func(ctx context.Context, metric conn.CommandMetric)
+
+	// Audit, if set, is called with the command's context and a record of
+	// each login (successful or not), logout, mailbox deletion and rename
+	Audit func(ctx context.Context, event conn.AuditEvent)
+
+	// DisabledCommands lists commands (eg "DELETE", "RENAME") which clients
+	// are refused with NO [CANNOT], and capabilities (eg "AUTH=XOAUTH2")
+	// which are not offered. Capabilities belonging to a disabled command
+	// are withdrawn along with it. SEARCH followed by a search key (eg
+	// "SEARCH TEXT") refuses searches using that key, through SEARCH, SORT
+	// or ESEARCH. Users' feature toggles can't enable anything listed here.
+	DisabledCommands []string
+
+	// UTF8Accept offers the UTF8=ACCEPT extension (RFC 6855), which
+	// clients turn on with the ENABLE command. It lets them send UTF-8
+	// outside literals, and has mailbox names sent to them in UTF-8 rather
+	// than modified UTF-7.
+	UTF8Accept bool
+
+	// FuzzyRanker scores how relevant messages are to the text keys of
+	// FUZZY searches (RFC 6203), for mailboxes which don't implement
+	// mailstore.RankedSearchMailbox. If nil, search.DefaultRanker is used.
+	FuzzyRanker search.Ranker
+
+	// SubmitServers lists the usernames of message submission servers,
+	// which may fetch URLAUTH URLs issued to users for submission
+	// (submit+<user>). Other users may only fetch submission URLs issued to
+	// themselves.
+	SubmitServers []string
+
+	// QResync offers the CONDSTORE and QRESYNC extensions (RFC 7162), which
+	// clients turn on with the ENABLE command. Clients are then sent the
+	// mod-sequences of messages in mailboxes which implement
+	// mailstore.ChangeLogMailbox, and VANISHED responses rather than
+	// EXPUNGE.
+	QResync bool
+
+	// ACL restricts which client addresses may connect
+	ACL NetworkACL
+}
+
+// The limits a new Server starts with, which keep a client from making it
+// buffer an unbounded command. RFC 7162 section 4 asks servers to accept
+// lines of at least 8192 octets.
+const (
+	DefaultMaxLineLength  uint32 = 64 * 1024
+	DefaultMaxLiteralSize uint32 = 64 * 1024 * 1024
+)
+
+// The shortest MaxLineLength allowed. Clients commonly send commands of
+// several hundred bytes, eg FETCH with many header fields.
+const minLineLength = 1024
+
+// Validate checks that the configuration can be applied
+func (cfg Config) Validate() error {
+	if strings.ContainsAny(cfg.Hostname, " \r\n") {
+		return fmt.Errorf("Invalid hostname %q", cfg.Hostname)
+	}
+	for _, threshold := range cfg.QuotaWarningThresholds {
+		if threshold == 0 || threshold > 100 {
+			return fmt.Errorf("Invalid quota warning threshold %d%%", threshold)
+		}
+	}
+	if cfg.MaxLineLength != 0 && cfg.MaxLineLength < minLineLength {
+		return fmt.Errorf("Invalid maximum line length %d, must be at least %d", cfg.MaxLineLength, minLineLength)
+	}
+	if cfg.ProgressInterval < 0 {
+		return fmt.Errorf("Invalid progress interval %s", cfg.ProgressInterval)
+	}
+	if cfg.CommandTimeout < 0 {
+		return fmt.Errorf("Invalid command timeout %s", cfg.CommandTimeout)
+	}
+	for _, capability := range cfg.ExtraCapabilities {
+		if !util.IsAtom(capability) {
+			return fmt.Errorf("Invalid capability %q", capability)
+		}
+	}
+	for _, item := range cfg.FetchItems {
+		if item.Name == "" || strings.ContainsAny(item.Name, " ()[]\r\n") {
+			return fmt.Errorf("Invalid FETCH item name %q", item.Name)
+		}
+		if item.Format == nil && item.FormatAttribute == nil {
+			return fmt.Errorf("FETCH item %s has no Format or FormatAttribute function", item.Name)
+		}
+		if item.Capability != "" && !util.IsAtom(item.Capability) {
+			return fmt.Errorf("Invalid capability %q for FETCH item %s", item.Capability, item.Name)
+		}
+	}
+	for _, command := range cfg.DisabledCommands {
+		words := strings.Split(command, " ")
+		if len(words) > 2 || words[0] == "" || words[len(words)-1] == "" || strings.ContainsAny(command, "\r\n") {
+			return fmt.Errorf("Invalid disabled command %q", command)
+		}
+	}
+	if !conn.CanAuthenticate(nil, cfg.DisabledCommands) {
+		return errors.New("LOGIN and every authentication mechanism are disabled, so no one can log in")
+	}
+	return nil
+}
+
+// ValidateFor checks the configuration as Validate does, and also that it
+// suits the mailstore it's used with, eg that some way of logging in which
+// the mailstore supports is left enabled and that its hierarchy delimiter
+// can be sent to clients
+func (cfg Config) ValidateFor(store mailstore.Mailstore) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !conn.CanAuthenticate(store, cfg.DisabledCommands) {
+		return errors.New("LOGIN and every authentication mechanism the mailstore supports are disabled, so no one can log in")
+	}
+	// The delimiter is a single 7-bit character (RFC 3501 section 6.3.8),
+	// and mustn't be a wildcard or it couldn't be told apart in LIST
+	delimiter := mailstore.Delimiter(store)
+	if len(delimiter) > 1 || delimiter != "" && (delimiter[0] >= 0x80 || strings.ContainsAny(delimiter, "\r\n\x00%*")) {
+		return fmt.Errorf("Invalid hierarchy delimiter %q", delimiter)
+	}
+	return nil
+}
+
+// Copy the configuration so that later changes to the caller's slices
+// don't affect it
+func (cfg Config) clone() Config {
+	cfg.QuotaWarningThresholds = append([]uint8(nil), cfg.QuotaWarningThresholds...)
+	cfg.ExtraCapabilities = append([]string(nil), cfg.ExtraCapabilities...)
+	cfg.FetchItems = append([]conn.FetchItem(nil), cfg.FetchItems...)
+	cfg.DisabledCommands = append([]string(nil), cfg.DisabledCommands...)
+	cfg.ACL.Allow = append([]*net.IPNet(nil), cfg.ACL.Allow...)
+	cfg.ACL.Deny = append([]*net.IPNet(nil), cfg.ACL.Deny...)
+	return cfg
+}
+
+// Apply the configuration to a new client connection
+func (cfg Config) apply(c *conn.Conn) {
+	c.AppendLimit = cfg.AppendLimit
+	c.MemoryLimit = cfg.SessionMemoryLimit
+	c.MaxLineLength = cfg.MaxLineLength
+	c.MaxLiteralSize = cfg.MaxLiteralSize
+	c.QuotaWarningThresholds = cfg.QuotaWarningThresholds
+	c.LoginReferral = cfg.LoginReferral
+	c.DisabledCommands = cfg.DisabledCommands
+	c.Hostname = cfg.Hostname
+	c.Stealth = cfg.Stealth
+	c.Catalog = cfg.Catalog
+	c.ExtraCapabilities = cfg.ExtraCapabilities
+	c.FetchItems = cfg.FetchItems
+	c.ProgressInterval = cfg.ProgressInterval
+	c.CommandTimeout = cfg.CommandTimeout
+	c.Transactions = cfg.Transactions
+	c.RecordSession = cfg.RecordSession
+	c.Logger = cfg.Logger
+	c.CommandMetrics = cfg.CommandMetrics
+	c.Audit = cfg.Audit
+	c.UTF8Accept = cfg.UTF8Accept
+	c.QResync = cfg.QResync
+	c.SubmitServers = cfg.SubmitServers
+	c.FuzzyRanker = cfg.FuzzyRanker
+}
+
+// ErrServerNotListening is returned when reconfiguring a server which has
+// not started listening, whose Config fields can be set directly instead
+var ErrServerNotListening = errors.New("Server not listening")
+
+// CurrentConfig returns the configuration applied to new connections
+func (s *Server) CurrentConfig() Config {
+	if cfg, ok := s.config.Load().(Config); ok {
+		return cfg
+	}
+	return s.Config
+}
+
+// Reconfigure validates a new configuration and atomically replaces the one
+// applied to new connections. Existing connections keep the configuration
+// they were opened with.
+func (s *Server) Reconfigure(cfg Config) error {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	return s.reconfigure(cfg)
+}
+
+func (s *Server) reconfigure(cfg Config) error {
+	if s.config.Load() == nil {
+		return ErrServerNotListening
+	}
+	if err := cfg.ValidateFor(s.mailstore); err != nil {
+		return err
+	}
+	s.config.Store(cfg.clone())
+	fmt.Fprintf(s.Transcript, "Server reconfigured\n")
+	return nil
+}
+
+// AdminConfig holds the options which can be seen and changed through the
+// admin API. The others, such as hooks, can only be set by the program
+// running the server. Durations are given in nanoseconds.
+type AdminConfig struct {
+	AppendLimit            *uint32        `json:",omitempty"`
+	MaxLineLength          *uint32        `json:",omitempty"`
+	MaxLiteralSize         *uint32        `json:",omitempty"`
+	SessionMemoryLimit     *uint64        `json:",omitempty"`
+	QuotaWarningThresholds []uint8        `json:",omitempty"`
+	ProgressInterval       *time.Duration `json:",omitempty"`
+	CommandTimeout         *time.Duration `json:",omitempty"`
+	DisabledCommands       []string       `json:",omitempty"`
+	Hostname               *string        `json:",omitempty"`
+	Stealth                *bool          `json:",omitempty"`
+}
+
+// The options of a configuration which the admin API shows
+func adminConfig(cfg Config) AdminConfig {
+	return AdminConfig{
+		AppendLimit:            &cfg.AppendLimit,
+		MaxLineLength:          &cfg.MaxLineLength,
+		MaxLiteralSize:         &cfg.MaxLiteralSize,
+		SessionMemoryLimit:     &cfg.SessionMemoryLimit,
+		QuotaWarningThresholds: cfg.QuotaWarningThresholds,
+		ProgressInterval:       &cfg.ProgressInterval,
+		CommandTimeout:         &cfg.CommandTimeout,
+		DisabledCommands:       cfg.DisabledCommands,
+		Hostname:               &cfg.Hostname,
+		Stealth:                &cfg.Stealth,
+	}
+}
+
+// UpdateConfig changes the options given in update, leaving those which are
+// nil as they are, and reconfigures the server as Reconfigure does.
+// QuotaWarningThresholds and DisabledCommands are replaced if they're not
+// nil; an empty list clears them.
+func (s *Server) UpdateConfig(update AdminConfig) error {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	cfg := s.CurrentConfig()
+	if update.AppendLimit != nil {
+		cfg.AppendLimit = *update.AppendLimit
+	}
+	if update.MaxLineLength != nil {
+		cfg.MaxLineLength = *update.MaxLineLength
+	}
+	if update.MaxLiteralSize != nil {
+		cfg.MaxLiteralSize = *update.MaxLiteralSize
+	}
+	if update.SessionMemoryLimit != nil {
+		cfg.SessionMemoryLimit = *update.SessionMemoryLimit
+	}
+	if update.QuotaWarningThresholds != nil {
+		cfg.QuotaWarningThresholds = update.QuotaWarningThresholds
+	}
+	if update.ProgressInterval != nil {
+		cfg.ProgressInterval = *update.ProgressInterval
+	}
+	if update.CommandTimeout != nil {
+		cfg.CommandTimeout = *update.CommandTimeout
+	}
+	if update.DisabledCommands != nil {
+		cfg.DisabledCommands = update.DisabledCommands
+	}
+	if update.Hostname != nil {
+		cfg.Hostname = *update.Hostname
+	}
+	if update.Stealth != nil {
+		cfg.Stealth = *update.Stealth
+	}
+	return s.reconfigure(cfg)
+}
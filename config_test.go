@@ -0,0 +1,175 @@
+package imap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/internal/client"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+func TestReconfigure(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	s.Addr = "127.0.0.1:10148"
+	s.Hostname = "old.example.com"
+	if err := s.Reconfigure(s.Config); err != ErrServerNotListening {
+		t.Errorf("Expected ErrServerNotListening, got %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	cfg := s.CurrentConfig()
+	cfg.Hostname = "bad host"
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected invalid hostname to be rejected")
+	}
+	cfg.Hostname = "new.example.com"
+	cfg.QuotaWarningThresholds = []uint8{101}
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected invalid quota threshold to be rejected")
+	}
+	cfg.QuotaWarningThresholds = nil
+	cfg.ExtraCapabilities = []string{"X-BAD HINT"}
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected capability which isn't an atom to be rejected")
+	}
+	cfg.ExtraCapabilities = nil
+	cfg.FetchItems = []conn.FetchItem{{Name: "X-GM-MSGID"}}
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected FETCH item without a Format function to be rejected")
+	}
+	cfg.FetchItems = []conn.FetchItem{{
+		Name: "X-GM-MSGID",
+		FormatAttribute: func(attr types.FetchAttribute, m mailstore.Message) (string, error) {
+			return "1", nil
+		},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected FETCH item with only a FormatAttribute function to be accepted: %s", err)
+	}
+	cfg.FetchItems[0].Capability = "X-GM-EXT-1 IMAP4rev1"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected FETCH item capability which isn't an atom to be rejected")
+	}
+	cfg.FetchItems = nil
+	cfg.MaxLineLength = 80
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected maximum line length too short for real commands to be rejected")
+	}
+	cfg.MaxLineLength = 0
+	cfg.ProgressInterval = -time.Second
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected negative progress interval to be rejected")
+	}
+	cfg.ProgressInterval = 0
+	cfg.CommandTimeout = -time.Second
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected negative command timeout to be rejected")
+	}
+	cfg.CommandTimeout = 0
+	cfg.DisabledCommands = []string{"SEARCH TEXT"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a disabled search key to be accepted: %s", err)
+	}
+	for _, command := range []string{"SEARCH  TEXT", "SEARCH TEXT BODY", "SEARCH "} {
+		cfg.DisabledCommands = []string{command}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected disabled command %q to be rejected", command)
+		}
+	}
+	cfg.DisabledCommands = []string{"LOGIN", "AUTH=PLAIN", "AUTH=XOAUTH2", "AUTH=OAUTHBEARER"}
+	if err := s.Reconfigure(cfg); err == nil {
+		t.Errorf("Expected configuration preventing logins to be rejected")
+	}
+	if s.CurrentConfig().Hostname != "old.example.com" {
+		t.Errorf("Invalid configuration should not have been applied")
+	}
+
+	cfg.QuotaWarningThresholds = []uint8{90}
+	cfg.DisabledCommands = []string{"STATUS"}
+	if err := s.Reconfigure(cfg); err != nil {
+		t.Fatalf("Error reconfiguring: %s", err)
+	}
+	cfg.DisabledCommands[0] = "NOOP"
+
+	c, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+	if c.Greeting.Text != "new.example.com IMAP4rev1 Service Ready" {
+		t.Errorf("Expected greeting with new hostname, got %q", c.Greeting.Text)
+	}
+	if _, err = c.Command("NOOP"); err != nil {
+		t.Errorf("Changes to the caller's config should not be applied: %s", err)
+	}
+	if _, err = c.Command("STATUS INBOX (MESSAGES)"); err == nil {
+		t.Errorf("Expected STATUS to be disabled")
+	}
+}
+
+// A mailstore which can't verify bearer tokens
+type passwordMailstore struct {
+	mailstore.Mailstore
+}
+
+func TestValidateForMailstore(t *testing.T) {
+	cfg := Config{DisabledCommands: []string{"LOGIN", "AUTH=PLAIN"}}
+	if err := cfg.ValidateFor(mailstore.NewDummyMailstore()); err != nil {
+		t.Errorf("Expected token authentication to be enough for a mailstore which verifies tokens: %s", err)
+	}
+	if err := cfg.ValidateFor(passwordMailstore{mailstore.NewDummyMailstore()}); err == nil {
+		t.Errorf("Expected a mailstore which can't verify tokens to need LOGIN or AUTH=PLAIN")
+	}
+
+	s := NewServer(passwordMailstore{mailstore.NewDummyMailstore()})
+	s.Addr = "127.0.0.1:10154"
+	s.Config = cfg
+	if err := s.Listen(); err == nil {
+		s.Close()
+		t.Errorf("Expected a server nobody can log in to not to start")
+	}
+}
+
+func TestValidateForDelimiter(t *testing.T) {
+	for delimiter, valid := range map[string]bool{
+		"/":      true,
+		".":      true,
+		"":       true,
+		"::":     false,
+		"%":      false,
+		"\n":     false,
+		"\u00a7": false,
+	} {
+		store := mailstore.NewDummyMailstore()
+		store.Delimiter = delimiter
+		if err := (Config{}).ValidateFor(store); (err == nil) != valid {
+			t.Errorf("Expected delimiter %q to be valid: %t, got %v", delimiter, valid, err)
+		}
+	}
+}
+
+func TestListenValidatesConfig(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	s.Addr = "127.0.0.1:10151"
+	s.DisabledCommands = []string{"LOGIN", "AUTHENTICATE"}
+	if err := s.Listen(); err == nil {
+		s.Close()
+		t.Errorf("Expected a server nobody can log in to not to start")
+	}
+}
+
+func TestNewServerLimits(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	if s.MaxLineLength != DefaultMaxLineLength || s.MaxLiteralSize != DefaultMaxLiteralSize {
+		t.Errorf("Expected a new server to limit lines and literals, got %d and %d", s.MaxLineLength, s.MaxLiteralSize)
+	}
+	if err := s.Config.Validate(); err != nil {
+		t.Errorf("Expected the default limits to be valid: %s", err)
+	}
+}
@@ -0,0 +1,245 @@
+package conn
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	"github.com/jordwest/imap-server/util"
+)
+
+const (
+	storeAnnotationArgUID   int = 0
+	storeAnnotationArgRange int = 1
+	storeAnnotationArgList  int = 2
+)
+
+// The attributes of an annotation entry, in the order they are returned
+var annotationAttributes = []string{"value.priv", "value.shared", "size.priv", "size.shared"}
+
+// Tokens of an annotation list: quoted strings, parenthesised lists and atoms
+var annotationTokenRE = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|\([^()]*(?:\([^()]*\)[^()]*)*\)|[^ ()]+`)
+
+// Split an annotation list into its top level tokens. Quoted strings keep
+// their quotes, so they can be told apart from NIL.
+func annotationTokens(list string) []string {
+	return annotationTokenRE.FindAllString(list, -1)
+}
+
+// Returns the items of a parenthesised list, or the token itself if it is
+// not a list
+func annotationListOrSingle(token string) []string {
+	if strings.HasPrefix(token, "(") && strings.HasSuffix(token, ")") {
+		return annotationTokens(token[1 : len(token)-1])
+	}
+	return []string{token}
+}
+
+func unquoteAnnotation(s string) string {
+	if len(s) < 2 || !strings.HasPrefix(s, "\"") {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	s = strings.Replace(s, "\\\"", "\"", -1)
+	return strings.Replace(s, "\\\\", "\\", -1)
+}
+
+// Match an entry or attribute name against a pattern, where "*" matches
+// anything and "%" matches anything except the hierarchy separator
+func annotationMatch(pattern string, name string, separator string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, "\\*", ".*", -1)
+	expr = strings.Replace(expr, "%", "[^"+regexp.QuoteMeta(separator)+"]*", -1)
+	matched, _ := regexp.MatchString("^"+expr+"$", name)
+	return matched
+}
+
+// Format a string as a quoted string, or as a literal if it can't be quoted
+func imapString(s string) string {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+	}
+	return util.Quote(s)
+}
+
+// Fetch annotations (RFC 5257), eg ANNOTATION (/comment (value.priv))
+func fetchAnnotation(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	if _, ok := underlyingMailbox(c.SelectedMailbox).(mailstore.AnnotationMailbox); !ok {
+		return "", ErrUnrecognisedParameter
+	}
+	mailbox := c.SelectedMailbox.(mailstore.AnnotationMailbox)
+	tokens := annotationTokens(util.FormatList(attr.Args))
+	if len(tokens) != 2 {
+		return "", ErrUnrecognisedParameter
+	}
+	entryPatterns := annotationListOrSingle(tokens[0])
+	attributePatterns := annotationListOrSingle(tokens[1])
+
+	annotations, err := mailstore.Annotations(c.context(), mailbox, m.UID())
+	if err != nil {
+		return "", err
+	}
+
+	// Entries named explicitly are always returned; wildcards only return
+	// entries which exist
+	entries := make([]string, 0)
+	for _, pattern := range entryPatterns {
+		pattern = unquoteAnnotation(pattern)
+		if !strings.ContainsAny(pattern, "*%") {
+			entries = append(entries, pattern)
+			continue
+		}
+		matched := make([]string, 0)
+		for entry := range annotations {
+			if annotationMatch(pattern, entry, "/") {
+				matched = append(matched, entry)
+			}
+		}
+		sort.Strings(matched)
+		entries = append(entries, matched...)
+	}
+
+	parts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		values := make([]string, 0)
+		for _, attribute := range annotationAttributes {
+			if !annotationAttributeRequested(attributePatterns, attribute) {
+				continue
+			}
+			values = append(values, attribute+" "+annotationValue(annotations[entry], attribute))
+		}
+		parts = append(parts, entry+" ("+strings.Join(values, " ")+")")
+	}
+	return "ANNOTATION (" + strings.Join(parts, " ") + ")", nil
+}
+
+// Returns true if an attribute matches any of the requested patterns. A
+// pattern without a suffix (eg "value") requests both the private and
+// shared attributes.
+func annotationAttributeRequested(patterns []string, attribute string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(unquoteAnnotation(pattern))
+		if !strings.ContainsAny(pattern, ".*%") {
+			pattern += ".*"
+		}
+		if annotationMatch(pattern, attribute, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Format the value of an attribute, calculating sizes from the values
+func annotationValue(attributes map[string]string, attribute string) string {
+	if strings.HasPrefix(attribute, "size.") {
+		value, ok := attributes["value."+strings.TrimPrefix(attribute, "size.")]
+		if !ok {
+			return "NIL"
+		}
+		return fmt.Sprintf("\"%d\"", len(value))
+	}
+	value, ok := attributes[attribute]
+	if !ok {
+		return "NIL"
+	}
+	return imapString(value)
+}
+
+// A change to one attribute of an annotation entry
+type annotationChange struct {
+	entry     string
+	attribute string
+	value     string
+	remove    bool
+}
+
+// Parse the entries of a STORE ANNOTATION command, eg
+// /comment (value.priv "My comment" value.shared NIL)
+func parseAnnotationChanges(list string) ([]annotationChange, error) {
+	tokens := annotationTokens(list)
+	if len(tokens) == 0 || len(tokens)%2 != 0 {
+		return nil, fmt.Errorf("Invalid annotation list")
+	}
+
+	changes := make([]annotationChange, 0)
+	for i := 0; i < len(tokens); i += 2 {
+		entry := unquoteAnnotation(tokens[i])
+		if !strings.HasPrefix(entry, "/") || strings.ContainsAny(entry, "*%") {
+			return nil, fmt.Errorf("Invalid annotation entry %s", entry)
+		}
+		attributes := annotationListOrSingle(tokens[i+1])
+		if len(attributes)%2 != 0 {
+			return nil, fmt.Errorf("Invalid attributes for annotation entry %s", entry)
+		}
+		for j := 0; j < len(attributes); j += 2 {
+			attribute := strings.ToLower(unquoteAnnotation(attributes[j]))
+			if attribute != "value.priv" && attribute != "value.shared" {
+				return nil, fmt.Errorf("Annotation attribute %s can't be stored", attribute)
+			}
+			value := attributes[j+1]
+			changes = append(changes, annotationChange{
+				entry:     entry,
+				attribute: attribute,
+				value:     unquoteAnnotation(value),
+				remove:    strings.ToUpper(value) == "NIL",
+			})
+		}
+	}
+	return changes, nil
+}
+
+// Handles STORE ANNOTATION, which sets or removes message annotations
+func cmdStoreAnnotation(args commandArgs, c *Conn) {
+	if !c.assertWritable(args.ID()) {
+		return
+	}
+	if _, ok := underlyingMailbox(c.SelectedMailbox).(mailstore.AnnotationMailbox); !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgNoAnnotations))
+		return
+	}
+	mailbox := c.SelectedMailbox.(mailstore.AnnotationMailbox)
+
+	changes, err := parseAnnotationChanges(args.Arg(storeAnnotationArgList))
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+	var msgs []mailstore.Message
+	if strings.ToUpper(args.Arg(storeAnnotationArgUID)) == "UID " {
+		var uidSet types.UIDSet
+		if uidSet, err = types.InterpretUIDSet(args.Arg(storeAnnotationArgRange)); err != nil {
+			c.writeResponse(args.ID(), "BAD "+err.Error())
+			return
+		}
+		msgs, err = mailstore.MessageSetByUID(c.context(), c.SelectedMailbox, uidSet)
+	} else {
+		var seqSet types.SequenceSet
+		if seqSet, err = types.InterpretSequenceSet(args.Arg(storeAnnotationArgRange)); err != nil {
+			c.writeResponse(args.ID(), "BAD "+err.Error())
+			return
+		}
+		msgs, err = c.messagesBySequenceSet(seqSet)
+	}
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	for _, msg := range msgs {
+		for _, change := range changes {
+			if change.remove {
+				err = mailstore.RemoveAnnotation(c.context(), mailbox, msg.UID(), change.entry, change.attribute)
+			} else {
+				err = mailstore.SetAnnotation(c.context(), mailbox, msg.UID(), change.entry, change.attribute, change.value)
+			}
+			if err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "STORE"))
+}
@@ -0,0 +1,44 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Annotations", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateSelected)
+		tConn.SetReadWrite()
+		tConn.User = mStore.User
+		tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+	})
+
+	It("should return NIL for annotations which are not set", func() {
+		SendLine("abcd.123 FETCH 1 (ANNOTATION (/comment value))")
+		ExpectResponse("* 1 FETCH (ANNOTATION (/comment (value.priv NIL value.shared NIL)))")
+		ExpectResponse("abcd.123 OK FETCH completed")
+	})
+
+	It("should store and fetch annotations", func() {
+		SendLine("abcd.123 STORE 1:2 ANNOTATION (/comment (value.priv \"My \\\"comment\\\"\" value.shared \"Shared\"))")
+		ExpectResponse("abcd.123 OK STORE completed")
+		SendLine("abcd.124 UID FETCH 11 (ANNOTATION (/comment (value.priv size.priv)) FLAGS)")
+		ExpectResponse("* 2 FETCH (ANNOTATION (/comment (value.priv \"My \\\"comment\\\"\" size.priv \"12\")) FLAGS (\\Recent) UID 11)")
+		ExpectResponse("abcd.124 OK UID FETCH completed")
+	})
+
+	It("should remove annotations stored as NIL", func() {
+		SendLine("abcd.123 STORE 1 ANNOTATION (/comment (value.priv \"Note\") /altsubject (value.shared \"Subject\"))")
+		ExpectResponse("abcd.123 OK STORE completed")
+		SendLine("abcd.124 STORE 1 ANNOTATION (/comment (value.priv NIL))")
+		ExpectResponse("abcd.124 OK STORE completed")
+		SendLine("abcd.125 FETCH 1 (ANNOTATION (* value.shared))")
+		ExpectResponse("* 1 FETCH (ANNOTATION (/altsubject (value.shared \"Subject\")))")
+		ExpectResponse("abcd.125 OK FETCH completed")
+	})
+
+	It("should refuse to store sizes", func() {
+		SendLine("abcd.123 STORE 1 ANNOTATION (/comment (size.priv \"10\"))")
+		ExpectResponse("abcd.123 BAD Annotation attribute size.priv can't be stored")
+	})
+})
@@ -1,8 +1,9 @@
 package conn
 
 import (
-	"strconv"
+	"bytes"
 
+	"github.com/jordwest/imap-server/mailstore"
 	"github.com/jordwest/imap-server/types"
 )
 
@@ -10,58 +11,157 @@ const (
 	appendArgMailbox int = 0
 	appendArgFlags   int = 1
 	appendArgDate    int = 2
-	appendArgLength  int = 3
+	appendArgBinary  int = 3
+	appendArgLength  int = 4
+	appendArgNonSync int = 5
 )
 
+// The largest non-synchronizing literal accepted, as required by LITERAL-
+const maxNonSyncLiteralSize = 4096
+
 // Add a new message to a mailbox
 func cmdAppend(args commandArgs, c *Conn) {
-	if !c.assertAuthenticated(args.ID()) {
+	req, err := appendRequest(args, 0)
+	msg, mailbox, ok := c.receiveMessage(args.ID(), req, err, nil)
+	if !ok {
 		return
 	}
 
-	mailboxName := args.Arg(appendArgMailbox)
-	mailbox, err := c.User.MailboxByName(mailboxName)
+	_, err = mailstore.SaveMessage(c.context(), msg)
 	if err != nil {
-		c.writeResponse(args.ID(), "NO could not get mailbox")
+		c.writeError(args.ID(), err)
 		return
 	}
+	c.announceChanges(mailbox.Name())
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "APPEND"))
+}
 
-	length, err := strconv.ParseUint(args.Arg(appendArgLength), 10, 64)
-	if err != nil || length == 0 {
-		c.writeResponse(args.ID(), "BAD invalid length for message literal")
-		return
+// Receive a message literal from the client and prepare it to be saved
+// into a mailbox, as done by both APPEND and REPLACE. Any error parsing the
+// request's arguments is reported once the literal can be refused or has
+// been consumed. The precheck function, if any, is run before anything is
+// saved, once any non-synchronizing literal has been consumed, and must
+// write its own response if the command cannot proceed. The returned
+// message has not yet been saved. Returns false if a response has already
+// been sent to the client.
+func (c *Conn) receiveMessage(tag string, req types.AppendRequest, parseErr error, precheck func() bool) (mailstore.Message, mailstore.Mailbox, bool) {
+	if req.Length == 0 {
+		c.writeResponse(tag, "BAD "+parseErr.Error())
+		return nil, nil, false
 	}
+	length := req.Length
 
-	flagString := args.Arg(appendArgFlags)
-	flags := types.Flags(0)
-	if flagString != "" {
-		flags = types.FlagsFromString(flagString)
+	// The client sends a non-synchronizing literal without waiting for a
+	// continuation, so it has to be consumed before the command can be
+	// rejected for any other reason
+	var messageData []byte
+	var err error
+	tooBig := c.AppendLimit > 0 && length > uint64(c.AppendLimit)
+	overMemory := c.exceedsMemoryLimit(length)
+	if req.NonSync {
+		if c.exceedsLiteralLimit(length) {
+			c.discardFixedLength(int64(length))
+			c.writeNo(tag, codeTooBig, c.text(MsgLiteralTooLarge))
+			return nil, nil, false
+		}
+		if length > maxNonSyncLiteralSize {
+			c.discardFixedLength(int64(length))
+			c.writeBad(tag, codeTooBig, c.text(MsgNonSyncLiteralTooLarge))
+			return nil, nil, false
+		}
+		if tooBig {
+			c.discardFixedLength(int64(length))
+			c.writeNo(tag, codeTooBig, c.text(MsgOverAppendLimit))
+			return nil, nil, false
+		}
+		if overMemory {
+			c.discardFixedLength(int64(length))
+			c.writeNo(tag, codeLimit, c.text(MsgMessageOverMemoryLimit))
+			return nil, nil, false
+		}
+		messageData, err = c.ReadFixedLength(int(length))
+		if err != nil {
+			return nil, nil, false
+		}
 	}
 
-	// Tell client to send the mail message
-	c.writeResponse("+", "go ahead, feed me your message")
+	if precheck != nil && !precheck() {
+		return nil, nil, false
+	}
+	if parseErr != nil {
+		c.writeResponse(tag, "BAD "+parseErr.Error())
+		return nil, nil, false
+	}
 
-	// Read in the whole message
-	messageData, err := c.ReadFixedLength(int(length))
+	mailbox, err := mailstore.MailboxByName(c.context(), c.User, req.Mailbox)
 	if err != nil {
-		return
+		c.writeNo(tag, codeTryCreate, c.text(MsgNoSuchMailbox))
+		return nil, nil, false
+	}
+	if isNoselect(mailbox) {
+		c.writeNo(tag, codeCannot, c.text(MsgCannotHoldMessages))
+		return nil, nil, false
+	}
+	if c.examining(mailbox.Name()) {
+		c.writeNo(tag, codeNone, c.text(MsgReadOnly))
+		return nil, nil, false
+	}
+
+	// The new message is recent to whichever session sees it first
+	flags := req.Flags
+	flags.System = flags.System.SetFlags(types.FlagRecent)
+
+	if c.exceedsQuota(length) {
+		c.writeNo(tag, codeOverQuota, c.text(MsgOverQuota))
+		return nil, nil, false
+	}
+
+	if !req.NonSync {
+		// Refuse oversize messages before the client starts sending them
+		if c.exceedsLiteralLimit(length) {
+			c.writeNo(tag, codeTooBig, c.text(MsgLiteralTooLarge))
+			return nil, nil, false
+		}
+		if tooBig {
+			c.writeNo(tag, codeTooBig, c.text(MsgOverAppendLimit))
+			return nil, nil, false
+		}
+		if overMemory {
+			c.writeNo(tag, codeLimit, c.text(MsgMessageOverMemoryLimit))
+			return nil, nil, false
+		}
+
+		// Tell client to send the mail message
+		c.writeResponse("+", c.text(MsgReadyForMessage))
+
+		// Read in the whole message
+		messageData, err = c.ReadFixedLength(int(length))
+		if err != nil {
+			return nil, nil, false
+		}
+	}
+
+	// Only a literal8 may contain NULs (RFC 3516 section 4.3)
+	if !req.Binary && bytes.IndexByte(messageData, 0) >= 0 {
+		c.writeBad(tag, codeNone, c.text(MsgMessageNUL))
+		return nil, nil, false
 	}
 
-	msg := mailbox.NewMessage()
 	rawMsg, err := types.MessageFromBytes(messageData)
 	if err != nil {
-		c.writeResponse(args.ID(), "NO "+err.Error())
-		return
+		c.writeNo(tag, codeNone, c.text(MsgUnparseableMessage, err))
+		return nil, nil, false
+	}
+	msg, err := mailstore.NewMessage(c.context(), mailbox)
+	if err == nil {
+		msg, err = mailstore.SetMessageFlags(c.context(), msg.SetHeaders(rawMsg.Headers).SetBody(rawMsg.Body), flags)
 	}
-	msg = msg.SetHeaders(rawMsg.Headers)
-	msg = msg.SetBody(rawMsg.Body)
-	msg = msg.OverwriteFlags(flags)
-
-	msg, err = msg.Save()
 	if err != nil {
-		c.writeResponse(args.ID(), "NO "+err.Error())
-		return
+		c.writeError(tag, err)
+		return nil, nil, false
 	}
-
-	c.writeResponse(args.ID(), "OK APPEND completed")
+	if dated, ok := msg.(mailstore.InternalDateMessage); ok && !req.Date.IsZero() {
+		msg = dated.SetInternalDate(req.Date)
+	}
+	return msg, mailbox, true
 }
@@ -1,7 +1,12 @@
 package conn_test
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
+	"github.com/jordwest/imap-server/util"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -34,6 +39,8 @@ var _ = Describe("APPEND Command", func() {
 			Expect(msg.Header().Get("From")).To(Equal("me@testing.com"))
 			Expect(msg.Header().Get("To")).To(Equal("you@testing.com"))
 			Expect(msg.Header().Get("Subject")).To(Equal("This is a newly appended email"))
+			Expect(msg.Flags()).To(Equal(types.FlagSeen | types.FlagRecent))
+			Expect(msg.InternalDate().Format(util.InternalDate)).To(Equal("21-Jun-2015 01:00:25 +0900"))
 
 			// Ensure no other emails were interfered with
 			msg = mbox.MessageBySequenceNumber(1)
@@ -45,5 +52,138 @@ var _ = Describe("APPEND Command", func() {
 			msg = mbox.MessageBySequenceNumber(4)
 			Expect(msg.Header().Get("Subject")).To(Equal("This is a newly appended email"))
 		})
+
+		It("should store keywords given with the flags", func() {
+			SendLine("abcd.123 APPEND INBOX (\\Seen $Forwarded) {13}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Hi")
+			ExpectResponse("abcd.123 OK APPEND completed")
+
+			msg := tConn.User.Mailboxes()[0].MessageBySequenceNumber(4)
+			Expect(msg.Flags()).To(Equal(types.FlagSeen | types.FlagRecent))
+			Expect(msg.Keywords()).To(Equal([]string{"$Forwarded"}))
+		})
+
+		It("should accept an empty flag list and a space-padded date", func() {
+			SendLine("abcd.123 APPEND INBOX () \" 1-Jun-2015 01:00:25 -0500\" {13}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Hi")
+			ExpectResponse("abcd.123 OK APPEND completed")
+
+			msg := tConn.User.Mailboxes()[0].MessageBySequenceNumber(4)
+			Expect(msg.Flags()).To(Equal(types.FlagRecent))
+			Expect(msg.InternalDate().Format(util.InternalDate)).To(Equal("01-Jun-2015 01:00:25 -0500"))
+		})
+
+		It("should reject an invalid date", func() {
+			SendLine("abcd.123 APPEND INBOX \"31-Foo-2015 01:00:25 +0900\" {13}")
+			ExpectResponse("abcd.123 BAD invalid date-time for message")
+		})
+
+		It("should ask the client to create a missing mailbox", func() {
+			SendLine("abcd.123 APPEND Archive {13}")
+			ExpectResponse("abcd.123 NO [TRYCREATE] Mailbox does not exist")
+		})
+
+		It("should append a message sent as a binary literal", func() {
+			SendLine("abcd.123 APPEND INBOX ~{38}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Binary email")
+			SendLine("")
+			SendLine("Null \x00 body")
+			ExpectResponse("abcd.123 OK APPEND completed")
+
+			msg := tConn.User.Mailboxes()[0].MessageBySequenceNumber(4)
+			Expect(msg.Body()).To(Equal("Null \x00 body\r\n"))
+		})
+
+		It("should reject a NUL in a message not sent as a binary literal", func() {
+			SendLine("abcd.123 APPEND INBOX {38}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Binary email")
+			SendLine("")
+			SendLine("Null \x00 body")
+			ExpectResponse("abcd.123 BAD Message contains NUL, which is only allowed in a literal8")
+			Expect(tConn.User.Mailboxes()[0].Messages()).To(Equal(uint32(3)))
+		})
+
+		It("should append a message sent as a non-synchronizing literal", func() {
+			SendLine("abcd.123 APPEND INBOX {34+}")
+			SendLine("Subject: Non-sync email")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("abcd.123 OK APPEND completed")
+
+			msg := tConn.User.Mailboxes()[0].MessageBySequenceNumber(4)
+			Expect(msg.Header().Get("Subject")).To(Equal("Non-sync email"))
+		})
+
+		It("should read a non-synchronizing literal sent along with its command", func() {
+			fmt.Fprint(mockConn.Client, "abcd.123 APPEND INBOX {34+}\r\nSubject: Non-sync email\r\n\r\nHello\r\nabcd.124 NOOP\r\n")
+			ExpectResponse("abcd.123 OK APPEND completed")
+			ExpectResponse("abcd.124 OK NOOP completed")
+			Expect(tConn.User.Mailboxes()[0].Messages()).To(Equal(uint32(4)))
+		})
+
+		It("should reject non-synchronizing literals larger than 4096 bytes", func() {
+			SendLine("abcd.123 APPEND INBOX {4097+}")
+			SendLine(strings.Repeat("x", 4095))
+			ExpectResponse("abcd.123 BAD [TOOBIG] non-synchronizing literal too large")
+
+			// The literal should have been discarded rather than interpreted
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+			Expect(tConn.User.Mailboxes()[0].Messages()).To(Equal(uint32(3)))
+		})
+	})
+
+	Context("When an APPENDLIMIT is set", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+			tConn.AppendLimit = 20
+		})
+
+		It("should advertise the limit", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponsePattern("^\\* CAPABILITY .* APPENDLIMIT=20$")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should reject oversize messages without reading them", func() {
+			SendLine("abcd.123 APPEND INBOX {21}")
+			ExpectResponse("abcd.123 NO [TOOBIG] message exceeds APPENDLIMIT")
+		})
+
+		It("should discard oversize non-synchronizing literals", func() {
+			SendLine("abcd.123 APPEND INBOX {21+}")
+			SendLine("Subject: Too big!!!")
+			ExpectResponse("abcd.123 NO [TOOBIG] message exceeds APPENDLIMIT")
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should accept messages within the limit", func() {
+			SendLine("abcd.123 APPEND INBOX {20}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Small")
+			SendLine("")
+			SendLine("")
+			ExpectResponse("abcd.123 OK APPEND completed")
+		})
+	})
+
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should consume a non-synchronizing literal before rejecting", func() {
+			SendLine("abcd.123 APPEND INBOX {7+}")
+			SendLine("Hello")
+			ExpectResponse("abcd.123 BAD not authenticated")
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
 	})
 })
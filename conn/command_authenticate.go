@@ -3,38 +3,188 @@ package conn
 import (
 	"encoding/base64"
 	"regexp"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+const authArgInitialResponse int = 0
+
+// Error challenges sent to the client when token authentication fails
+const (
+	xoauth2ErrorChallenge     = `{"status":"401","schemes":"Bearer"}`
+	oauthBearerErrorChallenge = `{"status":"invalid_token"}`
 )
 
 // Handles PLAIN text AUTHENTICATE command
 func cmdAuthPlain(args commandArgs, c *Conn) {
+	if !c.assertCapabilityEnabled(args.ID(), "AUTH=PLAIN") {
+		return
+	}
+
 	// Compile login regex
-	loginRE := regexp.MustCompile("(?:[A-z0-9]+)?\x00([A-z0-9]+)\x00([A-z0-9]+)")
+	loginRE := regexp.MustCompile("([A-z0-9]*)\x00([A-z0-9]+)\x00([A-z0-9]+)")
+
+	data, ok := c.readAuthResponse(args)
+	if !ok {
+		return
+	}
+	match := loginRE.FindSubmatch(data)
+	if len(match) != 4 {
+		c.writeNo(args.ID(), codeAuthenticationFailed, c.text(MsgIncorrectLogin))
+		return
+	}
+	authzid, username := string(match[1]), string(match[2])
+	if c.referLogin(args.ID(), username) {
+		return
+	}
+	user, err := mailstore.Authenticate(c.context(), c.Mailstore, username, string(match[3]))
+	if err != nil {
+		c.writeLoginFailure(args.ID(), username, err, MsgIncorrectLogin)
+		return
+	}
 
-	// Tell client to go ahead
-	c.writeResponse("+", "")
+	// Acting on behalf of another user isn't supported
+	if authzid != "" && authzid != username {
+		c.writeError(args.ID(), mailstore.ErrAuthorizationFailed)
+		return
+	}
+	c.setAuthenticated(username, user)
+	c.writeOK(args.ID(), codeNone, c.text(MsgAuthenticated))
+}
 
-	// Wait for client to send auth details
-	ok := c.RwcScanner.Scan()
+// Handles the XOAUTH2 AUTHENTICATE command (Gmail-style bearer tokens)
+func cmdAuthXOAuth2(args commandArgs, c *Conn) {
+	if !c.assertCapabilityEnabled(args.ID(), "AUTH=XOAUTH2") {
+		return
+	}
+	data, ok := c.readAuthResponse(args)
 	if !ok {
 		return
 	}
-	authDetails := c.RwcScanner.Text()
+	username, token := parseXOAuth2(string(data))
+	c.authenticateToken(args, username, token, xoauth2ErrorChallenge)
+}
+
+// Handles the OAUTHBEARER AUTHENTICATE command (RFC 7628)
+func cmdAuthOAuthBearer(args commandArgs, c *Conn) {
+	if !c.assertCapabilityEnabled(args.ID(), "AUTH=OAUTHBEARER") {
+		return
+	}
+	data, ok := c.readAuthResponse(args)
+	if !ok {
+		return
+	}
+	username, token := parseOAuthBearer(string(data))
+	c.authenticateToken(args, username, token, oauthBearerErrorChallenge)
+}
+
+// Wait for the client to send its authentication details, unless they were
+// already supplied as an initial response on the command line (SASL-IR).
+// Returns false if the exchange has already been answered.
+func (c *Conn) readAuthResponse(args commandArgs) (data []byte, ok bool) {
+	authDetails := args.Arg(authArgInitialResponse)
+	if authDetails == "" {
+		// Tell client to go ahead
+		c.writeResponse("+", "")
+
+		// Wait for client to send auth details
+		var tooLong bool
+		authDetails, tooLong, ok = c.readLine()
+		if !ok {
+			return nil, false
+		}
+		if tooLong {
+			c.writeBad(args.ID(), codeTooBig, c.text(MsgAuthResponseTooLong))
+			return nil, false
+		}
+	} else if authDetails == "=" {
+		// A lone "=" is an empty initial response
+		authDetails = ""
+	}
+
+	if authDetails == "*" {
+		c.writeBad(args.ID(), codeNone, c.text(MsgAuthCancelled))
+		return nil, false
+	}
 
 	data, err := base64.StdEncoding.DecodeString(authDetails)
 	if err != nil {
-		c.writeResponse("", "BAD Invalid auth details")
+		c.writeBad(args.ID(), codeNone, c.text(MsgInvalidAuth))
+		return nil, false
+	}
+	return data, true
+}
+
+// Hand a bearer token to the mailstore's TokenAuthenticator. On failure the
+// error challenge is sent and the client's (empty) reply awaited before
+// responding with NO, as both XOAUTH2 and OAUTHBEARER require.
+func (c *Conn) authenticateToken(args commandArgs, username string, token string, errChallenge string) {
+	tokenAuth, ok := c.Mailstore.(mailstore.TokenAuthenticator)
+	if !ok {
+		c.writeNo(args.ID(), codeNone, c.text(MsgUnsupportedMechanism))
 		return
 	}
-	match := loginRE.FindSubmatch(data)
-	if len(match) != 3 {
-		c.writeResponse(args.ID(), "NO Incorrect username/password")
+
+	if c.referLogin(args.ID(), username) {
 		return
 	}
-	c.User, err = c.Mailstore.Authenticate(string(match[1]), string(match[2]))
-	if err != nil {
-		c.writeResponse(args.ID(), "NO Incorrect username/password")
+
+	var user mailstore.User
+	var err error
+	if token != "" {
+		user, err = mailstore.AuthenticateToken(c.context(), tokenAuth, username, token)
+	}
+	if token == "" || err != nil {
+		c.writeResponse("+", base64.StdEncoding.EncodeToString([]byte(errChallenge)))
+		c.ReadLine()
+		c.writeLoginFailure(args.ID(), username, err, MsgInvalidCredentials)
 		return
 	}
-	c.SetState(StateAuthenticated)
-	c.writeResponse(args.ID(), "OK Authenticated")
+
+	c.setAuthenticated(username, user)
+	c.writeOK(args.ID(), codeNone, c.text(MsgAuthenticated))
+}
+
+// Extract the username and bearer token from an XOAUTH2 client response
+// eg "user=someone@example.com\x01auth=Bearer ya29.token\x01\x01"
+func parseXOAuth2(data string) (username string, token string) {
+	for _, field := range strings.Split(data, "\x01") {
+		if strings.HasPrefix(field, "user=") {
+			username = field[len("user="):]
+		} else if strings.HasPrefix(field, "auth=") {
+			token = bearerToken(field[len("auth="):])
+		}
+	}
+	return username, token
+}
+
+// Extract the authorization identity and bearer token from an OAUTHBEARER
+// client response
+// eg "n,a=someone@example.com,\x01host=server\x01auth=Bearer ya29.token\x01\x01"
+func parseOAuthBearer(data string) (username string, token string) {
+	fields := strings.Split(data, "\x01")
+
+	// The GS2 header carries the (optional) authorization identity
+	for _, attr := range strings.Split(fields[0], ",") {
+		if strings.HasPrefix(attr, "a=") {
+			username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attr[len("a="):])
+		}
+	}
+
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "auth=") {
+			token = bearerToken(field[len("auth="):])
+		}
+	}
+	return username, token
+}
+
+// Strip the (case insensitive) "Bearer " scheme from an auth value
+func bearerToken(auth string) string {
+	const scheme = "bearer "
+	if len(auth) <= len(scheme) || strings.ToLower(auth[:len(scheme)]) != scheme {
+		return ""
+	}
+	return auth[len(scheme):]
 }
@@ -1,6 +1,8 @@
 package conn_test
 
 import (
+	"encoding/base64"
+
 	"github.com/jordwest/imap-server/conn"
 	. "github.com/onsi/ginkgo"
 )
@@ -23,5 +25,40 @@ var _ = Describe("AUTHENTICATE Command", func() {
 
 		PIt("should give an error", func() {
 		})
+
+		It("should authenticate with PLAIN and an initial response", func() {
+			SendLine("abcd.123 AUTHENTICATE PLAIN " +
+				base64.StdEncoding.EncodeToString([]byte("\x00username\x00password")))
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
+
+		It("should authenticate with an XOAUTH2 bearer token", func() {
+			SendLine("abcd.123 AUTHENTICATE XOAUTH2")
+			ExpectResponse("+")
+			SendBase64("user=username\x01auth=Bearer token\x01\x01")
+			SendLine("")
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
+
+		It("should authenticate with an OAUTHBEARER initial response", func() {
+			SendLine("abcd.123 AUTHENTICATE OAUTHBEARER " +
+				base64.StdEncoding.EncodeToString([]byte("n,a=username,\x01host=localhost\x01port=143\x01auth=Bearer token\x01\x01")))
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
+
+		It("should send an error challenge for an invalid XOAUTH2 token", func() {
+			SendLine("abcd.123 AUTHENTICATE XOAUTH2 " +
+				base64.StdEncoding.EncodeToString([]byte("user=username\x01auth=Bearer wrong\x01\x01")))
+			ExpectResponse("+ " + base64.StdEncoding.EncodeToString([]byte(`{"status":"401","schemes":"Bearer"}`)))
+			SendLine("")
+			ExpectResponse("abcd.123 NO [AUTHENTICATIONFAILED] Invalid credentials")
+		})
+
+		It("should allow the client to cancel authentication", func() {
+			SendLine("abcd.123 AUTHENTICATE OAUTHBEARER")
+			ExpectResponse("+")
+			SendLine("*")
+			ExpectResponse("abcd.123 BAD Authentication cancelled")
+		})
 	})
 })
@@ -1,7 +1,144 @@
 package conn
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// Returns the capabilities to advertise on a connection, which may depend on
+// its state, its configuration or the logged in user
+type capabilitySource func(c *Conn) []string
+
+// The sources of every capability the server may advertise, in the order
+// they're advertised
+var capabilitySources []capabilitySource
+
+func registerCapability(source capabilitySource) {
+	capabilitySources = append(capabilitySources, source)
+}
+
+// Advertise the capabilities in every state
+func always(names ...string) capabilitySource {
+	return func(c *Conn) []string { return names }
+}
+
+// Advertise the capabilities only while the condition holds
+func when(condition func(c *Conn) bool, names ...string) capabilitySource {
+	return func(c *Conn) []string {
+		if condition(c) {
+			return names
+		}
+		return nil
+	}
+}
+
+// Whether the client has yet to log in
+func preAuth(c *Conn) bool {
+	return c.state == StateNotAuthenticated
+}
+
+func init() {
+	registerCapability(always("IMAP4rev1"))
+
+	// Authenticating is only possible before logging in, so there's no
+	// point advertising how to do it afterwards
+	registerCapability(when(preAuth, "SASL-IR"))
+	registerCapability(always("LITERAL-", "BINARY", "STATUS=SIZE", "SAVEDATE", "REPLACE", "ID", "XLIST", "X-UID-MAP"))
+	registerCapability(always("ESEARCH", "SORT", "ESORT", "CONTEXT=SEARCH", "CONTEXT=SORT", "SEARCH=FUZZY", "MULTISEARCH"))
+	registerCapability(func(c *Conn) []string {
+		if !preAuth(c) {
+			return nil
+		}
+		return c.offeredAuthMechanisms()
+	})
+
+	// Clients are told not to bother trying to log in if every way of
+	// doing so has been disabled
+	registerCapability(when(func(c *Conn) bool {
+		return preAuth(c) && c.loginDisabled()
+	}, "LOGINDISABLED"))
+
+	// URLAUTH keys are stored per user, so it can only be offered once the
+	// user has logged in
+	registerCapability(when(func(c *Conn) bool {
+		_, ok := c.User.(mailstore.AccessKeyUser)
+		return ok
+	}, "URLAUTH"))
+
+	// Annotations are stored by mailboxes, so are only advertised once the
+	// user has logged in and only if their INBOX supports them
+	registerCapability(when(func(c *Conn) bool {
+		if c.User == nil {
+			return false
+		}
+		inbox, err := mailstore.MailboxByName(c.context(), c.User, "INBOX")
+		if err != nil {
+			return false
+		}
+		_, ok := inbox.(mailstore.AnnotationMailbox)
+		return ok
+	}, "ANNOTATE-EXPERIMENT-1"))
+
+	registerCapability(when(func(c *Conn) bool { return c.UTF8Accept || c.QResync }, "ENABLE"))
+	registerCapability(when(func(c *Conn) bool { return c.UTF8Accept }, "UTF8=ACCEPT"))
+	registerCapability(when(func(c *Conn) bool { return c.QResync }, "CONDSTORE", "QRESYNC"))
+	registerCapability(when(func(c *Conn) bool { return c.Transactions }, "XTRANSACTION"))
+	registerCapability(when(func(c *Conn) bool { return c.Catalog != nil }, "LANGUAGE"))
+	registerCapability(when(func(c *Conn) bool { return c.LoginReferral != nil }, "LOGIN-REFERRALS"))
+	registerCapability(func(c *Conn) []string { return c.fetchItemCapabilities() })
+	registerCapability(func(c *Conn) []string {
+		if c.AppendLimit == 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf("APPENDLIMIT=%d", c.AppendLimit)}
+	})
+}
+
 // Handles a CAPABILITY command
 func cmdCapability(args commandArgs, c *Conn) {
-	c.writeResponse("", "CAPABILITY IMAP4rev1 AUTH=PLAIN")
-	c.writeResponse(args.ID(), "OK CAPABILITY completed")
+	c.writeResponse("", "CAPABILITY "+strings.Join(c.capabilities(), " "))
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "CAPABILITY"))
+}
+
+// List the authentication mechanisms supported by the mailstore, whether or
+// not they have been disabled. Token authentication is only offered if the
+// mailstore can verify tokens.
+func (c *Conn) offeredAuthMechanisms() []string {
+	if _, ok := c.Mailstore.(mailstore.TokenAuthenticator); ok {
+		return authMechanisms
+	}
+	return authMechanisms[:1]
+}
+
+// List the capabilities supported for this connection in its current state
+func (c *Conn) capabilities() []string {
+	caps := make([]string, 0)
+	for _, source := range capabilitySources {
+		caps = append(caps, source(c)...)
+	}
+
+	for _, extra := range c.ExtraCapabilities {
+		if !hasCapability(caps, extra) {
+			caps = append(caps, extra)
+		}
+	}
+
+	enabled := caps[:0]
+	for _, capability := range caps {
+		if !c.capabilityDisabled(capability) {
+			enabled = append(enabled, capability)
+		}
+	}
+	return enabled
+}
+
+func hasCapability(caps []string, capability string) bool {
+	for _, c := range caps {
+		if strings.EqualFold(c, capability) {
+			return true
+		}
+	}
+	return false
 }
@@ -13,9 +13,36 @@ var _ = Describe("CAPABILITY Command", func() {
 
 		It("should return server capabilities", func() {
 			SendLine("abcd.123 CAPABILITY")
-			ExpectResponse("* CAPABILITY IMAP4rev1 AUTH=PLAIN")
+			ExpectResponse("* CAPABILITY IMAP4rev1 SASL-IR LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH AUTH=PLAIN AUTH=XOAUTH2 AUTH=OAUTHBEARER")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should include extra capabilities", func() {
+			tConn.ExtraCapabilities = []string{"X-ACME-PUSH", "id"}
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 SASL-IR LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH AUTH=PLAIN AUTH=XOAUTH2 AUTH=OAUTHBEARER X-ACME-PUSH")
 			ExpectResponse("abcd.123 OK CAPABILITY completed")
 		})
 	})
 
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should no longer offer ways to authenticate", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH URLAUTH ANNOTATE-EXPERIMENT-1")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should offer extensions which have been configured", func() {
+			tConn.Transactions = true
+			tConn.AppendLimit = 1024
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH URLAUTH ANNOTATE-EXPERIMENT-1 XTRANSACTION APPENDLIMIT=1024")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+	})
 })
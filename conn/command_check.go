@@ -0,0 +1,15 @@
+package conn
+
+import "github.com/jordwest/imap-server/mailstore"
+
+// Ask the mailstore to write the selected mailbox's changes to storage, if
+// it buffers them
+func cmdCheck(args commandArgs, c *Conn) {
+	if mailbox, ok := c.SelectedMailbox.(mailstore.CheckpointMailbox); ok {
+		if err := mailstore.Checkpoint(c.context(), mailbox); err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "CHECK"))
+}
@@ -0,0 +1,65 @@
+package conn_test
+
+import (
+	"errors"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A mailbox which counts its checkpoints, and fails if told to
+type checkpointMailbox struct {
+	mailstore.DummyMailbox
+	checkpoints *int
+	err         error
+}
+
+func (m checkpointMailbox) Checkpoint() error {
+	*m.checkpoints++
+	return m.err
+}
+
+var _ = Describe("CHECK Command", func() {
+	var checkpoints int
+
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			checkpoints = 0
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		})
+
+		It("should succeed for mailboxes without a checkpoint", func() {
+			SendLine("abcd.123 CHECK")
+			ExpectResponse("abcd.123 OK CHECK completed")
+		})
+
+		It("should checkpoint the mailbox", func() {
+			tConn.SelectedMailbox = checkpointMailbox{tConn.SelectedMailbox.(mailstore.DummyMailbox), &checkpoints, nil}
+			SendLine("abcd.123 CHECK")
+			ExpectResponse("abcd.123 OK CHECK completed")
+			Expect(checkpoints).To(Equal(1))
+		})
+
+		It("should report a failed checkpoint", func() {
+			tConn.SelectedMailbox = checkpointMailbox{tConn.SelectedMailbox.(mailstore.DummyMailbox), &checkpoints, errors.New("Disk full")}
+			SendLine("abcd.123 CHECK")
+			ExpectResponse("abcd.123 NO [SERVERBUG] Internal server error")
+		})
+	})
+
+	Context("When no mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 CHECK")
+			ExpectResponse("abcd.123 BAD not selected")
+		})
+	})
+})
@@ -1,7 +1,15 @@
 package conn
 
+// Leave the selected mailbox, silently expunging the messages flagged
+// \Deleted if it was selected for writing
 func cmdClose(args commandArgs, c *Conn) {
+	if c.mailboxWritable == ReadWrite {
+		if _, err := c.expungeDeleted(); err != nil && err != errCannotExpunge {
+			c.logf("Error expunging on CLOSE: %s\n", err)
+		}
+	}
+
 	c.SetState(StateAuthenticated)
 	c.SelectedMailbox = nil
-	c.writeResponse(args.ID(), "OK CLOSE Completed")
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "CLOSE"))
 }
@@ -2,26 +2,46 @@ package conn_test
 
 import (
 	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("CLOSE Command", func() {
-	Context("When logged in", func() {
+	Context("When a mailbox is selected", func() {
 		BeforeEach(func() {
-			tConn.SetState(conn.StateAuthenticated)
+			tConn.SetState(conn.StateSelected)
 			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+			tConn.SelectedMailbox.MessageBySequenceNumber(2).AddFlags(types.FlagDeleted).Save()
+		})
+
+		It("should silently expunge deleted messages", func() {
+			tConn.SetReadWrite()
+			SendLine("abcd.123 CLOSE")
+			ExpectResponse("abcd.123 OK CLOSE completed")
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			Expect(inbox.Messages()).To(Equal(uint32(2)))
+			Expect(tConn.SelectedMailbox).To(BeNil())
 		})
 
-		PIt("should (implement test)", func() {
+		It("should not expunge a mailbox selected read-only", func() {
+			SendLine("abcd.123 CLOSE")
+			ExpectResponse("abcd.123 OK CLOSE completed")
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			Expect(inbox.Messages()).To(Equal(uint32(3)))
 		})
 	})
 
-	Context("When not logged in", func() {
+	Context("When no mailbox is selected", func() {
 		BeforeEach(func() {
-			tConn.SetState(conn.StateNotAuthenticated)
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
 		})
 
-		PIt("should give an error", func() {
+		It("should give an error", func() {
+			SendLine("abcd.123 CLOSE")
+			ExpectResponse("abcd.123 BAD not selected")
 		})
 	})
 })
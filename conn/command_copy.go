@@ -0,0 +1,153 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	copyArgUID     int = 0
+	copyArgRange   int = 1
+	copyArgMailbox int = 2
+)
+
+// Copy messages from the selected mailbox into another mailbox, which must
+// already exist
+func cmdCopy(args commandArgs, c *Conn) {
+	req, err := copyRequest(args)
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+
+	destination, err := c.copyDestination(req.Mailbox)
+	if err != nil {
+		c.writeNo(args.ID(), codeTryCreate, c.text(MsgNoSuchDestination))
+		return
+	}
+	if c.examining(destination.Name()) {
+		c.writeNo(args.ID(), codeNone, c.text(MsgReadOnly))
+		return
+	}
+
+	var msgs []mailstore.Message
+	if req.UID {
+		msgs, err = mailstore.MessageSetByUID(c.context(), c.SelectedMailbox, req.UIDs)
+	} else {
+		msgs, err = c.messagesBySequenceSet(req.Set)
+	}
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	var size uint64
+	for _, msg := range msgs {
+		if msg != nil {
+			size += uint64(msg.Size())
+		}
+	}
+	if c.exceedsQuota(size) {
+		c.writeNo(args.ID(), codeOverQuota, c.text(MsgOverQuota))
+		return
+	}
+
+	err = copyMessages(c.context(), c.SelectedMailbox, msgs, destination, c.progressReporter(args.ID(), MsgCopying))
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.announceChanges(destination.Name())
+
+	// Tell the client about copies made into the mailbox it has selected,
+	// unless they're part of a transaction which might yet be rolled back
+	if destination.Name() == c.SelectedMailbox.Name() && c.transaction == nil {
+		c.reportMailboxChanges(true)
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, req.Command()))
+}
+
+// Look up the mailbox into which COPY copies messages, through the open
+// transaction if there is one
+func (c *Conn) copyDestination(name string) (mailstore.Mailbox, error) {
+	if c.transaction != nil {
+		return c.transaction.MailboxByName(name)
+	}
+	return mailstore.MailboxByName(c.context(), c.User, name)
+}
+
+// Copy messages into another mailbox, natively if the source mailbox
+// supports it. Otherwise each message is saved into the destination as a new
+// message with the same flags and, if the destination's messages support it,
+// the same internal date and keywords. Copies are marked \Recent. If a
+// message can't be saved, the copies already made are expunged again so that
+// COPY has no effect, as long as the destination can expunge messages.
+func copyMessages(ctx context.Context, from mailstore.Mailbox, msgs []mailstore.Message, to mailstore.Mailbox, progress func(int, int)) error {
+	if native, ok := from.(mailstore.CopyMailbox); ok {
+		uids := make([]uint32, 0, len(msgs))
+		for _, msg := range msgs {
+			if msg != nil {
+				uids = append(uids, msg.UID())
+			}
+		}
+		if err := mailstore.CopyMessages(ctx, native, uids, to); !errors.Is(err, mailstore.ErrNotSupported) {
+			return err
+		}
+	}
+
+	var copied []uint32
+	for i, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		saved, err := copyMessage(ctx, msg, to)
+		if err != nil {
+			return undoCopies(to, copied, err)
+		}
+		copied = append(copied, saved.UID())
+		if progress != nil {
+			progress(i+1, len(msgs))
+		}
+	}
+	return nil
+}
+
+// Save a copy of a message into another mailbox
+func copyMessage(ctx context.Context, msg mailstore.Message, to mailstore.Mailbox) (mailstore.Message, error) {
+	dup, err := mailstore.NewMessage(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	dup = dup.SetHeaders(msg.Header())
+	dup = dup.SetBody(msg.Body())
+	flags := mailstore.MessageFlags(msg)
+	flags.System |= types.FlagRecent
+	if dup, err = mailstore.SetMessageFlags(ctx, dup, flags); err != nil {
+		return nil, err
+	}
+	if dated, ok := dup.(mailstore.InternalDateMessage); ok {
+		dup = dated.SetInternalDate(msg.InternalDate())
+	}
+	return mailstore.SaveMessage(ctx, dup)
+}
+
+// Expunge the copies made by a COPY which failed part way through, and
+// return the error which stopped it. They're removed outside the command's
+// context, which may be what ended the copy.
+func undoCopies(to mailstore.Mailbox, copied []uint32, err error) error {
+	if len(copied) == 0 {
+		return err
+	}
+	expunger, ok := to.(mailstore.ExpungeMailbox)
+	if !ok {
+		return fmt.Errorf("%w (%d messages were copied before it)", err, len(copied))
+	}
+	if undoErr := mailstore.Expunge(context.Background(), expunger, copied); undoErr != nil {
+		return fmt.Errorf("%w (and the copies already made could not be removed: %s)", err, undoErr)
+	}
+	return err
+}
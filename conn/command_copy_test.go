@@ -0,0 +1,151 @@
+package conn_test
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A mailbox which copies messages itself
+type copyingMailbox struct {
+	mailstore.DummyMailbox
+	copied *[]uint32
+}
+
+func (m copyingMailbox) CopyMessages(uids []uint32, destination mailstore.Mailbox) error {
+	*m.copied = append(*m.copied, uids...)
+	return nil
+}
+
+// A user whose Trash runs out of space after a number of messages are saved
+// into it
+type fillingUser struct {
+	mailstore.User
+	saves *int
+	limit int
+}
+
+func (u fillingUser) MailboxByName(name string) (mailstore.Mailbox, error) {
+	mailbox, err := u.User.MailboxByName(name)
+	if err != nil || name != "Trash" {
+		return mailbox, err
+	}
+	return fillingMailbox{mailbox, u.saves, u.limit}, nil
+}
+
+type fillingMailbox struct {
+	mailstore.Mailbox
+	saves *int
+	limit int
+}
+
+func (m fillingMailbox) NewMessage() mailstore.Message {
+	return fillingMessage{m.Mailbox.NewMessage(), m.saves, m.limit}
+}
+
+func (m fillingMailbox) Expunge(uids []uint32) error {
+	return m.Mailbox.(mailstore.ExpungeMailbox).Expunge(uids)
+}
+
+type fillingMessage struct {
+	mailstore.Message
+	saves *int
+	limit int
+}
+
+func (m fillingMessage) SetHeaders(header textproto.MIMEHeader) mailstore.Message {
+	return fillingMessage{m.Message.SetHeaders(header), m.saves, m.limit}
+}
+
+func (m fillingMessage) SetBody(body string) mailstore.Message {
+	return fillingMessage{m.Message.SetBody(body), m.saves, m.limit}
+}
+
+func (m fillingMessage) SetFlagsContext(ctx context.Context, flags types.Flags) (mailstore.Message, error) {
+	return fillingMessage{m.Message.OverwriteFlags(flags), m.saves, m.limit}, nil
+}
+
+func (m fillingMessage) SaveContext(ctx context.Context) (mailstore.Message, error) {
+	if *m.saves == m.limit {
+		return nil, errors.New("Disk full")
+	}
+	*m.saves++
+	return m.Message.Save()
+}
+
+var _ = Describe("COPY Command", func() {
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		})
+
+		It("should copy messages with their flags and dates", func() {
+			tConn.SelectedMailbox.MessageBySequenceNumber(2).AddFlags(types.FlagSeen).Save()
+
+			SendLine("abcd.123 COPY 1:2 Trash")
+			ExpectResponse("abcd.123 OK COPY completed")
+
+			trash, _ := mStore.User.MailboxByName("Trash")
+			Expect(trash.Messages()).To(Equal(uint32(2)))
+			original := tConn.SelectedMailbox.MessageBySequenceNumber(2)
+			copied := trash.MessageBySequenceNumber(2)
+			Expect(copied.Header().Get("Subject")).To(Equal("Another test email"))
+			Expect(copied.Flags()).To(Equal(types.FlagSeen | types.FlagRecent))
+			Expect(copied.InternalDate()).To(Equal(original.InternalDate()))
+		})
+
+		It("should announce copies into the selected mailbox", func() {
+			SendLine("abcd.123 UID COPY 12 \"INBOX\"")
+			ExpectResponse("* 4 EXISTS")
+			ExpectResponse("* 4 RECENT")
+			ExpectResponse("abcd.123 OK UID COPY completed")
+		})
+
+		It("should ask the client to create a missing destination", func() {
+			SendLine("abcd.123 COPY 1 Archive")
+			ExpectResponse("abcd.123 NO [TRYCREATE] Destination mailbox does not exist")
+		})
+
+		It("should remove the copies already made if one can't be saved", func() {
+			saves := 0
+			tConn.User = fillingUser{mStore.User, &saves, 2}
+
+			SendLine("abcd.123 COPY 1:3 Trash")
+			ExpectResponse("abcd.123 NO [SERVERBUG] Internal server error")
+			Expect(saves).To(Equal(2))
+
+			trash, _ := mStore.User.MailboxByName("Trash")
+			Expect(trash.Messages()).To(Equal(uint32(0)))
+		})
+
+		It("should let the mailstore copy messages itself", func() {
+			var copied []uint32
+			tConn.SelectedMailbox = copyingMailbox{tConn.SelectedMailbox.(mailstore.DummyMailbox), &copied}
+
+			SendLine("abcd.123 COPY 2:* Trash")
+			ExpectResponse("abcd.123 OK COPY completed")
+			Expect(copied).To(Equal([]uint32{11, 12}))
+		})
+	})
+
+	Context("When no mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 COPY 1 Trash")
+			ExpectResponse("abcd.123 BAD not selected")
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/util"
+)
+
+const createArgMailbox int = 0
+
+func cmdCreate(args commandArgs, c *Conn) {
+	// A trailing delimiter only declares that the client intends to create
+	// mailboxes beneath this one (RFC 3501 section 6.3.3)
+	name, ok := c.mailboxArg(args, createArgMailbox)
+	if !ok {
+		return
+	}
+	if c.delimiter() != "" {
+		name = strings.TrimSuffix(name, c.delimiter())
+	}
+	if name == "" || util.MailboxNamesEqual(name, "INBOX") {
+		c.writeNo(args.ID(), codeAlreadyExists, c.text(MsgMailboxExists))
+		return
+	}
+	if _, err := mailstore.MailboxByName(c.context(), c.User, name); err == nil {
+		c.writeNo(args.ID(), codeAlreadyExists, c.text(MsgMailboxExists))
+		return
+	}
+
+	creator, ok := c.User.(mailstore.MailboxCreator)
+	if !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgCannotCreate))
+		return
+	}
+
+	if err := createParents(c.context(), c.User, creator, name, c.delimiter()); err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	if _, err := mailstore.CreateMailbox(c.context(), creator, name); errors.Is(err, mailstore.ErrMailboxExists) {
+		c.writeNo(args.ID(), codeAlreadyExists, c.text(MsgMailboxExists))
+		return
+	} else if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "CREATE"))
+}
+
+// Create any levels of the hierarchy above the named mailbox which don't
+// exist yet
+func createParents(ctx context.Context, user mailstore.User, creator mailstore.MailboxCreator, name string, delimiter string) error {
+	for _, parent := range mailstore.ParentNames(name, delimiter) {
+		if _, err := mailstore.MailboxByName(ctx, user, parent); err == nil {
+			continue
+		}
+		if _, err := mailstore.CreateMailbox(ctx, creator, parent); err != nil && !errors.Is(err, mailstore.ErrMailboxExists) {
+			return err
+		}
+	}
+	return nil
+}
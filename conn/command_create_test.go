@@ -0,0 +1,111 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A user whose mailstore can't create mailboxes
+type fixedMailboxesUser struct {
+	mailstore.User
+}
+
+var _ = Describe("CREATE Command", func() {
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should create a mailbox", func() {
+			SendLine("abcd.123 CREATE Archive")
+			ExpectResponse("abcd.123 OK CREATE completed")
+
+			mailbox, err := mStore.User.MailboxByName("Archive")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mailbox.Messages()).To(BeEquivalentTo(0))
+		})
+
+		It("should create a mailbox given as a quoted string", func() {
+			SendLine("abcd.123 CREATE \"Tom \\\\ Jerry\"")
+			ExpectResponse("abcd.123 OK CREATE completed")
+
+			_, err := mStore.User.MailboxByName("Tom \\ Jerry")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should create missing levels of the hierarchy", func() {
+			SendLine("abcd.123 CREATE \"Archive/2015/June/\"")
+			ExpectResponse("abcd.123 OK CREATE completed")
+
+			for _, name := range []string{"Archive", "Archive/2015", "Archive/2015/June"} {
+				_, err := mStore.User.MailboxByName(name)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(mStore.User.Mailboxes()).To(HaveLen(6))
+		})
+
+		It("should create missing levels split by the mailstore's delimiter", func() {
+			mStore.Delimiter = "."
+			tConn.Mailstore = mStore
+			SendLine("abcd.123 CREATE Archive.2015.")
+			ExpectResponse("abcd.123 OK CREATE completed")
+
+			for _, name := range []string{"Archive", "Archive.2015"} {
+				_, err := mStore.User.MailboxByName(name)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(mStore.User.Mailboxes()).To(HaveLen(5))
+		})
+
+		It("should create a mailbox beneath an existing one", func() {
+			SendLine("abcd.123 CREATE Trash/Old")
+			ExpectResponse("abcd.123 OK CREATE completed")
+			Expect(mStore.User.Mailboxes()).To(HaveLen(4))
+		})
+
+		It("should decode mailbox names from modified UTF-7", func() {
+			SendLine("abcd.123 CREATE \"Entw&APw-rfe\"")
+			ExpectResponse("abcd.123 OK CREATE completed")
+
+			_, err := mStore.User.MailboxByName("Entw\u00fcrfe")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should reject mailbox names which aren't modified UTF-7", func() {
+			SendLine("abcd.123 CREATE \"Entw&APw\"")
+			ExpectResponse("abcd.123 BAD Mailbox name is not valid modified UTF-7")
+			Expect(mStore.User.Mailboxes()).To(HaveLen(3))
+		})
+
+		It("should refuse to create INBOX", func() {
+			SendLine("abcd.123 CREATE inbox")
+			ExpectResponse("abcd.123 NO [ALREADYEXISTS] Mailbox already exists")
+		})
+
+		It("should refuse to create a mailbox which exists", func() {
+			SendLine("abcd.123 CREATE Sent")
+			ExpectResponse("abcd.123 NO [ALREADYEXISTS] Mailbox already exists")
+			Expect(mStore.User.Mailboxes()).To(HaveLen(3))
+		})
+
+		It("should refuse users who can't create mailboxes", func() {
+			tConn.User = fixedMailboxesUser{mStore.User}
+			SendLine("abcd.123 CREATE Archive")
+			ExpectResponse("abcd.123 NO [CANNOT] Mailboxes cannot be created")
+		})
+	})
+
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 CREATE Archive")
+			ExpectResponse("abcd.123 BAD not authenticated")
+		})
+	})
+})
@@ -0,0 +1,56 @@
+package conn
+
+import (
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/util"
+)
+
+const deleteArgMailbox int = 0
+
+func cmdDelete(args commandArgs, c *Conn) {
+	name, ok := c.mailboxArg(args, deleteArgMailbox)
+	if !ok {
+		return
+	}
+	if util.MailboxNamesEqual(name, "INBOX") {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgCannotDeleteInbox))
+		return
+	}
+	mailbox, err := mailstore.MailboxByName(c.context(), c.User, name)
+	if err != nil {
+		c.writeNo(args.ID(), codeNonexistent, c.text(MsgNoSuchMailbox))
+		return
+	}
+
+	deleter, ok := c.User.(mailstore.MailboxDeleter)
+	if !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgCannotDelete))
+		return
+	}
+
+	// A mailbox with children loses its messages but keeps its name, so the
+	// hierarchy beneath it is left intact (RFC 3501 section 6.3.4)
+	inferiors, err := mailstore.Inferiors(c.context(), c.User, mailbox.Name(), c.delimiter())
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	children := len(inferiors) > 0
+	if children && isNoselect(mailbox) {
+		c.writeNo(args.ID(), codeHasChildren, c.text(MsgHasChildren))
+		return
+	}
+	if err := mailstore.DeleteMailbox(c.context(), deleter, mailbox.Name(), children); err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.audit(AuditEvent{Action: "DELETE", Username: c.username, Mailbox: mailbox.Name()})
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "DELETE"))
+}
+
+// Whether the mailbox is a placeholder in the hierarchy which can't be
+// selected
+func isNoselect(m mailstore.Mailbox) bool {
+	noselect, ok := m.(mailstore.NoselectMailbox)
+	return ok && noselect.Noselect()
+}
@@ -0,0 +1,94 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DELETE Command", func() {
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should delete a mailbox", func() {
+			SendLine("abcd.123 DELETE Trash")
+			ExpectResponse("abcd.123 OK DELETE completed")
+
+			_, err := mStore.User.MailboxByName("Trash")
+			Expect(err).To(HaveOccurred())
+			Expect(mStore.User.Mailboxes()).To(HaveLen(2))
+		})
+
+		It("should keep a mailbox with children as \\Noselect", func() {
+			mStore.User.CreateMailbox("Trash/Old")
+
+			SendLine("abcd.123 DELETE Trash")
+			ExpectResponse("abcd.123 OK DELETE completed")
+
+			mailbox, err := mStore.User.MailboxByName("Trash")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mailbox.(mailstore.NoselectMailbox).Noselect()).To(BeTrue())
+
+			SendLine("abcd.124 LIST \"\" *")
+			ExpectResponse("* LIST () \"/\" \"INBOX\"")
+			ExpectResponse("* LIST (\\Noselect) \"/\" \"Trash\"")
+			ExpectResponse("* LIST () \"/\" \"Sent\"")
+			ExpectResponse("* LIST () \"/\" \"Trash/Old\"")
+			ExpectResponse("abcd.124 OK LIST completed")
+
+			SendLine("abcd.125 SELECT Trash")
+			ExpectResponse("abcd.125 NO [CANNOT] Mailbox cannot be selected")
+
+			SendLine("abcd.126 DELETE Trash")
+			ExpectResponse("abcd.126 NO [HASCHILDREN] Mailbox has children")
+		})
+
+		It("should empty a mailbox with children", func() {
+			mStore.User.CreateMailbox("INBOX/Old")
+			mStore.User.CreateMailbox("INBOX/Old/Older")
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			message := inbox.MessageBySequenceNumber(1)
+			old, _ := mStore.User.MailboxByName("INBOX/Old")
+			old.NewMessage().SetHeaders(message.Header()).SetBody(message.Body()).Save()
+			old, _ = mStore.User.MailboxByName("INBOX/Old")
+			Expect(old.Messages()).To(BeEquivalentTo(1))
+
+			SendLine("abcd.123 DELETE INBOX/Old")
+			ExpectResponse("abcd.123 OK DELETE completed")
+
+			old, _ = mStore.User.MailboxByName("INBOX/Old")
+			Expect(old.Messages()).To(BeEquivalentTo(0))
+		})
+
+		It("should refuse to delete INBOX", func() {
+			SendLine("abcd.123 DELETE INBOX")
+			ExpectResponse("abcd.123 NO [CANNOT] INBOX cannot be deleted")
+		})
+
+		It("should refuse to delete a mailbox which doesn't exist", func() {
+			SendLine("abcd.123 DELETE Archive")
+			ExpectResponse("abcd.123 NO [NONEXISTENT] Mailbox does not exist")
+		})
+
+		It("should refuse users who can't delete mailboxes", func() {
+			tConn.User = fixedMailboxesUser{mStore.User}
+			SendLine("abcd.123 DELETE Trash")
+			ExpectResponse("abcd.123 NO [CANNOT] Mailboxes cannot be deleted")
+		})
+	})
+
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 DELETE Trash")
+			ExpectResponse("abcd.123 BAD not authenticated")
+		})
+	})
+})
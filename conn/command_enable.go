@@ -0,0 +1,40 @@
+package conn
+
+import (
+	"strings"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// Handles ENABLE (RFC 5161), which turns on extensions that change how the
+// server behaves. Extensions which aren't supported are ignored, and those
+// enabled are listed in an untagged ENABLED response.
+func cmdEnable(args commandArgs, c *Conn) {
+	enabled := make([]string, 0)
+	for _, capability := range strings.Fields(args.Arg(0)) {
+		switch {
+		case strings.EqualFold(capability, "UTF8=ACCEPT") && c.UTF8Accept:
+			c.utf8Enabled = true
+			enabled = append(enabled, "UTF8=ACCEPT")
+		case strings.EqualFold(capability, "CONDSTORE") && c.QResync:
+			c.condStoreEnabled = true
+			enabled = append(enabled, "CONDSTORE")
+		case strings.EqualFold(capability, "QRESYNC") && c.QResync:
+			// QRESYNC implies CONDSTORE (RFC 7162 section 3.2.3)
+			c.condStoreEnabled = true
+			c.qresyncEnabled = true
+			enabled = append(enabled, "QRESYNC")
+		}
+	}
+	c.writeResponse("", strings.TrimSpace("ENABLED "+strings.Join(enabled, " ")))
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "ENABLE"))
+}
+
+// Format a mailbox name to be sent to the client, in UTF-8 if the client has
+// enabled UTF8=ACCEPT and otherwise in modified UTF-7
+func (c *Conn) encodeMailboxName(name string) string {
+	if c.utf8Enabled {
+		return name
+	}
+	return util.EncodeMailboxName(name)
+}
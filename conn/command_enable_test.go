@@ -0,0 +1,98 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("ENABLE Command", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateAuthenticated)
+		tConn.User = mStore.User
+	})
+
+	It("should ignore extensions which aren't offered", func() {
+		SendLine("abcd.123 ENABLE UTF8=ACCEPT CONDSTORE")
+		ExpectResponse("* ENABLED")
+		ExpectResponse("abcd.123 OK ENABLE completed")
+	})
+
+	Context("When UTF8=ACCEPT is offered", func() {
+		BeforeEach(func() {
+			tConn.UTF8Accept = true
+		})
+
+		It("should advertise it", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH URLAUTH ANNOTATE-EXPERIMENT-1 ENABLE UTF8=ACCEPT")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should enable it", func() {
+			SendLine("abcd.123 ENABLE utf8=accept")
+			ExpectResponse("* ENABLED UTF8=ACCEPT")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+		})
+
+		It("should refuse 8-bit text outside a literal until enabled", func() {
+			SendLine("abcd.123 CREATE \"Entwürfe\"")
+			ExpectResponse("abcd.123 BAD Command contains 8-bit characters, which must be sent as a literal")
+		})
+
+		It("should accept 8-bit text outside a literal once enabled", func() {
+			SendLine("abcd.123 ENABLE UTF8=ACCEPT")
+			ExpectResponse("* ENABLED UTF8=ACCEPT")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+			SendLine("abcd.124 CREATE \"Entwürfe\"")
+			ExpectResponse("abcd.124 OK CREATE completed")
+			SendLine("abcd.125 LIST \"\" Entw*")
+			ExpectResponse("* LIST () \"/\" \"Entwürfe\"")
+			ExpectResponse("abcd.125 OK LIST completed")
+		})
+
+		It("should send mailbox names in UTF-8 once enabled", func() {
+			SendLine("abcd.123 ENABLE UTF8=ACCEPT")
+			ExpectResponse("* ENABLED UTF8=ACCEPT")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+			SendLine("abcd.124 CREATE {9}")
+			ExpectResponse("+ Ready for additional command text")
+			SendLine("Entwürfe")
+			ExpectResponse("abcd.124 OK CREATE completed")
+			SendLine("abcd.125 STATUS {9}")
+			ExpectResponse("+ Ready for additional command text")
+			SendLine("Entwürfe (MESSAGES)")
+			ExpectResponse("* STATUS \"Entwürfe\" (MESSAGES 0)")
+			ExpectResponse("abcd.125 OK STATUS completed")
+		})
+	})
+
+	Context("When CONDSTORE and QRESYNC are offered", func() {
+		BeforeEach(func() {
+			tConn.QResync = true
+		})
+
+		It("should advertise them", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH URLAUTH ANNOTATE-EXPERIMENT-1 ENABLE CONDSTORE QRESYNC")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should enable them", func() {
+			SendLine("abcd.123 ENABLE UTF8=ACCEPT CONDSTORE QRESYNC")
+			ExpectResponse("* ENABLED CONDSTORE QRESYNC")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+		})
+	})
+
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		})
+
+		It("should not be allowed", func() {
+			SendLine("abcd.123 ENABLE UTF8=ACCEPT")
+			ExpectResponse("abcd.123 BAD Not allowed once a mailbox has been selected")
+		})
+	})
+})
@@ -0,0 +1,146 @@
+package conn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	multiSearchArgIn       int = 0
+	multiSearchArgReturn   int = 1
+	multiSearchArgCharset  int = 2
+	multiSearchArgCriteria int = 3
+)
+
+// Handles ESEARCH (RFC 7377), which searches several of the user's
+// mailboxes at once. The UIDs found in each mailbox are returned in an
+// ESEARCH response naming the mailbox; mailboxes without any are left out.
+func cmdMultiSearch(args commandArgs, c *Conn) {
+	req, err := multiSearchRequest(args)
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+	ret := types.SearchReturn{All: true}
+	if req.Return != nil {
+		ret = *req.Return
+	}
+	switch {
+	case ret.Update || ret.Partial != nil:
+		c.writeBad(args.ID(), codeNone, c.text(MsgMultiSearchUpdate))
+		return
+	case usesKey(req.Criteria, "SEQUENCE"):
+		c.writeBad(args.ID(), codeNone, c.text(MsgMultiSearchSequence))
+		return
+	case ret.Relevancy && !usesKey(req.Criteria, "FUZZY"):
+		c.writeBad(args.ID(), codeNone, c.text(MsgRelevancyNeedsFuzzy))
+		return
+	}
+
+	mailboxes, err := c.filteredMailboxes(req.Sources)
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	for _, mailbox := range mailboxes {
+		msgs, ok := c.search(args.ID(), mailbox, req.Charset, req.Criteria, args.Arg(multiSearchArgCriteria))
+		if !ok {
+			return
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		var relevancy []int
+		if ret.Relevancy {
+			if relevancy, err = c.relevancy(mailbox, req.Criteria, msgs); err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+		}
+		modseq, err := searchModSeq(mailbox, req.Criteria, msgs)
+		if err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
+		correlator := fmt.Sprintf("%s MAILBOX %s UIDVALIDITY %d",
+			tagCorrelator(args.ID()), c.mailboxString(mailbox.Name()), uidValidity)
+		c.writeESearch(correlator, true, ret, c.resultIDs(msgs, true), relevancy, modseq)
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "ESEARCH"))
+}
+
+// Find the user's mailboxes picked out by any of the filters, in the order
+// the mailstore lists them. The selected mailbox is searched as the session
+// sees it.
+func (c *Conn) filteredMailboxes(filters []types.MailboxFilter) ([]mailstore.Mailbox, error) {
+	all, err := mailstore.Mailboxes(c.context(), c.User)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := make(map[string]bool)
+	for _, filter := range filters {
+		if filter.Kind != "subscribed" {
+			continue
+		}
+		names, err := subscriptions(c.context(), c.User)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			subscribed[name] = true
+		}
+		break
+	}
+
+	selected := ""
+	if c.SelectedMailbox != nil {
+		selected = c.SelectedMailbox.Name()
+	}
+	delimiter := c.delimiter()
+	var found []mailstore.Mailbox
+	for _, mailbox := range all {
+		name := mailbox.Name()
+		for _, filter := range filters {
+			if !mailboxFilterMatches(filter, name, selected, subscribed, delimiter) {
+				continue
+			}
+			if name == selected {
+				mailbox = c.SelectedMailbox
+			}
+			found = append(found, mailbox)
+			break
+		}
+	}
+	return found, nil
+}
+
+// Whether a mailbox is picked out by a filter given to ESEARCH IN
+func mailboxFilterMatches(filter types.MailboxFilter, name string, selected string, subscribed map[string]bool, delimiter string) bool {
+	switch filter.Kind {
+	case "selected", "selected-delayed":
+		return name == selected
+	case "inboxes":
+		return strings.EqualFold(name, "INBOX")
+	case "personal":
+		return true
+	case "subscribed":
+		return subscribed[name]
+	}
+
+	for _, root := range filter.Mailboxes {
+		if name == root {
+			return true
+		}
+		if !strings.HasPrefix(name, root+delimiter) {
+			continue
+		}
+		below := name[len(root)+len(delimiter):]
+		if filter.Kind == "subtree" || (filter.Kind == "subtree-one" && !strings.Contains(below, delimiter)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("ESEARCH Command", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateAuthenticated)
+		tConn.User = mStore.User
+
+		mStore.User.CreateMailbox("Archive")
+		mStore.User.CreateMailbox("Archive/2014")
+		mStore.User.CreateMailbox("Archive/2014/October")
+		inbox, _ := mStore.User.MailboxByName("INBOX")
+		message := inbox.MessageBySequenceNumber(2)
+		for _, name := range []string{"Archive/2014", "Archive/2014/October"} {
+			archive, _ := mStore.User.MailboxByName(name)
+			archive.NewMessage().SetHeaders(message.Header()).SetBody(message.Body()).Save()
+		}
+	})
+
+	It("should search every personal mailbox", func() {
+		SendLine("abcd.123 ESEARCH IN (personal) SUBJECT another")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX INBOX UIDVALIDITY 250) UID ALL 11")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014 UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014/October UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("abcd.123 OK ESEARCH completed")
+	})
+
+	It("should search the inboxes", func() {
+		SendLine("abcd.123 ESEARCH IN (inboxes) RETURN (MIN MAX COUNT) ALL")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX INBOX UIDVALIDITY 250) UID MIN 10 MAX 12 COUNT 3")
+		ExpectResponse("abcd.123 OK ESEARCH completed")
+	})
+
+	It("should search a subtree", func() {
+		SendLine("abcd.123 ESEARCH IN (subtree Archive) ALL")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014 UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014/October UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("abcd.123 OK ESEARCH completed")
+	})
+
+	It("should search one level of a subtree", func() {
+		SendLine("abcd.123 ESEARCH IN (subtree-one (Archive/2014)) ALL")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014 UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014/October UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("abcd.123 OK ESEARCH completed")
+
+		SendLine("abcd.124 ESEARCH IN (subtree-one Archive) ALL")
+		ExpectResponse("* ESEARCH (TAG \"abcd.124\" MAILBOX Archive/2014 UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("abcd.124 OK ESEARCH completed")
+	})
+
+	It("should search named mailboxes", func() {
+		SendLine("abcd.123 ESEARCH IN (mailboxes (Trash \"Archive/2014\") mailboxes INBOX) SUBJECT email")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX INBOX UIDVALIDITY 250) UID ALL 10:12")
+		ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX Archive/2014 UIDVALIDITY 250) UID ALL 10")
+		ExpectResponse("abcd.123 OK ESEARCH completed")
+	})
+
+	It("should find nothing without a selected mailbox", func() {
+		SendLine("abcd.123 ESEARCH ALL")
+		ExpectResponse("abcd.123 OK ESEARCH completed")
+	})
+
+	It("should refuse unknown mailbox filters", func() {
+		SendLine("abcd.123 ESEARCH IN (everything) ALL")
+		ExpectResponse("abcd.123 BAD Unknown mailbox filter everything")
+	})
+
+	It("should refuse sequence numbers", func() {
+		SendLine("abcd.123 ESEARCH IN (personal) 1:2")
+		ExpectResponse("abcd.123 BAD Sequence numbers can't be used to search several mailboxes")
+	})
+
+	It("should refuse to keep results up to date", func() {
+		SendLine("abcd.123 ESEARCH IN (personal) RETURN (UPDATE) ALL")
+		ExpectResponse("abcd.123 BAD UPDATE and PARTIAL can't be used to search several mailboxes")
+	})
+
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should search the selected mailbox by default", func() {
+			SendLine("abcd.123 ESEARCH SUBJECT last")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\" MAILBOX INBOX UIDVALIDITY 250) UID ALL 12")
+			ExpectResponse("abcd.123 OK ESEARCH completed")
+		})
+	})
+})
@@ -1,14 +1,17 @@
 package conn
 
-import "fmt"
+import "github.com/jordwest/imap-server/util"
 
+// Select a mailbox without allowing any changes to it, including clearing
+// the \Recent flag of its messages
 func cmdExamine(args commandArgs, c *Conn) {
-	m, err := c.User.MailboxByName(args.Arg(0))
-	if err != nil {
-		fmt.Fprintf(c, "%s NO %s\r\n", args.ID(), err)
-		return
-	}
+	req, err := selectRequest(args, true)
+	selectMailbox(args, c, req, err)
+}
 
-	writeMailboxInfo(c, m)
-	c.writeResponse(args.ID(), "OK [READ-ONLY] EXAMINE completed")
+// Whether the named mailbox is the one selected with EXAMINE, and so mustn't
+// be changed by this session
+func (c *Conn) examining(name string) bool {
+	return c.state == StateSelected && c.mailboxWritable == ReadOnly &&
+		util.MailboxNamesEqual(c.SelectedMailbox.Name(), name)
 }
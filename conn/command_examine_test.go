@@ -3,6 +3,7 @@ package conn_test
 import (
 	"github.com/jordwest/imap-server/conn"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("EXAMINE Command", func() {
@@ -12,7 +13,58 @@ var _ = Describe("EXAMINE Command", func() {
 			tConn.User = mStore.User
 		})
 
-		PIt("should (implement test)", func() {
+		It("should select the mailbox read-only", func() {
+			SendLine("abcd.123 EXAMINE INBOX")
+			ExpectResponse("* 3 EXISTS")
+			ExpectResponse("* 3 RECENT")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS ()] No permanent flags permitted")
+			ExpectResponse("abcd.123 OK [READ-ONLY] EXAMINE completed")
+			Expect(tConn.SelectedMailbox.Name()).To(Equal("INBOX"))
+		})
+
+		It("should refuse changes to the mailbox", func() {
+			SendLine("abcd.123 EXAMINE INBOX")
+			for i := 0; i < 7; i++ {
+				reader.ReadLine()
+			}
+			ExpectResponse("abcd.123 OK [READ-ONLY] EXAMINE completed")
+
+			SendLine("abcd.124 STORE 1 +FLAGS (\\Seen)")
+			ExpectResponse("abcd.124 NO Selected mailbox is READONLY")
+			SendLine("abcd.125 EXPUNGE")
+			ExpectResponse("abcd.125 NO Selected mailbox is READONLY")
+			SendLine("abcd.126 APPEND INBOX {13}")
+			ExpectResponse("abcd.126 NO Selected mailbox is READONLY")
+			SendLine("abcd.127 COPY 1 INBOX")
+			ExpectResponse("abcd.127 NO Selected mailbox is READONLY")
+
+			// Fetching mustn't clear \Recent either
+			SendLine("abcd.128 FETCH 1 (FLAGS)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("abcd.128 OK FETCH completed")
+			SendLine("abcd.129 FETCH 1 (FLAGS)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("abcd.129 OK FETCH completed")
+		})
+
+		It("should still allow other mailboxes to be changed", func() {
+			SendLine("abcd.123 EXAMINE INBOX")
+			for i := 0; i < 7; i++ {
+				reader.ReadLine()
+			}
+			ExpectResponse("abcd.123 OK [READ-ONLY] EXAMINE completed")
+
+			SendLine("abcd.124 COPY 1 Trash")
+			ExpectResponse("abcd.124 OK COPY completed")
+		})
+
+		It("should refuse a mailbox which doesn't exist", func() {
+			SendLine("abcd.123 EXAMINE Archive")
+			ExpectResponse("abcd.123 NO [NONEXISTENT] Invalid mailbox")
 		})
 	})
 
@@ -21,7 +73,9 @@ var _ = Describe("EXAMINE Command", func() {
 			tConn.SetState(conn.StateNotAuthenticated)
 		})
 
-		PIt("should give an error", func() {
+		It("should give an error", func() {
+			SendLine("abcd.123 EXAMINE INBOX")
+			ExpectResponse("abcd.123 BAD not authenticated")
 		})
 	})
 })
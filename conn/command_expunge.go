@@ -0,0 +1,84 @@
+package conn
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// errCannotExpunge is returned when the selected mailbox doesn't support
+// removing messages
+var errCannotExpunge = errors.New("Messages cannot be expunged from this mailbox")
+
+// Permanently remove the messages flagged \Deleted from the selected
+// mailbox
+func cmdExpunge(args commandArgs, c *Conn) {
+	if !c.assertWritable(args.ID()) {
+		return
+	}
+
+	expunged, err := c.expungeDeleted()
+	if err == errCannotExpunge {
+		c.writeNo(args.ID(), codeCannot, err.Error())
+		return
+	}
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	// Work down from the highest sequence number, so that each response
+	// refers to the numbering left by the responses before it. Expunges made
+	// within a transaction might yet be rolled back, so they're reported
+	// once it's committed.
+	if c.transaction == nil {
+		for i := len(expunged) - 1; i >= 0; i-- {
+			c.writeExpunge(expunged[i])
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "EXPUNGE"))
+}
+
+// Expunge the messages flagged \Deleted in the selected mailbox, returning
+// the sequence numbers the client knows them by in ascending order. The
+// client's numbering is only updated as EXPUNGE responses are sent.
+func (c *Conn) expungeDeleted() ([]uint32, error) {
+	if _, ok := underlyingMailbox(c.SelectedMailbox).(mailstore.ExpungeMailbox); !ok {
+		return nil, errCannotExpunge
+	}
+	mailbox := c.SelectedMailbox.(mailstore.ExpungeMailbox)
+
+	// The mailbox is read once, and each message numbered as the client
+	// knows it. A message the client hasn't been told of is expunged without
+	// being reported.
+	all, _ := types.InterpretSequenceSet("1:*")
+	msgs, err := mailstore.MessageSetBySequenceNumber(c.context(), c.SelectedMailbox, all)
+	if err != nil {
+		return nil, err
+	}
+	var seqnos, uids []uint32
+	for _, msg := range msgs {
+		if !msg.Flags().HasFlags(types.FlagDeleted) {
+			continue
+		}
+		uids = append(uids, msg.UID())
+		if !c.viewCurrent() {
+			seqnos = append(seqnos, msg.SequenceNumber())
+		} else if i := c.viewIndex(msg.UID()); i != -1 {
+			seqnos = append(seqnos, uint32(i+1))
+		}
+	}
+	sort.Slice(seqnos, func(i, j int) bool { return seqnos[i] < seqnos[j] })
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	if err := mailstore.Expunge(c.context(), mailbox, uids); err != nil {
+		return nil, err
+	}
+	c.reloadSelectedMailbox()
+	c.announceChanges(c.SelectedMailbox.Name())
+	return seqnos, nil
+}
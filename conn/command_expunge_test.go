@@ -0,0 +1,49 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EXPUNGE Command", func() {
+	Context("When a mailbox is selected for writing", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		})
+
+		It("should expunge deleted messages, highest first", func() {
+			tConn.SelectedMailbox.MessageBySequenceNumber(1).AddFlags(types.FlagDeleted).Save()
+			tConn.SelectedMailbox.MessageBySequenceNumber(3).AddFlags(types.FlagDeleted).Save()
+
+			SendLine("abcd.123 EXPUNGE")
+			ExpectResponse("* 3 EXPUNGE")
+			ExpectResponse("* 1 EXPUNGE")
+			ExpectResponse("abcd.123 OK EXPUNGE completed")
+			Expect(tConn.SelectedMailbox.Messages()).To(Equal(uint32(1)))
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).UID()).To(Equal(uint32(11)))
+		})
+
+		It("should succeed when nothing is deleted", func() {
+			SendLine("abcd.123 EXPUNGE")
+			ExpectResponse("abcd.123 OK EXPUNGE completed")
+		})
+	})
+
+	Context("When a mailbox is selected read-only", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 EXPUNGE")
+			ExpectResponse("abcd.123 NO Selected mailbox is READONLY")
+		})
+	})
+})
@@ -1,9 +1,10 @@
 package conn
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"net/textproto"
+	"mime"
 	"regexp"
 	"strings"
 
@@ -18,103 +19,166 @@ const (
 	fetchArgParams int = 2
 )
 
-var registeredFetchParams []fetchParamDefinition
-var peekRE *regexp.Regexp
+// How a data item may be written
+const (
+	fetchPlain   int = iota // A name alone, eg FLAGS
+	fetchSection            // A name which may have a section, eg BODY or BODY[TEXT]
+	fetchList               // A name followed by a list, eg ANNOTATION (/comment value)
+)
+
+type fetchAttributeDefinition struct {
+	form    int
+	handler func(types.FetchAttribute, *Conn, mailstore.Message) (string, error)
+}
+
+var registeredFetchAttributes = make(map[string]fetchAttributeDefinition)
+
+// The name and literal length at the start of a section's response, eg
+// "BODY[TEXT] {26}\r\n" or "BINARY[1] ~{11}\r\n"
+var literalResponseRE = regexp.MustCompile(`^(\S+(?: \([^)]*\)\])?) (~?)\{[0-9]+\}\r\n`)
 
 // ErrUnrecognisedParameter indicates that the parameter requested in a FETCH
 // command is unrecognised or not implemented in this IMAP server
 var ErrUnrecognisedParameter = errors.New("Unrecognised Parameter")
 
-type fetchParamDefinition struct {
-	re      *regexp.Regexp
-	handler func([]string, *Conn, mailstore.Message, bool) string
-}
-
-// Register all supported fetch parameters
+// Register all supported fetch attributes
 func init() {
-	peekRE = regexp.MustCompile("\\.PEEK")
-	registeredFetchParams = make([]fetchParamDefinition, 0)
-	registerFetchParam("UID", fetchUID)
-	registerFetchParam("FLAGS", fetchFlags)
-	registerFetchParam("RFC822\\.SIZE", fetchRfcSize)
-	registerFetchParam("INTERNALDATE", fetchInternalDate)
-	registerFetchParam("BODY(?:\\.PEEK)?\\[HEADER\\]", fetchHeaders)
-	registerFetchParam("BODY(?:\\.PEEK)?"+
-		"\\[HEADER\\.FIELDS \\(([A-z\\s-]+)\\)\\]", fetchHeaderSpecificFields)
-	registerFetchParam("BODY(?:\\.PEEK)?\\[TEXT\\]", fetchBody)
-	registerFetchParam("BODY(?:\\.PEEK)?\\[\\]", fetchFullText)
+	registerFetchAttribute("ANNOTATION", fetchList, fetchAnnotation)
+	registerFetchAttribute("UID", fetchPlain, fetchUID)
+	registerFetchAttribute("FLAGS", fetchPlain, fetchFlags)
+	registerFetchAttribute("RFC822.SIZE", fetchPlain, fetchRfcSize)
+	registerFetchAttribute("RFC822", fetchPlain, fetchRFC822)
+	registerFetchAttribute("RFC822.HEADER", fetchPlain, fetchRFC822)
+	registerFetchAttribute("RFC822.TEXT", fetchPlain, fetchRFC822)
+	registerFetchAttribute("INTERNALDATE", fetchPlain, fetchInternalDate)
+	registerFetchAttribute("SAVEDATE", fetchPlain, fetchSaveDate)
+	registerFetchAttribute("MODSEQ", fetchPlain, fetchModSeq)
+	registerFetchAttribute("ENVELOPE", fetchPlain, fetchEnvelope)
+	registerFetchAttribute("BODYSTRUCTURE", fetchPlain, fetchBodyStructure)
+	registerFetchAttribute("BODY", fetchSection, fetchBody)
+	registerFetchAttribute("BINARY", fetchSection, fetchBinary)
+	registerFetchAttribute("BINARY.SIZE", fetchSection, fetchBinarySize)
 }
 
 func cmdFetch(args commandArgs, c *Conn) {
-	if !c.assertSelected(args.ID(), ReadOnly) {
+	req, err := fetchRequest(args)
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
 		return
 	}
 
 	// Fetch the messages
-	seqSet, err := types.InterpretSequenceSet(args.Arg(fetchArgRange))
+	var msgs []mailstore.Message
+	if req.UID {
+		msgs, err = mailstore.MessageSetByUID(c.context(), c.SelectedMailbox, req.UIDs)
+	} else {
+		msgs, err = c.messagesBySequenceSet(req.Set)
+	}
 	if err != nil {
-		c.writeResponse(args.ID(), "NO "+err.Error())
+		c.writeError(args.ID(), err)
 		return
 	}
 
-	searchByUID := strings.ToUpper(args.Arg(fetchArgUID)) == "UID "
-
-	var msgs []mailstore.Message
-	if searchByUID {
-		msgs = c.SelectedMailbox.MessageSetByUID(seqSet)
-	} else {
-		msgs = c.SelectedMailbox.MessageSetBySequenceNumber(seqSet)
+	// With CHANGEDSINCE, only messages changed since are fetched, along
+	// with their mod-sequences, and with VANISHED the client is told which
+	// of the others have been expunged since
+	if req.ChangedSince != 0 {
+		log, ok := c.enableCondStore(args.ID())
+		if !ok {
+			return
+		}
+		if req.Vanished && !c.qresyncEnabled {
+			c.writeBad(args.ID(), codeNone, c.text(MsgVanishedNeedsQResync))
+			return
+		}
+		if req.Vanished {
+			if err := c.writeVanishedEarlier(log, req.ChangedSince, req.UIDs); err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+		}
+		if msgs, err = changedSince(log, msgs, req.ChangedSince); err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
 	}
 
-	fetchParamString := args.Arg(fetchArgParams)
-	if searchByUID && !strings.Contains(fetchParamString, "UID") {
-		fetchParamString += " UID"
+	// X-UID-MAP is answered for all the messages at once. If nothing else
+	// was asked for, no FETCH responses are sent.
+	attrs, uidMap := splitUIDMap(req.Attributes)
+	if uidMap && c.capabilityDisabled(uidMapItem) {
+		c.writeBad(args.ID(), codeNone, c.text(MsgUnrecognisedParameter))
+		return
+	}
+	if req.UID && len(attrs) > 0 && !requestsItem(attrs, "UID") {
+		attrs = append(attrs, types.FetchAttribute{Name: "UID"})
+	}
+	if req.ChangedSince != 0 && len(attrs) > 0 && !requestsItem(attrs, "MODSEQ") {
+		attrs = append(attrs, types.FetchAttribute{Name: "MODSEQ"})
 	}
 
 	for _, msg := range msgs {
-		fetchParams, err := fetch(fetchParamString, c, msg)
+		seqno, known := c.sequenceNumber(msg)
+		if !known || len(attrs) == 0 {
+			continue
+		}
+		fetchParams, err := fetchAttributes(attrs, c, msg)
 		if err != nil {
 			if err == ErrUnrecognisedParameter {
-				c.writeResponse(args.ID(), "BAD Unrecognised Parameter")
+				c.writeBad(args.ID(), codeNone, c.text(MsgUnrecognisedParameter))
 				return
 			}
 
-			c.writeResponse(args.ID(), "BAD")
-			return
-		}
+			if err == types.ErrUnknownTransferEncoding {
+				c.writeNo(args.ID(), codeUnknownCTE, err.Error())
+				return
+			}
 
-		if c.mailboxWritable == ReadWrite {
-			msg = msg.RemoveFlags(types.FlagRecent)
-			msg, err = msg.Save()
-			if err != nil {
-				// TODO: this error is not fatal, but should still be logged
+			if err == types.ErrNoSuchPart {
+				c.writeError(args.ID(), err)
+				return
 			}
+
+			c.writeResponse(args.ID(), "BAD")
+			return
 		}
 
-		fullReply := fmt.Sprintf("%d FETCH (%s)",
-			msg.SequenceNumber(),
-			fetchParams)
+		fullReply := fmt.Sprintf("%d FETCH (%s)", seqno, fetchParams)
 
 		c.writeResponse("", fullReply)
 	}
 
-	if searchByUID {
-		c.writeResponse(args.ID(), "OK UID FETCH Completed")
+	if uidMap {
+		c.writeUIDMap(msgs)
+	}
+	if req.UID {
+		c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "UID FETCH"))
 	} else {
-		c.writeResponse(args.ID(), "OK FETCH Completed")
+		c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "FETCH"))
 	}
 }
 
 // Fetch requested params from a given message
 // eg fetch("UID BODY[TEXT] RFC822.SIZE", c, message)
 func fetch(params string, c *Conn, m mailstore.Message) (string, error) {
-	paramList := util.SplitParams(params)
+	items, err := util.ParseList(params)
+	if err != nil {
+		return "", err
+	}
+	attrs, err := types.ParseFetchAttributes(items)
+	if err != nil {
+		return "", err
+	}
+	return fetchAttributes(attrs, c, m)
+}
 
+// Fetch the data items parsed from a FETCH command from a given message
+func fetchAttributes(attrs []types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
 	// Prepare the list of responses
-	responseParams := make([]string, 0, len(paramList))
+	responseParams := make([]string, 0, len(attrs))
 
-	for _, param := range paramList {
-		paramResponse, err := fetchParam(param, c, m)
+	for _, attr := range attrs {
+		paramResponse, err := fetchAttribute(attr, c, m)
 		if err != nil {
 			return "", err
 		}
@@ -123,99 +187,276 @@ func fetch(params string, c *Conn, m mailstore.Message) (string, error) {
 	return strings.Join(responseParams, " "), nil
 }
 
-// Match a single fetch parameter and return the data
-func fetchParam(param string, c *Conn, m mailstore.Message) (string, error) {
-	peek := false
-	if peekRE.MatchString(param) {
-		peek = true
-	}
-	// Search through the parameter list until a parameter handler is found
-	for _, element := range registeredFetchParams {
-		if element.re.MatchString(param) {
-			return element.handler(element.re.FindStringSubmatch(param), c, m, peek), nil
+// Whether the data items of a FETCH command include the named item, eg UID
+func requestsItem(attrs []types.FetchAttribute, name string) bool {
+	for _, attr := range attrs {
+		if attr.Name == name && attr.Section == nil {
+			return true
 		}
 	}
-	return "", ErrUnrecognisedParameter
+	return false
+}
+
+// Fetch a single data item from a message
+func fetchAttribute(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	if item, ok := c.customFetchItem(attr); ok {
+		return fetchCustomItem(item, attr, m)
+	}
+
+	definition, ok := registeredFetchAttributes[attr.Name]
+	if !ok ||
+		(attr.Section != nil && definition.form != fetchSection) ||
+		((attr.Args != nil) != (definition.form == fetchList)) {
+		return "", ErrUnrecognisedParameter
+	}
+
+	// A partial fetch (eg BODY[]<0.1024>) is handled like a fetch of the
+	// whole section, which is then cut down
+	response, err := definition.handler(attr, c, m)
+	if err != nil || attr.Partial == nil {
+		return response, err
+	}
+	return partialResponse(response, uint64(attr.Partial.Origin), uint64(attr.Partial.Count))
 }
 
-func registerFetchParam(regex string, handler func([]string, *Conn, mailstore.Message, bool) string) {
-	newParam := fetchParamDefinition{
-		re:      regexp.MustCompile(regex),
-		handler: handler,
+// Cut the literal of a section's response down to count octets from origin,
+// and add the origin to the section name, eg BODY[TEXT]<100> {50}
+func partialResponse(response string, origin uint64, count uint64) (string, error) {
+	match := literalResponseRE.FindStringSubmatch(response)
+	if match == nil {
+		return "", ErrUnrecognisedParameter
+	}
+	data := response[len(match[0]):]
+
+	start := origin
+	if start > uint64(len(data)) {
+		start = uint64(len(data))
 	}
-	registeredFetchParams = append(registeredFetchParams, newParam)
+	end := start + count
+	if end > uint64(len(data)) {
+		end = uint64(len(data))
+	}
+	return fmt.Sprintf("%s<%d> %s{%d}\r\n%s", match[1], origin, match[2], end-start, data[start:end]), nil
+}
+
+func registerFetchAttribute(name string, form int, handler func(types.FetchAttribute, *Conn, mailstore.Message) (string, error)) {
+	registeredFetchAttributes[name] = fetchAttributeDefinition{form: form, handler: handler}
 }
 
 // Fetch the UID of the mail message
-func fetchUID(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
-	return fmt.Sprintf("UID %d", m.UID())
+func fetchUID(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	return fmt.Sprintf("UID %d", m.UID()), nil
 }
 
-func fetchFlags(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
-	flags := append(m.Flags().Strings(), m.Keywords()...)
-	flagList := strings.Join(flags, " ")
-	return fmt.Sprintf("FLAGS (%s)", flagList)
+func fetchFlags(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	flags := types.FlagList{System: c.sessionFlags(m), Keywords: m.Keywords()}
+	return "FLAGS " + flags.String(), nil
 }
 
-func fetchRfcSize(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
-	return fmt.Sprintf("RFC822.SIZE %d", m.Size())
+func fetchRfcSize(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	return fmt.Sprintf("RFC822.SIZE %d", m.Size()), nil
 }
 
-func fetchInternalDate(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
+func fetchInternalDate(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
 	dateStr := m.InternalDate().Format(util.InternalDate)
-	return fmt.Sprintf("INTERNALDATE \"%s\"", dateStr)
+	return fmt.Sprintf("INTERNALDATE \"%s\"", dateStr), nil
 }
 
-func fetchHeaders(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
-	hdr := fmt.Sprintf("%s\r\n", util.MIMEHeaderToString(m.Header()))
-	hdrLen := len(hdr)
+func fetchSaveDate(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	saved, ok := m.(mailstore.SaveDateMessage)
+	if !ok {
+		return "SAVEDATE NIL", nil
+	}
+	date, ok := saved.SaveDate()
+	if !ok {
+		return "SAVEDATE NIL", nil
+	}
+	return fmt.Sprintf("SAVEDATE \"%s\"", date.Format(util.InternalDate)), nil
+}
 
-	peekStr := ""
-	if peekOnly {
-		peekStr = ".PEEK"
+// Fetch BODY, which alone is the MIME structure of the message without
+// extension data. With a section it's the content of that section, eg
+// BODY[TEXT], BODY[1.MIME] or BODY[HEADER.FIELDS (From)].
+func fetchBody(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	section := attr.Section
+	switch {
+	case section == nil:
+		msg := types.RFC2822Message{Headers: m.Header(), Body: m.Body()}
+		return "BODY " + msg.BodyStructure(false), nil
+	case strings.HasPrefix(section.Specifier, "HEADER.FIELDS"):
+		return fetchHeaderFields(c.context(), *section, m)
+	case len(section.Part) > 0:
+		return fetchBodySection(*section, m)
+	case section.Specifier == "HEADER":
+		return fetchHeaders(m), nil
+	case section.Specifier == "TEXT":
+		return fetchText(m), nil
 	}
+	return fetchFullText(m), nil
+}
 
-	return fmt.Sprintf("BODY%s[HEADER] {%d}\r\n%s", peekStr, hdrLen, hdr)
+func fetchHeaders(m mailstore.Message) string {
+	hdr := fmt.Sprintf("%s\r\n", util.MIMEHeaderToString(m.Header()))
+	return fmt.Sprintf("BODY[HEADER] {%d}\r\n%s", len(hdr), hdr)
 }
 
-func fetchHeaderSpecificFields(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
-	if !peekOnly {
-		fmt.Printf("TODO: Peek not requested, mark all as non-recent\n")
-	}
-	fields := strings.Split(args[1], " ")
-	hdrs := m.Header()
-	requestedHeaders := make(textproto.MIMEHeader)
-	replyFieldList := make([]string, len(fields))
-	for i, key := range fields {
-		replyFieldList[i] = "\"" + key + "\""
-		// If the key exists in the headers, copy it over
-		v := hdrs.Get(key)
-		if v != "" {
-			requestedHeaders.Add(key, v)
+// Fetch the fields of a header named in a list, or with .NOT those not
+// named, eg BODY[HEADER.FIELDS (From Subject)]. The fields are returned
+// exactly as they appear in the message. With a part number (eg
+// BODY[2.HEADER.FIELDS (From)]) the header of an attached message is used.
+func fetchHeaderFields(ctx context.Context, section types.BodySection, m mailstore.Message) (string, error) {
+	raw := rawMessage(ctx, m)
+	if len(section.Part) > 0 {
+		msg, err := types.MessageFromBytes(raw)
+		if err != nil {
+			return "", err
 		}
+		part, err := msg.Part(section.Part)
+		if err != nil {
+			return "", err
+		}
+		if mediaType, _, _ := mime.ParseMediaType(part.Headers.Get("Content-Type")); mediaType != "message/rfc822" {
+			return "", types.ErrNoSuchPart
+		}
+		raw = []byte(part.Body)
+	}
+
+	replyFieldList := make([]string, len(section.Fields))
+	for i, field := range section.Fields {
+		replyFieldList[i] = "\"" + field + "\""
 	}
-	hdr := util.MIMEHeaderToString(requestedHeaders)
-	hdrLen := len(hdr)
 
-	return fmt.Sprintf("BODY[HEADER.FIELDS (%s)] {%d}\r\n%s",
+	header, _ := types.SplitRawMessage(raw)
+	hdr := types.FilterHeader(header, section.Fields, section.Specifier == "HEADER.FIELDS.NOT")
+
+	names := types.BodySection{Part: section.Part, Specifier: section.Specifier}
+	return fmt.Sprintf("BODY[%s (%s)] {%d}\r\n%s",
+		names.String(),
 		strings.Join(replyFieldList, " "),
-		hdrLen,
-		hdr)
+		len(hdr),
+		hdr), nil
+}
 
+// Returns the full text of a message, reassembling it from its header and
+// body if the mailstore can't provide it
+func rawMessage(ctx context.Context, m mailstore.Message) []byte {
+	if raw, ok := m.(mailstore.RawMessage); ok {
+		if data, err := mailstore.Raw(ctx, raw); err == nil {
+			return data
+		}
+	}
+	return []byte(util.MIMEHeaderToString(m.Header()) + "\r\n" + m.Body())
 }
 
-func fetchBody(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
+func fetchText(m mailstore.Message) string {
 	body := fmt.Sprintf("%s\r\n", m.Body())
-	bodyLen := len(body)
-
-	return fmt.Sprintf("BODY[TEXT] {%d}\r\n%s",
-		bodyLen, body)
+	return fmt.Sprintf("BODY[TEXT] {%d}\r\n%s", len(body), body)
 }
 
-func fetchFullText(args []string, c *Conn, m mailstore.Message, peekOnly bool) string {
+func fetchFullText(m mailstore.Message) string {
 	mail := fmt.Sprintf("%s\r\n%s\r\n", util.MIMEHeaderToString(m.Header()), m.Body())
-	mailLen := len(mail)
+	return fmt.Sprintf("BODY[] {%d}\r\n%s", len(mail), mail)
+}
+
+// Fetch one of the legacy RFC822 items, which are the same as BODY[],
+// BODY.PEEK[HEADER] and BODY[TEXT] under a different name
+func fetchRFC822(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	var response string
+	switch attr.Name {
+	case "RFC822":
+		response = fetchFullText(m)
+	case "RFC822.HEADER":
+		response = fetchHeaders(m)
+	case "RFC822.TEXT":
+		response = fetchText(m)
+	}
+	// Swap the section name for the item's name
+	return attr.Name + response[strings.Index(response, " "):], nil
+}
+
+func fetchEnvelope(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	msg := types.RFC2822Message{Headers: m.Header()}
+	return "ENVELOPE " + msg.Envelope(), nil
+}
+
+// Fetch the MIME structure of the message, with extension data
+func fetchBodyStructure(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	msg := types.RFC2822Message{Headers: m.Header(), Body: m.Body()}
+	return "BODYSTRUCTURE " + msg.BodyStructure(true), nil
+}
 
-	return fmt.Sprintf("BODY[] {%d}\r\n%s",
-		mailLen, mail)
+// Fetch a MIME part of the message, eg BODY[1], BODY[2.1.TEXT] or
+// BODY[1.MIME]. HEADER and TEXT refer to the header and body of an attached
+// message, and MIME to the MIME header of any part.
+func fetchBodySection(section types.BodySection, m mailstore.Message) (string, error) {
+	msg := types.RFC2822Message{Headers: m.Header(), Body: m.Body()}
+	part, err := msg.Part(section.Part)
+	if err != nil {
+		return "", err
+	}
+
+	var data string
+	switch section.Specifier {
+	case "":
+		data = part.Body
+	case "MIME":
+		data = util.MIMEHeaderToString(part.Headers) + "\r\n"
+	default:
+		mediaType, _, err := mime.ParseMediaType(part.Headers.Get("Content-Type"))
+		if err != nil || mediaType != "message/rfc822" {
+			return "", types.ErrNoSuchPart
+		}
+		attached, err := types.MessageFromBytes([]byte(part.Body))
+		if err != nil {
+			return "", err
+		}
+		if section.Specifier == "HEADER" {
+			data = util.MIMEHeaderToString(attached.Headers) + "\r\n"
+		} else {
+			data = attached.Body
+		}
+	}
+
+	return fmt.Sprintf("BODY[%s] {%d}\r\n%s", section.String(), len(data), data), nil
+}
+
+// Fetch a section of the message with its transfer encoding removed (RFC 3516)
+func fetchBinary(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	if attr.Section == nil {
+		return "", ErrUnrecognisedParameter
+	}
+	data, err := binarySection(m, attr.Section.Part)
+	if err != nil {
+		return "", err
+	}
+
+	// Decoded data may contain NULs, so it must always be sent as a literal8
+	return fmt.Sprintf("BINARY[%s] ~{%d}\r\n%s",
+		attr.Section.String(), len(data), data), nil
+}
+
+func fetchBinarySize(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	if attr.Section == nil {
+		return "", ErrUnrecognisedParameter
+	}
+	data, err := binarySection(m, attr.Section.Part)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("BINARY.SIZE[%s] %d", attr.Section.String(), len(data)), nil
+}
+
+// Find the requested part of the message and decode it. An empty part path
+// refers to the entire message, which is returned as-is.
+func binarySection(m mailstore.Message, path []int) ([]byte, error) {
+	msg := types.RFC2822Message{Headers: m.Header(), Body: m.Body()}
+	if len(path) == 0 {
+		return []byte(fmt.Sprintf("%s\r\n%s", util.MIMEHeaderToString(msg.Headers), msg.Body)), nil
+	}
+
+	part, err := msg.Part(path)
+	if err != nil {
+		return nil, err
+	}
+	return part.DecodedBody()
 }
@@ -1,6 +1,8 @@
 package conn_test
 
 import (
+	"net/textproto"
+
 	"github.com/jordwest/imap-server/conn"
 	. "github.com/onsi/ginkgo"
 
@@ -19,13 +21,13 @@ var _ = Describe("FETCH Command", func() {
 		It("should fetch the flags from a message by UID", func() {
 			SendLine("abcd.123 FETCH 1 (FLAGS UID)")
 			ExpectResponse("* 1 FETCH (FLAGS (\\Recent) UID 10)")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should be case insensitive", func() {
 			SendLine("abcd.123 fetch 1 (FLAGS UID)")
 			ExpectResponse("* 1 FETCH (FLAGS (\\Recent) UID 10)")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should fetch the header of a message", func() {
@@ -38,37 +40,76 @@ var _ = Describe("FETCH Command", func() {
 			ExpectResponsePattern("^((?i)(subject)|(message-id)|(to)|(from)|(date)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
 			ExpectResponse("")
 			ExpectResponse(")")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should fetch specific headers of a message", func() {
 			SendLine("abcd.123 FETCH 1 (BODY[HEADER.FIELDS (From Subject)])")
-			ExpectResponse("* 1 FETCH (BODY[HEADER.FIELDS (\"From\" \"Subject\")] {40}")
+			ExpectResponse("* 1 FETCH (BODY[HEADER.FIELDS (\"From\" \"Subject\")] {42}")
 			ExpectResponsePattern("^((?i)(subject)|(from)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
 			ExpectResponsePattern("^((?i)(subject)|(from)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
+			ExpectResponse("")
 			ExpectResponse(")")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should PEEK specific headers of a message without changing the Recent flag", func() {
 			SendLine("abcd.123 FETCH 1 (BODY.PEEK[HEADER.FIELDS (from Subject x-priority)])")
-			ExpectResponse("* 1 FETCH (BODY[HEADER.FIELDS (\"from\" \"Subject\" \"x-priority\")] {40}")
+			ExpectResponse("* 1 FETCH (BODY[HEADER.FIELDS (\"from\" \"Subject\" \"x-priority\")] {42}")
 			ExpectResponsePattern("^((?i)(subject)|(from)): [A-z0-9\\s@\\.]+$")
 			ExpectResponsePattern("^((?i)(subject)|(from)): [A-z0-9\\s@\\.]+$")
+			ExpectResponse("")
+			ExpectResponse(")")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should fetch all but the listed headers of a message", func() {
+			SendLine("abcd.123 FETCH 1 (BODY.PEEK[HEADER.FIELDS.NOT (Subject Message-ID To Date)])")
+			ExpectResponse("* 1 FETCH (BODY[HEADER.FIELDS.NOT (\"Subject\" \"Message-ID\" \"To\" \"Date\")] {21}")
+			ExpectResponsePattern("^(?i)from: [<>A-z0-9\\s@\\.]+$")
+			ExpectResponse("")
 			ExpectResponse(")")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should expand the FAST macro", func() {
+			SendLine("abcd.123 FETCH 1 FAST")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent) INTERNALDATE \"28-Oct-2014 00:09:00 +0700\" RFC822.SIZE 154)")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should expand the ALL and FULL macros", func() {
+			SendLine("abcd.123 FETCH 1 all")
+			ExpectResponsePattern(`^\* 1 FETCH \(FLAGS \(\\Recent\) INTERNALDATE "[^"]+" RFC822.SIZE 154 ENVELOPE \(.+\)\)$`)
+			ExpectResponse("abcd.123 OK FETCH completed")
+
+			SendLine("abcd.124 FETCH 1 FULL")
+			ExpectResponsePattern(`^\* 1 FETCH \(FLAGS \(\\Recent\) INTERNALDATE "[^"]+" RFC822.SIZE 154 ENVELOPE \(.+\) BODY \("TEXT" "PLAIN" .+\)\)$`)
+			ExpectResponse("abcd.124 OK FETCH completed")
+		})
+
+		It("should fetch a single data item without parentheses", func() {
+			SendLine("abcd.123 FETCH 1 UID")
+			ExpectResponse("* 1 FETCH (UID 10)")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should fetch the internal date of a message", func() {
 			SendLine("abcd.123 FETCH 1 (INTERNALDATE)")
 			ExpectResponse("* 1 FETCH (INTERNALDATE \"28-Oct-2014 00:09:00 +0700\")")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should fetch the save date of a message", func() {
+			SendLine("abcd.123 FETCH 1 (SAVEDATE)")
+			ExpectResponse("* 1 FETCH (SAVEDATE \"28-Oct-2014 00:09:00 +0700\")")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should fetch the RFC822 size of a message", func() {
 			SendLine("abcd.123 FETCH 1 (RFC822.SIZE)")
 			ExpectResponse("* 1 FETCH (RFC822.SIZE 154)")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should fetch the body of a message only", func() {
@@ -78,7 +119,27 @@ var _ = Describe("FETCH Command", func() {
 			ExpectResponse("Regards,")
 			ExpectResponse("Me")
 			ExpectResponse(")")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should fetch the legacy RFC822 items", func() {
+			SendLine("abcd.123 FETCH 1 (RFC822.HEADER RFC822.TEXT)")
+			ExpectResponse("* 1 FETCH (RFC822.HEADER {126}")
+			ExpectResponsePattern("^((?i)(subject)|(message-id)|(to)|(from)|(date)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
+			ExpectResponsePattern("^((?i)(subject)|(message-id)|(to)|(from)|(date)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
+			ExpectResponsePattern("^((?i)(subject)|(message-id)|(to)|(from)|(date)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
+			ExpectResponsePattern("^((?i)(subject)|(message-id)|(to)|(from)|(date)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
+			ExpectResponsePattern("^((?i)(subject)|(message-id)|(to)|(from)|(date)): [<>A-z0-9\\s@\\.,\\:\\+]+$")
+			ExpectResponse("")
+			ExpectResponse(" RFC822.TEXT {26}")
+			ExpectResponse("Test email")
+			ExpectResponse("Regards,")
+			ExpectResponse("Me")
+			ExpectResponse(")")
+			ExpectResponse("abcd.123 OK FETCH completed")
+
+			SendLine("abcd.124 FETCH 2 (RFC822)")
+			ExpectResponse("* 2 FETCH (RFC822 {154}")
 		})
 
 		It("should fetch a complete message", func() {
@@ -94,7 +155,7 @@ var _ = Describe("FETCH Command", func() {
 			ExpectResponse("Regards,")
 			ExpectResponse("Me")
 			ExpectResponse(")")
-			ExpectResponse("abcd.123 OK FETCH Completed")
+			ExpectResponse("abcd.123 OK FETCH completed")
 		})
 
 		It("should fetch a complete message by UID", func() {
@@ -108,7 +169,125 @@ var _ = Describe("FETCH Command", func() {
 			ExpectResponse("")
 			ExpectResponse("Another test email")
 			ExpectResponse(" UID 11)")
-			ExpectResponse("abcd.123 OK UID FETCH Completed")
+			ExpectResponse("abcd.123 OK UID FETCH completed")
+		})
+
+		It("should fetch the envelope of a message", func() {
+			SendLine("abcd.123 FETCH 1 (ENVELOPE)")
+			ExpectResponse("* 1 FETCH (ENVELOPE (\"Tue, 28 Oct 2014 00:09:00 +0700\" \"Test email\" " +
+				"((NIL NIL \"me\" \"test.com\")) ((NIL NIL \"me\" \"test.com\")) ((NIL NIL \"me\" \"test.com\")) " +
+				"((NIL NIL \"you\" \"test.com\")) NIL NIL NIL \"<10@test.com>\"))")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should fetch the structure of a message", func() {
+			SendLine("abcd.123 FETCH 1 (BODY BODYSTRUCTURE)")
+			ExpectResponse("* 1 FETCH (BODY (\"TEXT\" \"PLAIN\" (\"CHARSET\" \"us-ascii\") NIL NIL \"7BIT\" 24 3) " +
+				"BODYSTRUCTURE (\"TEXT\" \"PLAIN\" (\"CHARSET\" \"us-ascii\") NIL NIL \"7BIT\" 24 3 NIL NIL NIL NIL))")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should fetch part of a section", func() {
+			SendLine("abcd.123 FETCH 1 (BODY.PEEK[TEXT]<5.7> BINARY.PEEK[1]<20.100> BODY[]<500.10>)")
+			ExpectResponse("* 1 FETCH (BODY[TEXT]<5> {7}")
+			ExpectResponse("email")
+			ExpectResponse(" BINARY[1]<20> ~{4}")
+			ExpectResponse("")
+			ExpectResponse("Me BODY[]<500> {0}")
+			ExpectResponse(")")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should reject partial fetches of no octets", func() {
+			SendLine("abcd.123 FETCH 1 (BODY.PEEK[TEXT]<5.0>)")
+			ExpectResponse("abcd.123 BAD Invalid fetch item BODY.PEEK[TEXT]<5.0>, at least one octet must be fetched")
+		})
+
+		It("should reject partial fetches of items which aren't sections", func() {
+			SendLine("abcd.123 FETCH 1 (FLAGS<0.10>)")
+			ExpectResponse("abcd.123 BAD Unrecognised Parameter")
+		})
+
+		It("should reject malformed sections before fetching anything", func() {
+			SendLine("abcd.123 FETCH 1:* (FLAGS BODY[0.TEXT])")
+			ExpectResponse("abcd.123 BAD Invalid fetch item BODY[0.TEXT], part numbers start from 1")
+		})
+
+		It("should fetch the binary content of a message part", func() {
+			SendLine("abcd.123 FETCH 1 (BINARY.PEEK[1])")
+			ExpectResponse("* 1 FETCH (BINARY[1] ~{24}")
+			ExpectResponse("Test email")
+			ExpectResponse("Regards,")
+			ExpectResponse("Me)")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should decode base64 content when fetching binary data", func() {
+			msg := tConn.SelectedMailbox.NewMessage()
+			hdr := make(textproto.MIMEHeader)
+			hdr.Set("Content-Transfer-Encoding", "base64")
+			msg = msg.SetHeaders(hdr)
+			msg = msg.SetBody("SGVsbG8g\r\nd29ybGQ=\r\n")
+			msg.Save()
+			tConn.SelectedMailbox, _ = tConn.User.MailboxByName("INBOX")
+
+			SendLine("abcd.123 FETCH 4 (BINARY.SIZE[1] BINARY[1])")
+			ExpectResponse("* 4 FETCH (BINARY.SIZE[1] 11 BINARY[1] ~{11}")
+			ExpectResponse("Hello world)")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should fetch individual MIME parts", func() {
+			msg := tConn.SelectedMailbox.NewMessage()
+			hdr := make(textproto.MIMEHeader)
+			hdr.Set("Content-Type", "multipart/mixed; boundary=b")
+			msg = msg.SetHeaders(hdr)
+			msg = msg.SetBody("--b\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"Hello\r\n" +
+				"--b\r\n" +
+				"Content-Type: message/rfc822\r\n" +
+				"\r\n" +
+				"Subject: Fwd\r\n" +
+				"\r\n" +
+				"Inner\r\n" +
+				"--b--\r\n")
+			msg.Save()
+			tConn.SelectedMailbox, _ = tConn.User.MailboxByName("INBOX")
+
+			SendLine("abcd.123 FETCH 4 (BODY.PEEK[1] BODY[1.MIME])")
+			ExpectResponse("* 4 FETCH (BODY[1] {5}")
+			ExpectResponse("Hello BODY[1.MIME] {28}")
+			ExpectResponse("Content-Type: text/plain")
+			ExpectResponse("")
+			ExpectResponse(")")
+			ExpectResponse("abcd.123 OK FETCH completed")
+
+			SendLine("abcd.124 FETCH 4 (BODY[2.HEADER] BODY[2.TEXT] BODY[2.1])")
+			ExpectResponse("* 4 FETCH (BODY[2.HEADER] {16}")
+			ExpectResponse("Subject: Fwd")
+			ExpectResponse("")
+			ExpectResponse(" BODY[2.TEXT] {5}")
+			ExpectResponse("Inner BODY[2.1] {5}")
+			ExpectResponse("Inner)")
+			ExpectResponse("abcd.124 OK FETCH completed")
+
+			SendLine("abcd.125 FETCH 4 (BODY[1.TEXT])")
+			ExpectResponse("abcd.125 NO No such message part")
+		})
+
+		It("should reject binary fetches of unknown transfer encodings", func() {
+			msg := tConn.SelectedMailbox.NewMessage()
+			hdr := make(textproto.MIMEHeader)
+			hdr.Set("Content-Transfer-Encoding", "x-unknown")
+			msg = msg.SetHeaders(hdr)
+			msg = msg.SetBody("Hello")
+			msg.Save()
+			tConn.SelectedMailbox, _ = tConn.User.MailboxByName("INBOX")
+
+			SendLine("abcd.123 FETCH 4 (BINARY[1])")
+			ExpectResponse("abcd.123 NO [UNKNOWN-CTE] Unknown Content-Transfer-Encoding")
 		})
 
 	})
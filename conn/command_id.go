@@ -0,0 +1,50 @@
+package conn
+
+import (
+	"fmt"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// Identification sent to clients in response to ID, unless stealth mode is on
+const (
+	serverName       = "imap-server"
+	serverVendor     = "jordwest"
+	serverSupportURL = "https://github.com/jordwest/imap-server"
+)
+
+// Handles the ID command (RFC 2971). The parameters sent by the client are
+// ignored. The server's hostname is given if one is configured, but nothing
+// about the software is given in stealth mode, and nothing about the
+// platform it runs on is ever given.
+func cmdID(args commandArgs, c *Conn) {
+	var fields string
+	if !c.Stealth {
+		fields = fmt.Sprintf("\"name\" \"%s\" \"vendor\" \"%s\" \"support-url\" \"%s\"",
+			serverName, serverVendor, serverSupportURL)
+	}
+	if c.Hostname != "" {
+		if fields != "" {
+			fields += " "
+		}
+		fields += "\"host\" " + util.Quote(c.Hostname)
+	}
+	if fields == "" {
+		c.writeResponse("", "ID NIL")
+	} else {
+		c.writeResponse("", "ID ("+fields+")")
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "ID"))
+}
+
+// The text of the greeting sent when a client connects
+func (c *Conn) greeting() string {
+	text := "OK "
+	if c.Hostname != "" {
+		text += c.Hostname + " "
+	}
+	if !c.Stealth {
+		text += "IMAP4rev1 "
+	}
+	return text + "Service Ready"
+}
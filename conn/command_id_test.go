@@ -0,0 +1,62 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("ID Command", func() {
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should identify the server", func() {
+			SendLine("abcd.123 ID (\"name\" \"Thunderbird\")")
+			ExpectResponse("* ID (\"name\" \"imap-server\" \"vendor\" \"jordwest\" \"support-url\" \"https://github.com/jordwest/imap-server\")")
+			ExpectResponse("abcd.123 OK ID completed")
+		})
+
+		It("should include the hostname", func() {
+			tConn.Hostname = "imap.example.com"
+			SendLine("abcd.123 ID NIL")
+			ExpectResponse("* ID (\"name\" \"imap-server\" \"vendor\" \"jordwest\" \"support-url\" \"https://github.com/jordwest/imap-server\" \"host\" \"imap.example.com\")")
+			ExpectResponse("abcd.123 OK ID completed")
+		})
+
+		It("should only give the hostname in stealth mode", func() {
+			tConn.Stealth = true
+			tConn.Hostname = "imap.example.com"
+			SendLine("abcd.123 ID NIL")
+			ExpectResponse("* ID (\"host\" \"imap.example.com\")")
+			ExpectResponse("abcd.123 OK ID completed")
+		})
+
+		It("should hide server details in stealth mode", func() {
+			tConn.Stealth = true
+			SendLine("abcd.123 ID NIL")
+			ExpectResponse("* ID NIL")
+			ExpectResponse("abcd.123 OK ID completed")
+		})
+	})
+})
+
+var _ = Describe("Greeting", func() {
+	BeforeEach(func() {
+		tConn.Hostname = "imap.example.com"
+	})
+
+	It("should include the hostname", func() {
+		ExpectResponse("* OK imap.example.com IMAP4rev1 Service Ready")
+	})
+
+	Context("In stealth mode", func() {
+		BeforeEach(func() {
+			tConn.Stealth = true
+		})
+
+		It("should hide server details", func() {
+			ExpectResponse("* OK imap.example.com Service Ready")
+		})
+	})
+})
@@ -1,20 +1,114 @@
 package conn
 
-const listArgSelector int = 1
+import (
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/util"
+)
+
+const (
+	listArgReference int = 0
+	listArgPattern   int = 1
+)
+
+// Gmail's XLIST attributes, keyed by the equivalent RFC 6154 special-use
+// attribute
+var xlistAttributes = map[string]string{
+	"\\All":     "\\AllMail",
+	"\\Junk":    "\\Spam",
+	"\\Flagged": "\\Starred",
+}
 
 func cmdList(args commandArgs, c *Conn) {
-	if !c.assertAuthenticated(args.ID()) {
+	listMailboxes(args, c, "LIST", func(mailbox mailstore.Mailbox) string {
+		return ""
+	})
+}
+
+// Handles XLIST, Gmail's predecessor to special-use mailboxes, which some
+// older clients still use to find the Sent and Trash folders
+func cmdXList(args commandArgs, c *Conn) {
+	listMailboxes(args, c, "XLIST", func(mailbox mailstore.Mailbox) string {
+		if strings.EqualFold(mailbox.Name(), "INBOX") {
+			return "\\Inbox"
+		}
+		specialUse, ok := mailbox.(mailstore.SpecialUseMailbox)
+		if !ok || specialUse.SpecialUse() == "" {
+			return ""
+		}
+		if attribute, ok := xlistAttributes[specialUse.SpecialUse()]; ok {
+			return attribute
+		}
+		return specialUse.SpecialUse()
+	})
+}
+
+// Write the response to a LIST-like command, with the attributes of each
+// mailbox given by the attributes function
+func listMailboxes(args commandArgs, c *Conn, command string, attributes func(mailstore.Mailbox) string) {
+	reference, ok := c.mailboxArg(args, listArgReference)
+	if !ok {
+		return
+	}
+	listPattern, ok := c.mailboxArg(args, listArgPattern)
+	if !ok {
 		return
 	}
+	if listPattern == "" {
+		// An empty pattern asks for the hierarchy delimiter, and the root of
+		// the reference name
+		root := ""
+		if i := strings.Index(reference, c.delimiter()); c.delimiter() != "" && i >= 0 {
+			root = reference[:i+len(c.delimiter())]
+		}
+		c.writeListResponse(command, "\\Noselect", root)
+		c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, command))
+		return
+	}
+
+	pattern := reference + listPattern
+	delimiter := c.delimiter()
+	mailboxes, err := mailstore.Mailboxes(c.context(), c.User)
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	exists := make(map[string]bool)
+	for _, mailbox := range mailboxes {
+		exists[mailbox.Name()] = true
+	}
+
+	listed := make(map[string]bool)
+	for _, mailbox := range mailboxes {
+		if util.MailboxMatchesPattern(mailbox.Name(), pattern, delimiter) {
+			attrs := attributes(mailbox)
+			if isNoselect(mailbox) {
+				attrs = strings.TrimSpace("\\Noselect " + attrs)
+			}
+			c.writeListResponse(command, attrs, mailbox.Name())
+			continue
+		}
 
-	if args.Arg(listArgSelector) == "" {
-		// Blank selector means request directory separator
-		c.writeResponse("", "LIST (\\Noselect) \"/\" \"\"")
-	} else if args.Arg(listArgSelector) == "*" {
-		// List all mailboxes requested
-		for _, mailbox := range c.User.Mailboxes() {
-			c.writeResponse("", "LIST () \"/\" \""+mailbox.Name()+"\"")
+		// When % stops a mailbox from matching, a parent of it which matches
+		// but doesn't exist is listed as \Noselect instead (RFC 3501 6.3.8)
+		if !strings.Contains(pattern, "%") {
+			continue
+		}
+		parents := mailstore.ParentNames(mailbox.Name(), delimiter)
+		for i := len(parents) - 1; i >= 0; i-- {
+			parent := parents[i]
+			if exists[parent] || listed[parent] || !util.MailboxMatchesPattern(parent, pattern, delimiter) {
+				continue
+			}
+			listed[parent] = true
+			c.writeListResponse(command, "\\Noselect", parent)
 		}
 	}
-	c.writeResponse(args.ID(), "OK LIST completed")
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, command))
+}
+
+// Write an untagged response to a LIST-like command for one mailbox
+func (c *Conn) writeListResponse(command string, attributes string, name string) {
+	c.writeResponse("", command+" ("+attributes+") "+c.quotedDelimiter()+" "+util.Quote(c.encodeMailboxName(name)))
 }
@@ -22,6 +22,121 @@ var _ = Describe("LIST Command", func() {
 			SendLine("abcd.123 LIST \"\" \"*\"")
 			ExpectResponse("* LIST () \"/\" \"INBOX\"")
 			ExpectResponse("* LIST () \"/\" \"Trash\"")
+			ExpectResponse("* LIST () \"/\" \"Sent\"")
+			ExpectResponse("abcd.123 OK LIST completed")
+		})
+
+		It("should return the root of the reference with the separator", func() {
+			SendLine("abcd.123 LIST \"Work/Projects\" \"\"")
+			ExpectResponse("* LIST (\\Noselect) \"/\" \"Work/\"")
+			ExpectResponse("abcd.123 OK LIST completed")
+		})
+
+		Context("With nested mailboxes", func() {
+			BeforeEach(func() {
+				mStore.User.CreateMailbox("Work/Projects/2015")
+			})
+
+			It("should match one level of the hierarchy with %", func() {
+				SendLine("abcd.123 LIST \"\" %")
+				ExpectResponse("* LIST () \"/\" \"INBOX\"")
+				ExpectResponse("* LIST () \"/\" \"Trash\"")
+				ExpectResponse("* LIST () \"/\" \"Sent\"")
+				ExpectResponse("* LIST (\\Noselect) \"/\" \"Work\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+
+			It("should list missing parents matched by % as \\Noselect", func() {
+				SendLine("abcd.123 LIST Work/ %")
+				ExpectResponse("* LIST (\\Noselect) \"/\" \"Work/Projects\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+
+			It("should match every level with *", func() {
+				SendLine("abcd.123 LIST Work/ *")
+				ExpectResponse("* LIST () \"/\" \"Work/Projects/2015\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+		})
+
+		Context("With a mailstore which delimits the hierarchy with '.'", func() {
+			BeforeEach(func() {
+				mStore.Delimiter = "."
+				tConn.Mailstore = mStore
+				mStore.User.CreateMailbox("Work.Projects")
+			})
+
+			It("should return the mailstore's delimiter", func() {
+				SendLine("abcd.123 LIST \"Work.Projects\" \"\"")
+				ExpectResponse("* LIST (\\Noselect) \".\" \"Work.\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+
+			It("should match levels of the hierarchy split by the delimiter", func() {
+				SendLine("abcd.123 LIST \"\" %")
+				ExpectResponse("* LIST () \".\" \"INBOX\"")
+				ExpectResponse("* LIST () \".\" \"Trash\"")
+				ExpectResponse("* LIST () \".\" \"Sent\"")
+				ExpectResponse("* LIST (\\Noselect) \".\" \"Work\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+		})
+
+		Context("With a mailstore which delimits the hierarchy with ':'", func() {
+			BeforeEach(func() {
+				mStore.Delimiter = ":"
+				tConn.Mailstore = mStore
+				mStore.User.CreateMailbox("Work:Projects")
+			})
+
+			It("should accept unquoted names using the delimiter", func() {
+				SendLine("abcd.123 LIST Work: %")
+				ExpectResponse("* LIST () \":\" \"Work:Projects\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+
+				SendLine("abcd.124 STATUS Work:Projects (MESSAGES)")
+				ExpectResponse("* STATUS Work:Projects (MESSAGES 0)")
+				ExpectResponse("abcd.124 OK STATUS completed")
+			})
+		})
+
+		Context("With a mailstore whose mailbox names are flat", func() {
+			BeforeEach(func() {
+				mStore.Delimiter = ""
+				tConn.Mailstore = mStore
+				mStore.User.CreateMailbox("Work/Projects")
+			})
+
+			It("should return NIL as the delimiter", func() {
+				SendLine("abcd.123 LIST \"\" \"\"")
+				ExpectResponse("* LIST (\\Noselect) NIL \"\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+
+			It("should treat every mailbox name as a single level", func() {
+				SendLine("abcd.123 LIST \"\" Work%")
+				ExpectResponse("* LIST () NIL \"Work/Projects\"")
+				ExpectResponse("abcd.123 OK LIST completed")
+			})
+		})
+
+		It("should encode mailbox names in modified UTF-7", func() {
+			mStore.User.CreateMailbox("Entw\u00fcrfe")
+			SendLine("abcd.123 LIST \"\" \"Entw&APw-%\"")
+			ExpectResponse("* LIST () \"/\" \"Entw&APw-rfe\"")
+			ExpectResponse("abcd.123 OK LIST completed")
+		})
+
+		It("should accept quoted patterns with spaces and escapes", func() {
+			mStore.User.CreateMailbox("Say \"hi\" there")
+			SendLine("abcd.123 LIST \"\" \"Say \\\"hi\\\" *\"")
+			ExpectResponse("* LIST () \"/\" \"Say \\\"hi\\\" there\"")
+			ExpectResponse("abcd.123 OK LIST completed")
+		})
+
+		It("should match INBOX in any case", func() {
+			SendLine("abcd.123 LIST \"\" inb%")
+			ExpectResponse("* LIST () \"/\" \"INBOX\"")
 			ExpectResponse("abcd.123 OK LIST completed")
 		})
 	})
@@ -37,3 +152,18 @@ var _ = Describe("LIST Command", func() {
 		})
 	})
 })
+
+var _ = Describe("XLIST Command", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateAuthenticated)
+		tConn.User = mStore.User
+	})
+
+	It("should return Gmail-style mailbox attributes", func() {
+		SendLine("abcd.123 XLIST \"\" \"*\"")
+		ExpectResponse("* XLIST (\\Inbox) \"/\" \"INBOX\"")
+		ExpectResponse("* XLIST () \"/\" \"Trash\"")
+		ExpectResponse("* XLIST (\\Sent) \"/\" \"Sent\"")
+		ExpectResponse("abcd.123 OK XLIST completed")
+	})
+})
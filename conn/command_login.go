@@ -1,13 +1,44 @@
 package conn
 
+import (
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/util"
+)
+
+const (
+	loginArgUsername int = 0
+	loginArgPassword int = 1
+)
+
 // Handles PLAIN text LOGIN command
 func cmdLogin(args commandArgs, c *Conn) {
-	user, err := c.Mailstore.Authenticate(args.Arg(0), args.Arg(1))
-	c.User = user
+	username := util.Unquote(args.Arg(loginArgUsername))
+	password := util.Unquote(args.Arg(loginArgPassword))
+	if c.referLogin(args.ID(), username) {
+		return
+	}
+
+	user, err := mailstore.Authenticate(c.context(), c.Mailstore, username, password)
 	if err != nil {
-		c.writeResponse(args.ID(), "NO Incorrect username/password")
+		c.writeLoginFailure(args.ID(), username, err, MsgIncorrectLogin)
 		return
 	}
-	c.SetState(StateAuthenticated)
-	c.writeResponse(args.ID(), "OK Authenticated")
+	c.setAuthenticated(username, user)
+	c.writeOK(args.ID(), codeNone, c.text(MsgAuthenticated))
+}
+
+// Check whether the user's mailstore lives on another server and if so, refer
+// the client there instead of logging in (RFC 2221). Returns true if the
+// client was referred.
+func (c *Conn) referLogin(seq string, username string) bool {
+	if c.LoginReferral == nil || username == "" {
+		return false
+	}
+
+	url := c.LoginReferral(username)
+	if url == "" {
+		return false
+	}
+	c.writeNo(seq, codeReferral.with(url), c.text(MsgRemoteServer))
+	return true
 }
@@ -1,11 +1,13 @@
 package conn_test
 
 import (
+	"encoding/base64"
+
 	"github.com/jordwest/imap-server/conn"
 	. "github.com/onsi/ginkgo"
 )
 
-var _ = Describe("LSUB Command", func() {
+var _ = Describe("LOGIN Command", func() {
 	Context("When logged in", func() {
 		BeforeEach(func() {
 			tConn.SetState(conn.StateAuthenticated)
@@ -23,5 +25,49 @@ var _ = Describe("LSUB Command", func() {
 
 		PIt("should give an error", func() {
 		})
+
+		It("should accept credentials sent as atoms", func() {
+			SendLine("abcd.123 LOGIN username password")
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
+
+		It("should unquote credentials sent as quoted strings", func() {
+			SendLine("abcd.123 LOGIN \"username\" \"pass\\\"word\"")
+			ExpectResponse("abcd.123 NO [AUTHENTICATIONFAILED] Incorrect username/password")
+		})
+	})
+
+	Context("When login referrals are configured", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+			tConn.LoginReferral = func(username string) string {
+				if username == "remote" {
+					return "imap://remote@other-host/"
+				}
+				return ""
+			}
+		})
+
+		It("should advertise LOGIN-REFERRALS", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponsePattern("^\\* CAPABILITY .* LOGIN-REFERRALS")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should refer users hosted on another server", func() {
+			SendLine("abcd.123 LOGIN \"remote\" \"password\"")
+			ExpectResponse("abcd.123 NO [REFERRAL imap://remote@other-host/] Remote server")
+		})
+
+		It("should refer users authenticating with AUTHENTICATE", func() {
+			SendLine("abcd.123 AUTHENTICATE PLAIN " +
+				base64.StdEncoding.EncodeToString([]byte("\x00remote\x00password")))
+			ExpectResponse("abcd.123 NO [REFERRAL imap://remote@other-host/] Remote server")
+		})
+
+		It("should log in local users as normal", func() {
+			SendLine("abcd.123 LOGIN \"username\" \"password\"")
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
 	})
 })
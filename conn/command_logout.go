@@ -1,8 +1,12 @@
 package conn
 
 func cmdLogout(args commandArgs, c *Conn) {
-	c.writeResponse("", "BYE IMAP4rev1 server logging out")
+	if c.username != "" {
+		c.audit(AuditEvent{Action: "LOGOUT", Username: c.username})
+	}
+	c.writeResponse("", "BYE "+c.text(MsgLoggingOut))
 	c.SetState(StateLoggedOut)
-	c.writeResponse(args.ID(), "OK LOGOUT completed")
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "LOGOUT"))
+	c.Flush()
 	c.Close()
 }
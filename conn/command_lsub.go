@@ -1,8 +1,81 @@
 package conn
 
+import (
+	"context"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/util"
+)
+
+const (
+	lsubArgReference int = 0
+	lsubArgPattern   int = 1
+)
+
+// List the subscribed mailboxes matching a pattern. Users without a
+// subscription store are treated as subscribed to all of their mailboxes.
 func cmdLSub(args commandArgs, c *Conn) {
-	for _, mailbox := range c.User.Mailboxes() {
-		c.writeResponse("", "LSUB () \"/\" \""+mailbox.Name()+"\"")
+	reference, ok := c.mailboxArg(args, lsubArgReference)
+	if !ok {
+		return
+	}
+	pattern, ok := c.mailboxArg(args, lsubArgPattern)
+	if !ok {
+		return
+	}
+	pattern = reference + pattern
+
+	subscribed, err := subscriptions(c.context(), c.User)
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	delimiter := c.delimiter()
+	isSubscribed := make(map[string]bool)
+	for _, name := range subscribed {
+		isSubscribed[name] = true
+	}
+
+	listed := make(map[string]bool)
+	for _, name := range subscribed {
+		if util.MailboxMatchesPattern(name, pattern, delimiter) {
+			c.writeListResponse("LSUB", "", name)
+			continue
+		}
+
+		// When % stops a subscribed mailbox from matching, a parent of it
+		// that matches is listed as \Noselect instead (RFC 3501 6.3.9)
+		if !strings.Contains(pattern, "%") {
+			continue
+		}
+		parents := mailstore.ParentNames(name, delimiter)
+		for i := len(parents) - 1; i >= 0; i-- {
+			parent := parents[i]
+			if isSubscribed[parent] || listed[parent] || !util.MailboxMatchesPattern(parent, pattern, delimiter) {
+				continue
+			}
+			listed[parent] = true
+			c.writeListResponse("LSUB", "\\Noselect", parent)
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "LSUB"))
+}
+
+// Return the names of the mailboxes a user is subscribed to
+func subscriptions(ctx context.Context, user mailstore.User) ([]string, error) {
+	if store, ok := user.(mailstore.SubscriptionStore); ok {
+		return mailstore.Subscriptions(ctx, store)
+	}
+
+	mailboxes, err := mailstore.Mailboxes(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, mailbox := range mailboxes {
+		names = append(names, mailbox.Name())
 	}
-	c.writeResponse(args.ID(), "OK LSUB Completed")
+	return names, nil
 }
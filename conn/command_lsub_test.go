@@ -2,17 +2,52 @@ package conn_test
 
 import (
 	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
 	. "github.com/onsi/ginkgo"
 )
 
-var _ = Describe("LOGIN Command", func() {
+var _ = Describe("LSUB Command", func() {
 	Context("When logged in", func() {
 		BeforeEach(func() {
 			tConn.SetState(conn.StateAuthenticated)
 			tConn.User = mStore.User
 		})
 
-		PIt("should (implement test)", func() {
+		It("should list all subscribed mailboxes", func() {
+			SendLine("abcd.123 LSUB \"\" \"*\"")
+			ExpectResponse("* LSUB () \"/\" \"INBOX\"")
+			ExpectResponse("* LSUB () \"/\" \"Sent\"")
+			ExpectResponse("* LSUB () \"/\" \"Trash\"")
+			ExpectResponse("abcd.123 OK LSUB completed")
+		})
+
+		It("should only list subscribed mailboxes matching the pattern", func() {
+			SendLine("abcd.123 LSUB \"\" T%")
+			ExpectResponse("* LSUB () \"/\" \"Trash\"")
+			ExpectResponse("abcd.123 OK LSUB completed")
+		})
+
+		It("should list unsubscribed parents of matching mailboxes as \\Noselect", func() {
+			mStore.User.Subscribe("Work/Projects")
+			mStore.User.Unsubscribe("Sent")
+			SendLine("abcd.123 LSUB \"\" \"%\"")
+			ExpectResponse("* LSUB () \"/\" \"INBOX\"")
+			ExpectResponse("* LSUB () \"/\" \"Trash\"")
+			ExpectResponse("* LSUB (\\Noselect) \"/\" \"Work\"")
+			ExpectResponse("abcd.123 OK LSUB completed")
+
+			SendLine("abcd.124 LSUB Work/ %")
+			ExpectResponse("* LSUB () \"/\" \"Work/Projects\"")
+			ExpectResponse("abcd.124 OK LSUB completed")
+		})
+
+		It("should treat users without subscriptions as subscribed to every mailbox", func() {
+			tConn.User = unsubscribableUser{mStore.User}
+			SendLine("abcd.123 LSUB \"\" *")
+			ExpectResponse("* LSUB () \"/\" \"INBOX\"")
+			ExpectResponse("* LSUB () \"/\" \"Trash\"")
+			ExpectResponse("* LSUB () \"/\" \"Sent\"")
+			ExpectResponse("abcd.123 OK LSUB completed")
 		})
 	})
 
@@ -21,7 +56,20 @@ var _ = Describe("LOGIN Command", func() {
 			tConn.SetState(conn.StateNotAuthenticated)
 		})
 
-		PIt("should give an error", func() {
+		It("should give an error", func() {
+			SendLine("abcd.123 LSUB \"\" *")
+			ExpectResponse("abcd.123 BAD not authenticated")
 		})
 	})
 })
+
+// A user whose mailstore doesn't keep subscriptions
+type unsubscribableUser struct {
+	user mailstore.User
+}
+
+func (u unsubscribableUser) Mailboxes() []mailstore.Mailbox { return u.user.Mailboxes() }
+
+func (u unsubscribableUser) MailboxByName(name string) (mailstore.Mailbox, error) {
+	return u.user.MailboxByName(name)
+}
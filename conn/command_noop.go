@@ -1,5 +1,5 @@
 package conn
 
 func cmdNoop(args commandArgs, c *Conn) {
-	c.writeResponse(args.ID(), "OK NOOP Completed")
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "NOOP"))
 }
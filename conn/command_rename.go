@@ -0,0 +1,177 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/util"
+)
+
+const (
+	renameArgMailbox int = 0
+	renameArgNewName int = 1
+)
+
+func cmdRename(args commandArgs, c *Conn) {
+	name, ok := c.mailboxArg(args, renameArgMailbox)
+	if !ok {
+		return
+	}
+	newName, ok := c.mailboxArg(args, renameArgNewName)
+	if !ok {
+		return
+	}
+
+	mailbox, err := mailstore.MailboxByName(c.context(), c.User, name)
+	if err != nil {
+		c.writeNo(args.ID(), codeNonexistent, c.text(MsgNoSuchMailbox))
+		return
+	}
+	oldName := mailbox.Name()
+	delimiter := c.delimiter()
+	if delimiter != "" {
+		newName = strings.TrimSuffix(newName, delimiter)
+	}
+	if newName == "" || util.MailboxNamesEqual(newName, "INBOX") {
+		c.writeNo(args.ID(), codeAlreadyExists, c.text(MsgMailboxExists))
+		return
+	}
+	if _, err := mailstore.MailboxByName(c.context(), c.User, newName); err == nil {
+		c.writeNo(args.ID(), codeAlreadyExists, c.text(MsgMailboxExists))
+		return
+	}
+	if delimiter != "" && strings.HasPrefix(newName, oldName+delimiter) {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgRenameBeneathItself))
+		return
+	}
+
+	renamer, ok := c.User.(mailstore.MailboxRenamer)
+	if !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgCannotRename))
+		return
+	}
+	if creator, ok := c.User.(mailstore.MailboxCreator); ok {
+		if err := createParents(c.context(), c.User, creator, newName, delimiter); err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
+	}
+
+	// Mailboxes beneath the renamed one move with it, except those beneath
+	// INBOX, which stays where it is (RFC 3501 section 6.3.5)
+	var children []string
+	if !util.MailboxNamesEqual(oldName, "INBOX") {
+		inferiors, err := mailstore.Inferiors(c.context(), c.User, oldName, delimiter)
+		if err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
+		for _, child := range inferiors {
+			children = append(children, child.Name())
+		}
+	}
+
+	// The children are renamed first, so that if the mailbox itself can't be
+	// renamed they can all be put back
+	var renames [][2]string
+	for _, child := range children {
+		renames = append(renames, [2]string{child, newName + strings.TrimPrefix(child, oldName)})
+	}
+	renames = append(renames, [2]string{oldName, newName})
+	if err := renameMailboxes(c.context(), renamer, renames); errors.Is(err, mailstore.ErrMailboxExists) {
+		c.writeNo(args.ID(), codeAlreadyExists, c.text(MsgMailboxExists))
+		return
+	} else if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.followRename(renames)
+	c.moveSubscriptions(renames)
+	c.audit(AuditEvent{Action: "RENAME", Username: c.username, Mailbox: oldName, NewName: newName})
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "RENAME"))
+}
+
+// Rename each mailbox in turn from its old name to its new one. If any can't
+// be renamed, those already renamed are given their old names back.
+func renameMailboxes(ctx context.Context, renamer mailstore.MailboxRenamer, renames [][2]string) error {
+	for i, rename := range renames {
+		err := mailstore.RenameMailbox(ctx, renamer, rename[0], rename[1])
+		if err == nil {
+			continue
+		}
+		// The old names are given back even if the command's context has
+		// ended
+		for j := i - 1; j >= 0; j-- {
+			mailstore.RenameMailbox(context.Background(), renamer, renames[j][1], renames[j][0])
+		}
+		return err
+	}
+	return nil
+}
+
+// Keep the selected mailbox selected under its new name if it was renamed,
+// or moved along with a mailbox above it. When INBOX is renamed its messages
+// move to the new mailbox but INBOX itself stays, so it stays selected.
+func (c *Conn) followRename(renames [][2]string) {
+	if c.SelectedMailbox == nil || util.MailboxNamesEqual(c.SelectedMailbox.Name(), "INBOX") {
+		return
+	}
+	selected := c.SelectedMailbox.Name()
+	for _, rename := range renames {
+		if rename[0] != selected {
+			continue
+		}
+		mailbox, err := mailstore.MailboxByName(c.context(), c.User, rename[1])
+		if err != nil {
+			c.logf("Can't find renamed mailbox %q: %s\n", rename[1], err)
+			return
+		}
+		if view, ok := c.SelectedMailbox.(mailstore.SoftDeleteView); ok {
+			mailbox = view.Reload(mailbox)
+		}
+		c.SelectedMailbox = mailbox
+		if c.view.mailbox == selected {
+			c.view.mailbox = rename[1]
+		}
+		for i := range c.searchUpdates {
+			if c.searchUpdates[i].mailbox == selected {
+				c.searchUpdates[i].mailbox = rename[1]
+			}
+		}
+		c.registerSelection()
+		return
+	}
+}
+
+// Move the user's subscriptions to renamed mailboxes over to their new
+// names. The mailboxes have already been renamed, so a subscription which
+// can't be moved is only logged.
+func (c *Conn) moveSubscriptions(renames [][2]string) {
+	store, ok := c.User.(mailstore.SubscriptionStore)
+	if !ok {
+		return
+	}
+	subscriptions, err := mailstore.Subscriptions(c.context(), store)
+	if err != nil {
+		c.logf("Can't move subscriptions: %s\n", err)
+		return
+	}
+	subscribed := make(map[string]bool, len(subscriptions))
+	for _, name := range subscriptions {
+		subscribed[name] = true
+	}
+	for _, rename := range renames {
+		if !subscribed[rename[0]] || util.MailboxNamesEqual(rename[0], "INBOX") {
+			continue
+		}
+		if err := mailstore.Subscribe(c.context(), store, rename[1]); err != nil {
+			c.logf("Can't subscribe to renamed mailbox %q: %s\n", rename[1], err)
+			continue
+		}
+		if err := mailstore.Unsubscribe(c.context(), store, rename[0]); err != nil {
+			c.logf("Can't unsubscribe from renamed mailbox %q: %s\n", rename[0], err)
+		}
+	}
+}
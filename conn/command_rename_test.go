@@ -0,0 +1,201 @@
+package conn_test
+
+import (
+	"errors"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A user who can rename every mailbox but one
+type failingRenameUser struct {
+	mailstore.DummyUser
+	failing string
+}
+
+func (u failingRenameUser) RenameMailbox(oldName string, newName string) error {
+	if oldName == u.failing {
+		return errors.New("Disk full")
+	}
+	return u.DummyUser.RenameMailbox(oldName, newName)
+}
+
+var _ = Describe("RENAME Command", func() {
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should rename a mailbox", func() {
+			SendLine("abcd.123 RENAME Trash Bin")
+			ExpectResponse("abcd.123 OK RENAME completed")
+
+			_, err := mStore.User.MailboxByName("Trash")
+			Expect(err).To(HaveOccurred())
+			_, err = mStore.User.MailboxByName("Bin")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should rename the mailboxes beneath it", func() {
+			mStore.User.CreateMailbox("Trash/2015")
+			mStore.User.CreateMailbox("Trash/2015/June")
+
+			SendLine("abcd.123 RENAME Trash \"Old/Bin\"")
+			ExpectResponse("abcd.123 OK RENAME completed")
+
+			SendLine("abcd.124 LIST \"\" *")
+			ExpectResponse("* LIST () \"/\" \"INBOX\"")
+			ExpectResponse("* LIST () \"/\" \"Old/Bin\"")
+			ExpectResponse("* LIST () \"/\" \"Sent\"")
+			ExpectResponse("* LIST () \"/\" \"Old/Bin/2015\"")
+			ExpectResponse("* LIST () \"/\" \"Old/Bin/2015/June\"")
+			ExpectResponse("* LIST () \"/\" \"Old\"")
+			ExpectResponse("abcd.124 OK LIST completed")
+		})
+
+		It("should move the messages out of INBOX", func() {
+			mStore.User.CreateMailbox("INBOX/Old")
+
+			SendLine("abcd.123 RENAME INBOX Archive")
+			ExpectResponse("abcd.123 OK RENAME completed")
+
+			inbox, err := mStore.User.MailboxByName("INBOX")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inbox.Messages()).To(BeEquivalentTo(0))
+
+			archive, err := mStore.User.MailboxByName("Archive")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(archive.Messages()).To(BeEquivalentTo(3))
+			Expect(archive.MessageBySequenceNumber(1).UID()).To(BeEquivalentTo(10))
+
+			_, err = mStore.User.MailboxByName("INBOX/Old")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should move subscriptions to the new names", func() {
+			mStore.User.CreateMailbox("Trash/2015")
+			mStore.User.Subscribe("Trash/2015")
+
+			SendLine("abcd.123 RENAME Trash Bin")
+			ExpectResponse("abcd.123 OK RENAME completed")
+			Expect(mStore.User.Subscriptions()).To(ConsistOf("INBOX", "Sent", "Bin", "Bin/2015"))
+		})
+
+		It("should keep INBOX subscribed when it's renamed", func() {
+			SendLine("abcd.123 RENAME INBOX Archive")
+			ExpectResponse("abcd.123 OK RENAME completed")
+			Expect(mStore.User.Subscriptions()).To(ConsistOf("INBOX", "Trash", "Sent"))
+		})
+
+		Context("When a mailbox is selected", func() {
+			// Read the responses to a command up to the tagged one
+			readUntil := func(tagged string) []string {
+				var lines []string
+				for {
+					line, err := reader.ReadLine()
+					Expect(err).ToNot(HaveOccurred())
+					if line == tagged {
+						return lines
+					}
+					lines = append(lines, line)
+				}
+			}
+
+			// Append a message to a mailbox and return the responses to the
+			// NOOP which follows
+			appendAndNoop := func(mailbox string) []string {
+				SendLine("abcd.124 APPEND " + mailbox + " {15}")
+				ExpectResponse("+ go ahead, feed me your message")
+				SendLine("Subject: Hi")
+				SendLine("")
+				ExpectResponse("abcd.124 OK APPEND completed")
+
+				SendLine("abcd.125 NOOP")
+				return readUntil("abcd.125 OK NOOP completed")
+			}
+
+			BeforeEach(func() {
+				mStore.User.CreateMailbox("Trash/2015")
+			})
+
+			It("should keep the renamed mailbox selected", func() {
+				SendLine("abcd.122 SELECT Trash")
+				readUntil("abcd.122 OK [READ-WRITE] SELECT completed")
+				SendLine("abcd.123 RENAME Trash Bin")
+				ExpectResponse("abcd.123 OK RENAME completed")
+				Expect(appendAndNoop("Bin")).To(ContainElement("* 1 EXISTS"))
+			})
+
+			It("should keep a mailbox selected when a mailbox above it is renamed", func() {
+				SendLine("abcd.122 SELECT Trash/2015")
+				readUntil("abcd.122 OK [READ-WRITE] SELECT completed")
+				SendLine("abcd.123 RENAME Trash Bin")
+				ExpectResponse("abcd.123 OK RENAME completed")
+				Expect(appendAndNoop("Bin/2015")).To(ContainElement("* 1 EXISTS"))
+			})
+		})
+
+		It("should refuse to rename a mailbox which doesn't exist", func() {
+			SendLine("abcd.123 RENAME Archive Bin")
+			ExpectResponse("abcd.123 NO [NONEXISTENT] Mailbox does not exist")
+		})
+
+		It("should refuse to replace a mailbox", func() {
+			SendLine("abcd.123 RENAME Trash Sent")
+			ExpectResponse("abcd.123 NO [ALREADYEXISTS] Mailbox already exists")
+
+			SendLine("abcd.124 RENAME Trash inbox")
+			ExpectResponse("abcd.124 NO [ALREADYEXISTS] Mailbox already exists")
+		})
+
+		It("should refuse to move a mailbox beneath itself", func() {
+			SendLine("abcd.123 RENAME Trash Trash/Bin")
+			ExpectResponse("abcd.123 NO [CANNOT] Mailbox cannot be moved beneath itself")
+		})
+
+		Context("When the user can't rename mailboxes", func() {
+			BeforeEach(func() {
+				tConn.User = fixedMailboxesUser{mStore.User}
+			})
+
+			It("should refuse to rename them", func() {
+				SendLine("abcd.123 RENAME Trash Bin")
+				ExpectResponse("abcd.123 NO [CANNOT] Mailboxes cannot be renamed")
+			})
+		})
+
+		Context("When a mailbox can't be renamed", func() {
+			BeforeEach(func() {
+				mStore.User.CreateMailbox("Trash/2015")
+				mStore.User.CreateMailbox("Trash/2015/June")
+				tConn.User = failingRenameUser{mStore.User, "Trash"}
+			})
+
+			It("should put back the mailboxes beneath it", func() {
+				SendLine("abcd.123 RENAME Trash Bin")
+				ExpectResponse("abcd.123 NO [SERVERBUG] Internal server error")
+
+				for _, name := range []string{"Trash", "Trash/2015", "Trash/2015/June"} {
+					_, err := mStore.User.MailboxByName(name)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				_, err := mStore.User.MailboxByName("Bin/2015")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 RENAME Trash Bin")
+			ExpectResponse("abcd.123 BAD not authenticated")
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	replaceArgUID     int = 0
+	replaceArgMessage int = 1
+	replaceArgAppend  int = 2 // The remaining arguments are as for APPEND
+)
+
+// Handles REPLACE and UID REPLACE (RFC 8508), which append a new message
+// and expunge an existing one from the selected mailbox, typically to
+// update a draft
+func cmdReplace(args commandArgs, c *Conn) {
+	req, err := replaceRequest(args)
+	var old mailstore.Message
+	msg, mailbox, ok := c.receiveMessage(args.ID(), req.Append, err, func() bool {
+		if !c.assertWritable(args.ID()) {
+			return false
+		}
+		_, native := underlyingMailbox(c.SelectedMailbox).(mailstore.ReplaceMailbox)
+		_, expunge := underlyingMailbox(c.SelectedMailbox).(mailstore.ExpungeMailbox)
+		if !native && !expunge {
+			c.writeNo(args.ID(), codeCannot, c.text(MsgCannotExpunge))
+			return false
+		}
+		found, err := c.replacedMessage(req)
+		if err != nil {
+			c.writeError(args.ID(), err)
+			return false
+		}
+		old = found
+		if old == nil {
+			c.writeNo(args.ID(), codeNone, c.text(MsgNoSuchMessage))
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	oldSeqno, known := c.sequenceNumber(old)
+	if err := replaceMessage(c.context(), c.SelectedMailbox, old, msg); err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	// Bring the selected mailbox up to date with the replacement
+	c.reloadSelectedMailbox()
+	if mailbox.Name() == c.SelectedMailbox.Name() {
+		count, _ := mailstore.MessageCount(c.context(), c.SelectedMailbox)
+		c.writeResponse("", fmt.Sprintf("%d EXISTS", count+1))
+	}
+	if known {
+		c.writeExpunge(oldSeqno)
+	}
+	c.rememberMailbox()
+	c.announceChanges(c.SelectedMailbox.Name())
+	if mailbox.Name() != c.SelectedMailbox.Name() {
+		c.announceChanges(mailbox.Name())
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "REPLACE"))
+}
+
+// Save the replacement message and expunge the old one, atomically if the
+// mailbox supports it
+func replaceMessage(ctx context.Context, mailbox mailstore.Mailbox, old mailstore.Message, replacement mailstore.Message) error {
+	if native, ok := mailbox.(mailstore.ReplaceMailbox); ok {
+		if _, err := mailstore.Replace(ctx, native, old.UID(), replacement); !errors.Is(err, mailstore.ErrNotSupported) {
+			return err
+		}
+	}
+
+	if _, err := mailstore.SaveMessage(ctx, replacement); err != nil {
+		return err
+	}
+	return mailstore.Expunge(ctx, mailbox.(mailstore.ExpungeMailbox), []uint32{old.UID()})
+}
+
+// Find the message in the selected mailbox which a REPLACE command refers to
+func (c *Conn) replacedMessage(req types.ReplaceRequest) (mailstore.Message, error) {
+	if req.Message == 0 {
+		return nil, nil
+	}
+	if req.UID {
+		return mailstore.MessageByUID(c.context(), c.SelectedMailbox, req.Message)
+	}
+	return c.messageBySequenceNumber(req.Message)
+}
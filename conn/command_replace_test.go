@@ -0,0 +1,294 @@
+package conn_test
+
+import (
+	"strings"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A mailbox which can expunge messages but not replace them natively
+type expungeOnlyMailbox struct {
+	mailstore.Mailbox
+}
+
+func (m expungeOnlyMailbox) Expunge(uids []uint32) error {
+	return m.Mailbox.(mailstore.ExpungeMailbox).Expunge(uids)
+}
+
+// A user whose mailboxes can expunge messages but not replace them natively
+type expungeOnlyUser struct {
+	mailstore.User
+}
+
+func (u expungeOnlyUser) MailboxByName(name string) (mailstore.Mailbox, error) {
+	mailbox, err := u.User.MailboxByName(name)
+	return expungeOnlyMailbox{mailbox}, err
+}
+
+// A mailbox which can neither expunge nor replace messages
+type readOnlyStorageMailbox struct {
+	mailstore.Mailbox
+}
+
+var _ = Describe("REPLACE Command", func() {
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should replace a message within the selected mailbox", func() {
+			SendLine("abcd.123 REPLACE 1 INBOX (\\Draft) {28+}")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("* 4 EXISTS")
+			ExpectResponse("* 1 EXPUNGE")
+			ExpectResponse("abcd.123 OK REPLACE completed")
+
+			mbox, _ := tConn.User.MailboxByName("INBOX")
+			Expect(mbox.Messages()).To(Equal(uint32(3)))
+			Expect(mbox.MessageByUID(10)).To(BeNil())
+			Expect(mbox.MessageBySequenceNumber(3).Header().Get("Subject")).To(Equal("Draft v2"))
+		})
+
+		It("should replace a message by UID into another mailbox", func() {
+			SendLine("abcd.123 UID REPLACE 12 Trash {28}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("* 3 EXPUNGE")
+			ExpectResponse("abcd.123 OK REPLACE completed")
+
+			trash, _ := tConn.User.MailboxByName("Trash")
+			Expect(trash.Messages()).To(Equal(uint32(1)))
+		})
+
+		It("should append and expunge if the mailbox can't replace natively", func() {
+			tConn.SelectedMailbox = expungeOnlyMailbox{tConn.SelectedMailbox}
+			SendLine("abcd.123 REPLACE 2 INBOX {28+}")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("* 4 EXISTS")
+			ExpectResponse("* 2 EXPUNGE")
+			ExpectResponse("abcd.123 OK REPLACE completed")
+
+			mbox, _ := tConn.User.MailboxByName("INBOX")
+			Expect(mbox.MessageByUID(11)).To(BeNil())
+		})
+
+		It("should refuse to replace a message which doesn't exist", func() {
+			SendLine("abcd.123 REPLACE 9 INBOX {28}")
+			ExpectResponse("abcd.123 NO No such message")
+		})
+
+		It("should refuse to replace a message hidden behind a view if the mailbox can't expunge", func() {
+			tConn.SelectedMailbox = mailstore.NewSoftDeleteView(readOnlyStorageMailbox{tConn.SelectedMailbox})
+			SendLine("abcd.123 REPLACE 1 INBOX {28+}")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("abcd.123 NO [CANNOT] Messages cannot be expunged from this mailbox")
+
+			mbox, _ := tConn.User.MailboxByName("INBOX")
+			Expect(mbox.Messages()).To(Equal(uint32(3)))
+		})
+	})
+
+	Context("When combined with other extensions", func() {
+		type replaceCase struct {
+			description  string
+			command      string
+			continuation bool
+			appendLimit  uint32
+		}
+		cases := []replaceCase{
+			{"a synchronizing literal", "REPLACE 1 INBOX {28}", true, 0},
+			{"a non-synchronizing literal (LITERAL-)", "REPLACE 1 INBOX {28+}", false, 0},
+			{"a binary literal (BINARY)", "REPLACE 1 INBOX ~{28}", true, 0},
+			{"flags and a date", "REPLACE 1 INBOX (\\Seen \\Draft) \"21-Jun-2015 01:00:25 +0900\" {28+}", false, 0},
+			{"a UID (UIDPLUS)", "UID REPLACE 10 INBOX {28}", true, 0},
+			{"a message within the APPENDLIMIT", "REPLACE 1 INBOX {28}", true, 28},
+		}
+
+		var changeLog mailstore.ChangeLog
+		var modseq uint64
+
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+			changeLog = tConn.SelectedMailbox.(mailstore.ChangeLogMailbox).ChangeLog()
+			modseq, _ = changeLog.HighestModSeq()
+		})
+
+		for _, tc := range cases {
+			tc := tc
+			It("should replace a message sent with "+tc.description, func() {
+				tConn.AppendLimit = tc.appendLimit
+				SendLine("abcd.123 " + tc.command)
+				if tc.continuation {
+					ExpectResponse("+ go ahead, feed me your message")
+				}
+				SendLine("Subject: Draft v2")
+				SendLine("")
+				SendLine("Hello")
+				ExpectResponse("* 4 EXISTS")
+				ExpectResponse("* 1 EXPUNGE")
+				ExpectResponse("abcd.123 OK REPLACE completed")
+
+				// The append must be logged before the expunge, each with
+				// a new mod-sequence
+				changes, err := changeLog.Since(modseq)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(changes).To(HaveLen(2))
+				Expect(changes[0].Type).To(Equal(mailstore.ChangeAppend))
+				Expect(changes[0].UID).To(Equal(uint32(13)))
+				Expect(changes[0].ModSeq).To(BeNumerically(">", modseq))
+				Expect(changes[1].Type).To(Equal(mailstore.ChangeExpunge))
+				Expect(changes[1].UID).To(Equal(uint32(10)))
+				Expect(changes[1].ModSeq).To(BeNumerically(">", changes[0].ModSeq))
+
+				view, err := mailstore.ViewAt(changeLog, changes[1].ModSeq)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(view.SequenceNumber(10)).To(Equal(uint32(0)))
+				Expect(view.SequenceNumber(13)).To(Equal(uint32(3)))
+			})
+		}
+
+		It("should leave the mailbox untouched if the replacement is too big", func() {
+			tConn.AppendLimit = 27
+			SendLine("abcd.123 REPLACE 1 INBOX {28+}")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("abcd.123 NO [TOOBIG] message exceeds APPENDLIMIT")
+
+			changes, _ := changeLog.Since(modseq)
+			Expect(changes).To(BeEmpty())
+		})
+	})
+
+	Context("When CONDSTORE and QRESYNC are offered", func() {
+		type modseqCase struct {
+			enable   string // The extension enabled before replacing
+			command  string
+			expunged string // How the old message is reported as expunged
+		}
+		cases := []modseqCase{
+			{"", "REPLACE 1 INBOX {28+}", "* 1 EXPUNGE"},
+			{"", "UID REPLACE 10 INBOX {28+}", "* 1 EXPUNGE"},
+			{"CONDSTORE", "REPLACE 1 INBOX {28+}", "* 1 EXPUNGE"},
+			{"CONDSTORE", "UID REPLACE 10 INBOX {28+}", "* 1 EXPUNGE"},
+			{"QRESYNC", "REPLACE 1 INBOX {28+}", "* VANISHED 10"},
+			{"QRESYNC", "UID REPLACE 10 INBOX {28+}", "* VANISHED 10"},
+		}
+
+		BeforeEach(func() {
+			tConn.QResync = true
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		// Enable an extension, which is only possible before selecting a
+		// mailbox, then select the INBOX
+		enable := func(extension string) {
+			if extension != "" {
+				SendLine("abcd.121 ENABLE " + extension)
+				ExpectResponse("* ENABLED " + extension)
+				ExpectResponse("abcd.121 OK ENABLE completed")
+			}
+			SendLine("abcd.122 SELECT INBOX")
+			for {
+				response, err := reader.ReadLine()
+				Expect(err).ToNot(HaveOccurred())
+				if strings.HasPrefix(response, "abcd.122 ") {
+					Expect(response).To(Equal("abcd.122 OK [READ-WRITE] SELECT completed"))
+					return
+				}
+			}
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			It("should give the replacement a new mod-sequence with "+tc.command+" after ENABLE "+tc.enable, func() {
+				enable(tc.enable)
+				SendLine("abcd.123 " + tc.command)
+				SendLine("Subject: Draft v2")
+				SendLine("")
+				SendLine("Hello")
+				ExpectResponse("* 4 EXISTS")
+				ExpectResponse(tc.expunged)
+				ExpectResponse("abcd.123 OK REPLACE completed")
+
+				// The three original messages were logged with mod-sequences
+				// 1 to 3, then the append and the expunge with 4 and 5
+				SendLine("abcd.124 FETCH 3 (UID MODSEQ)")
+				ExpectResponse("* 3 FETCH (UID 13 MODSEQ (4))")
+				ExpectResponse("abcd.124 OK FETCH completed")
+			})
+		}
+
+		It("should report the old message as vanished when replacing into another mailbox", func() {
+			enable("QRESYNC")
+			SendLine("abcd.123 UID REPLACE 12 Trash {28+}")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("* VANISHED 12")
+			ExpectResponse("abcd.123 OK REPLACE completed")
+		})
+
+		It("should send the mod-sequence of the replacement when its flags are stored", func() {
+			enable("CONDSTORE")
+			SendLine("abcd.123 REPLACE 1 INBOX {28+}")
+			SendLine("Subject: Draft v2")
+			SendLine("")
+			SendLine("Hello")
+			ExpectResponse("* 4 EXISTS")
+			ExpectResponse("* 1 EXPUNGE")
+			ExpectResponse("abcd.123 OK REPLACE completed")
+
+			SendLine("abcd.124 STORE 3 +FLAGS (\\Seen)")
+			ExpectResponsePattern(`^\* 3 FETCH \(FLAGS \(.*\\Seen.*\) MODSEQ \(6\)\)$`)
+			ExpectResponse("abcd.124 OK STORE completed")
+		})
+
+		Context("and the mailbox can't replace natively", func() {
+			BeforeEach(func() {
+				tConn.User = expungeOnlyUser{mStore.User}
+			})
+
+			It("should still report the old message as vanished", func() {
+				enable("QRESYNC")
+				SendLine("abcd.123 REPLACE 2 INBOX {28+}")
+				SendLine("Subject: Draft v2")
+				SendLine("")
+				SendLine("Hello")
+				ExpectResponse("* 4 EXISTS")
+				ExpectResponse("* VANISHED 11")
+				ExpectResponse("abcd.123 OK REPLACE completed")
+			})
+		})
+	})
+
+	Context("When no mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 REPLACE 1 INBOX {28}")
+			ExpectResponse("abcd.123 BAD not selected")
+		})
+	})
+})
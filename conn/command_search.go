@@ -0,0 +1,229 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	searchArgUID      int = 0
+	searchArgReturn   int = 1
+	searchArgCharset  int = 2
+	searchArgCriteria int = 3
+)
+
+// Handles SEARCH and UID SEARCH, which return the sequence numbers (or
+// UIDs) of the messages in the selected mailbox matching the given criteria
+func cmdSearch(args commandArgs, c *Conn) {
+	req, err := searchRequest(args)
+	if err != nil {
+		c.logf("Search error: %s\n", err)
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+	if req.Return != nil && req.Return.Relevancy && !usesKey(req.Criteria, "FUZZY") {
+		c.writeBad(args.ID(), codeNone, c.text(MsgRelevancyNeedsFuzzy))
+		return
+	}
+	if usesKey(req.Criteria, "MODSEQ") {
+		if _, ok := c.enableCondStore(args.ID()); !ok {
+			return
+		}
+	}
+	msgs, ok := c.search(args.ID(), c.SelectedMailbox, req.Charset, req.Criteria, args.Arg(searchArgCriteria))
+	if !ok {
+		return
+	}
+	if !req.UID {
+		msgs = c.knownMessages(msgs)
+	}
+	modseq, err := searchModSeq(c.SelectedMailbox, req.Criteria, msgs)
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	if req.Return == nil {
+		c.writeResponse("", withModSeq(strings.TrimSpace("SEARCH "+c.formatResults(msgs, req.UID)), modseq))
+	} else {
+		var relevancy []int
+		if req.Return.Relevancy {
+			if relevancy, err = c.relevancy(c.SelectedMailbox, req.Criteria, msgs); err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+		}
+		c.writeESearch(tagCorrelator(args.ID()), req.UID, *req.Return, c.resultIDs(msgs, req.UID), relevancy, modseq)
+		if req.Return.Update {
+			c.watchSearch(args.ID(), req.UID, nil, req.Criteria, msgs)
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, req.Command()))
+}
+
+// Find the messages in a mailbox matching the criteria of a SEARCH, SORT or
+// ESEARCH, whose strings are in the given charset. If they can't be found,
+// the tagged response is written and false is returned.
+func (c *Conn) search(tag string, mailbox mailstore.Mailbox, charset string, criteria types.SearchCriteria, query string) ([]mailstore.Message, bool) {
+	if key, disabled := c.disabledSearchKey(criteria); disabled {
+		c.writeNo(tag, codeCannot, c.text(MsgCommandDisabled, "SEARCH "+key))
+		return nil, false
+	}
+	if charset != "" {
+		convert, ok := searchCharsets[strings.ToUpper(charset)]
+		if !ok {
+			c.writeNo(tag, codeBadCharset.with(searchCharsetList), c.text(MsgUnsupportedCharset, charset))
+			return nil, false
+		}
+		var err error
+		if criteria, err = convertSearchStrings(criteria, convert); err != nil {
+			c.writeResponse(tag, "BAD "+err.Error())
+			return nil, false
+		}
+		query = strings.ToUpper(charset) + " " + query
+	}
+
+	msgs, err := c.cachedSearch(mailbox, query, criteria, c.progressReporter(tag, MsgSearching))
+	if err != nil {
+		c.writeError(tag, err)
+		return nil, false
+	}
+	return msgs, true
+}
+
+// The sequence numbers, or UIDs, by which search results are sent to the
+// client. Messages the client hasn't been told of are left out of results
+// given by sequence number.
+func (c *Conn) resultIDs(msgs []mailstore.Message, uid bool) []uint32 {
+	ids := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		if uid {
+			ids = append(ids, msg.UID())
+		} else if seqno, known := c.sequenceNumber(msg); known {
+			ids = append(ids, seqno)
+		}
+	}
+	return ids
+}
+
+// Format search results for a SEARCH or SORT response, separated by spaces
+func (c *Conn) formatResults(msgs []mailstore.Message, uid bool) string {
+	results := make([]string, 0, len(msgs))
+	for _, id := range c.resultIDs(msgs, uid) {
+		results = append(results, fmt.Sprint(id))
+	}
+	return strings.Join(results, " ")
+}
+
+// Find the messages in a mailbox which match the search criteria, calling
+// progress (if not nil) before each message is checked. Mailboxes which
+// can search themselves are asked to; if they fail, every message is
+// checked here instead.
+func (c *Conn) searchMailbox(mailbox mailstore.Mailbox, criteria types.SearchCriteria, progress func(done int, total int)) ([]mailstore.Message, error) {
+	if searchable, ok := mailbox.(mailstore.SearchableMailbox); ok {
+		resolved, err := c.resolveSearchSets(mailbox, criteria)
+		if err != nil {
+			return nil, err
+		}
+		if uids, err := mailstore.Search(c.context(), searchable, resolved); err == nil {
+			return messagesByUID(c.context(), mailbox, uids)
+		}
+	}
+
+	ctx, err := c.searchContext(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	all, _ := types.InterpretSequenceSet("1:*")
+
+	msgs, err := mailstore.MessageSetBySequenceNumber(c.context(), mailbox, all)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]mailstore.Message, 0)
+	for i, msg := range msgs {
+		if progress != nil {
+			progress(i, len(msgs))
+		}
+		if search.Matches(criteria, c.searchMessage(mailbox, msg), ctx) {
+			matched = append(matched, msg)
+		}
+	}
+	return matched, nil
+}
+
+// Rewrite the keys of search criteria which depend on the client's view of
+// a mailbox, so that a mailbox searching itself needn't know it: sequence
+// sets become the UIDs of the messages the client means by them, and * in a
+// UID set becomes the mailbox's highest UID
+func (c *Conn) resolveSearchSets(mailbox mailstore.Mailbox, criteria types.SearchCriteria) (types.SearchCriteria, error) {
+	switch criteria.Key {
+	case "SEQUENCE":
+		var msgs []mailstore.Message
+		var err error
+		if c.isSelected(mailbox) {
+			msgs, err = c.messagesBySequenceSet(criteria.Set)
+		} else {
+			msgs, err = mailstore.MessageSetBySequenceNumber(c.context(), mailbox, criteria.Set)
+		}
+		if err != nil {
+			return criteria, err
+		}
+		uids := make([]uint32, 0, len(msgs))
+		for _, msg := range msgs {
+			if msg != nil {
+				uids = append(uids, msg.UID())
+			}
+		}
+		return types.SearchCriteria{Key: "UID", UIDs: types.NewUIDSet(uids)}, nil
+	case "UID":
+		criteria.UIDs = criteria.UIDs.Normalize(mailbox.LastUID())
+		return criteria, nil
+	}
+
+	if criteria.Children == nil {
+		return criteria, nil
+	}
+	children := make([]types.SearchCriteria, len(criteria.Children))
+	for i, child := range criteria.Children {
+		resolved, err := c.resolveSearchSets(mailbox, child)
+		if err != nil {
+			return criteria, err
+		}
+		children[i] = resolved
+	}
+	criteria.Children = children
+	return criteria, nil
+}
+
+// The state of a mailbox needed to search it
+func (c *Conn) searchContext(mailbox mailstore.Mailbox) (search.Context, error) {
+	count, err := mailstore.MessageCount(c.context(), mailbox)
+	if err != nil {
+		return search.Context{}, err
+	}
+	if c.isSelected(mailbox) && c.viewCurrent() {
+		count = uint32(len(c.view.uids))
+	}
+	return search.Context{
+		LastSequenceNumber: count,
+		LastUID:            mailbox.LastUID(),
+		Ranker:             c.fuzzyRanker(mailbox),
+	}, nil
+}
+
+// Look up the messages with the given UIDs, in sequence number order
+func messagesByUID(ctx context.Context, mailbox mailstore.Mailbox, uids []uint32) ([]mailstore.Message, error) {
+	if len(uids) == 0 {
+		return []mailstore.Message{}, nil
+	}
+	sorted := append([]uint32(nil), uids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return mailstore.MessageSetByUID(ctx, mailbox, types.NewUIDSet(sorted))
+}
@@ -0,0 +1,452 @@
+package conn_test
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A mailbox which counts how many times its messages are listed
+type countingMailbox struct {
+	mailstore.DummyMailbox
+	listed *int32
+}
+
+func (m countingMailbox) MessageSetBySequenceNumber(set types.SequenceSet) []mailstore.Message {
+	atomic.AddInt32(m.listed, 1)
+	return m.DummyMailbox.MessageSetBySequenceNumber(set)
+}
+
+// A user whose mailboxes count how many times their messages are listed
+type countingUser struct {
+	mailstore.User
+	listed *int32
+}
+
+func (u countingUser) MailboxByName(name string) (mailstore.Mailbox, error) {
+	mailbox, err := u.User.MailboxByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingMailbox{mailbox.(mailstore.DummyMailbox), u.listed}, nil
+}
+
+// A mailbox which searches itself, returning fixed results
+type indexedMailbox struct {
+	mailstore.DummyMailbox
+	uids []uint32
+	err  error
+}
+
+func (m indexedMailbox) Search(criteria types.SearchCriteria) ([]uint32, error) {
+	return m.uids, m.err
+}
+
+// A mailbox which searches itself, recording the criteria it was given
+type recordingMailbox struct {
+	mailstore.DummyMailbox
+	searched *types.SearchCriteria
+}
+
+func (m recordingMailbox) Search(criteria types.SearchCriteria) ([]uint32, error) {
+	*m.searched = criteria
+	return []uint32{11}, nil
+}
+
+// A mailbox with a full-text index which ranks messages by fixed scores
+type rankedMailbox struct {
+	mailstore.DummyMailbox
+	scores map[uint32]int
+}
+
+func (m rankedMailbox) Rank(key types.SearchCriteria, uid uint32) (int, error) {
+	return m.scores[uid], nil
+}
+
+var _ = Describe("SEARCH Command", func() {
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		searches := []struct {
+			criteria string
+			results  string
+		}{
+			{"ALL", " 1 2 3"},
+			{"2:*", " 2 3"},
+			{"UID 11,12", " 2 3"},
+			{"SUBJECT \"test EMAIL\"", " 1 2"},
+			{"NOT SUBJECT another", " 1 3"},
+			{"OR BODY regards TEXT hello", " 1 3"},
+			{"HEADER Message-ID <12@test.com>", " 3"},
+			{"FROM me@test.com (TO you LARGER 150 SMALLER 155)", " 1"},
+			{"NEW", " 1 2 3"},
+			{"SEEN", ""},
+			{"SINCE 28-Oct-2014 BEFORE 29-Oct-2014", " 1 2 3"},
+			{"ON 1-Jan-2015", ""},
+			{"SENTON 28-Oct-2014", " 1 2 3"},
+			{"SAVEDSINCE 1-Jan-2015", ""},
+			{"SAVEDATESUPPORTED", " 1 2 3"},
+		}
+		for _, search := range searches {
+			search := search
+			It("should search for "+search.criteria, func() {
+				SendLine("abcd.123 SEARCH " + search.criteria)
+				ExpectResponse("* SEARCH" + search.results)
+				ExpectResponse("abcd.123 OK SEARCH completed")
+			})
+		}
+
+		It("should return UIDs for UID SEARCH", func() {
+			SendLine("abcd.123 UID SEARCH 2:* NOT UID 12")
+			ExpectResponse("* SEARCH 11")
+			ExpectResponse("abcd.123 OK UID SEARCH completed")
+		})
+
+		It("should match the same messages with SEARCH and UID SEARCH", func() {
+			SendLine("abcd.123 SEARCH SUBJECT email")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+			SendLine("abcd.124 uid search SUBJECT email")
+			ExpectResponse("* SEARCH 10 11 12")
+			ExpectResponse("abcd.124 OK UID SEARCH completed")
+		})
+
+		It("should accept a supported charset", func() {
+			SendLine("abcd.123 SEARCH CHARSET UTF-8 SUBJECT another")
+			ExpectResponse("* SEARCH 2")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+
+		It("should reject an unknown charset", func() {
+			SendLine("abcd.123 SEARCH CHARSET KOI8-R SUBJECT another")
+			ExpectResponse("abcd.123 NO [BADCHARSET (UTF-8 US-ASCII ISO-8859-1)] Unsupported charset KOI8-R")
+		})
+
+		It("should reject strings which aren't valid in the charset", func() {
+			SendLine("abcd.123 SEARCH CHARSET US-ASCII SUBJECT {7}")
+			ExpectResponse("+ Ready for additional command text")
+			SendLine("Grüße")
+			ExpectResponse("abcd.123 BAD Search string is not valid US-ASCII")
+		})
+
+		It("should accept command lines longer than 64KB", func() {
+			SendLine("abcd.123 SEARCH SUBJECT email" + strings.Repeat(" NOT SUBJECT nothing", 4000))
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+
+		It("should explain invalid criteria", func() {
+			SendLine("abcd.123 SEARCH SINCE yesterday")
+			ExpectResponse("abcd.123 BAD Unexpected \"yesterday\" at position 6 of search criteria, expected a date such as 1-Feb-1994")
+		})
+	})
+
+	Context("When RETURN options are given", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		searches := []struct {
+			command string
+			result  string
+		}{
+			{"SEARCH RETURN (MIN MAX COUNT) SUBJECT email", "MIN 1 MAX 3 COUNT 3"},
+			{"UID SEARCH RETURN () ALL", "UID ALL 10:12"},
+			{"SEARCH RETURN (ALL) NOT UID 11", "ALL 1,3"},
+			{"SEARCH RETURN (MIN COUNT) SEEN", "COUNT 0"},
+			{"SEARCH RETURN (PARTIAL 2:5) ALL", "PARTIAL (2:5 2:3)"},
+			{"UID SEARCH RETURN (PARTIAL 5:9) ALL", "UID PARTIAL (5:9 NIL)"},
+			{"SEARCH RETURN (CONTEXT COUNT) CHARSET UTF-8 SUBJECT another", "COUNT 1"},
+		}
+		for _, search := range searches {
+			search := search
+			It("should return "+search.result+" for "+search.command, func() {
+				SendLine("abcd.123 " + search.command)
+				ExpectResponse("* ESEARCH (TAG \"abcd.123\") " + search.result)
+				ExpectResponsePattern("^abcd.123 OK (UID )?SEARCH completed$")
+			})
+		}
+
+		It("should reject a PARTIAL range starting at 0", func() {
+			SendLine("abcd.123 SEARCH RETURN (PARTIAL 0:10) ALL")
+			ExpectResponse("abcd.123 BAD Invalid PARTIAL range 0:10")
+		})
+
+		It("should reject unknown options", func() {
+			SendLine("abcd.123 SEARCH RETURN (SAVE) ALL")
+			ExpectResponse("abcd.123 BAD Unknown RETURN option SAVE")
+		})
+	})
+
+	Context("When searching with FUZZY", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should match misspelt words", func() {
+			SendLine("abcd.123 SEARCH FUZZY SUBJECT emall")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+
+		It("should return the relevancy of each result", func() {
+			SendLine("abcd.123 SEARCH RETURN (RELEVANCY) OR FUZZY SUBJECT anther FUZZY SUBJECT last")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") ALL 2:3 RELEVANCY (50 100)")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+
+		It("should sort by relevancy", func() {
+			SendLine("abcd.123 SORT (REVERSE RELEVANCY) UTF-8 OR FUZZY SUBJECT anther FUZZY SUBJECT last")
+			ExpectResponse("* SORT 3 2")
+			ExpectResponse("abcd.123 OK SORT completed")
+		})
+
+		It("should refuse RELEVANCY without a FUZZY key", func() {
+			SendLine("abcd.123 SEARCH RETURN (RELEVANCY) SUBJECT email")
+			ExpectResponse("abcd.123 BAD RELEVANCY requires a FUZZY search key")
+		})
+
+		Context("and a ranker is configured", func() {
+			BeforeEach(func() {
+				tConn.FuzzyRanker = func(key types.SearchCriteria, m *search.Message) int {
+					return int(m.UID) - 9
+				}
+			})
+
+			It("should use its scores", func() {
+				SendLine("abcd.123 UID SEARCH RETURN (RELEVANCY) FUZZY TEXT anything")
+				ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ALL 10:12 RELEVANCY (1 2 3)")
+				ExpectResponse("abcd.123 OK UID SEARCH completed")
+			})
+		})
+
+		Context("and the mailbox has a full-text index", func() {
+			BeforeEach(func() {
+				inbox := tConn.SelectedMailbox.(mailstore.DummyMailbox)
+				tConn.SelectedMailbox = rankedMailbox{inbox, map[uint32]int{11: 80}}
+			})
+
+			It("should use the index's scores", func() {
+				SendLine("abcd.123 SEARCH RETURN (RELEVANCY) FUZZY BODY anything")
+				ExpectResponse("* ESEARCH (TAG \"abcd.123\") ALL 2 RELEVANCY (80)")
+				ExpectResponse("abcd.123 OK SEARCH completed")
+			})
+		})
+	})
+
+	Context("When results are kept up to date", func() {
+		var inbox mailstore.Mailbox
+
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+			inbox = tConn.SelectedMailbox
+		})
+
+		// Let the connection see the mailbox before anything changes it
+		JustBeforeEach(func() {
+			SendLine("abcd.100 NOOP")
+			ExpectResponse("abcd.100 OK NOOP completed")
+		})
+
+		It("should report messages which no longer match by UID", func() {
+			SendLine("abcd.123 UID SEARCH RETURN (UPDATE) UNSEEN")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ALL 10:12")
+			ExpectResponse("abcd.123 OK UID SEARCH completed")
+
+			inbox.MessageByUID(11).AddFlags(types.FlagSeen).Save()
+			inbox.(mailstore.ExpungeMailbox).Expunge([]uint32{12})
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("* 3 EXPUNGE")
+			ExpectResponsePattern("^\\* 2 FETCH \\(FLAGS \\(\\\\Seen.*\\)\\)$")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID REMOVEFROM (3 12) REMOVEFROM (2 11)")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should report messages which no longer match by sequence number", func() {
+			SendLine("abcd.123 SEARCH RETURN (COUNT UPDATE) UNSEEN")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") COUNT 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+
+			inbox.MessageByUID(11).AddFlags(types.FlagSeen).Save()
+			SendLine("abcd.124 NOOP")
+			ExpectResponsePattern("^\\* 2 FETCH \\(FLAGS \\(\\\\Seen.*\\)\\)$")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") REMOVEFROM (2 2)")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should report new messages which match", func() {
+			SendLine("abcd.123 UID SEARCH RETURN (UPDATE) SUBJECT email")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ALL 10:12")
+			ExpectResponse("abcd.123 OK UID SEARCH completed")
+
+			message := inbox.MessageBySequenceNumber(1)
+			inbox.NewMessage().SetHeaders(message.Header()).SetBody(message.Body()).Save()
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("* 4 EXISTS")
+			ExpectResponsePattern("^\\* [0-9]+ RECENT$")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ADDTO (4 13)")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should stop reporting changes once cancelled", func() {
+			SendLine("abcd.123 UID SEARCH RETURN (UPDATE) UNSEEN")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ALL 10:12")
+			ExpectResponse("abcd.123 OK UID SEARCH completed")
+			SendLine("abcd.124 CANCELUPDATE \"abcd.123\"")
+			ExpectResponse("abcd.124 OK CANCELUPDATE completed")
+
+			inbox.MessageByUID(11).AddFlags(types.FlagSeen).Save()
+			SendLine("abcd.125 NOOP")
+			ExpectResponsePattern("^\\* 2 FETCH \\(FLAGS \\(\\\\Seen.*\\)\\)$")
+			ExpectResponse("abcd.125 OK NOOP completed")
+		})
+
+		It("should refuse to cancel an unknown search", func() {
+			SendLine("abcd.124 CANCELUPDATE \"abcd.123\"")
+			ExpectResponse("abcd.124 BAD Unknown search tag \"abcd.123\"")
+		})
+
+		It("should refuse to report changes to MIN or MAX", func() {
+			SendLine("abcd.123 SEARCH RETURN (MIN UPDATE) ALL")
+			ExpectResponse("abcd.123 BAD UPDATE can't be combined with MIN or MAX")
+		})
+	})
+
+	Context("When the mailbox can search itself", func() {
+		var inbox mailstore.DummyMailbox
+
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			inbox = tConn.User.Mailboxes()[0].(mailstore.DummyMailbox)
+		})
+
+		It("should use the mailbox's results", func() {
+			tConn.SelectedMailbox = indexedMailbox{inbox, []uint32{12, 10}, nil}
+			SendLine("abcd.123 SEARCH SUBJECT nothing")
+			ExpectResponse("* SEARCH 1 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+
+		Context("and the criteria refer to sequence numbers", func() {
+			var searched types.SearchCriteria
+
+			BeforeEach(func() {
+				searched = types.SearchCriteria{}
+				tConn.SelectedMailbox = recordingMailbox{inbox, &searched}
+			})
+
+			It("should give the mailbox UIDs instead", func() {
+				SendLine("abcd.123 SEARCH 2:* NOT 1")
+				ExpectResponse("* SEARCH 2")
+				ExpectResponse("abcd.123 OK SEARCH completed")
+				Expect(searched.Children[0].Key).To(Equal("UID"))
+				Expect(searched.Children[0].UIDs.String()).To(Equal("11:12"))
+				Expect(searched.Children[1].Children[0].UIDs.String()).To(Equal("10"))
+			})
+
+			It("should give the mailbox its highest UID for *", func() {
+				SendLine("abcd.123 UID SEARCH UID 11:*")
+				ExpectResponse("* SEARCH 11")
+				ExpectResponse("abcd.123 OK UID SEARCH completed")
+				Expect(searched.Children[0].UIDs.String()).To(Equal("11:12"))
+			})
+		})
+
+		It("should search each message if the mailbox fails", func() {
+			tConn.SelectedMailbox = indexedMailbox{inbox, nil, errors.New("Index unavailable")}
+			SendLine("abcd.123 SEARCH SUBJECT another")
+			ExpectResponse("* SEARCH 2")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+	})
+
+	Context("When searches are repeated", func() {
+		var listed int32
+
+		BeforeEach(func() {
+			listed = 0
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = countingUser{mStore.User, &listed}
+		})
+
+		JustBeforeEach(func() {
+			SendLine("abcd.122 SELECT INBOX")
+			Expect(ReadUntilTagged("abcd.122")).To(Equal("abcd.122 OK [READ-WRITE] SELECT completed"))
+		})
+
+		It("should reuse the results while the mailbox is unchanged", func() {
+			SendLine("abcd.123 SEARCH UNSEEN")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+			searched := atomic.LoadInt32(&listed)
+
+			SendLine("abcd.124 SEARCH UNSEEN")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.124 OK SEARCH completed")
+			Expect(atomic.LoadInt32(&listed)).To(Equal(searched))
+		})
+
+		It("should search again once the mailbox has changed", func() {
+			SendLine("abcd.123 SEARCH UNSEEN")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+			SendLine("abcd.124 STORE 2 +FLAGS.SILENT (\\Seen)")
+			ExpectResponse("abcd.124 OK STORE completed")
+			stored := atomic.LoadInt32(&listed)
+
+			SendLine("abcd.125 SEARCH UNSEEN")
+			ExpectResponse("* SEARCH 1 3")
+			ExpectResponse("abcd.125 OK SEARCH completed")
+			Expect(atomic.LoadInt32(&listed)).To(BeNumerically(">", stored))
+		})
+	})
+
+	Context("When progress is reported", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+			tConn.ProgressInterval = time.Nanosecond
+		})
+
+		It("should send INPROGRESS responses while searching", func() {
+			SendLine("abcd.123 SEARCH SUBJECT email")
+			ExpectResponse("* OK [INPROGRESS (\"abcd.123\" 0 3)] Searching")
+			ExpectResponse("* OK [INPROGRESS (\"abcd.123\" 1 3)] Searching")
+			ExpectResponse("* OK [INPROGRESS (\"abcd.123\" 2 3)] Searching")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+	})
+
+	Context("When no mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 SEARCH ALL")
+			ExpectResponse("abcd.123 BAD not selected")
+		})
+	})
+})
@@ -1,21 +1,87 @@
 package conn
 
-import "fmt"
+import (
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	selectArgMailbox int = 0
+	selectArgParams  int = 1
+)
 
 func cmdSelect(args commandArgs, c *Conn) {
-	if !c.assertAuthenticated(args.ID()) {
-		return
+	req, err := selectRequest(args, false)
+	selectMailbox(args, c, req, err)
+}
+
+// Open a mailbox for SELECT or EXAMINE, with the access the request asks for
+func selectMailbox(args commandArgs, c *Conn, req types.SelectRequest, parseErr error) {
+	// Whether or not the new mailbox can be opened, the old one is closed.
+	// Clients which may use QRESYNC are told where the old mailbox's
+	// responses end (RFC 7162 section 3.2.11).
+	if c.state == StateSelected {
+		c.SetState(StateAuthenticated)
+		if c.QResync {
+			c.writeOK("", codeClosed, c.text(MsgPreviousMailboxClosed))
+		}
 	}
 
-	var err error
-	c.SelectedMailbox, err = c.User.MailboxByName(args.Arg(0))
+	if parseErr != nil {
+		c.writeResponse(args.ID(), "BAD "+parseErr.Error())
+		return
+	}
+	if (req.CondStore || req.QResync != nil) && !c.QResync {
+		c.writeBad(args.ID(), codeNone, c.text(MsgCondStoreUnsupported))
+		return
+	}
+	if req.QResync != nil && !c.qresyncEnabled {
+		c.writeBad(args.ID(), codeNone, c.text(MsgQResyncNotEnabled))
+		return
+	}
+	if req.CondStore {
+		c.condStoreEnabled = true
+	}
+	var writable WriteMode = ReadWrite
+	if req.ReadOnly {
+		writable = ReadOnly
+	}
+	mailbox, err := mailstore.MailboxByName(c.context(), c.User, req.Mailbox)
 	if err != nil {
-		fmt.Fprintf(c, "%s NO %s\r\n", args.ID(), err)
+		c.writeNo(args.ID(), codeNonexistent, err.Error())
+		return
+	}
+	if isNoselect(mailbox) {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgCannotSelect))
+		return
+	}
+	if _, err := mailstore.MessageCount(c.context(), mailbox); err != nil {
+		c.writeError(args.ID(), err)
 		return
 	}
+	c.SelectedMailbox = openMailbox(mailbox)
 	c.SetState(StateSelected)
-	c.SetReadWrite()
+	c.recent = nil
+	c.searchUpdates = nil
+	if writable == ReadWrite {
+		c.SetReadWrite()
+		c.claimRecent()
+	}
 
-	writeMailboxInfo(c, c.SelectedMailbox)
-	c.writeResponse(args.ID(), "OK [READ-WRITE] SELECT completed")
+	writeMailboxInfo(c, c.SelectedMailbox, writable)
+	if c.QResync {
+		c.writeHighestModSeq()
+	}
+	c.rememberMailbox()
+	if req.QResync != nil {
+		if err := c.writeQResync(*req.QResync); err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
+	}
+	if writable == ReadWrite {
+		c.writeOK(args.ID(), codeReadWrite, c.text(MsgCompleted, req.Command()))
+	} else {
+		c.writeOK(args.ID(), codeReadOnly, c.text(MsgCompleted, req.Command()))
+	}
 }
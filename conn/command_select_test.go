@@ -2,9 +2,30 @@ package conn_test
 
 import (
 	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
 	. "github.com/onsi/ginkgo"
 )
 
+// A mailbox whose UIDs aren't kept between sessions
+type volatileUIDMailbox struct {
+	mailstore.Mailbox
+}
+
+func (m volatileUIDMailbox) UIDsNotSticky() bool {
+	return true
+}
+
+// A user whose mailboxes all have volatile UIDs
+type volatileUIDUser struct {
+	mailstore.User
+}
+
+func (u volatileUIDUser) MailboxByName(name string) (mailstore.Mailbox, error) {
+	mailbox, err := u.User.MailboxByName(name)
+	return volatileUIDMailbox{mailbox}, err
+}
+
 var _ = Describe("SELECT Command", func() {
 	Context("When logged in", func() {
 		BeforeEach(func() {
@@ -16,10 +37,139 @@ var _ = Describe("SELECT Command", func() {
 			SendLine("abcd.123 SELECT INBOX")
 			ExpectResponse("* 3 EXISTS")
 			ExpectResponse("* 3 RECENT")
-			ExpectResponse("* OK [UNSEEN 3]")
-			ExpectResponse("* OK [UIDNEXT 13]")
-			ExpectResponse("* OK [UIDVALIDITY 250]")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
 			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
+			ExpectResponse("abcd.123 OK [READ-WRITE] SELECT completed")
+		})
+
+		It("should point to the first unseen message", func() {
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			inbox.MessageBySequenceNumber(1).AddFlags(types.FlagSeen).Save()
+
+			SendLine("abcd.123 SELECT INBOX")
+			ExpectResponse("* 3 EXISTS")
+			ExpectResponse("* 3 RECENT")
+			ExpectResponse("* OK [UNSEEN 2] First unseen message")
+		})
+
+		It("should leave out UNSEEN when every message has been seen", func() {
+			SendLine("abcd.123 SELECT Trash")
+			ExpectResponse("* 0 EXISTS")
+			ExpectResponse("* 0 RECENT")
+			ExpectResponse("* OK [UIDNEXT 10] Predicted next UID")
+		})
+
+		It("should warn when UIDs won't persist between sessions", func() {
+			tConn.User = volatileUIDUser{mStore.User}
+			SendLine("abcd.123 SELECT Trash")
+			ExpectResponse("* 0 EXISTS")
+			ExpectResponse("* 0 RECENT")
+			ExpectResponse("* OK [UIDNEXT 10] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+			ExpectResponse("* NO [UIDNOTSTICKY] Non-persistent UIDs")
+		})
+
+		It("should refuse a mailbox which doesn't exist", func() {
+			SendLine("abcd.123 SELECT Nonexistent")
+			ExpectResponse("abcd.123 NO [NONEXISTENT] Invalid mailbox")
+		})
+	})
+
+	Context("When CONDSTORE and QRESYNC are offered", func() {
+		BeforeEach(func() {
+			tConn.QResync = true
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should send the highest mod-sequence of the mailbox", func() {
+			SendLine("abcd.123 SELECT INBOX")
+			ExpectResponse("* 3 EXISTS")
+			ExpectResponse("* 3 RECENT")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
+			ExpectResponse("* OK [HIGHESTMODSEQ 3] Highest mod-sequence")
+			ExpectResponse("abcd.123 OK [READ-WRITE] SELECT completed")
+		})
+
+		Context("and the mailbox keeps no change log", func() {
+			BeforeEach(func() {
+				tConn.User = volatileUIDUser{mStore.User}
+			})
+
+			It("should say that the mailbox has no mod-sequences", func() {
+				SendLine("abcd.123 EXAMINE Trash")
+				ExpectResponse("* 0 EXISTS")
+				ExpectResponse("* 0 RECENT")
+				ExpectResponse("* OK [UIDNEXT 10] Predicted next UID")
+				ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+				ExpectResponse("* NO [UIDNOTSTICKY] Non-persistent UIDs")
+				ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+				ExpectResponse("* OK [PERMANENTFLAGS ()] No permanent flags permitted")
+				ExpectResponse("* OK [NOMODSEQ] Mod-sequences are not supported for this mailbox")
+				ExpectResponse("abcd.123 OK [READ-ONLY] EXAMINE completed")
+			})
+		})
+	})
+
+	Context("When deleted messages are hidden in the mailbox", func() {
+		BeforeEach(func() {
+			user := mStore.User
+			user.SoftDeleteMailboxes = []string{"INBOX"}
+			inbox, _ := user.MailboxByName("INBOX")
+			inbox.MessageByUID(11).AddFlags(types.FlagDeleted).Save()
+
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = user
+		})
+
+		It("should leave deleted messages out of the mailbox", func() {
+			SendLine("abcd.123 SELECT INBOX")
+			ExpectResponse("* 2 EXISTS")
+			ExpectResponse("* 2 RECENT")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
+			ExpectResponse("abcd.123 OK [READ-WRITE] SELECT completed")
+
+			SendLine("abcd.124 FETCH 2 (UID)")
+			ExpectResponse("* 2 FETCH (UID 12)")
+			ExpectResponse("abcd.124 OK FETCH completed")
+		})
+
+		Context("When the mailbox is selected", func() {
+			BeforeEach(func() {
+				tConn.SetState(conn.StateSelected)
+				tConn.SetReadWrite()
+				inbox, _ := tConn.User.MailboxByName("INBOX")
+				tConn.SelectedMailbox = mailstore.NewSoftDeleteView(inbox)
+			})
+
+			It("should keep showing messages deleted after selecting", func() {
+				SendLine("abcd.123 STORE 1 +FLAGS.SILENT (\\Deleted)")
+				ExpectResponse("abcd.123 OK STORE completed")
+				SendLine("abcd.124 FETCH 1:* (UID)")
+				ExpectResponse("* 1 FETCH (UID 10)")
+				ExpectResponse("* 2 FETCH (UID 12)")
+				ExpectResponse("abcd.124 OK FETCH completed")
+
+				SendLine("abcd.125 EXAMINE INBOX")
+				ExpectResponse("* 1 EXISTS")
+			})
+
+			It("should search by the sequence numbers of the visible messages", func() {
+				SendLine("abcd.123 UID SEARCH 2")
+				ExpectResponse("* SEARCH 12")
+				ExpectResponse("abcd.123 OK UID SEARCH completed")
+			})
 		})
 	})
 
@@ -0,0 +1,86 @@
+package conn
+
+import (
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	sortArgUID      int = 0
+	sortArgReturn   int = 1
+	sortArgOrder    int = 2
+	sortArgCharset  int = 3
+	sortArgCriteria int = 4
+)
+
+// Handles SORT and UID SORT (RFC 5256), which return the messages matching
+// the given criteria in the order given by the sort keys
+func cmdSort(args commandArgs, c *Conn) {
+	req, err := sortRequest(args)
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+	if usesKey(req.Criteria, "MODSEQ") {
+		if _, ok := c.enableCondStore(args.ID()); !ok {
+			return
+		}
+	}
+	msgs, ok := c.search(args.ID(), c.SelectedMailbox, req.Charset, req.Criteria, args.Arg(sortArgCriteria))
+	if !ok {
+		return
+	}
+	if msgs, err = c.sortMessages(msgs, req.Order, req.Criteria); err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	modseq, err := searchModSeq(c.SelectedMailbox, req.Criteria, msgs)
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+
+	if req.Return == nil {
+		c.writeResponse("", withModSeq(strings.TrimSpace("SORT "+c.formatResults(msgs, req.UID)), modseq))
+	} else {
+		c.writeESearch(tagCorrelator(args.ID()), req.UID, *req.Return, c.resultIDs(msgs, req.UID), nil, modseq)
+		if req.Return.Update {
+			c.watchSearch(args.ID(), req.UID, req.Order, req.Criteria, msgs)
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, req.Command()))
+}
+
+// Order messages by the keys of a SORT command. The RELEVANCY key orders
+// them by how relevant they are to the FUZZY keys of the search criteria.
+func (c *Conn) sortMessages(msgs []mailstore.Message, order []types.SortCriterion, criteria types.SearchCriteria) ([]mailstore.Message, error) {
+	var relevancy []int
+	for _, criterion := range order {
+		if criterion.Key == "RELEVANCY" && relevancy == nil {
+			var err error
+			if relevancy, err = c.relevancy(c.SelectedMailbox, criteria, msgs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	byUID := make(map[uint32]mailstore.Message, len(msgs))
+	searchMsgs := make([]*search.Message, len(msgs))
+	for i, msg := range msgs {
+		byUID[msg.UID()] = msg
+		searchMsgs[i] = c.searchMessage(c.SelectedMailbox, msg)
+		if relevancy != nil {
+			searchMsgs[i].Relevancy = relevancy[i]
+		}
+	}
+	search.Sort(searchMsgs, order)
+
+	sorted := make([]mailstore.Message, len(searchMsgs))
+	for i, msg := range searchMsgs {
+		sorted[i] = byUID[msg.UID]
+	}
+	return sorted, nil
+}
@@ -0,0 +1,75 @@
+package conn_test
+
+import (
+	"net/textproto"
+
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("SORT Command", func() {
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		sorts := []struct {
+			command string
+			result  string
+		}{
+			{"SORT (SUBJECT) UTF-8 ALL", "* SORT 2 3 1"},
+			{"UID SORT (REVERSE SUBJECT) UTF-8 ALL", "* SORT 10 12 11"},
+			{"SORT (ARRIVAL) US-ASCII SUBJECT email", "* SORT 1 2 3"},
+			{"SORT (SUBJECT) UTF-8 SEEN", "* SORT"},
+			{"SORT RETURN (MIN MAX COUNT) (SUBJECT) UTF-8 ALL", "* ESEARCH (TAG \"abcd.123\") MIN 2 MAX 1 COUNT 3"},
+			{"UID SORT RETURN (ALL) (SUBJECT) UTF-8 ALL", "* ESEARCH (TAG \"abcd.123\") UID ALL 11:12,10"},
+			{"SORT RETURN (PARTIAL 1:2) (SUBJECT) UTF-8 ALL", "* ESEARCH (TAG \"abcd.123\") PARTIAL (1:2 2:3)"},
+		}
+		for _, sort := range sorts {
+			sort := sort
+			It("should return "+sort.result+" for "+sort.command, func() {
+				SendLine("abcd.123 " + sort.command)
+				ExpectResponse(sort.result)
+				ExpectResponsePattern("^abcd.123 OK (UID )?SORT completed$")
+			})
+		}
+
+		It("should reject unknown sort keys", func() {
+			SendLine("abcd.123 SORT (BODY) UTF-8 ALL")
+			ExpectResponse("abcd.123 BAD Unknown sort key BODY")
+		})
+
+		It("should reject an unknown charset", func() {
+			SendLine("abcd.123 SORT (DATE) KOI8-R ALL")
+			ExpectResponse("abcd.123 NO [BADCHARSET (UTF-8 US-ASCII ISO-8859-1)] Unsupported charset KOI8-R")
+		})
+
+		It("should report new messages at their place in the sort order", func() {
+			SendLine("abcd.123 UID SORT RETURN (UPDATE) (SUBJECT) UTF-8 ALL")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ALL 11:12,10")
+			ExpectResponse("abcd.123 OK UID SORT completed")
+
+			inbox := tConn.SelectedMailbox
+			inbox.NewMessage().SetHeaders(textproto.MIMEHeader{"Subject": {"Re: Better email"}}).SetBody("Body").Save()
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("* 4 EXISTS")
+			ExpectResponsePattern("^\\* [0-9]+ RECENT$")
+			ExpectResponse("* ESEARCH (TAG \"abcd.123\") UID ADDTO (2 13)")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+	})
+
+	Context("When no mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 SORT (DATE) UTF-8 ALL")
+			ExpectResponse("abcd.123 BAD not selected")
+		})
+	})
+})
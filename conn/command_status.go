@@ -1,19 +1,83 @@
 package conn
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+const (
+	statusArgMailbox int = 0
+	statusArgItems   int = 1
+)
 
 func cmdStatus(args commandArgs, c *Conn) {
-	if !c.assertAuthenticated(args.ID()) {
+	name, ok := c.mailboxArg(args, statusArgMailbox)
+	if !ok {
 		return
 	}
-
-	mailbox, err := c.User.MailboxByName(args.Arg(0))
+	mailbox, err := mailstore.MailboxByName(c.context(), c.User, name)
 	if err != nil {
-		c.writeResponse(args.ID(), "NO "+err.Error())
+		c.writeNo(args.ID(), codeNonexistent, err.Error())
 		return
 	}
 
-	c.writeResponse("", fmt.Sprintf("STATUS %s (UIDNEXT %d UNSEEN %d)",
-		mailbox.Name(), mailbox.NextUID(), mailbox.Unseen()))
-	c.writeResponse(args.ID(), "OK STATUS Completed")
+	// Respond with the requested items in the order they were requested
+	items := strings.Fields(args.Arg(statusArgItems))
+	responseItems := make([]string, len(items))
+	for index, item := range items {
+		var value uint64
+		switch strings.ToUpper(item) {
+		case "MESSAGES":
+			count, err := mailstore.MessageCount(c.context(), mailbox)
+			if err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+			value = uint64(count)
+		case "RECENT":
+			value = uint64(mailbox.Recent())
+		case "UIDNEXT":
+			value = uint64(mailbox.NextUID())
+		case "UIDVALIDITY":
+			value = uidValidity
+		case "UNSEEN":
+			value = uint64(mailbox.Unseen())
+		case "SIZE":
+			if value, err = mailboxSize(c.context(), mailbox); err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+		default:
+			c.writeBad(args.ID(), codeNone, c.text(MsgUnrecognisedStatusItem, item))
+			return
+		}
+		responseItems[index] = fmt.Sprintf("%s %d", strings.ToUpper(item), value)
+	}
+
+	c.writeResponse("", fmt.Sprintf("STATUS %s (%s)",
+		c.mailboxString(mailbox.Name()), strings.Join(responseItems, " ")))
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "STATUS"))
+}
+
+// Calculate the total size of all messages in a mailbox. Mailboxes which
+// can't compute this cheaply themselves have each message's size summed.
+func mailboxSize(ctx context.Context, mailbox mailstore.Mailbox) (uint64, error) {
+	if sized, ok := mailbox.(mailstore.SizedMailbox); ok {
+		return sized.Size(), nil
+	}
+
+	allMessages, _ := types.InterpretSequenceSet("1:*")
+	msgs, err := mailstore.MessageSetBySequenceNumber(ctx, mailbox, allMessages)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, msg := range msgs {
+		size += uint64(msg.Size())
+	}
+	return size, nil
 }
@@ -1,7 +1,10 @@
 package conn_test
 
 import (
+	"fmt"
+
 	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
 	. "github.com/onsi/ginkgo"
 )
 
@@ -15,7 +18,43 @@ var _ = Describe("STATUS Command", func() {
 		It("should respond with the status of INBOX", func() {
 			SendLine("abcd.123 STATUS INBOX (UIDNEXT UNSEEN)")
 			ExpectResponse("* STATUS INBOX (UIDNEXT 13 UNSEEN 3)")
-			ExpectResponse("abcd.123 OK STATUS Completed")
+			ExpectResponse("abcd.123 OK STATUS completed")
+		})
+
+		It("should quote mailbox names which aren't atoms", func() {
+			mStore.User.CreateMailbox("Old Mail")
+			SendLine("abcd.123 STATUS \"Old Mail\" (MESSAGES)")
+			ExpectResponse("* STATUS \"Old Mail\" (MESSAGES 0)")
+			ExpectResponse("abcd.123 OK STATUS completed")
+		})
+
+		It("should respond with the requested items in order", func() {
+			SendLine("abcd.123 STATUS INBOX (MESSAGES UIDVALIDITY RECENT)")
+			ExpectResponse("* STATUS INBOX (MESSAGES 3 UIDVALIDITY 250 RECENT 3)")
+			ExpectResponse("abcd.123 OK STATUS completed")
+		})
+
+		It("should respond with the total size of the mailbox", func() {
+			mbox, _ := mStore.User.MailboxByName("INBOX")
+			allMessages, _ := types.InterpretSequenceSet("1:*")
+			size := 0
+			for _, msg := range mbox.MessageSetBySequenceNumber(allMessages) {
+				size += int(msg.Size())
+			}
+
+			SendLine("abcd.123 STATUS INBOX (SIZE)")
+			ExpectResponse(fmt.Sprintf("* STATUS INBOX (SIZE %d)", size))
+			ExpectResponse("abcd.123 OK STATUS completed")
+		})
+
+		It("should reject unknown status items", func() {
+			SendLine("abcd.123 STATUS INBOX (BOGUS)")
+			ExpectResponse("abcd.123 BAD Unrecognised status item BOGUS")
+		})
+
+		It("should refuse a mailbox which doesn't exist", func() {
+			SendLine("abcd.123 STATUS Nonexistent (MESSAGES)")
+			ExpectResponse("abcd.123 NO [NONEXISTENT] Invalid mailbox")
 		})
 	})
 
@@ -2,7 +2,7 @@ package conn
 
 import (
 	"fmt"
-	"strings"
+	"sort"
 
 	"github.com/jordwest/imap-server/mailstore"
 	"github.com/jordwest/imap-server/types"
@@ -10,70 +10,153 @@ import (
 
 const storeArgUID int = 0
 const storeArgRange int = 1
-const storeArgOperation int = 2
-const storeArgSilent int = 3
-const storeArgFlags int = 4
+const storeArgUnchangedSince int = 2
+const storeArgOperation int = 3
+const storeArgSilent int = 4
+const storeArgFlags int = 5
 
+// Change the flags of a set of messages. FLAGS replaces their flags, +FLAGS
+// adds to them and -FLAGS removes from them. Unless .SILENT is given, the
+// new flags of each message are sent back to the client. With UNCHANGEDSINCE
+// messages changed since the given mod-sequence are left alone, and listed
+// in the tagged response (RFC 7162 section 3.1.3).
 func cmdStoreFlags(args commandArgs, c *Conn) {
-	if !c.assertSelected(args.ID(), ReadWrite) {
+	if !c.assertWritable(args.ID()) {
 		return
 	}
 
-	operation := args.Arg(storeArgOperation)
-	flags := args.Arg(storeArgFlags)
-	uid := strings.ToUpper(args.Arg(storeArgUID)) == "UID "
-	seqSetStr := args.Arg(storeArgRange)
-
-	silent := false
-	if args.Arg(storeArgSilent) == ".SILENT" {
-		silent = true
+	req, err := storeRequest(args)
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
 	}
 
 	var msgs []mailstore.Message
-	seqSet, err := types.InterpretSequenceSet(seqSetStr)
+	if req.UID {
+		msgs, err = mailstore.MessageSetByUID(c.context(), c.SelectedMailbox, req.UIDs)
+	} else {
+		msgs, err = c.messagesBySequenceSet(req.Set)
+	}
 	if err != nil {
-		c.writeResponse(args.ID(), "NO "+err.Error())
+		c.writeError(args.ID(), err)
 		return
 	}
-	if uid {
-		msgs = c.SelectedMailbox.MessageSetByUID(seqSet)
-	} else {
-		msgs = c.SelectedMailbox.MessageSetBySequenceNumber(seqSet)
+	var log mailstore.ChangeLog
+	if req.Conditional {
+		var ok bool
+		if log, ok = c.enableCondStore(args.ID()); !ok {
+			return
+		}
+	}
+
+	// Refuse flags the mailbox can't keep, rather than pretending to store them
+	policy := permanentFlags(c.SelectedMailbox)
+	for _, flag := range req.Flags.Strings() {
+		if !policy.Permits(flag) {
+			c.writeNo(args.ID(), codeCannot, c.text(MsgFlagNotStorable, flag))
+			return
+		}
+	}
+
+	// The response to a UID command identifies each message by UID as well.
+	// Once CONDSTORE is enabled, each message's new mod-sequence is sent
+	// back even if its flags aren't.
+	fetchItems := c.flagFetchItems(req.UID)
+	if req.Silent {
+		fetchItems = ""
+		if _, ok := c.selectedChangeLog(); ok && c.condStoreEnabled {
+			fetchItems = "MODSEQ"
+			if req.UID {
+				fetchItems = "UID MODSEQ"
+			}
+		}
 	}
 
-	flagField := types.FlagsFromString(flags)
+	flagField := req.Flags.System
+	keywords := req.Flags.Keywords
+	modified := make([]uint32, 0)
 	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		seqno, known := c.sequenceNumber(msg)
 
-		if operation == "+" {
-			msg = msg.AddFlags(flagField)
-		} else if operation == "-" {
-			msg = msg.RemoveFlags(flagField)
-		} else {
-			msg = msg.OverwriteFlags(flagField)
+		if req.Conditional {
+			modseq, err := mailstore.MessageModSeq(log, msg.UID())
+			if err != nil {
+				c.writeError(args.ID(), err)
+				return
+			}
+			if modseq > req.UnchangedSince {
+				if req.UID {
+					modified = append(modified, msg.UID())
+				} else if known {
+					modified = append(modified, seqno)
+				}
+				continue
+			}
 		}
-		msg.Save()
 
+		changed := flagField
+		if req.Operation == types.StoreReplace {
+			// Only the server may change \Recent, so it survives replacement
+			changed |= msg.Flags() & types.FlagRecent
+		}
+		msg, err = mailstore.UpdateFlags(c.context(), msg, req.Operation, changed)
+		if err == nil {
+			msg = storeKeywords(msg, req.Operation, keywords)
+			msg, err = mailstore.SaveMessage(c.context(), msg)
+		}
 		if err != nil {
-			c.writeResponse(args.ID(), "NO "+err.Error())
+			c.announceChanges(c.SelectedMailbox.Name())
+			c.writeError(args.ID(), err)
 			return
 		}
+		c.rememberFlags(msg)
 
-		// Auto-fetch for the client
-		if !silent {
-			newFlags, err := fetch("FLAGS", c, msg)
+		// Auto-fetch for the client, unless it doesn't know of the message
+		if known && fetchItems != "" {
+			newFlags, err := fetch(fetchItems, c, msg)
 			if err != nil {
-				c.writeResponse(args.ID(), "NO "+err.Error())
+				c.writeError(args.ID(), err)
 				return
 			}
 
-			fetchResponse := fmt.Sprintf("%d FETCH (%s)",
-				msg.SequenceNumber(),
-				newFlags,
-			)
+			fetchResponse := fmt.Sprintf("%d FETCH (%s)", seqno, newFlags)
 
 			c.writeResponse("", fetchResponse)
 		}
 	}
 
-	c.writeResponse(args.ID(), "OK STORE Completed")
+	c.announceChanges(c.SelectedMailbox.Name())
+	if len(modified) > 0 {
+		sort.Slice(modified, func(i, j int) bool { return modified[i] < modified[j] })
+		c.writeOK(args.ID(), codeModified.with(types.NewUIDSet(modified)), c.text(MsgConditionalStoreFailed))
+		return
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "STORE"))
+}
+
+// Change the keywords of a message in the same way as its flags. Messages
+// which can't hold keywords are left as they are, which is only possible if
+// no keywords are being stored.
+func storeKeywords(msg mailstore.Message, operation types.StoreOperation, keywords []string) mailstore.Message {
+	keywordMsg, ok := msg.(mailstore.KeywordMessage)
+	if !ok {
+		return msg
+	}
+	switch operation {
+	case types.StoreAdd:
+		if len(keywords) == 0 {
+			return msg
+		}
+		return keywordMsg.SetKeywords(types.AddKeywords(msg.Keywords(), keywords))
+	case types.StoreRemove:
+		if len(keywords) == 0 {
+			return msg
+		}
+		return keywordMsg.SetKeywords(types.RemoveKeywords(msg.Keywords(), keywords))
+	default:
+		return keywordMsg.SetKeywords(keywords)
+	}
 }
@@ -2,11 +2,21 @@ package conn_test
 
 import (
 	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
 	"github.com/jordwest/imap-server/types"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// A mailbox which can only store some flags
+type restrictedMailbox struct {
+	mailstore.DummyMailbox
+}
+
+func (m restrictedMailbox) PermanentFlags() types.PermanentFlags {
+	return types.PermanentFlags{Flags: types.FlagSeen}
+}
+
 var _ = Describe("STORE Command", func() {
 	Context("When a mailbox is selected", func() {
 		BeforeEach(func() {
@@ -18,23 +28,97 @@ var _ = Describe("STORE Command", func() {
 
 		It("should silently add a flag to a message", func() {
 			SendLine("abcd.123 STORE 1 +FLAGS.SILENT (\\Seen)")
-			ExpectResponse("abcd.123 OK STORE Completed")
+			ExpectResponse("abcd.123 OK STORE completed")
 			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).Flags()).
 				To(Equal(types.FlagSeen | types.FlagRecent))
 		})
 
 		It("should remove a flag from a message by UID", func() {
 			SendLine("abcd.124 UID STORE 12 -FLAGS (\\Seen)")
-			ExpectResponse("* 3 FETCH (FLAGS (\\Recent))")
-			ExpectResponse("abcd.124 OK STORE Completed")
+			ExpectResponse("* 3 FETCH (UID 12 FLAGS (\\Recent))")
+			ExpectResponse("abcd.124 OK STORE completed")
 		})
 
 		It("should overwrite multiple flags on multiple message by UID", func() {
 			SendLine("abcd.125 uid STORE 3:* FLAGS (\\Deleted \\Seen)")
-			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Deleted))")
-			ExpectResponse("* 2 FETCH (FLAGS (\\Seen \\Deleted))")
-			ExpectResponse("* 3 FETCH (FLAGS (\\Seen \\Deleted))")
-			ExpectResponse("abcd.125 OK STORE Completed")
+			ExpectResponse("* 1 FETCH (UID 10 FLAGS (\\Seen \\Recent \\Deleted))")
+			ExpectResponse("* 2 FETCH (UID 11 FLAGS (\\Seen \\Recent \\Deleted))")
+			ExpectResponse("* 3 FETCH (UID 12 FLAGS (\\Seen \\Recent \\Deleted))")
+			ExpectResponse("abcd.125 OK STORE completed")
+		})
+
+		It("should silently replace flags, ignoring the case of names", func() {
+			SendLine("abcd.123 store 1:2 flags.silent (\\seen \\FLAGGED)")
+			ExpectResponse("abcd.123 OK STORE completed")
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(2).Flags()).
+				To(Equal(types.FlagSeen | types.FlagFlagged | types.FlagRecent))
+		})
+
+		It("should refuse unknown system flags", func() {
+			SendLine("abcd.123 STORE 1 +FLAGS (\\Seen \\Important)")
+			ExpectResponse("abcd.123 BAD Invalid flag \\Important, not a system flag")
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).Flags()).
+				To(Equal(types.FlagRecent))
+		})
+
+		It("should clear flags with an empty list", func() {
+			SendLine("abcd.123 STORE 1 +FLAGS (\\Seen \\Answered)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Answered \\Seen \\Recent))")
+			ExpectResponse("abcd.123 OK STORE completed")
+			SendLine("abcd.124 STORE 1 FLAGS ()")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("abcd.124 OK STORE completed")
+		})
+
+		It("should remove flags without a parenthesised list", func() {
+			SendLine("abcd.123 STORE 1,3 +FLAGS.SILENT (\\Draft \\Seen)")
+			ExpectResponse("abcd.123 OK STORE completed")
+			SendLine("abcd.124 STORE 1:* -FLAGS \\Draft")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Recent))")
+			ExpectResponse("* 2 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("* 3 FETCH (FLAGS (\\Seen \\Recent))")
+			ExpectResponse("abcd.124 OK STORE completed")
+		})
+
+		It("should store keywords alongside flags", func() {
+			SendLine("abcd.123 STORE 1 +FLAGS (\\Seen $Forwarded $Label1)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Recent $Forwarded $Label1))")
+			ExpectResponse("abcd.123 OK STORE completed")
+			SendLine("abcd.124 STORE 1 -FLAGS ($label1)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Recent $Forwarded))")
+			ExpectResponse("abcd.124 OK STORE completed")
+			SendLine("abcd.125 STORE 1 FLAGS ($Junk)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent $Junk))")
+			ExpectResponse("abcd.125 OK STORE completed")
+
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).Keywords()).
+				To(Equal([]string{"$Junk"}))
+		})
+	})
+
+	Context("When the mailbox can't store every flag", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = restrictedMailbox{tConn.User.Mailboxes()[0].(mailstore.DummyMailbox)}
+		})
+
+		It("should store permitted flags", func() {
+			SendLine("abcd.123 STORE 1 +FLAGS.SILENT (\\Seen)")
+			ExpectResponse("abcd.123 OK STORE completed")
+		})
+
+		It("should refuse other flags", func() {
+			SendLine("abcd.123 STORE 1 +FLAGS (\\Seen \\Flagged)")
+			ExpectResponse("abcd.123 NO [CANNOT] Flag \\Flagged can't be stored in this mailbox")
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).Flags()).
+				To(Equal(types.FlagRecent))
+		})
+
+		It("should refuse keywords", func() {
+			SendLine("abcd.123 STORE 1 +FLAGS (Important)")
+			ExpectResponse("abcd.123 NO [CANNOT] Flag Important can't be stored in this mailbox")
 		})
 	})
 
@@ -0,0 +1,35 @@
+package conn
+
+import (
+	"context"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+const subscribeArgMailbox int = 0
+
+func cmdSubscribe(args commandArgs, c *Conn) {
+	changeSubscription(args, c, "SUBSCRIBE", mailstore.Subscribe)
+}
+
+func cmdUnsubscribe(args commandArgs, c *Conn) {
+	changeSubscription(args, c, "UNSUBSCRIBE", mailstore.Unsubscribe)
+}
+
+// Add a mailbox to or remove it from the user's subscriptions
+func changeSubscription(args commandArgs, c *Conn, command string, change func(context.Context, mailstore.SubscriptionStore, string) error) {
+	store, ok := c.User.(mailstore.SubscriptionStore)
+	if !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgNoSubscriptions))
+		return
+	}
+	name, ok := c.mailboxArg(args, subscribeArgMailbox)
+	if !ok {
+		return
+	}
+	if err := change(c.context(), store, name); err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, command))
+}
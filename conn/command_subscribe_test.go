@@ -0,0 +1,48 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SUBSCRIBE and UNSUBSCRIBE Commands", func() {
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should subscribe to a mailbox", func() {
+			SendLine("abcd.123 SUBSCRIBE \"Archive\"")
+			ExpectResponse("abcd.123 OK SUBSCRIBE completed")
+			Expect(mStore.User.Subscriptions()).To(ContainElement("Archive"))
+		})
+
+		It("should unsubscribe from a mailbox", func() {
+			SendLine("abcd.123 UNSUBSCRIBE Trash")
+			ExpectResponse("abcd.123 OK UNSUBSCRIBE completed")
+			Expect(mStore.User.Subscriptions()).To(Equal([]string{"INBOX", "Sent"}))
+
+			SendLine("abcd.124 UNSUBSCRIBE Trash")
+			ExpectResponse("abcd.124 NO Not subscribed to that mailbox")
+		})
+
+		It("should refuse users without subscriptions", func() {
+			tConn.User = unsubscribableUser{mStore.User}
+			SendLine("abcd.123 SUBSCRIBE INBOX")
+			ExpectResponse("abcd.123 NO [CANNOT] Subscriptions are not supported")
+		})
+	})
+
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 SUBSCRIBE INBOX")
+			ExpectResponse("abcd.123 BAD not authenticated")
+		})
+	})
+})
@@ -0,0 +1,124 @@
+package conn
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// Commands which may be sent while a transaction is open. Anything else
+// would see (or change) the mailbox in a state which might yet be undone.
+var transactionCommands = map[string]bool{
+	"STORE":     true,
+	"COPY":      true,
+	"EXPUNGE":   true,
+	"NOOP":      true,
+	"LOGOUT":    true,
+	"XCOMMIT":   true,
+	"XROLLBACK": true,
+}
+
+// Handles XBEGIN, which starts a batch of changes to the selected mailbox
+// that are applied together by XCOMMIT or discarded by XROLLBACK
+func cmdXBegin(args commandArgs, c *Conn) {
+	if !c.Transactions {
+		c.writeBad(args.ID(), codeNone, c.text(MsgTransactionsDisabled))
+		return
+	}
+	if !c.assertWritable(args.ID()) {
+		return
+	}
+	if c.transaction != nil {
+		c.writeBad(args.ID(), codeNone, c.text(MsgTransactionOpen))
+		return
+	}
+	if _, ok := underlyingMailbox(c.SelectedMailbox).(mailstore.TransactionMailbox); !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgNoTransactions))
+		return
+	}
+
+	tx, err := mailstore.Begin(c.context(), c.SelectedMailbox.(mailstore.TransactionMailbox))
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.transaction = tx
+	c.transactionChanges = make(map[string]bool)
+	c.reloadSelectedMailbox()
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "XBEGIN"))
+}
+
+// Handles XCOMMIT. If the changes can't be applied, whatever part of them
+// may have been is rolled back.
+func cmdXCommit(args commandArgs, c *Conn) {
+	if c.transaction == nil {
+		c.writeBad(args.ID(), codeNone, c.text(MsgNoTransactionOpen))
+		return
+	}
+	err := mailstore.Commit(c.context(), c.transaction)
+	if err != nil {
+		if rollbackErr := mailstore.Rollback(c.context(), c.transaction); rollbackErr != nil {
+			c.logf("Error rolling back failed transaction: %s\n", rollbackErr)
+		}
+	}
+	changed := c.endTransaction()
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	for _, mailbox := range changed {
+		c.announceChanges(mailbox)
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "XCOMMIT"))
+}
+
+// Handles XROLLBACK
+func cmdXRollback(args commandArgs, c *Conn) {
+	if c.transaction == nil {
+		c.writeBad(args.ID(), codeNone, c.text(MsgNoTransactionOpen))
+		return
+	}
+	err := mailstore.Rollback(c.context(), c.transaction)
+	c.endTransaction()
+	if err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "XROLLBACK"))
+}
+
+// Roll back a transaction left open when the client logs out or disconnects
+func (c *Conn) abandonTransaction() {
+	if c.transaction == nil {
+		return
+	}
+	if err := mailstore.Rollback(context.Background(), c.transaction); err != nil {
+		c.logf("Error rolling back abandoned transaction: %s\n", err)
+	}
+	c.transaction = nil
+	c.transactionChanges = nil
+}
+
+// Return to working on the selected mailbox directly, and tell the client
+// how the outcome of the transaction differs from what it has been told,
+// eg the expunges held back until the commit or flags restored by a
+// rollback. Returns the names of the mailboxes changed in the transaction.
+func (c *Conn) endTransaction() []string {
+	changed := make([]string, 0, len(c.transactionChanges))
+	for mailbox := range c.transactionChanges {
+		changed = append(changed, mailbox)
+	}
+	sort.Strings(changed)
+	c.transaction = nil
+	c.transactionChanges = nil
+
+	// Searches within the transaction may have been cached under
+	// mod-sequences which the mailbox will go on to reuse
+	c.invalidateSearchCache(c.SelectedMailbox.Name())
+	for _, mailbox := range changed {
+		c.invalidateSearchCache(mailbox)
+	}
+	c.reportMailboxChanges(true)
+	return changed
+}
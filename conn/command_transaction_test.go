@@ -0,0 +1,174 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("XBEGIN Command", func() {
+	Context("When transactions are enabled", func() {
+		BeforeEach(func() {
+			tConn.Transactions = true
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		// Establish the client's view of the mailbox, against which the
+		// outcome of a transaction is reported
+		JustBeforeEach(func() {
+			SendLine("abcd.122 NOOP")
+			ExpectResponse("abcd.122 OK NOOP completed")
+		})
+
+		It("should apply changes when committed", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 STORE 1 +FLAGS.SILENT (\\Seen)")
+			ExpectResponse("abcd.124 OK STORE completed")
+			SendLine("abcd.125 XCOMMIT")
+			ExpectResponse("abcd.125 OK XCOMMIT completed")
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).Flags()).
+				To(Equal(types.FlagSeen))
+		})
+
+		It("should discard changes when rolled back", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 STORE 1:2 +FLAGS.SILENT (\\Deleted)")
+			ExpectResponse("abcd.124 OK STORE completed")
+			SendLine("abcd.125 XROLLBACK")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("* 2 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("abcd.125 OK XROLLBACK completed")
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(1).Flags()).
+				To(Equal(types.Flags(0)))
+			Expect(tConn.SelectedMailbox.MessageBySequenceNumber(2).Flags()).
+				To(Equal(types.Flags(0)))
+		})
+
+		It("should hide changes from other sessions until committed", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 STORE 1 +FLAGS.SILENT (\\Flagged)")
+			ExpectResponse("abcd.124 OK STORE completed")
+
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			Expect(inbox.MessageBySequenceNumber(1).Flags()).To(Equal(types.Flags(0)))
+
+			SendLine("abcd.125 XCOMMIT")
+			ExpectResponse("abcd.125 OK XCOMMIT completed")
+			inbox, _ = mStore.User.MailboxByName("INBOX")
+			Expect(inbox.MessageBySequenceNumber(1).Flags()).To(Equal(types.FlagFlagged))
+		})
+
+		It("should report expunges once committed", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 STORE 2 +FLAGS.SILENT (\\Deleted)")
+			ExpectResponse("abcd.124 OK STORE completed")
+			SendLine("abcd.125 EXPUNGE")
+			ExpectResponse("abcd.125 OK EXPUNGE completed")
+
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			Expect(inbox.Messages()).To(BeEquivalentTo(3))
+
+			SendLine("abcd.126 XCOMMIT")
+			ExpectResponse("* 2 EXPUNGE")
+			ExpectResponse("abcd.126 OK XCOMMIT completed")
+			inbox, _ = mStore.User.MailboxByName("INBOX")
+			Expect(inbox.Messages()).To(BeEquivalentTo(2))
+		})
+
+		It("should copy messages when committed", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 COPY 1:2 Trash")
+			ExpectResponse("abcd.124 OK COPY completed")
+
+			trash, _ := mStore.User.MailboxByName("Trash")
+			Expect(trash.Messages()).To(BeEquivalentTo(0))
+
+			SendLine("abcd.125 XCOMMIT")
+			ExpectResponse("abcd.125 OK XCOMMIT completed")
+			trash, _ = mStore.User.MailboxByName("Trash")
+			Expect(trash.Messages()).To(BeEquivalentTo(2))
+		})
+
+		It("should discard copies and expunges when rolled back", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 COPY 1 Trash")
+			ExpectResponse("abcd.124 OK COPY completed")
+			SendLine("abcd.125 STORE 1 +FLAGS.SILENT (\\Deleted)")
+			ExpectResponse("abcd.125 OK STORE completed")
+			SendLine("abcd.126 EXPUNGE")
+			ExpectResponse("abcd.126 OK EXPUNGE completed")
+			SendLine("abcd.127 XROLLBACK")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("abcd.127 OK XROLLBACK completed")
+
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			Expect(inbox.Messages()).To(BeEquivalentTo(3))
+			trash, _ := mStore.User.MailboxByName("Trash")
+			Expect(trash.Messages()).To(BeEquivalentTo(0))
+		})
+
+		It("should roll back a transaction which can't be committed", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 STORE 1 +FLAGS (\\Seen)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Recent))")
+			ExpectResponse("abcd.124 OK STORE completed")
+
+			// Another session changes the mailbox before the commit
+			inbox, _ := mStore.User.MailboxByName("INBOX")
+			inbox.MessageBySequenceNumber(3).AddFlags(types.FlagFlagged).Save()
+
+			SendLine("abcd.125 XCOMMIT")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("* 3 FETCH (FLAGS (\\Recent \\Flagged))")
+			ExpectResponsePattern("^abcd.125 NO ")
+
+			inbox, _ = mStore.User.MailboxByName("INBOX")
+			Expect(inbox.MessageBySequenceNumber(1).Flags()).To(Equal(types.Flags(0)))
+		})
+
+		It("should refuse other commands during a transaction", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 OK XBEGIN completed")
+			SendLine("abcd.124 FETCH 1 (FLAGS)")
+			ExpectResponse("abcd.124 BAD Command not allowed in a transaction")
+			SendLine("abcd.125 XBEGIN")
+			ExpectResponse("abcd.125 BAD Command not allowed in a transaction")
+		})
+
+		It("should advertise the capability", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponsePattern("^\\* CAPABILITY .* XTRANSACTION")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should return an error without an open transaction", func() {
+			SendLine("abcd.123 XCOMMIT")
+			ExpectResponse("abcd.123 BAD No transaction is open")
+		})
+	})
+
+	Context("When transactions are not enabled", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should return an error", func() {
+			SendLine("abcd.123 XBEGIN")
+			ExpectResponse("abcd.123 BAD Transactions are not enabled")
+		})
+	})
+})
@@ -0,0 +1,277 @@
+package conn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	"github.com/jordwest/imap-server/util"
+)
+
+const (
+	genURLAuthArgPairs int = 0
+	urlFetchArgURLs    int = 0
+	resetKeyArgMailbox int = 0
+	resetKeyArgMechs   int = 1
+)
+
+// The only URLAUTH mechanism supported, where URLs are signed with a key
+// known only to the server
+const urlAuthMechanism = "INTERNAL"
+
+// imap://joe@example.com/INBOX;UIDVALIDITY=250/;UID=20/;SECTION=1.2;EXPIRE=2025-01-01T00:00:00Z;URLAUTH=user+fred:INTERNAL:91354a...
+// The user and mailbox are percent-encoded (RFC 5092), and the mailbox may
+// contain the hierarchy separator, eg imap://joe@example.com/Archive/2024/;UID=20
+var imapURLRE = regexp.MustCompile("^(?i)(imap://([^@/;]+)(?:;AUTH=[^@/]+)?@[^/]+/([^;]+)" +
+	"(?:;UIDVALIDITY=([0-9]+))?/;UID=([0-9]+)(?:/;SECTION=([0-9\\.]+))?" +
+	"(?:;EXPIRE=([^;]+))?;URLAUTH=([^:]+))(?::([^:]+):([0-9a-f]+))?$")
+
+// Pairs of URL rumps and mechanisms, as sent with GENURLAUTH
+var urlAuthPairRE = regexp.MustCompile("(\"[^\"]*\"|[^ ]+) ([A-Za-z0-9-]+)")
+
+// An IMAP URL referring to a message, which may carry a URLAUTH token
+type imapURL struct {
+	rump        string // Everything up to the mechanism, which is signed
+	user        string // Decoded from the URL
+	mailbox     string // Decoded from the URL
+	uidValidity string
+	uid         uint32
+	section     string
+	expire      string
+	access      string
+	mechanism   string
+	token       string
+}
+
+func parseIMAPURL(rawURL string) (u imapURL, ok bool) {
+	match := imapURLRE.FindStringSubmatch(rawURL)
+	if match == nil {
+		return u, false
+	}
+	uid, err := strconv.ParseUint(match[5], 10, 32)
+	if err != nil {
+		return u, false
+	}
+	user, err := url.PathUnescape(match[2])
+	if err != nil {
+		return u, false
+	}
+	mailbox, err := url.PathUnescape(match[3])
+	if err != nil {
+		return u, false
+	}
+	return imapURL{
+		rump:        match[1],
+		user:        user,
+		mailbox:     mailbox,
+		uidValidity: match[4],
+		uid:         uint32(uid),
+		section:     match[6],
+		expire:      match[7],
+		access:      match[8],
+		mechanism:   strings.ToUpper(match[9]),
+		token:       strings.ToLower(match[10]),
+	}, true
+}
+
+// Sign a URL rump with a mailbox access key
+func urlAuthToken(key []byte, rump string) string {
+	mac := hmac.New(sha1.New, key)
+	mac.Write([]byte(rump))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns true if the URL's access identifier permits this connection's
+// user to fetch it. URLs for submission (submit+) may only be fetched by
+// the submission servers configured in SubmitServers, or by the user they
+// were issued for.
+func (c *Conn) urlAccessAllowed(access string) bool {
+	lower := strings.ToLower(access)
+	switch {
+	case lower == "anonymous", lower == "authuser":
+		return true
+	case strings.HasPrefix(lower, "submit+"):
+		return access[len("submit+"):] == c.username || c.isSubmitServer()
+	case strings.HasPrefix(lower, "user+"):
+		return access[len("user+"):] == c.username
+	}
+	return false
+}
+
+// Whether this connection's user is a submission server, trusted to fetch
+// URLs issued to others for submission
+func (c *Conn) isSubmitServer() bool {
+	for _, server := range c.SubmitServers {
+		if server == c.username {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles GENURLAUTH, which signs URLs referring to the user's own messages
+func cmdGenURLAuth(args commandArgs, c *Conn) {
+	keyUser, ok := c.User.(mailstore.AccessKeyUser)
+	if !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgNoURLAuth))
+		return
+	}
+
+	pairs := urlAuthPairRE.FindAllStringSubmatch(args.Arg(genURLAuthArgPairs), -1)
+	urls := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		rump := strings.Trim(pair[1], "\"")
+		if !strings.EqualFold(pair[2], urlAuthMechanism) {
+			c.writeBad(args.ID(), codeNone, c.text(MsgUnsupportedURLAuthMechanism, pair[2]))
+			return
+		}
+
+		u, ok := parseIMAPURL(rump)
+		if !ok || u.token != "" {
+			c.writeBad(args.ID(), codeNone, c.text(MsgInvalidURLRump, rump))
+			return
+		}
+		if u.user != c.username {
+			c.writeNo(args.ID(), codeNone, c.text(MsgNotOwnMailbox))
+			return
+		}
+		if _, err := mailstore.MailboxByName(c.context(), c.User, u.mailbox); err != nil {
+			c.writeNo(args.ID(), codeNone, c.text(MsgNoSuchNamedMailbox, c.encodeMailboxName(u.mailbox)))
+			return
+		}
+
+		key, err := mailstore.MailboxAccessKey(c.context(), keyUser, u.mailbox)
+		if err != nil {
+			c.writeError(args.ID(), err)
+			return
+		}
+		urls = append(urls, imapString(rump+":"+strings.ToLower(urlAuthMechanism)+":"+urlAuthToken(key, rump)))
+	}
+	if len(urls) == 0 {
+		c.writeBad(args.ID(), codeNone, c.text(MsgNoURLs))
+		return
+	}
+
+	c.writeResponse("", "GENURLAUTH "+strings.Join(urls, " "))
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "GENURLAUTH"))
+}
+
+// Handles URLFETCH, which returns the data referred to by signed URLs
+func cmdURLFetch(args commandArgs, c *Conn) {
+	urls, err := util.ParseList(args.Arg(urlFetchArgURLs))
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+	for _, item := range urls {
+		data, ok := c.fetchURL(item.Value)
+		if !ok {
+			c.writeResponse("", fmt.Sprintf("URLFETCH %s NIL", imapString(item.Value)))
+			continue
+		}
+		c.writeResponse("", fmt.Sprintf("URLFETCH %s {%d}\r\n%s", imapString(item.Value), len(data), data))
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "URLFETCH"))
+}
+
+// Verify a signed URL and find the data it refers to
+func (c *Conn) fetchURL(rawURL string) ([]byte, bool) {
+	u, ok := parseIMAPURL(rawURL)
+	if !ok || u.mechanism != urlAuthMechanism || !c.urlAccessAllowed(u.access) {
+		return nil, false
+	}
+	if u.uidValidity != "" && u.uidValidity != strconv.Itoa(uidValidity) {
+		return nil, false
+	}
+	if u.expire != "" {
+		expire, err := time.Parse(time.RFC3339, u.expire)
+		if err != nil || time.Now().After(expire) {
+			return nil, false
+		}
+	}
+
+	// The URL may refer to another user's mailbox
+	owner := c.User
+	if u.user != c.username {
+		lookup, ok := c.Mailstore.(mailstore.UserLookup)
+		if !ok {
+			return nil, false
+		}
+		var err error
+		if owner, err = mailstore.UserByName(c.context(), lookup, u.user); err != nil {
+			return nil, false
+		}
+	}
+	keyUser, ok := owner.(mailstore.AccessKeyUser)
+	if !ok {
+		return nil, false
+	}
+	key, err := mailstore.MailboxAccessKey(c.context(), keyUser, u.mailbox)
+	if err != nil || !hmac.Equal([]byte(urlAuthToken(key, u.rump)), []byte(u.token)) {
+		return nil, false
+	}
+
+	mailbox, err := mailstore.MailboxByName(c.context(), owner, u.mailbox)
+	if err != nil {
+		return nil, false
+	}
+	msg, err := mailstore.MessageByUID(c.context(), mailbox, u.uid)
+	if err != nil || msg == nil {
+		return nil, false
+	}
+
+	full := types.RFC2822Message{Headers: msg.Header(), Body: msg.Body()}
+	if u.section == "" {
+		return []byte(fmt.Sprintf("%s\r\n%s", util.MIMEHeaderToString(full.Headers), full.Body)), true
+	}
+	path, err := types.ParsePartPath(u.section)
+	if err != nil {
+		return nil, false
+	}
+	part, err := full.Part(path)
+	if err != nil {
+		return nil, false
+	}
+	return []byte(part.Body), true
+}
+
+// Handles RESETKEY, which invalidates all URLs signed for a mailbox (or all
+// of the user's mailboxes)
+func cmdResetKey(args commandArgs, c *Conn) {
+	keyUser, ok := c.User.(mailstore.AccessKeyUser)
+	if !ok {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgNoURLAuth))
+		return
+	}
+
+	for _, mech := range strings.Fields(args.Arg(resetKeyArgMechs)) {
+		if !strings.EqualFold(mech, urlAuthMechanism) {
+			c.writeBad(args.ID(), codeNone, c.text(MsgUnsupportedURLAuthMechanism, mech))
+			return
+		}
+	}
+
+	mailbox, ok := c.mailboxArg(args, resetKeyArgMailbox)
+	if !ok {
+		return
+	}
+	if mailbox != "" {
+		if _, err := mailstore.MailboxByName(c.context(), c.User, mailbox); err != nil {
+			c.writeNo(args.ID(), codeNone, c.text(MsgNoSuchNamedMailbox, c.encodeMailboxName(mailbox)))
+			return
+		}
+	}
+	if err := mailstore.ResetMailboxAccessKeys(c.context(), keyUser, mailbox); err != nil {
+		c.writeError(args.ID(), err)
+		return
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "RESETKEY"))
+}
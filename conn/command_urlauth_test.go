@@ -0,0 +1,149 @@
+package conn_test
+
+import (
+	"regexp"
+
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("URLAUTH Commands", func() {
+	rump := "imap://username@localhost/INBOX;UIDVALIDITY=250/;UID=12;URLAUTH=user+username"
+
+	// Generate a signed URL and return it
+	genURLAuth := func(rump string) string {
+		SendLine("abcd.124 GENURLAUTH \"" + rump + "\" INTERNAL")
+		line, err := reader.ReadLine()
+		Expect(err).ToNot(HaveOccurred())
+		match := regexp.MustCompile("^\\* GENURLAUTH \"(.*)\"$").FindStringSubmatch(line)
+		Expect(match).To(HaveLen(2))
+		ExpectResponse("abcd.124 OK GENURLAUTH completed")
+		return match[1]
+	}
+
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		JustBeforeEach(func() {
+			SendLine("abcd.123 LOGIN \"username\" \"password\"")
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
+
+		It("should advertise URLAUTH", func() {
+			SendLine("abcd.124 CAPABILITY")
+			ExpectResponsePattern("^\\* CAPABILITY .* URLAUTH ANNOTATE-EXPERIMENT-1$")
+			ExpectResponse("abcd.124 OK CAPABILITY completed")
+		})
+
+		It("should fetch a message by a signed URL", func() {
+			url := genURLAuth(rump)
+			Expect(url).To(MatchRegexp("^" + regexp.QuoteMeta(rump) + ":internal:[0-9a-f]{40}$"))
+
+			SendLine("abcd.125 URLFETCH \"" + url + "\"")
+			ExpectResponsePattern("^\\* URLFETCH \"" + regexp.QuoteMeta(url) + "\" {[0-9]+}$")
+			ExpectResponsePattern("^[A-z-]+: .*$")
+			lines := []string{}
+			for {
+				line, err := reader.ReadLine()
+				Expect(err).ToNot(HaveOccurred())
+				if line == "abcd.125 OK URLFETCH completed" {
+					break
+				}
+				lines = append(lines, line)
+			}
+			Expect(lines).To(ContainElement("Hello"))
+		})
+
+		It("should not fetch URLs with an invalid token", func() {
+			url := rump + ":internal:0000000000000000000000000000000000000000"
+			SendLine("abcd.125 URLFETCH \"" + url + "\"")
+			ExpectResponse("* URLFETCH \"" + url + "\" NIL")
+			ExpectResponse("abcd.125 OK URLFETCH completed")
+		})
+
+		It("should not fetch URLs for other users", func() {
+			url := genURLAuth("imap://username@localhost/INBOX/;UID=12;URLAUTH=user+someone")
+			SendLine("abcd.125 URLFETCH \"" + url + "\"")
+			ExpectResponse("* URLFETCH \"" + url + "\" NIL")
+			ExpectResponse("abcd.125 OK URLFETCH completed")
+		})
+
+		It("should not fetch URLs for submission on behalf of other users", func() {
+			url := genURLAuth("imap://username@localhost/INBOX/;UID=12;URLAUTH=submit+someone")
+			SendLine("abcd.125 URLFETCH \"" + url + "\"")
+			ExpectResponse("* URLFETCH \"" + url + "\" NIL")
+			ExpectResponse("abcd.125 OK URLFETCH completed")
+		})
+
+		It("should fetch URLs for submission on behalf of the user", func() {
+			url := genURLAuth("imap://username@localhost/INBOX/;UID=12;URLAUTH=submit+username")
+			SendLine("abcd.125 URLFETCH \"" + url + "\"")
+			ExpectResponsePattern("^\\* URLFETCH \"" + regexp.QuoteMeta(url) + "\" {[0-9]+}$")
+		})
+
+		Context("as a submission server", func() {
+			BeforeEach(func() {
+				tConn.SubmitServers = []string{"username"}
+			})
+
+			It("should fetch URLs for submission on behalf of other users", func() {
+				url := genURLAuth("imap://username@localhost/INBOX/;UID=12;URLAUTH=submit+someone")
+				SendLine("abcd.125 URLFETCH \"" + url + "\"")
+				ExpectResponsePattern("^\\* URLFETCH \"" + regexp.QuoteMeta(url) + "\" {[0-9]+}$")
+			})
+		})
+
+		It("should fetch URLs for mailboxes with percent-encoded hierarchical names", func() {
+			SendLine("abcd.125 CREATE \"Work/Plans 2024\"")
+			ExpectResponse("abcd.125 OK CREATE completed")
+			SendLine("abcd.126 APPEND \"Work/Plans 2024\" {15}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Hi")
+			SendLine("")
+			ExpectResponse("abcd.126 OK APPEND completed")
+
+			url := genURLAuth("imap://username@localhost/Work/Plans%202024/;UID=10;URLAUTH=user+username")
+			SendLine("abcd.127 URLFETCH \"" + url + "\"")
+			ExpectResponsePattern("^\\* URLFETCH \"" + regexp.QuoteMeta(url) + "\" {[0-9]+}$")
+		})
+
+		It("should escape the URLs it echoes", func() {
+			SendLine("abcd.125 URLFETCH \"imap://username@localhost/IN\\\"BOX/;UID=12;URLAUTH=anonymous:internal:00\"")
+			ExpectResponse("* URLFETCH \"imap://username@localhost/IN\\\"BOX/;UID=12;URLAUTH=anonymous:internal:00\" NIL")
+			ExpectResponse("abcd.125 OK URLFETCH completed")
+		})
+
+		It("should invalidate URLs when the key is reset", func() {
+			url := genURLAuth(rump)
+			SendLine("abcd.125 RESETKEY INBOX INTERNAL")
+			ExpectResponse("abcd.125 OK RESETKEY completed")
+			SendLine("abcd.126 URLFETCH \"" + url + "\"")
+			ExpectResponse("* URLFETCH \"" + url + "\" NIL")
+			ExpectResponse("abcd.126 OK URLFETCH completed")
+		})
+
+		It("should refuse to sign URLs for another user's mailbox", func() {
+			SendLine("abcd.124 GENURLAUTH \"imap://other@localhost/INBOX/;UID=12;URLAUTH=anonymous\" INTERNAL")
+			ExpectResponse("abcd.124 NO URL must refer to your own mailbox")
+		})
+
+		It("should refuse unsupported mechanisms", func() {
+			SendLine("abcd.124 GENURLAUTH \"" + rump + "\" XSAMPLE")
+			ExpectResponse("abcd.124 BAD Unsupported URLAUTH mechanism XSAMPLE")
+		})
+	})
+
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should give an error", func() {
+			SendLine("abcd.123 URLFETCH \"" + rump + ":internal:00\"")
+			ExpectResponse("abcd.123 BAD not authenticated")
+		})
+	})
+})
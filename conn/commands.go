@@ -1,15 +1,21 @@
 package conn
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	"github.com/jordwest/imap-server/util"
 )
 
 type command struct {
+	name    string // The command verb, as listed in Conn.DisabledCommands
 	match   *regexp.Regexp
 	handler func(commandArgs, *Conn)
+	parse   func(commandArgs) (types.Request, error) // Produces the typed request, if any
 }
 
 type commandArgs []string
@@ -40,6 +46,9 @@ func (a commandArgs) DebugPrint(prompt string) {
 
 var commands []command
 
+// The UIDVALIDITY reported for all mailboxes
+const uidValidity = 250
+
 // Register all supported client command handlers
 // with the server. This function is run on server startup and
 // panics if a command regex is invalid.
@@ -50,52 +59,313 @@ func init() {
 	// eg: 5,9,10:15,256:*,566
 	sequenceSet := "[\\d\\:\\*\\,]+"
 
-	registerCommand("(?i:CAPABILITY)", cmdCapability)
-	registerCommand("(?i:LOGIN) \"([A-z0-9]+)\" \"([A-z0-9]+)\"", cmdLogin)
-	registerCommand("(?i:AUTHENTICATE PLAIN)", cmdAuthPlain)
-	registerCommand("(?i:LIST) \"?([A-z0-9]+)?\"? \"?([A-z0-9*]+)?\"?", cmdList)
-	registerCommand("(?i:LSUB)", cmdLSub)
-	registerCommand("(?i:LOGOUT)", cmdLogout)
-	registerCommand("(?i:NOOP)", cmdNoop)
-	registerCommand("(?i:CLOSE)", cmdClose)
-	registerCommand("(?i:SELECT) \"?([A-z0-9]+)?\"?", cmdSelect)
-	registerCommand("(?i:EXAMINE) \"?([A-z0-9]+)\"?", cmdExamine)
-	registerCommand("(?i:STATUS) \"?([A-z0-9/]+)\"? \\(([A-z\\s]+)\\)", cmdStatus)
-	registerCommand("((?i)UID )?(?i:FETCH) ("+sequenceSet+") \\(([A-z0-9\\s\\(\\)\\[\\]\\.-]+)\\)", cmdFetch)
+	// A quoted string may hold anything, with any " or \ escaped by a
+	// backslash. Clients can't send CR or LF in one, but literals are put
+	// back into commands as quoted strings which may hold line breaks.
+	// Arguments which may be quoted are captured with their quotes, and
+	// unquoted by the handler.
+	quoted := `"(?:[^"\\]|\\["\\])*"`
+	astring := "(" + quoted + `|[^\s(){%*"\\\]]+)`
+
+	// Unquoted mailbox names may hold any atom character, so that names
+	// using whatever hierarchy delimiter the mailstore declares needn't be
+	// quoted
+	mailbox := astring
+
+	// LIST and LSUB take a reference name, which may be empty, and a
+	// pattern which may also contain the wildcards * and %
+	reference := "(" + quoted + `|[^\s(){%*"\\\]]*)`
+	pattern := "(" + quoted + `|[^\s(){"\\]*)`
+
+	registerCommand("CAPABILITY", "(?i:CAPABILITY)", cmdCapability)
+	registerCommand("ENABLE", "(?i:ENABLE) (.+)$", cmdEnable)
+	registerCommand("LANGUAGE", "(?i:LANGUAGE)(?: (.+))?$", cmdLanguage)
+	registerCommand("ID", "(?i:ID) (?:(?i:NIL)|\\(.*\\))$", cmdID)
+	registerCommand("LOGIN", "(?i:LOGIN) "+astring+" "+astring+"$", cmdLogin)
+	// AUTHENTICATE PLAIN
+	// AUTHENTICATE XOAUTH2 dXNlcj1zb21lb25lQGV4YW1wbGUuY29tAWF1dGg9QmVhcmVyIHRva2VuAQE=
+	saslInitialResponse := "(?: ([A-Za-z0-9\\+/=]+))?$"
+	registerCommand("AUTHENTICATE", "(?i:AUTHENTICATE PLAIN)"+saslInitialResponse, cmdAuthPlain)
+	registerCommand("AUTHENTICATE", "(?i:AUTHENTICATE XOAUTH2)"+saslInitialResponse, cmdAuthXOAuth2)
+	registerCommand("AUTHENTICATE", "(?i:AUTHENTICATE OAUTHBEARER)"+saslInitialResponse, cmdAuthOAuthBearer)
+
+	// GENURLAUTH "imap://joe@example.com/INBOX/;UID=20;URLAUTH=user+fred" INTERNAL
+	// URLFETCH "imap://joe@example.com/INBOX/;UID=20;URLAUTH=user+fred:internal:91354a..."
+	// RESETKEY INBOX INTERNAL
+	registerCommand("GENURLAUTH", "(?i:GENURLAUTH) (.+)$", cmdGenURLAuth)
+	registerCommand("URLFETCH", "(?i:URLFETCH) (.+)$", cmdURLFetch)
+	registerCommand("RESETKEY", "(?i:RESETKEY)(?: \"?([^\" ]+)\"?)?(?: (.+))?$", cmdResetKey)
+
+	registerCommand("LIST", "(?i:LIST) "+reference+" "+pattern+"$", cmdList)
+	registerCommand("XLIST", "(?i:XLIST) "+reference+" "+pattern+"$", cmdXList)
+	registerCommand("CREATE", "(?i:CREATE) "+mailbox+"$", cmdCreate)
+	registerCommand("DELETE", "(?i:DELETE) "+mailbox+"$", cmdDelete)
+	registerCommand("RENAME", "(?i:RENAME) "+mailbox+" "+mailbox+"$", cmdRename)
+	registerCommand("LSUB", "(?i:LSUB) "+reference+" "+pattern+"$", cmdLSub)
+	registerCommand("SUBSCRIBE", "(?i:SUBSCRIBE) "+mailbox+"$", cmdSubscribe)
+	registerCommand("UNSUBSCRIBE", "(?i:UNSUBSCRIBE) "+mailbox+"$", cmdUnsubscribe)
+	registerCommand("LOGOUT", "(?i:LOGOUT)", cmdLogout)
+	registerCommand("NOOP", "(?i:NOOP)", cmdNoop)
+	registerCommand("CLOSE", "(?i:CLOSE)", cmdClose)
+	registerCommand("EXPUNGE", "(?i:EXPUNGE)$", cmdExpunge)
+	registerCommand("CHECK", "(?i:CHECK)$", cmdCheck)
+	// SELECT INBOX
+	// SELECT INBOX (CONDSTORE)
+	// EXAMINE INBOX (QRESYNC (67890007 90060115194045000 41:211))
+	selectParams := "(?: \\((.+)\\))?$"
+	registerRequest("SELECT", "(?i:SELECT) "+mailbox+selectParams, func(args commandArgs) (types.Request, error) {
+		return selectRequest(args, false)
+	}, cmdSelect)
+	registerRequest("EXAMINE", "(?i:EXAMINE) "+mailbox+selectParams, func(args commandArgs) (types.Request, error) {
+		return selectRequest(args, true)
+	}, cmdExamine)
+	registerCommand("STATUS", "(?i:STATUS) "+mailbox+" \\(([A-z\\s]+)\\)", cmdStatus)
+	// FETCH 2:4 (FLAGS BODY[HEADER])
+	// UID FETCH 1:* FLAGS (CHANGEDSINCE 12345 VANISHED)
+	registerRequest("FETCH", "((?i)UID )?(?i:FETCH) ("+sequenceSet+") (\\([A-z0-9\\s\\(\\)\\[\\]\\.\"/*%<>-]+\\)|[A-z0-9\\[\\]\\.<>-]+(?: \\([A-z0-9\\s]+\\))?$)", func(args commandArgs) (types.Request, error) {
+		return fetchRequest(args)
+	}, cmdFetch)
 
 	// APPEND "INBOX" (\Seen) {310}
 	// APPEND "INBOX" (\Seen) "21-Jun-2015 01:00:25 +0900" {310}
 	// APPEND "INBOX" {310}
-	registerCommand("(?i:APPEND) \"?([A-z0-9/]+)\"?(?: \\(([\\\\A-z\\s]+)\\))?(?: \"([A-Za-z0-9\\-\\:\\+ ]+)\")? {([0-9]+)}", cmdAppend)
+	// APPEND "INBOX" ~{310}             Binary (literal8) message data
+	// APPEND "INBOX" {310+}             Non-synchronizing literal (LITERAL-)
+	appendArgs := " " + mailbox + "(?: \\(([\\\\$A-z0-9\\s]*)\\))?(?: \"([A-Za-z0-9\\-\\:\\+ ]+)\")? (~)?{([0-9]+)(\\+)?}"
+	registerRequest("APPEND", "(?i:APPEND)"+appendArgs, func(args commandArgs) (types.Request, error) {
+		return appendRequest(args, 0)
+	}, cmdAppend)
+
+	// REPLACE 4 "Drafts" (\Seen \Draft) {312}
+	// UID REPLACE 2000 "Drafts" {312}
+	registerRequest("REPLACE", "((?i)UID )?(?i:REPLACE) ([0-9]+)"+appendArgs, func(args commandArgs) (types.Request, error) {
+		return replaceRequest(args)
+	}, cmdReplace)
+
+	// SEARCH FROM "Smith" SINCE 1-Feb-1994 NOT SEEN
+	// UID SEARCH UID 100:* UNSEEN
+	// SEARCH CHARSET UTF-8 SUBJECT "Grüße"
+	// UID SEARCH RETURN (MIN COUNT) UNSEEN
+	registerRequest("SEARCH", "((?i)UID )?(?i:SEARCH) (?:(?i:RETURN) (\\([^)]*\\)) )?(?:(?i:CHARSET) (\"?[A-z0-9_.:-]+\"?) )?(.+)$", func(args commandArgs) (types.Request, error) {
+		return searchRequest(args)
+	}, cmdSearch)
+
+	// SORT (REVERSE DATE) UTF-8 UNSEEN
+	// UID SORT RETURN (PARTIAL 1:50) (SUBJECT) UTF-8 ALL
+	registerRequest("SORT", "((?i)UID )?(?i:SORT) (?:(?i:RETURN) (\\([^)]*\\)) )?\\(([^)]*)\\) (\"?[A-z0-9_.:-]+\"?) (.+)$", func(args commandArgs) (types.Request, error) {
+		return sortRequest(args)
+	}, cmdSort)
+
+	// ESEARCH IN (personal) RETURN (COUNT) UNSEEN
+	// ESEARCH IN (subtree "Lists" mailboxes (INBOX)) FROM "Smith"
+	registerRequest("ESEARCH", "(?i:ESEARCH)(?: (?i:IN) \\(((?:[^()]|\\([^()]*\\))*)\\))?(?: (?i:RETURN) (\\([^)]*\\)))?(?: (?i:CHARSET) (\"?[A-z0-9_.:-]+\"?))? (.+)$", func(args commandArgs) (types.Request, error) {
+		return multiSearchRequest(args)
+	}, cmdMultiSearch)
+	registerCommand("CANCELUPDATE", "(?i:CANCELUPDATE) (.+)$", cmdCancelUpdate)
 
 	// STORE 2:4 +FLAGS (\Deleted)       Mark messages as deleted
 	// STORE 2:4 -FLAGS (\Seen)          Mark messages as unseen
 	// STORE 2:4 FLAGS (\Seen \Deleted)  Replace flags
-	registerCommand("((?i)UID )?(?i:STORE) ("+sequenceSet+") ([\\+\\-])?(?i:FLAGS(\\.SILENT)?) \\(?([\\\\A-z0-9\\s]+)\\)?", cmdStoreFlags)
+	// STORE 2:4 ANNOTATION (/comment (value.priv "Note"))  Annotate messages
+	// STORE 2:4 (UNCHANGEDSINCE 12345) +FLAGS (\Seen)   Only if unchanged
+	registerCommand("STORE", "((?i)UID )?(?i:STORE) ("+sequenceSet+") (?i:ANNOTATION) \\((.+)\\)$", cmdStoreAnnotation)
+	registerRequest("STORE", "((?i)UID )?(?i:STORE) ("+sequenceSet+") (?:\\((?i:UNCHANGEDSINCE) ([0-9]+)\\) )?([\\+\\-])?(?i:FLAGS(\\.SILENT)?) \\(?([\\\\$A-z0-9\\s]*)\\)?$", func(args commandArgs) (types.Request, error) {
+		return storeRequest(args)
+	}, cmdStoreFlags)
+
+	// COPY 2:4 "Archive"
+	// UID COPY 100:* Trash
+	registerRequest("COPY", "((?i)UID )?(?i:COPY) ("+sequenceSet+") "+mailbox+"$", func(args commandArgs) (types.Request, error) {
+		return copyRequest(args)
+	}, cmdCopy)
 
-	registerCommand("", cmdNA)
+	registerCommand("XBEGIN", "(?i:XBEGIN)$", cmdXBegin)
+	registerCommand("XCOMMIT", "(?i:XCOMMIT)$", cmdXCommit)
+	registerCommand("XROLLBACK", "(?i:XROLLBACK)$", cmdXRollback)
+	registerCommand("", ".*", cmdNA)
 }
 
-func registerCommand(matchExpr string, handleFunc func(commandArgs, *Conn)) error {
-	// Add command identifier to beginning of command
-	matchExpr = "([A-z0-9\\.]+) " + matchExpr
+func registerCommand(name string, matchExpr string, handleFunc func(commandArgs, *Conn)) error {
+	// Add command identifier to beginning of command. Literals are put back
+	// into the command as quoted strings which may hold line breaks, so .
+	// matches them too.
+	matchExpr = "(?s)(" + tagChars + "+) " + matchExpr
 
 	newRE := regexp.MustCompile(matchExpr)
-	c := command{match: newRE, handler: handleFunc}
+	c := command{name: name, match: newRE, handler: handleFunc}
 	commands = append(commands, c)
 	return nil
 }
 
-// Write out the info for a mailbox (used in both SELECT and EXAMINE)
-func writeMailboxInfo(c *Conn, m mailstore.Mailbox) {
-	fmt.Fprintf(c, "* %d EXISTS\r\n", m.Messages())
-	fmt.Fprintf(c, "* %d RECENT\r\n", m.Recent())
-	fmt.Fprintf(c, "* OK [UNSEEN %d]\r\n", m.Unseen())
-	fmt.Fprintf(c, "* OK [UIDNEXT %d]\r\n", m.NextUID())
-	fmt.Fprintf(c, "* OK [UIDVALIDITY %d]\r\n", 250)
-	fmt.Fprintf(c, "* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)\r\n")
+// Write out the info for a mailbox (used in both SELECT and EXAMINE). No
+// flags can be stored in a mailbox opened read-only.
+func writeMailboxInfo(c *Conn, m mailstore.Mailbox, writable WriteMode) {
+	count, _ := mailstore.MessageCount(c.context(), m)
+	c.writeResponse("", fmt.Sprintf("%d EXISTS", count))
+	c.writeResponse("", fmt.Sprintf("%d RECENT", c.recentCount(m)))
+	if unseen := firstUnseen(c.context(), m); unseen > 0 {
+		c.writeOK("", codeUnseen.with(unseen), c.text(MsgFirstUnseen))
+	}
+	c.writeOK("", codeUIDNext.with(m.NextUID()), c.text(MsgPredictedUIDNext))
+	c.writeOK("", codeUIDValidity.with(uidValidity), c.text(MsgUIDsValid))
+	if !stickyUIDs(m) {
+		c.writeNo("", codeUIDNotSticky, c.text(MsgNonPersistentUIDs))
+	}
+	c.writeResponse("", "FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+	if writable == ReadWrite {
+		c.writeOK("", codePermanentFlags.with(permanentFlags(m)), c.text(MsgFlagsPermitted))
+	} else {
+		c.writeOK("", codePermanentFlags.with("()"), c.text(MsgNoPermanentFlags))
+	}
+}
+
+// Whether the UIDs of a mailbox's messages are kept between sessions, which
+// clients rely on to cache messages (RFC 4315 section 3)
+func stickyUIDs(m mailstore.Mailbox) bool {
+	volatile, ok := m.(mailstore.VolatileUIDMailbox)
+	return !ok || !volatile.UIDsNotSticky()
+}
+
+// Find the sequence number of the first message which hasn't been seen, or 0
+// if every message has been seen
+func firstUnseen(ctx context.Context, m mailstore.Mailbox) uint32 {
+	if mailbox, ok := m.(mailstore.FirstUnseenMailbox); ok {
+		return mailbox.FirstUnseen()
+	}
+	msgs, _ := allMessages(ctx, m)
+	for _, msg := range msgs {
+		if !msg.Flags().HasFlags(types.FlagSeen) {
+			return msg.SequenceNumber()
+		}
+	}
+	return 0
 }
 
+// Read every message in a mailbox, in sequence number order
+func allMessages(ctx context.Context, m mailstore.Mailbox) ([]mailstore.Message, error) {
+	count, err := mailstore.MessageCount(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]mailstore.Message, 0, count)
+	for seqno := uint32(1); seqno <= count; seqno++ {
+		msg, err := mailstore.MessageBySequenceNumber(ctx, m, seqno)
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// Read a mailbox name argument, which clients send as an atom or quoted
+// string in modified UTF-7. If the name isn't properly encoded the command is
+// rejected and false returned.
+func (c *Conn) mailboxArg(args commandArgs, index int) (string, bool) {
+	name, err := mailboxName(args.Arg(index))
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return "", false
+	}
+	return name, true
+}
+
+// Format a mailbox name to be sent to the client, as an atom if possible and
+// otherwise as a quoted string
+func (c *Conn) mailboxString(name string) string {
+	encoded := c.encodeMailboxName(name)
+	if util.IsAtom(encoded) {
+		return encoded
+	}
+	return util.Quote(encoded)
+}
+
+// The delimiter between levels of the mailbox hierarchy, or "" if the
+// mailstore's mailbox names are flat
+func (c *Conn) delimiter() string {
+	return mailstore.Delimiter(c.Mailstore)
+}
+
+// The hierarchy delimiter as it appears in LIST and LSUB responses, which is
+// NIL when there is no hierarchy
+func (c *Conn) quotedDelimiter() string {
+	if c.delimiter() == "" {
+		return "NIL"
+	}
+	return util.Quote(c.delimiter())
+}
+
+// Find which flags can be stored in a mailbox
+func permanentFlags(m mailstore.Mailbox) types.PermanentFlags {
+	if policy, ok := m.(mailstore.PermanentFlagsMailbox); ok {
+		return policy.PermanentFlags()
+	}
+	return types.DefaultPermanentFlags
+}
+
+// The syntax of each command, given to clients which send a command with
+// arguments that can't be parsed
+var commandSyntax = map[string]string{
+	"CAPABILITY":   "CAPABILITY",
+	"ENABLE":       "ENABLE <capability> ...",
+	"LANGUAGE":     "LANGUAGE [<language range> ...]",
+	"ID":           "ID NIL | ID (<field> <value> ...)",
+	"LOGIN":        "LOGIN \"<username>\" \"<password>\"",
+	"AUTHENTICATE": "AUTHENTICATE PLAIN|XOAUTH2|OAUTHBEARER [<initial response>]",
+	"GENURLAUTH":   "GENURLAUTH <url rump> INTERNAL ...",
+	"URLFETCH":     "URLFETCH <url> ...",
+	"RESETKEY":     "RESETKEY [<mailbox> [INTERNAL]]",
+	"LIST":         "LIST <reference> <mailbox pattern>",
+	"XLIST":        "XLIST <reference> <mailbox pattern>",
+	"CREATE":       "CREATE <mailbox>",
+	"DELETE":       "DELETE <mailbox>",
+	"RENAME":       "RENAME <mailbox> <new name>",
+	"LSUB":         "LSUB <reference> <mailbox pattern>",
+	"SUBSCRIBE":    "SUBSCRIBE <mailbox>",
+	"UNSUBSCRIBE":  "UNSUBSCRIBE <mailbox>",
+	"LOGOUT":       "LOGOUT",
+	"NOOP":         "NOOP",
+	"CLOSE":        "CLOSE",
+	"EXPUNGE":      "EXPUNGE",
+	"CHECK":        "CHECK",
+	"SELECT":       "SELECT <mailbox> [(CONDSTORE|QRESYNC (<uidvalidity> <mod-sequence> [<known uids>]))]",
+	"EXAMINE":      "EXAMINE <mailbox> [(CONDSTORE|QRESYNC (<uidvalidity> <mod-sequence> [<known uids>]))]",
+	"STATUS":       "STATUS <mailbox> (<status item> ...)",
+	"FETCH":        "[UID] FETCH <sequence set> ALL|FAST|FULL|<data item>|(<data item> ...) [(CHANGEDSINCE <mod-sequence> [VANISHED])]",
+	"APPEND":       "APPEND <mailbox> [(<flags>)] [\"<date>\"] {<length>}",
+	"REPLACE":      "[UID] REPLACE <message> <mailbox> [(<flags>)] [\"<date>\"] {<length>}",
+	"SEARCH":       "[UID] SEARCH [RETURN (<option> ...)] [CHARSET <charset>] <search key> ...",
+	"ESEARCH":      "ESEARCH [IN (<mailbox filter> ...)] [RETURN (<option> ...)] [CHARSET <charset>] <search key> ...",
+	"SORT":         "[UID] SORT [RETURN (<option> ...)] (<sort key> ...) <charset> <search key> ...",
+	"CANCELUPDATE": "CANCELUPDATE <tag> ...",
+	"STORE":        "[UID] STORE <sequence set> [(UNCHANGEDSINCE <mod-sequence>)] [+|-]FLAGS[.SILENT] (<flags>)",
+	"COPY":         "[UID] COPY <sequence set> <mailbox>",
+	"XBEGIN":       "XBEGIN",
+	"XCOMMIT":      "XCOMMIT",
+	"XROLLBACK":    "XROLLBACK",
+}
+
+// Handles any command which did not match a registered command, explaining
+// to the client what it got wrong
 func cmdNA(args commandArgs, c *Conn) {
-	c.writeResponse(args.ID(), "BAD Not implemented")
+	verb := strings.TrimPrefix(commandVerb(args.FullCommand()), "UID ")
+	if verb == "" {
+		c.writeBad(args.ID(), codeNone, c.text(MsgMissingCommand))
+		return
+	}
+	err := syntaxError(args.FullCommand())
+	if err == nil {
+		c.logf("Unknown command %q\n", verb)
+		c.writeBad(args.ID(), codeNone, c.text(MsgUnknownCommand, verb))
+		return
+	}
+
+	c.logf("Syntax error: %q does not match %s\n", args.FullCommand(), err.Expected)
+	if err.Token == "" {
+		c.writeBad(args.ID(), codeNone, c.text(MsgUnexpectedEnd, err.Verb, err.Position, err.Expected))
+		return
+	}
+	c.writeBad(args.ID(), codeNone, c.text(MsgUnexpectedToken, err.Verb, err.Token, err.Position, err.Expected))
 }
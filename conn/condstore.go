@@ -0,0 +1,203 @@
+package conn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// The change log of the selected mailbox, from which mod-sequences are
+// found, if it keeps one
+func (c *Conn) selectedChangeLog() (mailstore.ChangeLog, bool) {
+	return changeLog(c.SelectedMailbox)
+}
+
+// The change log of a mailbox, if it keeps one. A mailbox seen through a
+// SoftDeleteView has a nil log if the mailbox underneath doesn't keep one.
+func changeLog(m mailstore.Mailbox) (mailstore.ChangeLog, bool) {
+	mailbox, ok := m.(mailstore.ChangeLogMailbox)
+	if !ok {
+		return nil, false
+	}
+	log := mailbox.ChangeLog()
+	return log, log != nil
+}
+
+// Tell the client the highest mod-sequence of the selected mailbox, or that
+// it has none if it doesn't keep a change log (RFC 7162 section 3.1.2)
+func (c *Conn) writeHighestModSeq() {
+	log, ok := c.selectedChangeLog()
+	if !ok {
+		c.writeOK("", codeNoModSeq, c.text(MsgModSeqUnsupported))
+		return
+	}
+	highest, err := log.HighestModSeq()
+	if err != nil {
+		c.writeOK("", codeNoModSeq, c.text(MsgModSeqUnavailable))
+		return
+	}
+	c.writeOK("", codeHighestModSeq.with(highest), c.text(MsgHighestModSeq))
+}
+
+// Turn on CONDSTORE for a command which uses it, eg FETCH with CHANGEDSINCE
+// or STORE with UNCHANGEDSINCE, and return the selected mailbox's change
+// log. A mailbox without mod-sequences can't take part in such commands
+// (RFC 7162 section 3.1.2.2), so the command is rejected and false returned.
+func (c *Conn) enableCondStore(tag string) (mailstore.ChangeLog, bool) {
+	log, ok := c.selectedChangeLog()
+	if !c.QResync || !ok {
+		c.writeBad(tag, codeNone, c.text(MsgModSeqUnsupported))
+		return nil, false
+	}
+	c.condStoreEnabled = true
+	return log, true
+}
+
+// The messages changed after the given mod-sequence, in the order given
+func changedSince(log mailstore.ChangeLog, msgs []mailstore.Message, modseq uint64) ([]mailstore.Message, error) {
+	changed := make([]mailstore.Message, 0)
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		msgModSeq, err := mailstore.MessageModSeq(log, msg.UID())
+		if err != nil {
+			return nil, err
+		}
+		if msgModSeq > modseq {
+			changed = append(changed, msg)
+		}
+	}
+	return changed, nil
+}
+
+// The highest mod-sequence of a set of messages, sent with the results of a
+// search using the MODSEQ key (RFC 7162 section 3.1.5)
+func highestModSeq(log mailstore.ChangeLog, msgs []mailstore.Message) (uint64, error) {
+	var highest uint64
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		modseq, err := mailstore.MessageModSeq(log, msg.UID())
+		if err != nil {
+			return 0, err
+		}
+		if modseq > highest {
+			highest = modseq
+		}
+	}
+	return highest, nil
+}
+
+// The highest mod-sequence of the results of a search, if its criteria use
+// the MODSEQ key and the mailbox keeps a change log, or else 0. There is
+// none if there are no results.
+func searchModSeq(mailbox mailstore.Mailbox, criteria types.SearchCriteria, msgs []mailstore.Message) (uint64, error) {
+	log, ok := changeLog(mailbox)
+	if !ok || !usesKey(criteria, "MODSEQ") {
+		return 0, nil
+	}
+	return highestModSeq(log, msgs)
+}
+
+// Add the highest mod-sequence of the results to a SEARCH or SORT response,
+// if there is one
+func withModSeq(response string, modseq uint64) string {
+	if modseq == 0 {
+		return response
+	}
+	return fmt.Sprintf("%s (MODSEQ %d)", response, modseq)
+}
+
+// Tell the client which of the given UIDs were expunged from the selected
+// mailbox after a mod-sequence, with a VANISHED (EARLIER) response. All
+// expunged messages are reported if uids is nil. Nothing is sent if none
+// were expunged.
+func (c *Conn) writeVanishedEarlier(log mailstore.ChangeLog, modseq uint64, uids types.UIDSet) error {
+	expunged, err := mailstore.ExpungedSince(log, modseq)
+	if err != nil {
+		return err
+	}
+	last := c.SelectedMailbox.NextUID() - 1
+	vanished := make([]uint32, 0, len(expunged))
+	for _, uid := range expunged {
+		if uids == nil || uids.Contains(uid, last) {
+			vanished = append(vanished, uid)
+		}
+	}
+	if len(vanished) == 0 {
+		return nil
+	}
+	sort.Slice(vanished, func(i, j int) bool { return vanished[i] < vanished[j] })
+	c.writeResponse("", "VANISHED (EARLIER) "+types.NewUIDSet(vanished).String())
+	return nil
+}
+
+// Bring a client which selected the mailbox with QRESYNC up to date with
+// what changed after the mod-sequence it knows of: the messages expunged
+// since, and the flags of the messages changed since (RFC 7162 section
+// 3.2.5.1). If the client's UIDs are no longer valid it is told nothing
+// more, and must forget what it knew.
+func (c *Conn) writeQResync(params types.QResyncParams) error {
+	log, ok := c.selectedChangeLog()
+	if !ok || params.UIDValidity != uidValidity {
+		return nil
+	}
+	if err := c.writeVanishedEarlier(log, params.ModSeq, params.KnownUIDs); err != nil {
+		return err
+	}
+
+	all, _ := types.InterpretSequenceSet("1:*")
+	msgs, err := c.messagesBySequenceSet(all)
+	if err != nil {
+		return err
+	}
+	changed, err := changedSince(log, msgs, params.ModSeq)
+	if err != nil {
+		return err
+	}
+	items := c.flagFetchItems(true)
+	for _, msg := range changed {
+		seqno, known := c.sequenceNumber(msg)
+		if !known {
+			continue
+		}
+		data, err := fetch(items, c, msg)
+		if err != nil {
+			return err
+		}
+		c.writeResponse("", fmt.Sprintf("%d FETCH (%s)", seqno, data))
+	}
+	return nil
+}
+
+// Fetch the mod-sequence of a message. Asking for it turns on CONDSTORE for
+// the rest of the session (RFC 7162 section 3.1).
+func fetchModSeq(attr types.FetchAttribute, c *Conn, m mailstore.Message) (string, error) {
+	log, ok := c.selectedChangeLog()
+	if !c.QResync || !ok {
+		return "", ErrUnrecognisedParameter
+	}
+	c.condStoreEnabled = true
+	modseq, err := mailstore.MessageModSeq(log, m.UID())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("MODSEQ (%d)", modseq), nil
+}
+
+// The data items sent back when a message's flags change, which include its
+// new mod-sequence once the client has enabled CONDSTORE
+func (c *Conn) flagFetchItems(uid bool) string {
+	items := "FLAGS"
+	if uid {
+		items = "UID FLAGS"
+	}
+	if _, ok := c.selectedChangeLog(); ok && c.condStoreEnabled {
+		items += " MODSEQ"
+	}
+	return items
+}
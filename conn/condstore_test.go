@@ -0,0 +1,174 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("CONDSTORE and QRESYNC", func() {
+	Context("When a mailbox with a change log is selected", func() {
+		BeforeEach(func() {
+			tConn.QResync = true
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should only fetch messages changed since a mod-sequence", func() {
+			SendLine("abcd.123 UID FETCH 1:* (FLAGS) (CHANGEDSINCE 2)")
+			ExpectResponse("* 3 FETCH (FLAGS (\\Recent) UID 12 MODSEQ (3))")
+			ExpectResponse("abcd.123 OK UID FETCH completed")
+
+			SendLine("abcd.124 FETCH 1:* FLAGS (CHANGEDSINCE 3)")
+			ExpectResponse("abcd.124 OK FETCH completed")
+		})
+
+		It("should refuse VANISHED unless QRESYNC is enabled", func() {
+			SendLine("abcd.123 UID FETCH 1:* (FLAGS) (CHANGEDSINCE 2 VANISHED)")
+			ExpectResponse("abcd.123 BAD VANISHED requires QRESYNC to be enabled")
+		})
+
+		It("should refuse VANISHED without UID", func() {
+			SendLine("abcd.123 FETCH 1:* (FLAGS) (CHANGEDSINCE 2 VANISHED)")
+			ExpectResponse("abcd.123 BAD VANISHED can only be given to UID FETCH with CHANGEDSINCE")
+		})
+
+		It("should only store flags on messages unchanged since a mod-sequence", func() {
+			SendLine("abcd.123 STORE 1:3 (UNCHANGEDSINCE 2) +FLAGS (\\Seen)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Recent) MODSEQ (4))")
+			ExpectResponse("* 2 FETCH (FLAGS (\\Seen \\Recent) MODSEQ (5))")
+			ExpectResponse("abcd.123 OK [MODIFIED 3] Conditional STORE failed")
+
+			SendLine("abcd.124 UID STORE 10:12 (UNCHANGEDSINCE 4) FLAGS.SILENT (\\Flagged)")
+			ExpectResponse("* 1 FETCH (UID 10 MODSEQ (6))")
+			ExpectResponse("* 3 FETCH (UID 12 MODSEQ (7))")
+			ExpectResponse("abcd.124 OK [MODIFIED 11] Conditional STORE failed")
+		})
+
+		It("should search by mod-sequence", func() {
+			SendLine("abcd.123 SEARCH MODSEQ 2")
+			ExpectResponse("* SEARCH 2 3 (MODSEQ 3)")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+
+			SendLine("abcd.124 UID SEARCH RETURN (ALL) MODSEQ \"/flags/\\\\seen\" all 2")
+			ExpectResponse("* ESEARCH (TAG \"abcd.124\") UID ALL 11:12 MODSEQ 3")
+			ExpectResponse("abcd.124 OK UID SEARCH completed")
+
+			SendLine("abcd.125 SEARCH MODSEQ 4")
+			ExpectResponse("* SEARCH")
+			ExpectResponse("abcd.125 OK SEARCH completed")
+		})
+
+		Context("but CONDSTORE isn't offered", func() {
+			BeforeEach(func() {
+				tConn.QResync = false
+			})
+
+			It("should refuse the CONDSTORE modifiers", func() {
+				SendLine("abcd.123 FETCH 1:* (FLAGS) (CHANGEDSINCE 2)")
+				ExpectResponse("abcd.123 BAD Mod-sequences are not supported for this mailbox")
+				SendLine("abcd.124 STORE 1 (UNCHANGEDSINCE 2) +FLAGS (\\Seen)")
+				ExpectResponse("abcd.124 BAD Mod-sequences are not supported for this mailbox")
+				SendLine("abcd.125 SEARCH MODSEQ 2")
+				ExpectResponse("abcd.125 BAD Mod-sequences are not supported for this mailbox")
+			})
+		})
+	})
+
+	Context("When QRESYNC is enabled", func() {
+		BeforeEach(func() {
+			tConn.QResync = true
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		// Enable QRESYNC, then flag the last message as seen and expunge the
+		// first, so that the mailbox is at mod-sequence 6
+		changeInbox := func() {
+			SendLine("abcd.100 ENABLE QRESYNC")
+			ExpectResponse("* ENABLED QRESYNC")
+			ExpectResponse("abcd.100 OK ENABLE completed")
+			SendLine("abcd.101 SELECT INBOX")
+			ReadUntilTagged("abcd.101")
+			SendLine("abcd.102 STORE 3 +FLAGS.SILENT (\\Seen)")
+			ExpectResponse("* 3 FETCH (MODSEQ (4))")
+			ExpectResponse("abcd.102 OK STORE completed")
+			SendLine("abcd.103 STORE 1 +FLAGS.SILENT (\\Deleted)")
+			ExpectResponse("* 1 FETCH (MODSEQ (5))")
+			ExpectResponse("abcd.103 OK STORE completed")
+			SendLine("abcd.104 EXPUNGE")
+			ExpectResponse("* VANISHED 10")
+			ExpectResponse("abcd.104 OK EXPUNGE completed")
+		}
+
+		It("should report what changed since the client's mod-sequence on SELECT", func() {
+			changeInbox()
+			SendLine("abcd.123 SELECT INBOX (QRESYNC (250 3 10:12))")
+			ExpectResponse("* OK [CLOSED] Previous mailbox closed")
+			ExpectResponse("* 2 EXISTS")
+			ExpectResponse("* 0 RECENT")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
+			ExpectResponse("* OK [HIGHESTMODSEQ 6] Highest mod-sequence")
+			ExpectResponse("* VANISHED (EARLIER) 10")
+			ExpectResponse("* 2 FETCH (UID 12 FLAGS (\\Seen) MODSEQ (4))")
+			ExpectResponse("abcd.123 OK [READ-WRITE] SELECT completed")
+		})
+
+		It("should report nothing more if the client's UIDs are no longer valid", func() {
+			changeInbox()
+			SendLine("abcd.123 EXAMINE INBOX (QRESYNC (249 3))")
+			ExpectResponse("* OK [CLOSED] Previous mailbox closed")
+			ExpectResponse("* 2 EXISTS")
+			ExpectResponse("* 0 RECENT")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS ()] No permanent flags permitted")
+			ExpectResponse("* OK [HIGHESTMODSEQ 6] Highest mod-sequence")
+			ExpectResponse("abcd.123 OK [READ-ONLY] EXAMINE completed")
+		})
+
+		It("should report messages expunged since a mod-sequence on UID FETCH", func() {
+			changeInbox()
+			SendLine("abcd.123 UID FETCH 1:* (FLAGS) (CHANGEDSINCE 3 VANISHED)")
+			ExpectResponse("* VANISHED (EARLIER) 10")
+			ExpectResponse("* 2 FETCH (FLAGS (\\Seen \\Recent) UID 12 MODSEQ (4))")
+			ExpectResponse("abcd.123 OK UID FETCH completed")
+		})
+	})
+
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.QResync = true
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should refuse QRESYNC parameters until QRESYNC is enabled", func() {
+			SendLine("abcd.123 SELECT INBOX (QRESYNC (250 3))")
+			ExpectResponse("abcd.123 BAD QRESYNC must be enabled first")
+		})
+
+		It("should enable CONDSTORE when selecting a mailbox", func() {
+			SendLine("abcd.123 SELECT INBOX (CONDSTORE)")
+			ReadUntilTagged("abcd.123")
+			SendLine("abcd.124 STORE 1 +FLAGS (\\Seen)")
+			ExpectResponse("* 1 FETCH (FLAGS (\\Seen \\Recent) MODSEQ (4))")
+			ExpectResponse("abcd.124 OK STORE completed")
+		})
+
+		It("should refuse malformed QRESYNC parameters", func() {
+			SendLine("abcd.123 ENABLE QRESYNC")
+			ExpectResponse("* ENABLED QRESYNC")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+			SendLine("abcd.124 SELECT INBOX (QRESYNC (250))")
+			ExpectResponse("abcd.124 BAD invalid QRESYNC parameters")
+		})
+	})
+})
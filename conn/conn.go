@@ -2,12 +2,21 @@ package conn
 
 import (
 	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
 )
 
 type connState int
@@ -29,28 +38,158 @@ const (
 
 const lineEnding string = "\r\n"
 
+// Responses are collected into a buffer of up to this size before being
+// written to the connection, so that bursts of untagged responses (eg
+// hundreds of EXPUNGEs) don't each require a separate write
+const coalesceBufferSize = 16 * 1024
+
 // Conn represents a client connection to the IMAP server
 type Conn struct {
+	ID              string // Correlation ID identifying this connection in transcripts
+	commandCount    uint64 // Number of commands received, used to correlate individual commands
 	state           connState
 	Rwc             io.ReadWriteCloser
-	RwcScanner      *bufio.Scanner // Provides an interface for scanning lines from the connection
+	writer          *bufio.Writer // Coalesces responses before they are written to Rwc
+	writeMutex      sync.Mutex    // Guards writer, which ForceLogout uses from other goroutines
+	outOfBandMutex  sync.Mutex    // Held while an alert or forced logout is sent from another goroutine
+	RwcReader       *bufio.Reader // Buffers input from the connection, for reading lines and literals
 	Transcript      io.Writer
 	Mailstore       mailstore.Mailstore // Pointer to the IMAP server's mailstore to which this connection belongs
 	User            mailstore.User
+	username        string     // Name the user logged in with
+	usernameMutex   sync.Mutex // Guards changes to username, which ForceLogout reads
 	SelectedMailbox mailstore.Mailbox
 	mailboxWritable WriteMode // True if write access is allowed to the currently selected mailbox
+	AppendLimit     uint32    // Maximum size in bytes of a message that may be APPENDed, or 0 for no limit
+	MaxLineLength   uint32    // Maximum length in bytes of a line of command text, or 0 for no limit
+	MaxLiteralSize  uint32    // Maximum size in bytes of any literal, or 0 for no limit
+
+	// Approximate memory in bytes the connection may hold, or 0 for no
+	// limit. Over the limit, responses are sent without coalescing, old
+	// search results are forgotten and large literals are refused.
+	MemoryLimit  uint64
+	literalBytes uint64 // Size of the literals read by the current command
+
+	// Percentages of the user's quota at which an [ALERT] is sent to warn them
+	QuotaWarningThresholds []uint8
+	quotaWarned            uint8 // Highest threshold the user has already been warned about
+
+	// Returns the IMAP URL of the server a user should log in to instead,
+	// or an empty string if they should log in to this server
+	LoginReferral func(username string) (url string)
+
+	// Name of the server, announced in the greeting and ID responses
+	Hostname string
+
+	// Hides details of the server software from the greeting and ID
+	Stealth bool
+
+	// Translations of response text, offered to clients with LANGUAGE
+	Catalog  Catalog
+	language string // Language tag chosen by the client, or blank for the default
+
+	// How often long-running commands (eg SEARCH) report their progress,
+	// or 0 to never report it
+	ProgressInterval time.Duration
+
+	// Capabilities advertised in addition to those of the server itself, eg
+	// hints for the operator's own client apps
+	ExtraCapabilities []string
+
+	// Proprietary FETCH data items offered in addition to the standard ones
+	FetchItems []FetchItem
+
+	// Allows clients to batch changes with XBEGIN and XCOMMIT, if the
+	// selected mailbox implements mailstore.TransactionMailbox
+	Transactions bool
+	transaction  mailstore.Transaction // The open transaction, if any
+
+	// Mailboxes changed in the open transaction, to be announced once it's
+	// committed
+	transactionChanges map[string]bool
+
+	// Offers UTF8=ACCEPT with the ENABLE command, after which the client may
+	// send UTF-8 outside literals and is sent mailbox names in UTF-8
+	UTF8Accept  bool
+	utf8Enabled bool // The client has enabled UTF8=ACCEPT
+
+	// Ranks messages against the text keys of FUZZY searches, for mailboxes
+	// without a mailstore.RankedSearchMailbox index, or nil to use
+	// search.DefaultRanker
+	FuzzyRanker search.Ranker
+
+	// Usernames of the submission servers which may fetch URLAUTH URLs
+	// issued to other users for submission (submit+)
+	SubmitServers []string
+
+	// Offers CONDSTORE and QRESYNC (RFC 7162) with the ENABLE command, so
+	// that clients are told the mod-sequences of messages in mailboxes
+	// which implement mailstore.ChangeLogMailbox
+	QResync          bool
+	condStoreEnabled bool // The client has enabled CONDSTORE or QRESYNC
+	qresyncEnabled   bool // The client has enabled QRESYNC
+
+	// Commands (eg "DELETE") and capabilities (eg "AUTH=PLAIN") which may
+	// not be used on this connection
+	DisabledCommands []string
+
+	// Called with a record of the session when it ends, if set
+	RecordSession func(SessionRecord)
+	record        *SessionRecord
+	recordMutex   sync.Mutex
+
+	// Called with each line written to the transcript and the correlation
+	// ID it's tagged with, if set, eg to send them to a structured logger
+	Logger func(correlationID string, line string)
+
+	// Called with the command's context and a summary of each command once
+	// it has finished, if set, eg to export latency and error metrics
+	CommandMetrics func(ctx context.Context, metric CommandMetric)
+	commandStatus  string // The status of the tagged response to the command, eg "OK"
+
+	// Called with the command's context and a description of each login,
+	// logout, mailbox deletion and rename, if set, to keep an audit trail
+	Audit func(ctx context.Context, event AuditEvent)
+
+	// Tracks which mailbox each of the server's connections has selected, so
+	// that they can be told about changes made by this one
+	Sessions SessionRegistry
+
+	inFlight      *CommandInfo // The command currently being executed, if any
+	inFlightMutex sync.Mutex
+
+	// Longest the mailstore may spend on a single command before its
+	// context's deadline passes, or 0 for no limit
+	CommandTimeout time.Duration
+	connCtx        context.Context    // Cancelled when the connection drops
+	commandCtx     context.Context    // The context of the command being handled
+	cancelCommand  context.CancelFunc // Ends commandCtx
+
+	pendingUpdates map[mailboxUpdate]bool // Mailboxes changed by other connections
+	view           mailboxView            // The selected mailbox as the client last saw it
+	recent         map[uint32]bool        // UIDs of messages claimed as recent by this session
+	updatesMutex   sync.Mutex
+
+	searchCache      map[searchCacheKey][]mailstore.Message // Results of recent searches
+	searchCacheOrder []searchCacheKey                       // Cached searches, oldest first
+	searchCacheMutex sync.Mutex
+
+	searchUpdates []searchUpdate // Searches whose results are kept up to date
 }
 
 func NewConn(mailstore mailstore.Mailstore, netConn io.ReadWriteCloser, transcript io.Writer) (c *Conn) {
 	c = new(Conn)
+	c.ID = newCorrelationID()
 	c.Mailstore = mailstore
 	c.Rwc = netConn
+	c.writer = bufio.NewWriterSize(netConn, coalesceBufferSize)
 	c.Transcript = transcript
 	return c
 }
 
 func (c *Conn) SetState(state connState) {
 	c.state = state
+	c.registerSelection()
 
 	// As a precaution, reset any mailbox write access when changing states
 	c.SetReadOnly()
@@ -60,21 +199,100 @@ func (c *Conn) SetReadOnly()  { c.mailboxWritable = ReadOnly }
 func (c *Conn) SetReadWrite() { c.mailboxWritable = ReadWrite }
 
 func (c *Conn) handleRequest(req string) {
+	c.beginCommand(req)
+	defer c.endCommand()
+	c.beginCommandContext()
+	defer c.endCommandContext()
+	defer c.reportCommand(commandVerb(req), time.Now())
+	defer c.releaseLiterals()
+	c.recordCommand(req)
+	defer c.endRecordedCommand()
+
+	c.checkQuotaWarnings()
+	if c.transaction == nil {
+		c.sendMailboxUpdates(commandVerb(req))
+	}
+
+	// The tag is checked first, so that every other error can be reported
+	// with it
+	tag, rest, ok := splitTag(req)
+	if !ok {
+		c.writeBad("", codeNone, c.text(MsgMissingTag))
+		return
+	}
+	if rest == "" {
+		c.writeBad(tag, codeNone, c.text(MsgMissingCommand))
+		return
+	}
+
 	for _, cmd := range commands {
 		matches := cmd.match.FindStringSubmatch(req)
 		if len(matches) > 0 {
-			cmd.handler(matches, c)
+			// A refused command's message literal is drained before the
+			// client is answered
+			switch {
+			case (cmd.name == "LOGIN" || cmd.name == "AUTHENTICATE") && c.loginDisabled():
+				c.writeNo(tag, codeCannot, c.text(MsgLoginDisabled))
+				return
+			case cmd.name != "" && c.commandDisabled(cmd.name):
+				c.drainMessageLiteral(cmd, req)
+				c.writeNo(tag, codeCannot, c.text(MsgCommandDisabled, cmd.name))
+				return
+			case !stateCommands[c.state][cmd.name]:
+				c.drainMessageLiteral(cmd, req)
+				c.writeStateError(tag, cmd)
+				return
+			case c.transaction != nil && !transactionCommands[cmd.name]:
+				c.drainMessageLiteral(cmd, req)
+				c.writeBad(tag, codeNone, c.text(MsgNotInTransaction))
+				return
+			}
+			c.runHandler(cmd, matches)
 			return
 		}
 	}
 
-	c.writeResponse("", "BAD Command not understood")
+	c.writeBad(tag, codeNone, c.text(MsgCommandNotUnderstood))
 }
 
+// Run a command's handler. A handler which panics has hit a bug in the
+// server, which the client is told about instead of being disconnected.
+func (c *Conn) runHandler(cmd command, args commandArgs) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logf("Panic handling %s: %v\n%s", cmd.name, r, debug.Stack())
+			c.writeNo(args.ID(), codeServerBug, c.text(MsgServerBug))
+		}
+	}()
+	cmd.handler(args, c)
+}
+
+// Write buffers response data to be sent to the client. The buffer is
+// flushed when it fills up and whenever the server waits for client input.
 func (c *Conn) Write(p []byte) (n int, err error) {
-	fmt.Fprintf(c.Transcript, "S: %s", p)
+	c.logf("S: %s", p)
+	c.recordResponse(p)
 
-	return c.Rwc.Write(p)
+	overLimit := c.exceedsMemoryLimit(uint64(len(p)))
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	n, err = c.writer.Write(p)
+	if err == nil && overLimit {
+		err = c.writer.Flush()
+	}
+	return n, err
+}
+
+// Flush sends any buffered responses to the client
+func (c *Conn) Flush() error {
+	c.writeMutex.Lock()
+	err := c.writer.Flush()
+	c.writeMutex.Unlock()
+	if err != nil {
+		c.logf("Write error: %s\n", err)
+	}
+	return err
 }
 
 // Write a response to the client
@@ -83,10 +301,11 @@ func (c *Conn) writeResponse(seq string, command string) {
 		seq = "*"
 	}
 	// Ensure the command is terminated with a line ending
-	if !strings.HasSuffix(command, lineEnding) {
-		command += lineEnding
+	command = strings.TrimSuffix(command, lineEnding)
+	if seq != "*" && seq != "+" {
+		c.commandStatus = strings.SplitN(command, " ", 2)[0]
 	}
-	fmt.Fprintf(c, "%s %s", seq, command)
+	fmt.Fprintf(c, "%s %s%s", seq, command, lineEnding)
 }
 
 // Send the server greeting to the client
@@ -94,73 +313,125 @@ func (c *Conn) sendWelcome() error {
 	if c.state != StateNew {
 		return errors.New("Welcome already sent")
 	}
-	c.writeResponse("", "OK IMAP4rev1 Service Ready")
+	c.writeResponse("", c.greeting())
 	c.SetState(StateNotAuthenticated)
 	return nil
 }
 
-func (c *Conn) assertAuthenticated(seq string) bool {
-	if c.state != StateAuthenticated && c.state != StateSelected {
-		c.writeResponse(seq, "BAD not authenticated")
-		return false
-	}
-
-	if c.User == nil {
-		panic("In authenticated state but no user is set")
-	}
-
-	return true
+// Move to the authenticated state once a user has logged in
+func (c *Conn) setAuthenticated(username string, user mailstore.User) {
+	c.User = user
+	c.usernameMutex.Lock()
+	c.username = username
+	c.usernameMutex.Unlock()
+	c.SetState(StateAuthenticated)
+	c.audit(AuditEvent{Action: "LOGIN", Username: username})
 }
 
-func (c *Conn) assertSelected(seq string, writable WriteMode) bool {
-	// Ensure we are authenticated first
-	if !c.assertAuthenticated(seq) {
-		return false
-	}
-
-	if c.state != StateSelected {
-		c.writeResponse(seq, "BAD not selected")
-		return false
-	}
-
-	if c.SelectedMailbox == nil {
-		panic("In selected state but no selected mailbox is set")
-	}
+// CommandID returns the correlation ID of the command currently being
+// handled (or most recently handled) on this connection
+func (c *Conn) CommandID() string {
+	return fmt.Sprintf("%s.%d", c.ID, c.commandCount)
+}
 
-	if writable == ReadWrite && c.mailboxWritable != ReadWrite {
-		c.writeResponse(seq, "NO Selected mailbox is READONLY")
-		return false
+// Write a line to the transcript, tagged with the correlation ID of the
+// current command so that it can be traced across log outputs
+func (c *Conn) logf(format string, args ...interface{}) {
+	id := c.CommandID()
+	line := fmt.Sprintf(format, args...)
+	fmt.Fprintf(c.Transcript, "[%s] %s", id, line)
+	if c.Logger != nil {
+		c.Logger(id, line)
 	}
+}
 
-	return true
+// Generate a random identifier for a new connection
+func newCorrelationID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
 }
 
 // Close forces the server to close the client's connection
 func (c *Conn) Close() error {
-	fmt.Fprintf(c.Transcript, "Server closing connection\n")
+	c.logf("Server closing connection\n")
 	return c.Rwc.Close()
 }
 
-// ReadLine awaits a single line from the client
+// ReadLine awaits a single line from the client, ended by CRLF (or a bare
+// LF). The line is returned without its ending. A line longer than
+// MaxLineLength is cut short; use readLine to tell when this has happened.
 func (c *Conn) ReadLine() (text string, ok bool) {
-	ok = c.RwcScanner.Scan()
-	return c.RwcScanner.Text(), ok
+	text, _, ok = c.readLine()
+	return text, ok
+}
+
+// The number of bytes from the end of an over-long line which readLine
+// keeps, enough to hold any literal announced there
+const lineTailLength = 24
+
+// Read a line from the client. A line longer than MaxLineLength is read to
+// its end but not held in memory: only its first MaxLineLength bytes and
+// its last few bytes are returned, with tooLong set.
+func (c *Conn) readLine() (text string, tooLong bool, ok bool) {
+	c.Flush()
+	var line, tail []byte
+	for {
+		chunk, err := c.RwcReader.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			// Allow for the CRLF, which isn't counted as part of the line
+			if c.MaxLineLength > 0 && len(line) > int(c.MaxLineLength)+2 {
+				tooLong = true
+				tail = append(tail, line[c.MaxLineLength:]...)
+				line = line[:c.MaxLineLength]
+			}
+		} else {
+			tail = append(tail, chunk...)
+		}
+		if len(tail) > lineTailLength {
+			tail = append(tail[:0], tail[len(tail)-lineTailLength:]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				c.logf("Read error: %s\n", err)
+			}
+			// A final line without an ending is still handled
+			if len(line) == 0 {
+				return "", false, false
+			}
+		}
+		break
+	}
+
+	text = string(append(line, tail...))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	if !tooLong && c.MaxLineLength > 0 && len(text) > int(c.MaxLineLength) {
+		tooLong = true
+	}
+	return text, tooLong, true
 }
 
 // Reads data from the connection up to the length specified
 func (c *Conn) ReadFixedLength(length int) (data []byte, err error) {
+	c.Flush()
+
 	// Read the whole message into a buffer
+	atomic.AddUint64(&c.literalBytes, uint64(length))
 	data = make([]byte, length)
-	receivedLength := 0
-	for receivedLength < length {
-		bytesRead, err := c.Rwc.Read(data[receivedLength:])
-		if err != nil {
-			return data, err
-		}
-		receivedLength += bytesRead
-	}
+	_, err = io.ReadFull(c.RwcReader, data)
+	return data, err
+}
 
-	return data, nil
+// Read and throw away data from the connection up to the length specified,
+// without holding it in memory
+func (c *Conn) discardFixedLength(length int64) error {
+	c.Flush()
+	_, err := io.CopyN(ioutil.Discard, c.RwcReader, length)
+	return err
 }
 
 // Start tells the server to start communicating with the client (after
@@ -170,7 +441,16 @@ func (c *Conn) Start() error {
 		return errors.New("No connection exists")
 	}
 
-	c.RwcScanner = bufio.NewScanner(c.Rwc)
+	// Input is queued as it arrives so that pipelined commands can't
+	// deadlock the connection, and then read as lines and literals
+	var cancel context.CancelFunc
+	c.connCtx, cancel = context.WithCancel(mailstore.WithCorrelationID(context.Background(), c.ID))
+	defer cancel()
+	input := newInputQueue(c.Rwc, cancel)
+	defer input.stop()
+	c.RwcReader = bufio.NewReader(input)
+	c.startRecording()
+	defer c.finishRecording()
 
 	for c.state != StateLoggedOut {
 		// Always send welcome message if we are still in new connection state
@@ -179,19 +459,19 @@ func (c *Conn) Start() error {
 		}
 
 		// Await requests from the client
-		req, ok := c.ReadLine()
+		req, rejected, ok := c.readCommand()
 		if !ok {
 			// The client has closed the connection
 			c.state = StateLoggedOut
 			break
 		}
-		fmt.Fprintf(c.Transcript, "C: %s\n", req)
-		c.handleRequest(req)
-
-		if c.RwcScanner.Err() != nil {
-			fmt.Fprintf(c.Transcript, "Scan error: %s\n", c.RwcScanner.Err())
+		c.commandCount++
+		c.logf("C: %s\n", req)
+		if !rejected {
+			c.handleRequest(req)
 		}
 	}
 
+	c.abandonTransaction()
 	return nil
 }
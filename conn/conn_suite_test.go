@@ -51,6 +51,17 @@ func ExpectResponsePattern(pattern string) {
 	Expect(response, err).To(MatchRegexp(pattern))
 }
 
+// Read responses up to the tagged response to a command, which is returned
+func ReadUntilTagged(tag string) string {
+	for {
+		response, err := reader.ReadLine()
+		Expect(err).ToNot(HaveOccurred())
+		if strings.HasPrefix(response, tag+" ") {
+			return response
+		}
+	}
+}
+
 // === SETUP ====
 var _ = BeforeEach(func() {
 	mStore = mailstore.NewDummyMailstore()
@@ -0,0 +1,45 @@
+package conn
+
+import (
+	"context"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// Each command's mailstore operations are given a context, so that stores
+// implementing the mailstore package's Context interfaces can stop work that
+// nobody is waiting for. The context is cancelled when the connection drops,
+// and ends after CommandTimeout if one is set. It carries the command's
+// correlation ID, which mailstore.CorrelationID reads.
+
+// Start the context of a new command
+func (c *Conn) beginCommandContext() {
+	parent := c.connCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	parent = mailstore.WithCorrelationID(parent, c.CommandID())
+	if c.CommandTimeout > 0 {
+		c.commandCtx, c.cancelCommand = context.WithTimeout(parent, c.CommandTimeout)
+	} else {
+		c.commandCtx, c.cancelCommand = context.WithCancel(parent)
+	}
+}
+
+// End the context of the command which has finished
+func (c *Conn) endCommandContext() {
+	c.cancelCommand()
+	c.commandCtx, c.cancelCommand = nil, nil
+}
+
+// The context of the command being handled, or of the connection between
+// commands
+func (c *Conn) context() context.Context {
+	if c.commandCtx != nil {
+		return c.commandCtx
+	}
+	if c.connCtx != nil {
+		return c.connCtx
+	}
+	return context.Background()
+}
@@ -0,0 +1,100 @@
+package conn_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A mailbox whose messages take until the command's context ends to read
+type slowMailbox struct {
+	mailstore.Mailbox
+	deadlines chan bool // Receives whether each context had a deadline
+}
+
+func (m slowMailbox) wait(ctx context.Context) error {
+	_, ok := ctx.Deadline()
+	m.deadlines <- ok
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m slowMailbox) MessageCountContext(ctx context.Context) (uint32, error) {
+	return 0, m.wait(ctx)
+}
+
+func (m slowMailbox) MessageBySequenceNumberContext(ctx context.Context, seqno uint32) (mailstore.Message, error) {
+	return nil, m.wait(ctx)
+}
+
+func (m slowMailbox) MessageByUIDContext(ctx context.Context, uid uint32) (mailstore.Message, error) {
+	return nil, m.wait(ctx)
+}
+
+func (m slowMailbox) MessageSetByUIDContext(ctx context.Context, set types.UIDSet) ([]mailstore.Message, error) {
+	return nil, m.wait(ctx)
+}
+
+func (m slowMailbox) MessageSetBySequenceNumberContext(ctx context.Context, set types.SequenceSet) ([]mailstore.Message, error) {
+	return nil, m.wait(ctx)
+}
+
+func (m slowMailbox) NewMessageContext(ctx context.Context) (mailstore.Message, error) {
+	return nil, m.wait(ctx)
+}
+
+// A user whose mailboxes take until the command's context ends to create
+type slowCreateUser struct {
+	mailstore.DummyUser
+}
+
+func (u slowCreateUser) CreateMailboxContext(ctx context.Context, name string) (mailstore.Mailbox, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+var _ = Describe("Command contexts", func() {
+	var deadlines chan bool
+
+	BeforeEach(func() {
+		deadlines = make(chan bool, 1)
+		tConn.SetState(conn.StateSelected)
+		tConn.User = mStore.User
+		tConn.SelectedMailbox = slowMailbox{tConn.User.Mailboxes()[0], deadlines}
+	})
+
+	It("should give up on a command which runs out of time", func() {
+		tConn.CommandTimeout = 10 * time.Millisecond
+		SendLine("abcd.123 UID FETCH 1:* (FLAGS)")
+		ExpectResponse("abcd.123 NO [UNAVAILABLE] Command took too long")
+		Expect(<-deadlines).To(BeTrue())
+	})
+
+	It("should cancel a command when the client disconnects", func() {
+		SendLine("abcd.123 UID FETCH 1:* (FLAGS)")
+		Expect(<-deadlines).To(BeFalse())
+		mockConn.Client.Close()
+		Eventually(func() bool {
+			_, busy := tConn.InFlightCommand()
+			return busy
+		}).Should(BeFalse())
+	})
+
+	Context("When creating a mailbox takes too long", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = slowCreateUser{mStore.User}
+			tConn.CommandTimeout = 10 * time.Millisecond
+		})
+
+		It("should give up on creating the mailbox", func() {
+			SendLine("abcd.123 CREATE Archive")
+			ExpectResponse("abcd.123 NO [UNAVAILABLE] Command took too long")
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package conn
+
+import (
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// Capabilities which are only advertised while the command implementing
+// them is enabled, keyed by the capability name without any "=value"
+var capabilityCommands = map[string]string{
+	"SASL-IR":     "AUTHENTICATE",
+	"AUTH":        "AUTHENTICATE",
+	"APPENDLIMIT": "APPEND",
+	"STATUS":      "STATUS",
+	"BINARY":      "FETCH",
+	"URLAUTH":     "GENURLAUTH",
+	"UTF8":        "ENABLE",
+	"X-UID-MAP":   "FETCH",
+}
+
+// The mechanisms which AUTHENTICATE may offer
+var authMechanisms = []string{"AUTH=PLAIN", "AUTH=XOAUTH2", "AUTH=OAUTHBEARER"}
+
+// CanAuthenticate returns false if the given disabled commands and
+// capabilities leave clients no way to log in to the mailstore, eg if only
+// token mechanisms are left enabled and the mailstore can't verify tokens.
+// A nil mailstore is taken to support every mechanism.
+func CanAuthenticate(store mailstore.Mailstore, disabledCommands []string) bool {
+	c := &Conn{Mailstore: store, DisabledCommands: disabledCommands}
+	if store != nil {
+		return !c.loginDisabled()
+	}
+	if !c.commandDisabled("LOGIN") {
+		return true
+	}
+	for _, mechanism := range authMechanisms {
+		if !c.capabilityDisabled(mechanism) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if neither LOGIN nor any authentication mechanism offered
+// on this connection is enabled
+func (c *Conn) loginDisabled() bool {
+	if !c.commandDisabled("LOGIN") {
+		return false
+	}
+	for _, mechanism := range c.offeredAuthMechanisms() {
+		if !c.capabilityDisabled(mechanism) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the given command or capability has been disabled, either
+// by the operator for everyone or for the logged in user. The operator's
+// setting always wins: a user's feature toggles can only disable more.
+func (c *Conn) commandDisabled(name string) bool {
+	for _, disabled := range c.DisabledCommands {
+		if strings.EqualFold(disabled, name) {
+			return true
+		}
+	}
+	enabled, ok := c.userFeature(name)
+	return ok && !enabled
+}
+
+// Find a search key in the criteria which has been disabled as part of
+// SEARCH, eg "SEARCH TEXT". The keys given to SORT and ESEARCH are checked
+// against the same names.
+func (c *Conn) disabledSearchKey(criteria types.SearchCriteria) (string, bool) {
+	if c.commandDisabled("SEARCH " + criteria.Key) {
+		return criteria.Key, true
+	}
+	for _, child := range criteria.Children {
+		if key, disabled := c.disabledSearchKey(child); disabled {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Look up a per-user feature toggle. The second return value is false if the
+// user has no setting for the feature.
+func (c *Conn) userFeature(name string) (enabled bool, ok bool) {
+	featureUser, isFeatureUser := c.User.(mailstore.FeatureUser)
+	if !isFeatureUser {
+		return false, false
+	}
+	for feature, enabled := range featureUser.Features() {
+		if strings.EqualFold(feature, name) {
+			return enabled, true
+		}
+	}
+	return false, false
+}
+
+// Returns true if a capability should be withheld, either because it was
+// disabled directly or because the command it extends was
+func (c *Conn) capabilityDisabled(capability string) bool {
+	if c.commandDisabled(capability) {
+		return true
+	}
+	name := strings.ToUpper(strings.SplitN(capability, "=", 2)[0])
+	if c.commandDisabled(name) {
+		return true
+	}
+	command, ok := capabilityCommands[name]
+	return ok && c.commandDisabled(command)
+}
+
+// Refuses a command with NO [CANNOT] if the given capability (eg an
+// authentication mechanism) has been disabled
+func (c *Conn) assertCapabilityEnabled(seq string, capability string) bool {
+	if c.capabilityDisabled(capability) {
+		c.writeNo(seq, codeCannot, c.text(MsgCommandDisabled, capability))
+		return false
+	}
+	return true
+}
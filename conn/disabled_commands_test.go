@@ -0,0 +1,140 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Disabled commands", func() {
+	Context("When an operator has disabled commands", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+			tConn.DisabledCommands = []string{"status", "AUTH=XOAUTH2"}
+		})
+
+		It("should refuse disabled commands", func() {
+			SendLine("abcd.123 STATUS INBOX (MESSAGES)")
+			ExpectResponse("abcd.123 NO [CANNOT] STATUS is disabled on this server")
+		})
+
+		It("should still allow other commands", func() {
+			SendLine("abcd.123 NOOP")
+			ExpectResponse("abcd.123 OK NOOP completed")
+		})
+
+		It("should withdraw related capabilities", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH URLAUTH ANNOTATE-EXPERIMENT-1")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		Context("before logging in", func() {
+			BeforeEach(func() {
+				tConn.SetState(conn.StateNotAuthenticated)
+				tConn.User = nil
+			})
+
+			It("should withdraw disabled authentication mechanisms", func() {
+				SendLine("abcd.123 CAPABILITY")
+				ExpectResponse("* CAPABILITY IMAP4rev1 SASL-IR LITERAL- BINARY SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH AUTH=PLAIN AUTH=OAUTHBEARER")
+				ExpectResponse("abcd.123 OK CAPABILITY completed")
+			})
+
+			It("should refuse disabled authentication mechanisms", func() {
+				SendLine("abcd.123 AUTHENTICATE XOAUTH2 dXNlcj0BYXV0aD1CZWFyZXIgdG9rZW4BAQ==")
+				ExpectResponse("abcd.123 NO [CANNOT] AUTH=XOAUTH2 is disabled on this server")
+			})
+		})
+	})
+
+	Context("When search keys are disabled", func() {
+		BeforeEach(func() {
+			user := mStore.User
+			user.FeatureFlags = map[string]bool{"SEARCH BODY": false}
+
+			tConn.SetState(conn.StateSelected)
+			tConn.User = user
+			tConn.SelectedMailbox, _ = user.MailboxByName("INBOX")
+			tConn.DisabledCommands = []string{"SEARCH TEXT"}
+		})
+
+		It("should refuse searches using a key the operator disabled", func() {
+			SendLine("abcd.123 SEARCH OR SEEN NOT TEXT hello")
+			ExpectResponse("abcd.123 NO [CANNOT] SEARCH TEXT is disabled on this server")
+			SendLine("abcd.124 UID SORT (ARRIVAL) UTF-8 TEXT hello")
+			ExpectResponse("abcd.124 NO [CANNOT] SEARCH TEXT is disabled on this server")
+		})
+
+		It("should refuse searches using a key disabled for the user", func() {
+			SendLine("abcd.123 SEARCH BODY hello")
+			ExpectResponse("abcd.123 NO [CANNOT] SEARCH BODY is disabled on this server")
+		})
+
+		It("should allow other search keys", func() {
+			SendLine("abcd.123 SEARCH SUBJECT nothing")
+			ExpectResponse("* SEARCH")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+		})
+	})
+
+	Context("When AUTHENTICATE is disabled", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+			tConn.DisabledCommands = []string{"AUTHENTICATE"}
+		})
+
+		It("should not advertise any authentication mechanisms", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+	})
+
+	Context("When every way of logging in is disabled", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+			tConn.DisabledCommands = []string{"LOGIN", "AUTH=PLAIN", "AUTH=XOAUTH2", "AUTH=OAUTHBEARER"}
+		})
+
+		It("should advertise LOGINDISABLED", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 SASL-IR LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH LOGINDISABLED")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+
+		It("should refuse to log in", func() {
+			SendLine("abcd.123 LOGIN \"username\" \"password\"")
+			ExpectResponse("abcd.123 NO [CANNOT] Logging in is disabled on this server")
+			SendLine("abcd.124 AUTHENTICATE PLAIN AHVzZXJuYW1lAHBhc3N3b3Jk")
+			ExpectResponse("abcd.124 NO [CANNOT] Logging in is disabled on this server")
+		})
+	})
+
+	Context("When the user has feature toggles", func() {
+		BeforeEach(func() {
+			user := mStore.User
+			user.FeatureFlags = map[string]bool{"replace": false, "STATUS": true}
+
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = user
+			tConn.DisabledCommands = []string{"STATUS"}
+		})
+
+		It("should refuse commands disabled for the user", func() {
+			SendLine("abcd.123 REPLACE 1 INBOX {5}")
+			ExpectResponse("abcd.123 NO [CANNOT] REPLACE is disabled on this server")
+		})
+
+		It("should not enable commands the operator has disabled", func() {
+			SendLine("abcd.123 STATUS INBOX (MESSAGES)")
+			ExpectResponse("abcd.123 NO [CANNOT] STATUS is disabled on this server")
+		})
+
+		It("should only advertise the user's capabilities", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponse("* CAPABILITY IMAP4rev1 LITERAL- BINARY SAVEDATE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH URLAUTH ANNOTATE-EXPERIMENT-1")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+	})
+})
@@ -0,0 +1,195 @@
+package conn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	"github.com/jordwest/imap-server/util"
+)
+
+// A SEARCH or SORT whose results are kept up to date for the client, as
+// asked for with RETURN (UPDATE) (RFC 5267 section 4.3)
+type searchUpdate struct {
+	tag      string
+	mailbox  string
+	uid      bool
+	order    []types.SortCriterion // The sort keys, or nil for SEARCH
+	criteria types.SearchCriteria
+	uids     []uint32 // UIDs of the results as the client knows them, in order
+}
+
+// Write an ESEARCH response with the results of a SEARCH or SORT, given in
+// the order they're returned (RFC 4731, RFC 5267), along with their
+// relevancy scores if asked for (RFC 6203) and their highest mod-sequence if
+// it isn't 0 (RFC 7162). The correlator identifies the command, and for
+// ESEARCH the mailbox searched (RFC 7377).
+func (c *Conn) writeESearch(correlator string, uid bool, ret types.SearchReturn, ids []uint32, relevancy []int, modseq uint64) {
+	response := "ESEARCH (" + correlator + ")"
+	if uid {
+		response += " UID"
+	}
+	if ret.Min && len(ids) > 0 {
+		response += fmt.Sprintf(" MIN %d", ids[0])
+	}
+	if ret.Max && len(ids) > 0 {
+		response += fmt.Sprintf(" MAX %d", ids[len(ids)-1])
+	}
+	if ret.Count {
+		response += fmt.Sprintf(" COUNT %d", len(ids))
+	}
+	if ret.All && len(ids) > 0 {
+		response += " ALL " + types.NewUIDSet(ids).String()
+	}
+	if ret.Relevancy && len(ids) > 0 {
+		scores := make([]string, len(relevancy))
+		for i, score := range relevancy {
+			scores[i] = fmt.Sprint(score)
+		}
+		response += " RELEVANCY (" + strings.Join(scores, " ") + ")"
+	}
+	if ret.Partial != nil {
+		window := "NIL"
+		if first := int(ret.Partial.First); first <= len(ids) {
+			last := int(ret.Partial.Last)
+			if last > len(ids) {
+				last = len(ids)
+			}
+			window = types.NewUIDSet(ids[first-1 : last]).String()
+		}
+		response += fmt.Sprintf(" PARTIAL (%s %s)", ret.Partial, window)
+	}
+	if modseq != 0 && len(ids) > 0 {
+		response += fmt.Sprintf(" MODSEQ %d", modseq)
+	}
+	c.writeResponse("", response)
+}
+
+// The correlator of the ESEARCH response to a SEARCH or SORT command
+func tagCorrelator(tag string) string {
+	return "TAG " + util.Quote(tag)
+}
+
+// Keep the results of a search up to date until the client cancels it, or
+// selects another mailbox
+func (c *Conn) watchSearch(tag string, uid bool, order []types.SortCriterion, criteria types.SearchCriteria, msgs []mailstore.Message) {
+	uids := make([]uint32, len(msgs))
+	for i, msg := range msgs {
+		uids[i] = msg.UID()
+	}
+	c.searchUpdates = append(c.searchUpdates, searchUpdate{
+		tag:      tag,
+		mailbox:  c.SelectedMailbox.Name(),
+		uid:      uid,
+		order:    order,
+		criteria: criteria,
+		uids:     uids,
+	})
+}
+
+// Tell the client how the results of its updating searches have changed,
+// with ADDTO and REMOVEFROM. Each gives the position in the results at
+// which a message is added or removed, and refers to the results left by
+// the ones before it. Messages which were expunged are only reported by
+// UID; clients drop them from results by sequence number when they're sent
+// the EXPUNGE.
+func (c *Conn) writeSearchUpdates() {
+	current := c.searchUpdates[:0]
+	for _, update := range c.searchUpdates {
+		if update.mailbox != c.SelectedMailbox.Name() {
+			continue
+		}
+		msgs, err := c.searchMailbox(c.SelectedMailbox, update.criteria, nil)
+		if err != nil {
+			current = append(current, update)
+			continue
+		}
+		if update.order != nil {
+			if msgs, err = c.sortMessages(msgs, update.order, update.criteria); err != nil {
+				current = append(current, update)
+				continue
+			}
+		}
+
+		results := make([]uint32, len(msgs))
+		matched := make(map[uint32]bool, len(msgs))
+		for i, msg := range msgs {
+			results[i] = msg.UID()
+			matched[msg.UID()] = true
+		}
+		known := update.uids
+		if !update.uid {
+			known = c.presentUIDs(known)
+		}
+		previous := make(map[uint32]bool, len(known))
+		changes := make([]string, 0)
+		for i := len(known) - 1; i >= 0; i-- {
+			previous[known[i]] = true
+			if !matched[known[i]] {
+				changes = append(changes, fmt.Sprintf("REMOVEFROM (%d %d)", i+1, c.resultID(known[i], update.uid)))
+			}
+		}
+		for i, uid := range results {
+			if !previous[uid] {
+				changes = append(changes, fmt.Sprintf("ADDTO (%d %d)", i+1, c.resultID(uid, update.uid)))
+			}
+		}
+
+		if len(changes) > 0 {
+			response := "ESEARCH (TAG " + util.Quote(update.tag) + ")"
+			if update.uid {
+				response += " UID"
+			}
+			c.writeResponse("", response+" "+strings.Join(changes, " "))
+		}
+		update.uids = results
+		current = append(current, update)
+	}
+	c.searchUpdates = current
+}
+
+// The UIDs of those messages still in the client's view of the selected
+// mailbox
+func (c *Conn) presentUIDs(uids []uint32) []uint32 {
+	present := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		if c.viewIndex(uid) != -1 {
+			present = append(present, uid)
+		}
+	}
+	return present
+}
+
+// The UID of a message, or its sequence number if UIDs weren't asked for
+func (c *Conn) resultID(uid uint32, byUID bool) uint32 {
+	if byUID {
+		return uid
+	}
+	return uint32(c.viewIndex(uid) + 1)
+}
+
+// Handles CANCELUPDATE (RFC 5267), which stops the results of the searches
+// with the given tags from being kept up to date
+func cmdCancelUpdate(args commandArgs, c *Conn) {
+	items, err := util.ParseList(args.Arg(0))
+	if err != nil {
+		c.writeResponse(args.ID(), "BAD "+err.Error())
+		return
+	}
+	for _, item := range items {
+		found := false
+		for i, update := range c.searchUpdates {
+			if update.tag == item.Value {
+				c.searchUpdates = append(c.searchUpdates[:i], c.searchUpdates[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.writeBad(args.ID(), codeNone, c.text(MsgUnknownSearchTag, util.Quote(item.Value)))
+			return
+		}
+	}
+	c.writeOK(args.ID(), codeNone, c.text(MsgCompleted, "CANCELUPDATE"))
+}
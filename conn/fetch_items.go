@@ -0,0 +1,74 @@
+package conn
+
+import (
+	"strings"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// FetchItem is a proprietary FETCH data item (eg X-GM-MSGID) provided by
+// the application embedding the server
+type FetchItem struct {
+	// Name of the item as requested by clients, eg "X-GM-MSGID". Names are
+	// matched without regard to case, before any of the standard items.
+	Name string
+
+	// Capability advertised while the item is available (eg "X-GM-EXT-1"),
+	// or blank to add the item without advertising it
+	Capability string
+
+	// Format returns the item's value for a message, exactly as it should
+	// appear in the FETCH response, eg a number, a quoted string or a
+	// parenthesised list
+	Format func(m mailstore.Message) (string, error)
+
+	// FormatAttribute, if set, is used instead of Format, and is also given
+	// the data item as requested. This allows the item to be followed by a
+	// list of arguments, eg X-EXAMPLE (arg1 arg2), which are in attr.Args.
+	FormatAttribute func(attr types.FetchAttribute, m mailstore.Message) (string, error)
+}
+
+// Find the custom FETCH item requested by a data item, if any. Custom items
+// don't have sections, and only take a list of arguments if they have a
+// FormatAttribute function.
+func (c *Conn) customFetchItem(attr types.FetchAttribute) (FetchItem, bool) {
+	if attr.Section != nil || attr.Partial != nil {
+		return FetchItem{}, false
+	}
+	for _, item := range c.FetchItems {
+		if strings.EqualFold(item.Name, attr.Name) && (attr.Args == nil || item.FormatAttribute != nil) {
+			return item, true
+		}
+	}
+	return FetchItem{}, false
+}
+
+// Fetch a custom item and prefix it with its name
+func fetchCustomItem(item FetchItem, attr types.FetchAttribute, m mailstore.Message) (string, error) {
+	var value string
+	var err error
+	if item.FormatAttribute != nil {
+		value, err = item.FormatAttribute(attr, m)
+	} else {
+		value, err = item.Format(m)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(item.Name) + " " + value, nil
+}
+
+// List the capabilities advertised by custom FETCH items, without duplicates
+func (c *Conn) fetchItemCapabilities() []string {
+	caps := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, item := range c.FetchItems {
+		if item.Capability == "" || seen[item.Capability] {
+			continue
+		}
+		seen[item.Capability] = true
+		caps = append(caps, item.Capability)
+	}
+	return caps
+}
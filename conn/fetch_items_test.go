@@ -0,0 +1,45 @@
+package conn_test
+
+import (
+	"fmt"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Custom FETCH items", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateSelected)
+		tConn.User = mStore.User
+		tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		tConn.FetchItems = []conn.FetchItem{
+			{
+				Name:       "X-GM-MSGID",
+				Capability: "X-GM-EXT-1",
+				Format: func(m mailstore.Message) (string, error) {
+					return fmt.Sprintf("%d", 1278455344230334865+uint64(m.UID())), nil
+				},
+			},
+			{
+				Name:       "X-GM-LABELS",
+				Capability: "X-GM-EXT-1",
+				Format: func(m mailstore.Message) (string, error) {
+					return "(\"\\\\Important\")", nil
+				},
+			},
+		}
+	})
+
+	It("should fetch custom items alongside standard ones", func() {
+		SendLine("abcd.123 FETCH 1 (UID x-gm-msgid X-GM-LABELS)")
+		ExpectResponse("* 1 FETCH (UID 10 X-GM-MSGID 1278455344230334875 X-GM-LABELS (\"\\\\Important\"))")
+		ExpectResponse("abcd.123 OK FETCH completed")
+	})
+
+	It("should advertise each capability once", func() {
+		SendLine("abcd.123 CAPABILITY")
+		ExpectResponsePattern("^\\* CAPABILITY .* URLAUTH ANNOTATE-EXPERIMENT-1 X-GM-EXT-1$")
+		ExpectResponse("abcd.123 OK CAPABILITY completed")
+	})
+})
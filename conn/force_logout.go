@@ -0,0 +1,63 @@
+package conn
+
+import (
+	"fmt"
+	"time"
+)
+
+// The longest an alert or forced logout may take to be written to a client
+// which isn't reading its responses, after which the client is disconnected
+var outOfBandWriteTimeout = 10 * time.Second
+
+// ForceLogout ends the session immediately if it belongs to the given user,
+// eg because their password has changed or their account was suspended.
+// The client is sent an untagged BYE with the reason given, even if it is
+// part way through a command, and the connection is then closed. Returns
+// true if the session was ended. This is safe to call from other
+// goroutines, and returns within a few seconds even if the client has
+// stopped reading.
+func (c *Conn) ForceLogout(username string, reason string) bool {
+	c.usernameMutex.Lock()
+	loggedIn := c.username != "" && c.username == username
+	c.usernameMutex.Unlock()
+	if !loggedIn {
+		return false
+	}
+
+	c.sendOutOfBand("Forcing logout: "+reason, "* BYE "+reason)
+	c.Rwc.Close()
+	return true
+}
+
+// SendAlert sends the client an untagged OK [ALERT] with the given text,
+// which the client must show to the user, eg to warn of planned maintenance.
+// The alert is sent straight away, even if the client is part way through a
+// command. A client which doesn't take the alert within a few seconds is
+// disconnected. This is safe to call from other goroutines.
+func (c *Conn) SendAlert(text string) {
+	if err := c.sendOutOfBand("Sending alert: "+text, "* OK [ALERT] "+text); err != nil {
+		c.Rwc.Close()
+	}
+}
+
+// Send a response from outside the connection's goroutine. The response is
+// written in a single call, so it can't end up in the middle of another
+// response. Connections which support deadlines are given
+// outOfBandWriteTimeout to send it, which also ends any write the
+// connection's goroutine is stuck in while holding writeMutex.
+func (c *Conn) sendOutOfBand(description string, response string) error {
+	c.outOfBandMutex.Lock()
+	defer c.outOfBandMutex.Unlock()
+
+	deadliner, ok := c.Rwc.(interface{ SetWriteDeadline(time.Time) error })
+	if ok {
+		deadliner.SetWriteDeadline(time.Now().Add(outOfBandWriteTimeout))
+		defer deadliner.SetWriteDeadline(time.Time{})
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	fmt.Fprintf(c.Transcript, "[%s] %s\n", c.ID, description)
+	fmt.Fprintf(c.writer, "%s%s", response, lineEnding)
+	return c.writer.Flush()
+}
@@ -0,0 +1,40 @@
+package conn
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+func TestOutOfBandWriteTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { outOfBandWriteTimeout = timeout }(outOfBandWriteTimeout)
+	outOfBandWriteTimeout = 50 * time.Millisecond
+
+	// Neither client reads anything, so every write blocks
+	for name, send := range map[string]func(c *Conn){
+		"SendAlert":   func(c *Conn) { c.SendAlert("Down for maintenance") },
+		"ForceLogout": func(c *Conn) { c.ForceLogout("username", "Account suspended") },
+	} {
+		server, client := net.Pipe()
+		defer client.Close()
+		c := NewConn(mailstore.NewDummyMailstore(), server, ioutil.Discard)
+		c.username = "username"
+
+		done := make(chan bool)
+		go func() {
+			send(c)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to give up on a client which isn't reading", name)
+		}
+		if _, err := server.Write([]byte("*")); err == nil {
+			t.Errorf("Expected %s to close the connection to a client which isn't reading", name)
+		}
+	}
+}
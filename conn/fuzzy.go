@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
+	"github.com/jordwest/imap-server/types"
+)
+
+// How messages in a mailbox are ranked against FUZZY keys: by the
+// mailbox's own index if it has one, falling back to the configured ranker
+func (c *Conn) fuzzyRanker(mailbox mailstore.Mailbox) search.Ranker {
+	fallback := c.FuzzyRanker
+	if fallback == nil {
+		fallback = search.DefaultRanker
+	}
+	indexed, ok := mailbox.(mailstore.RankedSearchMailbox)
+	if !ok {
+		return fallback
+	}
+	return func(key types.SearchCriteria, m *search.Message) int {
+		score, err := indexed.Rank(key, m.UID)
+		if err != nil {
+			return fallback(key, m)
+		}
+		return score
+	}
+}
+
+// Score how relevant each of the messages found by a search of a mailbox is
+// to its FUZZY keys, from 1 to 100
+func (c *Conn) relevancy(mailbox mailstore.Mailbox, criteria types.SearchCriteria, msgs []mailstore.Message) ([]int, error) {
+	ctx, err := c.searchContext(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]int, len(msgs))
+	for i, msg := range msgs {
+		scores[i] = search.Relevancy(criteria, c.searchMessage(mailbox, msg), ctx)
+	}
+	return scores, nil
+}
+
+// Whether search criteria include the given key, eg FUZZY
+func usesKey(criteria types.SearchCriteria, key string) bool {
+	if criteria.Key == key {
+		return true
+	}
+	for _, child := range criteria.Children {
+		if usesKey(child, key) {
+			return true
+		}
+	}
+	return false
+}
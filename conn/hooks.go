@@ -0,0 +1,54 @@
+package conn
+
+import "time"
+
+// CommandMetric summarises a command which has finished, for the
+// CommandMetrics hook
+type CommandMetric struct {
+	ConnID    string
+	CommandID string
+	Verb      string // eg "FETCH" or "UID FETCH"
+	Duration  time.Duration
+	Status    string // "OK", "NO" or "BAD", or blank if no tagged response was sent
+	Username  string // Blank if the client has not authenticated
+}
+
+// AuditEvent describes a change to who is logged in or to the user's
+// mailboxes, for the Audit hook
+type AuditEvent struct {
+	ConnID    string
+	CommandID string
+	Action    string // "LOGIN", "LOGIN FAILED", "LOGOUT", "DELETE" or "RENAME"
+	Username  string // The user logged in, or trying to log in
+	Mailbox   string // The mailbox deleted or renamed, if any
+	NewName   string // The mailbox's new name, for RENAME
+}
+
+// Pass the outcome of the command which has just finished to the
+// CommandMetrics hook
+func (c *Conn) reportCommand(verb string, started time.Time) {
+	status := c.commandStatus
+	c.commandStatus = ""
+	if c.CommandMetrics == nil {
+		return
+	}
+	c.CommandMetrics(c.context(), CommandMetric{
+		ConnID:    c.ID,
+		CommandID: c.CommandID(),
+		Verb:      verb,
+		Duration:  time.Since(started),
+		Status:    status,
+		Username:  c.username,
+	})
+}
+
+// Pass an event to the Audit hook, filling in the connection's and
+// command's correlation IDs
+func (c *Conn) audit(event AuditEvent) {
+	if c.Audit == nil {
+		return
+	}
+	event.ConnID = c.ID
+	event.CommandID = c.CommandID()
+	c.Audit(c.context(), event)
+}
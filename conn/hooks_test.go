@@ -0,0 +1,106 @@
+package conn_test
+
+import (
+	"context"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A user which reports the correlation ID of each mailbox lookup
+type correlatedUser struct {
+	mailstore.DummyUser
+	ids chan string
+}
+
+func (u correlatedUser) MailboxesContext(ctx context.Context) ([]mailstore.Mailbox, error) {
+	return u.Mailboxes(), nil
+}
+
+func (u correlatedUser) MailboxByNameContext(ctx context.Context, name string) (mailstore.Mailbox, error) {
+	u.ids <- mailstore.CorrelationID(ctx)
+	return u.MailboxByName(name)
+}
+
+var _ = Describe("Observability hooks", func() {
+	Context("When logged in", func() {
+		var ids, contextIDs chan string
+		var metrics chan conn.CommandMetric
+
+		BeforeEach(func() {
+			ids = make(chan string, 1)
+			contextIDs = make(chan string, 1)
+			metrics = make(chan conn.CommandMetric, 1)
+
+			// The hooks keep their own channels, as the connection may
+			// outlive the test
+			contextIDs, metrics := contextIDs, metrics
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = correlatedUser{mStore.User, ids}
+			tConn.CommandMetrics = func(ctx context.Context, metric conn.CommandMetric) {
+				contextIDs <- mailstore.CorrelationID(ctx)
+				metrics <- metric
+			}
+		})
+
+		It("should give the mailstore and metrics the command's correlation ID", func() {
+			SendLine("abcd.123 STATUS INBOX (MESSAGES)")
+			ExpectResponse("* STATUS INBOX (MESSAGES 3)")
+			ExpectResponse("abcd.123 OK STATUS completed")
+
+			id := <-ids
+			Expect(id).To(Equal(tConn.ID + ".1"))
+			Expect(<-contextIDs).To(Equal(id))
+			metric := <-metrics
+			Expect(metric.ConnID).To(Equal(tConn.ID))
+			Expect(metric.CommandID).To(Equal(id))
+			Expect(metric.Verb).To(Equal("STATUS"))
+			Expect(metric.Status).To(Equal("OK"))
+		})
+	})
+
+	Context("When not logged in", func() {
+		var events chan conn.AuditEvent
+		var lines chan [2]string
+
+		BeforeEach(func() {
+			events = make(chan conn.AuditEvent, 3)
+			lines = make(chan [2]string, 16)
+
+			events, lines := events, lines
+			tConn.SetState(conn.StateNotAuthenticated)
+			tConn.Audit = func(ctx context.Context, event conn.AuditEvent) {
+				events <- event
+			}
+			tConn.Logger = func(correlationID string, line string) {
+				lines <- [2]string{correlationID, line}
+			}
+		})
+
+		It("should audit logins and logouts", func() {
+			SendLine("abcd.123 LOGIN username wrong")
+			ExpectResponse("abcd.123 NO [AUTHENTICATIONFAILED] Incorrect username/password")
+			SendLine("abcd.124 LOGIN username password")
+			ExpectResponse("abcd.124 OK Authenticated")
+			SendLine("abcd.125 LOGOUT")
+			ExpectResponse("* BYE IMAP4rev1 server logging out")
+
+			failed := <-events
+			Expect(failed.Action).To(Equal("LOGIN FAILED"))
+			Expect(failed.Username).To(Equal("username"))
+			Expect(failed.CommandID).To(Equal(tConn.ID + ".1"))
+			Expect((<-events).Action).To(Equal("LOGIN"))
+			loggedOut := <-events
+			Expect(loggedOut.Action).To(Equal("LOGOUT"))
+			Expect(loggedOut.CommandID).To(Equal(tConn.ID + ".3"))
+		})
+
+		It("should pass transcript lines to the logger with their correlation ID", func() {
+			SendLine("abcd.123 NOOP")
+			ExpectResponse("abcd.123 OK NOOP completed")
+			Eventually(lines).Should(Receive(Equal([2]string{tConn.ID + ".1", "C: abcd.123 NOOP\n"})))
+		})
+	})
+})
@@ -0,0 +1,75 @@
+package conn
+
+import (
+	"strings"
+	"time"
+)
+
+// CommandInfo describes a command which is currently being executed
+type CommandInfo struct {
+	ConnID    string
+	CommandID string
+	Verb      string // eg "FETCH" or "UID FETCH"
+	Started   time.Time
+	Username  string // Blank if the client has not authenticated
+	Mailbox   string // Blank if no mailbox is selected
+}
+
+// Duration returns how long the command has been executing for
+func (i CommandInfo) Duration() time.Duration {
+	return time.Since(i.Started)
+}
+
+// InFlightCommand returns information about the command currently being
+// executed on this connection. The second return value is false if the
+// connection is idle. This is safe to call from other goroutines.
+func (c *Conn) InFlightCommand() (CommandInfo, bool) {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	if c.inFlight == nil {
+		return CommandInfo{}, false
+	}
+	return *c.inFlight, true
+}
+
+// Record that a command has started executing
+func (c *Conn) beginCommand(req string) {
+	info := &CommandInfo{
+		ConnID:    c.ID,
+		CommandID: c.CommandID(),
+		Verb:      commandVerb(req),
+		Started:   time.Now(),
+		Username:  c.username,
+	}
+	if c.SelectedMailbox != nil {
+		info.Mailbox = c.SelectedMailbox.Name()
+	}
+
+	c.inFlightMutex.Lock()
+	c.inFlight = info
+	c.inFlightMutex.Unlock()
+}
+
+// Record that the current command has finished executing
+func (c *Conn) endCommand() {
+	c.inFlightMutex.Lock()
+	c.inFlight = nil
+	c.inFlightMutex.Unlock()
+}
+
+// Extract the command name from a request line, without the tag or any
+// arguments (which may contain credentials)
+// eg "abcd.123 uid fetch 1:* (FLAGS)" => "UID FETCH"
+func commandVerb(req string) string {
+	fields := strings.Fields(req)
+	if len(fields) < 2 {
+		return ""
+	}
+
+	verb := strings.ToUpper(fields[1])
+	if verb == "UID" && len(fields) > 2 {
+		verb += " " + strings.ToUpper(fields[2])
+	}
+	return verb
+}
@@ -0,0 +1,92 @@
+package conn
+
+import (
+	"sort"
+	"strings"
+)
+
+// The language used when the client hasn't chosen one, which is the
+// English text written by the command handlers
+const defaultLanguage = "i-default"
+
+// Catalog translates the human-readable text of responses into other
+// languages, for the LANGUAGE extension (RFC 5255). Translations are looked
+// up by message ID, and are format strings given the same arguments as the
+// English text of the message. Text which isn't one of the messages, such
+// as errors passed on from the mailstore or the argument parsers and
+// operators' alerts, isn't translated.
+type Catalog interface {
+	// The language tags translations are available for, eg "de", "fr-CA"
+	Languages() []string
+
+	// Translate a message into the given language. The second return value
+	// is false if there is no translation for the message.
+	Translate(language string, id MessageID) (format string, ok bool)
+}
+
+// MapCatalog is a Catalog held in memory, mapping language tags to a map of
+// message IDs to translated format strings
+type MapCatalog map[string]map[MessageID]string
+
+// Languages implements the Languages method on the Catalog interface
+func (m MapCatalog) Languages() []string {
+	languages := make([]string, 0, len(m))
+	for language := range m {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Translate implements the Translate method on the Catalog interface
+func (m MapCatalog) Translate(language string, id MessageID) (string, bool) {
+	translated, ok := m[language][id]
+	return translated, ok
+}
+
+// Find the first available language matching one of the language ranges
+// requested by the client, where a range such as "de" matches the tag
+// "de-CH" (RFC 4647 basic filtering)
+func matchLanguage(available []string, requested []string) (string, bool) {
+	for _, languageRange := range requested {
+		if strings.EqualFold(languageRange, "default") || strings.EqualFold(languageRange, defaultLanguage) {
+			return defaultLanguage, true
+		}
+		for _, tag := range available {
+			if strings.EqualFold(tag, languageRange) ||
+				strings.HasPrefix(strings.ToLower(tag), strings.ToLower(languageRange)+"-") {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Handles the LANGUAGE command, which lists the available languages or
+// chooses the language of response text
+func cmdLanguage(args commandArgs, c *Conn) {
+	if c.Catalog == nil {
+		c.writeNo(args.ID(), codeCannot, c.text(MsgNoLanguages))
+		return
+	}
+	available := append([]string{defaultLanguage}, c.Catalog.Languages()...)
+
+	requested := strings.Fields(args.Arg(0))
+	if len(requested) == 0 {
+		c.writeResponse("", "LANGUAGE ("+strings.Join(available, " ")+")")
+		c.writeOK(args.ID(), codeNone, c.text(MsgLanguagesEnumerated))
+		return
+	}
+
+	language, ok := matchLanguage(available, requested)
+	if !ok {
+		c.writeNo(args.ID(), codeNone, c.text(MsgUnsupportedLanguage))
+		return
+	}
+	c.language = language
+	if language == defaultLanguage {
+		c.language = ""
+	}
+	c.writeResponse("", "LANGUAGE ("+strings.ToUpper(language)+")")
+	c.writeOK(args.ID(), codeNone, c.text(MsgLanguageChanged))
+}
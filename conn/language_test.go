@@ -0,0 +1,95 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("LANGUAGE Command", func() {
+	Context("When translations are available", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+			tConn.Catalog = conn.MapCatalog{
+				"de": {
+					conn.MsgLanguageChanged:  "Sprachwechsel durch LANGUAGE-Befehl ausgefuehrt",
+					conn.MsgCompleted:        "%s abgeschlossen",
+					conn.MsgNotAuthenticated: "nicht angemeldet",
+					conn.MsgUnknownCommand:   "Unbekannter Befehl %s",
+				},
+				"fr-CA": {},
+			}
+		})
+
+		It("should list the available languages", func() {
+			SendLine("abcd.123 LANGUAGE")
+			ExpectResponse("* LANGUAGE (i-default de fr-CA)")
+			ExpectResponse("abcd.123 OK Supported languages have been enumerated")
+		})
+
+		It("should translate responses once a language is chosen", func() {
+			SendLine("abcd.123 LANGUAGE DE")
+			ExpectResponse("* LANGUAGE (DE)")
+			ExpectResponse("abcd.123 OK Sprachwechsel durch LANGUAGE-Befehl ausgefuehrt")
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP abgeschlossen")
+			SendLine("abcd.125 LIST \"\" \"*\"")
+			ExpectResponse("abcd.125 BAD nicht angemeldet")
+		})
+
+		It("should fill in the details of translated responses", func() {
+			SendLine("abcd.123 LANGUAGE de")
+			ExpectResponse("* LANGUAGE (DE)")
+			ExpectResponse("abcd.123 OK Sprachwechsel durch LANGUAGE-Befehl ausgefuehrt")
+			SendLine("abcd.124 CAPABILITY")
+			ExpectResponsePattern("^\\* CAPABILITY ")
+			ExpectResponse("abcd.124 OK CAPABILITY abgeschlossen")
+			SendLine("abcd.125 FROBNICATE")
+			ExpectResponse("abcd.125 BAD Unbekannter Befehl FROBNICATE")
+		})
+
+		It("should fall back to English for untranslated responses", func() {
+			SendLine("abcd.123 LANGUAGE de")
+			ExpectResponse("* LANGUAGE (DE)")
+			ExpectResponse("abcd.123 OK Sprachwechsel durch LANGUAGE-Befehl ausgefuehrt")
+			SendLine("abcd.124 LOGIN")
+			ExpectResponsePattern("^abcd.124 BAD Invalid arguments for LOGIN: unexpected end of command")
+		})
+
+		It("should match a language range to a more specific tag", func() {
+			SendLine("abcd.123 LANGUAGE es fr")
+			ExpectResponse("* LANGUAGE (FR-CA)")
+			ExpectResponse("abcd.123 OK Language changed")
+		})
+
+		It("should return to the default language", func() {
+			SendLine("abcd.123 LANGUAGE de")
+			ExpectResponse("* LANGUAGE (DE)")
+			ExpectResponse("abcd.123 OK Sprachwechsel durch LANGUAGE-Befehl ausgefuehrt")
+			SendLine("abcd.124 LANGUAGE default")
+			ExpectResponse("* LANGUAGE (I-DEFAULT)")
+			ExpectResponse("abcd.124 OK Language changed")
+		})
+
+		It("should reject unsupported languages", func() {
+			SendLine("abcd.123 LANGUAGE ja")
+			ExpectResponse("abcd.123 NO Unsupported language")
+		})
+
+		It("should advertise LANGUAGE", func() {
+			SendLine("abcd.123 CAPABILITY")
+			ExpectResponsePattern("^\\* CAPABILITY .* LANGUAGE$")
+			ExpectResponse("abcd.123 OK CAPABILITY completed")
+		})
+	})
+
+	Context("When no translations are available", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should refuse to change language", func() {
+			SendLine("abcd.123 LANGUAGE de")
+			ExpectResponse("abcd.123 NO [CANNOT] No languages are available")
+		})
+	})
+})
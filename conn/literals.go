@@ -0,0 +1,144 @@
+package conn
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// A literal at the end of a line of command text, eg {11} or {11+}
+var commandLiteralRE = regexp.MustCompile(`\{([0-9]+)(\+)?\}$`)
+
+// Commands whose handlers read the literal at the end of the command line
+// themselves, as it holds message data rather than command text
+var messageLiteralCommands = map[string]bool{
+	"APPEND":  true,
+	"REPLACE": true,
+}
+
+// Read a command from the client, including any literals within it. Each
+// literal is read once the client has been invited to send it, and is put
+// back into the command as a quoted string so that the command can be
+// matched as though it had been sent on one line. The quoted string keeps
+// any line breaks or NULs in the literal, except where the literal stands
+// in for the tag or command name. A command which can't be read this way,
+// or which contains characters not allowed outside a literal, is answered
+// here and returned with rejected set. The rest of a rejected command is
+// drained rather than read into memory.
+func (c *Conn) readCommand() (req string, rejected bool, ok bool) {
+	req, tooLong, ok := c.readLine()
+	line := req
+	for ok {
+		if tooLong && !rejected {
+			tag, _, _ := splitTag(req)
+			c.writeBad(tag, codeTooBig, c.text(MsgLineTooLong))
+			rejected = true
+		}
+		if problem := c.commandTextProblem(line); problem != "" && !rejected {
+			tag, _, _ := splitTag(req)
+			c.writeBad(tag, codeNone, c.text(problem))
+			rejected = true
+		}
+		match := commandLiteralRE.FindStringSubmatchIndex(req)
+		if match == nil || !rejected && messageLiteralCommands[c.matchCommand(req).name] {
+			return req, rejected, true
+		}
+		length, err := strconv.ParseUint(req[match[2]:match[3]], 10, 32)
+		nonSync := match[4] >= 0
+		tag, _, _ := splitTag(req)
+		prefix := req[:match[0]]
+
+		var data []byte
+		switch {
+		case rejected:
+			// The client has already been answered, so whatever else it
+			// sends of the command is thrown away
+			if nonSync {
+				c.discardFixedLength(int64(length))
+			} else {
+				return req, true, true
+			}
+		case err != nil:
+			c.writeBad(tag, codeNone, c.text(MsgInvalidLiteralLength))
+			return req, true, true
+		case c.exceedsLiteralLimit(length):
+			// A client refused a synchronizing literal doesn't send it
+			if !nonSync {
+				c.writeNo(tag, codeTooBig, c.text(MsgLiteralTooLarge))
+				return req, true, true
+			}
+			c.discardFixedLength(int64(length))
+			c.writeNo(tag, codeTooBig, c.text(MsgLiteralTooLarge))
+			rejected = true
+		case nonSync && length > maxNonSyncLiteralSize:
+			c.discardFixedLength(int64(length))
+			c.writeBad(tag, codeTooBig, c.text(MsgNonSyncLiteralTooLarge))
+			rejected = true
+		case c.exceedsMemoryLimit(length):
+			c.writeNo(tag, codeLimit, c.text(MsgLiteralOverMemoryLimit))
+			if !nonSync {
+				return req, true, true
+			}
+			c.discardFixedLength(int64(length))
+			rejected = true
+		default:
+			if !nonSync {
+				c.writeResponse("+", c.text(MsgReadyForCommandText))
+			}
+			if data, err = c.ReadFixedLength(int(length)); err != nil {
+				return req, rejected, false
+			}
+			if isCommandWord(prefix) && bytes.ContainsAny(data, "\r\n\x00") {
+				c.writeBad(tag, codeNone, c.text(MsgLiteralCommand))
+				rejected = true
+			}
+		}
+
+		line, tooLong, ok = c.readLine()
+		req = prefix + util.Quote(string(data)) + line
+	}
+	return req, rejected, false
+}
+
+// Whether a literal following the given command text would be the tag or
+// command name (including the command after UID), rather than an argument
+func isCommandWord(prefix string) bool {
+	words := strings.Fields(prefix)
+	return len(words) < 2 || len(words) == 2 && strings.EqualFold(words[1], "UID")
+}
+
+// Whether a literal is larger than MaxLiteralSize allows
+func (c *Conn) exceedsLiteralLimit(length uint64) bool {
+	return c.MaxLiteralSize > 0 && length > uint64(c.MaxLiteralSize)
+}
+
+// Throw away the message literal of an APPEND or REPLACE command refused
+// before its handler ran. A non-synchronizing literal is sent whether or not
+// the command is accepted, and would otherwise be read as commands.
+func (c *Conn) drainMessageLiteral(cmd command, req string) {
+	if !messageLiteralCommands[cmd.name] {
+		return
+	}
+	match := commandLiteralRE.FindStringSubmatch(req)
+	if match == nil || match[2] != "+" {
+		return
+	}
+	length, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return
+	}
+	c.discardFixedLength(int64(length))
+}
+
+// Find the command which would handle a request
+func (c *Conn) matchCommand(req string) command {
+	for _, cmd := range commands {
+		if cmd.match.MatchString(req) {
+			return cmd
+		}
+	}
+	return command{}
+}
@@ -0,0 +1,149 @@
+package conn_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Literals in commands", func() {
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should invite the client to send each literal", func() {
+			SendLine("abcd.123 LOGIN {8}")
+			ExpectResponse("+ Ready for additional command text")
+			fmt.Fprint(mockConn.Client, "username {8}\r\n")
+			ExpectResponse("+ Ready for additional command text")
+			fmt.Fprint(mockConn.Client, "password\r\n")
+			ExpectResponse("abcd.123 OK Authenticated")
+		})
+	})
+
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should read non-synchronizing literals without a continuation", func() {
+			SendLine("abcd.123 CREATE {7+}")
+			SendLine("Archive")
+			ExpectResponse("abcd.123 OK CREATE completed")
+
+			_, err := mStore.User.MailboxByName("Archive")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should reject literals which can't be a command name", func() {
+			SendLine("abcd.123 {6}")
+			ExpectResponse("+ Ready for additional command text")
+			fmt.Fprint(mockConn.Client, "NO\r\nOP")
+			ExpectResponse("abcd.123 BAD Literal cannot be used as command text")
+			SendLine("")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should pass line breaks in literal arguments on to the command", func() {
+			SendLine("abcd.123 CREATE {10}")
+			ExpectResponse("+ Ready for additional command text")
+			fmt.Fprint(mockConn.Client, "Two\r\nLines")
+			SendLine("")
+			ExpectResponse("abcd.123 BAD Mailbox name cannot contain control characters")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should discard non-synchronizing literals larger than 4096 bytes", func() {
+			SendLine("abcd.123 CREATE {4097+}")
+			SendLine(strings.Repeat("x", 4095))
+			ExpectResponse("abcd.123 BAD [TOOBIG] non-synchronizing literal too large")
+			SendLine("")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+	})
+
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should keep line breaks in string arguments sent as literals", func() {
+			SendLine("abcd.123 STORE 1 ANNOTATION (/comment (value.priv {11}")
+			ExpectResponse("+ Ready for additional command text")
+			fmt.Fprint(mockConn.Client, "Line\r\nBreak")
+			SendLine("))")
+			ExpectResponse("abcd.123 OK STORE completed")
+
+			SendLine("abcd.124 FETCH 1 (ANNOTATION (/comment value.priv))")
+			ExpectResponse("* 1 FETCH (ANNOTATION (/comment (value.priv {11}")
+			ExpectResponse("Line")
+			ExpectResponse("Break)))")
+			ExpectResponse("abcd.124 OK FETCH completed")
+		})
+	})
+
+	Context("When line and literal limits are set", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+			tConn.MaxLineLength = 40
+			tConn.MaxLiteralSize = 16
+		})
+
+		It("should refuse lines which are too long", func() {
+			SendLine("abcd.123 CREATE " + strings.Repeat("x", 5000))
+			ExpectResponse("abcd.123 BAD [TOOBIG] Command line too long")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should drain a non-synchronizing literal after a line which is too long", func() {
+			SendLine("abcd.123 CREATE " + strings.Repeat("x", 100) + " {7+}")
+			ExpectResponse("abcd.123 BAD [TOOBIG] Command line too long")
+			SendLine("Archive")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+			_, err := mStore.User.MailboxByName("Archive")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse synchronizing literals which are too large", func() {
+			SendLine("abcd.123 CREATE {17}")
+			ExpectResponse("abcd.123 NO [TOOBIG] literal exceeds the maximum size")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should discard non-synchronizing literals which are too large", func() {
+			SendLine("abcd.123 CREATE {17+}")
+			fmt.Fprint(mockConn.Client, strings.Repeat("x", 17))
+			ExpectResponse("abcd.123 NO [TOOBIG] literal exceeds the maximum size")
+			SendLine("")
+
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should refuse messages which are too large", func() {
+			SendLine("abcd.123 APPEND INBOX {20}")
+			ExpectResponse("abcd.123 NO [TOOBIG] literal exceeds the maximum size")
+		})
+	})
+})
@@ -0,0 +1,165 @@
+package conn
+
+import (
+	"fmt"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// Identifies a mailbox which has changed outside of this connection
+type mailboxUpdate struct {
+	username string
+	mailbox  string
+}
+
+// The selected mailbox as the client last saw it, against which changes made
+// by other connections are found
+type mailboxView struct {
+	mailbox string
+	uids    []uint32                  // UIDs of the messages, by sequence number
+	flags   map[uint32]types.FlagList // Flags and keywords of each message, less \Recent
+}
+
+// Commands during which EXPUNGE responses must not be sent, as they refer
+// to messages by sequence number (RFC 3501 section 7.4.1)
+var expungeForbidden = map[string]bool{
+	"FETCH":  true,
+	"STORE":  true,
+	"SEARCH": true,
+	"SORT":   true,
+}
+
+// MailboxChanged tells the connection that messages in a user's mailbox have
+// been added, expunged or had their flags changed by something other than
+// this connection. If this connection's user has that mailbox selected, the
+// changes are sent to the client along with the response to its next
+// command. This is safe to call from other goroutines.
+func (c *Conn) MailboxChanged(username string, mailbox string) {
+	c.invalidateSearchCache(mailbox)
+
+	c.updatesMutex.Lock()
+	defer c.updatesMutex.Unlock()
+
+	if c.pendingUpdates == nil {
+		c.pendingUpdates = make(map[mailboxUpdate]bool)
+	}
+	c.pendingUpdates[mailboxUpdate{username, mailbox}] = true
+}
+
+// Send untagged responses describing changes to the selected mailbox before
+// the given command runs, if the mailbox is known to have changed. NOOP
+// always looks for changes, so that clients which poll notice new mail even
+// when nothing has announced it.
+func (c *Conn) sendMailboxUpdates(verb string) {
+	c.updatesMutex.Lock()
+	updates := c.pendingUpdates
+	c.pendingUpdates = nil
+	c.updatesMutex.Unlock()
+
+	if c.state != StateSelected || c.SelectedMailbox == nil {
+		return
+	}
+	selected := mailboxUpdate{c.username, c.SelectedMailbox.Name()}
+	if !updates[selected] && verb != "NOOP" {
+		return
+	}
+
+	if !c.reportMailboxChanges(!expungeForbidden[verb]) {
+		// Try again before the next command
+		c.MailboxChanged(selected.username, selected.mailbox)
+	}
+}
+
+// Reload the selected mailbox and tell the client how it differs from what
+// the client last saw: which messages have been expunged, which have new
+// flags, and how many messages now exist. If expunges may not be sent and
+// there are some, nothing is sent and false is returned.
+func (c *Conn) reportMailboxChanges(expungeAllowed bool) bool {
+	if c.view.mailbox != c.SelectedMailbox.Name() {
+		c.rememberMailbox()
+	}
+	previous := c.SelectedMailbox
+	if err := c.reloadSelectedMailbox(); err != nil {
+		return true
+	}
+	c.claimRecent()
+
+	// If the messages can't be read, nothing can be reported until they can
+	msgs, err := allMessages(c.context(), c.SelectedMailbox)
+	if err != nil {
+		return true
+	}
+	present := make(map[uint32]bool, len(msgs))
+	for _, msg := range msgs {
+		present[msg.UID()] = true
+	}
+
+	// Work down from the highest sequence number, so that each response
+	// refers to the numbering left by the responses before it
+	var expunged []uint32
+	for i := len(c.view.uids) - 1; i >= 0; i-- {
+		if !present[c.view.uids[i]] {
+			expunged = append(expunged, uint32(i+1))
+		}
+	}
+	if len(expunged) > 0 && !expungeAllowed {
+		c.SelectedMailbox = previous
+		return false
+	}
+	for _, seqno := range expunged {
+		c.writeExpunge(seqno)
+	}
+
+	for _, msg := range msgs {
+		flags, known := c.view.flags[msg.UID()]
+		if known && !flags.Equal(viewFlags(msg)) {
+			fetchFlags, _ := fetch(c.flagFetchItems(false), c, msg)
+			c.writeResponse("", fmt.Sprintf("%d FETCH (%s)", msg.SequenceNumber(), fetchFlags))
+		}
+	}
+
+	if len(msgs) > len(c.view.uids) {
+		c.writeResponse("", fmt.Sprintf("%d EXISTS", len(msgs)))
+		c.writeResponse("", fmt.Sprintf("%d RECENT", c.recentCount(c.SelectedMailbox)))
+	}
+	c.rememberMailbox()
+	c.writeSearchUpdates()
+	return true
+}
+
+// Record the selected mailbox as the client now sees it. If its messages
+// can't be read, the client's numbering is forgotten and the mailbox's own
+// is used until it can be recorded.
+func (c *Conn) rememberMailbox() {
+	m := c.SelectedMailbox
+	msgs, err := allMessages(c.context(), m)
+	if err != nil {
+		c.view = mailboxView{}
+		return
+	}
+	view := mailboxView{
+		mailbox: m.Name(),
+		uids:    make([]uint32, 0, len(msgs)),
+		flags:   make(map[uint32]types.FlagList, len(msgs)),
+	}
+	for _, msg := range msgs {
+		view.uids = append(view.uids, msg.UID())
+		view.flags[msg.UID()] = viewFlags(msg)
+	}
+	c.view = view
+}
+
+// The flags and keywords of a message which changes to are reported to the
+// client. \Recent is left out, as only the server changes it.
+func viewFlags(msg mailstore.Message) types.FlagList {
+	return types.FlagList{System: msg.Flags().ResetFlags(types.FlagRecent), Keywords: msg.Keywords()}
+}
+
+// Record flags which the client has stored on a message itself, so that they
+// aren't reported back to it as a change
+func (c *Conn) rememberFlags(msg mailstore.Message) {
+	if c.view.flags != nil {
+		c.view.flags[msg.UID()] = viewFlags(msg)
+	}
+}
@@ -0,0 +1,183 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Mailbox updates", func() {
+	var inbox mailstore.Mailbox
+
+	// The test connection's user didn't log in with a username
+	const username = ""
+
+	BeforeEach(func() {
+		tConn.SetState(conn.StateSelected)
+		tConn.SetReadWrite()
+		tConn.User = mStore.User
+		tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		inbox = tConn.SelectedMailbox
+	})
+
+	// Let the connection see the mailbox before anything else changes it
+	JustBeforeEach(func() {
+		SendLine("abcd.100 NOOP")
+		ExpectResponse("abcd.100 OK NOOP completed")
+	})
+
+	It("should report new messages on NOOP", func() {
+		message := inbox.MessageBySequenceNumber(1)
+		inbox.NewMessage().SetHeaders(message.Header()).SetBody(message.Body()).Save()
+
+		SendLine("abcd.123 NOOP")
+		ExpectResponse("* 4 EXISTS")
+		ExpectResponse("* 3 RECENT")
+		ExpectResponse("abcd.123 OK NOOP completed")
+	})
+
+	It("should report expunged messages and changed flags on NOOP", func() {
+		inbox.MessageBySequenceNumber(3).AddFlags(types.FlagSeen).Save()
+		inbox.(mailstore.ExpungeMailbox).Expunge([]uint32{11})
+
+		SendLine("abcd.123 NOOP")
+		ExpectResponse("* 2 EXPUNGE")
+		ExpectResponse("* 2 FETCH (FLAGS (\\Seen \\Recent))")
+		ExpectResponse("abcd.123 OK NOOP completed")
+
+		SendLine("abcd.124 NOOP")
+		ExpectResponse("abcd.124 OK NOOP completed")
+	})
+
+	It("should report changed keywords on NOOP", func() {
+		message := inbox.MessageBySequenceNumber(3).(mailstore.KeywordMessage)
+		message.SetKeywords([]string{"$Work"}).Save()
+
+		SendLine("abcd.123 NOOP")
+		ExpectResponse("* 3 FETCH (FLAGS (\\Recent $Work))")
+		ExpectResponse("abcd.123 OK NOOP completed")
+
+		SendLine("abcd.124 STORE 3 +FLAGS.SILENT ($Personal)")
+		ExpectResponse("abcd.124 OK STORE completed")
+		SendLine("abcd.125 NOOP")
+		ExpectResponse("abcd.125 OK NOOP completed")
+	})
+
+	It("should not report the client's own flag changes", func() {
+		SendLine("abcd.123 STORE 1 +FLAGS.SILENT (\\Flagged)")
+		ExpectResponse("abcd.123 OK STORE completed")
+
+		SendLine("abcd.124 NOOP")
+		ExpectResponse("abcd.124 OK NOOP completed")
+	})
+
+	It("should report announced changes before other commands", func() {
+		inbox.MessageBySequenceNumber(1).AddFlags(types.FlagAnswered).Save()
+		tConn.MailboxChanged(username, "INBOX")
+
+		SendLine("abcd.123 UID SEARCH ALL")
+		ExpectResponse("* 1 FETCH (FLAGS (\\Answered \\Recent))")
+		ExpectResponse("* SEARCH 10 11 12")
+		ExpectResponse("abcd.123 OK UID SEARCH completed")
+	})
+
+	It("should hold back expunges until a command which allows them", func() {
+		inbox.(mailstore.ExpungeMailbox).Expunge([]uint32{10})
+		tConn.MailboxChanged(username, "INBOX")
+
+		SendLine("abcd.123 FETCH 1 (UID)")
+		ExpectResponse("* 1 FETCH (UID 10)")
+		ExpectResponse("abcd.123 OK FETCH completed")
+
+		SendLine("abcd.124 CHECK")
+		ExpectResponse("* 1 EXPUNGE")
+		ExpectResponse("abcd.124 OK CHECK completed")
+	})
+
+	It("should search by the sequence numbers the client last saw", func() {
+		inbox.(mailstore.ExpungeMailbox).Expunge([]uint32{10})
+		tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+
+		SendLine("abcd.123 SEARCH 2:*")
+		ExpectResponse("* SEARCH 2 3")
+		ExpectResponse("abcd.123 OK SEARCH completed")
+
+		SendLine("abcd.124 SEARCH 3")
+		ExpectResponse("* SEARCH 3")
+		ExpectResponse("abcd.124 OK SEARCH completed")
+	})
+
+	It("should number messages as the client last saw them", func() {
+		inbox.(mailstore.ExpungeMailbox).Expunge([]uint32{10})
+		tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+
+		SendLine("abcd.123 FETCH 2:* (UID)")
+		ExpectResponse("* 2 FETCH (UID 11)")
+		ExpectResponse("* 3 FETCH (UID 12)")
+		ExpectResponse("abcd.123 OK FETCH completed")
+
+		SendLine("abcd.124 SEARCH ALL")
+		ExpectResponse("* SEARCH 2 3")
+		ExpectResponse("abcd.124 OK SEARCH completed")
+
+		SendLine("abcd.125 NOOP")
+		ExpectResponse("* 1 EXPUNGE")
+		ExpectResponse("abcd.125 OK NOOP completed")
+
+		SendLine("abcd.126 FETCH 2 (UID)")
+		ExpectResponse("* 2 FETCH (UID 12)")
+		ExpectResponse("abcd.126 OK FETCH completed")
+	})
+
+	It("should not mention messages the client hasn't been told of", func() {
+		message := inbox.MessageBySequenceNumber(1)
+		inbox.NewMessage().SetHeaders(message.Header()).SetBody(message.Body()).Save()
+		tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+
+		SendLine("abcd.123 UID FETCH 12:* (UID)")
+		ExpectResponse("* 3 FETCH (UID 12)")
+		ExpectResponse("abcd.123 OK UID FETCH completed")
+
+		SendLine("abcd.124 SEARCH ALL")
+		ExpectResponse("* SEARCH 1 2 3")
+		ExpectResponse("abcd.124 OK SEARCH completed")
+
+		SendLine("abcd.125 UID STORE 13 +FLAGS (\\Flagged)")
+		ExpectResponse("abcd.125 OK STORE completed")
+
+		SendLine("abcd.126 NOOP")
+		ExpectResponse("* 4 EXISTS")
+	})
+
+	It("should only report expunging messages the client knows of", func() {
+		message := inbox.MessageBySequenceNumber(1)
+		inbox.NewMessage().SetHeaders(message.Header()).SetBody(message.Body()).AddFlags(types.FlagDeleted).Save()
+		tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+
+		SendLine("abcd.123 STORE 2 +FLAGS.SILENT (\\Deleted)")
+		ExpectResponse("abcd.123 OK STORE completed")
+
+		SendLine("abcd.124 EXPUNGE")
+		ExpectResponse("* 2 EXPUNGE")
+		ExpectResponse("abcd.124 OK EXPUNGE completed")
+
+		SendLine("abcd.125 UID SEARCH ALL")
+		ExpectResponse("* SEARCH 10 12")
+		ExpectResponse("abcd.125 OK UID SEARCH completed")
+	})
+
+	It("should renumber messages as each EXPUNGE is sent", func() {
+		SendLine("abcd.123 STORE 1:2 +FLAGS.SILENT (\\Deleted)")
+		ExpectResponse("abcd.123 OK STORE completed")
+
+		SendLine("abcd.124 EXPUNGE")
+		ExpectResponse("* 2 EXPUNGE")
+		ExpectResponse("* 1 EXPUNGE")
+		ExpectResponse("abcd.124 OK EXPUNGE completed")
+
+		SendLine("abcd.125 FETCH 1 (UID)")
+		ExpectResponse("* 1 FETCH (UID 12)")
+		ExpectResponse("abcd.125 OK FETCH completed")
+	})
+})
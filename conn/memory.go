@@ -0,0 +1,73 @@
+package conn
+
+import (
+	"sync/atomic"
+
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// Approximate memory held by each message in a cached search result
+const searchCacheEntryBytes = 64
+
+// MemoryUsage is an estimate of the memory held by a connection, in bytes
+type MemoryUsage struct {
+	ConnID           string
+	Username         string // Blank if the client has not authenticated
+	PendingResponses uint64 // Responses waiting to be sent to the client
+	SearchCache      uint64 // Results of recent searches
+	Literals         uint64 // Literals (eg APPENDed messages) read by the current command
+}
+
+// Total returns the estimated memory held by the connection altogether
+func (u MemoryUsage) Total() uint64 {
+	return u.PendingResponses + u.SearchCache + u.Literals
+}
+
+// MemoryUsage estimates the memory held by this connection. This is safe to
+// call from other goroutines.
+func (c *Conn) MemoryUsage() MemoryUsage {
+	c.usernameMutex.Lock()
+	usage := MemoryUsage{ConnID: c.ID, Username: c.username}
+	c.usernameMutex.Unlock()
+
+	usage.PendingResponses = c.pendingResponseBytes()
+	usage.Literals = atomic.LoadUint64(&c.literalBytes)
+
+	c.searchCacheMutex.Lock()
+	usage.SearchCache = c.searchCacheBytes()
+	c.searchCacheMutex.Unlock()
+	return usage
+}
+
+// Size of the responses buffered to be sent to the client
+func (c *Conn) pendingResponseBytes() uint64 {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return uint64(c.writer.Buffered())
+}
+
+// Check whether holding a further number of bytes would take the
+// connection over its memory limit
+func (c *Conn) exceedsMemoryLimit(size uint64) bool {
+	return c.MemoryLimit > 0 && c.MemoryUsage().Total()+size > c.MemoryLimit
+}
+
+// Estimate the memory held by the search cache. The caller must hold
+// searchCacheMutex.
+func (c *Conn) searchCacheBytes() uint64 {
+	var total uint64
+	for key, results := range c.searchCache {
+		total += searchResultBytes(key, results)
+	}
+	return total
+}
+
+// Estimate the memory held by one cached search result
+func searchResultBytes(key searchCacheKey, results []mailstore.Message) uint64 {
+	return uint64(len(key.mailbox)+len(key.criteria)) + uint64(len(results))*searchCacheEntryBytes
+}
+
+// Release the literals read by a command once it has finished
+func (c *Conn) releaseLiterals() {
+	atomic.StoreUint64(&c.literalBytes, 0)
+}
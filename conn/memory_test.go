@@ -0,0 +1,46 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session memory", func() {
+	Context("When a memory limit is set", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+			tConn.MemoryLimit = 250
+		})
+
+		It("should refuse literals which would exceed the limit", func() {
+			SendLine("abcd.123 APPEND INBOX {251}")
+			ExpectResponse("abcd.123 NO [LIMIT] message too large for this session's memory limit")
+		})
+
+		It("should accept literals within the limit", func() {
+			SendLine("abcd.123 APPEND INBOX {20}")
+			ExpectResponse("+ go ahead, feed me your message")
+			SendLine("Subject: Small")
+			SendLine("")
+			SendLine("")
+			ExpectResponse("abcd.123 OK APPEND completed")
+			Expect(tConn.MemoryUsage().Literals).To(BeZero())
+		})
+
+		It("should forget old search results to stay within the limit", func() {
+			SendLine("abcd.123 SEARCH ALL")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.123 OK SEARCH completed")
+			Expect(tConn.MemoryUsage().SearchCache).To(BeNumerically(">", 0))
+
+			SendLine("abcd.124 SEARCH 1:3")
+			ExpectResponse("* SEARCH 1 2 3")
+			ExpectResponse("abcd.124 OK SEARCH completed")
+			Expect(tConn.MemoryUsage().Total()).To(BeNumerically("<=", 250))
+		})
+	})
+})
@@ -0,0 +1,250 @@
+package conn
+
+import (
+	"fmt"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// MessageID identifies a piece of human-readable response text, so that a
+// Catalog can translate it whatever its English wording. Each message is a
+// format string, which is given the message's arguments as by fmt.Sprintf.
+type MessageID string
+
+// The messages sent in response text. The English format string of each,
+// and the arguments it's given, are listed in englishText.
+const (
+	MsgCompleted                   MessageID = "completed"
+	MsgCommandDisabled             MessageID = "command-disabled"
+	MsgLoginDisabled               MessageID = "login-disabled"
+	MsgServerBug                   MessageID = "server-bug"
+	MsgLoggingOut                  MessageID = "logging-out"
+	MsgMissingTag                  MessageID = "missing-tag"
+	MsgMissingCommand              MessageID = "missing-command"
+	MsgUnknownCommand              MessageID = "unknown-command"
+	MsgCommandNotUnderstood        MessageID = "command-not-understood"
+	MsgUnexpectedToken             MessageID = "unexpected-token"
+	MsgUnexpectedEnd               MessageID = "unexpected-end"
+	MsgNotInTransaction            MessageID = "not-in-transaction"
+	MsgAlreadyAuthenticated        MessageID = "already-authenticated"
+	MsgAlreadySelected             MessageID = "already-selected"
+	MsgNotAuthenticated            MessageID = "not-authenticated"
+	MsgNotSelected                 MessageID = "not-selected"
+	MsgReadOnly                    MessageID = "read-only"
+	MsgInvalidLiteralLength        MessageID = "invalid-literal-length"
+	MsgLiteralCommand              MessageID = "literal-command"
+	MsgLineTooLong                 MessageID = "line-too-long"
+	MsgNonSyncLiteralTooLarge      MessageID = "non-sync-literal-too-large"
+	MsgLiteralOverMemoryLimit      MessageID = "literal-over-memory-limit"
+	MsgLiteralTooLarge             MessageID = "literal-too-large"
+	MsgCommandNUL                  MessageID = "command-nul"
+	MsgCommandBareCR               MessageID = "command-bare-cr"
+	MsgCommand8Bit                 MessageID = "command-8bit"
+	MsgCommandInvalidUTF8          MessageID = "command-invalid-utf8"
+	MsgReadyForCommandText         MessageID = "ready-for-command-text"
+	MsgReadyForMessage             MessageID = "ready-for-message"
+	MsgMessageNUL                  MessageID = "message-nul"
+	MsgCannotHoldMessages          MessageID = "cannot-hold-messages"
+	MsgMessageOverMemoryLimit      MessageID = "message-over-memory-limit"
+	MsgUnparseableMessage          MessageID = "unparseable-message"
+	MsgOverQuota                   MessageID = "over-quota"
+	MsgOverAppendLimit             MessageID = "over-append-limit"
+	MsgNoSuchMailbox               MessageID = "no-such-mailbox"
+	MsgNoSuchNamedMailbox          MessageID = "no-such-named-mailbox"
+	MsgNoSuchDestination           MessageID = "no-such-destination"
+	MsgNoSuchMessage               MessageID = "no-such-message"
+	MsgAuthResponseTooLong         MessageID = "auth-response-too-long"
+	MsgIncorrectLogin              MessageID = "incorrect-login"
+	MsgInvalidCredentials          MessageID = "invalid-credentials"
+	MsgAuthCancelled               MessageID = "auth-cancelled"
+	MsgInvalidAuth                 MessageID = "invalid-auth"
+	MsgUnsupportedMechanism        MessageID = "unsupported-mechanism"
+	MsgAuthenticated               MessageID = "authenticated"
+	MsgRemoteServer                MessageID = "remote-server"
+	MsgMailboxExists               MessageID = "mailbox-exists"
+	MsgCannotCreate                MessageID = "cannot-create"
+	MsgCannotDeleteInbox           MessageID = "cannot-delete-inbox"
+	MsgCannotDelete                MessageID = "cannot-delete"
+	MsgHasChildren                 MessageID = "has-children"
+	MsgCannotRename                MessageID = "cannot-rename"
+	MsgRenameBeneathItself         MessageID = "rename-beneath-itself"
+	MsgRelevancyNeedsFuzzy         MessageID = "relevancy-needs-fuzzy"
+	MsgMultiSearchSequence         MessageID = "multisearch-sequence"
+	MsgMultiSearchUpdate           MessageID = "multisearch-update"
+	MsgUnknownSearchTag            MessageID = "unknown-search-tag"
+	MsgUnsupportedCharset          MessageID = "unsupported-charset"
+	MsgVanishedNeedsQResync        MessageID = "vanished-needs-qresync"
+	MsgUnrecognisedParameter       MessageID = "unrecognised-parameter"
+	MsgCondStoreUnsupported        MessageID = "condstore-unsupported"
+	MsgQResyncNotEnabled           MessageID = "qresync-not-enabled"
+	MsgCannotSelect                MessageID = "cannot-select"
+	MsgPreviousMailboxClosed       MessageID = "previous-mailbox-closed"
+	MsgUnrecognisedStatusItem      MessageID = "unrecognised-status-item"
+	MsgFlagNotStorable             MessageID = "flag-not-storable"
+	MsgConditionalStoreFailed      MessageID = "conditional-store-failed"
+	MsgNoSubscriptions             MessageID = "no-subscriptions"
+	MsgNoTransactions              MessageID = "no-transactions"
+	MsgTransactionOpen             MessageID = "transaction-open"
+	MsgNoTransactionOpen           MessageID = "no-transaction-open"
+	MsgTransactionsDisabled        MessageID = "transactions-disabled"
+	MsgNoURLAuth                   MessageID = "no-urlauth"
+	MsgInvalidURLRump              MessageID = "invalid-url-rump"
+	MsgNoURLs                      MessageID = "no-urls"
+	MsgUnsupportedURLAuthMechanism MessageID = "unsupported-urlauth-mechanism"
+	MsgNotOwnMailbox               MessageID = "not-own-mailbox"
+	MsgNoAnnotations               MessageID = "no-annotations"
+	MsgNonPersistentUIDs           MessageID = "non-persistent-uids"
+	MsgNoPermanentFlags            MessageID = "no-permanent-flags"
+	MsgFlagsPermitted              MessageID = "flags-permitted"
+	MsgPredictedUIDNext            MessageID = "predicted-uidnext"
+	MsgUIDsValid                   MessageID = "uids-valid"
+	MsgFirstUnseen                 MessageID = "first-unseen"
+	MsgModSeqUnsupported           MessageID = "modseq-unsupported"
+	MsgModSeqUnavailable           MessageID = "modseq-unavailable"
+	MsgHighestModSeq               MessageID = "highest-modseq"
+	MsgNoLanguages                 MessageID = "no-languages"
+	MsgUnsupportedLanguage         MessageID = "unsupported-language"
+	MsgLanguageChanged             MessageID = "language-changed"
+	MsgLanguagesEnumerated         MessageID = "languages-enumerated"
+	MsgSearching                   MessageID = "searching"
+	MsgCopying                     MessageID = "copying"
+	MsgQuotaWarning                MessageID = "quota-warning"
+	MsgCommandTooSlow              MessageID = "command-too-slow"
+	MsgCommandCancelled            MessageID = "command-cancelled"
+	MsgUnavailable                 MessageID = "unavailable"
+	MsgAuthorizationFailed         MessageID = "authorization-failed"
+	MsgLimit                       MessageID = "limit"
+	MsgNotSupported                MessageID = "not-supported"
+	MsgModSeqNotReached            MessageID = "modseq-not-reached"
+	MsgNotSubscribed               MessageID = "not-subscribed"
+	MsgNoSentMailbox               MessageID = "no-sent-mailbox"
+	MsgNotMultipart                MessageID = "not-multipart"
+	MsgNoSuchPart                  MessageID = "no-such-part"
+	MsgUnknownTransferEncoding     MessageID = "unknown-transfer-encoding"
+	MsgCannotExpunge               MessageID = "cannot-expunge"
+)
+
+// The English text of each message, with the arguments it's given
+var englishText = map[MessageID]string{
+	MsgCompleted:                   "%s completed",                  // The command, eg "UID FETCH"
+	MsgCommandDisabled:             "%s is disabled on this server", // The command or capability
+	MsgLoginDisabled:               "Logging in is disabled on this server",
+	MsgServerBug:                   "Internal server error",
+	MsgLoggingOut:                  "IMAP4rev1 server logging out",
+	MsgMissingTag:                  "Missing or invalid tag",
+	MsgMissingCommand:              "Missing command",
+	MsgUnknownCommand:              "Unknown command %s",
+	MsgCommandNotUnderstood:        "Command not understood",
+	MsgUnexpectedToken:             "Invalid arguments for %s: unexpected %q at position %d, expected: %s", // The command, token, position and syntax
+	MsgUnexpectedEnd:               "Invalid arguments for %s: unexpected end of command at position %d, expected: %s",
+	MsgNotInTransaction:            "Command not allowed in a transaction",
+	MsgAlreadyAuthenticated:        "Already authenticated",
+	MsgAlreadySelected:             "Not allowed once a mailbox has been selected",
+	MsgNotAuthenticated:            "not authenticated",
+	MsgNotSelected:                 "not selected",
+	MsgReadOnly:                    "Selected mailbox is READONLY",
+	MsgInvalidLiteralLength:        "Invalid literal length",
+	MsgLiteralCommand:              "Literal cannot be used as command text",
+	MsgLineTooLong:                 "Command line too long",
+	MsgNonSyncLiteralTooLarge:      "non-synchronizing literal too large",
+	MsgLiteralOverMemoryLimit:      "literal too large for this session's memory limit",
+	MsgLiteralTooLarge:             "literal exceeds the maximum size",
+	MsgCommandNUL:                  "Command contains a NUL byte",
+	MsgCommandBareCR:               "Command contains a bare CR",
+	MsgCommand8Bit:                 "Command contains 8-bit characters, which must be sent as a literal",
+	MsgCommandInvalidUTF8:          "Command contains invalid UTF-8",
+	MsgReadyForCommandText:         "Ready for additional command text",
+	MsgReadyForMessage:             "go ahead, feed me your message",
+	MsgMessageNUL:                  "Message contains NUL, which is only allowed in a literal8",
+	MsgCannotHoldMessages:          "Mailbox cannot hold messages",
+	MsgMessageOverMemoryLimit:      "message too large for this session's memory limit",
+	MsgUnparseableMessage:          "Message could not be parsed: %s", // The parser's error
+	MsgOverQuota:                   mailstore.ErrOverQuota.Error(),
+	MsgOverAppendLimit:             "message exceeds APPENDLIMIT",
+	MsgNoSuchMailbox:               "Mailbox does not exist",
+	MsgNoSuchNamedMailbox:          "No such mailbox %s", // The mailbox name
+	MsgNoSuchDestination:           "Destination mailbox does not exist",
+	MsgNoSuchMessage:               "No such message",
+	MsgAuthResponseTooLong:         "Authentication response too long",
+	MsgIncorrectLogin:              "Incorrect username/password",
+	MsgInvalidCredentials:          "Invalid credentials",
+	MsgAuthCancelled:               "Authentication cancelled",
+	MsgInvalidAuth:                 "Invalid auth details",
+	MsgUnsupportedMechanism:        "Unsupported authentication mechanism",
+	MsgAuthenticated:               "Authenticated",
+	MsgRemoteServer:                "Remote server",
+	MsgMailboxExists:               mailstore.ErrMailboxExists.Error(),
+	MsgCannotCreate:                "Mailboxes cannot be created",
+	MsgCannotDeleteInbox:           "INBOX cannot be deleted",
+	MsgCannotDelete:                "Mailboxes cannot be deleted",
+	MsgHasChildren:                 "Mailbox has children",
+	MsgCannotRename:                "Mailboxes cannot be renamed",
+	MsgRenameBeneathItself:         "Mailbox cannot be moved beneath itself",
+	MsgRelevancyNeedsFuzzy:         "RELEVANCY requires a FUZZY search key",
+	MsgMultiSearchSequence:         "Sequence numbers can't be used to search several mailboxes",
+	MsgMultiSearchUpdate:           "UPDATE and PARTIAL can't be used to search several mailboxes",
+	MsgUnknownSearchTag:            "Unknown search tag %s", // The quoted tag
+	MsgUnsupportedCharset:          "Unsupported charset %s",
+	MsgVanishedNeedsQResync:        "VANISHED requires QRESYNC to be enabled",
+	MsgUnrecognisedParameter:       "Unrecognised Parameter",
+	MsgCondStoreUnsupported:        "CONDSTORE and QRESYNC are not supported",
+	MsgQResyncNotEnabled:           "QRESYNC must be enabled first",
+	MsgCannotSelect:                "Mailbox cannot be selected",
+	MsgPreviousMailboxClosed:       "Previous mailbox closed",
+	MsgUnrecognisedStatusItem:      "Unrecognised status item %s",
+	MsgFlagNotStorable:             "Flag %s can't be stored in this mailbox",
+	MsgConditionalStoreFailed:      "Conditional STORE failed",
+	MsgNoSubscriptions:             "Subscriptions are not supported",
+	MsgNoTransactions:              "Transactions are not supported for this mailbox",
+	MsgTransactionOpen:             "A transaction is already open",
+	MsgNoTransactionOpen:           "No transaction is open",
+	MsgTransactionsDisabled:        "Transactions are not enabled",
+	MsgNoURLAuth:                   "URLAUTH is not supported",
+	MsgInvalidURLRump:              "Invalid URL rump %s",
+	MsgNoURLs:                      "No URLs given",
+	MsgUnsupportedURLAuthMechanism: "Unsupported URLAUTH mechanism %s",
+	MsgNotOwnMailbox:               "URL must refer to your own mailbox",
+	MsgNoAnnotations:               "Annotations are not supported",
+	MsgNonPersistentUIDs:           "Non-persistent UIDs",
+	MsgNoPermanentFlags:            "No permanent flags permitted",
+	MsgFlagsPermitted:              "Flags permitted",
+	MsgPredictedUIDNext:            "Predicted next UID",
+	MsgUIDsValid:                   "UIDs valid",
+	MsgFirstUnseen:                 "First unseen message",
+	MsgModSeqUnsupported:           "Mod-sequences are not supported for this mailbox",
+	MsgModSeqUnavailable:           "Mod-sequences are not available",
+	MsgHighestModSeq:               "Highest mod-sequence",
+	MsgNoLanguages:                 "No languages are available",
+	MsgUnsupportedLanguage:         "Unsupported language",
+	MsgLanguageChanged:             "Language changed",
+	MsgLanguagesEnumerated:         "Supported languages have been enumerated",
+	MsgSearching:                   "Searching",
+	MsgCopying:                     "Copying",
+	MsgQuotaWarning:                "Mailbox storage is %d%% full", // The percentage used
+	MsgCommandTooSlow:              "Command took too long",
+	MsgCommandCancelled:            "Command was cancelled",
+	MsgUnavailable:                 mailstore.ErrUnavailable.Error(),
+	MsgAuthorizationFailed:         mailstore.ErrAuthorizationFailed.Error(),
+	MsgLimit:                       mailstore.ErrLimit.Error(),
+	MsgNotSupported:                mailstore.ErrNotSupported.Error(),
+	MsgModSeqNotReached:            mailstore.ErrModSeqNotReached.Error(),
+	MsgNotSubscribed:               mailstore.ErrNotSubscribed.Error(),
+	MsgNoSentMailbox:               mailstore.ErrNoSentMailbox.Error(),
+	MsgNotMultipart:                mailstore.ErrNotMultipart.Error(),
+	MsgNoSuchPart:                  types.ErrNoSuchPart.Error(),
+	MsgUnknownTransferEncoding:     types.ErrUnknownTransferEncoding.Error(),
+	MsgCannotExpunge:               errCannotExpunge.Error(),
+}
+
+// Render a message in the client's chosen language, or in English if the
+// catalog has no translation for it
+func (c *Conn) text(id MessageID, args ...interface{}) string {
+	format := englishText[id]
+	if c.Catalog != nil && c.language != "" {
+		if translated, ok := c.Catalog.Translate(c.language, id); ok {
+			format = translated
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,60 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Unparseable commands", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateSelected)
+	})
+
+	It("should name unknown commands", func() {
+		SendLine("abcd.123 FROBNICATE 1:*")
+		ExpectResponse("abcd.123 BAD Unknown command FROBNICATE")
+	})
+
+	It("should reject a tag without a command", func() {
+		SendLine("abcd.123 ")
+		ExpectResponse("abcd.123 BAD Missing command")
+	})
+
+	It("should reject a tag on its own", func() {
+		SendLine("abcd.123")
+		ExpectResponse("abcd.123 BAD Missing command")
+	})
+
+	It("should echo tags containing any valid tag characters", func() {
+		SendLine("a-1]~ FROBNICATE")
+		ExpectResponse("a-1]~ BAD Unknown command FROBNICATE")
+		SendLine("a-1]~ NOOP")
+		ExpectResponse("a-1]~ OK NOOP completed")
+	})
+
+	It("should answer commands without a valid tag untagged", func() {
+		SendLine("* NOOP")
+		ExpectResponse("* BAD Missing or invalid tag")
+		SendLine("+ NOOP")
+		ExpectResponse("* BAD Missing or invalid tag")
+		SendLine("a(1 NOOP")
+		ExpectResponse("* BAD Missing or invalid tag")
+	})
+
+	It("should give the expected syntax for invalid arguments", func() {
+		SendLine("abcd.123 FETCH one two")
+		ExpectResponse("abcd.123 BAD Invalid arguments for FETCH: unexpected \"one\" at position 15, expected: [UID] FETCH <sequence set> ALL|FAST|FULL|<data item>|(<data item> ...) [(CHANGEDSINCE <mod-sequence> [VANISHED])]")
+	})
+
+	It("should give the expected syntax for UID commands", func() {
+		SendLine("abcd.123 UID STORE 1 FLAGS")
+		ExpectResponse("abcd.123 BAD Invalid arguments for STORE: unexpected end of command at position 26, expected: [UID] STORE <sequence set> [(UNCHANGEDSINCE <mod-sequence>)] [+|-]FLAGS[.SILENT] (<flags>)")
+	})
+
+	It("should point at the first argument which doesn't fit", func() {
+		SendLine("abcd.123 STORE 1 FLAGX (\\Seen)")
+		ExpectResponse("abcd.123 BAD Invalid arguments for STORE: unexpected \"FLAGX\" at position 17, expected: [UID] STORE <sequence set> [(UNCHANGEDSINCE <mod-sequence>)] [+|-]FLAGS[.SILENT] (<flags>)")
+		SendLine("abcd.124 COPY 1:3")
+		ExpectResponse("abcd.124 BAD Invalid arguments for COPY: unexpected end of command at position 17, expected: [UID] COPY <sequence set> <mailbox>")
+	})
+})
@@ -0,0 +1,91 @@
+package conn
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// The most client input held waiting to be handled. A client which
+// pipelines more than this without reading any responses is no longer
+// read from until the server has caught up.
+const maxQueuedInput = 256 * 1024
+
+// inputQueue reads from the connection as soon as the client sends data,
+// whether or not the server is ready to handle it. Clients may send several
+// commands before reading any responses, and would otherwise be unable to
+// finish sending them while the server waits for its responses to be read.
+type inputQueue struct {
+	src     io.Reader
+	mutex   sync.Mutex
+	ready   *sync.Cond // Signalled when data is queued or taken from the queue
+	queued  bytes.Buffer
+	err     error  // The error which stopped reading from src, if any
+	stopped bool   // Set once the connection has finished with the queue
+	closed  func() // Called once reading from src has stopped, if set
+}
+
+// Start queueing input from src in the background. closed, if not nil, is
+// called as soon as src can't be read any more, eg because the client has
+// disconnected.
+func newInputQueue(src io.Reader, closed func()) *inputQueue {
+	q := &inputQueue{src: src, closed: closed}
+	q.ready = sync.NewCond(&q.mutex)
+	go q.fill()
+	return q
+}
+
+// Copy input from the connection into the queue until it fails, eg because
+// the connection has been closed, or until the queue is stopped
+func (q *inputQueue) fill() {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := q.src.Read(chunk)
+
+		q.mutex.Lock()
+		q.queued.Write(chunk[:n])
+		if err != nil {
+			q.err = err
+		}
+		q.ready.Broadcast()
+		for q.err == nil && !q.stopped && q.queued.Len() >= maxQueuedInput {
+			q.ready.Wait()
+		}
+		stopped := q.stopped
+		q.mutex.Unlock()
+
+		if err != nil || stopped {
+			if q.closed != nil {
+				q.closed()
+			}
+			return
+		}
+	}
+}
+
+// Stop filling the queue, once the connection won't read from it again.
+// Filling stops at once if the queue is full, or otherwise after the next
+// read from the connection returns.
+func (q *inputQueue) stop() {
+	q.mutex.Lock()
+	q.stopped = true
+	q.ready.Broadcast()
+	q.mutex.Unlock()
+}
+
+// Read implements io.Reader, returning queued input as soon as there is
+// any. The error which stopped the queue being filled is returned once all
+// of the queued input has been read.
+func (q *inputQueue) Read(p []byte) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.queued.Len() == 0 && q.err == nil {
+		q.ready.Wait()
+	}
+	if q.queued.Len() == 0 {
+		return 0, q.err
+	}
+	n, _ := q.queued.Read(p)
+	q.ready.Broadcast()
+	return n, nil
+}
@@ -0,0 +1,39 @@
+package conn
+
+import (
+	"testing"
+	"time"
+)
+
+// A client which never stops sending
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestInputQueueStop(t *testing.T) {
+	done := make(chan bool)
+	q := newInputQueue(endlessReader{}, func() { close(done) })
+
+	// Wait for the queue to fill up
+	for {
+		q.mutex.Lock()
+		full := q.queued.Len() >= maxQueuedInput
+		q.mutex.Unlock()
+		if full {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	q.stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected a full queue to stop filling once stopped")
+	}
+}
@@ -0,0 +1,51 @@
+package conn_test
+
+import (
+	"fmt"
+
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Pipelined commands", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateNotAuthenticated)
+	})
+
+	It("should handle commands sent before any responses are read", func() {
+		SendLine("abcd.1 LOGIN username password")
+		SendLine("abcd.2 SELECT INBOX")
+		SendLine("abcd.3 FETCH 1:2 (UID)")
+
+		ExpectResponse("abcd.1 OK Authenticated")
+		ExpectResponse("* 3 EXISTS")
+		ExpectResponse("* 3 RECENT")
+		ExpectResponse("* OK [UNSEEN 1] First unseen message")
+		ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+		ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+		ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+		ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
+		ExpectResponse("abcd.2 OK [READ-WRITE] SELECT completed")
+		ExpectResponse("* 1 FETCH (UID 10)")
+		ExpectResponse("* 2 FETCH (UID 11)")
+		ExpectResponse("abcd.3 OK FETCH completed")
+	})
+
+	It("should handle commands sent in a single write", func() {
+		SendLine("abcd.1 LOGIN username password\r\nabcd.2 NOOP\r\nabcd.3 LOGOUT")
+		ExpectResponse("abcd.1 OK Authenticated")
+		ExpectResponse("abcd.2 OK NOOP completed")
+		ExpectResponse("* BYE IMAP4rev1 server logging out")
+		ExpectResponse("abcd.3 OK LOGOUT completed")
+	})
+
+	It("should keep reading while many responses are waiting to be read", func() {
+		for i := 0; i < 500; i++ {
+			SendLine(fmt.Sprintf("abcd.%d CAPABILITY", i))
+		}
+		for i := 0; i < 500; i++ {
+			ExpectResponsePattern("^\\* CAPABILITY ")
+			ExpectResponse(fmt.Sprintf("abcd.%d OK CAPABILITY completed", i))
+		}
+	})
+})
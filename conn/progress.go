@@ -0,0 +1,27 @@
+package conn
+
+import (
+	"fmt"
+	"time"
+)
+
+// Returns a function which long-running commands call as they work through
+// their items. Whenever ProgressInterval has passed since the command began
+// or last reported its progress, an untagged OK with an INPROGRESS response
+// code (RFC 9585) is sent straight away, so that clients and proxies can
+// tell the command hasn't stalled. Returns nil if progress isn't reported.
+func (c *Conn) progressReporter(tag string, id MessageID) func(done int, total int) {
+	if c.ProgressInterval <= 0 {
+		return nil
+	}
+
+	last := time.Now()
+	return func(done int, total int) {
+		if time.Since(last) < c.ProgressInterval {
+			return
+		}
+		last = time.Now()
+		c.writeOK("", codeInProgress.with(fmt.Sprintf("(\"%s\" %d %d)", tag, done, total)), c.text(id))
+		c.Flush()
+	}
+}
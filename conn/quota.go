@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+// Warn the client with an [ALERT] if the user's quota usage has crossed one
+// of the configured warning thresholds since they were last warned
+func (c *Conn) checkQuotaWarnings() {
+	if len(c.QuotaWarningThresholds) == 0 || c.User == nil {
+		return
+	}
+	if c.state != StateAuthenticated && c.state != StateSelected {
+		return
+	}
+
+	quotaUser, ok := c.User.(mailstore.QuotaUser)
+	if !ok {
+		return
+	}
+	used, limit, err := mailstore.QuotaUsage(c.context(), quotaUser)
+	if err != nil || limit == 0 {
+		return
+	}
+
+	percent := used * 100 / limit
+	crossed := uint8(0)
+	for _, threshold := range c.QuotaWarningThresholds {
+		if percent >= uint64(threshold) && threshold > crossed {
+			crossed = threshold
+		}
+	}
+
+	// Only warn once per threshold, but warn again if usage drops back
+	// below a threshold and later crosses it again
+	if crossed > c.quotaWarned {
+		c.writeOK("", codeAlert, c.text(MsgQuotaWarning, percent))
+	}
+	c.quotaWarned = crossed
+}
+
+// Check whether adding a message of the given size would take the user over
+// their quota
+func (c *Conn) exceedsQuota(size uint64) bool {
+	quotaUser, ok := c.User.(mailstore.QuotaUser)
+	if !ok {
+		return false
+	}
+	used, limit, err := mailstore.QuotaUsage(c.context(), quotaUser)
+	if err != nil || limit == 0 {
+		return false
+	}
+	return used+size > limit
+}
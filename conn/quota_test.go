@@ -0,0 +1,50 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Quota warnings", func() {
+	Context("When logged in as a user at 90% of their quota", func() {
+		BeforeEach(func() {
+			user := mStore.User
+			used, _, _ := user.QuotaUsage()
+			user.QuotaLimit = used * 10 / 9
+
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = user
+			tConn.QuotaWarningThresholds = []uint8{80, 95}
+		})
+
+		It("should warn once when a threshold has been crossed", func() {
+			SendLine("abcd.123 NOOP")
+			ExpectResponse("* OK [ALERT] Mailbox storage is 90% full")
+			ExpectResponse("abcd.123 OK NOOP completed")
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+
+		It("should reject appends which would exceed the quota", func() {
+			SendLine("abcd.123 APPEND INBOX {1000}")
+			ExpectResponse("* OK [ALERT] Mailbox storage is 90% full")
+			ExpectResponse("abcd.123 NO [OVERQUOTA] Mailbox storage quota exceeded")
+		})
+	})
+
+	Context("When logged in as a user well within their quota", func() {
+		BeforeEach(func() {
+			user := mStore.User
+			user.QuotaLimit = 1000000
+
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = user
+			tConn.QuotaWarningThresholds = []uint8{80, 95}
+		})
+
+		It("should not warn", func() {
+			SendLine("abcd.123 NOOP")
+			ExpectResponse("abcd.123 OK NOOP completed")
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package conn
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// Take the selected mailbox's recent messages for this session, so that no
+// other session will see them as recent. Mailboxes opened read-only are
+// left alone, and their messages stay recent for the next session which
+// selects them.
+func (c *Conn) claimRecent() {
+	if c.mailboxWritable != ReadWrite {
+		return
+	}
+	mailbox, err := mailstore.MailboxByName(c.context(), c.User, c.SelectedMailbox.Name())
+	if err != nil {
+		return
+	}
+	uids, err := claimRecent(c.context(), mailbox)
+	if err != nil {
+		c.logf("Error claiming recent messages: %s\n", err)
+	}
+	if c.recent == nil {
+		c.recent = make(map[uint32]bool)
+	}
+	for _, uid := range uids {
+		c.recent[uid] = true
+	}
+}
+
+// Clear the \Recent flag from a mailbox's messages, returning the UIDs of
+// the messages which had it. Mailboxes which can't do so themselves have
+// each message saved without the flag.
+func claimRecent(ctx context.Context, m mailstore.Mailbox) ([]uint32, error) {
+	if recent, ok := m.(mailstore.RecentMailbox); ok {
+		if uids, err := mailstore.ClaimRecent(ctx, recent); !errors.Is(err, mailstore.ErrNotSupported) {
+			return uids, err
+		}
+	}
+
+	msgs, err := allMessages(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, msg := range msgs {
+		if !msg.Flags().HasFlags(types.FlagRecent) {
+			continue
+		}
+		msg, err = mailstore.UpdateFlags(ctx, msg, types.StoreRemove, types.FlagRecent)
+		if err == nil {
+			_, err = mailstore.SaveMessage(ctx, msg)
+		}
+		if err != nil {
+			return uids, err
+		}
+		uids = append(uids, msg.UID())
+	}
+	return uids, nil
+}
+
+// The flags of a message as this session sees them. A message is recent if
+// this session claimed it, or if no session has claimed it yet.
+func (c *Conn) sessionFlags(m mailstore.Message) types.Flags {
+	if c.recent[m.UID()] {
+		return m.Flags().SetFlags(types.FlagRecent)
+	}
+	return m.Flags()
+}
+
+// Count the messages in a mailbox which are recent to this session
+func (c *Conn) recentCount(m mailstore.Mailbox) uint32 {
+	if c.recent == nil {
+		return m.Recent()
+	}
+	msgs, _ := allMessages(c.context(), m)
+	var count uint32
+	for _, msg := range msgs {
+		if c.sessionFlags(msg).HasFlags(types.FlagRecent) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,56 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("\\Recent flag", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateAuthenticated)
+		tConn.User = mStore.User
+	})
+
+	// Send a command which opens INBOX, skipping the mailbox's details
+	selectInbox := func(command string) {
+		SendLine("abcd.123 " + command + " INBOX")
+		ExpectResponse("* 3 EXISTS")
+		ExpectResponse("* 3 RECENT")
+		for i := 0; i < 5; i++ {
+			reader.ReadLine()
+		}
+		ExpectResponsePattern("^abcd.123 OK ")
+	}
+
+	It("should only be seen by the first session to select the mailbox", func() {
+		selectInbox("SELECT")
+
+		inbox, _ := mStore.User.MailboxByName("INBOX")
+		Expect(inbox.Recent()).To(BeEquivalentTo(0))
+		Expect(inbox.MessageByUID(10).Flags().HasFlags(types.FlagRecent)).To(BeFalse())
+
+		SendLine("abcd.124 STATUS INBOX (RECENT)")
+		ExpectResponse("* STATUS INBOX (RECENT 0)")
+		ExpectResponse("abcd.124 OK STATUS completed")
+	})
+
+	It("should stay recent for the rest of the session", func() {
+		selectInbox("SELECT")
+
+		SendLine("abcd.124 FETCH 1 (FLAGS)")
+		ExpectResponse("* 1 FETCH (FLAGS (\\Recent))")
+		ExpectResponse("abcd.124 OK FETCH completed")
+		SendLine("abcd.125 SEARCH RECENT")
+		ExpectResponse("* SEARCH 1 2 3")
+		ExpectResponse("abcd.125 OK SEARCH completed")
+	})
+
+	It("should be left for the next session when examining the mailbox", func() {
+		selectInbox("EXAMINE")
+
+		inbox, _ := mStore.User.MailboxByName("INBOX")
+		Expect(inbox.Recent()).To(BeEquivalentTo(3))
+	})
+})
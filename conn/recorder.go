@@ -0,0 +1,181 @@
+package conn
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionRecord is a structured record of a client session, which can be
+// encoded as JSON for offline analysis or to attach to a bug report.
+// Credentials and the contents of literals (eg message bodies) are redacted.
+type SessionRecord struct {
+	ConnID   string          `json:"connId"`
+	Started  time.Time       `json:"started"`
+	Ended    time.Time       `json:"ended"`
+	Greeting []string        `json:"greeting"` // Responses sent before the first command
+	Commands []CommandRecord `json:"commands"`
+}
+
+// CommandRecord is a single command of a recorded session and the
+// responses sent while it was executed
+type CommandRecord struct {
+	Tag          string    `json:"tag"`
+	Command      string    `json:"command"` // eg "FETCH" or "UID FETCH"
+	Arguments    string    `json:"arguments"`
+	Started      time.Time `json:"started"`
+	Duration     float64   `json:"durationMs"`
+	RequestSize  int       `json:"requestSize"`  // Bytes in the command line
+	ResponseSize int       `json:"responseSize"` // Bytes sent in response, before redaction
+	Responses    []string  `json:"responses"`
+}
+
+// WriteJSON writes the session as indented JSON
+func (r SessionRecord) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Commands whose arguments after the first are credentials
+var credentialCommands = map[string]bool{
+	"LOGIN":        true,
+	"AUTHENTICATE": true,
+}
+
+// The token at the end of an authorized URL
+var urlAuthTokenRE = regexp.MustCompile(`(?i)(;URLAUTH=[^:"\s]+:[^:"\s]+:)[0-9a-f]+`)
+
+// The length of a literal, eg {152} or ~{11}, at the end of a line
+var literalRE = regexp.MustCompile(`~?\{(\d+)\+?\}\r\n`)
+
+const redacted = "<redacted>"
+
+// Start recording the session, if a recorder has been set
+func (c *Conn) startRecording() {
+	if c.RecordSession == nil {
+		return
+	}
+	c.recordMutex.Lock()
+	c.record = &SessionRecord{
+		ConnID:   c.ID,
+		Started:  time.Now(),
+		Greeting: make([]string, 0),
+		Commands: make([]CommandRecord, 0),
+	}
+	c.recordMutex.Unlock()
+}
+
+// Hand the finished recording to the recorder
+func (c *Conn) finishRecording() {
+	c.recordMutex.Lock()
+	record := c.record
+	c.record = nil
+	c.recordMutex.Unlock()
+
+	if record != nil {
+		record.Ended = time.Now()
+		c.RecordSession(*record)
+	}
+}
+
+// Add a command to the recording
+func (c *Conn) recordCommand(req string) {
+	c.recordMutex.Lock()
+	defer c.recordMutex.Unlock()
+	if c.record == nil {
+		return
+	}
+
+	fields := strings.SplitN(req, " ", 2)
+	verb := commandVerb(req)
+	arguments := ""
+	if len(fields) == 2 {
+		// Skip past the verb, which may be two words (eg UID FETCH)
+		arguments = strings.TrimSpace(fields[1])
+		for range strings.Fields(verb) {
+			parts := strings.SplitN(arguments, " ", 2)
+			arguments = ""
+			if len(parts) == 2 {
+				arguments = parts[1]
+			}
+		}
+	}
+
+	c.record.Commands = append(c.record.Commands, CommandRecord{
+		Tag:         fields[0],
+		Command:     verb,
+		Arguments:   redactArguments(verb, arguments),
+		Started:     time.Now(),
+		RequestSize: len(req),
+		Responses:   make([]string, 0),
+	})
+}
+
+// Note how long the current command took
+func (c *Conn) endRecordedCommand() {
+	c.recordMutex.Lock()
+	defer c.recordMutex.Unlock()
+	if c.record == nil || len(c.record.Commands) == 0 {
+		return
+	}
+	command := &c.record.Commands[len(c.record.Commands)-1]
+	command.Duration = float64(time.Since(command.Started)) / float64(time.Millisecond)
+}
+
+// Add response data to the recording. Responses sent outside any command
+// (eg an untagged BYE when the server is shutting down) are recorded
+// against the last command.
+func (c *Conn) recordResponse(p []byte) {
+	c.recordMutex.Lock()
+	defer c.recordMutex.Unlock()
+	if c.record == nil {
+		return
+	}
+
+	lines := strings.Split(strings.TrimSuffix(redactResponse(string(p)), lineEnding), lineEnding)
+	if len(c.record.Commands) == 0 {
+		c.record.Greeting = append(c.record.Greeting, lines...)
+		return
+	}
+	command := &c.record.Commands[len(c.record.Commands)-1]
+	command.Responses = append(command.Responses, lines...)
+	command.ResponseSize += len(p)
+}
+
+// Remove credentials from the arguments of a command
+func redactArguments(verb string, arguments string) string {
+	if credentialCommands[verb] {
+		fields := strings.SplitN(arguments, " ", 2)
+		if len(fields) == 2 {
+			return fields[0] + " " + redacted
+		}
+		return arguments
+	}
+	return urlAuthTokenRE.ReplaceAllString(arguments, "${1}"+redacted)
+}
+
+// Remove the contents of literals and URLAUTH tokens from responses
+func redactResponse(response string) string {
+	response = urlAuthTokenRE.ReplaceAllString(response, "${1}"+redacted)
+
+	var result strings.Builder
+	for {
+		loc := literalRE.FindStringSubmatchIndex(response)
+		if loc == nil {
+			result.WriteString(response)
+			return result.String()
+		}
+		length, _ := strconv.Atoi(response[loc[2]:loc[3]])
+		end := loc[1] + length
+		if end > len(response) {
+			end = len(response)
+		}
+		result.WriteString(response[:loc[0]])
+		result.WriteString("{" + strconv.Itoa(length) + "} " + redacted)
+		response = response[end:]
+	}
+}
@@ -0,0 +1,63 @@
+package conn_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session recording", func() {
+	var records chan conn.SessionRecord
+
+	BeforeEach(func() {
+		records = make(chan conn.SessionRecord, 1)
+		tConn.RecordSession = func(record conn.SessionRecord) {
+			records <- record
+		}
+	})
+
+	It("should record commands and responses with credentials and bodies redacted", func() {
+		ExpectResponsePattern("^\\* OK")
+		SendLine("abcd.123 LOGIN \"username\" \"password\"")
+		ExpectResponse("abcd.123 OK Authenticated")
+		SendLine("abcd.124 SELECT INBOX")
+		for i := 0; i < 7; i++ {
+			reader.ReadLine()
+		}
+		ExpectResponse("abcd.124 OK [READ-WRITE] SELECT completed")
+		SendLine("abcd.125 FETCH 3 (BODY.PEEK[TEXT] UID)")
+		ExpectResponse("* 3 FETCH (BODY[TEXT] {7}")
+		ExpectResponse("Hello")
+		ExpectResponse(" UID 12)")
+		ExpectResponse("abcd.125 OK FETCH completed")
+		SendLine("abcd.126 LOGOUT")
+		ExpectResponsePattern("^\\* BYE")
+		ExpectResponse("abcd.126 OK LOGOUT completed")
+
+		var record conn.SessionRecord
+		Eventually(records).Should(Receive(&record))
+		Expect(record.Greeting).To(HaveLen(1))
+		Expect(record.Commands).To(HaveLen(4))
+
+		login := record.Commands[0]
+		Expect(login.Tag).To(Equal("abcd.123"))
+		Expect(login.Command).To(Equal("LOGIN"))
+		Expect(login.Arguments).To(Equal("\"username\" <redacted>"))
+
+		fetch := record.Commands[2]
+		Expect(fetch.Arguments).To(Equal("3 (BODY.PEEK[TEXT] UID)"))
+		Expect(fetch.Responses).To(Equal([]string{
+			"* 3 FETCH (BODY[TEXT] {7} <redacted> UID 12)",
+			"abcd.125 OK FETCH completed",
+		}))
+		Expect(fetch.ResponseSize).To(Equal(73))
+
+		var encoded bytes.Buffer
+		Expect(record.WriteJSON(&encoded)).To(Succeed())
+		Expect(json.Valid(encoded.Bytes())).To(BeTrue())
+		Expect(encoded.String()).NotTo(ContainSubstring("password"))
+	})
+})
@@ -0,0 +1,379 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jordwest/imap-server/types"
+	"github.com/jordwest/imap-server/util"
+)
+
+// ErrNoRequestType is returned by ParseRequest for commands which are
+// understood, but aren't parsed into a typed request
+var ErrNoRequestType = errors.New("Command has no request type")
+
+// ErrUnknownCommand is returned by ParseRequest for commands which aren't
+// understood at all
+var ErrUnknownCommand = errors.New("Command not understood")
+
+var errInvalidLiteralLength = errors.New("invalid length for message literal")
+var errInvalidAppendDate = errors.New("invalid date-time for message")
+var errInvalidQResync = errors.New("invalid QRESYNC parameters")
+var errInvalidModSeq = errors.New("invalid mod-sequence")
+var errMailboxControl = errors.New("Mailbox name cannot contain control characters")
+
+// ParseRequest parses a tagged command line, eg `a1 UID FETCH 1:* (FLAGS)`,
+// into the request the server would handle. Only some commands have typed
+// requests; ErrNoRequestType is returned for the others. A *SyntaxError is
+// returned for known commands whose arguments don't fit their syntax.
+func ParseRequest(line string) (types.Request, error) {
+	for _, cmd := range commands {
+		matches := cmd.match.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		if cmd.name == "" {
+			if err := syntaxError(line); err != nil {
+				return nil, err
+			}
+			return nil, ErrUnknownCommand
+		}
+		if cmd.parse == nil {
+			return nil, ErrNoRequestType
+		}
+		return cmd.parse(commandArgs(matches))
+	}
+	return nil, ErrUnknownCommand
+}
+
+// Register a command whose arguments are parsed into a typed request, which
+// ParseRequest can produce
+func registerRequest(name string, matchExpr string, parse func(commandArgs) (types.Request, error), handleFunc func(commandArgs, *Conn)) {
+	registerCommand(name, matchExpr, handleFunc)
+	commands[len(commands)-1].parse = parse
+}
+
+// Decode a mailbox name argument, sent as an atom or quoted string in
+// modified UTF-7. A name containing 8-bit characters is taken to be UTF-8,
+// as sent by clients which have enabled UTF8=ACCEPT. Names with control
+// characters, which could only have been sent in a literal, are refused.
+func mailboxName(arg string) (string, error) {
+	name := util.Unquote(arg)
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", errMailboxControl
+	}
+	if !isASCII(name) && utf8.ValidString(name) {
+		return name, nil
+	}
+	return util.DecodeMailboxName(name)
+}
+
+// Parse the arguments of SELECT, or of EXAMINE if readOnly is set, with
+// any CONDSTORE or QRESYNC parameter
+func selectRequest(args commandArgs, readOnly bool) (types.SelectRequest, error) {
+	name, err := mailboxName(args.Arg(selectArgMailbox))
+	req := types.SelectRequest{Mailbox: name, ReadOnly: readOnly}
+	if err != nil || args.Arg(selectArgParams) == "" {
+		return req, err
+	}
+	params, err := util.ParseList(args.Arg(selectArgParams))
+	if err != nil {
+		return req, err
+	}
+	for i := 0; i < len(params); i++ {
+		switch name := strings.ToUpper(params[i].Value); {
+		case name == "CONDSTORE" && !params[i].IsList:
+			req.CondStore = true
+		case name == "QRESYNC" && !params[i].IsList && i+1 < len(params) && params[i+1].IsList:
+			i++
+			if req.QResync, err = qresyncParams(params[i].List); err != nil {
+				return req, err
+			}
+		default:
+			return req, fmt.Errorf("Unknown %s parameter %s", req.Command(), params[i].String())
+		}
+	}
+	return req, nil
+}
+
+// Parse the parameters of QRESYNC, ie the UIDVALIDITY and mod-sequence the
+// client knows of, and optionally the UIDs it knows and some sequence
+// numbers with their UIDs, eg (67890007 90060115194045000 41:211 (1:5 41:45))
+func qresyncParams(items []util.ListItem) (*types.QResyncParams, error) {
+	if len(items) < 2 || len(items) > 4 || items[0].IsList || items[1].IsList {
+		return nil, errInvalidQResync
+	}
+	validity, err := strconv.ParseUint(items[0].Value, 10, 32)
+	if err != nil || validity == 0 {
+		return nil, errInvalidQResync
+	}
+	modseq, err := strconv.ParseUint(items[1].Value, 10, 63)
+	if err != nil || modseq == 0 {
+		return nil, errInvalidQResync
+	}
+	params := &types.QResyncParams{UIDValidity: uint32(validity), ModSeq: modseq}
+
+	rest := items[2:]
+	if len(rest) > 0 && !rest[0].IsList {
+		if params.KnownUIDs, err = types.InterpretUIDSet(rest[0].Value); err != nil {
+			return nil, errInvalidQResync
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		match := rest[0].List
+		if len(rest) > 1 || !rest[0].IsList || len(match) != 2 || match[0].IsList || match[1].IsList {
+			return nil, errInvalidQResync
+		}
+		if params.KnownSequences, err = types.InterpretSequenceSet(match[0].Value); err != nil {
+			return nil, errInvalidQResync
+		}
+		if params.KnownSeqUIDs, err = types.InterpretUIDSet(match[1].Value); err != nil {
+			return nil, errInvalidQResync
+		}
+	}
+	return params, nil
+}
+
+// Parse the arguments of FETCH. A single data item or macro may be given
+// without parentheses.
+func fetchRequest(args commandArgs) (types.FetchRequest, error) {
+	req := types.FetchRequest{UID: strings.ToUpper(args.Arg(fetchArgUID)) == "UID "}
+	var err error
+	if req.UID {
+		req.UIDs, err = types.InterpretUIDSet(args.Arg(fetchArgRange))
+	} else {
+		req.Set, err = types.InterpretSequenceSet(args.Arg(fetchArgRange))
+	}
+	if err != nil {
+		return req, err
+	}
+	items, err := util.ParseList(args.Arg(fetchArgParams))
+	if err != nil {
+		return req, err
+	}
+	if last := len(items) - 1; last > 0 && isFetchModifiers(items[last]) {
+		if err = fetchModifiers(&req, items[last].List); err != nil {
+			return req, err
+		}
+		items = items[:last]
+	}
+	req.Attributes, err = types.ParseFetchAttributes(items)
+	return req, err
+}
+
+// Whether a list following the data items of FETCH holds its modifiers,
+// rather than the arguments of a data item such as ANNOTATION
+func isFetchModifiers(item util.ListItem) bool {
+	if !item.IsList || len(item.List) == 0 || item.List[0].IsList {
+		return false
+	}
+	name := strings.ToUpper(item.List[0].Value)
+	return name == "CHANGEDSINCE" || name == "VANISHED"
+}
+
+// Parse the modifiers of FETCH, eg (CHANGEDSINCE 12345 VANISHED). VANISHED
+// may only be given to UID FETCH, along with CHANGEDSINCE (RFC 7162 section
+// 3.2.6).
+func fetchModifiers(req *types.FetchRequest, items []util.ListItem) error {
+	for i := 0; i < len(items); i++ {
+		switch name := strings.ToUpper(items[i].Value); {
+		case name == "CHANGEDSINCE" && i+1 < len(items) && !items[i+1].IsList:
+			i++
+			modseq, err := strconv.ParseUint(items[i].Value, 10, 63)
+			if err != nil || modseq == 0 {
+				return errInvalidModSeq
+			}
+			req.ChangedSince = modseq
+		case name == "VANISHED":
+			req.Vanished = true
+		default:
+			return fmt.Errorf("Unknown FETCH modifier %s", items[i].String())
+		}
+	}
+	if req.Vanished && (!req.UID || req.ChangedSince == 0) {
+		return errors.New("VANISHED can only be given to UID FETCH with CHANGEDSINCE")
+	}
+	return nil
+}
+
+// Parse the arguments of STORE, when it changes flags
+func storeRequest(args commandArgs) (types.StoreRequest, error) {
+	req := types.StoreRequest{
+		UID:       strings.ToUpper(args.Arg(storeArgUID)) == "UID ",
+		Operation: types.StoreOperation(args.Arg(storeArgOperation)),
+		Silent:    strings.EqualFold(args.Arg(storeArgSilent), ".SILENT"),
+	}
+	var err error
+	if unchangedSince := args.Arg(storeArgUnchangedSince); unchangedSince != "" {
+		req.Conditional = true
+		if req.UnchangedSince, err = strconv.ParseUint(unchangedSince, 10, 63); err != nil {
+			return req, errInvalidModSeq
+		}
+	}
+	if req.Flags, err = types.ParseFlagList(args.Arg(storeArgFlags)); err != nil {
+		return req, err
+	}
+	if req.UID {
+		req.UIDs, err = types.InterpretUIDSet(args.Arg(storeArgRange))
+	} else {
+		req.Set, err = types.InterpretSequenceSet(args.Arg(storeArgRange))
+	}
+	return req, err
+}
+
+// Parse the RETURN options of an extended SEARCH or SORT, in parentheses, or
+// return nil if none were given
+func searchReturn(arg string) (*types.SearchReturn, error) {
+	if arg == "" {
+		return nil, nil
+	}
+	ret, err := types.ParseSearchReturn(strings.TrimSuffix(strings.TrimPrefix(arg, "("), ")"))
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Parse the arguments of SORT
+func sortRequest(args commandArgs) (types.SortRequest, error) {
+	req := types.SortRequest{
+		UID:     strings.ToUpper(args.Arg(sortArgUID)) == "UID ",
+		Charset: strings.Trim(args.Arg(sortArgCharset), "\""),
+	}
+	var err error
+	if req.Return, err = searchReturn(args.Arg(sortArgReturn)); err != nil {
+		return req, err
+	}
+	if req.Order, err = types.ParseSortCriteria(args.Arg(sortArgOrder)); err != nil {
+		return req, err
+	}
+	req.Criteria, err = types.ParseSearchCriteria(args.Arg(sortArgCriteria))
+	return req, err
+}
+
+// Parse the arguments of ESEARCH. Without IN, the selected mailbox is
+// searched.
+func multiSearchRequest(args commandArgs) (types.MultiSearchRequest, error) {
+	req := types.MultiSearchRequest{Charset: strings.Trim(args.Arg(multiSearchArgCharset), "\"")}
+	var err error
+	if req.Sources, err = mailboxFilters(args.Arg(multiSearchArgIn)); err != nil {
+		return req, err
+	}
+	if req.Return, err = searchReturn(args.Arg(multiSearchArgReturn)); err != nil {
+		return req, err
+	}
+	req.Criteria, err = types.ParseSearchCriteria(args.Arg(multiSearchArgCriteria))
+	return req, err
+}
+
+// Parse the mailbox filters given to ESEARCH IN, eg
+// "personal subtree (Archive Lists)"
+func mailboxFilters(arg string) ([]types.MailboxFilter, error) {
+	if arg == "" {
+		return []types.MailboxFilter{{Kind: "selected"}}, nil
+	}
+	items, err := util.ParseList(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]types.MailboxFilter, 0, len(items))
+	for i := 0; i < len(items); i++ {
+		filter := types.MailboxFilter{Kind: strings.ToLower(items[i].Value)}
+		switch filter.Kind {
+		case "selected", "selected-delayed", "inboxes", "personal", "subscribed":
+		case "subtree", "subtree-one", "mailboxes":
+			i++
+			if i == len(items) {
+				return nil, fmt.Errorf("Expected mailboxes after %s", filter.Kind)
+			}
+			names := []util.ListItem{items[i]}
+			if items[i].IsList {
+				names = items[i].List
+			}
+			for _, item := range names {
+				name, err := mailboxName(item.Value)
+				if err != nil {
+					return nil, err
+				}
+				filter.Mailboxes = append(filter.Mailboxes, name)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown mailbox filter %s", items[i].String())
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// Parse the arguments of SEARCH
+func searchRequest(args commandArgs) (types.SearchRequest, error) {
+	req := types.SearchRequest{
+		UID:     strings.ToUpper(args.Arg(searchArgUID)) == "UID ",
+		Charset: strings.Trim(args.Arg(searchArgCharset), "\""),
+	}
+	var err error
+	if req.Return, err = searchReturn(args.Arg(searchArgReturn)); err != nil {
+		return req, err
+	}
+	req.Criteria, err = types.ParseSearchCriteria(args.Arg(searchArgCriteria))
+	return req, err
+}
+
+// Parse the APPEND arguments of APPEND or REPLACE, starting at position
+// first. The literal's length is parsed first, and if it's invalid the
+// request has a Length of 0. Otherwise the details of the literal are
+// returned even if the other arguments are invalid, as the literal may have
+// to be consumed before the error can be reported.
+func appendRequest(args commandArgs, first int) (types.AppendRequest, error) {
+	var req types.AppendRequest
+	length, err := strconv.ParseUint(args.Arg(first+appendArgLength), 10, 64)
+	if err != nil || length == 0 {
+		return req, errInvalidLiteralLength
+	}
+	req.Length = length
+	req.NonSync = args.Arg(first+appendArgNonSync) == "+"
+	req.Binary = args.Arg(first+appendArgBinary) == "~"
+	if req.Flags, err = types.ParseFlagList(args.Arg(first + appendArgFlags)); err != nil {
+		return req, err
+	}
+
+	if date := args.Arg(first + appendArgDate); date != "" {
+		if req.Date, err = types.ParseDateTime(date); err != nil {
+			return req, errInvalidAppendDate
+		}
+	}
+	req.Mailbox, err = mailboxName(args.Arg(first + appendArgMailbox))
+	return req, err
+}
+
+// Parse the arguments of REPLACE
+func replaceRequest(args commandArgs) (types.ReplaceRequest, error) {
+	req := types.ReplaceRequest{UID: strings.ToUpper(args.Arg(replaceArgUID)) == "UID "}
+	id, _ := strconv.ParseUint(args.Arg(replaceArgMessage), 10, 32)
+	req.Message = uint32(id)
+	var err error
+	req.Append, err = appendRequest(args, replaceArgAppend)
+	return req, err
+}
+
+// Parse the arguments of COPY
+func copyRequest(args commandArgs) (types.CopyRequest, error) {
+	req := types.CopyRequest{UID: strings.ToUpper(args.Arg(copyArgUID)) == "UID "}
+	var err error
+	if req.UID {
+		req.UIDs, err = types.InterpretUIDSet(args.Arg(copyArgRange))
+	} else {
+		req.Set, err = types.InterpretSequenceSet(args.Arg(copyArgRange))
+	}
+	if err != nil {
+		return req, err
+	}
+	req.Mailbox, err = mailboxName(args.Arg(copyArgMailbox))
+	return req, err
+}
@@ -0,0 +1,128 @@
+package conn_test
+
+import (
+	"time"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Typed requests", func() {
+	It("should parse SELECT and EXAMINE", func() {
+		req, err := conn.ParseRequest(`a1 EXAMINE "Entw&APw-rfe"`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(req).To(Equal(types.SelectRequest{Mailbox: "Entwürfe", ReadOnly: true}))
+		Expect(req.Command()).To(Equal("EXAMINE"))
+	})
+
+	It("should parse the QRESYNC parameter of SELECT", func() {
+		req, err := conn.ParseRequest("a1 SELECT INBOX (QRESYNC (67890007 90060115194045000 41:211 (1:5 41:45)))")
+		Expect(err).ToNot(HaveOccurred())
+		params := req.(types.SelectRequest).QResync
+		Expect(params.UIDValidity).To(Equal(uint32(67890007)))
+		Expect(params.ModSeq).To(Equal(uint64(90060115194045000)))
+		Expect(params.KnownUIDs.String()).To(Equal("41:211"))
+		Expect(params.KnownSequences.String()).To(Equal("1:5"))
+		Expect(params.KnownSeqUIDs.String()).To(Equal("41:45"))
+	})
+
+	It("should parse the modifiers of UID FETCH", func() {
+		req, err := conn.ParseRequest("a1 UID FETCH 1:* FLAGS (CHANGEDSINCE 12345 VANISHED)")
+		Expect(err).ToNot(HaveOccurred())
+		fetch := req.(types.FetchRequest)
+		Expect(fetch.Attributes).To(HaveLen(1))
+		Expect(fetch.ChangedSince).To(Equal(uint64(12345)))
+		Expect(fetch.Vanished).To(BeTrue())
+	})
+
+	It("should parse the data items of UID FETCH", func() {
+		req, err := conn.ParseRequest("a1 UID FETCH 1:* (FLAGS BODY.PEEK[HEADER.FIELDS (From)]<0.10>)")
+		Expect(err).ToNot(HaveOccurred())
+		fetch := req.(types.FetchRequest)
+		Expect(fetch.Command()).To(Equal("UID FETCH"))
+		Expect(fetch.UIDs).To(Equal(types.UIDSet{{Min: "1", Max: "*"}}))
+		Expect(fetch.Set).To(BeNil())
+		Expect(fetch.Attributes).To(HaveLen(2))
+		body := fetch.Attributes[1]
+		Expect(body.Name).To(Equal("BODY"))
+		Expect(body.Peek).To(BeTrue())
+		Expect(body.Section.Fields).To(Equal([]string{"From"}))
+		Expect(*body.Partial).To(Equal(types.PartialRange{Origin: 0, Count: 10}))
+		Expect(body.String()).To(Equal("BODY.PEEK[HEADER.FIELDS (From)]<0.10>"))
+	})
+
+	It("should parse STORE", func() {
+		req, err := conn.ParseRequest(`a1 STORE 2:4 -FLAGS.SILENT (\Seen $Forwarded)`)
+		Expect(err).ToNot(HaveOccurred())
+		store := req.(types.StoreRequest)
+		Expect(store.Set).To(Equal(types.SequenceSet{{Min: "2", Max: "4"}}))
+		Expect(store.Operation).To(Equal(types.StoreRemove))
+		Expect(store.Silent).To(BeTrue())
+		Expect(store.Flags).To(Equal(types.FlagList{System: types.FlagSeen, Keywords: []string{"$Forwarded"}}))
+		Expect(store.Conditional).To(BeFalse())
+
+		req, err = conn.ParseRequest(`a1 UID STORE 10 (UNCHANGEDSINCE 0) FLAGS (\Seen)`)
+		Expect(err).ToNot(HaveOccurred())
+		store = req.(types.StoreRequest)
+		Expect(store.Conditional).To(BeTrue())
+		Expect(store.UnchangedSince).To(Equal(uint64(0)))
+	})
+
+	It("should parse SEARCH with a charset", func() {
+		req, err := conn.ParseRequest("a1 UID SEARCH CHARSET UTF-8 UNSEEN")
+		Expect(err).ToNot(HaveOccurred())
+		search := req.(types.SearchRequest)
+		Expect(search.Command()).To(Equal("UID SEARCH"))
+		Expect(search.Charset).To(Equal("UTF-8"))
+		Expect(search.Criteria.Children[0].Key).To(Equal("UNSEEN"))
+	})
+
+	It("should parse APPEND", func() {
+		req, err := conn.ParseRequest(`a1 APPEND INBOX (\Seen) "21-Jun-2015 01:00:25 +0900" ~{310+}`)
+		Expect(err).ToNot(HaveOccurred())
+		appendReq := req.(types.AppendRequest)
+		Expect(appendReq.Mailbox).To(Equal("INBOX"))
+		Expect(appendReq.Flags).To(Equal(types.FlagList{System: types.FlagSeen}))
+		Expect(appendReq.Date.Equal(time.Date(2015, 6, 20, 16, 0, 25, 0, time.UTC))).To(BeTrue())
+		Expect(appendReq.Length).To(Equal(uint64(310)))
+		Expect(appendReq.Binary).To(BeTrue())
+		Expect(appendReq.NonSync).To(BeTrue())
+	})
+
+	It("should parse UID REPLACE", func() {
+		req, err := conn.ParseRequest("a1 UID REPLACE 2000 Drafts {312}")
+		Expect(err).ToNot(HaveOccurred())
+		replace := req.(types.ReplaceRequest)
+		Expect(replace.Command()).To(Equal("UID REPLACE"))
+		Expect(replace.Message).To(Equal(uint32(2000)))
+		Expect(replace.Append.Mailbox).To(Equal("Drafts"))
+	})
+
+	It("should parse COPY", func() {
+		req, err := conn.ParseRequest("a1 COPY 2:4 Trash")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(req.(types.CopyRequest).Mailbox).To(Equal("Trash"))
+	})
+
+	It("should report arguments which can't be parsed", func() {
+		_, err := conn.ParseRequest("a1 FETCH 1 (FLAGS")
+		Expect(err).To(HaveOccurred())
+
+		_, err = conn.ParseRequest("a1 UID COPY 1:3 Trash Junk")
+		Expect(err).To(Equal(&conn.SyntaxError{
+			Verb:     "COPY",
+			Token:    "Junk",
+			Position: 22,
+			Expected: "[UID] COPY <sequence set> <mailbox>",
+		}))
+	})
+
+	It("should distinguish commands without request types", func() {
+		_, err := conn.ParseRequest("a1 NOOP")
+		Expect(err).To(Equal(conn.ErrNoRequestType))
+		_, err = conn.ParseRequest("a1 FROBNICATE")
+		Expect(err).To(Equal(conn.ErrUnknownCommand))
+	})
+})
@@ -0,0 +1,162 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// A response code, sent in brackets before the human-readable text of a
+// status response so that clients can act on the result without having to
+// understand the text (RFC 3501 section 7.1)
+type responseCode string
+
+const (
+	codeNone                 responseCode = ""
+	codeAlert                responseCode = "ALERT"
+	codeAlreadyExists        responseCode = "ALREADYEXISTS"
+	codeAuthenticationFailed responseCode = "AUTHENTICATIONFAILED"
+	codeAuthorizationFailed  responseCode = "AUTHORIZATIONFAILED"
+	codeBadCharset           responseCode = "BADCHARSET"
+	codeCannot               responseCode = "CANNOT"
+	codeClosed               responseCode = "CLOSED"
+	codeHasChildren          responseCode = "HASCHILDREN"
+	codeHighestModSeq        responseCode = "HIGHESTMODSEQ"
+	codeInProgress           responseCode = "INPROGRESS"
+	codeLimit                responseCode = "LIMIT"
+	codeModified             responseCode = "MODIFIED"
+	codeNoModSeq             responseCode = "NOMODSEQ"
+	codeNonexistent          responseCode = "NONEXISTENT"
+	codeOverQuota            responseCode = "OVERQUOTA"
+	codePermanentFlags       responseCode = "PERMANENTFLAGS"
+	codeReadOnly             responseCode = "READ-ONLY"
+	codeReadWrite            responseCode = "READ-WRITE"
+	codeReferral             responseCode = "REFERRAL"
+	codeServerBug            responseCode = "SERVERBUG"
+	codeTooBig               responseCode = "TOOBIG"
+	codeTryCreate            responseCode = "TRYCREATE"
+	codeUIDNext              responseCode = "UIDNEXT"
+	codeUIDNotSticky         responseCode = "UIDNOTSTICKY"
+	codeUIDValidity          responseCode = "UIDVALIDITY"
+	codeUnavailable          responseCode = "UNAVAILABLE"
+	codeUnknownCTE           responseCode = "UNKNOWN-CTE"
+	codeUnseen               responseCode = "UNSEEN"
+)
+
+// Give a response code its argument, eg the number in [UIDNEXT 12]
+func (code responseCode) with(arg interface{}) responseCode {
+	return responseCode(fmt.Sprintf("%s %v", code, arg))
+}
+
+// Prefix the text of a status response with the response code, if any
+func (code responseCode) text(text string) string {
+	if code == codeNone {
+		return text
+	}
+	return "[" + string(code) + "] " + text
+}
+
+// Write an OK response, tagged unless the tag is empty
+func (c *Conn) writeOK(tag string, code responseCode, text string) {
+	c.writeResponse(tag, "OK "+code.text(text))
+}
+
+// Write a NO response, tagged unless the tag is empty
+func (c *Conn) writeNo(tag string, code responseCode, text string) {
+	c.writeResponse(tag, "NO "+code.text(text))
+}
+
+// Write a BAD response, tagged unless the tag is empty
+func (c *Conn) writeBad(tag string, code responseCode, text string) {
+	c.writeResponse(tag, "BAD "+code.text(text))
+}
+
+// Find the response code describing an error returned by a mailstore (RFC
+// 5530), so that clients can tell eg a full mailbox from a failed server.
+// Mailstores may wrap the errors they return. A command which ran out of
+// time may succeed if tried again.
+func errorCode(err error) responseCode {
+	switch {
+	case errors.Is(err, mailstore.ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return codeUnavailable
+	case errors.Is(err, mailstore.ErrAuthorizationFailed):
+		return codeAuthorizationFailed
+	case errors.Is(err, mailstore.ErrOverQuota):
+		return codeOverQuota
+	case errors.Is(err, mailstore.ErrLimit):
+		return codeLimit
+	case errors.Is(err, mailstore.ErrMailboxExists):
+		return codeAlreadyExists
+	case errors.Is(err, mailstore.ErrNotSupported):
+		return codeCannot
+	}
+	return codeNone
+}
+
+// Write a NO response describing an error, with the response code for the
+// error if it has one. Errors the server doesn't recognise may carry details
+// of the mailstore's internals, such as paths or queries, so the client is
+// only told that the server failed and the error itself goes to the log.
+func (c *Conn) writeError(tag string, err error) {
+	code := errorCode(err)
+	id, known := errorMessage(err)
+	if !known || englishText[id] != err.Error() {
+		c.logf("Error: %s\n", err)
+	}
+	if !known && code == codeNone {
+		code = codeServerBug
+	}
+	c.writeNo(tag, code, c.text(id))
+}
+
+// Errors which the server knows how to describe to a client, in the order in
+// which they're checked
+var errorMessages = []struct {
+	err error
+	id  MessageID
+}{
+	{context.DeadlineExceeded, MsgCommandTooSlow},
+	{context.Canceled, MsgCommandCancelled},
+	{mailstore.ErrUnavailable, MsgUnavailable},
+	{mailstore.ErrAuthorizationFailed, MsgAuthorizationFailed},
+	{mailstore.ErrOverQuota, MsgOverQuota},
+	{mailstore.ErrLimit, MsgLimit},
+	{mailstore.ErrMailboxExists, MsgMailboxExists},
+	{mailstore.ErrNotSupported, MsgNotSupported},
+	{mailstore.ErrModSeqNotReached, MsgModSeqNotReached},
+	{mailstore.ErrNotSubscribed, MsgNotSubscribed},
+	{mailstore.ErrNoSentMailbox, MsgNoSentMailbox},
+	{mailstore.ErrNotMultipart, MsgNotMultipart},
+	{types.ErrNoSuchPart, MsgNoSuchPart},
+	{types.ErrUnknownTransferEncoding, MsgUnknownTransferEncoding},
+	{errCannotExpunge, MsgCannotExpunge},
+}
+
+// The message of a NO response describing an error, and whether the error
+// is one the server recognises. Recognised errors are described by fixed
+// text, even if the mailstore wrapped them with more detail; anything else
+// is an internal server error.
+func errorMessage(err error) (MessageID, bool) {
+	for _, known := range errorMessages {
+		if errors.Is(err, known.err) {
+			return known.id, true
+		}
+	}
+	return MsgServerBug, false
+}
+
+// Write the NO response to a failed login. Any failure the mailstore hasn't
+// explained is put down to the credentials being wrong, and given the message
+// of the mechanism.
+func (c *Conn) writeLoginFailure(tag string, username string, err error, id MessageID) {
+	c.audit(AuditEvent{Action: "LOGIN FAILED", Username: username})
+	if code := errorCode(err); code != codeNone {
+		id, _ = errorMessage(err)
+		c.writeNo(tag, code, c.text(id))
+		return
+	}
+	c.writeNo(tag, codeAuthenticationFailed, c.text(id))
+}
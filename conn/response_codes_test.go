@@ -0,0 +1,179 @@
+package conn_test
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+// A mailstore which can't currently be reached
+type unavailableMailstore struct {
+	mailstore.Mailstore
+}
+
+func (m unavailableMailstore) Authenticate(username string, password string) (mailstore.User, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+// A user who has used up their storage quota
+type fullUser struct {
+	mailstore.User
+}
+
+func (u fullUser) CreateMailbox(name string) (mailstore.Mailbox, error) {
+	return nil, mailstore.ErrOverQuota
+}
+
+// A user whose mailstore explains which mailbox it couldn't create
+type explainingFullUser struct {
+	mailstore.User
+}
+
+func (u explainingFullUser) CreateMailbox(name string) (mailstore.Mailbox, error) {
+	return nil, fmt.Errorf("Can't create %s: %w", name, mailstore.ErrOverQuota)
+}
+
+// A user whose mailstore fails with an error describing its internals
+type brokenDiskUser struct {
+	mailstore.User
+}
+
+func (u brokenDiskUser) CreateMailbox(name string) (mailstore.Mailbox, error) {
+	return nil, fmt.Errorf("open /var/mail/%s: permission denied", name)
+}
+
+// A user whose mailboxes can't currently be listed
+type unreachableUser struct {
+	mailstore.User
+}
+
+func (u unreachableUser) ListMailboxes() ([]mailstore.Mailbox, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+// A mailbox whose messages can't currently be read
+type unreachableMailbox struct {
+	mailstore.Mailbox
+}
+
+func (m unreachableMailbox) MessageCount() (uint32, error) {
+	return 0, mailstore.ErrUnavailable
+}
+
+func (m unreachableMailbox) LookupMessageBySequenceNumber(seqno uint32) (mailstore.Message, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+func (m unreachableMailbox) LookupMessageByUID(uid uint32) (mailstore.Message, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+func (m unreachableMailbox) LookupMessageSetByUID(set types.UIDSet) ([]mailstore.Message, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+func (m unreachableMailbox) LookupMessageSetBySequenceNumber(set types.SequenceSet) ([]mailstore.Message, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+func (m unreachableMailbox) CreateMessage() (mailstore.Message, error) {
+	return nil, mailstore.ErrUnavailable
+}
+
+var _ = Describe("Error response codes", func() {
+	Context("When logging in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should report wrong credentials as AUTHENTICATIONFAILED", func() {
+			SendLine("abcd.123 LOGIN \"username\" \"wrong\"")
+			ExpectResponse("abcd.123 NO [AUTHENTICATIONFAILED] Incorrect username/password")
+		})
+
+		It("should report an unreachable mailstore as UNAVAILABLE", func() {
+			tConn.Mailstore = unavailableMailstore{mStore}
+			SendLine("abcd.123 LOGIN \"username\" \"password\"")
+			ExpectResponse("abcd.123 NO [UNAVAILABLE] Mail storage is temporarily unavailable")
+		})
+
+		It("should refuse to act as another user with AUTHORIZATIONFAILED", func() {
+			SendLine("abcd.123 AUTHENTICATE PLAIN " +
+				base64.StdEncoding.EncodeToString([]byte("admin\x00username\x00password")))
+			ExpectResponse("abcd.123 NO [AUTHORIZATIONFAILED] Not authorized to log in")
+		})
+	})
+
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should report errors from the mailstore with their codes", func() {
+			tConn.User = fullUser{mStore.User}
+			SendLine("abcd.123 CREATE Archive")
+			ExpectResponse("abcd.123 NO [OVERQUOTA] Mailbox storage quota exceeded")
+		})
+
+		It("should find the codes of wrapped errors", func() {
+			tConn.User = explainingFullUser{mStore.User}
+			SendLine("abcd.123 CREATE Archive")
+			ExpectResponse("abcd.123 NO [OVERQUOTA] Mailbox storage quota exceeded")
+		})
+
+		Context("When the mailstore fails in a way the server doesn't recognise", func() {
+			var transcript *gbytes.Buffer
+
+			BeforeEach(func() {
+				transcript = gbytes.NewBuffer()
+				tConn.Transcript = transcript
+				tConn.User = brokenDiskUser{mStore.User}
+			})
+
+			It("should keep the details from the client but log them", func() {
+				SendLine("abcd.123 CREATE Archive")
+				ExpectResponse("abcd.123 NO [SERVERBUG] Internal server error")
+				Eventually(transcript).Should(gbytes.Say("open /var/mail/Archive: permission denied"))
+			})
+		})
+
+		It("should report mailboxes which can't be listed", func() {
+			tConn.User = unreachableUser{mStore.User}
+			SendLine("abcd.123 LIST \"\" *")
+			ExpectResponse("abcd.123 NO [UNAVAILABLE] Mail storage is temporarily unavailable")
+		})
+
+		It("should report a failure in the server as SERVERBUG", func() {
+			tConn.User = nil
+			SendLine("abcd.123 LIST \"\" *")
+			ExpectResponse("abcd.123 NO [SERVERBUG] Internal server error")
+
+			tConn.User = mStore.User
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+		})
+	})
+
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.SetReadWrite()
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = unreachableMailbox{tConn.User.Mailboxes()[0]}
+		})
+
+		It("should report messages which can't be read", func() {
+			SendLine("abcd.123 UID FETCH 1:* (FLAGS)")
+			ExpectResponse("abcd.123 NO [UNAVAILABLE] Mail storage is temporarily unavailable")
+			SendLine("abcd.124 SEARCH ALL")
+			ExpectResponse("abcd.124 NO [UNAVAILABLE] Mail storage is temporarily unavailable")
+		})
+	})
+})
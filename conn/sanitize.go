@@ -0,0 +1,37 @@
+package conn
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Check a line of command text as it was received, before any literals are
+// put into it. Returns the message saying why the line must be refused, or
+// "" if it's acceptable. RFC 3501 only permits 7-bit text outside literals, but once
+// the client has enabled UTF8=ACCEPT it may send UTF-8 (RFC 6855).
+func (c *Conn) commandTextProblem(line string) MessageID {
+	if strings.IndexByte(line, 0) >= 0 {
+		return MsgCommandNUL
+	}
+	if strings.IndexByte(line, '\r') >= 0 {
+		return MsgCommandBareCR
+	}
+	switch {
+	case isASCII(line):
+		return ""
+	case !c.utf8Enabled:
+		return MsgCommand8Bit
+	case !utf8.ValidString(line):
+		return MsgCommandInvalidUTF8
+	}
+	return ""
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
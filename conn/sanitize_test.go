@@ -0,0 +1,73 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Command text", func() {
+	BeforeEach(func() {
+		tConn.SetState(conn.StateAuthenticated)
+		tConn.User = mStore.User
+	})
+
+	It("should refuse NUL bytes", func() {
+		SendLine("abcd.123 CREATE Arch\x00ive")
+		ExpectResponse("abcd.123 BAD Command contains a NUL byte")
+	})
+
+	It("should refuse bare carriage returns", func() {
+		SendLine("abcd.123 CREATE Arch\rive")
+		ExpectResponse("abcd.123 BAD Command contains a bare CR")
+	})
+
+	It("should refuse 8-bit characters outside literals", func() {
+		SendLine("abcd.123 CREATE \"Entwürfe\"")
+		ExpectResponse("abcd.123 BAD Command contains 8-bit characters, which must be sent as a literal")
+	})
+
+	It("should refuse the rest of a command after a refused line", func() {
+		SendLine("abcd.123 CREATE \"Entwürfe\" {7+}")
+		ExpectResponse("abcd.123 BAD Command contains 8-bit characters, which must be sent as a literal")
+		SendLine("Archive")
+		SendLine("abcd.124 NOOP")
+		ExpectResponse("abcd.124 OK NOOP completed")
+	})
+
+	Context("When UTF8=ACCEPT has been enabled", func() {
+		BeforeEach(func() {
+			tConn.UTF8Accept = true
+		})
+
+		It("should accept UTF-8 mailbox names", func() {
+			SendLine("abcd.123 ENABLE UTF8=ACCEPT")
+			ExpectResponse("* ENABLED UTF8=ACCEPT")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+
+			SendLine("abcd.124 CREATE \"Entwürfe\"")
+			ExpectResponse("abcd.124 OK CREATE completed")
+			SendLine("abcd.125 LIST \"\" Entw*")
+			ExpectResponse("* LIST () \"/\" \"Entwürfe\"")
+			ExpectResponse("abcd.125 OK LIST completed")
+		})
+
+		It("should still refuse invalid UTF-8", func() {
+			SendLine("abcd.123 ENABLE UTF8=ACCEPT")
+			ExpectResponse("* ENABLED UTF8=ACCEPT")
+			ExpectResponse("abcd.123 OK ENABLE completed")
+
+			SendLine("abcd.124 CREATE \"Entw\xfcrfe\"")
+			ExpectResponse("abcd.124 BAD Command contains invalid UTF-8")
+		})
+	})
+
+	It("should accept 8-bit characters in literals", func() {
+		SendLine("abcd.123 CREATE {9}")
+		ExpectResponse("+ Ready for additional command text")
+		SendLine("Entwürfe")
+		ExpectResponse("abcd.123 OK CREATE completed")
+		_, err := mStore.User.MailboxByName("Entwürfe")
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,39 @@
+package conn
+
+import (
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/search"
+)
+
+// Prepare a message in a mailbox to be searched, with its flags and, if the
+// mailbox is selected, its sequence number as this session sees them
+func (c *Conn) searchMessage(mailbox mailstore.Mailbox, m mailstore.Message) *search.Message {
+	seqno := m.SequenceNumber()
+	if c.isSelected(mailbox) {
+		seqno, _ = c.sequenceNumber(m)
+	}
+	msg := &search.Message{
+		UID:            m.UID(),
+		SequenceNumber: seqno,
+		Size:           m.Size(),
+		Flags:          c.sessionFlags(m),
+		Keywords:       m.Keywords(),
+		InternalDate:   m.InternalDate(),
+		Raw:            rawMessage(c.context(), m),
+	}
+
+	if log, ok := changeLog(mailbox); ok {
+		msg.ModSeq, _ = mailstore.MessageModSeq(log, m.UID())
+	}
+
+	// If the mailstore supports save dates but doesn't know this message's,
+	// its internal date is used instead
+	if saved, ok := m.(mailstore.SaveDateMessage); ok {
+		msg.SaveDateSupported = true
+		msg.SaveDate = m.InternalDate()
+		if date, ok := saved.SaveDate(); ok {
+			msg.SaveDate = date
+		}
+	}
+	return msg
+}
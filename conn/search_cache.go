@@ -0,0 +1,96 @@
+package conn
+
+import (
+	"sync/atomic"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// Number of search results remembered by each connection
+const searchCacheSize = 32
+
+// Identifies the results of a search. Results are only reused while the
+// mailbox's highest mod-sequence is unchanged, as any change to its
+// messages or their flags could change them.
+type searchCacheKey struct {
+	mailbox  string
+	criteria string
+	modseq   uint64
+}
+
+// Search a mailbox, reusing the results of an identical search if the
+// mailbox hasn't changed since. Mailboxes without a change log are always
+// searched, as there's no way to tell whether they have changed.
+func (c *Conn) cachedSearch(mailbox mailstore.Mailbox, query string, criteria types.SearchCriteria, progress func(int, int)) ([]mailstore.Message, error) {
+	log, ok := changeLog(mailbox)
+	if !ok {
+		return c.searchMailbox(mailbox, criteria, progress)
+	}
+	modseq, err := log.HighestModSeq()
+	if err != nil {
+		return c.searchMailbox(mailbox, criteria, progress)
+	}
+	key := searchCacheKey{mailbox.Name(), query, modseq}
+
+	c.searchCacheMutex.Lock()
+	results, ok := c.searchCache[key]
+	c.searchCacheMutex.Unlock()
+	if ok {
+		return results, nil
+	}
+
+	results, err = c.searchMailbox(mailbox, criteria, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	c.searchCacheMutex.Lock()
+	defer c.searchCacheMutex.Unlock()
+	if c.searchCache == nil {
+		c.searchCache = make(map[searchCacheKey][]mailstore.Message)
+	}
+	if len(c.searchCacheOrder) >= searchCacheSize {
+		c.evictOldestSearch()
+	}
+
+	// Make room within the memory limit by forgetting the oldest results,
+	// or skip caching if these results would never fit
+	if c.MemoryLimit > 0 {
+		size := searchResultBytes(key, results)
+		if size > c.MemoryLimit {
+			return results, nil
+		}
+		uncached := c.pendingResponseBytes() + atomic.LoadUint64(&c.literalBytes)
+		for len(c.searchCacheOrder) > 0 && uncached+c.searchCacheBytes()+size > c.MemoryLimit {
+			c.evictOldestSearch()
+		}
+	}
+	c.searchCache[key] = results
+	c.searchCacheOrder = append(c.searchCacheOrder, key)
+	return results, nil
+}
+
+// Forget the oldest cached search result. The caller must hold
+// searchCacheMutex.
+func (c *Conn) evictOldestSearch() {
+	delete(c.searchCache, c.searchCacheOrder[0])
+	c.searchCacheOrder = c.searchCacheOrder[1:]
+}
+
+// Forget cached search results for a mailbox which has been changed by
+// another connection
+func (c *Conn) invalidateSearchCache(mailbox string) {
+	c.searchCacheMutex.Lock()
+	defer c.searchCacheMutex.Unlock()
+
+	remaining := c.searchCacheOrder[:0]
+	for _, key := range c.searchCacheOrder {
+		if key.mailbox == mailbox {
+			delete(c.searchCache, key)
+			continue
+		}
+		remaining = append(remaining, key)
+	}
+	c.searchCacheOrder = remaining
+}
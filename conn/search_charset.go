@@ -0,0 +1,61 @@
+package conn
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// Charsets which SEARCH strings may be given in, with functions converting
+// them to UTF-8
+var searchCharsets = map[string]func(string) (string, error){
+	"UTF-8":      utf8SearchString,
+	"US-ASCII":   asciiSearchString,
+	"ISO-8859-1": latin1SearchString,
+}
+
+// The charsets listed in a BADCHARSET response code
+const searchCharsetList = "(UTF-8 US-ASCII ISO-8859-1)"
+
+func utf8SearchString(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("Search string is not valid UTF-8")
+	}
+	return s, nil
+}
+
+func asciiSearchString(s string) (string, error) {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return "", fmt.Errorf("Search string is not valid US-ASCII")
+		}
+	}
+	return s, nil
+}
+
+func latin1SearchString(s string) (string, error) {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes), nil
+}
+
+// Convert the strings in search criteria to UTF-8
+func convertSearchStrings(criteria types.SearchCriteria, convert func(string) (string, error)) (types.SearchCriteria, error) {
+	var err error
+	if criteria.Value != "" {
+		if criteria.Value, err = convert(criteria.Value); err != nil {
+			return criteria, err
+		}
+	}
+	children := make([]types.SearchCriteria, len(criteria.Children))
+	for i, child := range criteria.Children {
+		if children[i], err = convertSearchStrings(child, convert); err != nil {
+			return criteria, err
+		}
+	}
+	criteria.Children = children
+	return criteria, nil
+}
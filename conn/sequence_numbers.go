@@ -0,0 +1,135 @@
+package conn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// The client numbers messages as it last saw the selected mailbox, which
+// differs from the mailbox's own numbering once other sessions expunge
+// messages, until the client has been sent EXPUNGE responses for them. The
+// UIDs in c.view are kept in the client's numbering, and only lose a message
+// when its EXPUNGE response is sent.
+
+// Whether the client's numbering of the selected mailbox is known
+func (c *Conn) viewCurrent() bool {
+	return c.SelectedMailbox != nil && c.view.mailbox == c.SelectedMailbox.Name()
+}
+
+// Whether a mailbox is the selected one, whose messages the client numbers
+// as it last saw them
+func (c *Conn) isSelected(mailbox mailstore.Mailbox) bool {
+	return c.SelectedMailbox != nil && mailbox.Name() == c.SelectedMailbox.Name()
+}
+
+// Find the messages the client means by a set of sequence numbers. Messages
+// which have been expunged but not yet reported to the client are left out.
+func (c *Conn) messagesBySequenceSet(set types.SequenceSet) ([]mailstore.Message, error) {
+	if !c.viewCurrent() {
+		return mailstore.MessageSetBySequenceNumber(c.context(), c.SelectedMailbox, set)
+	}
+
+	// UIDs rise with sequence numbers, so each range of sequence numbers
+	// covers the range of UIDs between its ends
+	last := uint32(len(c.view.uids))
+	var uids types.UIDSet
+	for _, seqRange := range set.Normalize(last) {
+		min, _ := seqRange.Min.Value()
+		max := min
+		if !seqRange.Max.Nil() {
+			max, _ = seqRange.Max.Value()
+		}
+		if min == 0 {
+			min = 1
+		}
+		if min > last || max < min {
+			continue
+		}
+		if max > last {
+			max = last
+		}
+
+		uidRange := types.UIDRange{Min: types.NewUID(c.view.uids[min-1])}
+		if max != min {
+			uidRange.Max = types.NewUID(c.view.uids[max-1])
+		}
+		uids = append(uids, uidRange)
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	return mailstore.MessageSetByUID(c.context(), c.SelectedMailbox, uids)
+}
+
+// Find the message the client means by a single sequence number, or nil if
+// there is none
+func (c *Conn) messageBySequenceNumber(seqno uint32) (mailstore.Message, error) {
+	if !c.viewCurrent() {
+		count, err := mailstore.MessageCount(c.context(), c.SelectedMailbox)
+		if err != nil || seqno == 0 || seqno > count {
+			return nil, err
+		}
+		return mailstore.MessageBySequenceNumber(c.context(), c.SelectedMailbox, seqno)
+	}
+	if seqno == 0 || seqno > uint32(len(c.view.uids)) {
+		return nil, nil
+	}
+	return mailstore.MessageByUID(c.context(), c.SelectedMailbox, c.view.uids[seqno-1])
+}
+
+// Find the sequence number by which the client knows a message in the
+// selected mailbox. A message the client hasn't been told of yet, eg one
+// delivered since the last EXISTS response, has no number the client would
+// understand, so false is returned and no response should mention it.
+func (c *Conn) sequenceNumber(msg mailstore.Message) (uint32, bool) {
+	if !c.viewCurrent() {
+		return msg.SequenceNumber(), true
+	}
+	if i := c.viewIndex(msg.UID()); i != -1 {
+		return uint32(i + 1), true
+	}
+	return 0, false
+}
+
+// Leave out of a list of messages in the selected mailbox any which the
+// client has no sequence number for
+func (c *Conn) knownMessages(msgs []mailstore.Message) []mailstore.Message {
+	known := make([]mailstore.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if _, ok := c.sequenceNumber(msg); ok {
+			known = append(known, msg)
+		}
+	}
+	return known
+}
+
+// The index of a message in the client's view of the selected mailbox, or
+// -1 if the client doesn't know of it
+func (c *Conn) viewIndex(uid uint32) int {
+	uids := c.view.uids
+	i := sort.Search(len(uids), func(i int) bool { return uids[i] >= uid })
+	if i < len(uids) && uids[i] == uid {
+		return i
+	}
+	return -1
+}
+
+// Send the EXPUNGE response for a message, and renumber the messages after
+// it as the client will. Clients which have enabled QRESYNC are sent a
+// VANISHED response with the message's UID instead.
+func (c *Conn) writeExpunge(seqno uint32) {
+	if !c.viewCurrent() || seqno == 0 || seqno > uint32(len(c.view.uids)) {
+		c.writeResponse("", fmt.Sprintf("%d EXPUNGE", seqno))
+		return
+	}
+	if c.qresyncEnabled {
+		c.writeResponse("", fmt.Sprintf("VANISHED %d", c.view.uids[seqno-1]))
+	} else {
+		c.writeResponse("", fmt.Sprintf("%d EXPUNGE", seqno))
+	}
+	delete(c.view.flags, c.view.uids[seqno-1])
+	c.view.uids = append(c.view.uids[:seqno-1], c.view.uids[seqno:]...)
+}
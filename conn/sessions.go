@@ -0,0 +1,40 @@
+package conn
+
+// SessionRegistry keeps track of the mailbox each connection has selected,
+// so that changes made through one connection can be announced to the others
+// with the same mailbox selected (RFC 3501 section 5.2)
+type SessionRegistry interface {
+	// Selected records that the connection has selected the user's mailbox,
+	// or that it has no mailbox selected if the mailbox is empty
+	Selected(c *Conn, username string, mailbox string)
+
+	// Changed tells every other connection with the user's mailbox selected
+	// that its messages have changed
+	Changed(c *Conn, username string, mailbox string)
+}
+
+// Tell the session registry which mailbox, if any, is now selected
+func (c *Conn) registerSelection() {
+	if c.Sessions == nil {
+		return
+	}
+	if c.state == StateSelected && c.SelectedMailbox != nil {
+		c.Sessions.Selected(c, c.username, c.SelectedMailbox.Name())
+	} else {
+		c.Sessions.Selected(c, "", "")
+	}
+}
+
+// Let other sessions with the mailbox selected know that this connection has
+// changed its messages. Changes made within a transaction aren't announced
+// until it is committed.
+func (c *Conn) announceChanges(mailbox string) {
+	if c.transaction != nil {
+		c.transactionChanges[mailbox] = true
+		return
+	}
+	if c.Sessions == nil {
+		return
+	}
+	c.Sessions.Changed(c, c.username, mailbox)
+}
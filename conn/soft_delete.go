@@ -0,0 +1,41 @@
+package conn
+
+import "github.com/jordwest/imap-server/mailstore"
+
+// Prepare a mailbox to be selected, hiding the messages which are flagged
+// \Deleted if the mailbox asks for it
+func openMailbox(m mailstore.Mailbox) mailstore.Mailbox {
+	if soft, ok := m.(mailstore.SoftDeleteMailbox); ok && soft.HideDeleted() {
+		return mailstore.NewSoftDeleteView(m)
+	}
+	return m
+}
+
+// Fetch the selected mailbox again to pick up changes to it, hiding the same
+// messages as before. Within a transaction it's fetched from the transaction.
+func (c *Conn) reloadSelectedMailbox() error {
+	var mailbox mailstore.Mailbox
+	if c.transaction != nil {
+		mailbox = c.transaction.Mailbox()
+	} else {
+		var err error
+		if mailbox, err = mailstore.MailboxByName(c.context(), c.User, c.SelectedMailbox.Name()); err != nil {
+			return err
+		}
+	}
+	if view, ok := c.SelectedMailbox.(mailstore.SoftDeleteView); ok {
+		mailbox = view.Reload(mailbox)
+	}
+	c.SelectedMailbox = mailbox
+	return nil
+}
+
+// The mailbox whose optional interfaces decide what can be done with a
+// selected mailbox. A SoftDeleteView implements them all, passing each
+// through to the mailbox underneath it, which may not.
+func underlyingMailbox(m mailstore.Mailbox) mailstore.Mailbox {
+	if view, ok := m.(mailstore.SoftDeleteView); ok {
+		return view.Underlying()
+	}
+	return m
+}
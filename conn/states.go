@@ -0,0 +1,61 @@
+package conn
+
+// The commands which may be used in each state (RFC 3501 section 6). A
+// command used in any other state is refused before its handler runs, so
+// handlers can rely on the user being logged in or a mailbox being selected.
+var stateCommands = map[connState]map[string]bool{
+	StateNotAuthenticated: commandSet(anyStateCommands, "LOGIN", "AUTHENTICATE"),
+	StateAuthenticated:    commandSet(anyStateCommands, append(authenticatedCommands, "ENABLE")...),
+	StateSelected:         commandSet(anyStateCommands, append(authenticatedCommands, selectedCommands...)...),
+}
+
+// Commands which may be used in any state. The unnamed catch-all command,
+// which explains why a command wasn't understood, is among them.
+var anyStateCommands = []string{"", "CAPABILITY", "NOOP", "LOGOUT", "ID", "LANGUAGE"}
+
+// Commands which may be used once logged in, whether or not a mailbox is
+// selected
+var authenticatedCommands = []string{
+	"SELECT", "EXAMINE", "CREATE", "DELETE", "RENAME", "SUBSCRIBE",
+	"UNSUBSCRIBE", "LIST", "XLIST", "LSUB", "STATUS", "APPEND",
+	"GENURLAUTH", "URLFETCH", "RESETKEY", "ESEARCH",
+}
+
+// Commands which act on the selected mailbox
+var selectedCommands = []string{
+	"CHECK", "CLOSE", "EXPUNGE", "SEARCH", "SORT", "CANCELUPDATE", "FETCH",
+	"STORE", "COPY", "REPLACE", "XBEGIN", "XCOMMIT", "XROLLBACK",
+}
+
+func commandSet(base []string, names ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range append(append([]string(nil), base...), names...) {
+		set[name] = true
+	}
+	return set
+}
+
+// Explain to the client why a command can't be used in the connection's
+// current state
+func (c *Conn) writeStateError(tag string, cmd command) {
+	switch {
+	case stateCommands[StateNotAuthenticated][cmd.name]:
+		c.writeBad(tag, codeNone, c.text(MsgAlreadyAuthenticated))
+	case c.state == StateSelected && stateCommands[StateAuthenticated][cmd.name]:
+		c.writeBad(tag, codeNone, c.text(MsgAlreadySelected))
+	case c.state != StateAuthenticated:
+		c.writeBad(tag, codeNone, c.text(MsgNotAuthenticated))
+	default:
+		c.writeBad(tag, codeNone, c.text(MsgNotSelected))
+	}
+}
+
+// Check that the selected mailbox may be changed, ie that it wasn't opened
+// with EXAMINE
+func (c *Conn) assertWritable(tag string) bool {
+	if c.mailboxWritable != ReadWrite {
+		c.writeNo(tag, codeNone, c.text(MsgReadOnly))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Command states", func() {
+	Context("When not logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateNotAuthenticated)
+		})
+
+		It("should refuse commands which need a user", func() {
+			SendLine("abcd.123 SELECT INBOX")
+			ExpectResponse("abcd.123 BAD not authenticated")
+			SendLine("abcd.124 FETCH 1 FLAGS")
+			ExpectResponse("abcd.124 BAD not authenticated")
+		})
+
+		It("should allow commands valid in any state", func() {
+			SendLine("abcd.123 NOOP")
+			ExpectResponse("abcd.123 OK NOOP completed")
+		})
+	})
+
+	Context("When logged in", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateAuthenticated)
+			tConn.User = mStore.User
+		})
+
+		It("should refuse to log in again", func() {
+			SendLine("abcd.123 LOGIN username password")
+			ExpectResponse("abcd.123 BAD Already authenticated")
+		})
+
+		It("should refuse commands which need a selected mailbox", func() {
+			SendLine("abcd.123 UID FETCH 1:* FLAGS")
+			ExpectResponse("abcd.123 BAD not selected")
+			SendLine("abcd.124 CLOSE")
+			ExpectResponse("abcd.124 BAD not selected")
+		})
+
+		It("should drain the message of a refused REPLACE", func() {
+			SendLine("abcd.123 REPLACE 1 INBOX {7+}")
+			SendLine("Hello")
+			ExpectResponse("abcd.123 BAD not selected")
+			SendLine("abcd.124 NOOP")
+			ExpectResponse("abcd.124 OK NOOP completed")
+			Expect(mStore.User.Mailboxes()[0].Messages()).To(Equal(uint32(3)))
+		})
+	})
+
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+		})
+
+		It("should allow commands for the authenticated state", func() {
+			SendLine("abcd.123 STATUS INBOX (MESSAGES)")
+			ExpectResponse("* STATUS INBOX (MESSAGES 3)")
+			ExpectResponse("abcd.123 OK STATUS completed")
+		})
+
+		It("should refuse changes to a mailbox opened read-only", func() {
+			SendLine("abcd.123 EXPUNGE")
+			ExpectResponse("abcd.123 NO Selected mailbox is READONLY")
+		})
+	})
+})
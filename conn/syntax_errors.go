@@ -0,0 +1,155 @@
+package conn
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned by ParseRequest for a known command whose
+// arguments don't fit its syntax, saying where the command went wrong
+type SyntaxError struct {
+	Verb     string // The command, without any UID prefix
+	Token    string // The first token which doesn't fit, or "" if the command ended too soon
+	Position int    // The byte offset of Token in the command line, counting from the tag
+	Expected string // The command's syntax
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("Invalid arguments for %s: unexpected end of command at position %d", e.Verb, e.Position)
+	}
+	return fmt.Sprintf("Invalid arguments for %s: unexpected %q at position %d", e.Verb, e.Token, e.Position)
+}
+
+// Find where a command line stops fitting the syntax of its command. The
+// command registered for the verb which gets furthest through the line
+// decides where the error is. Nil is returned for unknown commands.
+func syntaxError(line string) *SyntaxError {
+	verb := strings.TrimPrefix(commandVerb(line), "UID ")
+	expected, ok := commandSyntax[verb]
+	if !ok {
+		return nil
+	}
+
+	furthest := -1
+	for _, cmd := range commands {
+		if cmd.name != verb {
+			continue
+		}
+		if n := matchLength(cmd.match, line); n > furthest {
+			furthest = n
+		}
+	}
+	if furthest < 0 {
+		return nil
+	}
+
+	// Report the whole token the match failed in, or the next one if it
+	// failed on the space after a token
+	if furthest < len(line) && line[furthest] == ' ' {
+		furthest++
+	}
+	err := &SyntaxError{Verb: verb, Position: len(line), Expected: expected}
+	if furthest < len(line) {
+		err.Position = strings.LastIndexByte(line[:furthest], ' ') + 1
+		err.Token = line[err.Position:]
+		if end := strings.IndexByte(err.Token, ' '); end >= 0 {
+			err.Token = err.Token[:end]
+		}
+	}
+	return err
+}
+
+// Find how many bytes at the start of s can be read before every way
+// through a command's expression has failed, by stepping through the
+// expression's compiled program one rune at a time
+func matchLength(re *regexp.Regexp, s string) int {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return 0
+	}
+
+	before := rune(-1)
+	threads := followEmpty(prog, []uint32{uint32(prog.Start)}, before, firstRune(s))
+	pos := 0
+	for pos < len(s) {
+		r, size := utf8.DecodeRuneInString(s[pos:])
+		var next []uint32
+		for _, pc := range threads {
+			if consumes(&prog.Inst[pc], r) {
+				next = append(next, prog.Inst[pc].Out)
+			}
+		}
+		next = followEmpty(prog, next, r, firstRune(s[pos+size:]))
+		if len(next) == 0 {
+			return pos
+		}
+		threads = next
+		pos += size
+	}
+	return pos
+}
+
+// Follow the instructions which don't read any input from each of pcs,
+// returning those which do, or which end the match
+func followEmpty(prog *syntax.Prog, pcs []uint32, before, after rune) []uint32 {
+	context := syntax.EmptyOpContext(before, after)
+	seen := make(map[uint32]bool)
+	var threads []uint32
+
+	var follow func(pc uint32)
+	follow = func(pc uint32) {
+		if seen[pc] {
+			return
+		}
+		seen[pc] = true
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			follow(inst.Out)
+			follow(inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			follow(inst.Out)
+		case syntax.InstEmptyWidth:
+			if syntax.EmptyOp(inst.Arg)&^context == 0 {
+				follow(inst.Out)
+			}
+		case syntax.InstFail:
+		default:
+			threads = append(threads, pc)
+		}
+	}
+	for _, pc := range pcs {
+		follow(pc)
+	}
+	return threads
+}
+
+// Whether an instruction reads the rune r
+func consumes(inst *syntax.Inst, r rune) bool {
+	switch inst.Op {
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return r != '\n'
+	case syntax.InstRune, syntax.InstRune1:
+		return inst.MatchRune(r)
+	}
+	return false
+}
+
+// The first rune of s, or -1 at the end of the text
+func firstRune(s string) rune {
+	if s == "" {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
@@ -0,0 +1,28 @@
+package conn
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The characters a tag may contain (RFC 3501 section 9): any printable
+// ASCII except the atom-specials (other than "]") and "+". Neither "*" nor
+// "+" can be a tag, so a tagged response can't be mistaken for an untagged
+// one or a continuation.
+const tagChars = `[!#$&'\x2c-\x5b\x5d-\x7a|}~]`
+
+var tagRE = regexp.MustCompile("^" + tagChars + "+$")
+
+// Split a command line into its tag and the rest of the command. Returns
+// false if the line doesn't start with a valid tag, in which case the
+// client can only be answered with an untagged response.
+func splitTag(req string) (tag string, command string, ok bool) {
+	parts := strings.SplitN(req, " ", 2)
+	if !tagRE.MatchString(parts[0]) {
+		return "", "", false
+	}
+	if len(parts) == 1 {
+		return parts[0], "", true
+	}
+	return parts[0], parts[1], true
+}
@@ -7,7 +7,7 @@ var _ = Describe("Thunderbird mail client", func() {
 		It("should download messages, mark a message as seen and then flagged", func() {
 			ExpectResponse("* OK IMAP4rev1 Service Ready")
 			SendLine("1 capability")
-			ExpectResponse("* CAPABILITY IMAP4rev1 AUTH=PLAIN")
+			ExpectResponse("* CAPABILITY IMAP4rev1 SASL-IR LITERAL- BINARY STATUS=SIZE SAVEDATE REPLACE ID XLIST X-UID-MAP ESEARCH SORT ESORT CONTEXT=SEARCH CONTEXT=SORT SEARCH=FUZZY MULTISEARCH AUTH=PLAIN AUTH=XOAUTH2 AUTH=OAUTHBEARER")
 			ExpectResponse("1 OK CAPABILITY completed")
 			SendLine("2 authenticate plain")
 			ExpectResponse("+")
@@ -17,26 +17,27 @@ var _ = Describe("Thunderbird mail client", func() {
 			SendLine("3 select \"INBOX\"")
 			ExpectResponse("* 3 EXISTS")
 			ExpectResponse("* 3 RECENT")
-			ExpectResponse("* OK [UNSEEN 3]")
-			ExpectResponse("* OK [UIDNEXT 13]")
-			ExpectResponse("* OK [UIDVALIDITY 250]")
+			ExpectResponse("* OK [UNSEEN 1] First unseen message")
+			ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+			ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
 			ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+			ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
 			ExpectResponse("3 OK [READ-WRITE] SELECT completed")
 			SendLine("4 UID fetch 1:* (FLAGS)")
 			ExpectResponse("* 1 FETCH (FLAGS (\\Recent) UID 10)")
 			ExpectResponse("* 2 FETCH (FLAGS (\\Recent) UID 11)")
 			ExpectResponse("* 3 FETCH (FLAGS (\\Recent) UID 12)")
-			ExpectResponse("4 OK UID FETCH Completed")
+			ExpectResponse("4 OK UID FETCH completed")
 			SendLine("5 noop")
-			ExpectResponse("5 OK NOOP Completed")
+			ExpectResponse("5 OK NOOP completed")
 			SendLine("6 UID fetch 13:* (FLAGS)")
-			ExpectResponse("6 OK UID FETCH Completed")
+			ExpectResponse("6 OK UID FETCH completed")
 			SendLine("7 uid store 12 +Flags (\\Seen)")
-			ExpectResponse("* 3 FETCH (FLAGS (\\Seen))")
-			ExpectResponse("7 OK STORE Completed")
+			ExpectResponse("* 3 FETCH (UID 12 FLAGS (\\Seen \\Recent))")
+			ExpectResponse("7 OK STORE completed")
 			SendLine("8 uid store 12 +Flags (\\Flagged)")
-			ExpectResponse("* 3 FETCH (FLAGS (\\Seen \\Flagged))")
-			ExpectResponse("8 OK STORE Completed")
+			ExpectResponse("* 3 FETCH (UID 12 FLAGS (\\Seen \\Recent \\Flagged))")
+			ExpectResponse("8 OK STORE completed")
 		})
 	})
 })
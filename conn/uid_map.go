@@ -0,0 +1,50 @@
+package conn
+
+import (
+	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
+)
+
+// The X-UID-MAP fetch item returns the UIDs of the fetched messages in a
+// single response rather than one FETCH response per message, eg
+//
+//	C: a1 FETCH 1:* (X-UID-MAP)
+//	S: * X-UID-MAP 1:4 10:12,15
+//	S: a1 OK FETCH completed
+//
+// The sequence numbers and UIDs are given as sets, with runs of consecutive
+// numbers compressed into ranges. As UIDs rise with sequence numbers, the
+// nth sequence number in the first set belongs to the nth UID in the
+// second. Messages are numbered as the client last saw the mailbox.
+const uidMapItem = "X-UID-MAP"
+
+// Take any X-UID-MAP item out of the data items of a FETCH command,
+// returning the items left and whether it was there
+func splitUIDMap(attrs []types.FetchAttribute) ([]types.FetchAttribute, bool) {
+	rest := make([]types.FetchAttribute, 0, len(attrs))
+	found := false
+	for _, attr := range attrs {
+		if attr.Name == uidMapItem && attr.Section == nil && attr.Partial == nil && attr.Args == nil {
+			found = true
+			continue
+		}
+		rest = append(rest, attr)
+	}
+	return rest, found
+}
+
+// Send the X-UID-MAP response for fetched messages. Messages the client
+// hasn't been told of are left out, and nothing is sent if none are left.
+func (c *Conn) writeUIDMap(msgs []mailstore.Message) {
+	var seqnos, uids []uint32
+	for _, msg := range msgs {
+		if seqno, known := c.sequenceNumber(msg); known {
+			seqnos = append(seqnos, seqno)
+			uids = append(uids, msg.UID())
+		}
+	}
+	if len(uids) == 0 {
+		return
+	}
+	c.writeResponse("", uidMapItem+" "+types.NewUIDSet(seqnos).String()+" "+types.NewUIDSet(uids).String())
+}
@@ -0,0 +1,74 @@
+package conn_test
+
+import (
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/mailstore"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("X-UID-MAP Fetch Item", func() {
+	Context("When a mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+		})
+
+		It("should return the sequence numbers and UIDs as ranges", func() {
+			SendLine("abcd.123 FETCH 1:* (X-UID-MAP)")
+			ExpectResponse("* X-UID-MAP 1:3 10:12")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should only map the messages asked for", func() {
+			SendLine("abcd.123 UID FETCH 10,12 X-UID-MAP")
+			ExpectResponse("* X-UID-MAP 1,3 10,12")
+			ExpectResponse("abcd.123 OK UID FETCH completed")
+		})
+
+		It("should be returned alongside other data items", func() {
+			SendLine("abcd.123 FETCH 2 (FLAGS X-UID-MAP)")
+			ExpectResponse("* 2 FETCH (FLAGS (\\Recent))")
+			ExpectResponse("* X-UID-MAP 2 11")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+
+		It("should number messages as the client last saw them", func() {
+			SendLine("abcd.122 NOOP")
+			ExpectResponse("abcd.122 OK NOOP completed")
+			tConn.SelectedMailbox.(mailstore.ExpungeMailbox).Expunge([]uint32{10})
+			tConn.SelectedMailbox, _ = mStore.User.MailboxByName("INBOX")
+
+			SendLine("abcd.123 FETCH 1:* (X-UID-MAP)")
+			ExpectResponse("* X-UID-MAP 2:3 11:12")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+	})
+
+	Context("When an empty mailbox is selected", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[1]
+		})
+
+		It("should return nothing", func() {
+			SendLine("abcd.123 FETCH 1:* (X-UID-MAP)")
+			ExpectResponse("abcd.123 OK FETCH completed")
+		})
+	})
+
+	Context("When X-UID-MAP is disabled", func() {
+		BeforeEach(func() {
+			tConn.SetState(conn.StateSelected)
+			tConn.User = mStore.User
+			tConn.SelectedMailbox = tConn.User.Mailboxes()[0]
+			tConn.DisabledCommands = []string{"X-UID-MAP"}
+		})
+
+		It("should refuse the item", func() {
+			SendLine("abcd.123 FETCH 1:* (X-UID-MAP)")
+			ExpectResponse("abcd.123 BAD Unrecognised Parameter")
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package conn_test
+
+import (
+	"io"
+
+	"github.com/jordwest/imap-server/conn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Counts the number of writes made to the underlying connection
+type writeCounter struct {
+	io.ReadWriteCloser
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.ReadWriteCloser.Write(p)
+}
+
+var _ = Describe("Write coalescing", func() {
+	var counter *writeCounter
+
+	BeforeEach(func() {
+		counter = &writeCounter{ReadWriteCloser: mockConn.Server}
+		tConn = conn.NewConn(mStore, counter, GinkgoWriter)
+		tConn.SetState(conn.StateAuthenticated)
+		tConn.User = mStore.User
+	})
+
+	It("should send a burst of untagged responses in a single write", func() {
+		SendLine("abcd.123 SELECT INBOX")
+		ExpectResponse("* 3 EXISTS")
+		ExpectResponse("* 3 RECENT")
+		ExpectResponse("* OK [UNSEEN 1] First unseen message")
+		ExpectResponse("* OK [UIDNEXT 13] Predicted next UID")
+		ExpectResponse("* OK [UIDVALIDITY 250] UIDs valid")
+		ExpectResponse("* FLAGS (\\Answered \\Flagged \\Deleted \\Seen \\Draft)")
+		ExpectResponse("* OK [PERMANENTFLAGS (\\Answered \\Seen \\Deleted \\Draft \\Flagged \\*)] Flags permitted")
+		ExpectResponse("abcd.123 OK [READ-WRITE] SELECT completed")
+		Expect(counter.writes).To(Equal(1))
+	})
+
+	It("should flush before waiting for a literal", func() {
+		SendLine("abcd.123 APPEND INBOX {10}")
+		ExpectResponse("+ go ahead, feed me your message")
+		SendLine("Subject:")
+		ExpectResponse("abcd.123 OK APPEND completed")
+		Expect(counter.writes).To(Equal(2))
+	})
+})
@@ -0,0 +1,146 @@
+package imaptest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseList parses a sequence of space-separated IMAP values, such as the
+// data of a FETCH response. Each value is returned as one of:
+//   - a string, for atoms, quoted strings and literals (with any quoting
+//     removed)
+//   - nil, for NIL
+//   - an []interface{} of values, for parenthesised lists
+//
+// Atoms may contain bracketed sections, so "BODY[HEADER.FIELDS (From)]" is
+// returned as a single string.
+func ParseList(s string) ([]interface{}, error) {
+	p := listParser{s: s}
+	values, err := p.values(false)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type listParser struct {
+	s   string
+	pos int
+}
+
+// Parse values until the end of the string, or the end of the current list
+func (p *listParser) values(inList bool) ([]interface{}, error) {
+	values := make([]interface{}, 0)
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.s) {
+			if inList {
+				return nil, p.errorf("unterminated list")
+			}
+			return values, nil
+		}
+
+		if p.s[p.pos] == ')' {
+			if !inList {
+				return nil, p.errorf("unexpected )")
+			}
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+func (p *listParser) value() (interface{}, error) {
+	switch p.s[p.pos] {
+	case '(':
+		p.pos++
+		return p.values(true)
+	case '"':
+		return p.quoted()
+	case '{', '~':
+		return p.literal()
+	}
+
+	atom := p.atom()
+	if strings.ToUpper(atom) == "NIL" {
+		return nil, nil
+	}
+	return atom, nil
+}
+
+func (p *listParser) quoted() (interface{}, error) {
+	var value strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '\\':
+			p.pos++
+			if p.pos < len(p.s) {
+				value.WriteByte(p.s[p.pos])
+			}
+		case '"':
+			p.pos++
+			return value.String(), nil
+		default:
+			value.WriteByte(p.s[p.pos])
+		}
+	}
+	return nil, p.errorf("unterminated quoted string")
+}
+
+// Parse a literal of the form {n}\r\n followed by n bytes of data
+func (p *listParser) literal() (interface{}, error) {
+	if p.s[p.pos] == '~' {
+		p.pos++
+	}
+	end := strings.Index(p.s[p.pos:], "}\r\n")
+	if p.s[p.pos] != '{' || end < 0 {
+		return nil, p.errorf("malformed literal")
+	}
+	length, err := strconv.Atoi(p.s[p.pos+1 : p.pos+end])
+	if err != nil {
+		return nil, p.errorf("malformed literal length")
+	}
+
+	start := p.pos + end + 3
+	if start+length > len(p.s) {
+		return nil, p.errorf("literal longer than data")
+	}
+	p.pos = start + length
+	return p.s[start:p.pos], nil
+}
+
+// Parse an atom, including any bracketed section within it
+func (p *listParser) atom() string {
+	start := p.pos
+	depth := 0
+	for ; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ' ', '(', ')':
+			if depth == 0 {
+				return p.s[start:p.pos]
+			}
+		}
+	}
+	return p.s[start:]
+}
+
+func (p *listParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *listParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d: %s", ErrMalformedResponse, p.pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,33 @@
+package imaptest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	values, err := ParseList("atom \"quoted \\\"string\\\"\" NIL (nested (list)) ~{3}\r\nabc BODY[1.2]")
+	if err != nil {
+		t.Fatalf("Error parsing list: %s", err)
+	}
+
+	expected := []interface{}{
+		"atom",
+		"quoted \"string\"",
+		nil,
+		[]interface{}{"nested", []interface{}{"list"}},
+		"abc",
+		"BODY[1.2]",
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %#v\ngot %#v", expected, values)
+	}
+}
+
+func TestParseMalformedList(t *testing.T) {
+	for _, s := range []string{"(unterminated", "extra)", "\"unterminated", "{10}\r\nshort"} {
+		if _, err := ParseList(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
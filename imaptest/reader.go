@@ -0,0 +1,72 @@
+package imaptest
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches a literal (or literal8) announced at the end of a line
+var literalRE = regexp.MustCompile("~?{([0-9]+)}$")
+
+// Reader reads responses from an IMAP server connection
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a Reader which reads server responses from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadLine reads a single line of the response, without the line ending
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// ReadResponse reads and parses the next response, including any literals
+// it contains
+func (r *Reader) ReadResponse() (Response, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Continuation requests may end with something resembling a literal,
+	// but are never followed by one
+	for !strings.HasPrefix(line, "+") && literalRE.MatchString(line) {
+		length, _ := strconv.Atoi(literalRE.FindStringSubmatch(line)[1])
+		literal := make([]byte, length)
+		if _, err = io.ReadFull(r.r, literal); err != nil {
+			return Response{}, err
+		}
+		rest, err := r.ReadLine()
+		if err != nil {
+			return Response{}, err
+		}
+		line += "\r\n" + string(literal) + rest
+	}
+
+	return ParseResponse(line)
+}
+
+// ReadTagged reads responses until the one completing the command with the
+// given tag, returning the untagged responses received along the way
+func (r *Reader) ReadTagged(tag string) (untagged []Response, tagged Response, err error) {
+	for {
+		response, err := r.ReadResponse()
+		if err != nil {
+			return untagged, response, err
+		}
+		if response.Tag == tag {
+			return untagged, response, nil
+		}
+		untagged = append(untagged, response)
+	}
+}
@@ -0,0 +1,46 @@
+package imaptest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadTagged(t *testing.T) {
+	r := NewReader(strings.NewReader("" +
+		"* 1 FETCH (BODY[TEXT] {7}\r\nHello\r\n)\r\n" +
+		"* 2 FETCH (UID 11)\r\n" +
+		"abcd.123 OK FETCH Completed\r\n"))
+
+	untagged, tagged, err := r.ReadTagged("abcd.123")
+	if err != nil {
+		t.Fatalf("Error reading responses: %s", err)
+	}
+	if tagged.Status != "OK" {
+		t.Errorf("Expected OK, got %s", tagged.Status)
+	}
+	if len(untagged) != 2 {
+		t.Fatalf("Expected 2 untagged responses, got %d", len(untagged))
+	}
+
+	items, err := untagged[0].FetchItems()
+	if err != nil {
+		t.Fatalf("Error getting fetch items: %s", err)
+	}
+	if items["BODY[TEXT]"] != "Hello\r\n" {
+		t.Errorf("Expected literal body, got %q", items["BODY[TEXT]"])
+	}
+	if untagged[1].Number != 2 {
+		t.Errorf("Expected second response for message 2, got %d", untagged[1].Number)
+	}
+}
+
+func TestReadContinuation(t *testing.T) {
+	r := NewReader(strings.NewReader("+ {5}\r\n"))
+	response, err := r.ReadResponse()
+	if err != nil {
+		t.Fatalf("Error reading continuation: %s", err)
+	}
+	if !response.IsContinuation() {
+		t.Errorf("Expected continuation request, got %+v", response)
+	}
+}
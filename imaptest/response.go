@@ -0,0 +1,199 @@
+// Package imaptest parses the responses sent by an IMAP server into structs,
+// so that tests of the server or of a mailstore can make assertions about
+// the data returned rather than regex-matching raw protocol lines.
+package imaptest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformedResponse indicates a line that could not be parsed as an IMAP
+// server response
+var ErrMalformedResponse = errors.New("Malformed response")
+
+// Response is a single response from the server, with any literals it
+// contained included inline
+type Response struct {
+	// "*" for untagged responses, "+" for continuation requests, otherwise
+	// the tag of the command being completed
+	Tag string
+
+	// OK, NO, BAD, PREAUTH or BYE for status responses, otherwise blank
+	Status string
+
+	// The response code name (eg "TOOBIG") and its arguments (eg "20" for
+	// [APPENDLIMIT 20]), if the status response included one
+	Code     string
+	CodeArgs string
+
+	// Human readable text following the status and response code, or the
+	// text of a continuation request
+	Text string
+
+	// The message sequence number or count preceding the response kind,
+	// eg 4 for "* 4 EXISTS"
+	Number uint32
+
+	// The kind of untagged data response, eg "EXISTS", "FETCH", "LIST"
+	Kind string
+
+	// Everything following the kind in an untagged data response, eg
+	// "(UID 10 FLAGS (\Seen))" for a FETCH response
+	Data string
+
+	// The full response line, excluding the line ending
+	Raw string
+}
+
+// ParseResponse parses a single server response line. Any literals must
+// already be included, as returned by Reader.ReadResponse.
+func ParseResponse(line string) (Response, error) {
+	r := Response{Raw: line}
+
+	tag, rest := splitWord(line)
+	if tag == "" {
+		return r, ErrMalformedResponse
+	}
+	r.Tag = tag
+
+	if tag == "+" {
+		r.Text = rest
+		return r, nil
+	}
+
+	word, afterWord := splitWord(rest)
+	switch strings.ToUpper(word) {
+	case "OK", "NO", "BAD", "PREAUTH", "BYE":
+		r.Status = strings.ToUpper(word)
+		return r, r.parseStatusText(afterWord)
+	}
+
+	if tag != "*" {
+		return r, ErrMalformedResponse
+	}
+
+	// Untagged data, optionally preceded by a number
+	if n, err := strconv.ParseUint(word, 10, 32); err == nil {
+		r.Number = uint32(n)
+		word, afterWord = splitWord(afterWord)
+	}
+	if word == "" {
+		return r, ErrMalformedResponse
+	}
+	r.Kind = strings.ToUpper(word)
+	r.Data = afterWord
+	return r, nil
+}
+
+// Split the response code from the human readable text of a status response
+func (r *Response) parseStatusText(text string) error {
+	if !strings.HasPrefix(text, "[") {
+		r.Text = text
+		return nil
+	}
+
+	end := strings.Index(text, "]")
+	if end < 0 {
+		return ErrMalformedResponse
+	}
+	r.Code, r.CodeArgs = splitWord(text[1:end])
+	r.Code = strings.ToUpper(r.Code)
+	r.Text = strings.TrimPrefix(text[end+1:], " ")
+	return nil
+}
+
+// IsUntagged returns true if this is an untagged response
+func (r Response) IsUntagged() bool { return r.Tag == "*" }
+
+// IsContinuation returns true if this is a continuation request
+func (r Response) IsContinuation() bool { return r.Tag == "+" }
+
+// Capabilities returns the capabilities listed by a CAPABILITY response or
+// a [CAPABILITY ...] response code
+func (r Response) Capabilities() []string {
+	if r.Code == "CAPABILITY" {
+		return strings.Fields(r.CodeArgs)
+	}
+	if r.Kind == "CAPABILITY" {
+		return strings.Fields(r.Data)
+	}
+	return nil
+}
+
+// FetchItems returns the data items of a FETCH response, keyed by their
+// upper case names (eg "FLAGS", "BODY[TEXT]"). Values are as returned by
+// ParseList.
+func (r Response) FetchItems() (map[string]interface{}, error) {
+	if r.Kind != "FETCH" {
+		return nil, fmt.Errorf("Not a FETCH response: %s", r.Kind)
+	}
+	return parsePairs(r.Data)
+}
+
+// StatusItems returns the mailbox name and the items of a STATUS response
+func (r Response) StatusItems() (mailbox string, items map[string]uint64, err error) {
+	if r.Kind != "STATUS" {
+		return "", nil, fmt.Errorf("Not a STATUS response: %s", r.Kind)
+	}
+	fields, err := ParseList(r.Data)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fields) != 2 {
+		return "", nil, ErrMalformedResponse
+	}
+	mailbox, ok := fields[0].(string)
+	list, isList := fields[1].([]interface{})
+	if !ok || !isList || len(list)%2 != 0 {
+		return "", nil, ErrMalformedResponse
+	}
+
+	items = make(map[string]uint64)
+	for i := 0; i < len(list); i += 2 {
+		name, _ := list[i].(string)
+		value, _ := list[i+1].(string)
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return "", nil, ErrMalformedResponse
+		}
+		items[strings.ToUpper(name)] = n
+	}
+	return mailbox, items, nil
+}
+
+// Parse a parenthesised list of alternating names and values into a map
+func parsePairs(data string) (map[string]interface{}, error) {
+	list, err := ParseList(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) != 1 {
+		return nil, ErrMalformedResponse
+	}
+	pairs, ok := list[0].([]interface{})
+	if !ok || len(pairs)%2 != 0 {
+		return nil, ErrMalformedResponse
+	}
+
+	items := make(map[string]interface{})
+	for i := 0; i < len(pairs); i += 2 {
+		name, ok := pairs[i].(string)
+		if !ok {
+			return nil, ErrMalformedResponse
+		}
+		items[strings.ToUpper(name)] = pairs[i+1]
+	}
+	return items, nil
+}
+
+// Split off the first space-separated word of a string
+func splitWord(s string) (word string, rest string) {
+	parts := strings.SplitN(s, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,95 @@
+package imaptest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected Response
+	}{
+		{"abcd.123 OK APPEND completed",
+			Response{Tag: "abcd.123", Status: "OK", Text: "APPEND completed"}},
+		{"abcd.123 NO [TOOBIG] message exceeds APPENDLIMIT",
+			Response{Tag: "abcd.123", Status: "NO", Code: "TOOBIG", Text: "message exceeds APPENDLIMIT"}},
+		{"* OK [UIDVALIDITY 250]",
+			Response{Tag: "*", Status: "OK", Code: "UIDVALIDITY", CodeArgs: "250"}},
+		{"* 4 EXISTS",
+			Response{Tag: "*", Number: 4, Kind: "EXISTS"}},
+		{"* 1 FETCH (UID 10)",
+			Response{Tag: "*", Number: 1, Kind: "FETCH", Data: "(UID 10)"}},
+		{"* LIST () \"/\" \"INBOX\"",
+			Response{Tag: "*", Kind: "LIST", Data: "() \"/\" \"INBOX\""}},
+		{"+ go ahead, feed me your message",
+			Response{Tag: "+", Text: "go ahead, feed me your message"}},
+	}
+
+	for _, test := range tests {
+		response, err := ParseResponse(test.line)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", test.line, err)
+			continue
+		}
+		test.expected.Raw = test.line
+		if !reflect.DeepEqual(response, test.expected) {
+			t.Errorf("Parsing %q\nExpected: %+v\nGot:      %+v", test.line, test.expected, response)
+		}
+	}
+}
+
+func TestParseMalformedResponse(t *testing.T) {
+	for _, line := range []string{"", "abcd.123 EXISTS", "* OK [TOOBIG"} {
+		if _, err := ParseResponse(line); err == nil {
+			t.Errorf("Expected error parsing %q", line)
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	response, _ := ParseResponse("* CAPABILITY IMAP4rev1 AUTH=PLAIN")
+	expected := []string{"IMAP4rev1", "AUTH=PLAIN"}
+	if caps := response.Capabilities(); !reflect.DeepEqual(caps, expected) {
+		t.Errorf("Expected %v, got %v", expected, caps)
+	}
+
+	response, _ = ParseResponse("* OK [CAPABILITY IMAP4rev1 AUTH=PLAIN] Ready")
+	if caps := response.Capabilities(); !reflect.DeepEqual(caps, expected) {
+		t.Errorf("Expected %v, got %v", expected, caps)
+	}
+}
+
+func TestFetchItems(t *testing.T) {
+	response, _ := ParseResponse("* 1 FETCH (UID 10 FLAGS (\\Seen \\Recent) " +
+		"BODY[HEADER.FIELDS (\"From\")] {12}\r\nFrom: me\r\n\r\n INTERNALDATE NIL)")
+	items, err := response.FetchItems()
+	if err != nil {
+		t.Fatalf("Error getting fetch items: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"UID":                            "10",
+		"FLAGS":                          []interface{}{"\\Seen", "\\Recent"},
+		"BODY[HEADER.FIELDS (\"FROM\")]": "From: me\r\n\r\n",
+		"INTERNALDATE":                   nil,
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %#v\ngot %#v", expected, items)
+	}
+}
+
+func TestStatusItems(t *testing.T) {
+	response, _ := ParseResponse("* STATUS \"INBOX\" (MESSAGES 3 UIDNEXT 13)")
+	mailbox, items, err := response.StatusItems()
+	if err != nil {
+		t.Fatalf("Error getting status items: %s", err)
+	}
+	if mailbox != "INBOX" {
+		t.Errorf("Expected INBOX, got %s", mailbox)
+	}
+	expected := map[string]uint64{"MESSAGES": 3, "UIDNEXT": 13}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %v, got %v", expected, items)
+	}
+}
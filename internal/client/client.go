@@ -0,0 +1,299 @@
+// Package client is a minimal IMAP client, used to talk to other IMAP
+// servers and to drive end-to-end tests of this one. It only supports what
+// those uses need and is not intended as a general purpose client library.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jordwest/imap-server/imaptest"
+)
+
+// StatusError is returned when the server completes a command with a NO or
+// BAD response
+type StatusError struct {
+	Response imaptest.Response
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s", e.Response.Status, e.Response.Text)
+}
+
+// MailboxStatus holds the data returned when a mailbox is selected
+type MailboxStatus struct {
+	Exists      uint32
+	Recent      uint32
+	Unseen      uint32
+	UIDNext     uint32
+	UIDValidity uint32
+	ReadOnly    bool
+}
+
+// Message holds the data items returned for a single message by FETCH,
+// keyed by their upper case names (eg "UID", "BODY[TEXT]")
+type Message struct {
+	SequenceNumber uint32
+	Items          map[string]interface{}
+}
+
+// Client is a connection to an IMAP server
+type Client struct {
+	// The greeting sent by the server when the connection was opened
+	Greeting imaptest.Response
+
+	conn       io.ReadWriteCloser
+	reader     *imaptest.Reader
+	tagCount   int
+	writeMutex sync.Mutex
+}
+
+// Dial connects to the IMAP server at the given address
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient creates a client on an existing connection, and waits for the
+// server's greeting
+func NewClient(conn io.ReadWriteCloser) (*Client, error) {
+	c := &Client{
+		conn:   conn,
+		reader: imaptest.NewReader(conn),
+	}
+
+	greeting, err := c.reader.ReadResponse()
+	if err != nil {
+		return nil, err
+	}
+	if greeting.Status != "OK" && greeting.Status != "PREAUTH" {
+		return nil, &StatusError{greeting}
+	}
+	c.Greeting = greeting
+	return c, nil
+}
+
+// Command sends a command and waits for it to complete, returning the
+// untagged responses the server sent while executing it. A *StatusError is
+// returned if the command did not succeed.
+func (c *Client) Command(format string, args ...interface{}) ([]imaptest.Response, error) {
+	tag, err := c.send(format, args...)
+	if err != nil {
+		return nil, err
+	}
+	return c.complete(tag)
+}
+
+// Login authenticates with a username and password
+func (c *Client) Login(username string, password string) error {
+	quotedUsername, err := quote(username)
+	if err != nil {
+		return err
+	}
+	quotedPassword, err := quote(password)
+	if err != nil {
+		return err
+	}
+	_, err = c.Command("LOGIN %s %s", quotedUsername, quotedPassword)
+	return err
+}
+
+// Select opens a mailbox for reading and writing
+func (c *Client) Select(mailbox string) (MailboxStatus, error) {
+	return c.selectMailbox("SELECT", mailbox)
+}
+
+// Examine opens a mailbox for reading only
+func (c *Client) Examine(mailbox string) (MailboxStatus, error) {
+	return c.selectMailbox("EXAMINE", mailbox)
+}
+
+func (c *Client) selectMailbox(command string, mailbox string) (MailboxStatus, error) {
+	quoted, err := quote(mailbox)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	tag, err := c.send("%s %s", command, quoted)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	untagged, tagged, err := c.reader.ReadTagged(tag)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	if tagged.Status != "OK" {
+		return MailboxStatus{}, &StatusError{tagged}
+	}
+
+	status := MailboxStatus{ReadOnly: tagged.Code == "READ-ONLY"}
+	for _, response := range untagged {
+		switch {
+		case response.Kind == "EXISTS":
+			status.Exists = response.Number
+		case response.Kind == "RECENT":
+			status.Recent = response.Number
+		case response.Code == "UNSEEN":
+			status.Unseen = parseUint32(response.CodeArgs)
+		case response.Code == "UIDNEXT":
+			status.UIDNext = parseUint32(response.CodeArgs)
+		case response.Code == "UIDVALIDITY":
+			status.UIDValidity = parseUint32(response.CodeArgs)
+		}
+	}
+	return status, nil
+}
+
+// Fetch retrieves data items (eg "UID FLAGS BODY.PEEK[]") for the messages
+// in a sequence set
+func (c *Client) Fetch(seqSet string, items string) ([]Message, error) {
+	return c.fetch("FETCH", seqSet, items)
+}
+
+// UIDFetch retrieves data items for the messages in a set of UIDs
+func (c *Client) UIDFetch(uidSet string, items string) ([]Message, error) {
+	return c.fetch("UID FETCH", uidSet, items)
+}
+
+func (c *Client) fetch(command string, set string, items string) ([]Message, error) {
+	untagged, err := c.Command("%s %s (%s)", command, set, items)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(untagged))
+	for _, response := range untagged {
+		if response.Kind != "FETCH" {
+			continue
+		}
+		fetched, err := response.FetchItems()
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, Message{response.Number, fetched})
+	}
+	return messages, nil
+}
+
+// ErrIdleNotAccepted is returned by Idle if the server does not allow the
+// client to start idling
+var ErrIdleNotAccepted = errors.New("Server did not accept IDLE")
+
+// Idle waits for the server to report changes to the selected mailbox
+// (RFC 2177), sending each untagged response received to updates, until
+// stop is closed. Responses which arrive once stop is closed are dropped,
+// so the caller may stop reading updates when it closes stop.
+func (c *Client) Idle(stop <-chan struct{}, updates chan<- imaptest.Response) error {
+	tag, err := c.send("IDLE")
+	if err != nil {
+		return err
+	}
+
+	response, err := c.reader.ReadResponse()
+	if err != nil {
+		return err
+	}
+	if !response.IsContinuation() {
+		if response.Tag == tag && response.Status != "OK" {
+			return &StatusError{response}
+		}
+		return ErrIdleNotAccepted
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			c.writeLine("DONE")
+		case <-done:
+		}
+	}()
+
+	for {
+		response, err := c.reader.ReadResponse()
+		if err != nil {
+			return err
+		}
+		if response.Tag == tag {
+			if response.Status != "OK" {
+				return &StatusError{response}
+			}
+			return nil
+		}
+		select {
+		case updates <- response:
+		case <-stop:
+		}
+	}
+}
+
+// Logout ends the session and closes the connection
+func (c *Client) Logout() error {
+	_, err := c.Command("LOGOUT")
+	c.conn.Close()
+	return err
+}
+
+// Close closes the connection without logging out
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Send a command with a new tag, returning the tag
+func (c *Client) send(format string, args ...interface{}) (string, error) {
+	c.tagCount++
+	tag := fmt.Sprintf("a%d", c.tagCount)
+	return tag, c.writeLine(tag + " " + fmt.Sprintf(format, args...))
+}
+
+// Wait for the command with the given tag to complete
+func (c *Client) complete(tag string) ([]imaptest.Response, error) {
+	untagged, tagged, err := c.reader.ReadTagged(tag)
+	if err != nil {
+		return untagged, err
+	}
+	if tagged.Status != "OK" {
+		return untagged, &StatusError{tagged}
+	}
+	return untagged, nil
+}
+
+func (c *Client) writeLine(line string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := io.WriteString(c.conn, line+"\r\n")
+	return err
+}
+
+// ErrUnquotable is returned when a string argument contains a character
+// which can't be sent in a quoted string, and would otherwise end the
+// command early
+var ErrUnquotable = errors.New("Argument contains a CR, LF or NUL character")
+
+// Quote a string argument for sending to the server
+func quote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return "", ErrUnquotable
+	}
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\"", nil
+}
+
+func parseUint32(s string) uint32 {
+	n, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(n)
+}
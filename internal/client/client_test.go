@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jordwest/imap-server"
+	"github.com/jordwest/imap-server/imaptest"
+	"github.com/jordwest/imap-server/mailstore"
+)
+
+func startServer(t *testing.T, addr string) *imap.Server {
+	s := imap.NewServer(mailstore.NewDummyMailstore())
+	s.Addr = addr
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	go s.Serve()
+	return s
+}
+
+func TestSession(t *testing.T) {
+	s := startServer(t, "127.0.0.1:10146")
+	defer s.Close()
+
+	c, err := Dial("127.0.0.1:10146")
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	if err = c.Login("username", "wrong"); err == nil {
+		t.Errorf("Expected login with the wrong password to fail")
+	}
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+
+	status, err := c.Select("INBOX")
+	if err != nil {
+		t.Fatalf("Error selecting INBOX: %s", err)
+	}
+	if status.Exists != 3 || status.UIDNext != 13 || status.ReadOnly {
+		t.Errorf("Unexpected mailbox status %+v", status)
+	}
+
+	messages, err := c.UIDFetch("11:12", "UID BODY.PEEK[TEXT]")
+	if err != nil {
+		t.Fatalf("Error fetching: %s", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	if messages[1].SequenceNumber != 3 || messages[1].Items["UID"] != "12" {
+		t.Errorf("Unexpected message %+v", messages[1])
+	}
+	if messages[1].Items["BODY[TEXT]"] != "Hello\r\n" {
+		t.Errorf("Expected body Hello, got %q", messages[1].Items["BODY[TEXT]"])
+	}
+
+	if err = c.Logout(); err != nil {
+		t.Errorf("Error logging out: %s", err)
+	}
+}
+
+func TestIdleNotSupported(t *testing.T) {
+	s := startServer(t, "127.0.0.1:10147")
+	defer s.Close()
+
+	c, err := Dial("127.0.0.1:10147")
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+
+	err = c.Idle(make(chan struct{}), make(chan imaptest.Response))
+	if _, ok := err.(*StatusError); !ok {
+		t.Errorf("Expected server to reject IDLE, got %v", err)
+	}
+}
+
+func TestUnquotableArguments(t *testing.T) {
+	s := startServer(t, "127.0.0.1:10157")
+	defer s.Close()
+
+	c, err := Dial("127.0.0.1:10157")
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+
+	if err = c.Login("username\r\na2 LOGOUT", "password"); err != ErrUnquotable {
+		t.Errorf("Expected a username with a line break to be refused, got %v", err)
+	}
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+	if _, err = c.Select("INBOX\nx"); err != ErrUnquotable {
+		t.Errorf("Expected a mailbox with a line break to be refused, got %v", err)
+	}
+}
+
+func TestIdleStopsWithoutReader(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	go func() {
+		io.WriteString(server, "* OK ready\r\n")
+		reader := bufio.NewReader(server)
+		reader.ReadString('\n')
+		io.WriteString(server, "+ idling\r\n")
+		io.WriteString(server, "* 1 EXISTS\r\n")
+		io.WriteString(server, "* 2 EXISTS\r\n")
+		reader.ReadString('\n')
+		io.WriteString(server, "a1 OK IDLE terminated\r\n")
+	}()
+
+	c, err := NewClient(conn)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	stop := make(chan struct{})
+	close(stop)
+
+	result := make(chan error)
+	go func() { result <- c.Idle(stop, make(chan imaptest.Response)) }()
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Errorf("Error idling: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Idle blocked on updates nobody was reading")
+	}
+}
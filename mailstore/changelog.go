@@ -0,0 +1,266 @@
+package mailstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// ChangeType identifies the kind of event recorded in a ChangeLog
+type ChangeType int
+
+const (
+	// ChangeAppend records a message being added to the mailbox
+	ChangeAppend ChangeType = iota
+	// ChangeFlags records a message's flags being modified
+	ChangeFlags
+	// ChangeExpunge records a message being permanently removed
+	ChangeExpunge
+)
+
+// Change is a single event in a mailbox's change log
+type Change struct {
+	// The mod-sequence assigned to this change when it was logged
+	ModSeq uint64
+
+	Type ChangeType
+
+	// UID of the message which was changed
+	UID uint32
+
+	// Flags of the message after the change (unused for ChangeExpunge)
+	Flags types.Flags
+
+	// Time the change was logged
+	Time time.Time
+}
+
+// ChangeLog is an append-only log of the changes made to a single mailbox.
+// Every change is assigned a strictly increasing mod-sequence which clients
+// of the log use as a cursor, allowing features such as QRESYNC, NOTIFY and
+// webhooks to be driven from the same source of truth.
+type ChangeLog interface {
+	// Record a change and return the mod-sequence assigned to it. The
+	// ModSeq field of the given change is ignored.
+	Append(change Change) (modseq uint64, err error)
+
+	// Return all changes with a mod-sequence greater than the given cursor,
+	// in the order they were logged
+	Since(modseq uint64) ([]Change, error)
+
+	// The mod-sequence of the most recently logged change, or 0 if no
+	// changes have been logged
+	HighestModSeq() (uint64, error)
+}
+
+// ModSeqIndex is an optional interface which may be implemented by a
+// ChangeLog which can find the mod-sequence of a message without replaying
+// the log, eg from an index kept by UID
+type ModSeqIndex interface {
+	// The mod-sequence of the last change made to the message with the
+	// given UID, not counting its expunge, or 0 if the log has no record of
+	// it
+	MessageModSeq(uid uint32) (uint64, error)
+}
+
+// ChangeLogMailbox is an optional interface which may be implemented by a
+// Mailbox that keeps a ChangeLog
+type ChangeLogMailbox interface {
+	ChangeLog() ChangeLog
+}
+
+// MemoryChangeLog is an in-memory ChangeLog implementation, suitable for
+// testing and for mailstores which don't need the log to persist across
+// restarts. The latest mod-sequence of each message is indexed by UID.
+type MemoryChangeLog struct {
+	mutex   sync.RWMutex
+	changes []Change
+	modseqs map[uint32]uint64
+}
+
+// NewMemoryChangeLog creates a new empty in-memory change log
+func NewMemoryChangeLog() *MemoryChangeLog {
+	return &MemoryChangeLog{changes: make([]Change, 0), modseqs: make(map[uint32]uint64)}
+}
+
+// Append implements the Append method on the ChangeLog interface
+func (l *MemoryChangeLog) Append(change Change) (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	change.ModSeq = uint64(len(l.changes) + 1)
+	if change.Time.IsZero() {
+		change.Time = time.Now()
+	}
+	l.changes = append(l.changes, change)
+	if l.modseqs == nil {
+		l.modseqs = make(map[uint32]uint64)
+	}
+	if change.Type != ChangeExpunge {
+		l.modseqs[change.UID] = change.ModSeq
+	}
+	return change.ModSeq, nil
+}
+
+// Since implements the Since method on the ChangeLog interface
+func (l *MemoryChangeLog) Since(modseq uint64) ([]Change, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	// Mod-sequences are assigned sequentially from 1, so the cursor is
+	// also the index of the first change after it
+	if modseq >= uint64(len(l.changes)) {
+		return []Change{}, nil
+	}
+	changes := make([]Change, uint64(len(l.changes))-modseq)
+	copy(changes, l.changes[modseq:])
+	return changes, nil
+}
+
+// HighestModSeq implements the HighestModSeq method on the ChangeLog interface
+func (l *MemoryChangeLog) HighestModSeq() (uint64, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return uint64(len(l.changes)), nil
+}
+
+// MessageModSeq implements the MessageModSeq method on the ModSeqIndex
+// interface
+func (l *MemoryChangeLog) MessageModSeq(uid uint32) (uint64, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.modseqs[uid], nil
+}
+
+// MessageModSeq finds the mod-sequence of the last change made to the message
+// with the given UID, or 0 if the log has no record of it. Logs which don't
+// index mod-sequences by UID are replayed from the start.
+func MessageModSeq(log ChangeLog, uid uint32) (uint64, error) {
+	if index, ok := log.(ModSeqIndex); ok {
+		return index.MessageModSeq(uid)
+	}
+	changes, err := log.Since(0)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].UID == uid && changes[i].Type != ChangeExpunge {
+			return changes[i].ModSeq, nil
+		}
+	}
+	return 0, nil
+}
+
+// ExpungedSince finds the UIDs of the messages expunged after the given
+// mod-sequence, in the order they were expunged
+func ExpungedSince(log ChangeLog, modseq uint64) ([]uint32, error) {
+	changes, err := log.Since(modseq)
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]uint32, 0)
+	for _, change := range changes {
+		if change.Type == ChangeExpunge {
+			uids = append(uids, change.UID)
+		}
+	}
+	return uids, nil
+}
+
+// MailboxView is a reconstruction of a mailbox's contents as they were at a
+// particular mod-sequence. It is intended for debugging, eg to reproduce
+// exactly what a client would have seen when it reported a sync problem.
+type MailboxView struct {
+	ModSeq uint64
+
+	// Messages in the mailbox at the time, in sequence number order
+	Messages []MailboxViewMessage
+}
+
+// MailboxViewMessage is the state of a single message in a MailboxView
+type MailboxViewMessage struct {
+	UID   uint32
+	Flags types.Flags
+
+	// Mod-sequence of the last change made to this message
+	ModSeq uint64
+}
+
+// ErrModSeqNotReached indicates that a view was requested at a mod-sequence
+// which is higher than any change in the log
+var ErrModSeqNotReached = errors.New("Mod-sequence has not been reached")
+
+// ViewAt replays a change log to reconstruct the mailbox as it was at the
+// given mod-sequence
+func ViewAt(log ChangeLog, modseq uint64) (MailboxView, error) {
+	highest, err := log.HighestModSeq()
+	if err != nil {
+		return MailboxView{}, err
+	}
+	if modseq > highest {
+		return MailboxView{}, ErrModSeqNotReached
+	}
+
+	changes, err := log.Since(0)
+	if err != nil {
+		return MailboxView{}, err
+	}
+
+	view := MailboxView{ModSeq: modseq, Messages: make([]MailboxViewMessage, 0)}
+	for _, change := range changes {
+		if change.ModSeq > modseq {
+			break
+		}
+
+		switch change.Type {
+		case ChangeAppend:
+			view.Messages = append(view.Messages, MailboxViewMessage{
+				UID:    change.UID,
+				Flags:  change.Flags,
+				ModSeq: change.ModSeq,
+			})
+		case ChangeFlags:
+			if index := view.index(change.UID); index != -1 {
+				view.Messages[index].Flags = change.Flags
+				view.Messages[index].ModSeq = change.ModSeq
+			}
+		case ChangeExpunge:
+			if index := view.index(change.UID); index != -1 {
+				view.Messages = append(view.Messages[:index], view.Messages[index+1:]...)
+			}
+		}
+	}
+	return view, nil
+}
+
+// SequenceNumber returns the sequence number the message with the given UID
+// had in this view, or 0 if it was not in the mailbox
+func (v MailboxView) SequenceNumber(uid uint32) uint32 {
+	return uint32(v.index(uid) + 1)
+}
+
+func (v MailboxView) index(uid uint32) int {
+	for index, msg := range v.Messages {
+		if msg.UID == uid {
+			return index
+		}
+	}
+	return -1
+}
+
+// String formats the view as a table for debugging, eg to be written to a
+// log
+func (v MailboxView) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Mailbox at modseq %d\n", v.ModSeq)
+	fmt.Fprintf(&b, "SeqNo  |UID    |ModSeq |Flags\n")
+	fmt.Fprintf(&b, "-------+-------+-------+-------\n")
+	for index, msg := range v.Messages {
+		fmt.Fprintf(&b, "%-7d|%-7d|%-7d|%s\n", index+1, msg.UID, msg.ModSeq, msg.Flags)
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package mailstore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+func TestMemoryChangeLog(t *testing.T) {
+	log := NewMemoryChangeLog()
+	if modseq, _ := log.HighestModSeq(); modseq != 0 {
+		t.Errorf("Expected empty log to have highest modseq 0, got %d", modseq)
+	}
+
+	log.Append(Change{Type: ChangeAppend, UID: 10})
+	log.Append(Change{Type: ChangeFlags, UID: 10, Flags: types.FlagSeen})
+	modseq, _ := log.Append(Change{Type: ChangeExpunge, UID: 10})
+	if modseq != 3 {
+		t.Errorf("Expected third change to have modseq 3, got %d", modseq)
+	}
+
+	changes, _ := log.Since(1)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes since modseq 1, got %d", len(changes))
+	}
+	if changes[0].ModSeq != 2 || changes[0].Type != ChangeFlags {
+		t.Errorf("Expected flag change with modseq 2, got %+v", changes[0])
+	}
+
+	changes, _ = log.Since(3)
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes since the highest modseq, got %d", len(changes))
+	}
+}
+
+func TestDummyMailboxChangeLog(t *testing.T) {
+	inbox := getDefaultInbox(t)
+	log := inbox.ChangeLog()
+	highest, _ := log.HighestModSeq()
+
+	msg := inbox.MessageBySequenceNumber(1)
+	msg = msg.AddFlags(types.FlagSeen)
+	msg.Save()
+
+	// Saving without modifying flags should not be logged
+	msg.Save()
+
+	changes, _ := log.Since(highest)
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change to be logged, got %d", len(changes))
+	}
+	if changes[0].Type != ChangeFlags || changes[0].UID != 10 ||
+		!changes[0].Flags.HasFlags(types.FlagSeen) {
+		t.Errorf("Unexpected change logged: %+v", changes[0])
+	}
+}
+
+func TestViewAt(t *testing.T) {
+	log := NewMemoryChangeLog()
+	log.Append(Change{Type: ChangeAppend, UID: 10})
+	log.Append(Change{Type: ChangeAppend, UID: 11})
+	log.Append(Change{Type: ChangeFlags, UID: 10, Flags: types.FlagSeen})
+	log.Append(Change{Type: ChangeExpunge, UID: 10})
+
+	view, err := ViewAt(log, 3)
+	if err != nil {
+		t.Fatalf("Error getting view: %s", err)
+	}
+	if len(view.Messages) != 2 {
+		t.Fatalf("Expected 2 messages at modseq 3, got %d", len(view.Messages))
+	}
+	if view.Messages[0].Flags != types.FlagSeen || view.Messages[0].ModSeq != 3 {
+		t.Errorf("Expected UID 10 to be seen at modseq 3, got %+v", view.Messages[0])
+	}
+
+	view, _ = ViewAt(log, 4)
+	if view.SequenceNumber(10) != 0 || view.SequenceNumber(11) != 1 {
+		t.Errorf("Expected UID 10 to be expunged and UID 11 to become sequence number 1")
+	}
+	if !strings.Contains(view.String(), "\n1      |11     |2      |") {
+		t.Errorf("Expected the view's table to list UID 11 as sequence number 1, got\n%s", view)
+	}
+
+	view, _ = ViewAt(log, 1)
+	if len(view.Messages) != 1 || view.Messages[0].Flags != 0 {
+		t.Errorf("Expected a single unflagged message at modseq 1, got %+v", view.Messages)
+	}
+
+	if _, err = ViewAt(log, 5); err != ErrModSeqNotReached {
+		t.Errorf("Expected ErrModSeqNotReached, got %v", err)
+	}
+}
+
+// A change log which can only be replayed, hiding the index kept by a
+// MemoryChangeLog
+type unindexedChangeLog struct {
+	ChangeLog
+}
+
+func TestMessageModSeq(t *testing.T) {
+	log := NewMemoryChangeLog()
+	log.Append(Change{Type: ChangeAppend, UID: 10})
+	log.Append(Change{Type: ChangeAppend, UID: 11})
+	log.Append(Change{Type: ChangeFlags, UID: 10, Flags: types.FlagSeen})
+	log.Append(Change{Type: ChangeExpunge, UID: 11})
+
+	for _, l := range []ChangeLog{log, unindexedChangeLog{log}} {
+		if modseq, _ := MessageModSeq(l, 10); modseq != 3 {
+			t.Errorf("Expected UID 10 to have modseq 3, got %d", modseq)
+		}
+		if modseq, _ := MessageModSeq(l, 11); modseq != 2 {
+			t.Errorf("Expected the expunge of UID 11 to be ignored, got modseq %d", modseq)
+		}
+		if modseq, _ := MessageModSeq(l, 12); modseq != 0 {
+			t.Errorf("Expected an unknown UID to have modseq 0, got %d", modseq)
+		}
+	}
+}
+
+func TestExpungedSince(t *testing.T) {
+	log := NewMemoryChangeLog()
+	log.Append(Change{Type: ChangeAppend, UID: 10})
+	log.Append(Change{Type: ChangeExpunge, UID: 10})
+	log.Append(Change{Type: ChangeAppend, UID: 11})
+	log.Append(Change{Type: ChangeExpunge, UID: 11})
+
+	uids, _ := ExpungedSince(log, 2)
+	if len(uids) != 1 || uids[0] != 11 {
+		t.Errorf("Expected only UID 11 to be expunged after modseq 2, got %v", uids)
+	}
+	uids, _ = ExpungedSince(log, 0)
+	if len(uids) != 2 {
+		t.Errorf("Expected both UIDs to be expunged after modseq 0, got %v", uids)
+	}
+}
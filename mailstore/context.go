@@ -0,0 +1,509 @@
+package mailstore
+
+import (
+	"context"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// The Context interfaces below are versions of the Mailstore, User, Mailbox
+// and Message operations, and of the optional interfaces which change
+// mailboxes or search them, which are given the context of the IMAP command
+// they're made for. The context is cancelled when the client disconnects, and
+// may have a deadline if the server limits how long a command can take, so a
+// database- or network-backed store can abandon queries nobody is waiting
+// for. A store may return ctx.Err() to give up, which the client is told of
+// if it's still there to hear it.
+
+// ContextMailstore is an optional interface which may be implemented by a
+// Mailstore to authenticate users within a context
+type ContextMailstore interface {
+	Mailstore
+
+	// Attempt to authenticate a user with given credentials, and return
+	// the user if successful
+	AuthenticateContext(ctx context.Context, username string, password string) (User, error)
+}
+
+// ContextUser is an optional interface which may be implemented by a User to
+// find its mailboxes within a context
+type ContextUser interface {
+	User
+
+	// Return a list of mailboxes belonging to this user
+	MailboxesContext(ctx context.Context) ([]Mailbox, error)
+
+	// Return the mailbox with the given name
+	MailboxByNameContext(ctx context.Context, name string) (Mailbox, error)
+}
+
+// ContextMailbox is an optional interface which may be implemented by a
+// Mailbox to count and read its messages within a context
+type ContextMailbox interface {
+	Mailbox
+
+	// Number of messages in the mailbox
+	MessageCountContext(ctx context.Context) (uint32, error)
+
+	// Get a message by its sequence number, or nil if there is none
+	MessageBySequenceNumberContext(ctx context.Context, seqno uint32) (Message, error)
+
+	// Get a message by its UID, or nil if there is none
+	MessageByUIDContext(ctx context.Context, uid uint32) (Message, error)
+
+	// Get messages that belong to a set of ranges of UIDs
+	MessageSetByUIDContext(ctx context.Context, set types.UIDSet) ([]Message, error)
+
+	// Get messages that belong to a set of ranges of sequence numbers
+	MessageSetBySequenceNumberContext(ctx context.Context, set types.SequenceSet) ([]Message, error)
+
+	// Creates a new (empty) message that belongs to this mailbox, which is
+	// added to the mailbox when it's saved
+	NewMessageContext(ctx context.Context) (Message, error)
+}
+
+// ContextMessage is an optional interface which may be implemented by a
+// Message to change its flags and save it within a context
+type ContextMessage interface {
+	Message
+
+	// Replace the flags for this message and return the updated message.
+	// The change isn't stored until the message is saved.
+	SetFlagsContext(ctx context.Context, flags types.Flags) (Message, error)
+
+	// Save any changes to the message
+	SaveContext(ctx context.Context) (Message, error)
+}
+
+// ContextSubscriptionStore is an optional interface which may be implemented
+// by a SubscriptionStore to read and change subscriptions within a context
+type ContextSubscriptionStore interface {
+	SubscriptionStore
+
+	// Return the names of the mailboxes the user is subscribed to
+	SubscriptionsContext(ctx context.Context) ([]string, error)
+
+	// Add a mailbox to the user's subscriptions
+	SubscribeContext(ctx context.Context, mailbox string) error
+
+	// Remove a mailbox from the user's subscriptions
+	UnsubscribeContext(ctx context.Context, mailbox string) error
+}
+
+// ContextMailboxCreator is an optional interface which may be implemented by
+// a MailboxCreator to create mailboxes within a context
+type ContextMailboxCreator interface {
+	MailboxCreator
+
+	// Create an empty mailbox with the given name, or return
+	// ErrMailboxExists if there is already a mailbox with that name
+	CreateMailboxContext(ctx context.Context, name string) (Mailbox, error)
+}
+
+// ContextMailboxDeleter is an optional interface which may be implemented by
+// a MailboxDeleter to delete mailboxes within a context
+type ContextMailboxDeleter interface {
+	MailboxDeleter
+
+	// Delete the named mailbox, or empty it and keep its name if keepName
+	// is true
+	DeleteMailboxContext(ctx context.Context, name string, keepName bool) error
+}
+
+// ContextMailboxRenamer is an optional interface which may be implemented by
+// a MailboxRenamer to rename mailboxes within a context
+type ContextMailboxRenamer interface {
+	MailboxRenamer
+
+	// Give the mailbox a new name, or return ErrMailboxExists if the new
+	// name is taken
+	RenameMailboxContext(ctx context.Context, oldName string, newName string) error
+}
+
+// ContextRecentMailbox is an optional interface which may be implemented by a
+// RecentMailbox to claim its recent messages within a context
+type ContextRecentMailbox interface {
+	RecentMailbox
+
+	// Clear the \Recent flag from every message which has it, and return
+	// the UIDs of those messages
+	ClaimRecentContext(ctx context.Context) ([]uint32, error)
+}
+
+// ContextSearchableMailbox is an optional interface which may be implemented
+// by a SearchableMailbox to evaluate SEARCH criteria within a context
+type ContextSearchableMailbox interface {
+	SearchableMailbox
+
+	// Returns the UIDs of the messages matching the criteria
+	SearchContext(ctx context.Context, criteria types.SearchCriteria) ([]uint32, error)
+}
+
+// ContextExpungeMailbox is an optional interface which may be implemented by
+// an ExpungeMailbox to remove messages within a context
+type ContextExpungeMailbox interface {
+	ExpungeMailbox
+
+	// Permanently remove the messages with the given UIDs
+	ExpungeContext(ctx context.Context, uids []uint32) error
+}
+
+// ContextTokenAuthenticator is an optional interface which may be
+// implemented by a TokenAuthenticator to check bearer tokens within a
+// context
+type ContextTokenAuthenticator interface {
+	TokenAuthenticator
+
+	// Attempt to authenticate a user with a bearer token and return the
+	// user if successful
+	AuthenticateTokenContext(ctx context.Context, username string, token string) (User, error)
+}
+
+// ContextUserLookup is an optional interface which may be implemented by a
+// UserLookup to find users within a context
+type ContextUserLookup interface {
+	UserLookup
+
+	// Return the user with the given username
+	UserByNameContext(ctx context.Context, username string) (User, error)
+}
+
+// ContextAccessKeyUser is an optional interface which may be implemented by
+// an AccessKeyUser to read and reset its access keys within a context
+type ContextAccessKeyUser interface {
+	AccessKeyUser
+
+	// Return the access key for the named mailbox, generating one if the
+	// mailbox does not have a key yet
+	MailboxAccessKeyContext(ctx context.Context, mailbox string) ([]byte, error)
+
+	// Discard the access key for the named mailbox, or for all mailboxes
+	// if the name is empty
+	ResetMailboxAccessKeysContext(ctx context.Context, mailbox string) error
+}
+
+// ContextQuotaUser is an optional interface which may be implemented by a
+// QuotaUser to measure its storage within a context
+type ContextQuotaUser interface {
+	QuotaUser
+
+	// Return the number of bytes of storage in use and the maximum number
+	// of bytes allowed
+	QuotaUsageContext(ctx context.Context) (used uint64, limit uint64, err error)
+}
+
+// ContextCopyMailbox is an optional interface which may be implemented by a
+// CopyMailbox to copy messages within a context
+type ContextCopyMailbox interface {
+	CopyMailbox
+
+	// Copy the messages with the given UIDs into the destination, keeping
+	// their flags and internal dates
+	CopyMessagesContext(ctx context.Context, uids []uint32, destination Mailbox) error
+}
+
+// ContextCheckpointMailbox is an optional interface which may be
+// implemented by a CheckpointMailbox to write its changes to storage within
+// a context
+type ContextCheckpointMailbox interface {
+	CheckpointMailbox
+
+	// Write any buffered changes to the mailbox to permanent storage
+	CheckpointContext(ctx context.Context) error
+}
+
+// ContextAnnotationMailbox is an optional interface which may be
+// implemented by an AnnotationMailbox to read and change annotations within
+// a context
+type ContextAnnotationMailbox interface {
+	AnnotationMailbox
+
+	// Return the annotations of the message with the given UID
+	AnnotationsContext(ctx context.Context, uid uint32) (map[string]map[string]string, error)
+
+	// Set an attribute of an annotation entry on the message with the
+	// given UID
+	SetAnnotationContext(ctx context.Context, uid uint32, entry string, attribute string, value string) error
+
+	// Remove an attribute of an annotation entry
+	RemoveAnnotationContext(ctx context.Context, uid uint32, entry string, attribute string) error
+}
+
+// ContextTransactionMailbox is an optional interface which may be
+// implemented by a TransactionMailbox to begin transactions within a
+// context
+type ContextTransactionMailbox interface {
+	TransactionMailbox
+
+	// Begin a transaction
+	BeginContext(ctx context.Context) (Transaction, error)
+}
+
+// ContextTransaction is an optional interface which may be implemented by a
+// Transaction to commit or roll back within a context
+type ContextTransaction interface {
+	Transaction
+
+	// Apply all changes made in the transaction
+	CommitContext(ctx context.Context) error
+
+	// Discard all changes made in the transaction
+	RollbackContext(ctx context.Context) error
+}
+
+// ContextReplaceMailbox is an optional interface which may be implemented by
+// a ReplaceMailbox to replace messages within a context
+type ContextReplaceMailbox interface {
+	ReplaceMailbox
+
+	// Save the replacement message and expunge the message with the given
+	// UID from this mailbox
+	ReplaceContext(ctx context.Context, uid uint32, replacement Message) (Message, error)
+}
+
+// ContextRawMessage is an optional interface which may be implemented by a
+// RawMessage to read its stored form within a context
+type ContextRawMessage interface {
+	RawMessage
+
+	// The message's headers and body, exactly as stored
+	RawContext(ctx context.Context) ([]byte, error)
+}
+
+// Authenticate returns the user with the given credentials
+func Authenticate(ctx context.Context, m Mailstore, username string, password string) (User, error) {
+	if mc, ok := m.(ContextMailstore); ok {
+		return mc.AuthenticateContext(ctx, username, password)
+	}
+	return m.Authenticate(username, password)
+}
+
+// MailboxByName returns the user's mailbox with the given name
+func MailboxByName(ctx context.Context, u User, name string) (Mailbox, error) {
+	if uc, ok := u.(ContextUser); ok {
+		return uc.MailboxByNameContext(ctx, name)
+	}
+	return u.MailboxByName(name)
+}
+
+// SaveMessage saves any changes to the message, and returns the saved
+// message
+func SaveMessage(ctx context.Context, m Message) (Message, error) {
+	if mc, ok := m.(ContextMessage); ok {
+		return mc.SaveContext(ctx)
+	}
+	return m.Save()
+}
+
+// Subscriptions returns the names of the mailboxes the user is subscribed to
+func Subscriptions(ctx context.Context, s SubscriptionStore) ([]string, error) {
+	if sc, ok := s.(ContextSubscriptionStore); ok {
+		return sc.SubscriptionsContext(ctx)
+	}
+	return s.Subscriptions()
+}
+
+// Subscribe adds a mailbox to the user's subscriptions
+func Subscribe(ctx context.Context, s SubscriptionStore, mailbox string) error {
+	if sc, ok := s.(ContextSubscriptionStore); ok {
+		return sc.SubscribeContext(ctx, mailbox)
+	}
+	return s.Subscribe(mailbox)
+}
+
+// Unsubscribe removes a mailbox from the user's subscriptions
+func Unsubscribe(ctx context.Context, s SubscriptionStore, mailbox string) error {
+	if sc, ok := s.(ContextSubscriptionStore); ok {
+		return sc.UnsubscribeContext(ctx, mailbox)
+	}
+	return s.Unsubscribe(mailbox)
+}
+
+// CreateMailbox creates an empty mailbox with the given name
+func CreateMailbox(ctx context.Context, c MailboxCreator, name string) (Mailbox, error) {
+	if cc, ok := c.(ContextMailboxCreator); ok {
+		return cc.CreateMailboxContext(ctx, name)
+	}
+	return c.CreateMailbox(name)
+}
+
+// DeleteMailbox deletes the named mailbox, or empties it if keepName is true
+func DeleteMailbox(ctx context.Context, d MailboxDeleter, name string, keepName bool) error {
+	if dc, ok := d.(ContextMailboxDeleter); ok {
+		return dc.DeleteMailboxContext(ctx, name, keepName)
+	}
+	return d.DeleteMailbox(name, keepName)
+}
+
+// RenameMailbox gives the mailbox a new name
+func RenameMailbox(ctx context.Context, r MailboxRenamer, oldName string, newName string) error {
+	if rc, ok := r.(ContextMailboxRenamer); ok {
+		return rc.RenameMailboxContext(ctx, oldName, newName)
+	}
+	return r.RenameMailbox(oldName, newName)
+}
+
+// ClaimRecent clears the \Recent flag from the mailbox's messages, and
+// returns the UIDs of those which had it
+func ClaimRecent(ctx context.Context, r RecentMailbox) ([]uint32, error) {
+	if rc, ok := r.(ContextRecentMailbox); ok {
+		return rc.ClaimRecentContext(ctx)
+	}
+	return r.ClaimRecent()
+}
+
+// Search returns the UIDs of the mailbox's messages which match the criteria
+func Search(ctx context.Context, s SearchableMailbox, criteria types.SearchCriteria) ([]uint32, error) {
+	if sc, ok := s.(ContextSearchableMailbox); ok {
+		return sc.SearchContext(ctx, criteria)
+	}
+	return s.Search(criteria)
+}
+
+// Expunge permanently removes the messages with the given UIDs
+func Expunge(ctx context.Context, e ExpungeMailbox, uids []uint32) error {
+	if ec, ok := e.(ContextExpungeMailbox); ok {
+		return ec.ExpungeContext(ctx, uids)
+	}
+	return e.Expunge(uids)
+}
+
+// AuthenticateToken returns the user identified by a bearer token
+func AuthenticateToken(ctx context.Context, a TokenAuthenticator, username string, token string) (User, error) {
+	if ac, ok := a.(ContextTokenAuthenticator); ok {
+		return ac.AuthenticateTokenContext(ctx, username, token)
+	}
+	return a.AuthenticateToken(username, token)
+}
+
+// UserByName returns the user with the given username
+func UserByName(ctx context.Context, l UserLookup, username string) (User, error) {
+	if lc, ok := l.(ContextUserLookup); ok {
+		return lc.UserByNameContext(ctx, username)
+	}
+	return l.UserByName(username)
+}
+
+// MailboxAccessKey returns the access key for the named mailbox
+func MailboxAccessKey(ctx context.Context, u AccessKeyUser, mailbox string) ([]byte, error) {
+	if uc, ok := u.(ContextAccessKeyUser); ok {
+		return uc.MailboxAccessKeyContext(ctx, mailbox)
+	}
+	return u.MailboxAccessKey(mailbox)
+}
+
+// ResetMailboxAccessKeys discards the access key for the named mailbox, or
+// for all mailboxes if the name is empty
+func ResetMailboxAccessKeys(ctx context.Context, u AccessKeyUser, mailbox string) error {
+	if uc, ok := u.(ContextAccessKeyUser); ok {
+		return uc.ResetMailboxAccessKeysContext(ctx, mailbox)
+	}
+	return u.ResetMailboxAccessKeys(mailbox)
+}
+
+// QuotaUsage returns the user's storage in use and their limit
+func QuotaUsage(ctx context.Context, u QuotaUser) (used uint64, limit uint64, err error) {
+	if uc, ok := u.(ContextQuotaUser); ok {
+		return uc.QuotaUsageContext(ctx)
+	}
+	return u.QuotaUsage()
+}
+
+// CopyMessages copies the messages with the given UIDs into the destination
+func CopyMessages(ctx context.Context, c CopyMailbox, uids []uint32, destination Mailbox) error {
+	if cc, ok := c.(ContextCopyMailbox); ok {
+		return cc.CopyMessagesContext(ctx, uids, destination)
+	}
+	return c.CopyMessages(uids, destination)
+}
+
+// Checkpoint writes any buffered changes to the mailbox to storage
+func Checkpoint(ctx context.Context, c CheckpointMailbox) error {
+	if cc, ok := c.(ContextCheckpointMailbox); ok {
+		return cc.CheckpointContext(ctx)
+	}
+	return c.Checkpoint()
+}
+
+// Annotations returns the annotations of the message with the given UID
+func Annotations(ctx context.Context, a AnnotationMailbox, uid uint32) (map[string]map[string]string, error) {
+	if ac, ok := a.(ContextAnnotationMailbox); ok {
+		return ac.AnnotationsContext(ctx, uid)
+	}
+	return a.Annotations(uid)
+}
+
+// SetAnnotation sets an attribute of an annotation entry on a message
+func SetAnnotation(ctx context.Context, a AnnotationMailbox, uid uint32, entry string, attribute string, value string) error {
+	if ac, ok := a.(ContextAnnotationMailbox); ok {
+		return ac.SetAnnotationContext(ctx, uid, entry, attribute, value)
+	}
+	return a.SetAnnotation(uid, entry, attribute, value)
+}
+
+// RemoveAnnotation removes an attribute of an annotation entry on a message
+func RemoveAnnotation(ctx context.Context, a AnnotationMailbox, uid uint32, entry string, attribute string) error {
+	if ac, ok := a.(ContextAnnotationMailbox); ok {
+		return ac.RemoveAnnotationContext(ctx, uid, entry, attribute)
+	}
+	return a.RemoveAnnotation(uid, entry, attribute)
+}
+
+// Begin begins a transaction on the mailbox
+func Begin(ctx context.Context, t TransactionMailbox) (Transaction, error) {
+	if tc, ok := t.(ContextTransactionMailbox); ok {
+		return tc.BeginContext(ctx)
+	}
+	return t.Begin()
+}
+
+// Commit applies all changes made in a transaction
+func Commit(ctx context.Context, tx Transaction) error {
+	if tc, ok := tx.(ContextTransaction); ok {
+		return tc.CommitContext(ctx)
+	}
+	return tx.Commit()
+}
+
+// Rollback discards all changes made in a transaction
+func Rollback(ctx context.Context, tx Transaction) error {
+	if tc, ok := tx.(ContextTransaction); ok {
+		return tc.RollbackContext(ctx)
+	}
+	return tx.Rollback()
+}
+
+// Replace saves the replacement message and expunges the message with the
+// given UID from the mailbox
+func Replace(ctx context.Context, r ReplaceMailbox, uid uint32, replacement Message) (Message, error) {
+	if rc, ok := r.(ContextReplaceMailbox); ok {
+		return rc.ReplaceContext(ctx, uid, replacement)
+	}
+	return r.Replace(uid, replacement)
+}
+
+// Raw returns the message's headers and body exactly as stored
+func Raw(ctx context.Context, m RawMessage) ([]byte, error) {
+	if mc, ok := m.(ContextRawMessage); ok {
+		return mc.RawContext(ctx)
+	}
+	return m.Raw()
+}
+
+// The key under which a context carries its correlation ID
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of the context carrying the correlation
+// ID of the command it was made for. The server's transcripts, metrics and
+// audit hooks identify the command by the same ID, so a store's own logs can
+// be matched up with them.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by the context, or "" if
+// it has none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
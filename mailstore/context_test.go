@@ -0,0 +1,101 @@
+package mailstore
+
+import (
+	"context"
+	"testing"
+)
+
+// A user whose mailboxes are only found within a context which hasn't
+// ended
+type contextUser struct {
+	DummyUser
+}
+
+func (u contextUser) MailboxesContext(ctx context.Context) ([]Mailbox, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return u.Mailboxes(), nil
+}
+
+func (u contextUser) MailboxByNameContext(ctx context.Context, name string) (Mailbox, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return u.MailboxByName(name)
+}
+
+func (u contextUser) CreateMailboxContext(ctx context.Context, name string) (Mailbox, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return u.CreateMailbox(name)
+}
+
+func TestContextUser(t *testing.T) {
+	m := NewDummyMailstore()
+	user := contextUser{m.User}
+	if mailbox, err := MailboxByName(context.Background(), user, "INBOX"); err != nil || mailbox.Name() != "INBOX" {
+		t.Errorf("Expected to find INBOX, got %v\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := MailboxByName(ctx, user, "INBOX"); err != context.Canceled {
+		t.Errorf("Expected the cancelled context's error, got %v\n", err)
+	}
+	if _, err := Inferiors(ctx, user, "INBOX", "/"); err != context.Canceled {
+		t.Errorf("Expected the cancelled context's error listing mailboxes, got %v\n", err)
+	}
+	if _, err := MailboxByName(ctx, m.User, "INBOX"); err != nil {
+		t.Errorf("Expected a User without contexts to ignore the context, got %v\n", err)
+	}
+}
+
+func TestCreateMailboxContext(t *testing.T) {
+	m := NewDummyMailstore()
+	user := contextUser{m.User}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CreateMailbox(ctx, user, "Archive"); err != context.Canceled {
+		t.Errorf("Expected the cancelled context's error, got %v\n", err)
+	}
+	if _, err := m.User.MailboxByName("Archive"); err == nil {
+		t.Errorf("Expected the mailbox not to be created")
+	}
+	if _, err := CreateMailbox(ctx, m.User, "Archive"); err != nil {
+		t.Errorf("Expected a User without contexts to ignore the context, got %v\n", err)
+	}
+}
+
+// A mailbox whose transactions are only begun within a context which hasn't
+// ended
+type contextTransactionMailbox struct {
+	DummyMailbox
+}
+
+func (m contextTransactionMailbox) BeginContext(ctx context.Context) (Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Begin()
+}
+
+func TestBeginContext(t *testing.T) {
+	m := NewDummyMailstore()
+	inbox, _ := m.User.MailboxByName("INBOX")
+	mailbox := contextTransactionMailbox{inbox.(DummyMailbox)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Begin(ctx, mailbox); err != context.Canceled {
+		t.Errorf("Expected the cancelled context's error, got %v\n", err)
+	}
+	tx, err := Begin(ctx, inbox.(TransactionMailbox))
+	if err != nil {
+		t.Fatalf("Expected a Mailbox without contexts to ignore the context, got %v\n", err)
+	}
+	if err := Rollback(ctx, tx); err != nil {
+		t.Errorf("Expected a Transaction without contexts to ignore the context, got %v\n", err)
+	}
+}
@@ -1,9 +1,11 @@
 package mailstore
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net/textproto"
+	"sort"
 	"time"
 
 	"github.com/jordwest/imap-server/types"
@@ -13,14 +15,18 @@ import (
 // DummyMailstore is an in-memory mail storage for testing purposes and to
 // provide an example implementation of a mailstore
 type DummyMailstore struct {
-	User DummyUser
+	User      DummyUser
+	Delimiter string
 }
 
 func newDummyMailbox(name string) DummyMailbox {
 	return DummyMailbox{
-		name:     name,
-		messages: make([]Message, 0),
-		nextuid:  10,
+		name:      name,
+		messages:  make([]Message, 0),
+		nextuid:   10,
+		changeLog: NewMemoryChangeLog(),
+
+		annotations: make(map[uint32]map[string]map[string]string),
 	}
 }
 
@@ -28,9 +34,12 @@ func newDummyMailbox(name string) DummyMailbox {
 // used to create a new DummyMailstore
 func NewDummyMailstore() DummyMailstore {
 	ms := DummyMailstore{
+		Delimiter: DefaultDelimiter,
 		User: DummyUser{
 			authenticated: false,
-			mailboxes:     make([]DummyMailbox, 2),
+			mailboxes:     make([]DummyMailbox, 3),
+			accessKeys:    make(map[string][]byte),
+			subscriptions: map[string]bool{"INBOX": true, "Trash": true, "Sent": true},
 		},
 	}
 	ms.User.mailstore = &ms
@@ -51,9 +60,20 @@ func NewDummyMailstore() DummyMailstore {
 	ms.User.mailboxes[1] = newDummyMailbox("Trash")
 	ms.User.mailboxes[1].ID = 1
 	ms.User.mailboxes[1].mailstore = &ms
+
+	ms.User.mailboxes[2] = newDummyMailbox("Sent")
+	ms.User.mailboxes[2].ID = 2
+	ms.User.mailboxes[2].mailstore = &ms
+	ms.User.mailboxes[2].specialUse = `\Sent`
 	return ms
 }
 
+// HierarchyDelimiter implements the HierarchyDelimiter method on the
+// HierarchyMailstore interface
+func (d DummyMailstore) HierarchyDelimiter() string {
+	return d.Delimiter
+}
+
 // Authenticate implements the Authenticate method on the Mailstore interface
 func (d DummyMailstore) Authenticate(username string, password string) (User, error) {
 	if username != "username" {
@@ -68,19 +88,74 @@ func (d DummyMailstore) Authenticate(username string, password string) (User, er
 	return d.User, nil
 }
 
+// AuthenticateToken implements the AuthenticateToken method on the
+// TokenAuthenticator interface
+func (d DummyMailstore) AuthenticateToken(username string, token string) (User, error) {
+	if username != "" && username != "username" {
+		return DummyUser{}, errors.New("Invalid username. Use 'username'")
+	}
+
+	if token != "token" {
+		return DummyUser{}, errors.New("Invalid token. Use 'token'")
+	}
+
+	d.User.authenticated = true
+	return d.User, nil
+}
+
+// UserByName implements the UserByName method on the UserLookup interface
+func (d DummyMailstore) UserByName(username string) (User, error) {
+	if username != "username" {
+		return DummyUser{}, errors.New("Invalid username. Use 'username'")
+	}
+	return d.User, nil
+}
+
 // DummyUser is an in-memory representation of a mailstore's user
 type DummyUser struct {
 	authenticated bool
 	mailboxes     []DummyMailbox
+	accessKeys    map[string][]byte
+	subscriptions map[string]bool
 	mailstore     *DummyMailstore
+
+	// QuotaLimit is the storage quota in bytes, or 0 for no quota
+	QuotaLimit uint64
+
+	// FeatureFlags are the per-user feature toggles returned by Features
+	FeatureFlags map[string]bool
+
+	// SoftDeleteMailboxes names the mailboxes in which messages flagged
+	// \Deleted are hidden
+	SoftDeleteMailboxes []string
+}
+
+// Return the user's mailboxes, including any created since this copy of
+// the user was made
+func (u DummyUser) allMailboxes() []DummyMailbox {
+	all := u.mailboxes
+	if u.mailstore != nil {
+		all = u.mailstore.User.mailboxes
+	}
+
+	// Deleted mailboxes keep their slot so that the IDs of the others, which
+	// index the slice, don't change
+	mailboxes := make([]DummyMailbox, 0, len(all))
+	for _, mailbox := range all {
+		if !mailbox.deleted {
+			mailboxes = append(mailboxes, mailbox)
+		}
+	}
+	return mailboxes
 }
 
 // Mailboxes implements the Mailboxes method on the User interface
 func (u DummyUser) Mailboxes() []Mailbox {
-	mailboxes := make([]Mailbox, len(u.mailboxes))
+	all := u.allMailboxes()
+	mailboxes := make([]Mailbox, len(all))
 	index := 0
-	for _, element := range u.mailboxes {
-		mailboxes[index] = element
+	for _, element := range all {
+		mailboxes[index] = u.withSettings(element)
 		index++
 	}
 	return mailboxes
@@ -88,23 +163,202 @@ func (u DummyUser) Mailboxes() []Mailbox {
 
 // MailboxByName returns a DummyMailbox object, given the mailbox's name
 func (u DummyUser) MailboxByName(name string) (Mailbox, error) {
-	for _, mailbox := range u.mailboxes {
-		if mailbox.Name() == name {
-			return mailbox, nil
+	for _, mailbox := range u.allMailboxes() {
+		if util.MailboxNamesEqual(mailbox.Name(), name) {
+			return u.withSettings(mailbox), nil
 		}
 	}
 	return DummyMailbox{}, errors.New("Invalid mailbox")
 }
 
+// CreateMailbox implements the CreateMailbox method on the MailboxCreator
+// interface
+func (u DummyUser) CreateMailbox(name string) (Mailbox, error) {
+	name = util.NormalizeMailboxName(name)
+	if _, err := u.MailboxByName(name); err == nil {
+		return nil, ErrMailboxExists
+	}
+
+	user := &u.mailstore.User
+	mailbox := newDummyMailbox(name)
+	mailbox.ID = uint32(len(user.mailboxes))
+	mailbox.mailstore = u.mailstore
+	user.mailboxes = append(user.mailboxes, mailbox)
+	return mailbox, nil
+}
+
+// DeleteMailbox implements the DeleteMailbox method on the MailboxDeleter
+// interface
+func (u DummyUser) DeleteMailbox(name string, keepName bool) error {
+	found, err := u.MailboxByName(name)
+	if err != nil {
+		return err
+	}
+
+	mailbox := &u.mailstore.User.mailboxes[found.(DummyMailbox).ID]
+	mailbox.messages = make([]Message, 0)
+	if keepName {
+		mailbox.noselect = true
+	} else {
+		mailbox.deleted = true
+	}
+	return nil
+}
+
+// RenameMailbox implements the RenameMailbox method on the MailboxRenamer
+// interface
+func (u DummyUser) RenameMailbox(oldName string, newName string) error {
+	found, err := u.MailboxByName(oldName)
+	if err != nil {
+		return err
+	}
+	newName = util.NormalizeMailboxName(newName)
+	if _, err := u.MailboxByName(newName); err == nil {
+		return ErrMailboxExists
+	}
+
+	user := &u.mailstore.User
+	mailbox := &user.mailboxes[found.(DummyMailbox).ID]
+	if !util.MailboxNamesEqual(mailbox.name, "INBOX") {
+		mailbox.name = newName
+		return nil
+	}
+
+	moved := newDummyMailbox(newName)
+	moved.ID = uint32(len(user.mailboxes))
+	moved.mailstore = u.mailstore
+	moved.nextuid = mailbox.nextuid
+	moved.annotations = mailbox.annotations
+	for _, message := range mailbox.messages {
+		message := message.(DummyMessage)
+		message.mailboxID = moved.ID
+		moved.messages = append(moved.messages, message)
+		moved.changeLog.Append(Change{Type: ChangeAppend, UID: message.uid, Flags: message.flags})
+	}
+
+	for _, message := range mailbox.messages {
+		mailbox.changeLog.Append(Change{Type: ChangeExpunge, UID: message.UID()})
+	}
+	mailbox.messages = make([]Message, 0)
+	mailbox.annotations = make(map[uint32]map[string]map[string]string)
+	user.mailboxes = append(user.mailboxes, moved)
+	return nil
+}
+
+// Apply the user's settings to one of their mailboxes
+func (u DummyUser) withSettings(mailbox DummyMailbox) DummyMailbox {
+	for _, name := range u.SoftDeleteMailboxes {
+		if util.MailboxNamesEqual(mailbox.Name(), name) {
+			mailbox.hideDeleted = true
+		}
+	}
+	return mailbox
+}
+
+// QuotaUsage implements the QuotaUsage method on the QuotaUser interface
+func (u DummyUser) QuotaUsage() (used uint64, limit uint64, err error) {
+	for _, mailbox := range u.allMailboxes() {
+		for _, message := range mailbox.messages {
+			used += uint64(message.Size())
+		}
+	}
+	return used, u.QuotaLimit, nil
+}
+
+// Features implements the Features method on the FeatureUser interface
+func (u DummyUser) Features() map[string]bool {
+	return u.FeatureFlags
+}
+
+// Subscriptions implements the Subscriptions method on the
+// SubscriptionStore interface
+func (u DummyUser) Subscriptions() ([]string, error) {
+	names := make([]string, 0, len(u.subscriptions))
+	for name := range u.subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Subscribe implements the Subscribe method on the SubscriptionStore
+// interface
+func (u DummyUser) Subscribe(mailbox string) error {
+	u.subscriptions[util.NormalizeMailboxName(mailbox)] = true
+	return nil
+}
+
+// Unsubscribe implements the Unsubscribe method on the SubscriptionStore
+// interface
+func (u DummyUser) Unsubscribe(mailbox string) error {
+	mailbox = util.NormalizeMailboxName(mailbox)
+	if !u.subscriptions[mailbox] {
+		return ErrNotSubscribed
+	}
+	delete(u.subscriptions, mailbox)
+	return nil
+}
+
+// MailboxAccessKey implements the MailboxAccessKey method on the
+// AccessKeyUser interface
+func (u DummyUser) MailboxAccessKey(mailbox string) ([]byte, error) {
+	mailbox = util.NormalizeMailboxName(mailbox)
+	if key, ok := u.accessKeys[mailbox]; ok {
+		return key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	u.accessKeys[mailbox] = key
+	return key, nil
+}
+
+// ResetMailboxAccessKeys implements the ResetMailboxAccessKeys method on the
+// AccessKeyUser interface
+func (u DummyUser) ResetMailboxAccessKeys(mailbox string) error {
+	if mailbox == "" {
+		for name := range u.accessKeys {
+			delete(u.accessKeys, name)
+		}
+		return nil
+	}
+	delete(u.accessKeys, util.NormalizeMailboxName(mailbox))
+	return nil
+}
+
 // DummyMailbox is an in-memory implementation of a Mailstore Mailbox
 type DummyMailbox struct {
-	ID        uint32
-	name      string
-	nextuid   uint32
-	messages  []Message
-	mailstore *DummyMailstore
+	ID         uint32
+	name       string
+	nextuid    uint32
+	messages   []Message
+	mailstore  *DummyMailstore
+	changeLog  *MemoryChangeLog
+	specialUse string
+
+	hideDeleted bool // Whether messages flagged \Deleted are hidden
+	noselect    bool // Whether the mailbox is only a placeholder
+	deleted     bool // Whether the mailbox has been deleted
+
+	// Message annotations by UID, then entry, then attribute
+	annotations map[uint32]map[string]map[string]string
 }
 
+// ChangeLog implements the ChangeLog method on the ChangeLogMailbox interface
+func (m DummyMailbox) ChangeLog() ChangeLog { return m.changeLog }
+
+// SpecialUse implements the SpecialUse method on the SpecialUseMailbox interface
+func (m DummyMailbox) SpecialUse() string { return m.specialUse }
+
+// HideDeleted implements the HideDeleted method on the SoftDeleteMailbox
+// interface
+func (m DummyMailbox) HideDeleted() bool { return m.hideDeleted }
+
+// Noselect implements the Noselect method on the NoselectMailbox interface
+func (m DummyMailbox) Noselect() bool { return m.noselect }
+
 // DebugPrintMailbox prints out all messages in the mailbox to the command line
 // for debugging purposes
 func (m DummyMailbox) DebugPrintMailbox() {
@@ -143,6 +397,39 @@ func (m DummyMailbox) Recent() uint32 {
 	return count
 }
 
+// PermanentFlags implements the PermanentFlags method on the
+// PermanentFlagsMailbox interface
+func (m DummyMailbox) PermanentFlags() types.PermanentFlags {
+	flags := types.DefaultPermanentFlags
+	flags.Keywords = true
+	return flags
+}
+
+// ClaimRecent implements the ClaimRecent method on the RecentMailbox
+// interface
+func (m DummyMailbox) ClaimRecent() ([]uint32, error) {
+	mailbox := &(m.mailstore.User.mailboxes[m.ID])
+	var uids []uint32
+	for index, message := range mailbox.messages {
+		if message.Flags().HasFlags(types.FlagRecent) {
+			uids = append(uids, message.UID())
+			mailbox.messages[index] = message.RemoveFlags(types.FlagRecent)
+		}
+	}
+	return uids, nil
+}
+
+// FirstUnseen implements the FirstUnseen method on the FirstUnseenMailbox
+// interface
+func (m DummyMailbox) FirstUnseen() uint32 {
+	for index, message := range m.messages {
+		if !message.Flags().HasFlags(types.FlagSeen) {
+			return uint32(index + 1)
+		}
+	}
+	return 0
+}
+
 // Messages returns the total number of messages in the Mailbox
 func (m DummyMailbox) Messages() uint32 { return uint32(len(m.messages)) }
 
@@ -158,6 +445,190 @@ func (m DummyMailbox) Unseen() uint32 {
 	return count
 }
 
+// Expunge implements the Expunge method on the ExpungeMailbox interface
+func (m DummyMailbox) Expunge(uids []uint32) error {
+	mailbox := &(m.mailstore.User.mailboxes[m.ID])
+	remaining := make([]Message, 0, len(mailbox.messages))
+	for _, message := range mailbox.messages {
+		msg := message.(DummyMessage)
+		if uidInList(msg.uid, uids) {
+			delete(mailbox.annotations, msg.uid)
+			mailbox.changeLog.Append(Change{Type: ChangeExpunge, UID: msg.uid})
+			continue
+		}
+		msg.sequenceNumber = uint32(len(remaining) + 1)
+		remaining = append(remaining, msg)
+	}
+	mailbox.messages = remaining
+	return nil
+}
+
+// Replace implements the Replace method on the ReplaceMailbox interface
+func (m DummyMailbox) Replace(uid uint32, replacement Message) (Message, error) {
+	if m.mailstore.User.mailboxes[m.ID].MessageByUID(uid) == nil {
+		return nil, errors.New("No such message")
+	}
+	saved, err := replacement.Save()
+	if err != nil {
+		return nil, err
+	}
+	return saved, m.Expunge([]uint32{uid})
+}
+
+// Annotations implements the Annotations method on the AnnotationMailbox
+// interface
+func (m DummyMailbox) Annotations(uid uint32) (map[string]map[string]string, error) {
+	if m.mailstore.User.mailboxes[m.ID].MessageByUID(uid) == nil {
+		return nil, errors.New("No such message")
+	}
+	annotations := make(map[string]map[string]string)
+	for entry, attributes := range m.annotations[uid] {
+		annotations[entry] = make(map[string]string)
+		for attribute, value := range attributes {
+			annotations[entry][attribute] = value
+		}
+	}
+	return annotations, nil
+}
+
+// SetAnnotation implements the SetAnnotation method on the
+// AnnotationMailbox interface
+func (m DummyMailbox) SetAnnotation(uid uint32, entry string, attribute string, value string) error {
+	if m.mailstore.User.mailboxes[m.ID].MessageByUID(uid) == nil {
+		return errors.New("No such message")
+	}
+	if m.annotations[uid] == nil {
+		m.annotations[uid] = make(map[string]map[string]string)
+	}
+	if m.annotations[uid][entry] == nil {
+		m.annotations[uid][entry] = make(map[string]string)
+	}
+	m.annotations[uid][entry][attribute] = value
+	return nil
+}
+
+// RemoveAnnotation implements the RemoveAnnotation method on the
+// AnnotationMailbox interface
+func (m DummyMailbox) RemoveAnnotation(uid uint32, entry string, attribute string) error {
+	if m.mailstore.User.mailboxes[m.ID].MessageByUID(uid) == nil {
+		return errors.New("No such message")
+	}
+	delete(m.annotations[uid][entry], attribute)
+	if len(m.annotations[uid][entry]) == 0 {
+		delete(m.annotations[uid], entry)
+	}
+	return nil
+}
+
+// Begin implements the Begin method on the TransactionMailbox interface.
+// Changes are made to a private copy of the user's mailboxes, which replaces
+// them when the transaction is committed.
+func (m DummyMailbox) Begin() (Transaction, error) {
+	store := m.mailstore
+	staging := &DummyMailstore{User: store.User, Delimiter: store.Delimiter}
+	staging.User.mailstore = staging
+	staging.User.mailboxes = make([]DummyMailbox, len(store.User.mailboxes))
+	logged := make([]uint64, len(store.User.mailboxes))
+	for i, mailbox := range store.User.mailboxes {
+		staging.User.mailboxes[i] = mailbox.copyTo(staging)
+		logged[i], _ = mailbox.changeLog.HighestModSeq()
+	}
+	return &dummyTransaction{store: store, staging: staging, mailboxID: m.ID, logged: logged}, nil
+}
+
+// Copy a mailbox and its messages into another mailstore
+func (m DummyMailbox) copyTo(store *DummyMailstore) DummyMailbox {
+	m.mailstore = store
+	messages := make([]Message, len(m.messages))
+	for i, message := range m.messages {
+		msg := message.(DummyMessage)
+		msg.mailstore = store
+		messages[i] = msg
+	}
+	m.messages = messages
+
+	changes, _ := m.changeLog.Since(0)
+	m.changeLog = &MemoryChangeLog{changes: changes}
+
+	annotations := make(map[uint32]map[string]map[string]string, len(m.annotations))
+	for uid, entries := range m.annotations {
+		annotations[uid] = make(map[string]map[string]string, len(entries))
+		for entry, attributes := range entries {
+			annotations[uid][entry] = make(map[string]string, len(attributes))
+			for attribute, value := range attributes {
+				annotations[uid][entry][attribute] = value
+			}
+		}
+	}
+	m.annotations = annotations
+	return m
+}
+
+// A transaction on a DummyMailbox
+type dummyTransaction struct {
+	store     *DummyMailstore
+	staging   *DummyMailstore // Holds the mailboxes as changed by the transaction
+	mailboxID uint32
+	logged    []uint64 // The highest mod-sequence of each mailbox when the transaction began
+	done      bool
+}
+
+func (t *dummyTransaction) Mailbox() Mailbox {
+	return t.staging.User.withSettings(t.staging.User.mailboxes[t.mailboxID])
+}
+
+func (t *dummyTransaction) MailboxByName(name string) (Mailbox, error) {
+	return t.staging.User.MailboxByName(name)
+}
+
+// Replace the user's mailboxes with the transaction's copies, logging the
+// changes made in the transaction. If any mailbox has been changed by
+// someone else in the meantime, nothing is applied.
+func (t *dummyTransaction) Commit() error {
+	if t.done {
+		return errors.New("Transaction already finished")
+	}
+	mailboxes := t.store.User.mailboxes
+	for i := range t.logged {
+		if modseq, _ := mailboxes[i].changeLog.HighestModSeq(); modseq != t.logged[i] {
+			return errors.New("Mailbox was changed during the transaction")
+		}
+	}
+	t.done = true
+
+	for i := range t.logged {
+		mailbox := &mailboxes[i]
+		staged := t.staging.User.mailboxes[i]
+		changes, _ := staged.changeLog.Since(t.logged[i])
+		for _, change := range changes {
+			mailbox.changeLog.Append(change)
+		}
+		committed := staged.copyTo(t.store)
+		mailbox.messages = committed.messages
+		mailbox.nextuid = committed.nextuid
+		mailbox.annotations = committed.annotations
+	}
+	return nil
+}
+
+// Discard the transaction's copies of the mailboxes
+func (t *dummyTransaction) Rollback() error {
+	if t.done {
+		return errors.New("Transaction already finished")
+	}
+	t.done = true
+	return nil
+}
+
+func uidInList(uid uint32, uids []uint32) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
+
 // MessageBySequenceNumber returns a single message given the message's sequence number
 func (m DummyMailbox) MessageBySequenceNumber(seqno uint32) Message {
 	if seqno > uint32(len(m.messages)) {
@@ -180,7 +651,7 @@ func (m DummyMailbox) MessageByUID(uidno uint32) Message {
 
 // MessageSetByUID returns a slice of messages given a set of UID ranges.
 // eg 1,5,9,28:140,190:*
-func (m DummyMailbox) MessageSetByUID(set types.SequenceSet) []Message {
+func (m DummyMailbox) MessageSetByUID(set types.UIDSet) []Message {
 	var msgs []Message
 
 	// If the mailbox is empty, return empty array
@@ -338,11 +809,13 @@ func (m *DummyMailbox) addEmail(from string, to string, subject string, date tim
 		header:         hdr,
 		body:           body,
 		internalDate:   date,
+		saveDate:       date,
 	}
 	newMessage = newMessage.AddFlags(types.FlagRecent).(DummyMessage)
 	newMessage.mailboxID = m.ID
 	newMessage.mailstore = m.mailstore
 	m.messages = append(m.messages, newMessage)
+	m.changeLog.Append(Change{Type: ChangeAppend, UID: uid, Flags: newMessage.flags})
 }
 
 // DummyMessage is a representation of a single in-memory message in a DummyMailbox
@@ -351,7 +824,9 @@ type DummyMessage struct {
 	uid            uint32
 	header         textproto.MIMEHeader
 	internalDate   time.Time
+	saveDate       time.Time
 	flags          types.Flags
+	keywords       []string
 	mailboxID      uint32
 	mailstore      *DummyMailstore
 	body           string
@@ -379,6 +854,18 @@ func (m DummyMessage) InternalDate() time.Time {
 	return m.internalDate
 }
 
+// SaveDate implements the SaveDate method on the SaveDateMessage interface
+func (m DummyMessage) SaveDate() (time.Time, bool) {
+	return m.saveDate, !m.saveDate.IsZero()
+}
+
+// SetInternalDate implements the SetInternalDate method on the
+// InternalDateMessage interface
+func (m DummyMessage) SetInternalDate(date time.Time) Message {
+	m.internalDate = date
+	return m
+}
+
 // Body returns the full body of the message
 func (m DummyMessage) Body() string {
 	return m.body
@@ -386,9 +873,14 @@ func (m DummyMessage) Body() string {
 
 // Keywords returns any keywords associated with the message
 func (m DummyMessage) Keywords() []string {
-	var f []string
-	//f[0] = "Test"
-	return f
+	return append([]string(nil), m.keywords...)
+}
+
+// SetKeywords implements the SetKeywords method on the KeywordMessage
+// interface
+func (m DummyMessage) SetKeywords(keywords []string) Message {
+	m.keywords = append([]string(nil), keywords...)
+	return m
 }
 
 func (m DummyMessage) Flags() types.Flags {
@@ -426,15 +918,46 @@ func (m DummyMessage) Save() (Message, error) {
 		// Message is new
 		m.uid = mailbox.nextuid
 		mailbox.nextuid++
-		m.sequenceNumber = uint32(len(mailbox.messages))
+		m.sequenceNumber = uint32(len(mailbox.messages) + 1)
+		m.saveDate = time.Now()
 		mailbox.messages = append(mailbox.messages, m)
+		mailbox.changeLog.Append(Change{Type: ChangeAppend, UID: m.uid, Flags: m.flags})
 	} else {
-		// Message exists
-		mailbox.messages[m.sequenceNumber-1] = m
+		// Message exists, though its sequence number may be out of date if
+		// messages before it have been expunged since it was read
+		index := -1
+		for i, message := range mailbox.messages {
+			if message.UID() == m.uid {
+				index = i
+			}
+		}
+		if index < 0 {
+			return m, errors.New("Message has been expunged")
+		}
+		previous := mailbox.messages[index]
+		if previous.Flags() != m.flags || !sameKeywords(previous.Keywords(), m.keywords) {
+			mailbox.changeLog.Append(Change{Type: ChangeFlags, UID: m.uid, Flags: m.flags})
+		}
+		stored := m
+		stored.sequenceNumber = uint32(index + 1)
+		mailbox.messages[index] = stored
 	}
 	return m, nil
 }
 
+// Whether two lists hold the same keywords, in any order
+func sameKeywords(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, keyword := range a {
+		if !types.HasKeyword(b, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
 func debugPrintMessages(messages []Message) {
 	fmt.Printf("SeqNo  |UID    |From      |To        |Subject\n")
 	fmt.Printf("-------+-------+----------+----------+-------\n")
@@ -1,6 +1,10 @@
 package mailstore
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/jordwest/imap-server/types"
+)
 
 func getDefaultInbox(t *testing.T) DummyMailbox {
 	m := NewDummyMailstore()
@@ -38,37 +42,180 @@ func assertMessageUIDs(t *testing.T, msgs []Message, uids []uint32) {
 
 func TestMessageSetBySequenceNumber(t *testing.T) {
 	inbox := getDefaultInbox(t)
-	msgs := inbox.MessageSetBySequenceNumber(SequenceSet{
-		SequenceRange{min: "1", max: ""},
-		SequenceRange{min: "4", max: "*"},
+	msgs := inbox.MessageSetBySequenceNumber(types.SequenceSet{
+		types.SequenceRange{Min: "1", Max: ""},
+		types.SequenceRange{Min: "4", Max: "*"},
 	})
 	assertMessageUIDs(t, msgs, []uint32{10})
 
-	msgs = inbox.MessageSetBySequenceNumber(SequenceSet{
-		SequenceRange{min: "2", max: "3"},
+	msgs = inbox.MessageSetBySequenceNumber(types.SequenceSet{
+		types.SequenceRange{Min: "2", Max: "3"},
 	})
 	assertMessageUIDs(t, msgs, []uint32{11, 12})
 }
 
 func TestMessageSetByUID(t *testing.T) {
 	inbox := getDefaultInbox(t)
-	msgs := inbox.MessageSetByUID(SequenceSet{
-		SequenceRange{min: "10", max: "*"},
+	msgs := inbox.MessageSetByUID(types.UIDSet{
+		types.UIDRange{Min: "10", Max: "*"},
 	})
 	assertMessageUIDs(t, msgs, []uint32{10, 11, 12})
 
-	msgs = inbox.MessageSetByUID(SequenceSet{
-		SequenceRange{min: "3", max: "9"},
+	msgs = inbox.MessageSetByUID(types.UIDSet{
+		types.UIDRange{Min: "3", Max: "9"},
 	})
 	assertMessageUIDs(t, msgs, []uint32{})
 
-	msgs = inbox.MessageSetByUID(SequenceSet{
-		SequenceRange{min: "11", max: "12"},
+	msgs = inbox.MessageSetByUID(types.UIDSet{
+		types.UIDRange{Min: "11", Max: "12"},
 	})
 	assertMessageUIDs(t, msgs, []uint32{11, 12})
 
-	msgs = inbox.MessageSetByUID(SequenceSet{
-		SequenceRange{min: "*", max: ""},
+	msgs = inbox.MessageSetByUID(types.UIDSet{
+		types.UIDRange{Min: "*", Max: ""},
 	})
 	assertMessageUIDs(t, msgs, []uint32{12})
 }
+
+func TestExpunge(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	if err := mailbox.Expunge([]uint32{11}); err != nil {
+		t.Fatalf("Error expunging: %s\n", err)
+	}
+
+	mailbox = mailbox.mailstore.User.mailboxes[mailbox.ID]
+	msgs := mailbox.MessageSetBySequenceNumber(types.SequenceSet{types.SequenceRange{Min: "1", Max: "*"}})
+	assertMessageUIDs(t, msgs, []uint32{10, 12})
+	if msgs[1].SequenceNumber() != 2 {
+		t.Errorf("Expected UID 12 to become sequence number 2, got %d\n", msgs[1].SequenceNumber())
+	}
+
+	changes, _ := mailbox.ChangeLog().Since(0)
+	last := changes[len(changes)-1]
+	if last.Type != ChangeExpunge || last.UID != 11 {
+		t.Errorf("Expected expunge of UID 11 to be logged, got %+v\n", last)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	replacement := mailbox.NewMessage()
+	replacement.Header().Set("Subject", "Replacement")
+
+	saved, err := mailbox.Replace(10, replacement)
+	if err != nil {
+		t.Fatalf("Error replacing: %s\n", err)
+	}
+	if saved.UID() != 13 {
+		t.Errorf("Expected replacement to be saved as UID 13, got %d\n", saved.UID())
+	}
+
+	mailbox = mailbox.mailstore.User.mailboxes[mailbox.ID]
+	msgs := mailbox.MessageSetBySequenceNumber(types.SequenceSet{types.SequenceRange{Min: "1", Max: "*"}})
+	assertMessageUIDs(t, msgs, []uint32{11, 12, 13})
+
+	if _, err = mailbox.Replace(10, mailbox.NewMessage()); err == nil {
+		t.Errorf("Expected error replacing a message which doesn't exist\n")
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	if err := mailbox.SetAnnotation(11, "/comment", "value.priv", "Note"); err != nil {
+		t.Fatalf("Error setting annotation: %s\n", err)
+	}
+	if err := mailbox.SetAnnotation(99, "/comment", "value.priv", "Note"); err == nil {
+		t.Errorf("Expected error annotating a nonexistent message\n")
+	}
+
+	annotations, _ := mailbox.Annotations(11)
+	if annotations["/comment"]["value.priv"] != "Note" {
+		t.Errorf("Expected annotation to be stored, got %v\n", annotations)
+	}
+	annotations["/comment"]["value.priv"] = "Changed"
+	if stored, _ := mailbox.Annotations(11); stored["/comment"]["value.priv"] != "Note" {
+		t.Errorf("Changes to returned annotations should not be stored\n")
+	}
+
+	mailbox.Expunge([]uint32{11})
+	if len(mailbox.annotations[11]) != 0 {
+		t.Errorf("Expected annotations to be removed with the message\n")
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	highest, _ := mailbox.ChangeLog().HighestModSeq()
+
+	tx, err := mailbox.Begin()
+	if err != nil {
+		t.Fatalf("Error beginning transaction: %s\n", err)
+	}
+	msg := tx.Mailbox().MessageByUID(10)
+	msg.AddFlags(types.FlagDeleted).Save()
+	tx.Mailbox().(DummyMailbox).Expunge([]uint32{11})
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Error rolling back: %s\n", err)
+	}
+
+	mailbox = mailbox.mailstore.User.mailboxes[mailbox.ID]
+	msgs := mailbox.MessageSetBySequenceNumber(types.SequenceSet{types.SequenceRange{Min: "1", Max: "*"}})
+	assertMessageUIDs(t, msgs, []uint32{10, 11, 12})
+	if msgs[0].Flags().HasFlags(types.FlagDeleted) {
+		t.Errorf("Expected flag change to be rolled back\n")
+	}
+
+	if changes, _ := mailbox.ChangeLog().Since(highest); len(changes) != 0 {
+		t.Errorf("Expected no changes to be logged, got %+v\n", changes)
+	}
+	if err = tx.Commit(); err == nil {
+		t.Errorf("Expected error committing a finished transaction\n")
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	highest, _ := mailbox.ChangeLog().HighestModSeq()
+
+	tx, _ := mailbox.Begin()
+	tx.Mailbox().MessageByUID(10).AddFlags(types.FlagSeen).Save()
+	tx.Mailbox().(DummyMailbox).Expunge([]uint32{11})
+	trash, _ := tx.MailboxByName("Trash")
+	trash.NewMessage().SetBody("Copied").Save()
+
+	mailbox = mailbox.mailstore.User.mailboxes[mailbox.ID]
+	if mailbox.Messages() != 3 || mailbox.MessageByUID(10).Flags().HasFlags(types.FlagSeen) {
+		t.Errorf("Expected changes to be hidden until committed\n")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s\n", err)
+	}
+
+	mailbox = mailbox.mailstore.User.mailboxes[mailbox.ID]
+	msgs := mailbox.MessageSetBySequenceNumber(types.SequenceSet{types.SequenceRange{Min: "1", Max: "*"}})
+	assertMessageUIDs(t, msgs, []uint32{10, 12})
+	if !msgs[0].Flags().HasFlags(types.FlagSeen) {
+		t.Errorf("Expected flag change to be committed\n")
+	}
+	if changes, _ := mailbox.ChangeLog().Since(highest); len(changes) != 2 {
+		t.Errorf("Expected the transaction's changes to be logged, got %+v\n", changes)
+	}
+	if trash, _ = mailbox.mailstore.User.MailboxByName("Trash"); trash.Messages() != 1 {
+		t.Errorf("Expected copy to be committed\n")
+	}
+}
+
+func TestTransactionConflict(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	tx, _ := mailbox.Begin()
+	tx.Mailbox().MessageByUID(10).AddFlags(types.FlagSeen).Save()
+	mailbox.MessageByUID(12).AddFlags(types.FlagFlagged).Save()
+
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("Expected error committing after the mailbox changed\n")
+	}
+	mailbox = mailbox.mailstore.User.mailboxes[mailbox.ID]
+	if mailbox.MessageByUID(10).Flags().HasFlags(types.FlagSeen) {
+		t.Errorf("Expected no changes to be committed\n")
+	}
+}
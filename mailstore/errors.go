@@ -0,0 +1,24 @@
+package mailstore
+
+import "errors"
+
+// Errors which a mailstore may return to tell the client why an operation
+// failed, so that it can decide whether to retry or what to show the user
+var (
+	// ErrUnavailable is returned when the mail storage can't be reached
+	// for now, but the operation may succeed if tried again later
+	ErrUnavailable = errors.New("Mail storage is temporarily unavailable")
+
+	// ErrAuthorizationFailed is returned by authentication when the
+	// credentials are correct but the user isn't allowed to log in, or
+	// isn't allowed to act as the user they asked to act as
+	ErrAuthorizationFailed = errors.New("Not authorized to log in")
+
+	// ErrOverQuota is returned when an operation would take the user over
+	// their storage quota
+	ErrOverQuota = errors.New("Mailbox storage quota exceeded")
+
+	// ErrLimit is returned when an operation exceeds a limit set by the
+	// server, other than the user's quota
+	ErrLimit = errors.New("Server limit exceeded")
+)
@@ -0,0 +1,27 @@
+package mailstore
+
+import (
+	"context"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// MessageFlags returns all of a message's flags: its system flags and its
+// keywords
+func MessageFlags(m Message) types.FlagList {
+	return types.FlagList{System: m.Flags(), Keywords: m.Keywords()}
+}
+
+// SetMessageFlags replaces a message's system flags and, if it's a
+// KeywordMessage, its keywords, and returns the updated message. As with
+// OverwriteFlags, the change isn't stored until the message is saved.
+func SetMessageFlags(ctx context.Context, m Message, flags types.FlagList) (Message, error) {
+	m, err := UpdateFlags(ctx, m, types.StoreReplace, flags.System)
+	if err != nil {
+		return nil, err
+	}
+	if keywordMsg, ok := m.(KeywordMessage); ok {
+		m = keywordMsg.SetKeywords(flags.Keywords)
+	}
+	return m, nil
+}
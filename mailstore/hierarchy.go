@@ -0,0 +1,52 @@
+package mailstore
+
+import (
+	"context"
+	"strings"
+)
+
+// DefaultDelimiter separates the levels of the mailbox hierarchy for
+// mailstores which don't declare their own delimiter
+const DefaultDelimiter = "/"
+
+// Delimiter returns the hierarchy delimiter used by a mailstore's mailbox
+// names, which is "" if the mailstore's namespace is flat
+func Delimiter(store Mailstore) string {
+	if hierarchy, ok := store.(HierarchyMailstore); ok {
+		return hierarchy.HierarchyDelimiter()
+	}
+	return DefaultDelimiter
+}
+
+// ParentNames returns the names of the levels of the hierarchy above the
+// named mailbox, outermost first, whether or not those mailboxes exist
+func ParentNames(name string, delimiter string) []string {
+	if delimiter == "" {
+		return nil
+	}
+	levels := strings.Split(name, delimiter)
+	parents := make([]string, 0, len(levels)-1)
+	for i := 1; i < len(levels); i++ {
+		parents = append(parents, strings.Join(levels[:i], delimiter))
+	}
+	return parents
+}
+
+// Inferiors returns the user's mailboxes which are anywhere beneath the
+// named mailbox in the hierarchy
+func Inferiors(ctx context.Context, user User, name string, delimiter string) ([]Mailbox, error) {
+	if delimiter == "" {
+		return nil, nil
+	}
+	mailboxes, err := Mailboxes(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	var inferiors []Mailbox
+	for _, mailbox := range mailboxes {
+		if strings.HasPrefix(mailbox.Name(), name+delimiter) {
+			inferiors = append(inferiors, mailbox)
+		}
+	}
+	return inferiors, nil
+}
@@ -0,0 +1,43 @@
+package mailstore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDelimiter(t *testing.T) {
+	m := NewDummyMailstore()
+	if d := Delimiter(m); d != "/" {
+		t.Errorf("Expected the dummy mailstore's delimiter, got %q\n", d)
+	}
+	m.Delimiter = ""
+	if d := Delimiter(m); d != "" {
+		t.Errorf("Expected a flat namespace, got %q\n", d)
+	}
+}
+
+func TestParentNames(t *testing.T) {
+	parents := ParentNames("Archive.2015.June", ".")
+	if !reflect.DeepEqual(parents, []string{"Archive", "Archive.2015"}) {
+		t.Errorf("Unexpected parents %v\n", parents)
+	}
+	if parents := ParentNames("Archive/2015", ""); len(parents) != 0 {
+		t.Errorf("Expected no parents in a flat namespace, got %v\n", parents)
+	}
+}
+
+func TestInferiors(t *testing.T) {
+	m := NewDummyMailstore()
+	m.User.CreateMailbox("Trash/Old")
+	m.User.CreateMailbox("Trash/Old/2015")
+	m.User.CreateMailbox("TrashCan")
+
+	inferiors, err := Inferiors(context.Background(), m.User, "Trash", "/")
+	if err != nil || len(inferiors) != 2 {
+		t.Errorf("Expected 2 mailboxes beneath Trash, got %d, %v\n", len(inferiors), err)
+	}
+	if inferiors, _ := Inferiors(context.Background(), m.User, "Trash", ""); len(inferiors) != 0 {
+		t.Errorf("Expected no mailboxes beneath Trash when flat, got %d\n", len(inferiors))
+	}
+}
@@ -1,6 +1,8 @@
 package mailstore
 
 import (
+	"context"
+	"errors"
 	"net/textproto"
 	"time"
 
@@ -14,6 +16,43 @@ type Mailstore interface {
 	Authenticate(username string, password string) (User, error)
 }
 
+// TokenAuthenticator is an optional interface which may be implemented by a
+// Mailstore to accept OAuth 2.0 bearer tokens via the XOAUTH2 and
+// OAUTHBEARER authentication mechanisms
+type TokenAuthenticator interface {
+	// Attempt to authenticate a user with a bearer token and return the
+	// user if successful. The username may be blank if the client did not
+	// supply one, in which case the token alone identifies the user.
+	AuthenticateToken(username string, token string) (User, error)
+}
+
+// UserLookup is an optional interface which may be implemented by a
+// Mailstore to find users without their credentials, for features where one
+// user accesses data on behalf of another (eg URLAUTH)
+type UserLookup interface {
+	// Return the user with the given username
+	UserByName(username string) (User, error)
+}
+
+// HierarchyMailstore is an optional interface which may be implemented by a
+// Mailstore to declare how its mailbox names form a hierarchy. Mailstores
+// which don't implement it use DefaultDelimiter.
+type HierarchyMailstore interface {
+	// Return the string separating the levels of the hierarchy in mailbox
+	// names, or "" if mailbox names are flat and have no parents
+	HierarchyDelimiter() string
+}
+
+// MaintainedMailstore is an optional interface which may be implemented by a
+// Mailstore with housekeeping to be run from time to time, eg compacting its
+// storage or purging old messages from the trash, which operators can start
+// through the server's admin API
+type MaintainedMailstore interface {
+	// Run the housekeeping, returning once it has finished or the context
+	// is cancelled
+	Maintain(ctx context.Context) error
+}
+
 // User represents a user in the mail storage system
 type User interface {
 	// Return a list of mailboxes belonging to this user
@@ -22,6 +61,89 @@ type User interface {
 	MailboxByName(name string) (Mailbox, error)
 }
 
+// AccessKeyUser is an optional interface which may be implemented by a User
+// to store the secret mailbox access keys used to sign URLAUTH URLs
+type AccessKeyUser interface {
+	// Return the access key for the named mailbox, generating one if the
+	// mailbox does not have a key yet
+	MailboxAccessKey(mailbox string) ([]byte, error)
+
+	// Discard the access key for the named mailbox, invalidating any URLs
+	// signed with it. An empty mailbox name resets the keys of all mailboxes.
+	ResetMailboxAccessKeys(mailbox string) error
+}
+
+// FeatureUser is an optional interface which may be implemented by a User
+// to turn commands and capabilities on or off for that user alone, eg to
+// hide an expensive search from a huge account, or to offer an
+// experimental extension to beta testers
+type FeatureUser interface {
+	// Return feature toggles keyed by command or capability name (eg
+	// "REPLACE", "AUTH=PLAIN"), or by SEARCH and a search key (eg "SEARCH
+	// TEXT"). A feature set to false is disabled for the user. Features set
+	// to true, or not in the map, keep the server's setting; a user can't
+	// be given a feature the operator has disabled.
+	Features() map[string]bool
+}
+
+// SubscriptionStore is an optional interface which may be implemented by a
+// User to remember which mailboxes they have subscribed to, as listed by
+// LSUB. A user may subscribe to mailboxes which don't exist.
+type SubscriptionStore interface {
+	// Return the names of the mailboxes the user is subscribed to
+	Subscriptions() ([]string, error)
+
+	// Add a mailbox to the user's subscriptions
+	Subscribe(mailbox string) error
+
+	// Remove a mailbox from the user's subscriptions, or return
+	// ErrNotSubscribed if the user isn't subscribed to it
+	Unsubscribe(mailbox string) error
+}
+
+// ErrNotSubscribed is returned when unsubscribing from a mailbox the user
+// isn't subscribed to
+var ErrNotSubscribed = errors.New("Not subscribed to that mailbox")
+
+// MailboxCreator is an optional interface which may be implemented by a
+// User who can create new mailboxes
+type MailboxCreator interface {
+	// Create an empty mailbox with the given name, or return
+	// ErrMailboxExists if there is already a mailbox with that name. The
+	// mailbox's parents in the hierarchy are created separately.
+	CreateMailbox(name string) (Mailbox, error)
+}
+
+// ErrMailboxExists is returned when creating a mailbox whose name is taken
+var ErrMailboxExists = errors.New("Mailbox already exists")
+
+// MailboxDeleter is an optional interface which may be implemented by a
+// User who can delete mailboxes
+type MailboxDeleter interface {
+	// Delete the named mailbox and all of its messages. If keepName is true
+	// the mailbox is instead emptied and kept as a \Noselect placeholder,
+	// because other mailboxes exist beneath it in the hierarchy.
+	DeleteMailbox(name string, keepName bool) error
+}
+
+// MailboxRenamer is an optional interface which may be implemented by a
+// User who can rename mailboxes
+type MailboxRenamer interface {
+	// Give the mailbox a new name, or return ErrMailboxExists if the new
+	// name is taken. Mailboxes beneath it in the hierarchy are renamed
+	// separately. Renaming INBOX instead moves all of its messages into a
+	// new mailbox with the new name, leaving INBOX empty.
+	RenameMailbox(oldName string, newName string) error
+}
+
+// QuotaUser is an optional interface which may be implemented by a User
+// whose storage is limited by a quota
+type QuotaUser interface {
+	// Return the number of bytes of storage in use and the maximum number
+	// of bytes allowed. A limit of 0 means the user has no quota.
+	QuotaUsage() (used uint64, limit uint64, err error)
+}
+
 // Mailbox represents a mailbox belonging to a user in the mail storage system
 type Mailbox interface {
 	// The name of the mailbox
@@ -51,7 +173,7 @@ type Mailbox interface {
 	MessageByUID(uidno uint32) Message
 
 	// Get messages that belong to a set of ranges of UIDs
-	MessageSetByUID(set types.SequenceSet) []Message
+	MessageSetByUID(set types.UIDSet) []Message
 
 	// Get messages that belong to a set of ranges of sequence numbers
 	MessageSetBySequenceNumber(set types.SequenceSet) []Message
@@ -62,6 +184,218 @@ type Mailbox interface {
 	NewMessage() Message
 }
 
+// RecentMailbox is an optional interface which may be implemented by a
+// Mailbox to hand its recent messages over to a session. A message delivered
+// to the mailbox is recent until the first session to select it claims it,
+// after which it is only recent to that session (RFC 3501 section 2.3.2).
+type RecentMailbox interface {
+	// Clear the \Recent flag from every message which has it, and return
+	// the UIDs of those messages
+	ClaimRecent() ([]uint32, error)
+}
+
+// FirstUnseenMailbox is an optional interface which may be implemented by a
+// Mailbox that can find its first unseen message without checking the flags
+// of every message before it
+type FirstUnseenMailbox interface {
+	// The sequence number of the first message without the \Seen flag, or 0
+	// if every message has been seen
+	FirstUnseen() uint32
+}
+
+// SizedMailbox is an optional interface which may be implemented by a
+// Mailbox that can calculate its total size without summing the size of
+// every message
+type SizedMailbox interface {
+	// Total size in bytes of all messages in the mailbox
+	Size() uint64
+}
+
+// SaveDateMessage is an optional interface which may be implemented by a
+// Message to report when it was saved into its current mailbox (RFC 8514)
+type SaveDateMessage interface {
+	// Return the date the message was saved into the mailbox. The second
+	// return value is false if the save date is not known for this message.
+	SaveDate() (time.Time, bool)
+}
+
+// InternalDateMessage is an optional interface which may be implemented by
+// a Message whose internal date can be set before it is saved, eg so that a
+// copy of a message keeps the date the original was received, or an
+// appended message has the date given by the client
+type InternalDateMessage interface {
+	// Set the date the message was received and return the updated message
+	SetInternalDate(time.Time) Message
+}
+
+// KeywordMessage is an optional interface which may be implemented by a
+// Message which can hold keywords, such as $Forwarded, as well as system
+// flags. Its mailbox should permit keywords in its PermanentFlags.
+type KeywordMessage interface {
+	// Replace the message's keywords. As with its flags, the change isn't
+	// stored until the message is saved.
+	SetKeywords(keywords []string) Message
+}
+
+// RawMessage is an optional interface which may be implemented by a Message
+// that can supply its full text as it was received. Messages which don't are
+// reassembled from their header and body when the raw text is needed (eg to
+// search them).
+type RawMessage interface {
+	// The message's headers and body, exactly as stored
+	Raw() ([]byte, error)
+}
+
+// PermanentFlagsMailbox is an optional interface which may be implemented by
+// a Mailbox which can't store every flag. Mailboxes which don't implement it
+// are assumed to store the flags in types.DefaultPermanentFlags.
+type PermanentFlagsMailbox interface {
+	// The flags which clients may store in the mailbox
+	PermanentFlags() types.PermanentFlags
+}
+
+// VolatileUIDMailbox is an optional interface which may be implemented by a
+// Mailbox whose messages may be given new UIDs between sessions
+type VolatileUIDMailbox interface {
+	// Return true if the UIDs of the mailbox's messages might not be kept
+	// the next time it is selected
+	UIDsNotSticky() bool
+}
+
+// NoselectMailbox is an optional interface which may be implemented by a
+// Mailbox which only holds a place in the hierarchy, eg one which was
+// deleted while other mailboxes existed beneath it
+type NoselectMailbox interface {
+	// Whether the mailbox is a placeholder which cannot hold messages or be
+	// selected
+	Noselect() bool
+}
+
+// SoftDeleteMailbox is an optional interface which may be implemented by a
+// Mailbox whose messages flagged \Deleted should be hidden rather than shown
+// until they're expunged, for users whose clients never EXPUNGE. The
+// mailbox is then selected through a SoftDeleteView.
+type SoftDeleteMailbox interface {
+	// Whether messages flagged \Deleted are hidden when the mailbox is
+	// selected
+	HideDeleted() bool
+}
+
+// SearchableMailbox is an optional interface which may be implemented by a
+// Mailbox able to evaluate SEARCH criteria itself, eg using database
+// indexes. Mailboxes which don't are searched by checking every message with
+// the search package. Implementations which test messages one at a time can
+// leave AND, OR and NOT to the criteria's Matches method. The criteria never
+// refer to sequence numbers or to * in a UID set: the server replaces them
+// with the UIDs the client means.
+type SearchableMailbox interface {
+	// Returns the UIDs of the messages matching the criteria. If an error is
+	// returned the mailbox is searched message by message instead.
+	Search(criteria types.SearchCriteria) ([]uint32, error)
+}
+
+// RankedSearchMailbox is an optional interface which may be implemented by a
+// Mailbox with a full-text index able to rank messages against the text keys
+// of FUZZY searches (RFC 6203). Mailboxes which don't are ranked by the
+// server's search.Ranker.
+type RankedSearchMailbox interface {
+	// Scores how relevant the message with the given UID is to a text
+	// search key, eg SUBJECT "holiday", from 1 to 100, or 0 if it doesn't
+	// match. If an error is returned the server's ranker is used instead.
+	Rank(key types.SearchCriteria, uid uint32) (int, error)
+}
+
+// CopyMailbox is an optional interface which may be implemented by a
+// Mailbox able to copy its messages into another mailbox itself, eg without
+// reading them out of storage. Mailboxes which don't have each message read
+// and saved into the destination as a new message.
+type CopyMailbox interface {
+	// Copy the messages with the given UIDs into the destination, keeping
+	// their flags and internal dates
+	CopyMessages(uids []uint32, destination Mailbox) error
+}
+
+// ExpungeMailbox is an optional interface which may be implemented by a
+// Mailbox from which messages can be permanently removed
+type ExpungeMailbox interface {
+	// Permanently remove the messages with the given UIDs, renumbering the
+	// sequence numbers of any remaining messages
+	Expunge(uids []uint32) error
+}
+
+// CheckpointMailbox is an optional interface which may be implemented by a
+// Mailbox which buffers changes, eg a file-backed mailbox which can flush
+// and fsync its files when a client sends CHECK
+type CheckpointMailbox interface {
+	// Write any buffered changes to the mailbox to permanent storage
+	Checkpoint() error
+}
+
+// AnnotationMailbox is an optional interface which may be implemented by a
+// Mailbox that can store annotations on its messages (RFC 5257). An
+// annotation entry (eg "/comment") has private and shared values, stored
+// as the "value.priv" and "value.shared" attributes.
+type AnnotationMailbox interface {
+	// Return the annotations of the message with the given UID, keyed by
+	// entry and then by attribute
+	Annotations(uid uint32) (map[string]map[string]string, error)
+
+	// Set an attribute of an annotation entry on the message with the
+	// given UID
+	SetAnnotation(uid uint32, entry string, attribute string, value string) error
+
+	// Remove an attribute of an annotation entry, removing the entry if it
+	// has no attributes left
+	RemoveAnnotation(uid uint32, entry string, attribute string) error
+}
+
+// TransactionMailbox is an optional interface which may be implemented by a
+// Mailbox that can apply a batch of changes (eg to flags, expunging messages
+// or copying them elsewhere) atomically. Other sessions must not see the
+// changes until the transaction is committed.
+type TransactionMailbox interface {
+	// Begin a transaction. Changes made through the transaction's Mailbox
+	// take effect together when it is committed, or not at all if it is
+	// rolled back.
+	Begin() (Transaction, error)
+}
+
+// Transaction is a batch of changes to a mailbox, begun with
+// TransactionMailbox.Begin
+type Transaction interface {
+	// The mailbox through which changes in the transaction are made
+	Mailbox() Mailbox
+
+	// Look up another of the user's mailboxes, into which messages are
+	// copied as part of the transaction
+	MailboxByName(name string) (Mailbox, error)
+
+	// Apply all changes made in the transaction
+	Commit() error
+
+	// Discard all changes made in the transaction
+	Rollback() error
+}
+
+// ReplaceMailbox is an optional interface which may be implemented by a
+// Mailbox that can replace one of its messages with another in a single
+// atomic operation, as needed by the REPLACE command. Without it, REPLACE
+// saves the new message and then expunges the old one, which requires the
+// Mailbox to implement ExpungeMailbox.
+type ReplaceMailbox interface {
+	// Save the replacement message (which may belong to another mailbox)
+	// and expunge the message with the given UID from this mailbox
+	Replace(uid uint32, replacement Message) (Message, error)
+}
+
+// SpecialUseMailbox is an optional interface which may be implemented by a
+// Mailbox that has a special purpose, such as holding sent messages
+type SpecialUseMailbox interface {
+	// The RFC 6154 special-use attribute of the mailbox (eg "\\Sent"), or
+	// an empty string if the mailbox is not special
+	SpecialUse() string
+}
+
 // Message represents a standard email message
 type Message interface {
 	// Return the message's MIME headers as a map in format
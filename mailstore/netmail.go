@@ -0,0 +1,53 @@
+package mailstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ErrNotMultipart is returned when reading the parts of a message which
+// isn't a multipart message
+var ErrNotMultipart = errors.New("Message is not multipart")
+
+// ToNetMail converts a message to a net/mail Message, so that it can be
+// processed with code written for the standard library
+func ToNetMail(m Message) *mail.Message {
+	return &mail.Message{
+		Header: mail.Header(m.Header()),
+		Body:   strings.NewReader(m.Body()),
+	}
+}
+
+// FromNetMail copies the header and body of a net/mail Message into a
+// message, which is not saved. Pass a message from Mailbox.NewMessage to
+// store a new message, eg:
+//     msg, err := mailstore.FromNetMail(parsed, mailbox.NewMessage())
+//     if err == nil {
+//         msg, err = msg.Save()
+//     }
+func FromNetMail(msg *mail.Message, m Message) (Message, error) {
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return m, err
+	}
+	header := make(textproto.MIMEHeader)
+	for field, values := range msg.Header {
+		header[textproto.CanonicalMIMEHeaderKey(field)] = append([]string(nil), values...)
+	}
+	return m.SetHeaders(header).SetBody(string(body)), nil
+}
+
+// MultipartReader returns a reader over the MIME parts of a multipart
+// message, or ErrNotMultipart if the message has a single part
+func MultipartReader(m Message) (*multipart.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(m.Header().Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, ErrNotMultipart
+	}
+	return multipart.NewReader(strings.NewReader(m.Body()), params["boundary"]), nil
+}
@@ -0,0 +1,57 @@
+package mailstore
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestNetMailRoundTrip(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+
+	msg := ToNetMail(mailbox.MessageByUID(11))
+	if subject := msg.Header.Get("Subject"); subject != "Another test email" {
+		t.Errorf("Expected the message's subject, got %q\n", subject)
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+	if string(body) != mailbox.MessageByUID(11).Body() {
+		t.Errorf("Expected the message's body, got %q\n", body)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader("subject: Hello\r\nFrom: me@test.com\r\n\r\nHi there\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s\n", err)
+	}
+	converted, err := FromNetMail(parsed, mailbox.NewMessage())
+	if err != nil {
+		t.Fatalf("Error converting message: %s\n", err)
+	}
+	if converted.Header().Get("Subject") != "Hello" || converted.Body() != "Hi there\r\n" {
+		t.Errorf("Expected the parsed header and body, got %v %q\n", converted.Header(), converted.Body())
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	mailbox := getDefaultInbox(t)
+	if _, err := MultipartReader(mailbox.MessageByUID(10)); err != ErrNotMultipart {
+		t.Errorf("Expected ErrNotMultipart, got %v\n", err)
+	}
+
+	msg := mailbox.NewMessage()
+	header := msg.Header()
+	header.Set("Content-Type", "multipart/alternative; boundary=b")
+	msg = msg.SetHeaders(header).SetBody("--b\r\nContent-Type: text/plain\r\n\r\nPlain\r\n--b--\r\n")
+
+	reader, err := MultipartReader(msg)
+	if err != nil {
+		t.Fatalf("Error reading parts: %s\n", err)
+	}
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatalf("Error reading first part: %s\n", err)
+	}
+	if body, _ := ioutil.ReadAll(part); string(body) != "Plain" {
+		t.Errorf("Expected the part's body, got %q\n", body)
+	}
+}
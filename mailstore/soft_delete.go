@@ -0,0 +1,637 @@
+package mailstore
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// SoftDeleteView presents a mailbox without the messages which were flagged
+// \Deleted when the view was created, so that clients which never EXPUNGE
+// see deleted messages disappear the next time they select the mailbox, as
+// if they had been moved to the trash. Messages deleted while the view is in
+// use remain visible until the mailbox is selected again. The remaining
+// messages are renumbered so that their sequence numbers have no gaps.
+//
+// The view reads the underlying mailbox through the context and MailboxV2
+// helpers, so it implements ContextMailbox and MailboxV2 whatever the
+// underlying mailbox does. The other optional mailbox interfaces are passed
+// through to it. Where it doesn't implement one, the view's method returns
+// ErrNotSupported, or nothing for ChangeLog and SpecialUse; use Underlying
+// to check what the mailbox itself supports.
+type SoftDeleteView struct {
+	Mailbox
+	hidden map[uint32]bool
+	index  *visibleIndex
+}
+
+// The positions of the visible messages in the underlying mailbox, so that
+// it isn't listed again for every message looked up through the view
+type visibleIndex struct {
+	mutex     sync.Mutex
+	key       indexKey
+	positions []uint32 // The sequence numbers of the visible messages in it
+	uids      []uint32 // The UIDs of the visible messages, in order
+}
+
+// The state of the underlying mailbox a visibleIndex was built from. Any
+// message added changes the next UID, and any removed changes the count
+// unless one was also added.
+type indexKey struct {
+	total   uint32
+	nextUID uint32
+}
+
+// ErrNotSupported is returned by a SoftDeleteView when the underlying
+// mailbox doesn't support an operation
+var ErrNotSupported = errors.New("Not supported by this mailbox")
+
+// NewSoftDeleteView hides the messages in a mailbox which are currently
+// flagged \Deleted
+func NewSoftDeleteView(m Mailbox) SoftDeleteView {
+	view := SoftDeleteView{Mailbox: m, hidden: make(map[uint32]bool), index: &visibleIndex{}}
+	msgs, _ := allMessages(context.Background(), m)
+	for _, msg := range msgs {
+		if msg != nil && msg.Flags().HasFlags(types.FlagDeleted) {
+			view.hidden[msg.UID()] = true
+		}
+	}
+	return view
+}
+
+// Reload returns a view of a newer copy of the same mailbox (eg one fetched
+// again after it was changed), hiding the same messages as this view
+func (v SoftDeleteView) Reload(m Mailbox) SoftDeleteView {
+	return SoftDeleteView{Mailbox: m, hidden: v.hidden, index: &visibleIndex{}}
+}
+
+// Underlying returns the mailbox the view presents, so that the optional
+// interfaces it implements can be checked
+func (v SoftDeleteView) Underlying() Mailbox {
+	return v.Mailbox
+}
+
+// Return every message in a mailbox
+func allMessages(ctx context.Context, m Mailbox) ([]Message, error) {
+	count, err := MessageCount(ctx, m)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	return MessageSetBySequenceNumber(ctx, m, types.SequenceSet{{Min: "1", Max: "*"}})
+}
+
+// Find the positions and UIDs of the messages which aren't hidden. They're
+// kept until messages are added to or removed from the underlying mailbox.
+func (v SoftDeleteView) visible(ctx context.Context) (positions []uint32, uids []uint32, err error) {
+	index := v.index
+	if index == nil {
+		index = &visibleIndex{}
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	total, err := MessageCount(ctx, v.Mailbox)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := indexKey{total: total, nextUID: v.Mailbox.NextUID()}
+	if index.uids != nil && index.key == key {
+		return index.positions, index.uids, nil
+	}
+	all, err := allMessages(ctx, v.Mailbox)
+	if err != nil {
+		return nil, nil, err
+	}
+	index.key = key
+	index.positions = make([]uint32, 0, total)
+	index.uids = make([]uint32, 0, total)
+	for i, msg := range all {
+		if msg != nil && !v.hidden[msg.UID()] {
+			index.positions = append(index.positions, uint32(i+1))
+			index.uids = append(index.uids, msg.UID())
+		}
+	}
+	return index.positions, index.uids, nil
+}
+
+// Fetch the visible message at the given position in the view, numbered by
+// its position
+func (v SoftDeleteView) message(ctx context.Context, positions []uint32, i int) (Message, error) {
+	msg, err := MessageBySequenceNumber(ctx, v.Mailbox, positions[i])
+	if msg == nil || err != nil {
+		return nil, err
+	}
+	return viewMessage{msg, uint32(i + 1)}, nil
+}
+
+// Fetch every visible message, numbered in order
+func (v SoftDeleteView) messages(ctx context.Context) ([]Message, error) {
+	positions, _, err := v.visible(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all, err := allMessages(ctx, v.Mailbox)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, 0, len(positions))
+	for i, position := range positions {
+		if int(position) <= len(all) && all[position-1] != nil {
+			msgs = append(msgs, viewMessage{all[position-1], uint32(i + 1)})
+		}
+	}
+	return msgs, nil
+}
+
+// Messages returns the number of messages which aren't hidden
+func (v SoftDeleteView) Messages() uint32 {
+	count, _ := v.MessageCountContext(context.Background())
+	return count
+}
+
+// Recent returns the number of visible messages with the \Recent flag
+func (v SoftDeleteView) Recent() uint32 {
+	var count uint32
+	msgs, _ := v.messages(context.Background())
+	for _, msg := range msgs {
+		if msg.Flags().HasFlags(types.FlagRecent) {
+			count++
+		}
+	}
+	return count
+}
+
+// Unseen returns the number of visible messages without the \Seen flag
+func (v SoftDeleteView) Unseen() uint32 {
+	var count uint32
+	msgs, _ := v.messages(context.Background())
+	for _, msg := range msgs {
+		if !msg.Flags().HasFlags(types.FlagSeen) {
+			count++
+		}
+	}
+	return count
+}
+
+// MessageBySequenceNumber returns a visible message by its sequence number
+// in the view
+func (v SoftDeleteView) MessageBySequenceNumber(seqno uint32) Message {
+	msg, _ := v.MessageBySequenceNumberContext(context.Background(), seqno)
+	return msg
+}
+
+// MessageByUID returns a visible message by its UID
+func (v SoftDeleteView) MessageByUID(uidno uint32) Message {
+	msg, _ := v.MessageByUIDContext(context.Background(), uidno)
+	return msg
+}
+
+// MessageSetByUID returns the visible messages whose UIDs are in the set,
+// where * is the UID of the last visible message
+func (v SoftDeleteView) MessageSetByUID(set types.UIDSet) []Message {
+	msgs, _ := v.MessageSetByUIDContext(context.Background(), set)
+	return msgs
+}
+
+// MessageSetBySequenceNumber returns the visible messages whose sequence
+// numbers in the view are in the set
+func (v SoftDeleteView) MessageSetBySequenceNumber(set types.SequenceSet) []Message {
+	msgs, _ := v.MessageSetBySequenceNumberContext(context.Background(), set)
+	return msgs
+}
+
+// MessageCountContext implements the MessageCountContext method on the
+// ContextMailbox interface, counting the messages which aren't hidden
+func (v SoftDeleteView) MessageCountContext(ctx context.Context) (uint32, error) {
+	_, uids, err := v.visible(ctx)
+	return uint32(len(uids)), err
+}
+
+// MessageBySequenceNumberContext implements the
+// MessageBySequenceNumberContext method on the ContextMailbox interface
+func (v SoftDeleteView) MessageBySequenceNumberContext(ctx context.Context, seqno uint32) (Message, error) {
+	positions, _, err := v.visible(ctx)
+	if err != nil || seqno == 0 || seqno > uint32(len(positions)) {
+		return nil, err
+	}
+	return v.message(ctx, positions, int(seqno-1))
+}
+
+// MessageByUIDContext implements the MessageByUIDContext method on the
+// ContextMailbox interface
+func (v SoftDeleteView) MessageByUIDContext(ctx context.Context, uidno uint32) (Message, error) {
+	positions, uids, err := v.visible(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(uids), func(i int) bool { return uids[i] >= uidno })
+	if i == len(uids) || uids[i] != uidno {
+		return nil, nil
+	}
+	return v.message(ctx, positions, i)
+}
+
+// MessageSetByUIDContext implements the MessageSetByUIDContext method on
+// the ContextMailbox interface. * is the UID of the last visible message.
+func (v SoftDeleteView) MessageSetByUIDContext(ctx context.Context, set types.UIDSet) ([]Message, error) {
+	positions, uids, err := v.visible(ctx)
+	if err != nil || len(uids) == 0 {
+		return nil, err
+	}
+	last := uids[len(uids)-1]
+
+	var matched []Message
+	for i, uid := range uids {
+		if !set.Contains(uid, last) {
+			continue
+		}
+		msg, err := v.message(ctx, positions, i)
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			matched = append(matched, msg)
+		}
+	}
+	return matched, nil
+}
+
+// MessageSetBySequenceNumberContext implements the
+// MessageSetBySequenceNumberContext method on the ContextMailbox interface
+func (v SoftDeleteView) MessageSetBySequenceNumberContext(ctx context.Context, set types.SequenceSet) ([]Message, error) {
+	positions, _, err := v.visible(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matched []Message
+	for i := range positions {
+		if !set.Contains(uint32(i+1), uint32(len(positions))) {
+			continue
+		}
+		msg, err := v.message(ctx, positions, i)
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			matched = append(matched, msg)
+		}
+	}
+	return matched, nil
+}
+
+// NewMessageContext implements the NewMessageContext method on the
+// ContextMailbox interface
+func (v SoftDeleteView) NewMessageContext(ctx context.Context) (Message, error) {
+	return NewMessage(ctx, v.Mailbox)
+}
+
+// MessageCount implements the MessageCount method on the MailboxV2
+// interface
+func (v SoftDeleteView) MessageCount() (uint32, error) {
+	return v.MessageCountContext(context.Background())
+}
+
+// LookupMessageBySequenceNumber implements the
+// LookupMessageBySequenceNumber method on the MailboxV2 interface
+func (v SoftDeleteView) LookupMessageBySequenceNumber(seqno uint32) (Message, error) {
+	return v.MessageBySequenceNumberContext(context.Background(), seqno)
+}
+
+// LookupMessageByUID implements the LookupMessageByUID method on the
+// MailboxV2 interface
+func (v SoftDeleteView) LookupMessageByUID(uid uint32) (Message, error) {
+	return v.MessageByUIDContext(context.Background(), uid)
+}
+
+// LookupMessageSetByUID implements the LookupMessageSetByUID method on the
+// MailboxV2 interface
+func (v SoftDeleteView) LookupMessageSetByUID(set types.UIDSet) ([]Message, error) {
+	return v.MessageSetByUIDContext(context.Background(), set)
+}
+
+// LookupMessageSetBySequenceNumber implements the
+// LookupMessageSetBySequenceNumber method on the MailboxV2 interface
+func (v SoftDeleteView) LookupMessageSetBySequenceNumber(set types.SequenceSet) ([]Message, error) {
+	return v.MessageSetBySequenceNumberContext(context.Background(), set)
+}
+
+// CreateMessage implements the CreateMessage method on the MailboxV2
+// interface
+func (v SoftDeleteView) CreateMessage() (Message, error) {
+	return v.NewMessageContext(context.Background())
+}
+
+// Leave out the UIDs of hidden messages
+func (v SoftDeleteView) visibleUIDs(uids []uint32) []uint32 {
+	shown := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		if !v.hidden[uid] {
+			shown = append(shown, uid)
+		}
+	}
+	return shown
+}
+
+// PermanentFlags implements the PermanentFlags method on the
+// PermanentFlagsMailbox interface
+func (v SoftDeleteView) PermanentFlags() types.PermanentFlags {
+	if policy, ok := v.Mailbox.(PermanentFlagsMailbox); ok {
+		return policy.PermanentFlags()
+	}
+	return types.DefaultPermanentFlags
+}
+
+// Search implements the Search method on the SearchableMailbox interface
+func (v SoftDeleteView) Search(criteria types.SearchCriteria) ([]uint32, error) {
+	return v.SearchContext(context.Background(), criteria)
+}
+
+// SearchContext implements the SearchContext method on the
+// ContextSearchableMailbox interface. Sequence numbers in the criteria are
+// those of the view, and hidden messages are left out of the UIDs found.
+func (v SoftDeleteView) SearchContext(ctx context.Context, criteria types.SearchCriteria) ([]uint32, error) {
+	searchable, ok := v.Mailbox.(SearchableMailbox)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	criteria, err := v.sequencesAsUIDs(ctx, criteria)
+	if err != nil {
+		return nil, err
+	}
+	uids, err := Search(ctx, searchable, criteria)
+	if err != nil {
+		return nil, err
+	}
+	return v.visibleUIDs(uids), nil
+}
+
+// Replace the sequence sets in search criteria with the UIDs of the
+// messages they pick out in the view, as the underlying mailbox numbers its
+// messages differently
+func (v SoftDeleteView) sequencesAsUIDs(ctx context.Context, criteria types.SearchCriteria) (types.SearchCriteria, error) {
+	if criteria.Key == "SEQUENCE" {
+		msgs, err := v.MessageSetBySequenceNumberContext(ctx, criteria.Set)
+		if err != nil {
+			return criteria, err
+		}
+		var uids []uint32
+		for _, msg := range msgs {
+			uids = append(uids, msg.UID())
+		}
+		return types.SearchCriteria{Key: "UID", UIDs: types.NewUIDSet(uids)}, nil
+	}
+	if criteria.Children == nil {
+		return criteria, nil
+	}
+	children := make([]types.SearchCriteria, len(criteria.Children))
+	for i, child := range criteria.Children {
+		var err error
+		if children[i], err = v.sequencesAsUIDs(ctx, child); err != nil {
+			return criteria, err
+		}
+	}
+	criteria.Children = children
+	return criteria, nil
+}
+
+// Expunge implements the Expunge method on the ExpungeMailbox interface
+func (v SoftDeleteView) Expunge(uids []uint32) error {
+	return v.ExpungeContext(context.Background(), uids)
+}
+
+// ExpungeContext implements the ExpungeContext method on the
+// ContextExpungeMailbox interface
+func (v SoftDeleteView) ExpungeContext(ctx context.Context, uids []uint32) error {
+	if expunger, ok := v.Mailbox.(ExpungeMailbox); ok {
+		return Expunge(ctx, expunger, uids)
+	}
+	return ErrNotSupported
+}
+
+// ChangeLog implements the ChangeLog method on the ChangeLogMailbox
+// interface. It's nil if the underlying mailbox doesn't keep one.
+func (v SoftDeleteView) ChangeLog() ChangeLog {
+	if logged, ok := v.Mailbox.(ChangeLogMailbox); ok {
+		return logged.ChangeLog()
+	}
+	return nil
+}
+
+// Annotations implements the Annotations method on the AnnotationMailbox
+// interface
+func (v SoftDeleteView) Annotations(uid uint32) (map[string]map[string]string, error) {
+	return v.AnnotationsContext(context.Background(), uid)
+}
+
+// AnnotationsContext implements the AnnotationsContext method on the
+// ContextAnnotationMailbox interface
+func (v SoftDeleteView) AnnotationsContext(ctx context.Context, uid uint32) (map[string]map[string]string, error) {
+	if annotated, ok := v.Mailbox.(AnnotationMailbox); ok {
+		return Annotations(ctx, annotated, uid)
+	}
+	return nil, ErrNotSupported
+}
+
+// SetAnnotation implements the SetAnnotation method on the
+// AnnotationMailbox interface
+func (v SoftDeleteView) SetAnnotation(uid uint32, entry string, attribute string, value string) error {
+	return v.SetAnnotationContext(context.Background(), uid, entry, attribute, value)
+}
+
+// SetAnnotationContext implements the SetAnnotationContext method on the
+// ContextAnnotationMailbox interface
+func (v SoftDeleteView) SetAnnotationContext(ctx context.Context, uid uint32, entry string, attribute string, value string) error {
+	if annotated, ok := v.Mailbox.(AnnotationMailbox); ok {
+		return SetAnnotation(ctx, annotated, uid, entry, attribute, value)
+	}
+	return ErrNotSupported
+}
+
+// RemoveAnnotation implements the RemoveAnnotation method on the
+// AnnotationMailbox interface
+func (v SoftDeleteView) RemoveAnnotation(uid uint32, entry string, attribute string) error {
+	return v.RemoveAnnotationContext(context.Background(), uid, entry, attribute)
+}
+
+// RemoveAnnotationContext implements the RemoveAnnotationContext method on
+// the ContextAnnotationMailbox interface
+func (v SoftDeleteView) RemoveAnnotationContext(ctx context.Context, uid uint32, entry string, attribute string) error {
+	if annotated, ok := v.Mailbox.(AnnotationMailbox); ok {
+		return RemoveAnnotation(ctx, annotated, uid, entry, attribute)
+	}
+	return ErrNotSupported
+}
+
+// Begin implements the Begin method on the TransactionMailbox interface
+func (v SoftDeleteView) Begin() (Transaction, error) {
+	return v.BeginContext(context.Background())
+}
+
+// BeginContext implements the BeginContext method on the
+// ContextTransactionMailbox interface
+func (v SoftDeleteView) BeginContext(ctx context.Context) (Transaction, error) {
+	if transactional, ok := v.Mailbox.(TransactionMailbox); ok {
+		return Begin(ctx, transactional)
+	}
+	return nil, ErrNotSupported
+}
+
+// Replace implements the Replace method on the ReplaceMailbox interface
+func (v SoftDeleteView) Replace(uid uint32, replacement Message) (Message, error) {
+	return v.ReplaceContext(context.Background(), uid, replacement)
+}
+
+// ReplaceContext implements the ReplaceContext method on the
+// ContextReplaceMailbox interface
+func (v SoftDeleteView) ReplaceContext(ctx context.Context, uid uint32, replacement Message) (Message, error) {
+	if replacer, ok := v.Mailbox.(ReplaceMailbox); ok {
+		return Replace(ctx, replacer, uid, replacement)
+	}
+	return nil, ErrNotSupported
+}
+
+// CopyMessages implements the CopyMessages method on the CopyMailbox
+// interface
+func (v SoftDeleteView) CopyMessages(uids []uint32, destination Mailbox) error {
+	return v.CopyMessagesContext(context.Background(), uids, destination)
+}
+
+// CopyMessagesContext implements the CopyMessagesContext method on the
+// ContextCopyMailbox interface
+func (v SoftDeleteView) CopyMessagesContext(ctx context.Context, uids []uint32, destination Mailbox) error {
+	if copier, ok := v.Mailbox.(CopyMailbox); ok {
+		return CopyMessages(ctx, copier, uids, destination)
+	}
+	return ErrNotSupported
+}
+
+// SpecialUse implements the SpecialUse method on the SpecialUseMailbox
+// interface. It's blank if the underlying mailbox has no special use.
+func (v SoftDeleteView) SpecialUse() string {
+	if special, ok := v.Mailbox.(SpecialUseMailbox); ok {
+		return special.SpecialUse()
+	}
+	return ""
+}
+
+// Size implements the Size method on the SizedMailbox interface, counting
+// only the visible messages
+func (v SoftDeleteView) Size() uint64 {
+	var size uint64
+	msgs, _ := v.messages(context.Background())
+	for _, msg := range msgs {
+		size += uint64(msg.Size())
+	}
+	return size
+}
+
+// ClaimRecent implements the ClaimRecent method on the RecentMailbox
+// interface. Only the UIDs of visible messages are returned.
+func (v SoftDeleteView) ClaimRecent() ([]uint32, error) {
+	return v.ClaimRecentContext(context.Background())
+}
+
+// ClaimRecentContext implements the ClaimRecentContext method on the
+// ContextRecentMailbox interface
+func (v SoftDeleteView) ClaimRecentContext(ctx context.Context) ([]uint32, error) {
+	if recent, ok := v.Mailbox.(RecentMailbox); ok {
+		uids, err := ClaimRecent(ctx, recent)
+		return v.visibleUIDs(uids), err
+	}
+	return nil, ErrNotSupported
+}
+
+// A message as seen through a SoftDeleteView, numbered by its position in
+// the view
+type viewMessage struct {
+	Message
+	sequenceNumber uint32
+}
+
+func (m viewMessage) SequenceNumber() uint32 { return m.sequenceNumber }
+
+func (m viewMessage) OverwriteFlags(flags types.Flags) Message {
+	return viewMessage{m.Message.OverwriteFlags(flags), m.sequenceNumber}
+}
+
+func (m viewMessage) AddFlags(flags types.Flags) Message {
+	return viewMessage{m.Message.AddFlags(flags), m.sequenceNumber}
+}
+
+func (m viewMessage) RemoveFlags(flags types.Flags) Message {
+	return viewMessage{m.Message.RemoveFlags(flags), m.sequenceNumber}
+}
+
+func (m viewMessage) SetHeaders(header textproto.MIMEHeader) Message {
+	return viewMessage{m.Message.SetHeaders(header), m.sequenceNumber}
+}
+
+func (m viewMessage) SetBody(body string) Message {
+	return viewMessage{m.Message.SetBody(body), m.sequenceNumber}
+}
+
+func (m viewMessage) Save() (Message, error) {
+	return m.SaveContext(context.Background())
+}
+
+// SaveContext implements the SaveContext method on the ContextMessage
+// interface
+func (m viewMessage) SaveContext(ctx context.Context) (Message, error) {
+	saved, err := SaveMessage(ctx, m.Message)
+	if err != nil {
+		return saved, err
+	}
+	return viewMessage{saved, m.sequenceNumber}, nil
+}
+
+// SetFlags implements the SetFlags method on the MessageV2 interface
+func (m viewMessage) SetFlags(flags types.Flags) (Message, error) {
+	return m.SetFlagsContext(context.Background(), flags)
+}
+
+// SetFlagsContext implements the SetFlagsContext method on the
+// ContextMessage interface, checking the flags as the underlying message
+// does
+func (m viewMessage) SetFlagsContext(ctx context.Context, flags types.Flags) (Message, error) {
+	updated, err := UpdateFlags(ctx, m.Message, types.StoreReplace, flags)
+	if err != nil {
+		return updated, err
+	}
+	return viewMessage{updated, m.sequenceNumber}, nil
+}
+
+// SetKeywords implements the SetKeywords method on the KeywordMessage
+// interface. Messages which can't hold keywords are left as they are.
+func (m viewMessage) SetKeywords(keywords []string) Message {
+	if keyworded, ok := m.Message.(KeywordMessage); ok {
+		return viewMessage{keyworded.SetKeywords(keywords), m.sequenceNumber}
+	}
+	return m
+}
+
+// Raw implements the Raw method on the RawMessage interface
+func (m viewMessage) Raw() ([]byte, error) {
+	return m.RawContext(context.Background())
+}
+
+// RawContext implements the RawContext method on the ContextRawMessage
+// interface
+func (m viewMessage) RawContext(ctx context.Context) ([]byte, error) {
+	if raw, ok := m.Message.(RawMessage); ok {
+		return Raw(ctx, raw)
+	}
+	return nil, ErrNotSupported
+}
+
+// SaveDate implements the SaveDate method on the SaveDateMessage interface
+func (m viewMessage) SaveDate() (time.Time, bool) {
+	if saved, ok := m.Message.(SaveDateMessage); ok {
+		return saved.SaveDate()
+	}
+	return time.Time{}, false
+}
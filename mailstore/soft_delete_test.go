@@ -0,0 +1,216 @@
+package mailstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+func TestSoftDeleteView(t *testing.T) {
+	inbox := getDefaultInbox(t)
+	if _, err := inbox.MessageByUID(11).AddFlags(types.FlagDeleted).Save(); err != nil {
+		t.Fatalf("Error flagging message: %s", err)
+	}
+
+	view := NewSoftDeleteView(inbox)
+	if view.Messages() != 2 || view.Recent() != 2 {
+		t.Errorf("Expected 2 messages, 2 recent, got %d, %d", view.Messages(), view.Recent())
+	}
+	if view.MessageByUID(11) != nil {
+		t.Errorf("Expected the deleted message to be hidden")
+	}
+
+	msg := view.MessageBySequenceNumber(2)
+	if msg == nil || msg.UID() != 12 || msg.SequenceNumber() != 2 {
+		t.Fatalf("Expected message 2 to be UID 12, got %v", msg)
+	}
+	assertMessageUIDs(t, view.MessageSetBySequenceNumber(types.SequenceSet{{Min: "2", Max: "*"}}), []uint32{12})
+	assertMessageUIDs(t, view.MessageSetByUID(types.UIDSet{{Min: "*"}}), []uint32{12})
+	assertMessageUIDs(t, view.MessageSetByUID(types.UIDSet{{Min: "10", Max: "12"}}), []uint32{10, 12})
+
+	// Messages deleted while the view is in use stay visible
+	saved, err := msg.AddFlags(types.FlagDeleted).Save()
+	if err != nil {
+		t.Fatalf("Error flagging message: %s", err)
+	}
+	if saved.SequenceNumber() != 2 {
+		t.Errorf("Expected saved message to keep sequence number 2, got %d", saved.SequenceNumber())
+	}
+	reloaded := view.Reload(getMailbox(t, inbox))
+	if reloaded.Messages() != 2 {
+		t.Errorf("Expected 2 messages after reloading, got %d", reloaded.Messages())
+	}
+	if !reloaded.MessageByUID(12).Flags().HasFlags(types.FlagDeleted) {
+		t.Errorf("Expected the reloaded view to see the new flag")
+	}
+	if NewSoftDeleteView(getMailbox(t, inbox)).Messages() != 1 {
+		t.Errorf("Expected a new view to hide both deleted messages")
+	}
+}
+
+// Fetch a fresh copy of a dummy mailbox
+func getMailbox(t *testing.T, m DummyMailbox) Mailbox {
+	mailbox, err := m.mailstore.User.MailboxByName(m.Name())
+	if err != nil {
+		t.Fatalf("Error getting mailbox: %s", err)
+	}
+	return mailbox
+}
+
+// A mailbox which finds every message in a search, recording the criteria
+type recordingSearchMailbox struct {
+	DummyMailbox
+	criteria *types.SearchCriteria
+}
+
+func (m recordingSearchMailbox) Search(criteria types.SearchCriteria) ([]uint32, error) {
+	*m.criteria = criteria
+	return []uint32{10, 11, 12}, nil
+}
+
+func TestSoftDeleteViewSearch(t *testing.T) {
+	inbox := getDefaultInbox(t)
+	if _, err := inbox.MessageByUID(11).AddFlags(types.FlagDeleted).Save(); err != nil {
+		t.Fatalf("Error flagging message: %s", err)
+	}
+	var criteria types.SearchCriteria
+	view := NewSoftDeleteView(recordingSearchMailbox{getMailbox(t, inbox).(DummyMailbox), &criteria})
+
+	set, _ := types.InterpretSequenceSet("2")
+	uids, err := view.Search(types.SearchCriteria{Key: "NOT", Children: []types.SearchCriteria{{Key: "SEQUENCE", Set: set}}})
+	if err != nil {
+		t.Fatalf("Error searching: %s", err)
+	}
+	if len(uids) != 2 || uids[0] != 10 || uids[1] != 12 {
+		t.Errorf("Expected the hidden message to be left out, got %v", uids)
+	}
+	if key := criteria.Children[0]; key.Key != "UID" || key.UIDs.String() != "12" {
+		t.Errorf("Expected sequence number 2 to be searched for as UID 12, got %+v", key)
+	}
+}
+
+func TestSoftDeleteViewOptionalInterfaces(t *testing.T) {
+	inbox := getDefaultInbox(t)
+	if _, err := inbox.MessageByUID(11).AddFlags(types.FlagDeleted).Save(); err != nil {
+		t.Fatalf("Error flagging message: %s", err)
+	}
+	view := NewSoftDeleteView(getMailbox(t, inbox))
+
+	if view.ChangeLog() != inbox.ChangeLog() {
+		t.Errorf("Expected the view to share the mailbox's change log")
+	}
+	expectedSize := uint64(inbox.MessageByUID(10).Size() + inbox.MessageByUID(12).Size())
+	if size := view.Size(); size != expectedSize {
+		t.Errorf("Expected the visible messages to take %d octets, got %d", expectedSize, size)
+	}
+	if err := view.CopyMessages([]uint32{10}, inbox); err != ErrNotSupported {
+		t.Errorf("Expected copying to be unsupported, got %v", err)
+	}
+
+	keyworded, ok := view.MessageByUID(10).(KeywordMessage)
+	if !ok {
+		t.Fatalf("Expected messages in the view to hold keywords")
+	}
+	msg := keyworded.SetKeywords([]string{"$Work"})
+	if msg.SequenceNumber() != 1 || len(msg.Keywords()) != 1 {
+		t.Errorf("Expected message 1 to gain a keyword, got %d %v", msg.SequenceNumber(), msg.Keywords())
+	}
+
+	claimed, err := view.ClaimRecent()
+	if err != nil {
+		t.Fatalf("Error claiming recent messages: %s", err)
+	}
+	if len(claimed) != 2 || claimed[0] != 10 || claimed[1] != 12 {
+		t.Errorf("Expected the visible messages to be claimed, got %v", claimed)
+	}
+}
+
+// A mailbox which always shows the latest copy of a dummy mailbox
+type liveMailbox struct {
+	Mailbox
+}
+
+func TestSoftDeleteViewSeesReplacedMessages(t *testing.T) {
+	inbox := getDefaultInbox(t)
+	live := &liveMailbox{inbox}
+	view := NewSoftDeleteView(live)
+	if view.MessageByUID(10) == nil {
+		t.Fatalf("Expected message 10 to be visible")
+	}
+
+	// Expunge one message and add another, leaving the count unchanged
+	if err := inbox.Expunge([]uint32{10}); err != nil {
+		t.Fatalf("Error expunging message: %s", err)
+	}
+	if _, err := getMailbox(t, inbox).NewMessage().SetBody("New").Save(); err != nil {
+		t.Fatalf("Error adding message: %s", err)
+	}
+	live.Mailbox = getMailbox(t, inbox)
+
+	if view.MessageByUID(10) != nil {
+		t.Errorf("Expected the expunged message to be gone")
+	}
+	msg := view.MessageByUID(13)
+	if msg == nil || msg.SequenceNumber() != 3 {
+		t.Errorf("Expected the new message to be message 3, got %v", msg)
+	}
+}
+
+func TestSoftDeleteViewContextInterfaces(t *testing.T) {
+	var view Mailbox = NewSoftDeleteView(getDefaultInbox(t))
+	if _, ok := view.(ContextMailbox); !ok {
+		t.Errorf("Expected the view to implement ContextMailbox")
+	}
+	if _, ok := view.(MailboxV2); !ok {
+		t.Errorf("Expected the view to implement MailboxV2")
+	}
+	if _, ok := view.(ContextSearchableMailbox); !ok {
+		t.Errorf("Expected the view to implement ContextSearchableMailbox")
+	}
+	if _, ok := view.(ContextExpungeMailbox); !ok {
+		t.Errorf("Expected the view to implement ContextExpungeMailbox")
+	}
+	if _, ok := view.(ContextRecentMailbox); !ok {
+		t.Errorf("Expected the view to implement ContextRecentMailbox")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewSoftDeleteView(cancellingMailbox{getDefaultInbox(t)})
+	if _, err := MessageCount(ctx, slow); err != context.Canceled {
+		t.Errorf("Expected counting to stop when cancelled, got %v", err)
+	}
+}
+
+// A mailbox whose messages can't be counted once the context is done
+type cancellingMailbox struct {
+	Mailbox
+}
+
+func (m cancellingMailbox) MessageCountContext(ctx context.Context) (uint32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.Messages(), nil
+}
+
+func (m cancellingMailbox) MessageBySequenceNumberContext(ctx context.Context, seqno uint32) (Message, error) {
+	return m.MessageBySequenceNumber(seqno), ctx.Err()
+}
+
+func (m cancellingMailbox) MessageByUIDContext(ctx context.Context, uid uint32) (Message, error) {
+	return m.MessageByUID(uid), ctx.Err()
+}
+
+func (m cancellingMailbox) MessageSetByUIDContext(ctx context.Context, set types.UIDSet) ([]Message, error) {
+	return m.MessageSetByUID(set), ctx.Err()
+}
+
+func (m cancellingMailbox) MessageSetBySequenceNumberContext(ctx context.Context, set types.SequenceSet) ([]Message, error) {
+	return m.MessageSetBySequenceNumber(set), ctx.Err()
+}
+
+func (m cancellingMailbox) NewMessageContext(ctx context.Context) (Message, error) {
+	return m.NewMessage(), ctx.Err()
+}
@@ -0,0 +1,34 @@
+package mailstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// ErrNoSentMailbox is returned when a user has no mailbox for sent messages
+var ErrNoSentMailbox = errors.New("No Sent mailbox")
+
+// SentMailbox finds the mailbox into which a user's sent messages should be
+// filed. A mailbox with the \Sent special-use attribute is preferred,
+// otherwise a mailbox named "Sent" is used.
+func SentMailbox(ctx context.Context, user User) (Mailbox, error) {
+	mailboxes, err := Mailboxes(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	var named Mailbox
+	for _, mailbox := range mailboxes {
+		if special, ok := mailbox.(SpecialUseMailbox); ok && special.SpecialUse() == `\Sent` {
+			return mailbox, nil
+		}
+		if util.MailboxNamesEqual(mailbox.Name(), "Sent") {
+			named = mailbox
+		}
+	}
+	if named == nil {
+		return nil, ErrNoSentMailbox
+	}
+	return named, nil
+}
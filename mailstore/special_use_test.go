@@ -0,0 +1,38 @@
+package mailstore
+
+import (
+	"context"
+	"testing"
+)
+
+// A user whose only mailboxes have no special-use attributes
+type namedMailboxUser struct {
+	DummyUser
+}
+
+func (u namedMailboxUser) Mailboxes() []Mailbox {
+	return []Mailbox{newDummyMailbox("INBOX"), newDummyMailbox("Sent")}
+}
+
+func TestSentMailbox(t *testing.T) {
+	m := NewDummyMailstore()
+	mailbox, err := SentMailbox(context.Background(), m.User)
+	if err != nil {
+		t.Fatalf("Error finding Sent mailbox: %s\n", err)
+	}
+	if mailbox.(DummyMailbox).ID != 2 {
+		t.Errorf("Expected the \\Sent mailbox, got %s\n", mailbox.Name())
+	}
+
+	mailbox, err = SentMailbox(context.Background(), namedMailboxUser{m.User})
+	if err != nil {
+		t.Fatalf("Error finding mailbox named Sent: %s\n", err)
+	}
+	if mailbox.Name() != "Sent" {
+		t.Errorf("Expected mailbox named Sent, got %s\n", mailbox.Name())
+	}
+
+	if _, err = SentMailbox(context.Background(), DummyUser{}); err != ErrNoSentMailbox {
+		t.Errorf("Expected ErrNoSentMailbox, got %v\n", err)
+	}
+}
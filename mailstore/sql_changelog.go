@@ -0,0 +1,119 @@
+package mailstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// The statements creating the table SQLChangeLog keeps its changes in,
+// which is shared by every mailbox
+var sqlChangeLogSchema = []string{
+	`CREATE TABLE IF NOT EXISTS mailbox_changes (
+		mailbox TEXT NOT NULL,
+		modseq INTEGER NOT NULL,
+		type INTEGER NOT NULL,
+		uid INTEGER NOT NULL,
+		flags INTEGER NOT NULL,
+		time INTEGER NOT NULL,
+		PRIMARY KEY (mailbox, modseq)
+	)`,
+	`CREATE INDEX IF NOT EXISTS mailbox_changes_uid ON mailbox_changes (mailbox, uid, modseq)`,
+}
+
+// SQLChangeLog is a ChangeLog kept in a SQLite database, so that it persists
+// across restarts. It is written against database/sql, and the caller opens
+// the database with the driver of their choice (eg github.com/mattn/go-sqlite3
+// or modernc.org/sqlite), so this package doesn't depend on one. The logs of
+// any number of mailboxes may share a database, each identified by a key
+// chosen by the mailstore, which should stay the same when the mailbox is
+// renamed. The latest mod-sequence of each message is indexed by UID.
+type SQLChangeLog struct {
+	db      *sql.DB
+	mailbox string
+}
+
+// NewSQLChangeLog creates the change log for a mailbox in the given database,
+// creating the table it's kept in if it doesn't exist yet. An in-memory
+// SQLite database is private to one connection, so a *sql.DB opened on
+// ":memory:" must be limited to a single connection with SetMaxOpenConns.
+func NewSQLChangeLog(db *sql.DB, mailbox string) (*SQLChangeLog, error) {
+	for _, statement := range sqlChangeLogSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLChangeLog{db: db, mailbox: mailbox}, nil
+}
+
+// Append implements the Append method on the ChangeLog interface
+func (l *SQLChangeLog) Append(change Change) (uint64, error) {
+	if change.Time.IsZero() {
+		change.Time = time.Now()
+	}
+
+	// The next mod-sequence is worked out by the insert itself, which SQLite
+	// runs while holding the database's write lock, so two sessions
+	// appending at once can't be given the same one
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO mailbox_changes (mailbox, modseq, type, uid, flags, time)
+		SELECT ?, COALESCE(MAX(modseq), 0) + 1, ?, ?, ?, ? FROM mailbox_changes WHERE mailbox = ?`,
+		l.mailbox, int(change.Type), int64(change.UID), int64(change.Flags), change.Time.UnixNano(), l.mailbox)
+	if err != nil {
+		return 0, err
+	}
+	var modseq uint64
+	err = tx.QueryRow(`SELECT MAX(modseq) FROM mailbox_changes WHERE mailbox = ?`, l.mailbox).Scan(&modseq)
+	if err != nil {
+		return 0, err
+	}
+	return modseq, tx.Commit()
+}
+
+// Since implements the Since method on the ChangeLog interface
+func (l *SQLChangeLog) Since(modseq uint64) ([]Change, error) {
+	rows, err := l.db.Query(`SELECT modseq, type, uid, flags, time FROM mailbox_changes
+		WHERE mailbox = ? AND modseq > ? ORDER BY modseq`, l.mailbox, int64(modseq))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var change Change
+		var changeType int
+		var uid, flags, nanoseconds int64
+		if err := rows.Scan(&change.ModSeq, &changeType, &uid, &flags, &nanoseconds); err != nil {
+			return nil, err
+		}
+		change.Type = ChangeType(changeType)
+		change.UID = uint32(uid)
+		change.Flags = types.Flags(flags)
+		change.Time = time.Unix(0, nanoseconds)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// HighestModSeq implements the HighestModSeq method on the ChangeLog interface
+func (l *SQLChangeLog) HighestModSeq() (uint64, error) {
+	var modseq uint64
+	err := l.db.QueryRow(`SELECT COALESCE(MAX(modseq), 0) FROM mailbox_changes WHERE mailbox = ?`,
+		l.mailbox).Scan(&modseq)
+	return modseq, err
+}
+
+// MessageModSeq implements the MessageModSeq method on the ModSeqIndex
+// interface
+func (l *SQLChangeLog) MessageModSeq(uid uint32) (uint64, error) {
+	var modseq uint64
+	err := l.db.QueryRow(`SELECT COALESCE(MAX(modseq), 0) FROM mailbox_changes
+		WHERE mailbox = ? AND uid = ? AND type != ?`, l.mailbox, int64(uid), int(ChangeExpunge)).Scan(&modseq)
+	return modseq, err
+}
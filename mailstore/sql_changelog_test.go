@@ -0,0 +1,72 @@
+package mailstore
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// Open an in-memory SQLite database, if a SQLite driver has been linked into
+// the test binary, eg by a file with a blank import of one
+func openTestSQLite(t *testing.T) *sql.DB {
+	for _, driver := range sql.Drivers() {
+		if driver != "sqlite3" && driver != "sqlite" {
+			continue
+		}
+		db, err := sql.Open(driver, ":memory:")
+		if err != nil {
+			t.Fatalf("Error opening database: %s", err)
+		}
+		db.SetMaxOpenConns(1)
+		return db
+	}
+	t.Skip("No SQLite driver is registered")
+	return nil
+}
+
+func TestSQLChangeLog(t *testing.T) {
+	db := openTestSQLite(t)
+	defer db.Close()
+
+	log, err := NewSQLChangeLog(db, "INBOX")
+	if err != nil {
+		t.Fatalf("Error creating change log: %s", err)
+	}
+	if modseq, _ := log.HighestModSeq(); modseq != 0 {
+		t.Errorf("Expected empty log to have highest modseq 0, got %d", modseq)
+	}
+
+	log.Append(Change{Type: ChangeAppend, UID: 10})
+	log.Append(Change{Type: ChangeFlags, UID: 10, Flags: types.FlagSeen})
+	modseq, err := log.Append(Change{Type: ChangeExpunge, UID: 10})
+	if err != nil || modseq != 3 {
+		t.Errorf("Expected third change to have modseq 3, got %d (%v)", modseq, err)
+	}
+
+	changes, _ := log.Since(1)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes since modseq 1, got %d", len(changes))
+	}
+	if changes[0].ModSeq != 2 || changes[0].Type != ChangeFlags || changes[0].Flags != types.FlagSeen {
+		t.Errorf("Expected seen flag change with modseq 2, got %+v", changes[0])
+	}
+	if changes[0].Time.IsZero() {
+		t.Errorf("Expected the change's time to be recorded")
+	}
+	if modseq, _ := MessageModSeq(log, 10); modseq != 2 {
+		t.Errorf("Expected the expunge of UID 10 to be ignored, got modseq %d", modseq)
+	}
+
+	// Another mailbox in the same database has its own mod-sequences
+	other, err := NewSQLChangeLog(db, "Sent")
+	if err != nil {
+		t.Fatalf("Error creating second change log: %s", err)
+	}
+	if modseq, _ := other.Append(Change{Type: ChangeAppend, UID: 10}); modseq != 1 {
+		t.Errorf("Expected first change to another mailbox to have modseq 1, got %d", modseq)
+	}
+	if modseq, _ := log.HighestModSeq(); modseq != 3 {
+		t.Errorf("Expected the first mailbox to keep highest modseq 3, got %d", modseq)
+	}
+}
@@ -0,0 +1,185 @@
+package mailstore
+
+import (
+	"context"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// The original User, Mailbox and Message interfaces assume that listing
+// mailboxes, counting and looking up messages and changing flags can't fail,
+// which is only true of stores held in memory. A database- or network-backed
+// store implements the V2 interfaces below as well, and the server then uses
+// their methods in place of the originals so that a failure is reported to
+// the client rather than passed off as an empty mailbox. Errors such as
+// ErrUnavailable are sent to the client with their response codes.
+//
+// The functions at the end of this file call whichever version of a method
+// the store implements, preferring those of the Context interfaces, and
+// should be used instead of calling any version directly. The Context
+// interfaces cover every operation the V2 interfaces do, so a new store need
+// only implement those; the V2 interfaces remain for stores which have no
+// use for a context.
+//
+// Some operations remain infallible in every version:
+//
+//   - Mailbox.Name, NextUID, LastUID, Recent and Unseen describe the mailbox
+//     as it was when it was looked up, which may fail, so a store should
+//     load them along with the mailbox rather than on each call. The server
+//     looks a mailbox up again whenever it needs to see changes.
+//   - Message.UID, SequenceNumber, Size, Header, Body, Flags, Keywords and
+//     InternalDate likewise describe the message as it was looked up.
+//   - Message.Save already returns an error, so it has no V2 version;
+//     SaveMessage gives it a context where the store supports one.
+
+// UserV2 is an optional interface which may be implemented by a User whose
+// mailboxes can't always be listed
+type UserV2 interface {
+	User
+
+	// Return a list of mailboxes belonging to this user
+	ListMailboxes() ([]Mailbox, error)
+}
+
+// MailboxV2 is an optional interface which may be implemented by a Mailbox
+// whose messages can't always be counted or read
+type MailboxV2 interface {
+	Mailbox
+
+	// Number of messages in the mailbox
+	MessageCount() (uint32, error)
+
+	// Get a message by its sequence number, or nil if there is none
+	LookupMessageBySequenceNumber(seqno uint32) (Message, error)
+
+	// Get a message by its UID, or nil if there is none
+	LookupMessageByUID(uid uint32) (Message, error)
+
+	// Get messages that belong to a set of ranges of UIDs
+	LookupMessageSetByUID(set types.UIDSet) ([]Message, error)
+
+	// Get messages that belong to a set of ranges of sequence numbers
+	LookupMessageSetBySequenceNumber(set types.SequenceSet) ([]Message, error)
+
+	// Creates a new (empty) message that belongs to this mailbox. As with
+	// NewMessage, the mailbox is unchanged until the message is saved.
+	CreateMessage() (Message, error)
+}
+
+// MessageV2 is an optional interface which may be implemented by a Message
+// whose flags are checked as they're changed, eg against the flags its
+// mailbox can store
+type MessageV2 interface {
+	Message
+
+	// Replace the flags for this message and return the updated message.
+	// The change isn't stored until the message is saved.
+	SetFlags(types.Flags) (Message, error)
+}
+
+// Mailboxes returns a list of mailboxes belonging to the user
+func Mailboxes(ctx context.Context, u User) ([]Mailbox, error) {
+	if uc, ok := u.(ContextUser); ok {
+		return uc.MailboxesContext(ctx)
+	}
+	if u2, ok := u.(UserV2); ok {
+		return u2.ListMailboxes()
+	}
+	return u.Mailboxes(), nil
+}
+
+// MessageCount returns the number of messages in the mailbox
+func MessageCount(ctx context.Context, m Mailbox) (uint32, error) {
+	if mc, ok := m.(ContextMailbox); ok {
+		return mc.MessageCountContext(ctx)
+	}
+	if m2, ok := m.(MailboxV2); ok {
+		return m2.MessageCount()
+	}
+	return m.Messages(), nil
+}
+
+// MessageBySequenceNumber returns the message with the given sequence
+// number, or nil if there is none
+func MessageBySequenceNumber(ctx context.Context, m Mailbox, seqno uint32) (Message, error) {
+	if mc, ok := m.(ContextMailbox); ok {
+		return mc.MessageBySequenceNumberContext(ctx, seqno)
+	}
+	if m2, ok := m.(MailboxV2); ok {
+		return m2.LookupMessageBySequenceNumber(seqno)
+	}
+	return m.MessageBySequenceNumber(seqno), nil
+}
+
+// MessageByUID returns the message with the given UID, or nil if there is
+// none
+func MessageByUID(ctx context.Context, m Mailbox, uid uint32) (Message, error) {
+	if mc, ok := m.(ContextMailbox); ok {
+		return mc.MessageByUIDContext(ctx, uid)
+	}
+	if m2, ok := m.(MailboxV2); ok {
+		return m2.LookupMessageByUID(uid)
+	}
+	return m.MessageByUID(uid), nil
+}
+
+// MessageSetByUID returns the messages in the mailbox with UIDs in the set
+func MessageSetByUID(ctx context.Context, m Mailbox, set types.UIDSet) ([]Message, error) {
+	if mc, ok := m.(ContextMailbox); ok {
+		return mc.MessageSetByUIDContext(ctx, set)
+	}
+	if m2, ok := m.(MailboxV2); ok {
+		return m2.LookupMessageSetByUID(set)
+	}
+	return m.MessageSetByUID(set), nil
+}
+
+// MessageSetBySequenceNumber returns the messages in the mailbox with
+// sequence numbers in the set
+func MessageSetBySequenceNumber(ctx context.Context, m Mailbox, set types.SequenceSet) ([]Message, error) {
+	if mc, ok := m.(ContextMailbox); ok {
+		return mc.MessageSetBySequenceNumberContext(ctx, set)
+	}
+	if m2, ok := m.(MailboxV2); ok {
+		return m2.LookupMessageSetBySequenceNumber(set)
+	}
+	return m.MessageSetBySequenceNumber(set), nil
+}
+
+// NewMessage returns a new (empty) message belonging to the mailbox
+func NewMessage(ctx context.Context, m Mailbox) (Message, error) {
+	if mc, ok := m.(ContextMailbox); ok {
+		return mc.NewMessageContext(ctx)
+	}
+	if m2, ok := m.(MailboxV2); ok {
+		return m2.CreateMessage()
+	}
+	return m.NewMessage(), nil
+}
+
+// UpdateFlags adds flags to a message, removes them or replaces its flags
+// with them, and returns the updated message
+func UpdateFlags(ctx context.Context, m Message, op types.StoreOperation, flags types.Flags) (Message, error) {
+	mc, isContext := m.(ContextMessage)
+	m2, isV2 := m.(MessageV2)
+	if isContext || isV2 {
+		switch op {
+		case types.StoreAdd:
+			flags = m.Flags() | flags
+		case types.StoreRemove:
+			flags = m.Flags() &^ flags
+		}
+		if isContext {
+			return mc.SetFlagsContext(ctx, flags)
+		}
+		return m2.SetFlags(flags)
+	}
+
+	switch op {
+	case types.StoreAdd:
+		return m.AddFlags(flags), nil
+	case types.StoreRemove:
+		return m.RemoveFlags(flags), nil
+	}
+	return m.OverwriteFlags(flags), nil
+}
@@ -0,0 +1,61 @@
+package mailstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// A user whose mailboxes are on a server which can't be reached
+type unreachableUser struct {
+	DummyUser
+}
+
+func (u unreachableUser) ListMailboxes() ([]Mailbox, error) {
+	return nil, ErrUnavailable
+}
+
+// A message which refuses to be flagged \Flagged
+type unflaggableMessage struct {
+	DummyMessage
+}
+
+func (m unflaggableMessage) SetFlags(flags types.Flags) (Message, error) {
+	if flags.HasFlags(types.FlagFlagged) {
+		return nil, ErrLimit
+	}
+	return unflaggableMessage{m.OverwriteFlags(flags).(DummyMessage)}, nil
+}
+
+func TestMailboxes(t *testing.T) {
+	m := NewDummyMailstore()
+	mailboxes, err := Mailboxes(context.Background(), m.User)
+	if err != nil || len(mailboxes) != len(m.User.Mailboxes()) {
+		t.Errorf("Expected the user's mailboxes, got %d, %v\n", len(mailboxes), err)
+	}
+
+	if _, err := Mailboxes(context.Background(), unreachableUser{m.User}); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable from a UserV2, got %v\n", err)
+	}
+	if _, err := SentMailbox(context.Background(), unreachableUser{m.User}); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable finding the Sent mailbox, got %v\n", err)
+	}
+}
+
+func TestUpdateFlags(t *testing.T) {
+	msg := DummyMessage{flags: types.FlagSeen}
+	updated, err := UpdateFlags(context.Background(), msg, types.StoreAdd, types.FlagAnswered)
+	if err != nil || updated.Flags() != types.FlagSeen|types.FlagAnswered {
+		t.Errorf("Expected \\Seen and \\Answered, got %v, %v\n", updated.Flags(), err)
+	}
+
+	v2 := unflaggableMessage{DummyMessage{flags: types.FlagSeen | types.FlagDraft}}
+	updated, err = UpdateFlags(context.Background(), v2, types.StoreRemove, types.FlagDraft)
+	if err != nil || updated.Flags() != types.FlagSeen {
+		t.Errorf("Expected \\Seen from a MessageV2, got %v, %v\n", updated.Flags(), err)
+	}
+	if _, err = UpdateFlags(context.Background(), v2, types.StoreAdd, types.FlagFlagged); err != ErrLimit {
+		t.Errorf("Expected the MessageV2's error, got %v\n", err)
+	}
+}
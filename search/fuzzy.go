@@ -0,0 +1,180 @@
+package search
+
+import (
+	"net/textproto"
+	"strings"
+	"unicode"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// Ranker scores how relevant a message is to a text search key under FUZZY
+// (RFC 6203), eg SUBJECT "holiday" or TEXT "invoice", from 1 (barely
+// relevant) to 100. A score of 0 means the message doesn't match.
+type Ranker func(key types.SearchCriteria, m *Message) int
+
+// The keys which search text, and may be matched approximately under FUZZY
+var textKeys = map[string]bool{
+	"BCC": true, "BODY": true, "CC": true, "FROM": true, "HEADER": true,
+	"SUBJECT": true, "TEXT": true, "TO": true,
+}
+
+// SearchedText returns the decoded text of a message which a text search
+// key searches, for rankers which don't keep their own index
+func (m *Message) SearchedText(key types.SearchCriteria) string {
+	switch key.Key {
+	case "BODY":
+		return m.parse().bodyText
+	case "TEXT":
+		return m.parse().headerText + "\n" + m.parse().bodyText
+	case "HEADER":
+		return decodedValues(m.parse().header, key.Header)
+	}
+	return decodedValues(m.parse().header, key.Key)
+}
+
+func decodedValues(header textproto.MIMEHeader, field string) string {
+	values := header[textproto.CanonicalMIMEHeaderKey(field)]
+	decoded := make([]string, len(values))
+	for i, value := range values {
+		decoded[i] = DecodeHeader(value)
+	}
+	return strings.Join(decoded, "\n")
+}
+
+// DefaultRanker matches each word of the searched string against the words
+// of the message's text, allowing for small misspellings. A message scores
+// 100 if every word is found exactly, less for each word that is only close
+// to one in the text, and 0 if any word is missing.
+func DefaultRanker(key types.SearchCriteria, m *Message) int {
+	wanted := words(key.Value)
+	if len(wanted) == 0 {
+		return 100
+	}
+	text := words(m.SearchedText(key))
+
+	total := 0
+	for _, word := range wanted {
+		best := 0
+		for _, candidate := range text {
+			if score := wordSimilarity(word, candidate); score > best {
+				best = score
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total / len(wanted)
+}
+
+// Split text into lower case words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Score how closely a word of the text matches a searched word: 100 if it
+// contains it, less for each edit needed to make them equal, or 0 if they
+// differ by more than a word of its length allows
+func wordSimilarity(wanted string, candidate string) int {
+	if strings.Contains(candidate, wanted) {
+		return 100
+	}
+	allowed := len([]rune(wanted)) / 4
+	if allowed == 0 {
+		return 0
+	}
+	distance := editDistance([]rune(wanted), []rune(candidate))
+	if distance > allowed {
+		return 0
+	}
+	return 100 - 100*distance/(allowed+1)
+}
+
+// The Levenshtein distance between two words
+func editDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// Relevancy scores how relevant a message is to the FUZZY keys of the
+// criteria, from 1 to 100, or returns 0 if it doesn't match them. Messages
+// are given the lowest score of the keys they must all match, and the
+// highest of those where either will do. A message matching criteria with
+// no FUZZY keys scores 100.
+func Relevancy(criteria types.SearchCriteria, m *Message, ctx Context) int {
+	switch criteria.Key {
+	case "AND":
+		score := 100
+		for _, child := range criteria.Children {
+			if childScore := Relevancy(child, m, ctx); childScore < score {
+				score = childScore
+			}
+			if score == 0 {
+				break
+			}
+		}
+		return score
+	case "OR":
+		first, second := Relevancy(criteria.Children[0], m, ctx), Relevancy(criteria.Children[1], m, ctx)
+		if second > first {
+			return second
+		}
+		return first
+	case "NOT":
+		if Matches(criteria.Children[0], m, ctx) {
+			return 0
+		}
+		return 100
+	case "FUZZY":
+		return fuzzyRelevancy(criteria.Children[0], m, ctx)
+	}
+	if matchesKey(criteria, m, ctx) {
+		return 100
+	}
+	return 0
+}
+
+// Score the key given to FUZZY, whose text keys are ranked rather than
+// matched exactly
+func fuzzyRelevancy(key types.SearchCriteria, m *Message, ctx Context) int {
+	if !textKeys[key.Key] {
+		return Relevancy(key, m, ctx)
+	}
+	rank := ctx.Ranker
+	if rank == nil {
+		rank = DefaultRanker
+	}
+	score := rank(key, m)
+	if score > 100 {
+		return 100
+	}
+	if score < 0 {
+		return 0
+	}
+	return score
+}
@@ -0,0 +1,145 @@
+package search
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// Message holds what is needed to evaluate search criteria against a
+// message. Only the raw message is examined for header and text keys, so
+// any mailstore able to supply the bytes of its messages can be searched.
+type Message struct {
+	Raw            []byte // The message as sent, headers and body
+	UID            uint32
+	SequenceNumber uint32
+	Size           uint32
+	Flags          types.Flags
+	Keywords       []string
+	InternalDate   time.Time
+
+	// The date the message was saved to the mailbox, if SaveDateSupported
+	// is true. If the save date is unknown the internal date should be
+	// given instead.
+	SaveDate          time.Time
+	SaveDateSupported bool
+
+	// The mod-sequence of the last change to the message, matched by the
+	// MODSEQ key, or 0 if the mailbox doesn't keep mod-sequences
+	ModSeq uint64
+
+	// How relevant the message is to the FUZZY keys of a search, from 1 to
+	// 100, by which the RELEVANCY sort key orders messages
+	Relevancy int
+
+	parsed *parsedMessage
+}
+
+// The parts of a message examined by header and text keys
+type parsedMessage struct {
+	header     textproto.MIMEHeader
+	headerText string // The header with encoded words decoded
+	bodyText   string // The decoded text of every text part
+}
+
+// Parse the raw message the first time it is needed
+func (m *Message) parse() *parsedMessage {
+	if m.parsed != nil {
+		return m.parsed
+	}
+	m.parsed = &parsedMessage{header: make(textproto.MIMEHeader)}
+
+	msg, err := types.MessageFromBytes(m.Raw)
+	if err != nil {
+		m.parsed.bodyText = string(m.Raw)
+		return m.parsed
+	}
+	m.parsed.header = msg.Headers
+
+	var headerText bytes.Buffer
+	for field, values := range msg.Headers {
+		for _, value := range values {
+			fmt.Fprintf(&headerText, "%s: %s\r\n", field, DecodeHeader(value))
+		}
+	}
+	m.parsed.headerText = headerText.String()
+
+	var bodyText bytes.Buffer
+	appendText(&bodyText, msg)
+	m.parsed.bodyText = bodyText.String()
+	return m.parsed
+}
+
+var headerDecoder = new(mime.WordDecoder)
+
+// DecodeHeader decodes any RFC 2047 encoded words in a header value, so
+// that searches for non-ASCII text can match it
+func DecodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// Append the decoded text of a message part to the buffer, descending into
+// multipart and message/rfc822 parts. Non-text parts (eg attachments) are
+// skipped.
+func appendText(text *bytes.Buffer, part types.RFC2822Message) {
+	mediaType, params, err := mime.ParseMediaType(part.Headers.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(strings.NewReader(part.Body), params["boundary"])
+		for {
+			p, err := reader.NextRawPart()
+			if err != nil {
+				return
+			}
+			var body bytes.Buffer
+			if _, err := body.ReadFrom(p); err != nil {
+				return
+			}
+			appendText(text, types.RFC2822Message{Headers: textproto.MIMEHeader(p.Header), Body: body.String()})
+		}
+
+	case mediaType == "message/rfc822":
+		body, err := part.DecodedBody()
+		if err != nil {
+			return
+		}
+		if inner, err := types.MessageFromBytes(body); err == nil {
+			appendText(text, inner)
+		}
+
+	case strings.HasPrefix(mediaType, "text/"):
+		body, err := part.DecodedBody()
+		if err != nil {
+			body = []byte(part.Body)
+		}
+		text.WriteString(toUTF8(body, params["charset"]))
+		text.WriteString("\r\n")
+	}
+}
+
+// Convert text in the given charset to UTF-8. Only ISO-8859-1 needs
+// converting; other charsets are assumed to be ASCII compatible.
+func toUTF8(text []byte, charset string) string {
+	if !strings.EqualFold(charset, "ISO-8859-1") && !strings.EqualFold(charset, "latin1") {
+		return string(text)
+	}
+	runes := make([]rune, len(text))
+	for i, b := range text {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,150 @@
+// Package search evaluates the criteria of a SEARCH command against
+// messages, by parsing their raw RFC 822 text. Mailstores which can't match
+// messages themselves get SEARCH support from it for free.
+package search
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// Context is the state of the mailbox needed to evaluate "*" in sequence
+// sets, and how FUZZY keys are ranked
+type Context struct {
+	LastSequenceNumber uint32
+	LastUID            uint32
+
+	// Scores messages against text keys under FUZZY, or nil for
+	// DefaultRanker
+	Ranker Ranker
+}
+
+// Matches returns true if a message matches the search criteria
+func Matches(criteria types.SearchCriteria, m *Message, ctx Context) bool {
+	return criteria.Matches(func(key types.SearchCriteria) bool {
+		return matchesKey(key, m, ctx)
+	})
+}
+
+// Test a message against a single key which doesn't combine other keys
+func matchesKey(criteria types.SearchCriteria, m *Message, ctx Context) bool {
+	flags := m.Flags
+
+	switch criteria.Key {
+	case "SEQUENCE":
+		return criteria.Set.Contains(m.SequenceNumber, ctx.LastSequenceNumber)
+	case "UID":
+		return criteria.UIDs.Contains(m.UID, ctx.LastUID)
+
+	case "ALL":
+		return true
+	case "ANSWERED":
+		return flags.HasFlags(types.FlagAnswered)
+	case "DELETED":
+		return flags.HasFlags(types.FlagDeleted)
+	case "DRAFT":
+		return flags.HasFlags(types.FlagDraft)
+	case "FLAGGED":
+		return flags.HasFlags(types.FlagFlagged)
+	case "RECENT":
+		return flags.HasFlags(types.FlagRecent)
+	case "SEEN":
+		return flags.HasFlags(types.FlagSeen)
+	case "NEW":
+		return flags.HasFlags(types.FlagRecent) && !flags.HasFlags(types.FlagSeen)
+	case "OLD":
+		return !flags.HasFlags(types.FlagRecent)
+	case "UNANSWERED":
+		return !flags.HasFlags(types.FlagAnswered)
+	case "UNDELETED":
+		return !flags.HasFlags(types.FlagDeleted)
+	case "UNDRAFT":
+		return !flags.HasFlags(types.FlagDraft)
+	case "UNFLAGGED":
+		return !flags.HasFlags(types.FlagFlagged)
+	case "UNSEEN":
+		return !flags.HasFlags(types.FlagSeen)
+	case "KEYWORD":
+		return hasKeyword(m, criteria.Value)
+	case "UNKEYWORD":
+		return !hasKeyword(m, criteria.Value)
+
+	case "BCC", "CC", "FROM", "SUBJECT", "TO":
+		return headerContains(m.parse().header, criteria.Key, criteria.Value)
+	case "HEADER":
+		if criteria.Value == "" {
+			_, ok := m.parse().header[textproto.CanonicalMIMEHeaderKey(criteria.Header)]
+			return ok
+		}
+		return headerContains(m.parse().header, criteria.Header, criteria.Value)
+	case "BODY":
+		return containsFold(m.parse().bodyText, criteria.Value)
+	case "TEXT":
+		return containsFold(m.parse().headerText, criteria.Value) ||
+			containsFold(m.parse().bodyText, criteria.Value)
+
+	case "LARGER":
+		return m.Size > criteria.Size
+	case "SMALLER":
+		return m.Size < criteria.Size
+
+	case "BEFORE", "ON", "SINCE":
+		return compareDates(m.InternalDate, criteria)
+	case "SENTBEFORE", "SENTON", "SENTSINCE":
+		sent, err := mail.ParseDate(m.parse().header.Get("Date"))
+		return err == nil && compareDates(sent, criteria)
+	case "SAVEDBEFORE", "SAVEDON", "SAVEDSINCE":
+		return m.SaveDateSupported && compareDates(m.SaveDate, criteria)
+	case "SAVEDATESUPPORTED":
+		return m.SaveDateSupported
+	case "MODSEQ":
+		// Mod-sequences aren't kept for each flag, so a metadata item is
+		// matched by the message's mod-sequence (RFC 7162 section 3.1.5)
+		return m.ModSeq >= criteria.ModSeq
+	case "FUZZY":
+		return fuzzyRelevancy(criteria.Children[0], m, ctx) > 0
+	}
+	return false
+}
+
+func hasKeyword(m *Message, keyword string) bool {
+	for _, k := range m.Keywords {
+		if strings.EqualFold(k, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerContains(header textproto.MIMEHeader, field string, value string) bool {
+	for _, v := range header[textproto.CanonicalMIMEHeaderKey(field)] {
+		if containsFold(DecodeHeader(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// Compare the day of a date with the date of a BEFORE, ON or SINCE style
+// key, disregarding the time and timezone
+func compareDates(date time.Time, criteria types.SearchCriteria) bool {
+	year, month, day := date.Date()
+	date = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+	switch {
+	case strings.HasSuffix(criteria.Key, "BEFORE"):
+		return date.Before(criteria.Date)
+	case strings.HasSuffix(criteria.Key, "ON"):
+		return date.Equal(criteria.Date)
+	default:
+		return !date.Before(criteria.Date)
+	}
+}
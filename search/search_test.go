@@ -0,0 +1,117 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+const rawMessage = "From: =?ISO-8859-1?Q?J=F6rg?= <jorg@example.com>\r\n" +
+	"To: you@example.com\r\n" +
+	"Subject: =?UTF-8?B?R3LDvMOfZQ==?=\r\n" +
+	"Date: Tue, 28 Oct 2014 00:09:00 +0700\r\n" +
+	"Content-Type: multipart/mixed; boundary=frontier\r\n" +
+	"\r\n" +
+	"--frontier\r\n" +
+	"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Sch=F6ne Gr=FC=DFe\r\n" +
+	"--frontier\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+aGVsbG8gd29ybGQ8L3A+\r\n" +
+	"--frontier\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"\r\n" +
+	"attachment data\r\n" +
+	"--frontier--\r\n"
+
+func TestMatchesRawMessage(t *testing.T) {
+	msg := &Message{
+		Raw:            []byte(rawMessage),
+		UID:            20,
+		SequenceNumber: 2,
+		Size:           uint32(len(rawMessage)),
+		Flags:          types.FlagSeen,
+		InternalDate:   time.Date(2014, time.October, 28, 0, 9, 0, 0, time.UTC),
+		ModSeq:         7,
+	}
+	ctx := Context{LastSequenceNumber: 3, LastUID: 30}
+
+	searches := []struct {
+		criteria string
+		matches  bool
+	}{
+		{"SUBJECT grüße", true},
+		{"FROM jörg", true},
+		{"BODY \"schöne grüße\"", true},
+		{"BODY \"hello world\"", true},
+		{"BODY attachment", false},
+		{"TEXT jorg@example.com", true},
+		{"HEADER Content-Type multipart", true},
+		{"SENTON 28-Oct-2014", true},
+		{"SEEN 2:* UID 20", true},
+		{"UNSEEN", false},
+		{"OR 1 3", false},
+		{"SAVEDATESUPPORTED", false},
+		{"MODSEQ 7", true},
+		{"MODSEQ 8", false},
+		{"MODSEQ \"/flags/\\\\seen\" priv 5", true},
+	}
+	for _, search := range searches {
+		criteria, err := types.ParseSearchCriteria(search.criteria)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %s", search.criteria, err)
+		}
+		if Matches(criteria, msg, ctx) != search.matches {
+			t.Errorf("Expected %q to match: %t", search.criteria, search.matches)
+		}
+	}
+}
+
+func TestMatchesUnparseableMessage(t *testing.T) {
+	msg := &Message{Raw: []byte("not a message")}
+	criteria, _ := types.ParseSearchCriteria("BODY message")
+	if !Matches(criteria, msg, Context{}) {
+		t.Errorf("Expected the raw text to be searched as the body")
+	}
+}
+
+func TestFuzzyRelevancy(t *testing.T) {
+	msg := &Message{Raw: []byte(rawMessage), UID: 20, SequenceNumber: 2}
+	ctx := Context{LastSequenceNumber: 2, LastUID: 20}
+
+	tests := []struct {
+		criteria string
+		expected int
+	}{
+		{"FUZZY SUBJECT grüße", 100},
+		{"FUZZY BODY schone", 50},
+		{"FUZZY BODY \"schöne grüse\"", 75},
+		{"FUZZY SUBJECT holiday", 0},
+		{"OR FUZZY SUBJECT holiday FUZZY BODY schone", 50},
+		{"FUZZY BODY schone SEEN", 0},
+		{"FROM jorg", 100},
+	}
+	for _, test := range tests {
+		criteria, err := types.ParseSearchCriteria(test.criteria)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %s", test.criteria, err)
+		}
+		if score := Relevancy(criteria, msg, ctx); score != test.expected {
+			t.Errorf("Expected %q to score %d, got %d", test.criteria, test.expected, score)
+		}
+		if matched := Matches(criteria, msg, ctx); matched != (test.expected > 0) {
+			t.Errorf("Expected %q to match: %t", test.criteria, test.expected > 0)
+		}
+	}
+
+	ctx.Ranker = func(key types.SearchCriteria, m *Message) int { return 42 }
+	criteria, _ := types.ParseSearchCriteria("FUZZY SUBJECT holiday")
+	if score := Relevancy(criteria, msg, ctx); score != 42 {
+		t.Errorf("Expected the ranker's score to be used, got %d", score)
+	}
+}
@@ -0,0 +1,116 @@
+package search
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+// Sort orders messages by the criteria of a SORT command (RFC 5256).
+// Messages which compare equal by every key are left in sequence number
+// order.
+func Sort(msgs []*Message, order []types.SortCriterion) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		for _, criterion := range order {
+			cmp := compareBy(criterion.Key, msgs[i], msgs[j])
+			if criterion.Reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return msgs[i].SequenceNumber < msgs[j].SequenceNumber
+	})
+}
+
+// Compare two messages by a single sort key, returning a negative number if
+// a comes first, a positive number if b does and zero if they're equal
+func compareBy(key string, a *Message, b *Message) int {
+	switch key {
+	case "ARRIVAL":
+		return compareTimes(a.InternalDate, b.InternalDate)
+	case "DATE":
+		return compareTimes(a.sentDate(), b.sentDate())
+	case "SIZE":
+		return compareNumbers(a.Size, b.Size)
+	case "RELEVANCY":
+		return compareNumbers(uint32(a.Relevancy), uint32(b.Relevancy))
+	case "SUBJECT":
+		return strings.Compare(a.baseSubject(), b.baseSubject())
+	case "CC", "FROM", "TO":
+		return strings.Compare(a.firstMailbox(key), b.firstMailbox(key))
+	}
+	return 0
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareNumbers(a uint32, b uint32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// The date the message was sent, or its internal date if it has no valid
+// Date header
+func (m *Message) sentDate() time.Time {
+	sent, err := mail.ParseDate(m.parse().header.Get("Date"))
+	if err != nil {
+		return m.InternalDate
+	}
+	return sent
+}
+
+// The local part of the first address in an address header, in lower case,
+// or blank if there is none (RFC 5256 section 3)
+func (m *Message) firstMailbox(field string) string {
+	addresses, err := mail.ParseAddressList(m.parse().header.Get(field))
+	if err != nil || len(addresses) == 0 {
+		return ""
+	}
+	address := addresses[0].Address
+	if at := strings.LastIndex(address, "@"); at != -1 {
+		address = address[:at]
+	}
+	return strings.ToLower(address)
+}
+
+// The subject of the message without the prefixes and suffixes added when
+// replying and forwarding, in upper case so that it compares without regard
+// to case (RFC 5256 section 2.1)
+func (m *Message) baseSubject() string {
+	subject := strings.ToUpper(strings.Join(strings.Fields(DecodeHeader(m.parse().header.Get("Subject"))), " "))
+	for {
+		trimmed := strings.TrimSpace(subject)
+		trimmed = strings.TrimSuffix(trimmed, "(FWD)")
+		for _, prefix := range []string{"RE:", "FW:", "FWD:"} {
+			trimmed = strings.TrimPrefix(trimmed, prefix)
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.Index(trimmed, "]"); end != -1 && end+1 < len(trimmed) {
+				trimmed = trimmed[end+1:]
+			}
+		}
+		trimmed = strings.TrimSpace(trimmed)
+		if trimmed == subject {
+			return subject
+		}
+		subject = trimmed
+	}
+}
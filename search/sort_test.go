@@ -0,0 +1,61 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jordwest/imap-server/types"
+)
+
+func sortMessage(seqno uint32, size uint32, header string) *Message {
+	return &Message{
+		Raw:            []byte(header + "\r\n\r\nBody\r\n"),
+		UID:            seqno + 10,
+		SequenceNumber: seqno,
+		Size:           size,
+		InternalDate:   time.Date(2014, time.October, int(seqno), 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func sortedSequenceNumbers(msgs []*Message, order []types.SortCriterion) []uint32 {
+	sorted := append([]*Message(nil), msgs...)
+	Sort(sorted, order)
+	seqnos := make([]uint32, len(sorted))
+	for i, msg := range sorted {
+		seqnos[i] = msg.SequenceNumber
+	}
+	return seqnos
+}
+
+func TestSort(t *testing.T) {
+	msgs := []*Message{
+		sortMessage(1, 300, "From: Zed <zed@example.com>\r\nSubject: Re: [list] Lunch\r\nDate: Wed, 29 Oct 2014 00:00:00 +0000"),
+		sortMessage(2, 100, "From: amy@example.com\r\nSubject: Budget\r\nDate: Mon, 27 Oct 2014 00:00:00 +0000"),
+		sortMessage(3, 200, "From: Bob <BOB@example.com>\r\nSubject: Fwd: lunch (fwd)"),
+	}
+
+	tests := []struct {
+		order    []types.SortCriterion
+		expected []uint32
+	}{
+		{[]types.SortCriterion{{Key: "ARRIVAL"}}, []uint32{1, 2, 3}},
+		{[]types.SortCriterion{{Key: "ARRIVAL", Reverse: true}}, []uint32{3, 2, 1}},
+		{[]types.SortCriterion{{Key: "SIZE"}}, []uint32{2, 3, 1}},
+		{[]types.SortCriterion{{Key: "FROM"}}, []uint32{2, 3, 1}},
+		// Messages without a Date header are sorted by their internal date
+		{[]types.SortCriterion{{Key: "DATE"}}, []uint32{3, 2, 1}},
+		// "Re: [list] Lunch" and "Fwd: lunch (fwd)" have the same base
+		// subject, so are left in sequence number order
+		{[]types.SortCriterion{{Key: "SUBJECT"}}, []uint32{2, 1, 3}},
+		{[]types.SortCriterion{{Key: "SUBJECT"}, {Key: "SIZE"}}, []uint32{2, 3, 1}},
+	}
+	for _, test := range tests {
+		actual := sortedSequenceNumbers(msgs, test.order)
+		for i := range actual {
+			if actual[i] != test.expected[i] {
+				t.Errorf("Expected %v sorting by %+v, got %v", test.expected, test.order, actual)
+				break
+			}
+		}
+	}
+}
@@ -1,23 +1,39 @@
 package imap
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jordwest/imap-server/conn"
 	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
 )
 
 // Server represents an IMAP server instance
 type Server struct {
 	Addr       string
 	listener   net.Listener
+	started    time.Time // When the server started listening
 	Transcript io.Writer
 	mailstore  mailstore.Mailstore
+
+	conns      map[string]*conn.Conn // Open client connections, by connection ID
+	connsMutex sync.Mutex
+	sessions   *sessionRegistry // Open client connections, by selected mailbox
+
+	// Options applied to each client connection. These are read when the
+	// server starts listening; use Reconfigure to change them afterwards.
+	Config
+	config      atomic.Value // The Config snapshot used for new connections
+	configMutex sync.Mutex   // Held while the config snapshot is replaced
 }
 
 // NewServer initialises a new Server. Note that this does not start the server.
@@ -27,6 +43,12 @@ func NewServer(store mailstore.Mailstore) *Server {
 		Addr:       ":143",
 		mailstore:  store,
 		Transcript: ioutil.Discard,
+		conns:      make(map[string]*conn.Conn),
+		sessions:   newSessionRegistry(),
+		Config: Config{
+			MaxLineLength:  DefaultMaxLineLength,
+			MaxLiteralSize: DefaultMaxLiteralSize,
+		},
 	}
 	return s
 }
@@ -46,6 +68,9 @@ func (s *Server) Listen() error {
 	if s.listener != nil {
 		return errors.New("Listener already exists")
 	}
+	if err := s.Config.ValidateFor(s.mailstore); err != nil {
+		return err
+	}
 	fmt.Fprintf(s.Transcript, "Listening on %s\n", s.Addr)
 	ln, err := net.Listen("tcp", s.Addr)
 	if err != nil {
@@ -53,6 +78,10 @@ func (s *Server) Listen() error {
 		return err
 	}
 	s.listener = ln
+	s.started = time.Now()
+	if s.config.Load() == nil {
+		s.config.Store(s.Config.clone())
+	}
 	return nil
 }
 
@@ -63,17 +92,11 @@ func (s *Server) Serve() error {
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			fmt.Errorf("Error accepting connection: %s\n", err)
+			fmt.Fprintf(s.Transcript, "Error accepting connection: %s\n", err)
 			return err
 		}
 
-		fmt.Fprintf(s.Transcript, "Connection accepted\n")
-		c, err := s.newConn(conn)
-		if err != nil {
-			return err
-		}
-
-		go c.Start()
+		go s.handleConn(conn)
 	}
 }
 
@@ -90,12 +113,146 @@ func (s *Server) Close() (err error) {
 	return err
 }
 
+// Check that a client is allowed to connect, then talk to it until the
+// connection is closed. The ACL is checked here rather than in Serve as its
+// Check hook may be slow.
+func (s *Server) handleConn(netConn net.Conn) {
+	if acl := s.CurrentConfig().ACL; !acl.Permits(netConn.RemoteAddr()) {
+		s.rejectConn(netConn, acl)
+		return
+	}
+
+	c, err := s.newConn(netConn)
+	if err != nil {
+		fmt.Fprintf(s.Transcript, "Error setting up connection from %s: %s\n", netConn.RemoteAddr(), err)
+		netConn.Close()
+		return
+	}
+	fmt.Fprintf(s.Transcript, "[%s] Connection accepted from %s\n", c.ID, netConn.RemoteAddr())
+	c.Start()
+	s.removeConn(c)
+}
+
 func (s *Server) newConn(netConn net.Conn) (c *conn.Conn, err error) {
 	c = conn.NewConn(s.mailstore, netConn, s.Transcript)
+	s.CurrentConfig().apply(c)
+	c.Sessions = s.sessions
 	c.SetState(conn.StateNew)
+
+	s.connsMutex.Lock()
+	s.conns[c.ID] = c
+	s.connsMutex.Unlock()
 	return c, nil
 }
 
+func (s *Server) removeConn(c *conn.Conn) {
+	s.connsMutex.Lock()
+	delete(s.conns, c.ID)
+	s.connsMutex.Unlock()
+	s.sessions.Selected(c, "", "")
+}
+
+// Take a snapshot of the open client connections, so they can be worked on
+// without holding connsMutex while writing to a slow client
+func (s *Server) connections() []*conn.Conn {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	conns := make([]*conn.Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// InFlightCommands returns the commands currently being executed across all
+// client connections, so that stuck commands can be diagnosed
+func (s *Server) InFlightCommands() []conn.CommandInfo {
+	commands := make([]conn.CommandInfo, 0)
+	for _, c := range s.connections() {
+		if info, ok := c.InFlightCommand(); ok {
+			commands = append(commands, info)
+		}
+	}
+	return commands
+}
+
+// SessionMemory returns an estimate of the memory held by each client
+// connection, for capacity planning
+func (s *Server) SessionMemory() []conn.MemoryUsage {
+	conns := s.connections()
+	usage := make([]conn.MemoryUsage, 0, len(conns))
+	for _, c := range conns {
+		usage = append(usage, c.MemoryUsage())
+	}
+	return usage
+}
+
+// CloseSession forcibly closes the client connection with the given ID
+func (s *Server) CloseSession(connID string) error {
+	s.connsMutex.Lock()
+	c, ok := s.conns[connID]
+	s.connsMutex.Unlock()
+
+	if !ok {
+		return errors.New("No such session")
+	}
+	return c.Close()
+}
+
+// LogoutUser immediately ends every session of the given user, eg when
+// their password is changed or their account is suspended. Each client is
+// sent a BYE with the reason given, in parallel so that one which has
+// stopped reading doesn't hold up the others. Returns the number of
+// sessions ended.
+func (s *Server) LogoutUser(username string, reason string) int {
+	var ended int32
+	var wg sync.WaitGroup
+	for _, c := range s.connections() {
+		wg.Add(1)
+		go func(c *conn.Conn) {
+			defer wg.Done()
+			if c.ForceLogout(username, reason) {
+				atomic.AddInt32(&ended, 1)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return int(ended)
+}
+
+// FileSentMessage saves a message which has been submitted for delivery
+// (eg over SMTP) into the user's Sent mailbox, marked as \Seen, so that the
+// client doesn't have to upload it a second time. Any of the user's
+// sessions with the Sent mailbox selected are notified of the new message.
+func (s *Server) FileSentMessage(ctx context.Context, username string, user mailstore.User, data []byte) (mailstore.Message, error) {
+	mailbox, err := mailstore.SentMailbox(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	rawMsg, err := types.MessageFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := mailstore.NewMessage(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	msg = msg.SetHeaders(rawMsg.Headers)
+	msg = msg.SetBody(rawMsg.Body)
+	if msg, err = mailstore.UpdateFlags(ctx, msg, types.StoreReplace, types.FlagSeen); err != nil {
+		return nil, err
+	}
+	msg, err = mailstore.SaveMessage(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sessions.Changed(nil, username, mailbox.Name())
+	return msg, nil
+}
+
 // NewTestConnection is for test facilitation.
 // Creates a server and then dials the server, returning the connection,
 // allowing test to inject state and wait for an expected response
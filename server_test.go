@@ -1,10 +1,17 @@
 package imap
 
 import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jordwest/imap-server/conn"
+	"github.com/jordwest/imap-server/internal/client"
 	"github.com/jordwest/imap-server/mailstore"
+	"github.com/jordwest/imap-server/types"
 )
 
 func TestDataRace(t *testing.T) {
@@ -16,3 +23,194 @@ func TestDataRace(t *testing.T) {
 	time.Sleep(time.Millisecond)
 	s.Close()
 }
+
+// A mailstore which blocks authentication until released
+type blockingMailstore struct {
+	mailstore.DummyMailstore
+	release chan bool
+}
+
+func (m blockingMailstore) Authenticate(username string, password string) (mailstore.User, error) {
+	<-m.release
+	return m.DummyMailstore.Authenticate(username, password)
+}
+
+func TestInFlightCommands(t *testing.T) {
+	store := blockingMailstore{mailstore.NewDummyMailstore(), make(chan bool)}
+	defer close(store.release)
+
+	s := NewServer(store)
+	s.Addr = "127.0.0.1:10144"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, err := net.Dial("tcp4", s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+	client := textproto.NewConn(c)
+	client.ReadLine()
+	client.PrintfLine("abcd.123 LOGIN \"username\" \"password\"")
+
+	var commands []conn.CommandInfo
+	for i := 0; i < 100 && len(commands) == 0; i++ {
+		time.Sleep(time.Millisecond)
+		commands = s.InFlightCommands()
+	}
+	if len(commands) != 1 {
+		t.Fatalf("Expected 1 command in flight, got %d", len(commands))
+	}
+	if commands[0].Verb != "LOGIN" {
+		t.Errorf("Expected LOGIN command to be in flight, got %s", commands[0].Verb)
+	}
+	if usage := s.SessionMemory(); len(usage) != 1 || usage[0].ConnID != commands[0].ConnID {
+		t.Errorf("Expected memory usage of 1 session, got %+v", usage)
+	}
+
+	if err = s.CloseSession(commands[0].ConnID); err != nil {
+		t.Errorf("Error closing session: %s", err)
+	}
+	if err = s.CloseSession("nonexistent"); err == nil {
+		t.Errorf("Expected error closing a nonexistent session")
+	}
+}
+
+func TestFileSentMessage(t *testing.T) {
+	store := mailstore.NewDummyMailstore()
+	s := NewServer(store)
+	s.Addr = "127.0.0.1:10145"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer c.Close()
+	if err = c.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+	if _, err = c.Select("Sent"); err != nil {
+		t.Fatalf("Error selecting Sent mailbox: %s", err)
+	}
+
+	msg, err := s.FileSentMessage(context.Background(), "username", store.User, []byte("Subject: Sent email\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatalf("Error filing sent message: %s", err)
+	}
+	if !msg.Flags().HasFlags(types.FlagSeen) {
+		t.Errorf("Expected sent message to be marked as seen")
+	}
+
+	untagged, err := c.Command("NOOP")
+	if err != nil {
+		t.Fatalf("Error reading NOOP response: %s", err)
+	}
+	if len(untagged) == 0 || untagged[0].Kind != "EXISTS" || untagged[0].Number != 1 {
+		t.Errorf("Expected to be notified of 1 message in Sent, got %+v", untagged)
+	}
+}
+
+func TestLogoutUser(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	s.Addr = "127.0.0.1:10150"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	loggedIn, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer loggedIn.Close()
+	if err = loggedIn.Login("username", "password"); err != nil {
+		t.Fatalf("Error logging in: %s", err)
+	}
+	anonymous, err := client.Dial(s.Addr)
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err)
+	}
+	defer anonymous.Close()
+
+	if ended := s.LogoutUser("username", "Account suspended"); ended != 1 {
+		t.Errorf("Expected 1 session to be ended, got %d", ended)
+	}
+	untagged, err := loggedIn.Command("NOOP")
+	if err == nil {
+		t.Errorf("Expected the session to be closed")
+	}
+	if len(untagged) != 1 || untagged[0].Status != "BYE" || untagged[0].Text != "Account suspended" {
+		t.Errorf("Expected BYE before the session was closed, got %+v", untagged)
+	}
+
+	if _, err = anonymous.Command("NOOP"); err != nil {
+		t.Errorf("Expected other sessions to remain open, got %s", err)
+	}
+}
+
+func TestMailboxChangesReachOtherSessions(t *testing.T) {
+	s := NewServer(mailstore.NewDummyMailstore())
+	s.Addr = "127.0.0.1:10152"
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	var sessions [3]*client.Client
+	for i, mailbox := range []string{"INBOX", "INBOX", "Trash"} {
+		c, err := client.Dial(s.Addr)
+		if err != nil {
+			t.Fatalf("Error connecting: %s", err)
+		}
+		defer c.Close()
+		if err = c.Login("username", "password"); err != nil {
+			t.Fatalf("Error logging in: %s", err)
+		}
+		if _, err = c.Select(mailbox); err != nil {
+			t.Fatalf("Error selecting %s: %s", mailbox, err)
+		}
+		sessions[i] = c
+	}
+	changer, watcher, bystander := sessions[0], sessions[1], sessions[2]
+
+	if _, err := changer.Command("STORE 1 +FLAGS.SILENT (\\Flagged)"); err != nil {
+		t.Fatalf("Error storing flags: %s", err)
+	}
+	// CHECK only reports changes which have been announced, unlike NOOP
+	untagged, err := watcher.Command("CHECK")
+	if err != nil {
+		t.Fatalf("Error reading CHECK response: %s", err)
+	}
+	if len(untagged) != 1 || untagged[0].Kind != "FETCH" || untagged[0].Number != 1 ||
+		!strings.Contains(untagged[0].Data, "\\Flagged") {
+		t.Errorf("Expected the new flags of message 1, got %+v", untagged)
+	}
+
+	if _, err = changer.Command("STORE 2 +FLAGS.SILENT (\\Deleted)"); err != nil {
+		t.Fatalf("Error storing flags: %s", err)
+	}
+	if _, err = changer.Command("EXPUNGE"); err != nil {
+		t.Fatalf("Error expunging: %s", err)
+	}
+	untagged, err = watcher.Command("CHECK")
+	if err != nil {
+		t.Fatalf("Error reading CHECK response: %s", err)
+	}
+	if len(untagged) == 0 || untagged[0].Kind != "EXPUNGE" || untagged[0].Number != 2 {
+		t.Errorf("Expected message 2 to be expunged, got %+v", untagged)
+	}
+
+	if untagged, err = bystander.Command("CHECK"); err != nil || len(untagged) != 0 {
+		t.Errorf("Expected sessions with other mailboxes selected to hear nothing, got %+v (%v)", untagged, err)
+	}
+}
@@ -0,0 +1,68 @@
+package imap
+
+import (
+	"sync"
+
+	"github.com/jordwest/imap-server/conn"
+)
+
+// Identifies one of a user's mailboxes
+type mailboxKey struct {
+	username string
+	mailbox  string
+}
+
+// sessionRegistry records which connections have each mailbox selected, so
+// that changes made through one connection reach the others at their next
+// command as untagged EXPUNGE and FETCH (FLAGS ...) responses
+type sessionRegistry struct {
+	selected  map[mailboxKey]map[*conn.Conn]bool // Connections by selected mailbox
+	mailboxes map[*conn.Conn]mailboxKey          // Selected mailbox by connection
+	mutex     sync.Mutex
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		selected:  make(map[mailboxKey]map[*conn.Conn]bool),
+		mailboxes: make(map[*conn.Conn]mailboxKey),
+	}
+}
+
+// Selected implements the Selected method on the conn.SessionRegistry
+// interface
+func (r *sessionRegistry) Selected(c *conn.Conn, username string, mailbox string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if previous, ok := r.mailboxes[c]; ok {
+		delete(r.selected[previous], c)
+		if len(r.selected[previous]) == 0 {
+			delete(r.selected, previous)
+		}
+		delete(r.mailboxes, c)
+	}
+	if mailbox == "" {
+		return
+	}
+
+	key := mailboxKey{username, mailbox}
+	if r.selected[key] == nil {
+		r.selected[key] = make(map[*conn.Conn]bool)
+	}
+	r.selected[key][c] = true
+	r.mailboxes[c] = key
+}
+
+// Changed implements the Changed method on the conn.SessionRegistry
+// interface. The connection may be nil if the change wasn't made by a
+// client.
+func (r *sessionRegistry) Changed(c *conn.Conn, username string, mailbox string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for other := range r.selected[mailboxKey{username, mailbox}] {
+		if other != c {
+			other.MailboxChanged(username, mailbox)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package types
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// BodyStructure returns the MIME structure of the message (RFC 3501 section
+// 7.4.2) as a parenthesised list. If extended is true the extension data
+// returned for BODYSTRUCTURE is included, otherwise the result is suitable
+// for the non-extensible BODY fetch item.
+func (msg RFC2822Message) BodyStructure(extended bool) string {
+	mediaType, params := contentType(msg.Headers)
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return msg.multipartStructure(mediaType, params, extended)
+	}
+
+	mainType, subType := splitMediaType(mediaType)
+	encoding := strings.ToUpper(strings.TrimSpace(msg.Headers.Get("Content-Transfer-Encoding")))
+	if encoding == "" {
+		encoding = "7BIT"
+	}
+	fields := []string{
+		nstring(mainType),
+		nstring(subType),
+		paramList(params),
+		nstring(msg.Headers.Get("Content-Id")),
+		nstring(msg.Headers.Get("Content-Description")),
+		nstring(encoding),
+		fmt.Sprint(len(msg.Body)),
+	}
+
+	switch {
+	case mediaType == "message/rfc822":
+		inner, err := MessageFromBytes([]byte(msg.Body))
+		if err != nil {
+			inner = RFC2822Message{Headers: make(textproto.MIMEHeader)}
+		}
+		fields = append(fields, inner.Envelope(), inner.BodyStructure(extended), fmt.Sprint(countLines(msg.Body)))
+	case mainType == "TEXT":
+		fields = append(fields, fmt.Sprint(countLines(msg.Body)))
+	}
+
+	if extended {
+		fields = append(fields,
+			nstring(msg.Headers.Get("Content-Md5")),
+			disposition(msg.Headers),
+			language(msg.Headers),
+			nstring(msg.Headers.Get("Content-Location")))
+	}
+	return "(" + strings.Join(fields, " ") + ")"
+}
+
+// The structure of a multipart body is the structure of each of its parts
+// followed by the multipart subtype
+func (msg RFC2822Message) multipartStructure(mediaType string, params map[string]string, extended bool) string {
+	parts := make([]string, 0)
+	reader := multipart.NewReader(strings.NewReader(msg.Body), params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			break
+		}
+		subPart := RFC2822Message{Headers: textproto.MIMEHeader(part.Header), Body: string(body)}
+		parts = append(parts, subPart.BodyStructure(extended))
+	}
+
+	// A multipart body must have at least one part
+	if len(parts) == 0 {
+		empty := RFC2822Message{Headers: make(textproto.MIMEHeader)}
+		parts = append(parts, empty.BodyStructure(extended))
+	}
+
+	_, subType := splitMediaType(mediaType)
+	structure := strings.Join(parts, "") + " " + nstring(subType)
+	if extended {
+		structure += " " + strings.Join([]string{
+			paramList(params),
+			disposition(msg.Headers),
+			language(msg.Headers),
+			nstring(msg.Headers.Get("Content-Location")),
+		}, " ")
+	}
+	return "(" + structure + ")"
+}
+
+// Parse the Content-Type header, defaulting to plain US-ASCII text
+func contentType(header textproto.MIMEHeader) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return "text/plain", map[string]string{"charset": "us-ascii"}
+	}
+	return mediaType, params
+}
+
+// Split a media type into its upper case type and subtype
+func splitMediaType(mediaType string) (string, string) {
+	parts := strings.SplitN(strings.ToUpper(mediaType), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// Format parameters as a list of attribute/value pairs, sorted by attribute
+// so that the structure of a message is always the same
+func paramList(params map[string]string) string {
+	if len(params) == 0 {
+		return "NIL"
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]string, 0, len(params)*2)
+	for _, name := range names {
+		list = append(list, nstring(strings.ToUpper(name)), nstring(params[name]))
+	}
+	return "(" + strings.Join(list, " ") + ")"
+}
+
+// Format the Content-Disposition header, eg ("ATTACHMENT" ("FILENAME" "a.txt"))
+func disposition(header textproto.MIMEHeader) string {
+	value, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return "NIL"
+	}
+	return "(" + nstring(strings.ToUpper(value)) + " " + paramList(params) + ")"
+}
+
+// Format the Content-Language header as a list of language tags
+func language(header textproto.MIMEHeader) string {
+	value := header.Get("Content-Language")
+	if value == "" {
+		return "NIL"
+	}
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, nstring(tag))
+		}
+	}
+	return "(" + strings.Join(tags, " ") + ")"
+}
+
+// Count the lines of a body, including a final line with no line ending
+func countLines(body string) int {
+	lines := strings.Count(body, "\n")
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		lines++
+	}
+	return lines
+}
@@ -0,0 +1,56 @@
+package types
+
+import "testing"
+
+func TestBodyStructure(t *testing.T) {
+	msg, err := MessageFromBytes([]byte(multipartMessage))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s", err)
+	}
+
+	expected := `(("TEXT" "PLAIN" NIL NIL NIL "QUOTED-PRINTABLE" 9 1)` +
+		`("APPLICATION" "OCTET-STREAM" NIL NIL NIL "BASE64" 4) "MIXED")`
+	if structure := msg.BodyStructure(false); structure != expected {
+		t.Errorf("Expected BODY %s, got %s", expected, structure)
+	}
+
+	expected = `(("TEXT" "PLAIN" NIL NIL NIL "QUOTED-PRINTABLE" 9 1 NIL NIL NIL NIL)` +
+		`("APPLICATION" "OCTET-STREAM" NIL NIL NIL "BASE64" 4 NIL NIL NIL NIL)` +
+		` "MIXED" ("BOUNDARY" "XYZ") NIL NIL NIL)`
+	if structure := msg.BodyStructure(true); structure != expected {
+		t.Errorf("Expected BODYSTRUCTURE %s, got %s", expected, structure)
+	}
+}
+
+func TestBodyStructureOfAttachedMessage(t *testing.T) {
+	msg, err := MessageFromBytes([]byte("Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Language: en, de\r\n" +
+		"\r\n" +
+		"See attached\r\n" +
+		"--b\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"Content-Disposition: attachment; filename=\"fwd.eml\"\r\n" +
+		"\r\n" +
+		"From: Bob <bob@example.com>\r\n" +
+		"Subject: Hi\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--b--\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s", err)
+	}
+
+	expected := `(("TEXT" "PLAIN" ("CHARSET" "utf-8") NIL NIL "7BIT" 12 1 NIL NIL ("en" "de") NIL)` +
+		`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 49` +
+		` (NIL "Hi" (("Bob" NIL "bob" "example.com")) (("Bob" NIL "bob" "example.com"))` +
+		` (("Bob" NIL "bob" "example.com")) NIL NIL NIL NIL NIL)` +
+		` ("TEXT" "PLAIN" ("CHARSET" "us-ascii") NIL NIL "7BIT" 5 1 NIL NIL NIL NIL) 4` +
+		` NIL ("ATTACHMENT" ("FILENAME" "fwd.eml")) NIL NIL)` +
+		` "MIXED" ("BOUNDARY" "b") NIL NIL NIL)`
+	if structure := msg.BodyStructure(true); structure != expected {
+		t.Errorf("Expected BODYSTRUCTURE\n%s\ngot\n%s", expected, structure)
+	}
+}
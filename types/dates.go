@@ -0,0 +1,58 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// The syntax of a date-time, which time.Parse is more lenient about, eg
+// accepting single digit hours
+var dateTimeRegexp = regexp.MustCompile("^(?: [0-9]|[0-9]{1,2})-[A-Za-z]{3}-[0-9]{4} [0-9]{2}:[0-9]{2}:[0-9]{2} [+-][0-9]{4}$")
+
+type errInvalidDate string
+type errInvalidDateTime string
+
+func (e errInvalidDate) Error() string {
+	return fmt.Sprintf("Invalid date '%s' specified, expected eg 1-Feb-1994", string(e))
+}
+func (e errInvalidDateTime) Error() string {
+	return fmt.Sprintf("Invalid date-time '%s' specified, expected eg 17-Jul-1996 02:44:25 -0700", string(e))
+}
+
+// Remove the double quotes around a date, if it has them
+func unquoteDate(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// ParseDate parses a date as used by SEARCH, eg 1-Feb-1994, which may be
+// in double quotes. Month names may be in any case. The date is returned as
+// midnight UTC.
+func ParseDate(imapDate string) (time.Time, error) {
+	date, err := time.Parse(SearchDate, unquoteDate(imapDate))
+	if err != nil {
+		return time.Time{}, errInvalidDate(imapDate)
+	}
+	return date, nil
+}
+
+// ParseDateTime parses a date-time as used by APPEND and in the INTERNALDATE
+// of messages, eg "17-Jul-1996 02:44:25 -0700", which may be in double
+// quotes. The zone must be a numeric offset; the time is returned in that
+// zone.
+func ParseDateTime(imapDateTime string) (time.Time, error) {
+	s := unquoteDate(imapDateTime)
+	if !dateTimeRegexp.MatchString(s) {
+		return time.Time{}, errInvalidDateTime(imapDateTime)
+	}
+	dateTime, err := time.Parse(util.AppendDate, s)
+	if err != nil {
+		return time.Time{}, errInvalidDateTime(imapDateTime)
+	}
+	return dateTime, nil
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	expected := time.Date(1994, time.February, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range []string{"1-Feb-1994", "01-Feb-1994", "1-feb-1994", `"1-Feb-1994"`} {
+		date, err := ParseDate(s)
+		if err != nil || !date.Equal(expected) {
+			t.Errorf("%s parsed as %s, %v", s, date, err)
+		}
+	}
+
+	date, err := ParseDate("28-Oct-2014")
+	if err != nil || date.Day() != 28 {
+		t.Errorf("Two-digit day parsed as %s, %v", date, err)
+	}
+
+	for _, s := range []string{"", "1-Feb-94", "001-Feb-1994", "31-Feb-1994", "1 Feb 1994", "1-February-1994", `"1-Feb-1994`} {
+		if _, err := ParseDate(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	pdt := time.FixedZone("", -7*60*60)
+	tests := []struct {
+		dateTime string
+		expected time.Time
+	}{
+		{"17-Jul-1996 02:44:25 -0700", time.Date(1996, time.July, 17, 2, 44, 25, 0, pdt)},
+		{`"17-Jul-1996 02:44:25 -0700"`, time.Date(1996, time.July, 17, 2, 44, 25, 0, pdt)},
+		{" 7-Jul-1996 02:44:25 -0700", time.Date(1996, time.July, 7, 2, 44, 25, 0, pdt)},
+		{"07-Jul-1996 02:44:25 -0700", time.Date(1996, time.July, 7, 2, 44, 25, 0, pdt)},
+		{"7-Jul-1996 02:44:25 -0700", time.Date(1996, time.July, 7, 2, 44, 25, 0, pdt)},
+		{"7-JUL-1996 09:14:25 +0530", time.Date(1996, time.July, 7, 3, 44, 25, 0, time.UTC)},
+		{"1-Jan-2000 00:00:00 +0000", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		dateTime, err := ParseDateTime(test.dateTime)
+		if err != nil || !dateTime.Equal(test.expected) {
+			t.Errorf("%s parsed as %s, %v", test.dateTime, dateTime, err)
+		}
+	}
+
+	dateTime, _ := ParseDateTime("17-Jul-1996 02:44:25 +0530")
+	if _, offset := dateTime.Zone(); offset != 5*60*60+30*60 {
+		t.Errorf("Expected the +0530 zone to be kept, got offset %d", offset)
+	}
+
+	invalid := []string{
+		"17-Jul-1996 02:44:25 PDT",
+		"17-Jul-1996 02:44:25 Z",
+		"17-Jul-1996 02:44:25 -07",
+		"17-Jul-1996 02:44:25 -07:00",
+		"17-Jul-1996 2:44:25 -0700",
+		" 07-Jul-1996 02:44:25 -0700",
+		"17-Jul-96 02:44:25 -0700",
+		"17-Jul-1996",
+		"32-Jul-1996 02:44:25 -0700",
+	}
+	for _, s := range invalid {
+		if _, err := ParseDateTime(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
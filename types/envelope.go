@@ -0,0 +1,193 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"strings"
+)
+
+// Envelope returns the ENVELOPE of the message (RFC 3501 section 7.4.2):
+// the date, subject, addresses, In-Reply-To and Message-ID taken from its
+// header, as a parenthesised list
+func (msg RFC2822Message) Envelope() string {
+	header := msg.Headers
+	from := addressList(header.Get("From"))
+
+	// Sender and Reply-To default to From when they are absent
+	sender := addressList(header.Get("Sender"))
+	if sender == "NIL" {
+		sender = from
+	}
+	replyTo := addressList(header.Get("Reply-To"))
+	if replyTo == "NIL" {
+		replyTo = from
+	}
+
+	fields := []string{
+		nstring(header.Get("Date")),
+		nstring(header.Get("Subject")),
+		from,
+		sender,
+		replyTo,
+		addressList(header.Get("To")),
+		addressList(header.Get("Cc")),
+		addressList(header.Get("Bcc")),
+		nstring(header.Get("In-Reply-To")),
+		nstring(header.Get("Message-Id")),
+	}
+	return "(" + strings.Join(fields, " ") + ")"
+}
+
+// Format an address header as a list of addresses, each of which is a list
+// of the personal name, source route, mailbox name and host. Members of a
+// group are preceded by an address giving the group's name and followed by
+// one marking its end. Returns NIL if the header is absent or can't be
+// parsed.
+func addressList(value string) string {
+	list := make([]string, 0)
+	for _, segment := range splitGroups(value) {
+		var addresses []*mail.Address
+		if strings.TrimSpace(segment.addresses) != "" {
+			var err error
+			addresses, err = addressParser.ParseList(segment.addresses)
+			if err != nil {
+				return "NIL"
+			}
+		}
+
+		if segment.group != "" {
+			list = append(list, fmt.Sprintf("(NIL NIL %s NIL)", nstring(segment.group)))
+		}
+		for _, address := range addresses {
+			mailbox, host := address.Address, ""
+			if at := strings.LastIndex(mailbox, "@"); at >= 0 {
+				mailbox, host = mailbox[:at], mailbox[at+1:]
+			}
+			list = append(list, fmt.Sprintf("(%s NIL %s %s)", nstring(encodeName(address.Name)), nstring(mailbox), nstring(host)))
+		}
+		if segment.group != "" {
+			list = append(list, "(NIL NIL NIL NIL)")
+		}
+	}
+	if len(list) == 0 {
+		return "NIL"
+	}
+	return "(" + strings.Join(list, "") + ")"
+}
+
+// Decodes the encoded words in display names. Only UTF-8, US-ASCII and
+// ISO-8859-1 can be converted; words in any other charset are encoded again
+// in that charset, so the client still gets an encoded-word it can decode
+// itself rather than the raw bytes passed off as UTF-8. Returning an error
+// instead would fail the whole address list.
+var addressParser = &mail.AddressParser{WordDecoder: &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		raw, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(mime.QEncoding.Encode(charset, string(raw))), nil
+	},
+}}
+
+// Addresses which are either members of a named group, or not in a group
+type addressGroup struct {
+	group     string // The name of the group, or blank if not in a group
+	addresses string // The addresses as they appear in the header
+}
+
+// Split an address header into groups (eg "Team: a@b.com, c@d.com;") and
+// runs of addresses outside groups. Commas, colons and semicolons inside
+// quoted strings, comments and angle brackets are ignored.
+func splitGroups(value string) []addressGroup {
+	groups := make([]addressGroup, 0)
+	start := 0 // Start of the current run of addresses or group
+	groupName := ""
+	inGroup := false
+	quoted, comment, angle := false, 0, false
+
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; {
+		case quoted:
+			if ch == '\\' {
+				i++
+			} else if ch == '"' {
+				quoted = false
+			}
+		case ch == '"':
+			quoted = true
+		case ch == '(':
+			comment++
+		case ch == ')' && comment > 0:
+			comment--
+		case comment > 0:
+		case ch == '<':
+			angle = true
+		case ch == '>':
+			angle = false
+		case angle:
+		case ch == ':' && !inGroup:
+			// The group name follows the last address before it
+			lastComma := strings.LastIndex(value[start:i], ",")
+			groups = append(groups, addressGroup{addresses: value[start : start+lastComma+1]})
+			groupName = strings.TrimSpace(value[start+lastComma+1 : i])
+			inGroup = true
+			start = i + 1
+		case ch == ';' && inGroup:
+			groups = append(groups, addressGroup{group: unquoteGroupName(groupName), addresses: value[start:i]})
+			inGroup = false
+			start = i + 1
+		}
+	}
+	rest := addressGroup{addresses: value[start:]}
+	if inGroup {
+		rest.group = unquoteGroupName(groupName)
+	}
+	groups = append(groups, rest)
+
+	// Drop the empty runs between groups
+	result := groups[:0]
+	for _, group := range groups {
+		group.addresses = strings.Trim(group.addresses, " ,")
+		if group.group != "" || group.addresses != "" {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+func unquoteGroupName(name string) string {
+	if len(name) >= 2 && strings.HasPrefix(name, "\"") && strings.HasSuffix(name, "\"") {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// Names are decoded when addresses are parsed, so any which aren't ASCII
+// are encoded again to be sent to the client
+func encodeName(name string) string {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= 0x80 {
+			return mime.QEncoding.Encode("utf-8", name)
+		}
+	}
+	return name
+}
+
+// Format a string as NIL if it is empty, as a literal if it contains
+// characters which can't be quoted, or otherwise as a quoted string
+func nstring(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\r' || s[i] == '\n' || s[i] == 0 || s[i] >= 0x80 {
+			return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+		}
+	}
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
@@ -0,0 +1,53 @@
+package types
+
+import "testing"
+
+func TestEnvelope(t *testing.T) {
+	msg, err := MessageFromBytes([]byte("Date: Wed, 17 Jul 1996 02:23:25 -0700\r\n" +
+		"Subject: =?ISO-8859-1?Q?Caf=E9?=\r\n" +
+		"From: \"Terry Gray\" <gray@cac.washington.edu>\r\n" +
+		"Reply-To: =?UTF-8?Q?J=C3=B6rg?= <jorg@example.com>\r\n" +
+		"To: imap@cac.washington.edu, Team: Ann <ann@example.com>, bob@example.com;\r\n" +
+		"Cc: undisclosed-recipients:;\r\n" +
+		"Message-Id: <B27397-0100000@cac.washington.edu>\r\n" +
+		"\r\n" +
+		"Body\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s", err)
+	}
+
+	expected := `("Wed, 17 Jul 1996 02:23:25 -0700" "=?ISO-8859-1?Q?Caf=E9?="` +
+		` (("Terry Gray" NIL "gray" "cac.washington.edu"))` +
+		` (("Terry Gray" NIL "gray" "cac.washington.edu"))` +
+		` (("=?utf-8?q?J=C3=B6rg?=" NIL "jorg" "example.com"))` +
+		` ((NIL NIL "imap" "cac.washington.edu")(NIL NIL "Team" NIL)` +
+		`("Ann" NIL "ann" "example.com")(NIL NIL "bob" "example.com")(NIL NIL NIL NIL))` +
+		` ((NIL NIL "undisclosed-recipients" NIL)(NIL NIL NIL NIL))` +
+		` NIL NIL "<B27397-0100000@cac.washington.edu>")`
+	if envelope := msg.Envelope(); envelope != expected {
+		t.Errorf("Expected envelope\n%s\ngot\n%s", expected, envelope)
+	}
+}
+
+func TestEnvelopeOfEmptyHeader(t *testing.T) {
+	msg := RFC2822Message{}
+	expected := "(NIL NIL NIL NIL NIL NIL NIL NIL NIL NIL)"
+	if envelope := msg.Envelope(); envelope != expected {
+		t.Errorf("Expected envelope %s, got %s", expected, envelope)
+	}
+}
+
+func TestEnvelopeKeepsUnknownCharsetsEncoded(t *testing.T) {
+	msg, err := MessageFromBytes([]byte("From: =?KOI8-R?B?8MXU0g==?= <petr@example.com>\r\n" +
+		"\r\n" +
+		"Body\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s", err)
+	}
+
+	name := `(("=?koi8-r?q?=F0=C5=D4=D2?=" NIL "petr" "example.com"))`
+	expected := "(NIL NIL " + name + " " + name + " " + name + " NIL NIL NIL NIL NIL)"
+	if envelope := msg.Envelope(); envelope != expected {
+		t.Errorf("Expected envelope\n%s\ngot\n%s", expected, envelope)
+	}
+}
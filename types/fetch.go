@@ -0,0 +1,255 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// FetchAttribute is one of the data items requested by a FETCH command, eg
+// FLAGS or BODY.PEEK[1.HEADER.FIELDS (From)]<0.1024>
+type FetchAttribute struct {
+	// The upper case name of the item without .PEEK, eg "FLAGS", "BODY" or
+	// "BINARY.SIZE"
+	Name string
+
+	// The item was requested with .PEEK, so fetching it doesn't set \Seen
+	Peek bool
+
+	// The section in square brackets, or nil if there was none. BODY[] has
+	// an empty section.
+	Section *BodySection
+
+	// The range of a partial fetch, or nil if the whole item is fetched
+	Partial *PartialRange
+
+	// The items of a parenthesised list following the item, eg the entry
+	// and attributes of ANNOTATION (/comment value)
+	Args []util.ListItem
+}
+
+// BodySection is the section specification of a BODY or BINARY item, eg
+// the 1.HEADER.FIELDS (From) of BODY[1.HEADER.FIELDS (From)]
+type BodySection struct {
+	// Path of the MIME part, eg [2 1] for 2.1, or empty for the whole
+	// message
+	Part []int
+
+	// HEADER, HEADER.FIELDS, HEADER.FIELDS.NOT, TEXT or MIME, or blank for
+	// the whole part
+	Specifier string
+
+	// The header fields listed by HEADER.FIELDS and HEADER.FIELDS.NOT
+	Fields []string
+}
+
+// PartialRange is the <origin.count> of a partial fetch, which returns count
+// octets of the item starting from origin
+type PartialRange struct {
+	Origin uint32
+	Count  uint32
+}
+
+// FetchSyntaxError describes a FETCH data item which couldn't be parsed
+type FetchSyntaxError struct {
+	Item   string // The offending item, as sent by the client
+	Reason string
+}
+
+func (e *FetchSyntaxError) Error() string {
+	return fmt.Sprintf("Invalid fetch item %s, %s", e.Item, e.Reason)
+}
+
+// Macros which stand for a list of data items (RFC 3501 section 6.4.5)
+var fetchMacros = map[string][]string{
+	"ALL":  {"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"},
+	"FAST": {"FLAGS", "INTERNALDATE", "RFC822.SIZE"},
+	"FULL": {"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"},
+}
+
+// The section specifiers which may follow a part path. MIME requires a part.
+var sectionSpecifiers = map[string]bool{
+	"":                  true,
+	"HEADER":            true,
+	"HEADER.FIELDS":     true,
+	"HEADER.FIELDS.NOT": true,
+	"TEXT":              true,
+	"MIME":              true,
+}
+
+// ParseFetchAttributes parses the data items of a FETCH command, as split
+// into list items by util.ParseList. A single parenthesised list is
+// unwrapped, and the ALL, FAST and FULL macros are replaced by the items they
+// stand for. Items which the server may not support are still parsed; only
+// malformed items are rejected, with a *FetchSyntaxError.
+func ParseFetchAttributes(items []util.ListItem) ([]FetchAttribute, error) {
+	if len(items) == 1 && items[0].IsList {
+		items = items[0].List
+	}
+
+	attrs := make([]FetchAttribute, 0, len(items))
+	for _, item := range items {
+		if item.IsList {
+			if len(attrs) == 0 || attrs[len(attrs)-1].Args != nil {
+				return nil, &FetchSyntaxError{item.String(), "expected a data item"}
+			}
+			attrs[len(attrs)-1].Args = item.List
+			continue
+		}
+		if item.Quoted {
+			return nil, &FetchSyntaxError{item.String(), "expected a data item"}
+		}
+
+		name := strings.ToUpper(item.Value)
+		if macro, ok := fetchMacros[name]; ok && !item.HasSection && item.Partial == "" {
+			for _, macroItem := range macro {
+				attrs = append(attrs, FetchAttribute{Name: macroItem})
+			}
+			continue
+		}
+
+		attr, err := parseFetchAttribute(item)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// Parse a single data item which isn't a macro
+func parseFetchAttribute(item util.ListItem) (FetchAttribute, error) {
+	attr := FetchAttribute{Name: strings.ToUpper(item.Value)}
+	if item.HasSection {
+		if strings.HasSuffix(attr.Name, ".PEEK") {
+			attr.Name = strings.TrimSuffix(attr.Name, ".PEEK")
+			attr.Peek = true
+		}
+		section, err := parseBodySection(item.Section)
+		if err != nil {
+			return attr, &FetchSyntaxError{item.String(), err.Error()}
+		}
+		if strings.HasPrefix(attr.Name, "BINARY") && section.Specifier != "" {
+			return attr, &FetchSyntaxError{item.String(), "BINARY sections may only give a part"}
+		}
+		attr.Section = &section
+	}
+
+	if item.Partial != "" {
+		partial, err := parsePartialRange(item.Partial)
+		if err != nil {
+			return attr, &FetchSyntaxError{item.String(), err.Error()}
+		}
+		attr.Partial = &partial
+	}
+	return attr, nil
+}
+
+// Parse the items within the square brackets of a section, eg
+// 1.HEADER.FIELDS followed by the list (From To)
+func parseBodySection(items []util.ListItem) (BodySection, error) {
+	section := BodySection{Part: []int{}}
+	if len(items) == 0 {
+		return section, nil
+	}
+	if items[0].IsList || items[0].Quoted || items[0].HasSection {
+		return section, fmt.Errorf("expected a section such as 1.TEXT")
+	}
+
+	// Numbers at the start make up the part path, and the rest of the
+	// section is its specifier
+	names := strings.Split(items[0].Value, ".")
+	i := 0
+	for ; i < len(names); i++ {
+		num, err := strconv.ParseUint(names[i], 10, 31)
+		if err != nil {
+			break
+		}
+		if num == 0 {
+			return section, fmt.Errorf("part numbers start from 1")
+		}
+		section.Part = append(section.Part, int(num))
+	}
+	section.Specifier = strings.ToUpper(strings.Join(names[i:], "."))
+	if !sectionSpecifiers[section.Specifier] {
+		return section, fmt.Errorf("unknown section %s", section.Specifier)
+	}
+	if section.Specifier == "MIME" && len(section.Part) == 0 {
+		return section, fmt.Errorf("MIME requires a part number")
+	}
+
+	if !strings.HasPrefix(section.Specifier, "HEADER.FIELDS") {
+		if len(items) != 1 {
+			return section, fmt.Errorf("only HEADER.FIELDS takes a list")
+		}
+		return section, nil
+	}
+	if len(items) != 2 || !items[1].IsList || len(items[1].List) == 0 {
+		return section, fmt.Errorf("expected a list of header fields")
+	}
+	for _, field := range items[1].List {
+		if field.IsList || field.HasSection {
+			return section, fmt.Errorf("expected a list of header fields")
+		}
+		section.Fields = append(section.Fields, field.Value)
+	}
+	return section, nil
+}
+
+// Parse the origin.count of a partial fetch
+func parsePartialRange(s string) (PartialRange, error) {
+	dot := strings.IndexByte(s, '.')
+	if dot < 0 {
+		return PartialRange{}, fmt.Errorf("expected a range such as <0.1024>")
+	}
+	origin, err := strconv.ParseUint(s[:dot], 10, 32)
+	if err != nil {
+		return PartialRange{}, fmt.Errorf("expected a range such as <0.1024>")
+	}
+	count, err := strconv.ParseUint(s[dot+1:], 10, 32)
+	if err != nil {
+		return PartialRange{}, fmt.Errorf("expected a range such as <0.1024>")
+	}
+	if count == 0 {
+		return PartialRange{}, fmt.Errorf("at least one octet must be fetched")
+	}
+	return PartialRange{Origin: uint32(origin), Count: uint32(count)}, nil
+}
+
+// String formats the section as it appears within square brackets, eg
+// 1.HEADER.FIELDS (From To)
+func (s BodySection) String() string {
+	names := make([]string, 0, len(s.Part)+1)
+	for _, part := range s.Part {
+		names = append(names, strconv.Itoa(part))
+	}
+	if s.Specifier != "" {
+		names = append(names, s.Specifier)
+	}
+	str := strings.Join(names, ".")
+	if s.Fields != nil {
+		str += " (" + strings.Join(s.Fields, " ") + ")"
+	}
+	return str
+}
+
+// String formats the item as it would be requested by a client, eg
+// BODY.PEEK[TEXT]<0.1024>
+func (a FetchAttribute) String() string {
+	s := a.Name
+	if a.Section != nil {
+		if a.Peek {
+			s += ".PEEK"
+		}
+		s += "[" + a.Section.String() + "]"
+	}
+	if a.Partial != nil {
+		s += fmt.Sprintf("<%d.%d>", a.Partial.Origin, a.Partial.Count)
+	}
+	if a.Args != nil {
+		s += " (" + util.FormatList(a.Args) + ")"
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+func parseFetchAttributes(t *testing.T, s string) ([]FetchAttribute, error) {
+	items, err := util.ParseList(s)
+	if err != nil {
+		t.Fatalf("Couldn't split %q into items: %s", s, err)
+	}
+	return ParseFetchAttributes(items)
+}
+
+func TestParseFetchAttributes(t *testing.T) {
+	tests := []struct {
+		items    string
+		expected []FetchAttribute
+	}{
+		{"FLAGS", []FetchAttribute{{Name: "FLAGS"}}},
+		{"(uid rfc822.size)", []FetchAttribute{{Name: "UID"}, {Name: "RFC822.SIZE"}}},
+		{"FAST", []FetchAttribute{{Name: "FLAGS"}, {Name: "INTERNALDATE"}, {Name: "RFC822.SIZE"}}},
+		{"BODY", []FetchAttribute{{Name: "BODY"}}},
+		{"BODY[]", []FetchAttribute{{Name: "BODY", Section: &BodySection{Part: []int{}}}}},
+		{"BODY.PEEK[2.1.MIME]", []FetchAttribute{{Name: "BODY", Peek: true,
+			Section: &BodySection{Part: []int{2, 1}, Specifier: "MIME"}}}},
+		{"BODY[header.fields.not (From To)]", []FetchAttribute{{Name: "BODY",
+			Section: &BodySection{Part: []int{}, Specifier: "HEADER.FIELDS.NOT", Fields: []string{"From", "To"}}}}},
+		{"BINARY.PEEK[1]<20.100>", []FetchAttribute{{Name: "BINARY", Peek: true,
+			Section: &BodySection{Part: []int{1}}, Partial: &PartialRange{Origin: 20, Count: 100}}}},
+		{"(ANNOTATION (/comment value.priv))", []FetchAttribute{{Name: "ANNOTATION",
+			Args: []util.ListItem{{Value: "/comment"}, {Value: "value.priv"}}}}},
+	}
+	for _, test := range tests {
+		attrs, err := parseFetchAttributes(t, test.items)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", test.items, err)
+			continue
+		}
+		if !reflect.DeepEqual(attrs, test.expected) {
+			t.Errorf("%s parsed as %#v, expected %#v", test.items, attrs, test.expected)
+		}
+	}
+}
+
+func TestParseFetchAttributesErrors(t *testing.T) {
+	for _, s := range []string{
+		`("FLAGS")`,
+		"((FLAGS))",
+		"BODY[0]",
+		"BODY[MIME]",
+		"BODY[1.FOOTER]",
+		"BODY[HEADER.FIELDS]",
+		"BODY[TEXT (From)]",
+		"BINARY[1.TEXT]",
+		"BODY[]<10>",
+		"BODY[]<0.0>",
+	} {
+		_, err := parseFetchAttributes(t, s)
+		if _, ok := err.(*FetchSyntaxError); !ok {
+			t.Errorf("Expected a FetchSyntaxError parsing %s, got %v", s, err)
+		}
+	}
+}
+
+func TestFetchAttributeString(t *testing.T) {
+	for _, s := range []string{
+		"FLAGS",
+		"BODY[]",
+		"BODY.PEEK[1.2.HEADER.FIELDS (From Subject)]<0.1024>",
+		"BINARY.SIZE[3]",
+		"ANNOTATION (/comment value)",
+	} {
+		attrs, err := parseFetchAttributes(t, s)
+		if err != nil || len(attrs) != 1 {
+			t.Errorf("Error parsing %s: %v", s, err)
+			continue
+		}
+		if attrs[0].String() != s {
+			t.Errorf("%s formatted as %s", s, attrs[0].String())
+		}
+	}
+}
@@ -23,25 +23,71 @@ func CombineFlags(flags ...Flags) Flags {
 }
 
 func FlagsFromString(imapFlagString string) (f Flags) {
-	for _, flag := range strings.Split(imapFlagString, " ") {
-		switch flag {
-		case "\\Seen":
+	for _, flag := range strings.Fields(imapFlagString) {
+		// Flag names are case-insensitive
+		switch strings.ToUpper(flag) {
+		case "\\SEEN":
 			f = f.SetFlags(FlagSeen)
-		case "\\Answered":
+		case "\\ANSWERED":
 			f = f.SetFlags(FlagAnswered)
-		case "\\Flagged":
+		case "\\FLAGGED":
 			f = f.SetFlags(FlagFlagged)
-		case "\\Deleted":
+		case "\\DELETED":
 			f = f.SetFlags(FlagDeleted)
-		case "\\Draft":
+		case "\\DRAFT":
 			f = f.SetFlags(FlagDraft)
-		case "\\Recent":
+		case "\\RECENT":
 			f = f.SetFlags(FlagRecent)
 		}
 	}
 	return f
 }
 
+// KeywordsFromString returns the keywords in an IMAP flag list, ie the flags
+// which aren't system flags, such as $Forwarded or $Label1
+func KeywordsFromString(imapFlagString string) []string {
+	var keywords []string
+	for _, flag := range strings.Fields(imapFlagString) {
+		if !strings.HasPrefix(flag, "\\") {
+			keywords = AddKeywords(keywords, []string{flag})
+		}
+	}
+	return keywords
+}
+
+// AddKeywords returns the keywords with those being added appended, except
+// for any already present. Keywords are case-insensitive.
+func AddKeywords(keywords []string, add []string) []string {
+	result := append([]string(nil), keywords...)
+	for _, keyword := range add {
+		if !HasKeyword(result, keyword) {
+			result = append(result, keyword)
+		}
+	}
+	return result
+}
+
+// RemoveKeywords returns the keywords without those being removed
+func RemoveKeywords(keywords []string, remove []string) []string {
+	var result []string
+	for _, keyword := range keywords {
+		if !HasKeyword(remove, keyword) {
+			result = append(result, keyword)
+		}
+	}
+	return result
+}
+
+// HasKeyword returns true if the keyword is in the list, in any case
+func HasKeyword(keywords []string, keyword string) bool {
+	for _, k := range keywords {
+		if strings.EqualFold(k, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
 func (f Flags) ResetFlags(remove Flags) Flags {
 	f &^= remove
 	return f
@@ -84,3 +130,88 @@ func (f Flags) Strings() []string {
 func (f Flags) String() string {
 	return strings.Join(f.Strings(), " ")
 }
+
+// FlagList holds every flag on a message: its system flags, such as \Seen,
+// and its keywords, such as $Forwarded
+type FlagList struct {
+	System   Flags
+	Keywords []string
+}
+
+// Equal returns true if both lists hold the same system flags and the same
+// keywords, in any order and any case
+func (l FlagList) Equal(other FlagList) bool {
+	if l.System != other.System || len(l.Keywords) != len(other.Keywords) {
+		return false
+	}
+	for _, keyword := range l.Keywords {
+		if !HasKeyword(other.Keywords, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// FlagSyntaxError describes why a flag list sent by a client is invalid
+type FlagSyntaxError struct {
+	Flag   string // The offending flag, or blank if the list is malformed
+	Reason string
+}
+
+func (e *FlagSyntaxError) Error() string {
+	if e.Flag == "" {
+		return "Invalid flag list, " + e.Reason
+	}
+	return "Invalid flag " + e.Flag + ", " + e.Reason
+}
+
+// The characters which can't appear in a keyword (atom-specials in RFC3501)
+const keywordSpecials = "(){ %*\"\\]"
+
+// ParseFlagList parses a flag list as sent by a client, such as
+// (\Seen $Forwarded). The parentheses may be left out, as STORE allows.
+// System flags may be in any case; keywords keep the case they're sent in,
+// but only the first of several which differ only in case is kept.
+func ParseFlagList(imapFlagList string) (FlagList, error) {
+	list := FlagList{}
+	inner := strings.TrimSpace(imapFlagList)
+	if strings.HasPrefix(inner, "(") {
+		if !strings.HasSuffix(inner, ")") {
+			return list, &FlagSyntaxError{Reason: "expected a closing parenthesis"}
+		}
+		inner = inner[1 : len(inner)-1]
+	}
+
+	for _, flag := range strings.Fields(inner) {
+		if strings.HasPrefix(flag, "\\") {
+			f, ok := systemFlags[strings.ToUpper(flag)]
+			if !ok && !strings.EqualFold(flag, "\\Recent") {
+				return list, &FlagSyntaxError{flag, "not a system flag"}
+			}
+			if !ok {
+				f = FlagRecent
+			}
+			list.System = list.System.SetFlags(f)
+			continue
+		}
+		if strings.ContainsAny(flag, keywordSpecials) || strings.IndexFunc(flag, isControl) >= 0 {
+			return list, &FlagSyntaxError{flag, "keywords must be atoms"}
+		}
+		list.Keywords = AddKeywords(list.Keywords, []string{flag})
+	}
+	return list, nil
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r >= 0x7f
+}
+
+// Strings returns the system flags in IMAP format followed by the keywords
+func (l FlagList) Strings() []string {
+	return append(l.System.Strings(), l.Keywords...)
+}
+
+// String formats the flags as a parenthesised list, eg (\Seen $Forwarded)
+func (l FlagList) String() string {
+	return "(" + strings.Join(l.Strings(), " ") + ")"
+}
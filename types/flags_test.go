@@ -1,6 +1,9 @@
 package types
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestCombine(t *testing.T) {
 	c1 := CombineFlags(FlagSeen, FlagDraft|FlagDeleted)
@@ -52,4 +55,104 @@ func TestFlagsFromString(t *testing.T) {
 	if c1 != expected {
 		t.Errorf("Expected %d, Actual %d", expected, c1)
 	}
+
+	c2 := FlagsFromString("\\flagged  \\DRAFT")
+	if c2 != FlagFlagged|FlagDraft {
+		t.Errorf("Expected flag names to be case-insensitive, got %d", c2)
+	}
+}
+
+func TestPermanentFlags(t *testing.T) {
+	policy := PermanentFlags{Flags: CombineFlags(FlagSeen, FlagDeleted)}
+	if s := policy.String(); s != "(\\Seen \\Deleted)" {
+		t.Errorf("Expected (\\Seen \\Deleted), got %s", s)
+	}
+	for flag, permitted := range map[string]bool{
+		"\\seen":     true,
+		"\\Deleted":  true,
+		"\\Flagged":  false,
+		"\\Recent":   false,
+		"\\Unknown":  false,
+		"$Forwarded": false,
+	} {
+		if policy.Permits(flag) != permitted {
+			t.Errorf("Expected %s to be permitted: %t", flag, permitted)
+		}
+	}
+
+	policy.Keywords = true
+	if !policy.Permits("$Forwarded") || policy.String() != "(\\Seen \\Deleted \\*)" {
+		t.Errorf("Expected keywords to be permitted, got %s", policy)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	keywords := KeywordsFromString("\\Seen $Forwarded $Label1 $forwarded")
+	if !reflect.DeepEqual(keywords, []string{"$Forwarded", "$Label1"}) {
+		t.Errorf("Expected each keyword once without system flags, got %v", keywords)
+	}
+
+	keywords = AddKeywords(keywords, []string{"$LABEL1", "$Junk"})
+	if !reflect.DeepEqual(keywords, []string{"$Forwarded", "$Label1", "$Junk"}) {
+		t.Errorf("Expected only new keywords to be added, got %v", keywords)
+	}
+
+	keywords = RemoveKeywords(keywords, []string{"$label1"})
+	if !reflect.DeepEqual(keywords, []string{"$Forwarded", "$Junk"}) {
+		t.Errorf("Expected keywords to be removed in any case, got %v", keywords)
+	}
+}
+
+func TestParseFlagList(t *testing.T) {
+	list, err := ParseFlagList(`(\seen $Forwarded \Deleted $forwarded Junk)`)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	expected := FlagList{System: FlagSeen | FlagDeleted, Keywords: []string{"$Forwarded", "Junk"}}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("Expected %v, Actual %v", expected, list)
+	}
+
+	list, err = ParseFlagList(`\Answered`)
+	if err != nil || list.System != FlagAnswered {
+		t.Errorf("Flags without parentheses parsed as %v, %v", list, err)
+	}
+
+	list, err = ParseFlagList("()")
+	if err != nil || !reflect.DeepEqual(list, FlagList{}) {
+		t.Errorf("Empty flag list parsed as %v, %v", list, err)
+	}
+
+	for _, invalid := range []string{`(\Seen`, `(\Important)`, `(Foo%)`, `(Foo]`} {
+		if _, err := ParseFlagList(invalid); err == nil {
+			t.Errorf("Expected an error parsing %s", invalid)
+		}
+	}
+}
+
+func TestFlagListString(t *testing.T) {
+	list := FlagList{System: FlagSeen | FlagFlagged, Keywords: []string{"$Forwarded"}}
+	if s := list.String(); s != `(\Seen \Flagged $Forwarded)` {
+		t.Errorf("Flag list formatted as %s", s)
+	}
+	if s := (FlagList{}).String(); s != "()" {
+		t.Errorf("Empty flag list formatted as %s", s)
+	}
+}
+
+func TestFlagListEqual(t *testing.T) {
+	list := FlagList{System: FlagSeen, Keywords: []string{"$Work", "$Forwarded"}}
+	for _, other := range []struct {
+		list  FlagList
+		equal bool
+	}{
+		{FlagList{System: FlagSeen, Keywords: []string{"$forwarded", "$WORK"}}, true},
+		{FlagList{System: FlagSeen | FlagFlagged, Keywords: []string{"$Work", "$Forwarded"}}, false},
+		{FlagList{System: FlagSeen, Keywords: []string{"$Work"}}, false},
+		{FlagList{System: FlagSeen, Keywords: []string{"$Work", "$Personal"}}, false},
+	} {
+		if list.Equal(other.list) != other.equal {
+			t.Errorf("Expected %s equal to %s to be %t", list, other.list, other.equal)
+		}
+	}
 }
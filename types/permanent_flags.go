@@ -0,0 +1,44 @@
+package types
+
+import "strings"
+
+// PermanentFlags describes the flags a mailbox can store permanently, as
+// announced to clients in the PERMANENTFLAGS response code
+type PermanentFlags struct {
+	Flags    Flags // The system flags which can be stored
+	Keywords bool  // Whether clients may store keywords, announced as \*
+}
+
+// DefaultPermanentFlags allows every system flag which a client may set,
+// but no keywords
+var DefaultPermanentFlags = PermanentFlags{
+	Flags: CombineFlags(FlagSeen, FlagAnswered, FlagFlagged, FlagDeleted, FlagDraft),
+}
+
+// The system flags which clients may set, by name
+var systemFlags = map[string]Flags{
+	"\\SEEN":     FlagSeen,
+	"\\ANSWERED": FlagAnswered,
+	"\\FLAGGED":  FlagFlagged,
+	"\\DELETED":  FlagDeleted,
+	"\\DRAFT":    FlagDraft,
+}
+
+// Permits returns true if a flag (eg "\Seen") or keyword (eg "$Forwarded")
+// can be stored. \Recent and unknown system flags are never permitted.
+func (p PermanentFlags) Permits(flag string) bool {
+	if strings.HasPrefix(flag, "\\") {
+		f, ok := systemFlags[strings.ToUpper(flag)]
+		return ok && p.Flags.HasFlags(f)
+	}
+	return p.Keywords
+}
+
+// String formats the flags as a parenthesised list, eg (\Seen \Deleted \*)
+func (p PermanentFlags) String() string {
+	flags := p.Flags.ResetFlags(FlagRecent).Strings()
+	if p.Keywords {
+		flags = append(flags, "\\*")
+	}
+	return "(" + strings.Join(flags, " ") + ")"
+}
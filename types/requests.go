@@ -0,0 +1,197 @@
+package types
+
+import (
+	"time"
+)
+
+// Request is a command sent by a client, with its arguments parsed. The
+// server's command parser produces requests for its handlers to carry out,
+// and extensions and tests may construct or inspect them directly.
+type Request interface {
+	// Command returns the name of the command, eg "SELECT" or "UID FETCH"
+	Command() string
+}
+
+// Prefix a command name with UID for the UID variant of a command
+func uidCommand(uid bool, command string) string {
+	if uid {
+		return "UID " + command
+	}
+	return command
+}
+
+// SelectRequest opens a mailbox with SELECT, or read-only with EXAMINE
+type SelectRequest struct {
+	Mailbox   string // Name of the mailbox, decoded from modified UTF-7
+	ReadOnly  bool
+	CondStore bool           // CONDSTORE was given, enabling it (RFC 7162 section 3.1.8)
+	QResync   *QResyncParams // The client's cached state, given with QRESYNC, or nil
+}
+
+// QResyncParams describes what a client knows of a mailbox it has opened
+// before, so that SELECT or EXAMINE can tell it only what has changed since
+// (RFC 7162 section 3.2.5)
+type QResyncParams struct {
+	UIDValidity uint32
+	ModSeq      uint64 // The highest mod-sequence the client knows of
+	KnownUIDs   UIDSet // The UIDs the client knows of, or nil if not given
+
+	// Some sequence numbers the client knows of, and the UIDs it knows them
+	// by, which a server without a full change log may use to work out
+	// which messages have been expunged
+	KnownSequences SequenceSet
+	KnownSeqUIDs   UIDSet
+}
+
+// Command implements the Command method on the Request interface
+func (r SelectRequest) Command() string {
+	if r.ReadOnly {
+		return "EXAMINE"
+	}
+	return "SELECT"
+}
+
+// FetchRequest retrieves data about a set of messages with FETCH, or with
+// UID FETCH if the set is of UIDs rather than sequence numbers
+type FetchRequest struct {
+	UID        bool
+	Set        SequenceSet      // The messages fetched, when UID is false
+	UIDs       UIDSet           // The messages fetched, when UID is true
+	Attributes []FetchAttribute // Data items, with any macros expanded
+
+	// Only messages changed since this mod-sequence are fetched, if it
+	// isn't 0 (CHANGEDSINCE, RFC 7162 section 3.1.4)
+	ChangedSince uint64
+
+	// Messages expunged since ChangedSince are reported too (VANISHED, RFC
+	// 7162 section 3.2.6)
+	Vanished bool
+}
+
+// Command implements the Command method on the Request interface
+func (r FetchRequest) Command() string {
+	return uidCommand(r.UID, "FETCH")
+}
+
+// StoreOperation says how STORE changes the flags of messages
+type StoreOperation string
+
+const (
+	StoreReplace StoreOperation = ""  // FLAGS replaces the flags
+	StoreAdd     StoreOperation = "+" // +FLAGS adds to them
+	StoreRemove  StoreOperation = "-" // -FLAGS removes from them
+)
+
+// StoreRequest changes the flags of a set of messages with STORE or
+// UID STORE
+type StoreRequest struct {
+	UID       bool
+	Set       SequenceSet // The messages changed, when UID is false
+	UIDs      UIDSet      // The messages changed, when UID is true
+	Operation StoreOperation
+	Silent    bool     // The new flags aren't sent back (.SILENT)
+	Flags     FlagList // System flags and keywords, eg \Seen or $Forwarded
+
+	// Only messages not changed since UnchangedSince are changed, if
+	// Conditional is set (UNCHANGEDSINCE, RFC 7162 section 3.1.3)
+	Conditional    bool
+	UnchangedSince uint64
+}
+
+// Command implements the Command method on the Request interface
+func (r StoreRequest) Command() string {
+	return uidCommand(r.UID, "STORE")
+}
+
+// SearchRequest finds the messages matching some criteria with SEARCH, or
+// their UIDs with UID SEARCH
+type SearchRequest struct {
+	UID      bool
+	Return   *SearchReturn // The RETURN options of an extended search, or nil
+	Charset  string        // The charset of the criteria's strings, or "" for US-ASCII
+	Criteria SearchCriteria
+}
+
+// Command implements the Command method on the Request interface
+func (r SearchRequest) Command() string {
+	return uidCommand(r.UID, "SEARCH")
+}
+
+// SortRequest finds the messages matching some criteria and orders them
+// with SORT, or their UIDs with UID SORT (RFC 5256)
+type SortRequest struct {
+	UID      bool
+	Return   *SearchReturn // The RETURN options of an extended sort, or nil
+	Order    []SortCriterion
+	Charset  string
+	Criteria SearchCriteria
+}
+
+// Command implements the Command method on the Request interface
+func (r SortRequest) Command() string {
+	return uidCommand(r.UID, "SORT")
+}
+
+// MultiSearchRequest finds the messages matching some criteria in several
+// mailboxes at once with ESEARCH (RFC 7377)
+type MultiSearchRequest struct {
+	Sources  []MailboxFilter // The mailboxes to search, given after IN
+	Return   *SearchReturn   // The RETURN options, or nil
+	Charset  string
+	Criteria SearchCriteria
+}
+
+// Command implements the Command method on the Request interface
+func (r MultiSearchRequest) Command() string {
+	return "ESEARCH"
+}
+
+// MailboxFilter picks out a set of a user's mailboxes (RFC 5465 section 6),
+// eg "personal" for all of them or "subtree" for those below some mailboxes
+type MailboxFilter struct {
+	Kind      string   // The filter's name in lower case, eg "inboxes"
+	Mailboxes []string // The mailboxes given to "subtree", "subtree-one" and "mailboxes"
+}
+
+// AppendRequest adds a message to a mailbox with APPEND. The message itself
+// follows the command as a literal of the given length.
+type AppendRequest struct {
+	Mailbox string    // Name of the mailbox, decoded from modified UTF-7
+	Flags   FlagList  // Flags to set on the message
+	Date    time.Time // Internal date to give the message, if not zero
+	Binary  bool      // The message is sent as a literal8 (~{N})
+	Length  uint64    // Size of the message literal in bytes
+	NonSync bool      // The literal is non-synchronizing ({N+})
+}
+
+// Command implements the Command method on the Request interface
+func (r AppendRequest) Command() string {
+	return "APPEND"
+}
+
+// ReplaceRequest replaces a message in the selected mailbox with a new one,
+// which may be put in another mailbox, with REPLACE or UID REPLACE
+type ReplaceRequest struct {
+	UID     bool
+	Message uint32        // Sequence number or UID of the message replaced
+	Append  AppendRequest // Where and how to save the replacement
+}
+
+// Command implements the Command method on the Request interface
+func (r ReplaceRequest) Command() string {
+	return uidCommand(r.UID, "REPLACE")
+}
+
+// CopyRequest copies a set of messages to another mailbox with COPY or
+// UID COPY
+type CopyRequest struct {
+	UID     bool
+	Set     SequenceSet // The messages copied, when UID is false
+	UIDs    UIDSet      // The messages copied, when UID is true
+	Mailbox string      // Name of the destination, decoded from modified UTF-7
+}
+
+// Command implements the Command method on the Request interface
+func (r CopyRequest) Command() string {
+	return uidCommand(r.UID, "COPY")
+}
@@ -0,0 +1,26 @@
+package types
+
+import "testing"
+
+func TestRequestCommand(t *testing.T) {
+	tests := []struct {
+		req      Request
+		expected string
+	}{
+		{SelectRequest{Mailbox: "INBOX"}, "SELECT"},
+		{SelectRequest{Mailbox: "INBOX", ReadOnly: true}, "EXAMINE"},
+		{FetchRequest{}, "FETCH"},
+		{FetchRequest{UID: true}, "UID FETCH"},
+		{StoreRequest{UID: true}, "UID STORE"},
+		{SearchRequest{}, "SEARCH"},
+		{SortRequest{UID: true}, "UID SORT"},
+		{AppendRequest{}, "APPEND"},
+		{ReplaceRequest{UID: true}, "UID REPLACE"},
+		{CopyRequest{}, "COPY"},
+	}
+	for _, test := range tests {
+		if command := test.req.Command(); command != test.expected {
+			t.Errorf("Expected %s, Actual %s", test.expected, command)
+		}
+	}
+}
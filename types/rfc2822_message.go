@@ -3,8 +3,16 @@ package types
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net/textproto"
+	"strconv"
+	"strings"
 )
 
 type RFC2822Message struct {
@@ -30,3 +38,143 @@ func MessageFromBytes(msgBytes []byte) (msg RFC2822Message, err error) {
 
 	return msg, nil
 }
+
+// SplitRawMessage splits the text of a message into its header, including
+// the blank line which ends it, and its body
+func SplitRawMessage(raw []byte) (header []byte, body []byte) {
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if end < 0 {
+		return raw, nil
+	}
+	return raw[:end+4], raw[end+4:]
+}
+
+// FilterHeader returns the fields of a raw header whose names are (or, if
+// exclude is true, are not) in the given list, exactly as they appear in
+// the header and in the same order. The result ends with a blank line.
+func FilterHeader(header []byte, names []string, exclude bool) []byte {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+
+	var result bytes.Buffer
+	keep := false
+	for _, line := range strings.SplitAfter(string(header), "\n") {
+		if strings.TrimRight(line, "\r\n") == "" {
+			continue
+		}
+		// Lines starting with whitespace continue the previous field
+		if line[0] != ' ' && line[0] != '\t' {
+			name := line
+			if colon := strings.Index(line, ":"); colon >= 0 {
+				name = line[:colon]
+			}
+			keep = wanted[strings.ToLower(strings.TrimSpace(name))] != exclude
+		}
+		if keep {
+			result.WriteString(line)
+		}
+	}
+	result.WriteString("\r\n")
+	return result.Bytes()
+}
+
+// ErrUnknownTransferEncoding indicates that a message part's
+// Content-Transfer-Encoding is not one that can be decoded
+var ErrUnknownTransferEncoding = errors.New("Unknown Content-Transfer-Encoding")
+
+// ErrNoSuchPart indicates that a requested MIME part does not exist
+var ErrNoSuchPart = errors.New("No such message part")
+
+// ParsePartPath interprets a dot separated MIME part specifier such as
+// "1.2.3", as used in IMAP BODY and BINARY section specifications
+func ParsePartPath(partStr string) ([]int, error) {
+	if partStr == "" {
+		return []int{}, nil
+	}
+	parts := strings.Split(partStr, ".")
+	path := make([]int, len(parts))
+	for index, part := range parts {
+		num, err := strconv.ParseUint(part, 10, 31)
+		if err != nil || num == 0 {
+			return nil, ErrNoSuchPart
+		}
+		path[index] = int(num)
+	}
+	return path, nil
+}
+
+// Part returns the MIME part of the message identified by the given path,
+// where each element is a 1-based part index. A message which is not
+// multipart only contains part 1, which is the message body itself, unless
+// it is an attached message (message/rfc822) whose parts are those of the
+// message it contains.
+func (msg RFC2822Message) Part(path []int) (RFC2822Message, error) {
+	if len(path) == 0 {
+		return msg, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Headers.Get("Content-Type"))
+
+	// The parts of an attached message are numbered as parts of the
+	// message/rfc822 part itself
+	if err == nil && mediaType == "message/rfc822" {
+		inner, err := MessageFromBytes([]byte(msg.Body))
+		if err != nil {
+			return RFC2822Message{}, ErrNoSuchPart
+		}
+		return inner.Part(path)
+	}
+
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		if path[0] != 1 || len(path) > 1 {
+			return RFC2822Message{}, ErrNoSuchPart
+		}
+		return msg, nil
+	}
+
+	reader := multipart.NewReader(strings.NewReader(msg.Body), params["boundary"])
+	for index := 1; ; index++ {
+		// Raw parts are used so that the transfer encoding is left intact
+		part, err := reader.NextRawPart()
+		if err != nil {
+			return RFC2822Message{}, ErrNoSuchPart
+		}
+		if index < path[0] {
+			continue
+		}
+
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return RFC2822Message{}, err
+		}
+		subPart := RFC2822Message{
+			Headers: textproto.MIMEHeader(part.Header),
+			Body:    string(body),
+		}
+		return subPart.Part(path[1:])
+	}
+}
+
+// DecodedBody returns the body of the message with any
+// Content-Transfer-Encoding removed
+func (msg RFC2822Message) DecodedBody() ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(msg.Headers.Get("Content-Transfer-Encoding")))
+	switch encoding {
+	case "", "7bit", "8bit", "binary":
+		return []byte(msg.Body), nil
+	case "base64":
+		// Encoded lines are wrapped, so remove all whitespace before decoding
+		stripped := strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, msg.Body)
+		return base64.StdEncoding.DecodeString(stripped)
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(msg.Body)))
+	}
+	return nil, ErrUnknownTransferEncoding
+}
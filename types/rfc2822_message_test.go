@@ -0,0 +1,116 @@
+package types
+
+import "testing"
+
+const multipartMessage = "Subject: Multipart\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"XYZ\"\r\n" +
+	"\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=C3=A9\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAEC\r\n" +
+	"--XYZ--\r\n"
+
+func TestMessagePart(t *testing.T) {
+	msg, err := MessageFromBytes([]byte(multipartMessage))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s", err)
+	}
+
+	part, err := msg.Part([]int{1})
+	if err != nil {
+		t.Fatalf("Error getting part 1: %s", err)
+	}
+	data, err := part.DecodedBody()
+	if err != nil || string(data) != "Café" {
+		t.Errorf("Expected part 1 to decode to 'Café', got '%s' (%v)", data, err)
+	}
+
+	part, err = msg.Part([]int{2})
+	if err != nil {
+		t.Fatalf("Error getting part 2: %s", err)
+	}
+	data, err = part.DecodedBody()
+	if err != nil || string(data) != "\x00\x01\x02" {
+		t.Errorf("Expected part 2 to decode to binary data, got %q (%v)", data, err)
+	}
+
+	if _, err = msg.Part([]int{3}); err != ErrNoSuchPart {
+		t.Errorf("Expected ErrNoSuchPart for part 3, got %v", err)
+	}
+}
+
+func TestParsePartPath(t *testing.T) {
+	path, err := ParsePartPath("1.2.3")
+	if err != nil || len(path) != 3 || path[0] != 1 || path[1] != 2 || path[2] != 3 {
+		t.Errorf("Expected [1 2 3], got %v (%v)", path, err)
+	}
+
+	if _, err = ParsePartPath("1.0"); err != ErrNoSuchPart {
+		t.Errorf("Expected ErrNoSuchPart for part 0, got %v", err)
+	}
+}
+
+func TestAttachedMessagePart(t *testing.T) {
+	msg, err := MessageFromBytes([]byte("Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"Content-Type: multipart/alternative; boundary=c\r\n" +
+		"\r\n" +
+		"--c\r\n" +
+		"\r\n" +
+		"Plain\r\n" +
+		"--c\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>HTML</p>\r\n" +
+		"--c--\r\n" +
+		"--b--\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing message: %s", err)
+	}
+
+	part, err := msg.Part([]int{1, 2})
+	if err != nil {
+		t.Fatalf("Error getting part 1.2: %s", err)
+	}
+	if part.Body != "<p>HTML</p>" {
+		t.Errorf("Expected the second part of the attached message, got %q", part.Body)
+	}
+	if _, err = msg.Part([]int{1, 3}); err != ErrNoSuchPart {
+		t.Errorf("Expected ErrNoSuchPart for part 1.3, got %v", err)
+	}
+}
+
+func TestFilterHeader(t *testing.T) {
+	header, body := SplitRawMessage([]byte("Subject: A long\r\n" +
+		"\tsubject\r\n" +
+		"From: me@example.com\r\n" +
+		"X-Priority: 1\r\n" +
+		"to: you@example.com\r\n" +
+		"\r\n" +
+		"Body\r\n"))
+	if string(body) != "Body\r\n" {
+		t.Errorf("Expected the body to be split from the header, got %q", body)
+	}
+
+	filtered := FilterHeader(header, []string{"TO", "subject"}, false)
+	expected := "Subject: A long\r\n\tsubject\r\nto: you@example.com\r\n\r\n"
+	if string(filtered) != expected {
+		t.Errorf("Expected %q, got %q", expected, filtered)
+	}
+
+	filtered = FilterHeader(header, []string{"Subject", "X-Priority"}, true)
+	expected = "From: me@example.com\r\nto: you@example.com\r\n\r\n"
+	if string(filtered) != expected {
+		t.Errorf("Expected %q, got %q", expected, filtered)
+	}
+}
@@ -0,0 +1,426 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordwest/imap-server/util"
+)
+
+// Date format used by SEARCH criteria, eg 1-Feb-1994
+const SearchDate = "2-Jan-2006"
+
+// SearchCriteria is a single search key of a SEARCH command (RFC 3501
+// section 6.4.4). Keys which combine other keys (AND, OR and NOT) form a
+// tree, with the criteria of the whole command at its root.
+type SearchCriteria struct {
+	// The upper case name of the key, eg "FROM", "SINCE" or "OR". A message
+	// sequence set is given the name "SEQUENCE", and a parenthesised list of
+	// keys (or the list of keys making up the command) the name "AND".
+	Key string
+
+	// The string argument of keys such as FROM, BODY and KEYWORD, and the
+	// value searched for by HEADER
+	Value string
+
+	// The field name searched by HEADER
+	Header string
+
+	// The date argument of keys such as SINCE and SENTBEFORE
+	Date time.Time
+
+	// The size argument of LARGER and SMALLER
+	Size uint32
+
+	// The messages matched by SEQUENCE
+	Set SequenceSet
+
+	// The messages matched by UID
+	UIDs UIDSet
+
+	// The mod-sequence argument of MODSEQ (RFC 7162 section 3.1.5). If
+	// MODSEQ names a metadata item, eg "/flags/\\draft", the name is given
+	// in Value and its type ("priv", "shared" or "all") in EntryType.
+	ModSeq    uint64
+	EntryType string
+
+	// The keys combined by AND (any number), OR (two) and NOT (one)
+	Children []SearchCriteria
+}
+
+// SearchSyntaxError describes why search criteria could not be parsed
+type SearchSyntaxError struct {
+	Position int    // Offset of the offending token in the criteria
+	Token    string // The offending token, or blank at the end of the criteria
+	Expected string // What was expected instead
+}
+
+func (e *SearchSyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("Unexpected end of search criteria, expected %s", e.Expected)
+	}
+	return fmt.Sprintf("Unexpected %q at position %d of search criteria, expected %s",
+		e.Token, e.Position, e.Expected)
+}
+
+// The kind of argument each search key takes
+const (
+	searchArgNone = iota
+	searchArgString
+	searchArgDate
+	searchArgNumber
+	searchArgSet
+	searchArgHeader
+	searchArgKey
+	searchArgTwoKeys
+	searchArgModSeq
+)
+
+var searchKeyArgs = map[string]int{
+	"ALL":               searchArgNone,
+	"ANSWERED":          searchArgNone,
+	"DELETED":           searchArgNone,
+	"DRAFT":             searchArgNone,
+	"FLAGGED":           searchArgNone,
+	"NEW":               searchArgNone,
+	"OLD":               searchArgNone,
+	"RECENT":            searchArgNone,
+	"SEEN":              searchArgNone,
+	"UNANSWERED":        searchArgNone,
+	"UNDELETED":         searchArgNone,
+	"UNDRAFT":           searchArgNone,
+	"UNFLAGGED":         searchArgNone,
+	"UNSEEN":            searchArgNone,
+	"SAVEDATESUPPORTED": searchArgNone,
+	"BCC":               searchArgString,
+	"BODY":              searchArgString,
+	"CC":                searchArgString,
+	"FROM":              searchArgString,
+	"KEYWORD":           searchArgString,
+	"SUBJECT":           searchArgString,
+	"TEXT":              searchArgString,
+	"TO":                searchArgString,
+	"UNKEYWORD":         searchArgString,
+	"BEFORE":            searchArgDate,
+	"ON":                searchArgDate,
+	"SINCE":             searchArgDate,
+	"SENTBEFORE":        searchArgDate,
+	"SENTON":            searchArgDate,
+	"SENTSINCE":         searchArgDate,
+	"SAVEDBEFORE":       searchArgDate,
+	"SAVEDON":           searchArgDate,
+	"SAVEDSINCE":        searchArgDate,
+	"LARGER":            searchArgNumber,
+	"SMALLER":           searchArgNumber,
+	"UID":               searchArgSet,
+	"MODSEQ":            searchArgModSeq,
+	"HEADER":            searchArgHeader,
+	"NOT":               searchArgKey,
+	"FUZZY":             searchArgKey,
+	"OR":                searchArgTwoKeys,
+}
+
+// A token of the search criteria, with its offset for error messages
+type searchToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+// ParseSearchCriteria parses the search keys of a SEARCH command (eg
+// `FROM "Smith" SINCE 1-Feb-1994 NOT SEEN`), returning them combined with
+// AND. Parse errors are returned as a *SearchSyntaxError.
+func ParseSearchCriteria(criteria string) (SearchCriteria, error) {
+	tokens, err := tokenizeSearch(criteria)
+	if err != nil {
+		return SearchCriteria{}, err
+	}
+	p := searchParser{tokens: tokens}
+
+	root := SearchCriteria{Key: "AND"}
+	for !p.done() {
+		key, err := p.key()
+		if err != nil {
+			return SearchCriteria{}, err
+		}
+		root.Children = append(root.Children, key)
+	}
+	if len(root.Children) == 0 {
+		return SearchCriteria{}, &SearchSyntaxError{Position: len(criteria), Expected: "a search key"}
+	}
+	return root, nil
+}
+
+// Split search criteria into atoms, quoted strings and parentheses
+func tokenizeSearch(s string) ([]searchToken, error) {
+	tokens := make([]searchToken, 0)
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case ' ':
+			i++
+		case '(', ')':
+			tokens = append(tokens, searchToken{text: s[i : i+1], pos: i})
+			i++
+		case '"':
+			start := i
+			var value strings.Builder
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, &SearchSyntaxError{Position: start, Token: s[start:], Expected: "a closing quote"}
+			}
+			i++
+			tokens = append(tokens, searchToken{text: value.String(), quoted: true, pos: start})
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" ()\"", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, searchToken{text: s[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+func (p *searchParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *searchParser) next(expected string) (searchToken, error) {
+	if p.done() {
+		return searchToken{}, &SearchSyntaxError{Expected: expected}
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+// Read the argument of a search key, which may be an atom or quoted string
+// but not a parenthesis
+func (p *searchParser) arg(expected string) (searchToken, error) {
+	token, err := p.next(expected)
+	if err == nil && !token.quoted && (token.text == "(" || token.text == ")") {
+		return token, syntaxError(token, expected)
+	}
+	return token, err
+}
+
+func syntaxError(token searchToken, expected string) error {
+	return &SearchSyntaxError{Position: token.pos, Token: token.text, Expected: expected}
+}
+
+// Parse a single search key and its arguments
+func (p *searchParser) key() (SearchCriteria, error) {
+	token, err := p.next("a search key")
+	if err != nil {
+		return SearchCriteria{}, err
+	}
+
+	if !token.quoted && token.text == "(" {
+		return p.list()
+	}
+	if !token.quoted && token.text != ")" && setRegexp.MatchString(token.text) {
+		set, err := InterpretSequenceSet(token.text)
+		if err != nil {
+			return SearchCriteria{}, syntaxError(token, "a sequence set")
+		}
+		return SearchCriteria{Key: "SEQUENCE", Set: set}, nil
+	}
+
+	name := strings.ToUpper(token.text)
+	argKind, ok := searchKeyArgs[name]
+	if token.quoted || !ok {
+		return SearchCriteria{}, syntaxError(token, "a search key")
+	}
+	key := SearchCriteria{Key: name}
+
+	switch argKind {
+	case searchArgString:
+		arg, err := p.arg("a string")
+		if err != nil {
+			return key, err
+		}
+		key.Value = arg.text
+
+	case searchArgDate:
+		arg, err := p.arg("a date")
+		if err != nil {
+			return key, err
+		}
+		if key.Date, err = ParseDate(arg.text); err != nil {
+			return key, syntaxError(arg, "a date such as 1-Feb-1994")
+		}
+
+	case searchArgNumber:
+		arg, err := p.arg("a number")
+		if err != nil {
+			return key, err
+		}
+		size, err := strconv.ParseUint(arg.text, 10, 32)
+		if err != nil || arg.quoted {
+			return key, syntaxError(arg, "a number")
+		}
+		key.Size = uint32(size)
+
+	case searchArgSet:
+		arg, err := p.arg("a set of UIDs")
+		if err != nil {
+			return key, err
+		}
+		if key.UIDs, err = InterpretUIDSet(arg.text); err != nil || arg.quoted {
+			return key, syntaxError(arg, "a set of UIDs")
+		}
+
+	case searchArgHeader:
+		field, err := p.arg("a header field name")
+		if err != nil {
+			return key, err
+		}
+		value, err := p.arg("a string")
+		if err != nil {
+			return key, err
+		}
+		key.Header, key.Value = field.text, value.text
+
+	case searchArgModSeq:
+		arg, err := p.arg("a mod-sequence or metadata item")
+		if err != nil {
+			return key, err
+		}
+		if arg.quoted || strings.HasPrefix(arg.text, "/") {
+			entryType, err := p.arg("priv, shared or all")
+			if err != nil {
+				return key, err
+			}
+			switch strings.ToLower(entryType.text) {
+			case "priv", "shared", "all":
+			default:
+				return key, syntaxError(entryType, "priv, shared or all")
+			}
+			key.Value, key.EntryType = arg.text, strings.ToLower(entryType.text)
+			if arg, err = p.arg("a mod-sequence"); err != nil {
+				return key, err
+			}
+		}
+		if key.ModSeq, err = strconv.ParseUint(arg.text, 10, 63); err != nil || arg.quoted {
+			return key, syntaxError(arg, "a mod-sequence")
+		}
+
+	case searchArgKey, searchArgTwoKeys:
+		count := 1
+		if argKind == searchArgTwoKeys {
+			count = 2
+		}
+		for i := 0; i < count; i++ {
+			child, err := p.key()
+			if err != nil {
+				return key, err
+			}
+			key.Children = append(key.Children, child)
+		}
+	}
+	return key, nil
+}
+
+// Parse the keys of a parenthesised list, after the opening parenthesis
+func (p *searchParser) list() (SearchCriteria, error) {
+	list := SearchCriteria{Key: "AND"}
+	for {
+		if p.done() {
+			return list, &SearchSyntaxError{Expected: "a search key or )"}
+		}
+		if !p.tokens[p.pos].quoted && p.tokens[p.pos].text == ")" {
+			if len(list.Children) == 0 {
+				return list, syntaxError(p.tokens[p.pos], "a search key")
+			}
+			p.pos++
+			return list, nil
+		}
+		key, err := p.key()
+		if err != nil {
+			return list, err
+		}
+		list.Children = append(list.Children, key)
+	}
+}
+
+// Matches evaluates the criteria against a single message. The keys which
+// combine other keys (AND, OR and NOT) are evaluated here, and matchKey is
+// called to test the message against each of the other keys. Keys are
+// evaluated left to right, and only until the result is known.
+func (c SearchCriteria) Matches(matchKey func(key SearchCriteria) bool) bool {
+	switch c.Key {
+	case "AND":
+		for _, child := range c.Children {
+			if !child.Matches(matchKey) {
+				return false
+			}
+		}
+		return true
+	case "OR":
+		return c.Children[0].Matches(matchKey) || c.Children[1].Matches(matchKey)
+	case "NOT":
+		return !c.Children[0].Matches(matchKey)
+	}
+	return matchKey(c)
+}
+
+// String formats the criteria as they would be sent in a SEARCH command,
+// which ParseSearchCriteria parses back into the same criteria. Strings are
+// always quoted and dates given in full, so criteria which mean the same
+// are formatted the same.
+func (c SearchCriteria) String() string {
+	return c.format(false)
+}
+
+// Format the criteria, with a list of keys in parentheses if nested is set
+func (c SearchCriteria) format(nested bool) string {
+	switch c.Key {
+	case "AND":
+		keys := make([]string, len(c.Children))
+		for i, child := range c.Children {
+			keys[i] = child.format(true)
+		}
+		if nested {
+			return "(" + strings.Join(keys, " ") + ")"
+		}
+		return strings.Join(keys, " ")
+	case "SEQUENCE":
+		return c.Set.String()
+	}
+
+	s := c.Key
+	switch searchKeyArgs[c.Key] {
+	case searchArgString:
+		s += " " + util.Quote(c.Value)
+	case searchArgDate:
+		s += " " + c.Date.Format(SearchDate)
+	case searchArgNumber:
+		s += " " + strconv.FormatUint(uint64(c.Size), 10)
+	case searchArgSet:
+		s += " " + c.UIDs.String()
+	case searchArgHeader:
+		s += " " + util.Quote(c.Header) + " " + util.Quote(c.Value)
+	case searchArgModSeq:
+		if c.EntryType != "" {
+			s += " " + util.Quote(c.Value) + " " + c.EntryType
+		}
+		s += " " + strconv.FormatUint(c.ModSeq, 10)
+	case searchArgKey, searchArgTwoKeys:
+		for _, child := range c.Children {
+			s += " " + child.format(true)
+		}
+	}
+	return s
+}
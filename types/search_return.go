@@ -0,0 +1,156 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SearchReturn holds the result options of an extended SEARCH or SORT,
+// given in its RETURN list (RFC 4731, RFC 5267). The results are then sent
+// in an ESEARCH response rather than a SEARCH or SORT response.
+type SearchReturn struct {
+	Min   bool // The lowest (or, for SORT, the first) result
+	Max   bool // The highest (or, for SORT, the last) result
+	All   bool // Every result
+	Count bool // The number of results
+
+	// A window of the results, by position
+	Partial *ResultRange
+
+	// Changes to the results are reported as the mailbox changes, until
+	// the client sends CANCELUPDATE
+	Update bool
+
+	// A hint that UPDATE or PARTIAL are likely to follow, which needs no
+	// action from the server
+	Context bool
+
+	// How relevant each result is to the search's FUZZY keys (RFC 6203),
+	// in the order of ALL
+	Relevancy bool
+}
+
+// ResultRange is a window of search results, from the First to the Last
+// result inclusive, where the first result is 1
+type ResultRange struct {
+	First uint32
+	Last  uint32
+}
+
+func (r ResultRange) String() string {
+	return fmt.Sprintf("%d:%d", r.First, r.Last)
+}
+
+// ParseSearchReturn parses the options of a RETURN list, without its
+// parentheses, eg "MIN COUNT" or "PARTIAL 1:100". An empty list asks for
+// ALL.
+func ParseSearchReturn(options string) (SearchReturn, error) {
+	ret := SearchReturn{}
+	fields := strings.Fields(options)
+	for i := 0; i < len(fields); i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "MIN":
+			ret.Min = true
+		case "MAX":
+			ret.Max = true
+		case "ALL":
+			ret.All = true
+		case "COUNT":
+			ret.Count = true
+		case "UPDATE":
+			ret.Update = true
+		case "CONTEXT":
+			ret.Context = true
+		case "RELEVANCY":
+			ret.Relevancy = true
+		case "PARTIAL":
+			if i+1 == len(fields) {
+				return ret, errors.New("PARTIAL must be followed by a range")
+			}
+			i++
+			partial, err := parseResultRange(fields[i])
+			if err != nil {
+				return ret, err
+			}
+			ret.Partial = &partial
+		default:
+			return ret, fmt.Errorf("Unknown RETURN option %s", fields[i])
+		}
+	}
+
+	// Changes to the lowest or highest result can't be reported
+	if ret.Update && (ret.Min || ret.Max) {
+		return ret, errors.New("UPDATE can't be combined with MIN or MAX")
+	}
+	// Relevancy scores are given for the results listed by ALL
+	if ret.Relevancy || !ret.Min && !ret.Max && !ret.Count && ret.Partial == nil {
+		ret.All = true
+	}
+	return ret, nil
+}
+
+// Parse a range of positions, eg 1:100, in either order
+func parseResultRange(s string) (ResultRange, error) {
+	ends := strings.Split(s, ":")
+	if len(ends) != 2 {
+		return ResultRange{}, fmt.Errorf("Invalid PARTIAL range %s", s)
+	}
+	first, err := strconv.ParseUint(ends[0], 10, 32)
+	if err != nil || first == 0 {
+		return ResultRange{}, fmt.Errorf("Invalid PARTIAL range %s", s)
+	}
+	last, err := strconv.ParseUint(ends[1], 10, 32)
+	if err != nil || last == 0 {
+		return ResultRange{}, fmt.Errorf("Invalid PARTIAL range %s", s)
+	}
+	if last < first {
+		first, last = last, first
+	}
+	return ResultRange{First: uint32(first), Last: uint32(last)}, nil
+}
+
+// SortCriterion is a key by which SORT orders messages (RFC 5256), eg DATE
+// or REVERSE SUBJECT
+type SortCriterion struct {
+	Key     string // The upper case name of the key, eg "ARRIVAL"
+	Reverse bool
+}
+
+var sortKeys = map[string]bool{
+	"ARRIVAL":   true,
+	"CC":        true,
+	"DATE":      true,
+	"FROM":      true,
+	"RELEVANCY": true,
+	"SIZE":      true,
+	"SUBJECT":   true,
+	"TO":        true,
+}
+
+// ParseSortCriteria parses the sort criteria of a SORT command, without
+// their parentheses, eg "REVERSE DATE SUBJECT"
+func ParseSortCriteria(criteria string) ([]SortCriterion, error) {
+	order := make([]SortCriterion, 0)
+	reverse := false
+	for _, field := range strings.Fields(criteria) {
+		key := strings.ToUpper(field)
+		if key == "REVERSE" && !reverse {
+			reverse = true
+			continue
+		}
+		if !sortKeys[key] {
+			return nil, fmt.Errorf("Unknown sort key %s", field)
+		}
+		order = append(order, SortCriterion{Key: key, Reverse: reverse})
+		reverse = false
+	}
+	if reverse {
+		return nil, errors.New("REVERSE must be followed by a sort key")
+	}
+	if len(order) == 0 {
+		return nil, errors.New("No sort keys given")
+	}
+	return order, nil
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchReturn(t *testing.T) {
+	tests := []struct {
+		options  string
+		expected SearchReturn
+	}{
+		{"", SearchReturn{All: true}},
+		{"MIN COUNT", SearchReturn{Min: true, Count: true}},
+		{"all update context", SearchReturn{All: true, Update: true, Context: true}},
+		{"PARTIAL 1:100", SearchReturn{Partial: &ResultRange{1, 100}}},
+		{"COUNT PARTIAL 50:11", SearchReturn{Count: true, Partial: &ResultRange{11, 50}}},
+		{"UPDATE", SearchReturn{All: true, Update: true}},
+		{"COUNT RELEVANCY", SearchReturn{All: true, Count: true, Relevancy: true}},
+	}
+	for _, test := range tests {
+		ret, err := ParseSearchReturn(test.options)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", test.options, err)
+			continue
+		}
+		if !reflect.DeepEqual(ret, test.expected) {
+			t.Errorf("Expected %+v for %q, got %+v", test.expected, test.options, ret)
+		}
+	}
+
+	for _, options := range []string{"SAVE", "PARTIAL", "PARTIAL 0:10", "PARTIAL 5", "MIN UPDATE"} {
+		if _, err := ParseSearchReturn(options); err == nil {
+			t.Errorf("Expected an error parsing %q", options)
+		}
+	}
+}
+
+func TestParseSortCriteria(t *testing.T) {
+	order, err := ParseSortCriteria("REVERSE date subject")
+	if err != nil {
+		t.Fatalf("Error parsing sort criteria: %s", err)
+	}
+	expected := []SortCriterion{{Key: "DATE", Reverse: true}, {Key: "SUBJECT"}}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, order)
+	}
+
+	for _, criteria := range []string{"", "REVERSE", "REVERSE REVERSE DATE", "BODY"} {
+		if _, err := ParseSortCriteria(criteria); err == nil {
+			t.Errorf("Expected an error parsing %q", criteria)
+		}
+	}
+}
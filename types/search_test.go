@@ -0,0 +1,130 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchCriteria(t *testing.T) {
+	criteria, err := ParseSearchCriteria(`1:3,5 FROM "John \"JJ\" Smith" OR (SEEN UNDELETED) NOT LARGER 500 SINCE 1-Feb-1994 HEADER X-Priority 1`)
+	if err != nil {
+		t.Fatalf("Error parsing search criteria: %s", err)
+	}
+	if criteria.Key != "AND" || len(criteria.Children) != 5 {
+		t.Fatalf("Expected 5 keys combined with AND, got %+v", criteria)
+	}
+
+	keys := criteria.Children
+	if keys[0].Key != "SEQUENCE" || len(keys[0].Set) != 2 {
+		t.Errorf("Expected a sequence set of 2 ranges, got %+v", keys[0])
+	}
+	if keys[1].Key != "FROM" || keys[1].Value != `John "JJ" Smith` {
+		t.Errorf("Expected FROM with an unquoted string, got %+v", keys[1])
+	}
+
+	or := keys[2]
+	if or.Key != "OR" || len(or.Children) != 2 || or.Children[0].Key != "AND" ||
+		len(or.Children[0].Children) != 2 || or.Children[1].Key != "NOT" {
+		t.Errorf("Expected OR of a list and NOT, got %+v", or)
+	}
+	if larger := or.Children[1].Children[0]; larger.Key != "LARGER" || larger.Size != 500 {
+		t.Errorf("Expected NOT LARGER 500, got %+v", larger)
+	}
+
+	if keys[3].Key != "SINCE" || !keys[3].Date.Equal(time.Date(1994, time.February, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected SINCE 1-Feb-1994, got %+v", keys[3])
+	}
+	if keys[4].Key != "HEADER" || keys[4].Header != "X-Priority" || keys[4].Value != "1" {
+		t.Errorf("Expected HEADER X-Priority 1, got %+v", keys[4])
+	}
+}
+
+func TestParseSearchModSeq(t *testing.T) {
+	criteria, err := ParseSearchCriteria(`MODSEQ 20 MODSEQ "/flags/\\draft" all 620162338`)
+	if err != nil {
+		t.Fatalf("Error parsing search criteria: %s", err)
+	}
+	keys := criteria.Children
+	if keys[0].Key != "MODSEQ" || keys[0].ModSeq != 20 || keys[0].EntryType != "" {
+		t.Errorf("Expected MODSEQ 20, got %+v", keys[0])
+	}
+	if keys[1].Value != `/flags/\draft` || keys[1].EntryType != "all" || keys[1].ModSeq != 620162338 {
+		t.Errorf("Expected MODSEQ of the draft flag, got %+v", keys[1])
+	}
+	expected := `MODSEQ 20 MODSEQ "/flags/\\draft" all 620162338`
+	if s := criteria.String(); s != expected {
+		t.Errorf("Expected %s, Actual %s", expected, s)
+	}
+}
+
+func TestParseSearchCriteriaErrors(t *testing.T) {
+	tests := []struct {
+		criteria string
+		err      string
+	}{
+		{"", "Unexpected end of search criteria, expected a search key"},
+		{"FROM", "Unexpected end of search criteria, expected a string"},
+		{"SEEN FROBNICATE", `Unexpected "FROBNICATE" at position 5 of search criteria, expected a search key`},
+		{"SINCE 1994-02-01", `Unexpected "1994-02-01" at position 6 of search criteria, expected a date such as 1-Feb-1994`},
+		{"LARGER big", `Unexpected "big" at position 7 of search criteria, expected a number`},
+		{"(SEEN", "Unexpected end of search criteria, expected a search key or )"},
+		{"()", `Unexpected ")" at position 1 of search criteria, expected a search key`},
+		{"SUBJECT \"unterminated", `Unexpected "\"unterminated" at position 8 of search criteria, expected a closing quote`},
+		{"OR SEEN", "Unexpected end of search criteria, expected a search key"},
+		{"MODSEQ \"/flags/\\\\seen\" mine 5", `Unexpected "mine" at position 23 of search criteria, expected priv, shared or all`},
+		{"MODSEQ -1", `Unexpected "-1" at position 7 of search criteria, expected a mod-sequence`},
+	}
+	for _, test := range tests {
+		_, err := ParseSearchCriteria(test.criteria)
+		if err == nil {
+			t.Errorf("%q: expected error %q", test.criteria, test.err)
+			continue
+		}
+		if _, ok := err.(*SearchSyntaxError); !ok || err.Error() != test.err {
+			t.Errorf("%q: expected error %q, got %q", test.criteria, test.err, err)
+		}
+	}
+}
+
+func TestSearchCriteriaString(t *testing.T) {
+	criteria, err := ParseSearchCriteria(`1:3,5 from Smith OR (seen UID 10:*) NOT larger 500 SINCE 01-Feb-1994 HEADER X-Priority "1"`)
+	if err != nil {
+		t.Fatalf("Error parsing search criteria: %s", err)
+	}
+	expected := `1:3,5 FROM "Smith" OR (SEEN UID 10:*) NOT LARGER 500 SINCE 1-Feb-1994 HEADER "X-Priority" "1"`
+	if s := criteria.String(); s != expected {
+		t.Errorf("Expected %s, Actual %s", expected, s)
+	}
+
+	reparsed, err := ParseSearchCriteria(criteria.String())
+	if err != nil || !reflect.DeepEqual(reparsed, criteria) {
+		t.Errorf("Formatted criteria parsed as %+v, %v", reparsed, err)
+	}
+}
+
+func TestSearchCriteriaMatches(t *testing.T) {
+	criteria, err := ParseSearchCriteria(`OR SEEN NOT (FLAGGED DRAFT) UID 3:5`)
+	if err != nil {
+		t.Fatalf("Error parsing search criteria: %s", err)
+	}
+
+	// A message which is only flagged, with UID 4
+	var tested []string
+	matched := criteria.Matches(func(key SearchCriteria) bool {
+		tested = append(tested, key.Key)
+		switch key.Key {
+		case "FLAGGED":
+			return true
+		case "UID":
+			return key.UIDs.Contains(4, 10)
+		}
+		return false
+	})
+	if !matched {
+		t.Errorf("Expected the message to match %s", criteria)
+	}
+	if !reflect.DeepEqual(tested, []string{"SEEN", "FLAGGED", "DRAFT", "UID"}) {
+		t.Errorf("Expected each key to be tested once in order, got %v", tested)
+	}
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -137,3 +138,97 @@ func InterpretSequenceSet(imapSequenceSet string) (seqSet SequenceSet, err error
 
 	return seqSet, nil
 }
+
+// String formats the range as it's sent in commands, eg 5:9 or 15:*
+func (r SequenceRange) String() string {
+	if r.Max.Nil() {
+		return string(r.Min)
+	}
+	return string(r.Min) + ":" + string(r.Max)
+}
+
+// String formats the set as it's sent in commands, eg 1,3,5:9,18:*
+func (s SequenceSet) String() string {
+	ranges := make([]string, len(s))
+	for i, rng := range s {
+		ranges[i] = rng.String()
+	}
+	return strings.Join(ranges, ",")
+}
+
+// The integer values of the range's ends, with * standing for last, in
+// ascending order
+func (r SequenceRange) bounds(last uint32) (uint32, uint32) {
+	value := func(s SequenceNumber) uint32 {
+		if s.Last() {
+			return last
+		}
+		v, _ := s.Value()
+		return v
+	}
+	min := value(r.Min)
+	max := min
+	if !r.Max.Nil() {
+		max = value(r.Max)
+	}
+	if min > max {
+		min, max = max, min
+	}
+	return min, max
+}
+
+// Contains returns true if n is in the set, where * stands for last, the
+// highest number in the mailbox
+func (s SequenceSet) Contains(n uint32, last uint32) bool {
+	for _, rng := range s {
+		if min, max := rng.bounds(last); n >= min && n <= max {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize returns the same numbers as a set of ranges in ascending order
+// which neither overlap nor adjoin, with * replaced by last, the highest
+// number in the mailbox. A range of one number has no Max.
+func (s SequenceSet) Normalize(last uint32) SequenceSet {
+	type bounds struct{ min, max uint64 }
+	ranges := make([]bounds, 0, len(s))
+	for _, rng := range s {
+		min, max := rng.bounds(last)
+		ranges = append(ranges, bounds{uint64(min), uint64(max)})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].min < ranges[j].min })
+
+	normalized := make(SequenceSet, 0, len(ranges))
+	for i := 0; i < len(ranges); {
+		merged := ranges[i]
+		for i++; i < len(ranges) && ranges[i].min <= merged.max+1; i++ {
+			if ranges[i].max > merged.max {
+				merged.max = ranges[i].max
+			}
+		}
+		rng := SequenceRange{Min: SequenceNumber(strconv.FormatUint(merged.min, 10))}
+		if merged.max != merged.min {
+			rng.Max = SequenceNumber(strconv.FormatUint(merged.max, 10))
+		}
+		normalized = append(normalized, rng)
+	}
+	return normalized
+}
+
+// Expand returns every number in the set from 1 up to last, the highest
+// number in the mailbox, in ascending order and without duplicates
+func (s SequenceSet) Expand(last uint32) []uint32 {
+	var numbers []uint32
+	for _, rng := range s.Normalize(last) {
+		min, max := rng.bounds(last)
+		if min == 0 {
+			min = 1
+		}
+		for n := uint64(min); n <= uint64(max) && n <= uint64(last); n++ {
+			numbers = append(numbers, uint32(n))
+		}
+	}
+	return numbers
+}
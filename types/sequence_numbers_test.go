@@ -1,6 +1,7 @@
 package types
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -101,3 +102,54 @@ func TestSequenceNumber(t *testing.T) {
 		t.Errorf("Value() function for blank sequence number should return an error")
 	}
 }
+
+func TestSequenceSetContains(t *testing.T) {
+	set, _ := InterpretSequenceSet("2,5:7,9:*")
+	for n, expected := range map[uint32]bool{1: false, 2: true, 3: false, 6: true, 8: false, 9: true, 12: true} {
+		if set.Contains(n, 12) != expected {
+			t.Errorf("Contains(%d) in %v should be %v", n, set, expected)
+		}
+	}
+
+	// A range with * covers the last message even if its other end is beyond
+	// it
+	set, _ = InterpretSequenceSet("20:*")
+	if !set.Contains(12, 12) || set.Contains(11, 12) {
+		t.Errorf("20:* should only contain 12 when the last message is 12")
+	}
+}
+
+func TestSequenceSetNormalize(t *testing.T) {
+	set, _ := InterpretSequenceSet("9:*,1,4:2,3,5,7:8")
+	normalized := set.Normalize(12)
+	expected := SequenceSet{{Min: "1", Max: "5"}, {Min: "7", Max: "12"}}
+	if !reflect.DeepEqual(normalized, expected) {
+		t.Errorf("Normalized %v to %v, expected %v", set, normalized, expected)
+	}
+
+	set, _ = InterpretSequenceSet("*")
+	normalized = set.Normalize(3)
+	if !reflect.DeepEqual(normalized, SequenceSet{{Min: "3"}}) {
+		t.Errorf("Normalized * to %v", normalized)
+	}
+}
+
+func TestSequenceSetExpand(t *testing.T) {
+	set, _ := InterpretSequenceSet("6:4,2,*,1:2")
+	if expanded := set.Expand(8); !reflect.DeepEqual(expanded, []uint32{1, 2, 4, 5, 6, 8}) {
+		t.Errorf("Expanded %v to %v", set, expanded)
+	}
+	if expanded := set.Expand(5); !reflect.DeepEqual(expanded, []uint32{1, 2, 4, 5}) {
+		t.Errorf("Expanded %v to %v with 5 messages", set, expanded)
+	}
+	if expanded := set.Expand(0); len(expanded) != 0 {
+		t.Errorf("Expanded %v to %v in an empty mailbox", set, expanded)
+	}
+}
+
+func TestSequenceSetString(t *testing.T) {
+	set, _ := InterpretSequenceSet("1,3,5:9,18:*,*")
+	if formatted := set.String(); formatted != "1,3,5:9,18:*,*" {
+		t.Errorf("Sequence set formatted as %q", formatted)
+	}
+}
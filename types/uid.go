@@ -0,0 +1,121 @@
+package types
+
+import (
+	"strconv"
+)
+
+// UID represents a message's unique identifier, which unlike its sequence
+// number doesn't change when other messages are expunged. As in a
+// SequenceNumber, "*" stands for the highest UID in the mailbox.
+// See RFC3501 section 2.3.1.1
+type UID string
+
+const maxUID = 1<<32 - 1
+
+// NewUID returns the UID with the given integer value
+func NewUID(uid uint32) UID {
+	return UID(strconv.FormatUint(uint64(uid), 10))
+}
+
+// Last returns true if this UID indicates the highest UID in the mailbox
+func (u UID) Last() bool {
+	return SequenceNumber(u).Last()
+}
+
+// Nil returns true if no UID was specified
+func (u UID) Nil() bool {
+	return SequenceNumber(u).Nil()
+}
+
+// IsValue returns true if this UID contains an integer value
+func (u UID) IsValue() bool {
+	return SequenceNumber(u).IsValue()
+}
+
+// Value returns the integer value of the UID, or an error if Nil or Last
+// is true
+func (u UID) Value() (uint32, error) {
+	return SequenceNumber(u).Value()
+}
+
+// UIDRange represents a range of UIDs. eg in IMAP: 5:9 or 15:*
+type UIDRange struct {
+	Min UID
+	Max UID
+}
+
+// UIDSet represents a set of UID ranges, as given to the UID variants of
+// commands. eg in IMAP: 1,3,5:9,18:*
+type UIDSet []UIDRange
+
+// InterpretUIDSet parses a set of UIDs, which has the same syntax as a set
+// of sequence numbers
+func InterpretUIDSet(imapUIDSet string) (UIDSet, error) {
+	seqSet, err := InterpretSequenceSet(imapUIDSet)
+	if err != nil {
+		return UIDSet{}, err
+	}
+	return uidSetOf(seqSet), nil
+}
+
+// NewUIDSet returns the set of the given UIDs, with runs of consecutive UIDs
+// compressed into ranges. The UIDs keep the order they're given in, as the
+// sets in a COPYUID response code pair UIDs up by their positions.
+func NewUIDSet(uids []uint32) UIDSet {
+	set := make(UIDSet, 0)
+	for start := 0; start < len(uids); {
+		end := start
+		for end+1 < len(uids) && uids[end] < maxUID && uids[end+1] == uids[end]+1 {
+			end++
+		}
+		uidRange := UIDRange{Min: NewUID(uids[start])}
+		if end != start {
+			uidRange.Max = NewUID(uids[end])
+		}
+		set = append(set, uidRange)
+		start = end + 1
+	}
+	return set
+}
+
+// String formats the set as it's sent in commands and in response codes such
+// as APPENDUID and COPYUID, eg 4:7,9,12:15
+func (s UIDSet) String() string {
+	return s.SequenceSet().String()
+}
+
+func uidSetOf(seqSet SequenceSet) UIDSet {
+	uidSet := make(UIDSet, len(seqSet))
+	for i, seqRange := range seqSet {
+		uidSet[i] = UIDRange{Min: UID(seqRange.Min), Max: UID(seqRange.Max)}
+	}
+	return uidSet
+}
+
+// SequenceSet returns the same ranges as a SequenceSet, for code which treats
+// UIDs and sequence numbers alike
+func (s UIDSet) SequenceSet() SequenceSet {
+	seqSet := make(SequenceSet, len(s))
+	for i, uidRange := range s {
+		seqSet[i] = SequenceRange{Min: SequenceNumber(uidRange.Min), Max: SequenceNumber(uidRange.Max)}
+	}
+	return seqSet
+}
+
+// Contains returns true if uid is in the set, where * stands for last, the
+// highest UID in the mailbox
+func (s UIDSet) Contains(uid uint32, last uint32) bool {
+	return s.SequenceSet().Contains(uid, last)
+}
+
+// Normalize returns the same UIDs as a set of ranges in ascending order which
+// neither overlap nor adjoin, with * replaced by last
+func (s UIDSet) Normalize(last uint32) UIDSet {
+	return uidSetOf(s.SequenceSet().Normalize(last))
+}
+
+// Expand returns every UID in the set up to last, in ascending order and
+// without duplicates. Not every UID returned need belong to a message.
+func (s UIDSet) Expand(last uint32) []uint32 {
+	return s.SequenceSet().Expand(last)
+}
@@ -0,0 +1,62 @@
+package types
+
+import (
+	"testing"
+)
+
+func TestInterpretUIDSet(t *testing.T) {
+	set, err := InterpretUIDSet("4,10:*")
+	assertErr(t, nil, err)
+	if len(set) != 2 || set[0] != (UIDRange{Min: "4"}) || set[1] != (UIDRange{Min: "10", Max: "*"}) {
+		t.Errorf("UID set 4,10:* interpreted as %v", set)
+	}
+
+	_, err = InterpretUIDSet("4,,10")
+	if err == nil {
+		t.Errorf("Expected an error for UID set 4,,10")
+	}
+}
+
+func TestUIDValue(t *testing.T) {
+	uid := NewUID(4294967295)
+	if value, err := uid.Value(); err != nil || value != 4294967295 {
+		t.Errorf("UID %s has value %d, %v", uid, value, err)
+	}
+	if UID("*").IsValue() || !UID("*").Last() || !UID("").Nil() {
+		t.Errorf("UID * and the blank UID should not have values")
+	}
+}
+
+func TestUIDSetAsSequenceSet(t *testing.T) {
+	set := UIDSet{{Min: "1", Max: "5"}, {Min: "*"}}.SequenceSet()
+	if len(set) != 2 || set[0] != (SequenceRange{Min: "1", Max: "5"}) || set[1] != (SequenceRange{Min: "*"}) {
+		t.Errorf("UID set converted to %v", set)
+	}
+}
+
+func TestUIDSetNormalize(t *testing.T) {
+	set, _ := InterpretUIDSet("15:*,10,11")
+	if normalized := set.Normalize(20); len(normalized) != 2 || normalized[0] != (UIDRange{Min: "10", Max: "11"}) || normalized[1] != (UIDRange{Min: "15", Max: "20"}) {
+		t.Errorf("Normalized %v to %v", set, normalized)
+	}
+	if !set.Contains(20, 20) || set.Contains(12, 20) {
+		t.Errorf("Contains gave the wrong answer for %v", set)
+	}
+}
+
+func TestNewUIDSet(t *testing.T) {
+	tests := []struct {
+		uids     []uint32
+		expected string
+	}{
+		{[]uint32{4, 5, 6, 7, 9, 12, 13, 14, 15}, "4:7,9,12:15"},
+		{[]uint32{9, 4, 5}, "9,4:5"},
+		{[]uint32{4294967295, 0}, "4294967295,0"},
+		{[]uint32{}, ""},
+	}
+	for _, test := range tests {
+		if formatted := NewUIDSet(test.uids).String(); formatted != test.expected {
+			t.Errorf("UIDs %v formatted as %q, expected %q", test.uids, formatted, test.expected)
+		}
+	}
+}
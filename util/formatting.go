@@ -10,31 +10,58 @@ import (
 )
 
 // RFC822 date format used by IMAP in go date format
-const RFC822Date = "Mon, 2 Jan 2006 15:04:05 +0700"
+const RFC822Date = "Mon, 2 Jan 2006 15:04:05 -0700"
 
 // Date format used in INTERNALDATE fetch parameter
-const InternalDate = "02-Jan-2006 15:04:05 +0700"
+const InternalDate = "02-Jan-2006 15:04:05 -0700"
+
+// Date format accepted for the date-time argument of APPEND, whose day may
+// be padded with a space rather than a zero
+const AppendDate = "_2-Jan-2006 15:04:05 -0700"
 
 func FormatDate(date time.Time) string {
 	fmt.Printf("date: %s\n", date)
 	return date.Format(RFC822Date)
 }
 
-func SplitParams(params string) []string {
-	paramsOpen := false
-	result := strings.FieldsFunc(params, func(r rune) bool {
-		if r == '[' {
-			paramsOpen = true
-		}
-		if r == ']' {
-			paramsOpen = false
+// IsAtom returns true if s can be sent as an IMAP atom, ie it is not empty
+// and contains no spaces, control characters or atom-specials
+// ( ) { % * " \ ]
+func IsAtom(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] >= 0x7f || strings.IndexByte("(){%*\"\\]", s[i]) >= 0 {
+			return false
 		}
-		if r == ' ' && !paramsOpen {
-			return true
+	}
+	return true
+}
+
+// Quote formats s as an IMAP quoted string, escaping any backslashes and
+// double quotes within it
+func Quote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
+
+// Unquote returns the value of an argument sent by a client as either a
+// quoted string or an atom. The quotes around a quoted string are removed,
+// along with the backslashes escaping any quotes and backslashes within it.
+// Atoms are returned unchanged.
+func Unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var value strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
 		}
-		return false
-	})
-	return result
+		value.WriteByte(s[i])
+	}
+	return value.String()
 }
 
 // WriteMIMEHeader writes the MIME header out in the standard format. This
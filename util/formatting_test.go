@@ -1,26 +1,61 @@
 package util
 
 import (
-	"strings"
 	"testing"
+	"time"
 )
 
-func TestSplitParams(t *testing.T) {
-	originalList := []string{
-		"BODY[HEADER.FIELDS (From Subject)]",
-		"FLAGS",
+func TestIsAtom(t *testing.T) {
+	for atom, valid := range map[string]bool{
+		"X-VENDOR-HINT": true,
+		"AUTH=PLAIN":    true,
+		"":              false,
+		"TWO WORDS":     false,
+		"X-(LIST)":      false,
+		"X-STAR*":       false,
+		"X-\"QUOTED\"":  false,
+		"X-\u00e9":      false,
+	} {
+		if IsAtom(atom) != valid {
+			t.Errorf("Expected IsAtom(%q) to be %t", atom, valid)
+		}
 	}
-	params := strings.Join(originalList, " ")
-	result := splitParams(params)
-	for index, param := range originalList {
-		if result[index] != param {
-			t.Fatalf("Param %d does not match expected:\n"+
-				"\tExpected: %s\n"+
-				"\tActual:   %s", index, param, result[index])
+}
+
+func TestQuote(t *testing.T) {
+	for value, quoted := range map[string]string{
+		"INBOX":        "\"INBOX\"",
+		"":             "\"\"",
+		"My Folder":    "\"My Folder\"",
+		"Say \"hi\"":   "\"Say \\\"hi\\\"\"",
+		"C:\\Mail":     "\"C:\\\\Mail\"",
+		"\\\"together": "\"\\\\\\\"together\"",
+	} {
+		if q := Quote(value); q != quoted {
+			t.Errorf("Quote(%q) should return %s, got %s", value, quoted, q)
+		}
+		if v := Unquote(quoted); v != value {
+			t.Errorf("Unquote(%s) should return %q, got %q", quoted, value, v)
 		}
 	}
-	if len(result) > len(originalList) {
-		t.Fatalf("Expected %d parameters but %d were split:\n%s",
-			len(originalList), len(result), result)
+}
+
+func TestUnquoteAtom(t *testing.T) {
+	for _, atom := range []string{"INBOX", "Archive/2015", "\"unbalanced", ""} {
+		if value := Unquote(atom); value != atom {
+			t.Errorf("Unquote(%q) should return it unchanged, got %q", atom, value)
+		}
+	}
+}
+
+func TestRFC822Date(t *testing.T) {
+	for date, formatted := range map[time.Time]string{
+		time.Date(2014, time.October, 28, 0, 9, 0, 0, time.UTC):                             "Tue, 28 Oct 2014 00:09:00 +0000",
+		time.Date(2014, time.October, 28, 0, 9, 0, 0, time.FixedZone("", 7*60*60)):          "Tue, 28 Oct 2014 00:09:00 +0700",
+		time.Date(2014, time.October, 28, 0, 9, 0, 0, time.FixedZone("", -(4*60*60+30*60))): "Tue, 28 Oct 2014 00:09:00 -0430",
+	} {
+		if s := date.Format(RFC822Date); s != formatted {
+			t.Errorf("Expected %s, got %s", formatted, s)
+		}
 	}
 }
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ListItem is one element of a parenthesized list sent by a client, eg one
+// of the data items of a FETCH command. Each item is an atom, a quoted string
+// or a nested list. An atom may be followed by a section in square brackets
+// and a partial range in angle brackets, as in
+// BODY.PEEK[HEADER.FIELDS (From To)]<0.1024>.
+type ListItem struct {
+	Value  string // The atom, or the value of the quoted string
+	Quoted bool   // Value was sent as a quoted string
+
+	IsList bool
+	List   []ListItem // The items of a nested list
+
+	HasSection bool
+	Section    []ListItem // The items within the square brackets, if any
+	Partial    string     // The range within the angle brackets, eg "0.1024"
+}
+
+// ListSyntaxError describes why a parenthesized list couldn't be parsed
+type ListSyntaxError struct {
+	Position int    // Byte offset at which the error was found
+	Expected string // What was expected there
+}
+
+func (e *ListSyntaxError) Error() string {
+	return fmt.Sprintf("Invalid list at position %d, expected %s", e.Position, e.Expected)
+}
+
+// ParseList parses a space-separated sequence of atoms, quoted strings and
+// parenthesized lists, such as the data items requested by FETCH.
+func ParseList(s string) ([]ListItem, error) {
+	p := &listParser{s: s}
+	return p.items(0)
+}
+
+// String formats the item as it would be sent by a client, with strings
+// quoted as necessary
+func (item ListItem) String() string {
+	switch {
+	case item.IsList:
+		return "(" + FormatList(item.List) + ")"
+	case item.Quoted:
+		return Quote(item.Value)
+	}
+
+	s := item.Value
+	if item.HasSection {
+		s += "[" + FormatList(item.Section) + "]"
+	}
+	if item.Partial != "" {
+		s += "<" + item.Partial + ">"
+	}
+	return s
+}
+
+// FormatList formats a sequence of list items, separated by spaces and
+// without surrounding parentheses
+func FormatList(items []ListItem) string {
+	formatted := make([]string, len(items))
+	for i, item := range items {
+		formatted[i] = item.String()
+	}
+	return strings.Join(formatted, " ")
+}
+
+type listParser struct {
+	s   string
+	pos int
+}
+
+// Parse items up to the given closing bracket, or to the end of the input if
+// close is 0
+func (p *listParser) items(close byte) ([]ListItem, error) {
+	items := make([]ListItem, 0)
+	for {
+		for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+			p.pos++
+		}
+		if p.pos == len(p.s) {
+			if close != 0 {
+				return nil, &ListSyntaxError{p.pos, fmt.Sprintf("%q", close)}
+			}
+			return items, nil
+		}
+		if p.s[p.pos] == close {
+			p.pos++
+			return items, nil
+		}
+
+		item, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// Parse a single atom, quoted string or nested list
+func (p *listParser) item() (ListItem, error) {
+	switch p.s[p.pos] {
+	case '(':
+		p.pos++
+		list, err := p.items(')')
+		return ListItem{IsList: true, List: list}, err
+	case '"':
+		return p.quoted()
+	case ')', ']', '[':
+		return ListItem{}, &ListSyntaxError{p.pos, "an atom, string or list"}
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" ()[]\"", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	item := ListItem{Value: p.s[start:p.pos]}
+	if p.pos == len(p.s) || p.s[p.pos] != '[' {
+		return item, nil
+	}
+
+	p.pos++
+	section, err := p.items(']')
+	if err != nil {
+		return ListItem{}, err
+	}
+	item.HasSection = true
+	item.Section = section
+
+	if p.pos < len(p.s) && p.s[p.pos] == '<' {
+		end := strings.IndexByte(p.s[p.pos:], '>')
+		if end < 0 {
+			return ListItem{}, &ListSyntaxError{len(p.s), "\">\""}
+		}
+		item.Partial = p.s[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+	}
+	return item, nil
+}
+
+// Parse a quoted string, in which " and \ are escaped by a backslash
+func (p *listParser) quoted() (ListItem, error) {
+	start := p.pos
+	var value strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '"':
+			p.pos++
+			return ListItem{Value: value.String(), Quoted: true}, nil
+		case '\\':
+			if p.pos+1 < len(p.s) {
+				p.pos++
+			}
+		}
+		value.WriteByte(p.s[p.pos])
+	}
+	return ListItem{}, &ListSyntaxError{start, "a closing quote"}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	items, err := ParseList("FLAGS BODY.PEEK[HEADER.FIELDS (From To)] BODY[]<0.1024> UID")
+	if err != nil {
+		t.Fatalf("Error parsing list: %s", err)
+	}
+	expected := []ListItem{
+		{Value: "FLAGS"},
+		{Value: "BODY.PEEK", HasSection: true, Section: []ListItem{
+			{Value: "HEADER.FIELDS"},
+			{IsList: true, List: []ListItem{{Value: "From"}, {Value: "To"}}},
+		}},
+		{Value: "BODY", HasSection: true, Section: []ListItem{}, Partial: "0.1024"},
+		{Value: "UID"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, items)
+	}
+}
+
+func TestParseNestedList(t *testing.T) {
+	items, err := ParseList("(ANNOTATION (/comment (value.priv \"a \\\"b\\\"\")) UID)")
+	if err != nil {
+		t.Fatalf("Error parsing list: %s", err)
+	}
+	if len(items) != 1 || !items[0].IsList || len(items[0].List) != 3 {
+		t.Fatalf("Expected a single list of 3 items, got %+v", items)
+	}
+	annotation := items[0].List[1].List[1].List[1]
+	if !annotation.Quoted || annotation.Value != "a \"b\"" {
+		t.Errorf("Expected the quoted string to be unescaped, got %+v", annotation)
+	}
+}
+
+func TestFormatList(t *testing.T) {
+	for _, list := range []string{
+		"FLAGS BODY.PEEK[HEADER.FIELDS (From To)] UID",
+		"BINARY[1.2]<10.20> BODY[]",
+		"ANNOTATION (/comment (value.priv \"a \\\"b\\\"\"))",
+	} {
+		items, err := ParseList(list)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", list, err)
+			continue
+		}
+		if formatted := FormatList(items); formatted != list {
+			t.Errorf("Expected %q to be formatted unchanged, got %q", list, formatted)
+		}
+	}
+}
+
+func TestParseInvalidList(t *testing.T) {
+	for _, list := range []string{
+		"(FLAGS UID",
+		"FLAGS)",
+		"BODY[HEADER",
+		"BODY[]<0.10",
+		"\"unterminated",
+	} {
+		if _, err := ParseList(list); err == nil {
+			t.Errorf("Expected an error parsing %q", list)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The name of the special mailbox which is always case-insensitive
+const inboxName = "INBOX"
+
+// NormalizeMailboxName returns the canonical form of a mailbox name, which
+// should be used whenever mailbox names are stored or compared. Names are
+// converted to Unicode Normalization Form C so that eg "Café" entered with a
+// combining accent by one client and a precomposed é by another refer to the
+// same mailbox. Any capitalisation of INBOX is also converted to upper case,
+// as RFC 3501 requires INBOX to be case-insensitive.
+func NormalizeMailboxName(name string) string {
+	name = norm.NFC.String(name)
+	if strings.EqualFold(name, inboxName) {
+		return inboxName
+	}
+	return name
+}
+
+// MailboxNamesEqual reports whether two mailbox names refer to the same
+// mailbox. Apart from INBOX, mailbox names are case-sensitive.
+func MailboxNamesEqual(a string, b string) bool {
+	return NormalizeMailboxName(a) == NormalizeMailboxName(b)
+}
+
+// MailboxMatchesPattern reports whether a mailbox name matches a LIST or
+// LSUB pattern, in which * matches any characters and % matches any
+// characters except the hierarchy delimiter
+func MailboxMatchesPattern(name string, pattern string, delimiter string) bool {
+	name = NormalizeMailboxName(name)
+	pattern = norm.NFC.String(pattern)
+	if name == inboxName {
+		// INBOX matches in any capitalisation, eg "inb%"
+		pattern = strings.ToUpper(pattern)
+	}
+	return matchPattern(name, pattern, delimiter)
+}
+
+// Match a name against a pattern by working through the pattern one
+// character at a time, keeping track of every position in the name the
+// pattern so far can have matched up to. This takes time proportional to the
+// product of their lengths, however many wildcards the pattern has.
+func matchPattern(name string, pattern string, delimiter string) bool {
+	reached := make([]bool, len(name)+1)
+	reached[0] = true
+	for p := 0; p < len(pattern); p++ {
+		next := make([]bool, len(name)+1)
+		matched := false
+		switch pattern[p] {
+		case '*', '%':
+			// A wildcard extends each match over any number of characters,
+			// though % can't extend over a level of the hierarchy
+			extending := false
+			for i := range next {
+				extending = extending || reached[i]
+				next[i] = extending
+				matched = matched || extending
+				if pattern[p] == '%' && delimiter != "" && strings.HasPrefix(name[i:], delimiter) {
+					extending = false
+				}
+			}
+		default:
+			for i := 0; i < len(name); i++ {
+				if reached[i] && name[i] == pattern[p] {
+					next[i+1] = true
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+		reached = next
+	}
+	return reached[len(name)]
+}
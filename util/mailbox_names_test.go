@@ -0,0 +1,82 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailboxNamesEqual(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"Caf\u00e9", "Cafe\u0301", true},
+		{"inbox", "INBOX", true},
+		{"Inbox", "INBOX", true},
+		{"Trash", "trash", false},
+		{"Sent", "Drafts", false},
+	}
+
+	for _, test := range tests {
+		if MailboxNamesEqual(test.a, test.b) != test.equal {
+			t.Errorf("MailboxNamesEqual(%q, %q) should return %v", test.a, test.b, test.equal)
+		}
+	}
+}
+
+func TestNormalizeMailboxName(t *testing.T) {
+	if name := NormalizeMailboxName("Cafe\u0301"); name != "Caf\u00e9" {
+		t.Errorf("Expected name to be normalized to NFC, got %q", name)
+	}
+	if name := NormalizeMailboxName("inBox"); name != "INBOX" {
+		t.Errorf("Expected INBOX to be normalized to upper case, got %q", name)
+	}
+}
+
+func TestMailboxMatchesPattern(t *testing.T) {
+	tests := []struct {
+		name, pattern string
+		matches       bool
+	}{
+		{"INBOX", "*", true},
+		{"INBOX", "inbox", true},
+		{"INBOX", "inB%", true},
+		{"Trash", "trash", false},
+		{"Work/Projects", "*", true},
+		{"Work/Projects", "%", false},
+		{"Work", "%", true},
+		{"Work/Projects", "Work/%", true},
+		{"Work/Projects/2024", "Work/%", false},
+		{"Work/Projects/2024", "Work/*", true},
+		{"Work/Projects", "W%s", false},
+		{"Work/Projects", "W*s", true},
+		{"Trash", "Tr%", true},
+		{"Trash", "Sent", false},
+		{"Work/Projects", "*/%", true},
+		{"Work/Projects/2024", "%/%", false},
+		{"Work/Projects/2024", "%/*4", true},
+		{"", "%", true},
+		{"", "a*", false},
+	}
+
+	for _, test := range tests {
+		if MailboxMatchesPattern(test.name, test.pattern, "/") != test.matches {
+			t.Errorf("MailboxMatchesPattern(%q, %q) should return %v", test.name, test.pattern, test.matches)
+		}
+	}
+}
+
+func TestMailboxMatchesPatternTime(t *testing.T) {
+	// Backtracking over each wildcard would take years to find that this
+	// pattern doesn't match
+	name := strings.Repeat("a", 40)
+	pattern := strings.Repeat("*a", 30) + "b"
+	start := time.Now()
+	if MailboxMatchesPattern(name, pattern, "/") {
+		t.Errorf("Expected %q not to match %q", name, pattern)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected a pattern with many wildcards to be matched quickly, took %s", elapsed)
+	}
+}
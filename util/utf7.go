@@ -0,0 +1,117 @@
+package util
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF7 is returned when a mailbox name sent by a client isn't
+// valid modified UTF-7
+var ErrInvalidUTF7 = errors.New("Mailbox name is not valid modified UTF-7")
+
+// Modified base64 uses , instead of / and is never padded (RFC 3501
+// section 5.1.3)
+var utf7Encoding = base64.NewEncoding(
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,",
+).WithPadding(base64.NoPadding)
+
+// Printable US-ASCII characters represent themselves in modified UTF-7
+func isDirect(r rune) bool {
+	return r >= 0x20 && r <= 0x7e
+}
+
+// EncodeMailboxName converts a mailbox name to the modified UTF-7 form in
+// which it is sent to clients. Printable ASCII characters are sent as
+// themselves, except for & which becomes &-, and runs of other characters
+// are sent as base64-encoded UTF-16 between & and -.
+func EncodeMailboxName(name string) string {
+	var encoded strings.Builder
+	var run []rune
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		b := make([]byte, 0, len(units)*2)
+		for _, unit := range units {
+			b = append(b, byte(unit>>8), byte(unit))
+		}
+		encoded.WriteByte('&')
+		encoded.WriteString(utf7Encoding.EncodeToString(b))
+		encoded.WriteByte('-')
+		run = run[:0]
+	}
+
+	for _, r := range name {
+		if !isDirect(r) {
+			run = append(run, r)
+			continue
+		}
+		flush()
+		if r == '&' {
+			encoded.WriteString("&-")
+		} else {
+			encoded.WriteRune(r)
+		}
+	}
+	flush()
+	return encoded.String()
+}
+
+// DecodeMailboxName converts a mailbox name sent by a client in modified
+// UTF-7 to UTF-8, or returns ErrInvalidUTF7 if it isn't properly encoded
+func DecodeMailboxName(encoded string) (string, error) {
+	var name strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if c >= utf8.RuneSelf || !isDirect(rune(c)) {
+			return "", ErrInvalidUTF7
+		}
+		if c != '&' {
+			name.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(encoded[i+1:], '-')
+		if end < 0 {
+			return "", ErrInvalidUTF7
+		}
+		shifted := encoded[i+1 : i+1+end]
+		i += end + 1
+		if shifted == "" {
+			name.WriteByte('&')
+			continue
+		}
+
+		decoded, err := decodeShifted(shifted)
+		if err != nil {
+			return "", err
+		}
+		name.WriteString(decoded)
+	}
+	return name.String(), nil
+}
+
+// Decode the base64-encoded UTF-16 between & and -, which must not encode
+// characters that could have been sent as themselves
+func decodeShifted(shifted string) (string, error) {
+	b, err := utf7Encoding.DecodeString(shifted)
+	if err != nil || len(b) == 0 || len(b)%2 != 0 {
+		return "", ErrInvalidUTF7
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+
+	runes := utf16.Decode(units)
+	for _, r := range runes {
+		if isDirect(r) || r == utf8.RuneError {
+			return "", ErrInvalidUTF7
+		}
+	}
+	return string(runes), nil
+}
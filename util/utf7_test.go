@@ -0,0 +1,40 @@
+package util
+
+import "testing"
+
+func TestEncodeMailboxName(t *testing.T) {
+	tests := []struct {
+		name, encoded string
+	}{
+		{"INBOX", "INBOX"},
+		{"Tom & Jerry", "Tom &- Jerry"},
+		{"Entwürfe", "Entw&APw-rfe"},
+		{"~peter/mail/台北/日本語", "~peter/mail/&U,BTFw-/&ZeVnLIqe-"},
+		{"\U0001F4EC", "&2D3c7A-"},
+	}
+
+	for _, test := range tests {
+		if encoded := EncodeMailboxName(test.name); encoded != test.encoded {
+			t.Errorf("EncodeMailboxName(%q) should return %q, got %q", test.name, test.encoded, encoded)
+		}
+		if name, err := DecodeMailboxName(test.encoded); err != nil || name != test.name {
+			t.Errorf("DecodeMailboxName(%q) should return %q, got %q (%v)", test.encoded, test.name, name, err)
+		}
+	}
+}
+
+func TestDecodeInvalidMailboxName(t *testing.T) {
+	invalid := []string{
+		"Entw&APw",   // unterminated
+		"Entw&A-rfe", // truncated UTF-16
+		"&AEE-",      // encodes the printable "A"
+		"&2D0-",      // unpaired surrogate
+		"Entwürfe",   // raw 8-bit characters
+	}
+
+	for _, encoded := range invalid {
+		if _, err := DecodeMailboxName(encoded); err != ErrInvalidUTF7 {
+			t.Errorf("DecodeMailboxName(%q) should return ErrInvalidUTF7, got %v", encoded, err)
+		}
+	}
+}